@@ -0,0 +1,147 @@
+package console
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// DefaultDetachKeys is the key sequence used to detach from a console.
+const DefaultDetachKeys = "ctrl-]"
+
+// errDetached is returned when copying the input stops because the detach keys
+// were pressed.
+var errDetached = errors.New("detached")
+
+// Attach attaches to the console served on the socket. The output is written to
+// out and in is sent to the console until the detach keys are read from in, in
+// reaches EOF, the console is closed or the context is cancelled. The terminal
+// for in should be in raw mode so that the keys are sent straight away.
+func Attach(ctx context.Context, socketPath string, in io.Reader, out io.Writer, detachKeys []byte) error {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrNotAvailable, err)
+	}
+	defer conn.Close()
+
+	outputDone := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(out, conn)
+		outputDone <- err
+	}()
+
+	inputDone := make(chan error, 1)
+	go func() {
+		inputDone <- copyInput(conn, in, detachKeys)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-outputDone:
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			return fmt.Errorf("reading console output: %w", err)
+		}
+
+		return nil
+	case err := <-inputDone:
+		if err != nil && !errors.Is(err, errDetached) {
+			return fmt.Errorf("writing console input: %w", err)
+		}
+
+		return nil
+	}
+}
+
+// copyInput copies in to the console until the detach keys are read. Keys that
+// start the detach sequence are held back until its known if the sequence has
+// been typed.
+func copyInput(console io.Writer, in io.Reader, detachKeys []byte) error {
+	buf := make([]byte, readSize)
+	matched := 0
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			send := make([]byte, 0, n+len(detachKeys))
+			for _, b := range buf[:n] {
+				if len(detachKeys) > 0 && b == detachKeys[matched] {
+					matched++
+					if matched == len(detachKeys) {
+						if len(send) > 0 {
+							if _, err := console.Write(send); err != nil {
+								return err
+							}
+						}
+
+						return errDetached
+					}
+
+					continue
+				}
+
+				if matched > 0 {
+					send = append(send, detachKeys[:matched]...)
+					matched = 0
+					if b == detachKeys[0] {
+						matched = 1
+						continue
+					}
+				}
+				send = append(send, b)
+			}
+
+			if len(send) > 0 {
+				if _, err := console.Write(send); err != nil {
+					return err
+				}
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+	}
+}
+
+// ParseDetachKeys parses a comma separated key sequence, e.g. ctrl-a,d. A key
+// is either a single character or ctrl- followed by a letter or one of @[\]^_.
+func ParseDetachKeys(keys string) ([]byte, error) {
+	sequence := []byte{}
+	for _, key := range strings.Split(keys, ",") {
+		switch {
+		case len(key) == 1:
+			sequence = append(sequence, key[0])
+		case len(key) == 6 && strings.HasPrefix(strings.ToLower(key), "ctrl-"):
+			code, ok := controlCode(key[5])
+			if !ok {
+				return nil, fmt.Errorf("invalid detach key %q", key)
+			}
+			sequence = append(sequence, code)
+		default:
+			return nil, fmt.Errorf("invalid detach key %q", key)
+		}
+	}
+
+	return sequence, nil
+}
+
+// controlCode returns the code sent when ctrl and the key are pressed together.
+func controlCode(key byte) (byte, bool) {
+	switch {
+	case key >= 'a' && key <= 'z':
+		return key - 'a' + 1, true
+	case key >= 'A' && key <= 'Z':
+		return key - 'A' + 1, true
+	case key >= '@' && key <= '_':
+		return key - '@', true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,151 @@
+// Package console runs the serial console of a vm on a pty.
+//
+// The pty is held open by a shim process, so that the vm keeps running after
+// the process that started it exits. The shim appends the console output to a
+// log file and serves the console on a unix socket so that it can be attached
+// to. The shim is the current executable run with ShimName as its name, which
+// is handled by calling Init at the start of main.
+//
+// The shim also copies pipes, such as the hypervisor log, to files for as long
+// as the vm is running. The hypervisors don't open their log files with
+// O_APPEND, so the files couldn't be rotated if they were written directly.
+package console
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// ShimName is the name the current executable is run with to run the shim.
+	ShimName = "mikrolite-console-shim"
+
+	readyMessage = "ready"
+	readyTimeout = 10 * time.Second
+
+	fifoPerm = 0o600
+)
+
+// ErrNotAvailable is returned when attaching to a console that isn't being
+// served, for example because the vm isn't running.
+var ErrNotAvailable = errors.New("console isn't available")
+
+// Init runs the shim if the current executable was run as the shim, in which
+// case it doesn't return. It must be called at the start of main.
+func Init() {
+	if len(os.Args) == 0 || os.Args[0] != ShimName {
+		return
+	}
+
+	os.Exit(runShim(os.Args[1:]))
+}
+
+// Pipe is a fifo that the shim copies to a file while the vm is running. The vm
+// process writes to the fifo instead of the file.
+type Pipe struct {
+	// FIFO is the path of the fifo, its created by Start.
+	FIFO string
+	// File is the path of the file the fifo is appended to.
+	File string
+}
+
+// Start creates a pty and starts the shim, which serves the console on the
+// socket and appends the output to the log file. The returned file is the pty
+// to use as the stdin and stdout of the vm process, it must be closed once the
+// vm process has been started. The shim exits when the vm process exits.
+func Start(socketPath string, logPath string, pipes ...Pipe) (*os.File, error) {
+	args := []string{ShimName, socketPath, logPath}
+	for _, pipe := range pipes {
+		if err := createFIFO(pipe.FIFO); err != nil {
+			return nil, err
+		}
+		args = append(args, pipe.FIFO, pipe.File)
+	}
+
+	pty, tty, err := openPTY()
+	if err != nil {
+		return nil, fmt.Errorf("opening pty: %w", err)
+	}
+	defer pty.Close()
+
+	executable, err := os.Executable()
+	if err != nil {
+		tty.Close()
+		return nil, fmt.Errorf("getting the path to the shim: %w", err)
+	}
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		tty.Close()
+		return nil, fmt.Errorf("creating ready pipe: %w", err)
+	}
+	defer readyReader.Close()
+
+	cmd := &exec.Cmd{
+		Path:       executable,
+		Args:       args,
+		ExtraFiles: []*os.File{pty, readyWriter},
+		// Don't stop the shim when the terminal that started it is closed
+		SysProcAttr: &syscall.SysProcAttr{Setsid: true},
+	}
+	startErr := cmd.Start()
+	readyWriter.Close()
+	if startErr != nil {
+		tty.Close()
+		return nil, fmt.Errorf("starting console shim: %w", startErr)
+	}
+	// The shim is only waited on so that its reaped when it exits
+	go cmd.Wait()
+
+	if err := waitForReady(readyReader); err != nil {
+		tty.Close()
+		cmd.Process.Kill()
+		return nil, err
+	}
+
+	return tty, nil
+}
+
+// createFIFO creates the fifo, replacing the one left behind if the vm is being
+// restarted.
+func createFIFO(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing fifo %s: %w", path, err)
+	}
+	if err := unix.Mkfifo(path, fifoPerm); err != nil {
+		return fmt.Errorf("creating fifo %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// waitForReady waits for the shim to report that its serving the console.
+func waitForReady(ready *os.File) error {
+	if err := ready.SetReadDeadline(time.Now().Add(readyTimeout)); err != nil {
+		return fmt.Errorf("setting ready deadline: %w", err)
+	}
+
+	data, err := io.ReadAll(ready)
+	if err != nil {
+		return fmt.Errorf("waiting for console shim: %w", err)
+	}
+
+	message := strings.TrimSpace(string(data))
+	if message != readyMessage {
+		if message == "" {
+			message = "exited"
+		}
+
+		return fmt.Errorf("console shim failed: %s", message)
+	}
+
+	return nil
+}
@@ -0,0 +1,220 @@
+package console
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const waitTimeout = 5 * time.Second
+
+func TestMain(m *testing.M) {
+	// The shim is the test binary
+	Init()
+
+	os.Exit(m.Run())
+}
+
+func TestParseDetachKeys(t *testing.T) {
+	testCases := []struct {
+		keys      string
+		expected  []byte
+		expectErr bool
+	}{
+		{keys: DefaultDetachKeys, expected: []byte{0x1d}},
+		{keys: "ctrl-p,ctrl-q", expected: []byte{0x10, 0x11}},
+		{keys: "ctrl-A,d", expected: []byte{0x01, 'd'}},
+		{keys: "ctrl-@", expected: []byte{0x00}},
+		{keys: "ctrl-1", expectErr: true},
+		{keys: "ctrl", expectErr: true},
+		{keys: "", expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.keys, func(t *testing.T) {
+			actual, err := ParseDetachKeys(tc.keys)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !bytes.Equal(actual, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestCopyInput(t *testing.T) {
+	detachKeys := []byte{0x10, 0x11}
+
+	testCases := []struct {
+		name           string
+		input          string
+		expectSent     string
+		expectDetached bool
+	}{
+		{name: "input is sent", input: "ls -l\r", expectSent: "ls -l\r"},
+		{name: "detach keys", input: "ls\x10\x11pwd", expectSent: "ls", expectDetached: true},
+		{name: "partial detach keys are sent", input: "a\x10b", expectSent: "a\x10b"},
+		{name: "repeated first key", input: "\x10\x10\x11", expectSent: "\x10", expectDetached: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sent := &bytes.Buffer{}
+			err := copyInput(sent, strings.NewReader(tc.input), detachKeys)
+
+			if detached := errors.Is(err, errDetached); detached != tc.expectDetached {
+				t.Errorf("expected detached to be %t, got error %v", tc.expectDetached, err)
+			}
+			if sent.String() != tc.expectSent {
+				t.Errorf("expected %q to be sent, got %q", tc.expectSent, sent.String())
+			}
+		})
+	}
+}
+
+func TestConsole(t *testing.T) {
+	dir := t.TempDir()
+	socketPath := filepath.Join(dir, "console.sock")
+	logPath := filepath.Join(dir, "vm.stdout")
+
+	tty, err := Start(socketPath, logPath)
+	if err != nil {
+		t.Fatalf("starting console: %s", err)
+	}
+
+	// The tty is what the vm process uses
+	if _, err := tty.WriteString("booting\r\n"); err != nil {
+		t.Fatalf("writing to tty: %s", err)
+	}
+	waitFor(t, "console log", func() bool {
+		data, _ := os.ReadFile(logPath)
+		return strings.Contains(string(data), "booting")
+	})
+
+	inReader, inWriter := io.Pipe()
+	out := &syncBuffer{}
+	attached := make(chan error, 1)
+	go func() {
+		attached <- Attach(context.Background(), socketPath, inReader, out, []byte{0x1d})
+	}()
+
+	// Wait for the client to be connected before the vm writes to the console
+	waitFor(t, "console output", func() bool {
+		tty.WriteString("login: ")
+		return strings.Contains(out.String(), "login: ")
+	})
+
+	if _, err := inWriter.Write([]byte("root\r")); err != nil {
+		t.Fatalf("writing input: %s", err)
+	}
+	input := make([]byte, 5)
+	if _, err := io.ReadFull(tty, input); err != nil {
+		t.Fatalf("reading input from tty: %s", err)
+	}
+	if string(input) != "root\r" {
+		t.Errorf("expected input root, got %q", input)
+	}
+
+	if _, err := inWriter.Write([]byte{0x1d}); err != nil {
+		t.Fatalf("writing detach keys: %s", err)
+	}
+	select {
+	case err := <-attached:
+		if err != nil {
+			t.Errorf("expected detach to succeed, got %s", err)
+		}
+	case <-time.After(waitTimeout):
+		t.Fatalf("timed out waiting to detach")
+	}
+
+	// The shim exits once the vm process has closed the tty
+	tty.Close()
+	waitFor(t, "socket to be removed", func() bool {
+		_, err := os.Stat(socketPath)
+		return os.IsNotExist(err)
+	})
+
+	err = Attach(context.Background(), socketPath, strings.NewReader(""), io.Discard, nil)
+	if !errors.Is(err, ErrNotAvailable) {
+		t.Errorf("expected console not to be available, got %v", err)
+	}
+}
+
+func TestConsolePipe(t *testing.T) {
+	dir := t.TempDir()
+	fifoPath := filepath.Join(dir, "vm.log.fifo")
+	logPath := filepath.Join(dir, "vm.log")
+
+	tty, err := Start(filepath.Join(dir, "console.sock"), filepath.Join(dir, "vm.stdout"), Pipe{FIFO: fifoPath, File: logPath})
+	if err != nil {
+		t.Fatalf("starting console: %s", err)
+	}
+
+	// The vm process opens the fifo for writing, which mustn't block
+	fifo, err := os.OpenFile(fifoPath, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("opening fifo: %s", err)
+	}
+	if _, err := fifo.WriteString("first line\n"); err != nil {
+		t.Fatalf("writing to fifo: %s", err)
+	}
+	waitFor(t, "log to be written", func() bool {
+		data, _ := os.ReadFile(logPath)
+		return string(data) == "first line\n"
+	})
+
+	// What is written just before the vm process exits isn't lost
+	fifo.WriteString("last line\n")
+	fifo.Close()
+	tty.Close()
+	waitFor(t, "last line to be written", func() bool {
+		data, _ := os.ReadFile(logPath)
+		return string(data) == "first line\nlast line\n"
+	})
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(waitTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// syncBuffer is a bytes.Buffer that can be written and read at the same time.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
@@ -0,0 +1,299 @@
+package console
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/mikrolite/mikrolite/adapters/logs"
+	"github.com/mikrolite/mikrolite/defaults"
+)
+
+const (
+	// clientBuffer is the number of reads from the pty that are buffered for
+	// each attached client. Output is dropped for clients that fall behind, so
+	// that they can't stop the vm from writing to the console.
+	clientBuffer = 64
+	readSize     = 4096
+	// rotateCheckSize is how many bytes are written to a file between checking
+	// if it needs rotating.
+	rotateCheckSize = 64 * 1024
+	// drainTimeout is how long the pipes are read for after the vm process has
+	// exited, so that its last writes aren't lost.
+	drainTimeout = 100 * time.Millisecond
+
+	socketPerm = 0o600
+	logPerm    = 0o644
+)
+
+// runShim runs the shim. The pty is passed as fd 3 and the ready pipe as fd 4.
+// The args are the socket and log paths followed by a fifo and file path for
+// each pipe.
+func runShim(args []string) int {
+	ready := os.NewFile(4, "ready")
+	if len(args) < 2 || len(args)%2 != 0 {
+		fmt.Fprintf(ready, "expected the socket and log paths and pairs of pipe paths, got %v", args)
+		ready.Close()
+
+		return 2
+	}
+
+	pipes := []Pipe{}
+	for i := 2; i < len(args); i += 2 {
+		pipes = append(pipes, Pipe{FIFO: args[i], File: args[i+1]})
+	}
+
+	s, err := newShim(os.NewFile(3, "pty"), args[0], args[1], pipes)
+	if err != nil {
+		fmt.Fprint(ready, err.Error())
+		ready.Close()
+
+		return 1
+	}
+
+	fmt.Fprint(ready, readyMessage)
+	ready.Close()
+
+	s.serve()
+
+	return 0
+}
+
+// shim copies the output from the pty to the log and the attached clients and
+// the input from the clients to the pty. Each line in the log starts with the
+// time it was written.
+type shim struct {
+	pty        *os.File
+	log        *rotatingFile
+	logWriter  io.Writer
+	pipes      []*pipe
+	listener   net.Listener
+	socketPath string
+
+	mu      sync.Mutex
+	clients map[net.Conn]chan []byte
+}
+
+// pipe is a fifo being copied to a file.
+type pipe struct {
+	fifo *os.File
+	file *rotatingFile
+	done chan struct{}
+}
+
+func newShim(pty *os.File, socketPath string, logPath string, pipes []Pipe) (*shim, error) {
+	log, err := openRotatingFile(logPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening console log %s: %w", logPath, err)
+	}
+
+	s := &shim{
+		pty:        pty,
+		log:        log,
+		logWriter:  logs.NewTimestampWriter(log),
+		socketPath: socketPath,
+		clients:    map[net.Conn]chan []byte{},
+	}
+
+	for _, p := range pipes {
+		// The fifo is opened for reading and writing so that opening it doesn't
+		// block and reading it doesn't end if the vm process reopens it
+		fifo, err := os.OpenFile(p.FIFO, os.O_RDWR, 0)
+		if err != nil {
+			s.closeFiles()
+			return nil, fmt.Errorf("opening fifo %s: %w", p.FIFO, err)
+		}
+		file, err := openRotatingFile(p.File)
+		if err != nil {
+			fifo.Close()
+			s.closeFiles()
+			return nil, fmt.Errorf("opening %s: %w", p.File, err)
+		}
+		s.pipes = append(s.pipes, &pipe{fifo: fifo, file: file, done: make(chan struct{})})
+	}
+
+	// Remove the socket left behind if the vm is being restarted
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		s.closeFiles()
+		return nil, fmt.Errorf("removing socket %s: %w", socketPath, err)
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		s.closeFiles()
+		return nil, fmt.Errorf("listening on %s: %w", socketPath, err)
+	}
+	if err := os.Chmod(socketPath, socketPerm); err != nil {
+		listener.Close()
+		s.closeFiles()
+		return nil, fmt.Errorf("setting permissions on %s: %w", socketPath, err)
+	}
+	s.listener = listener
+
+	return s, nil
+}
+
+// serve runs until the pty is closed, which happens when the vm process exits.
+func (s *shim) serve() {
+	go s.accept()
+	for _, p := range s.pipes {
+		go p.copy()
+	}
+
+	buf := make([]byte, readSize)
+	for {
+		n, err := s.pty.Read(buf)
+		if n > 0 {
+			data := make([]byte, n)
+			copy(data, buf[:n])
+
+			// The console must keep working even if the log can't be written
+			s.logWriter.Write(data)
+			s.broadcast(data)
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	s.listener.Close()
+	os.Remove(s.socketPath)
+
+	// The fifos are left behind, removing them would race with the console of
+	// the vm being started again
+	for _, p := range s.pipes {
+		p.drain()
+	}
+	s.closeFiles()
+
+	s.mu.Lock()
+	for conn, output := range s.clients {
+		close(output)
+		delete(s.clients, conn)
+	}
+	s.mu.Unlock()
+}
+
+func (s *shim) closeFiles() {
+	s.log.Close()
+	for _, p := range s.pipes {
+		p.fifo.Close()
+		p.file.Close()
+	}
+}
+
+func (s *shim) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		output := make(chan []byte, clientBuffer)
+		s.mu.Lock()
+		s.clients[conn] = output
+		s.mu.Unlock()
+
+		go s.handle(conn, output)
+	}
+}
+
+// handle writes the output to the client and its input to the pty until
+// either the client disconnects or the pty is closed.
+func (s *shim) handle(conn net.Conn, output chan []byte) {
+	go func() {
+		io.Copy(s.pty, conn)
+		s.remove(conn)
+	}()
+
+	for data := range output {
+		if _, err := conn.Write(data); err != nil {
+			s.remove(conn)
+		}
+	}
+	conn.Close()
+}
+
+func (s *shim) remove(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if output, ok := s.clients[conn]; ok {
+		close(output)
+		delete(s.clients, conn)
+	}
+}
+
+func (s *shim) broadcast(data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, output := range s.clients {
+		select {
+		case output <- data:
+		default:
+		}
+	}
+}
+
+// copy appends the fifo to the file until the fifo is drained.
+func (p *pipe) copy() {
+	defer close(p.done)
+
+	io.Copy(p.file, p.fifo)
+}
+
+// drain waits for what is left in the fifo to be copied.
+func (p *pipe) drain() {
+	p.fifo.SetReadDeadline(time.Now().Add(drainTimeout))
+	<-p.done
+}
+
+// rotatingFile appends to a file and rotates it once its too big. The files
+// are rotated by the shim as they're written to, even when mikrolited isn't
+// running. The file is locked while its written to and rotated, so that
+// readers that truncate it, like the firecracker metrics, don't lose writes.
+type rotatingFile struct {
+	file    *os.File
+	written int
+}
+
+func openRotatingFile(path string) (*rotatingFile, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, logPerm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rotatingFile{file: file}, nil
+}
+
+func (f *rotatingFile) Write(data []byte) (int, error) {
+	fd := int(f.file.Fd())
+	if err := unix.Flock(fd, unix.LOCK_EX); err != nil {
+		return 0, fmt.Errorf("locking %s: %w", f.file.Name(), err)
+	}
+	defer unix.Flock(fd, unix.LOCK_UN)
+
+	n, err := f.file.Write(data)
+
+	f.written += n
+	if f.written >= rotateCheckSize {
+		f.written = 0
+		logs.RotateLocked(f.file.Name(), defaults.LogMaxSize, defaults.LogMaxFiles)
+	}
+
+	return n, err
+}
+
+func (f *rotatingFile) Close() error {
+	return f.file.Close()
+}
@@ -3,8 +3,11 @@ package containerd
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"strings"
 
 	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/leases"
 	"github.com/containerd/containerd/namespaces"
 	"github.com/pterm/pterm"
@@ -40,14 +43,44 @@ func (s *imageService) PullAndMount(ctx context.Context, input ports.PullAndMoun
 		return nil, fmt.Errorf("ensuring image is unpacked: %w", err)
 	}
 
-	mount, err := s.snapshotImage(leaseCtx, input.Owner, image, input.UsedFor)
+	mount, err := s.snapshotImage(leaseCtx, input.Owner, input.Name, image, input.UsedFor)
 	if err != nil {
 		return nil, fmt.Errorf("snapshotting image %s: %w", image.Name(), err)
 	}
 
+	spec, err := image.Spec(leaseCtx)
+	if err != nil {
+		return nil, fmt.Errorf("getting config for image %s: %w", image.Name(), err)
+	}
+	mount.Labels = spec.Config.Labels
+
 	return mount, nil
 }
 
+func (s *imageService) Release(ctx context.Context, input ports.ReleaseInput) error {
+	pterm.DefaultSpinner.Info(fmt.Sprintf("ℹ️  Releasing image mount: %s\n", input.Name))
+
+	nsCtx := namespaces.WithNamespace(ctx, Namespace)
+	snapshotKey := snapshotKeyFor(input.Owner, input.Name, input.UsedFor)
+	snapshotClient := s.client.SnapshotService(getSnapshotterByUse(input.UsedFor))
+
+	exists, err := snapshotExists(nsCtx, snapshotKey, snapshotClient)
+	if err != nil {
+		return fmt.Errorf("checking if snapshot %s exists: %w", snapshotKey, err)
+	}
+	if !exists {
+		slog.Debug("snapshot doesn't exist, skipping removal", "key", snapshotKey)
+
+		return nil
+	}
+
+	if err := snapshotClient.Remove(nsCtx, snapshotKey); err != nil {
+		return fmt.Errorf("removing snapshot %s: %w", snapshotKey, err)
+	}
+
+	return nil
+}
+
 func (s *imageService) Cleanup(ctx context.Context, owner string) error {
 	pterm.DefaultSpinner.Info("ℹ️  Cleaning up images")
 
@@ -56,8 +89,30 @@ func (s *imageService) Cleanup(ctx context.Context, owner string) error {
 	lease := leases.Lease{ID: leaseName}
 
 	if err := s.client.LeasesService().Delete(nsCtx, lease, leases.SynchronousDelete); err != nil {
-		return fmt.Errorf("deleting containerd lease %s: %w", leaseName, err)
+		if !errdefs.IsNotFound(err) {
+			return fmt.Errorf("deleting containerd lease %s: %w", leaseName, err)
+		}
+		slog.Debug("containerd lease not found, skipping deletion", "name", leaseName)
 	}
 
 	return nil
 }
+
+func (s *imageService) ListOwners(ctx context.Context) ([]string, error) {
+	nsCtx := namespaces.WithNamespace(ctx, Namespace)
+
+	found, err := s.client.LeasesService().List(nsCtx)
+	if err != nil {
+		return nil, fmt.Errorf("listing leases: %w", err)
+	}
+
+	owners := []string{}
+	for _, lease := range found {
+		if !strings.HasPrefix(lease.ID, leasePrefix) {
+			continue
+		}
+		owners = append(owners, strings.TrimPrefix(lease.ID, leasePrefix))
+	}
+
+	return owners, nil
+}
@@ -76,6 +76,9 @@ func deleteLease(ctx context.Context, owner string, manager leases.Manager) erro
 	return nil
 }
 
+// leasePrefix is the prefix of the names of the leases created by mikrolite.
+const leasePrefix = "mikrolite/"
+
 func leaseNameFromOwner(owner string) string {
-	return fmt.Sprintf("mikrolite/%s", owner)
+	return leasePrefix + owner
 }
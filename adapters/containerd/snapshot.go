@@ -14,7 +14,7 @@ import (
 	"github.com/mikrolite/mikrolite/core/ports"
 )
 
-func (s *imageService) snapshotImage(ctx context.Context, owner string, image containerd.Image, usage ports.ImageUserFor) (*domain.Mount, error) {
+func (s *imageService) snapshotImage(ctx context.Context, owner string, name string, image containerd.Image, usage ports.ImageUserFor) (*domain.Mount, error) {
 	snapshotter := getSnapshotterByUse(usage)
 
 	content, err := image.RootFS(ctx)
@@ -24,7 +24,7 @@ func (s *imageService) snapshotImage(ctx context.Context, owner string, image co
 
 	parent := identity.ChainID(content).String()
 
-	snapshotKey := fmt.Sprintf("mikrolite/%s/%s", owner, usage)
+	snapshotKey := snapshotKeyFor(owner, name, usage)
 	snapshotClient := s.client.SnapshotService(snapshotter)
 
 	exists, err := snapshotExists(ctx, snapshotKey, snapshotClient)
@@ -99,6 +99,14 @@ func ensureUnpacked(ctx context.Context, image containerd.Image, usage ports.Ima
 	return nil
 }
 
+func snapshotKeyFor(owner string, name string, usage ports.ImageUserFor) string {
+	if name == "" {
+		return fmt.Sprintf("mikrolite/%s/%s", owner, usage)
+	}
+
+	return fmt.Sprintf("mikrolite/%s/%s/%s", owner, usage, name)
+}
+
 func getSnapshotterByUse(use ports.ImageUserFor) string {
 	if use == ports.ImageUsedForKernel {
 		return SnapshotterKernel
@@ -72,13 +72,16 @@ func (s *stateService) ListVMs() ([]*domain.VM, error) {
 		return nil, fmt.Errorf("error reading state dir %w", err)
 	}
 
+	stateDir := s.stateDir
+	defer func() { s.stateDir = stateDir }()
+
 	vms := []*domain.VM{}
 	for _, fileInfo := range fileInfos {
 		if !fileInfo.IsDir() {
 			continue
 		}
 
-		s.stateDir = filepath.Join(s.stateDir, fileInfo.Name())
+		s.stateDir = filepath.Join(s.rootStateDir, fileInfo.Name())
 
 		vm, err := s.GetVM()
 		if err != nil {
@@ -90,11 +93,41 @@ func (s *stateService) ListVMs() ([]*domain.VM, error) {
 		}
 	}
 
-	s.stateDir = ""
-
 	return vms, nil
 }
 
+func (s *stateService) ListDirs() ([]ports.StateDir, error) {
+	fileInfos, err := afero.ReadDir(s.fs, s.rootStateDir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading state dir %w", err)
+	}
+
+	stateDir := s.stateDir
+	defer func() { s.stateDir = stateDir }()
+
+	dirs := []ports.StateDir{}
+	for _, fileInfo := range fileInfos {
+		if !fileInfo.IsDir() {
+			continue
+		}
+
+		s.stateDir = filepath.Join(s.rootStateDir, fileInfo.Name())
+
+		pid, err := s.GetPID()
+		if err != nil {
+			return nil, fmt.Errorf("error getting pid for %s: %w", fileInfo.Name(), err)
+		}
+
+		dirs = append(dirs, ports.StateDir{
+			Name:    fileInfo.Name(),
+			ModTime: fileInfo.ModTime(),
+			PID:     pid,
+		})
+	}
+
+	return dirs, nil
+}
+
 func (s *stateService) LogPath() string {
 	return fmt.Sprintf("%s/vm.log", s.stateDir)
 }
@@ -107,6 +140,10 @@ func (s *stateService) StderrPath() string {
 	return fmt.Sprintf("%s/vm.stderr", s.stateDir)
 }
 
+func (s *stateService) MikroliteLogPath() string {
+	return fmt.Sprintf("%s/mikrolite.log", s.stateDir)
+}
+
 func (s *stateService) GetMetadata() (map[string]string, error) {
 	meta := metadata{}
 
@@ -188,6 +225,39 @@ func (s *stateService) SavePID(pid int) error {
 	return nil
 }
 
+func (s *stateService) GetExit() (*domain.ProcessExit, error) {
+	exists, err := afero.Exists(s.fs, s.exitFileName())
+	if err != nil {
+		return nil, fmt.Errorf("checking if exit file exists: %w", err)
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	exit := &domain.ProcessExit{}
+	if err := s.readJSONFile(exit, s.exitFileName()); err != nil {
+		return nil, fmt.Errorf("reading process exit from state: %w", err)
+	}
+
+	return exit, nil
+}
+
+func (s *stateService) SaveExit(exit *domain.ProcessExit) error {
+	if err := s.writeToFileAsJSON(exit, s.exitFileName()); err != nil {
+		return fmt.Errorf("saving process exit to state: %w", err)
+	}
+
+	return nil
+}
+
+func (s *stateService) DeleteExit() error {
+	if err := s.fs.Remove(s.exitFileName()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing exit file: %w", err)
+	}
+
+	return nil
+}
+
 func (s *stateService) Root() string {
 	return s.stateDir
 }
@@ -200,6 +270,10 @@ func (s *stateService) configFileName() string {
 	return fmt.Sprintf("%s/vm.json", s.stateDir)
 }
 
+func (s *stateService) exitFileName() string {
+	return fmt.Sprintf("%s/exit.json", s.stateDir)
+}
+
 func (s *stateService) metadaFilename() string {
 	return fmt.Sprintf("%s/metadata.json", s.stateDir)
 }
@@ -229,16 +303,15 @@ func (s *stateService) writeToFileAsJSON(cfg interface{}, outputFilePath string)
 		return fmt.Errorf("marshalling: %w", err)
 	}
 
-	file, err := s.fs.OpenFile(outputFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, dataFilePerm)
-	if err != nil {
-		return fmt.Errorf("opening output file %s: %w", outputFilePath, err)
+	// Write to a temporary file and rename it so that readers, e.g. a foreground
+	// vm start and the daemon, never see a partially written file
+	tmpFilePath := outputFilePath + ".tmp"
+	if err := afero.WriteFile(s.fs, tmpFilePath, data, dataFilePerm); err != nil {
+		return fmt.Errorf("writing output file %s: %w", tmpFilePath, err)
 	}
 
-	defer file.Close()
-
-	_, err = file.Write(data)
-	if err != nil {
-		return fmt.Errorf("writing output file %s: %w", outputFilePath, err)
+	if err := s.fs.Rename(tmpFilePath, outputFilePath); err != nil {
+		return fmt.Errorf("renaming %s to %s: %w", tmpFilePath, outputFilePath, err)
 	}
 
 	return nil
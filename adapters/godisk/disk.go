@@ -5,11 +5,15 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path"
+	"sync"
 
 	"github.com/diskfs/go-diskfs"
 	"github.com/diskfs/go-diskfs/disk"
 	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/iso9660"
 	units "github.com/docker/go-units"
 	"github.com/spf13/afero"
 
@@ -18,9 +22,14 @@ import (
 
 const (
 	defaultBlockSizeInBytes = 512
+	isoBlockSizeInBytes     = 2048
 	fillVolume              = 0
 )
 
+// finalizeLock serialises writing iso images, as go-diskfs changes the working
+// directory of the process while it walks the files to write.
+var finalizeLock sync.Mutex
+
 func New(fs afero.Fs) ports.DiskService {
 	return &diskService{
 		fs: fs,
@@ -63,10 +72,11 @@ func (s *diskService) Create(ctx context.Context, input ports.DiskCreateInput) e
 	if err != nil {
 		return fmt.Errorf("creating disk %s: %w", input.Path, err)
 	}
+	defer createdDisk.File.Close()
 
 	createdDisk.LogicalBlocksize = defaultBlockSizeInBytes
 	if input.Type == ports.DiskTypeISO9660 {
-		createdDisk.LogicalBlocksize = 2048
+		createdDisk.LogicalBlocksize = isoBlockSizeInBytes
 	}
 	fspec := disk.FilesystemSpec{
 		Partition:   fillVolume,
@@ -84,6 +94,17 @@ func (s *diskService) Create(ctx context.Context, input ports.DiskCreateInput) e
 		}
 	}
 
+	// The files of an iso are written to a workspace and then to the image
+	if isoFS, ok := fs.(*iso9660.FileSystem); ok {
+		finalizeLock.Lock()
+		defer finalizeLock.Unlock()
+
+		// Rock Ridge keeps the names of the files, e.g. meta-data rather than META_DAT
+		if err := isoFS.Finalize(iso9660.FinalizeOptions{RockRidge: true, VolumeIdentifier: input.VolumeName}); err != nil {
+			return fmt.Errorf("writing iso image %s: %w", input.Path, err)
+		}
+	}
+
 	return nil
 }
 
@@ -105,10 +126,19 @@ func createFileInImage(dest string, content string, fs filesystem.FileSystem) er
 		return fmt.Errorf("base64 decoding content %s: %w", content, err)
 	}
 
+	if dir := path.Dir(dest); dir != "/" && dir != "." {
+		if err := fs.Mkdir(dir); err != nil {
+			return fmt.Errorf("creating directory %s: %w", dir, err)
+		}
+	}
+
 	rw, err := fs.OpenFile(dest, os.O_CREATE|os.O_RDWR)
 	if err != nil {
 		return err
 	}
+	if closer, ok := rw.(io.Closer); ok {
+		defer closer.Close()
+	}
 
 	_, err = rw.Write(decoded)
 
@@ -0,0 +1,106 @@
+package godisk
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/spf13/afero"
+
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+func TestCreate(t *testing.T) {
+	files := map[string]string{
+		"/meta-data":                       "instance_id: vm1\n",
+		"/user-data":                       "#cloud-config\nhostname: vm1\n",
+		"/openstack/latest/meta_data.json": `{"uuid": "vm1"}`,
+		"/openstack/latest/user_data":      "#cloud-config\n",
+	}
+
+	testCases := []struct {
+		name       string
+		diskType   ports.DiskType
+		volumeName string
+		expectType filesystem.Type
+	}{
+		{name: "fat32", diskType: ports.DiskTypeFat32, volumeName: "cidata", expectType: filesystem.TypeFat32},
+		{name: "iso9660", diskType: ports.DiskTypeISO9660, volumeName: "cidata", expectType: filesystem.TypeISO9660},
+		{name: "iso9660 config drive", diskType: ports.DiskTypeISO9660, volumeName: "config-2", expectType: filesystem.TypeISO9660},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			imagePath := filepath.Join(t.TempDir(), "disk.img")
+			input := ports.DiskCreateInput{
+				Path:       imagePath,
+				Size:       "8Mb",
+				VolumeName: tc.volumeName,
+				Type:       tc.diskType,
+			}
+			for path, content := range files {
+				input.Files = append(input.Files, ports.DiskFile{Path: path, ContentBase64: base64.StdEncoding.EncodeToString([]byte(content))})
+			}
+
+			svc := New(afero.NewOsFs())
+			if err := svc.Create(context.Background(), input); err != nil {
+				t.Fatalf("creating disk: %s", err)
+			}
+
+			image, err := diskfs.Open(imagePath, diskfs.WithOpenMode(diskfs.ReadOnly))
+			if err != nil {
+				t.Fatalf("opening disk: %s", err)
+			}
+			defer image.File.Close()
+			fs, err := image.GetFilesystem(0)
+			if err != nil {
+				t.Fatalf("reading filesystem: %s", err)
+			}
+			if fs.Type() != tc.expectType {
+				t.Errorf("expected filesystem type %d, got %d", tc.expectType, fs.Type())
+			}
+			if label := strings.Trim(fs.Label(), " \x00"); !strings.EqualFold(label, tc.volumeName) {
+				t.Errorf("expected label %s, got %s", tc.volumeName, label)
+			}
+
+			for path, content := range files {
+				file, err := fs.OpenFile(path, os.O_RDONLY)
+				if err != nil {
+					t.Fatalf("opening %s: %s", path, err)
+				}
+				data, err := io.ReadAll(file)
+				if err != nil {
+					t.Fatalf("reading %s: %s", path, err)
+				}
+				if string(data) != content {
+					t.Errorf("expected %s to be %q, got %q", path, content, data)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateExisting(t *testing.T) {
+	imagePath := filepath.Join(t.TempDir(), "disk.img")
+	if err := os.WriteFile(imagePath, []byte("existing"), 0o644); err != nil {
+		t.Fatalf("writing image: %s", err)
+	}
+	svc := New(afero.NewOsFs())
+	input := ports.DiskCreateInput{Path: imagePath, Size: "8Mb", VolumeName: "cidata", Type: ports.DiskTypeISO9660}
+
+	if err := svc.Create(context.Background(), input); !errors.Is(err, os.ErrExist) {
+		t.Errorf("expected the existing image to be kept, got %v", err)
+	}
+
+	input.Overwrite = true
+	if err := svc.Create(context.Background(), input); err != nil {
+		t.Errorf("expected the existing image to be overwritten, got %s", err)
+	}
+}
@@ -0,0 +1,129 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/mikrolite/mikrolite/defaults"
+)
+
+// VMKey is the key of the attribute that names the vm a log record is about.
+const VMKey = "vm"
+
+// mikroliteLogName is the name of the mikrolite log file in the vm state
+// directory, it matches the filesystem state service.
+const mikroliteLogName = "mikrolite.log"
+
+// NewHandler returns a slog handler that passes the records to next. The info
+// and higher records with a vm attribute are also appended as JSON to the
+// mikrolite log of the vm, if the vm has a state directory.
+func NewHandler(next slog.Handler, stateRoot string) slog.Handler {
+	return &handler{
+		next:      next,
+		stateRoot: stateRoot,
+		mu:        &sync.Mutex{},
+	}
+}
+
+type handler struct {
+	next      slog.Handler
+	stateRoot string
+	attrs     []slog.Attr
+	groups    []string
+	// mu serializes the writes and rotation of the log files, its shared by
+	// the handlers created with WithAttrs and WithGroup.
+	mu *sync.Mutex
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo || h.next.Enabled(ctx, level)
+}
+
+func (h *handler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level >= slog.LevelInfo {
+		if vmName := h.vmName(record); vmName != "" {
+			// Failing to write the vm log mustn't stop mikrolite logging
+			h.writeVMLog(ctx, vmName, record)
+		}
+	}
+
+	if !h.next.Enabled(ctx, record.Level) {
+		return nil
+	}
+
+	return h.next.Handle(ctx, record)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.next = h.next.WithAttrs(attrs)
+	clone.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+
+	return &clone
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.next = h.next.WithGroup(name)
+	clone.groups = append(append([]string{}, h.groups...), name)
+
+	return &clone
+}
+
+// vmName returns the value of the vm attribute, attributes in groups are
+// ignored.
+func (h *handler) vmName(record slog.Record) string {
+	if len(h.groups) > 0 {
+		return ""
+	}
+
+	vmName := ""
+	for _, attr := range h.attrs {
+		if attr.Key == VMKey {
+			vmName = attr.Value.String()
+		}
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		if attr.Key == VMKey {
+			vmName = attr.Value.String()
+			return false
+		}
+
+		return true
+	})
+
+	return vmName
+}
+
+func (h *handler) writeVMLog(ctx context.Context, vmName string, record slog.Record) error {
+	dir := filepath.Join(h.stateRoot, vmName)
+	if _, err := os.Stat(dir); err != nil {
+		// The vm has been removed or isn't created yet
+		return nil
+	}
+	path := filepath.Join(dir, mikroliteLogName)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, defaults.DataFilePerm)
+	if err != nil {
+		return fmt.Errorf("opening mikrolite log %s: %w", path, err)
+	}
+
+	var fileHandler slog.Handler = slog.NewJSONHandler(file, nil)
+	fileHandler = fileHandler.WithAttrs(h.attrs)
+	if err := fileHandler.Handle(ctx, record); err != nil {
+		file.Close()
+		return fmt.Errorf("writing mikrolite log %s: %w", path, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("closing mikrolite log %s: %w", path, err)
+	}
+
+	return Rotate(path, defaults.LogMaxSize, defaults.LogMaxFiles)
+}
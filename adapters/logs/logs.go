@@ -0,0 +1,101 @@
+// Package logs reads, rotates and writes the log files in the state directory
+// of a vm. The files are tagged with a source so that the console output, the
+// hypervisor logs and the log of what mikrolite has done to the vm can be shown
+// together or on their own.
+package logs
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+// Source is where a log line came from.
+type Source string
+
+const (
+	// SourceConsole is the output of the serial console of the vm.
+	SourceConsole Source = "console"
+	// SourceHypervisor is the log and the stderr of the vm process.
+	SourceHypervisor Source = "hypervisor"
+	// SourceMikrolite is the log of what mikrolite has done to the vm.
+	SourceMikrolite Source = "mikrolite"
+
+	// TimeFormat is the format of the time of the formatted entries.
+	TimeFormat = "2006-01-02T15:04:05.000Z07:00"
+)
+
+// Sources are all the sources, in the order their files are read.
+var Sources = []Source{SourceMikrolite, SourceHypervisor, SourceConsole}
+
+// ParseSource returns the source with the name.
+func ParseSource(name string) (Source, error) {
+	for _, source := range Sources {
+		if string(source) == name {
+			return source, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown log source %q, expected one of %s", name, joinSources(Sources))
+}
+
+// Entry is a line from a log file.
+type Entry struct {
+	// Time is when the line was logged. Lines without a time get the time of the
+	// line before them.
+	Time time.Time
+	// Source is the source of the file the line was read from.
+	Source Source
+	// Message is the line, parsed if it was structured.
+	Message string
+}
+
+// Format returns the entry as a line tagged with its time and source.
+func (e Entry) Format() string {
+	return fmt.Sprintf("%s [%s] %s", e.Time.Format(TimeFormat), e.Source, e.Message)
+}
+
+// Files returns the current log files of the source. The rotated files have
+// the same path with a number appended, e.g. vm.stdout.1.
+func Files(ss ports.StateService, source Source) []string {
+	switch source {
+	case SourceConsole:
+		return []string{ss.StdoutPath()}
+	case SourceHypervisor:
+		return []string{ss.LogPath(), ss.StderrPath()}
+	case SourceMikrolite:
+		return []string{ss.MikroliteLogPath()}
+	default:
+		return nil
+	}
+}
+
+// rotatedPath returns the path of the nth rotated file.
+func rotatedPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// WithRotated returns the rotated files that exist followed by the current file,
+// oldest first.
+func WithRotated(path string, keep int) []string {
+	paths := []string{}
+	for n := keep; n >= 1; n-- {
+		if _, err := os.Stat(rotatedPath(path, n)); err == nil {
+			paths = append(paths, rotatedPath(path, n))
+		}
+	}
+
+	return append(paths, path)
+}
+
+func joinSources(sources []Source) string {
+	names := make([]string, 0, len(sources))
+	for _, source := range sources {
+		names = append(names, string(source))
+	}
+
+	return strings.Join(names, ", ")
+}
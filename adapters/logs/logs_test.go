@@ -0,0 +1,366 @@
+package logs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/mikrolite/mikrolite/testing/fakes"
+)
+
+func TestParseLine(t *testing.T) {
+	testCases := []struct {
+		name       string
+		line       string
+		expectTime time.Time
+		expectMsg  string
+	}{
+		{
+			name:       "console line",
+			line:       "2024-01-02T15:04:05.5Z fakevmm login: \r\n",
+			expectTime: time.Date(2024, 1, 2, 15, 4, 5, 500000000, time.UTC),
+			expectMsg:  "fakevmm login: ",
+		},
+		{
+			name:       "firecracker text line",
+			line:       "2024-01-02T15:04:05.123456789 [anonymous-instance:main] Running Firecracker v1.5.0",
+			expectTime: time.Date(2024, 1, 2, 15, 4, 5, 123456789, time.Local),
+			expectMsg:  "[anonymous-instance:main] Running Firecracker v1.5.0",
+		},
+		{
+			name:       "firecracker json line",
+			line:       `{"utc_timestamp_ms":1704207845000,"level":"Warn","msg":"guest tried to write","origin":"devices::virtio"}`,
+			expectTime: time.UnixMilli(1704207845000),
+			expectMsg:  "WARN guest tried to write origin=devices::virtio",
+		},
+		{
+			name:       "mikrolite line",
+			line:       `{"time":"2024-01-02T15:04:05Z","level":"INFO","msg":"vm created","vm":"vm1","ip":"192.168.122.50"}`,
+			expectTime: time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC),
+			expectMsg:  "INFO vm created ip=192.168.122.50 vm=vm1",
+		},
+		{
+			name:      "nested fields",
+			line:      `{"api_server":{"process_startup_time_us":10},"reason":"shut down"}`,
+			expectMsg: `api_server={"process_startup_time_us":10} reason="shut down"`,
+		},
+		{
+			name:      "line without a time",
+			line:      "cloud-hypervisor: 10.5ms: <vmm> INFO:vmm/src/lib.rs:1 API request",
+			expectMsg: "cloud-hypervisor: 10.5ms: <vmm> INFO:vmm/src/lib.rs:1 API request",
+		},
+		{
+			name:      "invalid json",
+			line:      "{not json",
+			expectMsg: "{not json",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			parsedTime, message := parseLine(tc.line)
+
+			if !parsedTime.Equal(tc.expectTime) {
+				t.Errorf("expected time %s, got %s", tc.expectTime, parsedTime)
+			}
+			if message != tc.expectMsg {
+				t.Errorf("expected message %q, got %q", tc.expectMsg, message)
+			}
+		})
+	}
+}
+
+func TestRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vm.stdout")
+
+	for i := 1; i <= 4; i++ {
+		if err := os.WriteFile(path, []byte(fmt.Sprintf("file %d\n", i)), 0o644); err != nil {
+			t.Fatalf("writing log: %s", err)
+		}
+		if err := Rotate(path, 4, 2); err != nil {
+			t.Fatalf("rotating log: %s", err)
+		}
+	}
+
+	expected := map[string]string{
+		path:                 "",
+		rotatedPath(path, 1): "file 4\n",
+		rotatedPath(path, 2): "file 3\n",
+	}
+	for file, content := range expected {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("reading %s: %s", file, err)
+		}
+		if string(data) != content {
+			t.Errorf("expected %s to contain %q, got %q", file, content, data)
+		}
+	}
+	if _, err := os.Stat(rotatedPath(path, 3)); !os.IsNotExist(err) {
+		t.Errorf("expected only 2 rotated files to be kept")
+	}
+
+	// Small and missing files are left alone
+	if err := os.WriteFile(path, []byte("new\n"), 0o644); err != nil {
+		t.Fatalf("writing log: %s", err)
+	}
+	if err := Rotate(path, 100, 2); err != nil {
+		t.Fatalf("rotating log: %s", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new\n" {
+		t.Errorf("expected small file not to be rotated, got %q", data)
+	}
+	if err := Rotate(filepath.Join(t.TempDir(), "missing"), 1, 2); err != nil {
+		t.Errorf("expected missing file to be ignored, got %s", err)
+	}
+}
+
+func TestRotateWaitsForLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vm.stdout")
+	if err := os.WriteFile(path, []byte("before rotate\n"), 0o644); err != nil {
+		t.Fatalf("writing log: %s", err)
+	}
+	writer, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("opening log: %s", err)
+	}
+	defer writer.Close()
+	if err := unix.Flock(int(writer.Fd()), unix.LOCK_EX); err != nil {
+		t.Fatalf("locking log: %s", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- Rotate(path, 1, 1) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("expected rotate to wait for the lock, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	// Written while the lock is held, so it must be rotated with the rest
+	if _, err := writer.WriteString("while locked\n"); err != nil {
+		t.Fatalf("writing log: %s", err)
+	}
+	if err := unix.Flock(int(writer.Fd()), unix.LOCK_UN); err != nil {
+		t.Fatalf("unlocking log: %s", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("rotating log: %s", err)
+	}
+
+	if data, _ := os.ReadFile(rotatedPath(path, 1)); string(data) != "before rotate\nwhile locked\n" {
+		t.Errorf("expected the rotated file to have all the writes, got %q", data)
+	}
+	if data, _ := os.ReadFile(path); len(data) != 0 {
+		t.Errorf("expected the log to be truncated, got %q", data)
+	}
+}
+
+func TestRead(t *testing.T) {
+	ss := fakes.NewStateService(fakes.NewRecorder(), t.TempDir(), "vm1")
+	if err := os.MkdirAll(ss.Root(), 0o755); err != nil {
+		t.Fatalf("creating state dir: %s", err)
+	}
+
+	writeFile(t, ss.MikroliteLogPath(), `{"time":"2024-01-02T15:00:00Z","level":"INFO","msg":"vm created","vm":"vm1"}`+"\n")
+	writeFile(t, ss.StdoutPath()+".1", "2024-01-02T15:00:01Z Booting\n")
+	writeFile(t, ss.StdoutPath(), "2024-01-02T15:00:03Z login: ")
+	writeFile(t, ss.LogPath(), `{"utc_timestamp_ms":1704207602000,"level":"Info","msg":"vm started"}`+"\ncontinued\n")
+
+	testCases := []struct {
+		name     string
+		opts     Options
+		expected []string
+	}{
+		{
+			name: "all sources in time order",
+			expected: []string{
+				"2024-01-02T15:00:00.000Z [mikrolite] INFO vm created vm=vm1",
+				"2024-01-02T15:00:01.000Z [console] Booting",
+				"2024-01-02T15:00:02.000Z [hypervisor] INFO vm started",
+				"2024-01-02T15:00:02.000Z [hypervisor] continued",
+				"2024-01-02T15:00:03.000Z [console] login: ",
+			},
+		},
+		{
+			name: "single source",
+			opts: Options{Sources: []Source{SourceConsole}},
+			expected: []string{
+				"2024-01-02T15:00:01.000Z [console] Booting",
+				"2024-01-02T15:00:03.000Z [console] login: ",
+			},
+		},
+		{
+			name: "since",
+			opts: Options{Since: time.Date(2024, 1, 2, 15, 0, 2, 0, time.UTC)},
+			expected: []string{
+				"2024-01-02T15:00:02.000Z [hypervisor] INFO vm started",
+				"2024-01-02T15:00:02.000Z [hypervisor] continued",
+				"2024-01-02T15:00:03.000Z [console] login: ",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := []string{}
+			err := Read(context.Background(), ss, tc.opts, func(entry Entry) error {
+				entry.Time = entry.Time.UTC()
+				got = append(got, entry.Format())
+
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("reading logs: %s", err)
+			}
+
+			if strings.Join(got, "\n") != strings.Join(tc.expected, "\n") {
+				t.Errorf("expected:\n%s\ngot:\n%s", strings.Join(tc.expected, "\n"), strings.Join(got, "\n"))
+			}
+		})
+	}
+}
+
+func TestReadFollow(t *testing.T) {
+	ss := fakes.NewStateService(fakes.NewRecorder(), t.TempDir(), "vm1")
+	if err := os.MkdirAll(ss.Root(), 0o755); err != nil {
+		t.Fatalf("creating state dir: %s", err)
+	}
+	writeFile(t, ss.StdoutPath(), "2024-01-02T15:00:00Z old\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mu := sync.Mutex{}
+	got := []string{}
+	done := make(chan error, 1)
+	go func() {
+		opts := Options{Sources: []Source{SourceConsole}, Follow: true, PollInterval: 10 * time.Millisecond}
+		done <- Read(ctx, ss, opts, func(entry Entry) error {
+			mu.Lock()
+			defer mu.Unlock()
+			got = append(got, entry.Message)
+
+			return nil
+		})
+	}()
+
+	waitForMessages := func(expected ...string) {
+		t.Helper()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			mu.Lock()
+			current := strings.Join(got, ",")
+			mu.Unlock()
+			if current == strings.Join(expected, ",") {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected messages %v, got %s", expected, current)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitForMessages("old")
+
+	appendFile(t, ss.StdoutPath(), "2024-01-02T15:00:01Z new\n")
+	waitForMessages("old", "new")
+
+	// The file is read from the start again after its rotated
+	if err := Rotate(ss.StdoutPath(), 1, 1); err != nil {
+		t.Fatalf("rotating log: %s", err)
+	}
+	appendFile(t, ss.StdoutPath(), "after rotation\n")
+	waitForMessages("old", "new", "after rotation")
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("following logs: %s", err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "vm1"), 0o755); err != nil {
+		t.Fatalf("creating state dir: %s", err)
+	}
+
+	out := &bytes.Buffer{}
+	logger := slog.New(NewHandler(slog.NewTextHandler(out, &slog.HandlerOptions{Level: slog.LevelWarn}), root))
+
+	logger.Info("vm created", VMKey, "vm1")
+	logger.With(VMKey, "vm1").Warn("vm crashed", "code", 3)
+	logger.Debug("not logged", VMKey, "vm1")
+	logger.Info("vm without state", VMKey, "missing")
+	logger.Info("no vm")
+
+	data, err := os.ReadFile(filepath.Join(root, "vm1", mikroliteLogName))
+	if err != nil {
+		t.Fatalf("reading mikrolite log: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines in the mikrolite log, got %q", data)
+	}
+	if _, message := parseLine(lines[0]); message != "INFO vm created vm=vm1" {
+		t.Errorf("expected vm created to be logged, got %q", message)
+	}
+	if _, message := parseLine(lines[1]); message != "WARN vm crashed code=3 vm=vm1" {
+		t.Errorf("expected vm crashed to be logged, got %q", message)
+	}
+	if _, err := os.Stat(filepath.Join(root, "missing")); !os.IsNotExist(err) {
+		t.Errorf("expected the state directory not to be created")
+	}
+
+	// The next handler still filters by its level
+	if strings.Count(out.String(), "\n") != 1 || !strings.Contains(out.String(), "vm crashed") {
+		t.Errorf("expected only the warning to be passed on, got %q", out.String())
+	}
+}
+
+func TestTimestampWriter(t *testing.T) {
+	out := &bytes.Buffer{}
+	w := &timestampWriter{w: out, now: func() time.Time { return time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC) }, lineStart: true}
+
+	for _, data := range []string{"Boot", "ing\r\nlog", "in: "} {
+		if _, err := w.Write([]byte(data)); err != nil {
+			t.Fatalf("writing: %s", err)
+		}
+	}
+
+	expected := "2024-01-02T15:04:05Z Booting\r\n2024-01-02T15:04:05Z login: "
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("writing %s: %s", path, err)
+	}
+}
+
+func appendFile(t *testing.T, path string, content string) {
+	t.Helper()
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatalf("opening %s: %s", path, err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(content); err != nil {
+		t.Fatalf("writing %s: %s", path, err)
+	}
+}
@@ -0,0 +1,119 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// firecrackerTimeFormat is the time at the start of the firecracker text log
+// lines, its in local time.
+const firecrackerTimeFormat = "2006-01-02T15:04:05.999999999"
+
+// parseLine returns the time and message of a log line. The time is zero if
+// the line doesn't have one. JSON lines, such as the firecracker and mikrolite
+// logs, are turned into readable messages.
+func parseLine(line string) (time.Time, string) {
+	line = strings.TrimRight(line, "\r\n")
+
+	if strings.HasPrefix(line, "{") {
+		if t, message, ok := parseJSON(line); ok {
+			return t, message
+		}
+	}
+
+	// The console lines and the firecracker text lines start with the time
+	if first, rest, found := strings.Cut(line, " "); found {
+		if t, ok := parseTime(first); ok {
+			return t, strings.TrimRight(rest, "\r")
+		}
+	}
+
+	return time.Time{}, line
+}
+
+// parseJSON turns a JSON log line into a message with the level, the message
+// and then the other fields in name order.
+func parseJSON(line string) (time.Time, string, bool) {
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+	fields := map[string]interface{}{}
+	if err := decoder.Decode(&fields); err != nil {
+		return time.Time{}, "", false
+	}
+
+	t := time.Time{}
+	for _, key := range []string{"time", "timestamp"} {
+		if value, ok := fields[key].(string); ok {
+			if parsed, ok := parseTime(value); ok {
+				t = parsed
+				delete(fields, key)
+				break
+			}
+		}
+	}
+	if value, ok := fields["utc_timestamp_ms"].(json.Number); ok {
+		if ms, err := value.Int64(); err == nil {
+			t = time.UnixMilli(ms)
+			delete(fields, "utc_timestamp_ms")
+		}
+	}
+
+	parts := []string{}
+	if level, ok := fields["level"].(string); ok {
+		parts = append(parts, strings.ToUpper(level))
+		delete(fields, "level")
+	}
+	for _, key := range []string{"msg", "message"} {
+		if message, ok := fields[key].(string); ok {
+			parts = append(parts, message)
+			delete(fields, key)
+			break
+		}
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%s", key, formatValue(fields[key])))
+	}
+
+	return t, strings.Join(parts, " "), true
+}
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		if strings.ContainsAny(v, " \t\"=") {
+			return fmt.Sprintf("%q", v)
+		}
+
+		return v
+	case json.Number:
+		return v.String()
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+
+		return string(bytes.TrimSpace(data))
+	}
+}
+
+func parseTime(value string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, true
+	}
+	if t, err := time.ParseInLocation(firecrackerTimeFormat, value, time.Local); err == nil {
+		return t, true
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,218 @@
+package logs
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mikrolite/mikrolite/core/ports"
+	"github.com/mikrolite/mikrolite/defaults"
+)
+
+const defaultPollInterval = 250 * time.Millisecond
+
+// Options are the options for reading the logs of a vm.
+type Options struct {
+	// Sources are the sources to read, all of them if empty.
+	Sources []Source
+	// Since skips the entries from before the time, if its set.
+	Since time.Time
+	// Follow waits for new entries after the existing ones have been read.
+	Follow bool
+	// PollInterval is how often the files are checked for new entries when
+	// following, defaults to 250ms.
+	PollInterval time.Duration
+}
+
+// Read calls fn with the entries in the log files of the vm, including the
+// rotated files, in time order. Lines without a time get the time of the line
+// before them, or the time the file was last modified if there isn't one. With
+// Follow new entries are passed to fn as they're written until the context is
+// cancelled.
+func Read(ctx context.Context, ss ports.StateService, opts Options, fn func(Entry) error) error {
+	sources := opts.Sources
+	if len(sources) == 0 {
+		sources = Sources
+	}
+
+	entries := []Entry{}
+	tails := []*tail{}
+	for _, source := range sources {
+		for _, path := range Files(ss, source) {
+			t := &tail{path: path, source: source}
+			for _, file := range WithRotated(path, defaults.LogMaxFiles) {
+				fileEntries, size, err := readFile(file, source)
+				if err != nil {
+					return err
+				}
+				entries = append(entries, fileEntries...)
+				t.offset = size
+			}
+			tails = append(tails, t)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	for _, entry := range entries {
+		if entry.Time.Before(opts.Since) {
+			continue
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	if !opts.Follow {
+		return nil
+	}
+
+	interval := opts.PollInterval
+	if interval == 0 {
+		interval = defaultPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			for _, t := range tails {
+				if err := t.read(fn); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// readFile returns the entries in the file and its size, a missing file has
+// no entries.
+func readFile(path string, source Source) ([]Entry, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, 0, nil
+		}
+
+		return nil, 0, fmt.Errorf("opening log file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, fmt.Errorf("checking log file %s: %w", path, err)
+	}
+
+	entries, size, err := readEntries(file, source)
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading log file %s: %w", path, err)
+	}
+
+	// Lines without a time get the time of the line before them and the lines
+	// at the start of the file get the first time in it
+	last := time.Time{}
+	for i := range entries {
+		if entries[i].Time.IsZero() {
+			entries[i].Time = last
+		}
+		last = entries[i].Time
+	}
+	first := info.ModTime()
+	for _, entry := range entries {
+		if !entry.Time.IsZero() {
+			first = entry.Time
+			break
+		}
+	}
+	for i := range entries {
+		if !entries[i].Time.IsZero() {
+			break
+		}
+		entries[i].Time = first
+	}
+
+	return entries, size, nil
+}
+
+// readEntries returns the entries for the lines in r and the number of bytes
+// read. A last line without a newline, such as a login prompt, is included.
+func readEntries(r io.Reader, source Source) ([]Entry, int64, error) {
+	entries := []Entry{}
+	size := int64(0)
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		size += int64(len(line))
+		if strings.TrimRight(line, "\r\n") != "" {
+			t, message := parseLine(line)
+			entries = append(entries, Entry{Time: t, Source: source, Message: message})
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return entries, size, nil
+			}
+
+			return nil, 0, err
+		}
+	}
+}
+
+// tail follows a log file from an offset.
+type tail struct {
+	path   string
+	source Source
+	offset int64
+}
+
+// read passes the entries written since the last read to fn. The file is read
+// from the start again if its been rotated.
+func (t *tail) read(fn func(Entry) error) error {
+	file, err := os.Open(t.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			t.offset = 0
+			return nil
+		}
+
+		return fmt.Errorf("opening log file %s: %w", t.path, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("checking log file %s: %w", t.path, err)
+	}
+	if info.Size() < t.offset {
+		t.offset = 0
+	}
+	if info.Size() == t.offset {
+		return nil
+	}
+
+	entries, size, err := readEntries(io.NewSectionReader(file, t.offset, info.Size()-t.offset), t.source)
+	if err != nil {
+		return fmt.Errorf("reading log file %s: %w", t.path, err)
+	}
+	t.offset += size
+
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.Time.IsZero() {
+			entry.Time = now
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
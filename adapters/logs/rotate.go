@@ -0,0 +1,107 @@
+package logs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/mikrolite/mikrolite/core/ports"
+	"github.com/mikrolite/mikrolite/defaults"
+)
+
+// Rotate copies the file to path.1 and truncates it if it has grown to
+// maxSize bytes. The rotated files are shifted up and only keep of them are
+// kept. The file is copied rather than renamed because the vm process and the
+// console shim keep it open. Those that open it with O_APPEND carry on writing
+// at the start of the truncated file. The file is locked while its rotated, so
+// that the console shim doesn't write to it between the copy and the truncate.
+func Rotate(path string, maxSize int64, keep int) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return fmt.Errorf("opening log file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	fd := int(file.Fd())
+	if err := unix.Flock(fd, unix.LOCK_EX); err != nil {
+		return fmt.Errorf("locking log file %s: %w", path, err)
+	}
+	defer unix.Flock(fd, unix.LOCK_UN)
+
+	return RotateLocked(path, maxSize, keep)
+}
+
+// RotateLocked rotates the file like Rotate, for callers that already hold the
+// lock on it.
+func RotateLocked(path string, maxSize int64, keep int) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return fmt.Errorf("checking log file %s: %w", path, err)
+	}
+	if info.Size() < maxSize {
+		return nil
+	}
+
+	if err := os.Remove(rotatedPath(path, keep)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing oldest log file: %w", err)
+	}
+	for n := keep - 1; n >= 1; n-- {
+		if err := os.Rename(rotatedPath(path, n), rotatedPath(path, n+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("shifting rotated log file: %w", err)
+		}
+	}
+
+	if err := copyFile(path, rotatedPath(path, 1)); err != nil {
+		return err
+	}
+	if err := os.Truncate(path, 0); err != nil {
+		return fmt.Errorf("truncating log file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// RotateVM rotates all the log files of the vm that have grown too big.
+func RotateVM(ss ports.StateService) error {
+	errs := []error{}
+	for _, source := range Sources {
+		for _, path := range Files(ss, source) {
+			if err := Rotate(path, defaults.LogMaxSize, defaults.LogMaxFiles); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("opening log file %s: %w", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, defaults.DataFilePerm)
+	if err != nil {
+		return fmt.Errorf("creating rotated log file %s: %w", dst, err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("copying log file %s: %w", src, err)
+	}
+
+	return out.Close()
+}
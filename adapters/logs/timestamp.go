@@ -0,0 +1,39 @@
+package logs
+
+import (
+	"io"
+	"time"
+)
+
+// NewTimestampWriter returns a writer that writes to w with the current time
+// at the start of each line, so that the lines can be read with their time.
+func NewTimestampWriter(w io.Writer) io.Writer {
+	return &timestampWriter{w: w, now: time.Now, lineStart: true}
+}
+
+type timestampWriter struct {
+	w         io.Writer
+	now       func() time.Time
+	lineStart bool
+}
+
+func (t *timestampWriter) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p)+64)
+	for _, b := range p {
+		if t.lineStart {
+			out = append(out, t.now().UTC().Format(time.RFC3339Nano)...)
+			out = append(out, ' ')
+			t.lineStart = false
+		}
+		out = append(out, b)
+		if b == '\n' {
+			t.lineStart = true
+		}
+	}
+
+	if _, err := t.w.Write(out); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
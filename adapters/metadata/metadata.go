@@ -0,0 +1,336 @@
+// Package metadata implements the metadata server that mikrolite runs on the
+// host for vm providers that don't have a metadata service, like the firecracker
+// MMDS. It serves the metadata of the vm in the nocloud-net layout, or like the
+// EC2 instance metadata service for vms that use the ec2 datasource. The vm is
+// identified by the tap device of its metadata interface, which the host routes
+// the source address of the request over.
+package metadata
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+	"github.com/mikrolite/mikrolite/imds"
+)
+
+const (
+	// pathPrefix is the prefix of the nocloud-net seed url.
+	pathPrefix      = "/latest/"
+	shutdownTimeout = 5 * time.Second
+	// maxTokensPerVM is the number of session tokens a vm can have at once, the
+	// oldest is dropped when another is issued.
+	maxTokensPerVM = 32
+)
+
+// StateServiceFactory creates the state service for the named vm, the name is
+// empty for the state service used to list the vms.
+type StateServiceFactory func(vmName string) (ports.StateService, error)
+
+// New creates the metadata server for the vms in the state.
+func New(newStateService StateServiceFactory, networkService ports.NetworkService) *Server {
+	return &Server{
+		newStateService: newStateService,
+		networkService:  networkService,
+		tokens:          map[string]token{},
+		now:             time.Now,
+	}
+}
+
+// Server serves the metadata of the vms.
+type Server struct {
+	newStateService StateServiceFactory
+	networkService  ports.NetworkService
+
+	mu sync.Mutex
+	// tokens are the IMDSv2 session tokens that have been issued.
+	tokens map[string]token
+	// issued is the number of tokens issued, its used to order them.
+	issued uint64
+	now    func() time.Time
+}
+
+// token is an IMDSv2 session token, its only valid for the vm its issued to.
+type token struct {
+	vmName  string
+	expires time.Time
+	seq     uint64
+}
+
+// Listen listens on the address. The address doesn't have to exist on the host
+// yet, its added to the metadata interface of each vm that uses the server.
+func Listen(ctx context.Context, address string) (net.Listener, error) {
+	lc := net.ListenConfig{Control: freeBind}
+	listener, err := lc.Listen(ctx, "tcp4", address)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", address, err)
+	}
+
+	return listener, nil
+}
+
+// Serve serves the metadata on the listener until the context is cancelled.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	httpServer := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			httpServer.Close()
+		}
+	}()
+
+	slog.Info("metadata server listening", "address", listener.Addr().String())
+
+	if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serving metadata: %w", err)
+	}
+
+	return nil
+}
+
+// freeBind allows listening on an address that isn't on any interface yet.
+func freeBind(network, address string, conn syscall.RawConn) error {
+	var sockErr error
+	err := conn.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_IP, syscall.IP_FREEBIND, 1)
+	})
+	if err != nil {
+		return err
+	}
+
+	return sockErr
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	vm, err := s.findVM(host)
+	if err != nil {
+		slog.Error("finding vm for metadata request", "address", host, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if vm == nil {
+		slog.Warn("metadata request from unknown address", "address", host)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+
+	if vm.Spec.Datasource() == domain.DatasourceEC2 {
+		s.serveEC2(w, r, vm)
+		return
+	}
+	s.serveNoCloud(w, r, vm)
+}
+
+// serveNoCloud serves the metadata in the nocloud-net layout.
+func (s *Server) serveNoCloud(w http.ResponseWriter, r *http.Request, vm *domain.VM) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, pathPrefix)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	metadata, err := s.getMetadata(vm.Name)
+	if err != nil {
+		slog.Error("getting metadata", "vm", vm.Name, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// The seed url lists the keys like the firecracker MMDS
+	if key == "" {
+		keys := make([]string, 0, len(metadata))
+		for k := range metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprint(w, strings.Join(keys, "\n"))
+		return
+	}
+
+	value, ok := metadata[key]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	fmt.Fprint(w, value)
+}
+
+// serveEC2 serves the metadata like the EC2 instance metadata service. A
+// session token is required, as with IMDSv2 when its enforced.
+func (s *Server) serveEC2(w http.ResponseWriter, r *http.Request, vm *domain.VM) {
+	if r.URL.Path == imds.TokenPath {
+		s.issueToken(w, r, vm)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.validToken(r.Header.Get(imds.TokenHeader), vm.Name) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	tree, err := imds.New(vm)
+	if err != nil {
+		slog.Error("generating ec2 metadata", "vm", vm.Name, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	value, ok := tree.Get(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	fmt.Fprint(w, value)
+}
+
+// issueToken issues a session token for the vm that's valid for the number of
+// seconds in the ttl header.
+func (s *Server) issueToken(w http.ResponseWriter, r *http.Request, vm *domain.VM) {
+	if r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	// Like EC2, tokens can't be requested through a proxy
+	if r.Header.Get("X-Forwarded-For") != "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	ttl, err := strconv.Atoi(r.Header.Get(imds.TokenTTLHeader))
+	if err != nil || ttl < 1 || ttl > imds.MaxTokenTTL {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		slog.Error("generating metadata token", "vm", vm.Name, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	value := base64.RawURLEncoding.EncodeToString(data)
+
+	s.mu.Lock()
+	now := s.now()
+	oldest, count := "", 0
+	for key, t := range s.tokens {
+		if !now.Before(t.expires) {
+			delete(s.tokens, key)
+			continue
+		}
+		if t.vmName != vm.Name {
+			continue
+		}
+		count++
+		if oldest == "" || t.seq < s.tokens[oldest].seq {
+			oldest = key
+		}
+	}
+	if count >= maxTokensPerVM {
+		delete(s.tokens, oldest)
+	}
+	s.issued++
+	s.tokens[value] = token{vmName: vm.Name, expires: now.Add(time.Duration(ttl) * time.Second), seq: s.issued}
+	s.mu.Unlock()
+
+	w.Header().Set(imds.TokenTTLHeader, strconv.Itoa(ttl))
+	fmt.Fprint(w, value)
+}
+
+// validToken returns true if the token was issued to the vm and hasn't expired.
+func (s *Server) validToken(value, vmName string) bool {
+	if value == "" {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[value]
+
+	return ok && t.vmName == vmName && s.now().Before(t.expires)
+}
+
+// findVM finds the vm that uses the host metadata server with the address on
+// its metadata interface. The host must route the address over the tap device
+// of the interface. The replies to a connection go over the route, so a vm on
+// another tap or on the bridge can't connect using the address of another vm.
+func (s *Server) findVM(address string) (*domain.VM, error) {
+	ss, err := s.newStateService("")
+	if err != nil {
+		return nil, err
+	}
+	vms, err := ss.ListVMs()
+	if err != nil {
+		return nil, fmt.Errorf("listing vms: %w", err)
+	}
+
+	for _, vm := range vms {
+		if vm.Status == nil || vm.Status.MetadataURL == "" {
+			continue
+		}
+
+		for name, netInt := range vm.Spec.NetworkConfiguration.Interfaces {
+			if !netInt.AllowMetadataRequests || netInt.StaticIPv4Address == nil {
+				continue
+			}
+			ip, _, err := net.ParseCIDR(netInt.StaticIPv4Address.Address)
+			if err != nil || ip.String() != address {
+				continue
+			}
+
+			iface, err := s.networkService.RouteInterface(address)
+			if err != nil {
+				return nil, fmt.Errorf("getting the interface routed to %s: %w", address, err)
+			}
+			if tap := vm.Status.NetworkStatus[name].HostDeviveName; tap == "" || iface != tap {
+				slog.Warn("metadata request from an address that isn't routed over the metadata interface", "vm", vm.Name, "address", address, "interface", iface)
+
+				return nil, nil
+			}
+
+			return vm, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (s *Server) getMetadata(vmName string) (map[string]string, error) {
+	ss, err := s.newStateService(vmName)
+	if err != nil {
+		return nil, err
+	}
+
+	return ss.GetMetadata()
+}
@@ -0,0 +1,234 @@
+package metadata
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/mikrolite/mikrolite/adapters/filesystem"
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+	"github.com/mikrolite/mikrolite/defaults"
+	"github.com/mikrolite/mikrolite/imds"
+	"github.com/mikrolite/mikrolite/testing/fakes"
+)
+
+const (
+	testAddress = "169.254.12.34"
+	testMAC     = "02:00:00:00:00:01"
+	testTap     = "mltm0"
+)
+
+func TestServeHTTP(t *testing.T) {
+	vm := testVM()
+	server, network := newTestServer(t, vm, map[string]string{
+		"meta-data": "aW5zdGFuY2VfaWQ6IHZtMQo=",
+		"user-data": "#cloud-config\n",
+	})
+
+	testCases := []struct {
+		name         string
+		method       string
+		path         string
+		remoteAddr   string
+		routedOver   string
+		expectStatus int
+		expectBody   string
+	}{
+		{name: "keys are listed", path: "/latest/", remoteAddr: testAddress, expectStatus: http.StatusOK, expectBody: "meta-data\nuser-data"},
+		{name: "decoded value is served", path: "/latest/meta-data", remoteAddr: testAddress, expectStatus: http.StatusOK, expectBody: "instance_id: vm1\n"},
+		{name: "plain value is served", path: "/latest/user-data", remoteAddr: testAddress, expectStatus: http.StatusOK, expectBody: "#cloud-config\n"},
+		{name: "missing key", path: "/latest/vendor-data", remoteAddr: testAddress, expectStatus: http.StatusNotFound},
+		{name: "outside the seed url", path: "/user-data", remoteAddr: testAddress, expectStatus: http.StatusNotFound},
+		{name: "unknown address", path: "/latest/user-data", remoteAddr: "169.254.99.1", expectStatus: http.StatusForbidden},
+		{name: "address routed over another interface", path: "/latest/user-data", remoteAddr: testAddress, routedOver: "mltm9", expectStatus: http.StatusForbidden},
+		{name: "address routed over the bridge", path: "/latest/user-data", remoteAddr: testAddress, routedOver: "br0", expectStatus: http.StatusForbidden},
+		{name: "only gets are allowed", method: http.MethodPost, path: "/latest/user-data", remoteAddr: testAddress, expectStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			routedOver := testTap
+			if tc.routedOver != "" {
+				routedOver = tc.routedOver
+			}
+			network.Routes = map[string]string{tc.remoteAddr: routedOver}
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			req := httptest.NewRequest(method, "http://"+defaults.MetadataAddress+tc.path, nil)
+			req.RemoteAddr = tc.remoteAddr + ":41000"
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, req)
+
+			if rec.Code != tc.expectStatus {
+				t.Errorf("expected status %d, got %d", tc.expectStatus, rec.Code)
+			}
+			body, _ := io.ReadAll(rec.Body)
+			if tc.expectBody != "" && string(body) != tc.expectBody {
+				t.Errorf("expected body %q, got %q", tc.expectBody, body)
+			}
+		})
+	}
+}
+
+func TestServeEC2(t *testing.T) {
+	vm := testVM()
+	vm.Spec.Bootstrap = &domain.Bootstrap{Datasource: domain.DatasourceEC2}
+	vm.Status.IP = "192.168.122.10"
+	vm.Status.Metadata = map[string]string{
+		imds.PublicKeyKey: base64.StdEncoding.EncodeToString([]byte("ssh-ed25519 AAAA user@host")),
+	}
+	server, network := newTestServer(t, vm, nil)
+	network.Routes = map[string]string{testAddress: testTap}
+	now := time.Now()
+	server.now = func() time.Time { return now }
+
+	do := func(method, path string, header http.Header) (int, string, http.Header) {
+		req := httptest.NewRequest(method, "http://"+defaults.MetadataAddress+path, nil)
+		req.RemoteAddr = testAddress + ":41000"
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		body, _ := io.ReadAll(rec.Body)
+
+		return rec.Code, string(body), rec.Header()
+	}
+	ttlHeader := func(ttl string) http.Header {
+		return http.Header{http.CanonicalHeaderKey(imds.TokenTTLHeader): {ttl}}
+	}
+
+	if code, _, _ := do(http.MethodGet, "/latest/meta-data/instance-id", nil); code != http.StatusUnauthorized {
+		t.Errorf("expected a token to be required, got %d", code)
+	}
+	if code, _, _ := do(http.MethodGet, imds.TokenPath, ttlHeader("60")); code != http.StatusMethodNotAllowed {
+		t.Errorf("expected tokens to be requested with put, got %d", code)
+	}
+	if code, _, _ := do(http.MethodPut, imds.TokenPath, ttlHeader("0")); code != http.StatusBadRequest {
+		t.Errorf("expected an invalid ttl to be rejected, got %d", code)
+	}
+	header := ttlHeader("60")
+	header.Set("X-Forwarded-For", "10.0.0.1")
+	if code, _, _ := do(http.MethodPut, imds.TokenPath, header); code != http.StatusForbidden {
+		t.Errorf("expected a forwarded token request to be rejected, got %d", code)
+	}
+
+	code, token, respHeader := do(http.MethodPut, imds.TokenPath, ttlHeader("60"))
+	if code != http.StatusOK || token == "" || respHeader.Get(imds.TokenTTLHeader) != "60" {
+		t.Fatalf("expected a token, got %d %q %v", code, token, respHeader)
+	}
+	withToken := http.Header{http.CanonicalHeaderKey(imds.TokenHeader): {token}}
+
+	testCases := []struct {
+		path         string
+		expectStatus int
+		expectBody   string
+	}{
+		{path: "/latest/meta-data/instance-id", expectStatus: http.StatusOK, expectBody: "vm1"},
+		{path: "/latest/meta-data/local-ipv4", expectStatus: http.StatusOK, expectBody: "192.168.122.10"},
+		{path: "/latest/meta-data/public-keys/0/openssh-key", expectStatus: http.StatusOK, expectBody: "ssh-ed25519 AAAA user@host"},
+		{path: "/2009-04-04/meta-data/instance-id", expectStatus: http.StatusOK, expectBody: "vm1"},
+		{path: "/latest/user-data", expectStatus: http.StatusNotFound},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			code, body, _ := do(http.MethodGet, tc.path, withToken)
+			if code != tc.expectStatus {
+				t.Errorf("expected status %d, got %d", tc.expectStatus, code)
+			}
+			if tc.expectBody != "" && body != tc.expectBody {
+				t.Errorf("expected body %q, got %q", tc.expectBody, body)
+			}
+		})
+	}
+
+	if code, _, _ := do(http.MethodGet, "/latest/meta-data/instance-id", http.Header{http.CanonicalHeaderKey(imds.TokenHeader): {"invalid"}}); code != http.StatusUnauthorized {
+		t.Errorf("expected an unknown token to be rejected, got %d", code)
+	}
+	now = now.Add(time.Minute)
+	if code, _, _ := do(http.MethodGet, "/latest/meta-data/instance-id", withToken); code != http.StatusUnauthorized {
+		t.Errorf("expected an expired token to be rejected, got %d", code)
+	}
+
+	issued := []string{}
+	for i := 0; i <= maxTokensPerVM; i++ {
+		code, token, _ := do(http.MethodPut, imds.TokenPath, ttlHeader("60"))
+		if code != http.StatusOK {
+			t.Fatalf("expected a token, got %d", code)
+		}
+		issued = append(issued, token)
+	}
+	if len(server.tokens) != maxTokensPerVM {
+		t.Errorf("expected %d live tokens, got %d", maxTokensPerVM, len(server.tokens))
+	}
+	if code, _, _ := do(http.MethodGet, "/latest/meta-data/instance-id", http.Header{http.CanonicalHeaderKey(imds.TokenHeader): {issued[0]}}); code != http.StatusUnauthorized {
+		t.Errorf("expected the oldest token to be dropped, got %d", code)
+	}
+	if code, _, _ := do(http.MethodGet, "/latest/meta-data/instance-id", http.Header{http.CanonicalHeaderKey(imds.TokenHeader): {issued[maxTokensPerVM]}}); code != http.StatusOK {
+		t.Errorf("expected the newest token to be accepted, got %d", code)
+	}
+}
+
+// testVM is a vm that uses the host metadata server.
+func testVM() *domain.VM {
+	return &domain.VM{
+		Name: "vm1",
+		Spec: domain.VMSpec{
+			NetworkConfiguration: domain.NetworkConfiguration{
+				Interfaces: map[string]domain.NetwortInterface{
+					"eth0": {GuestDeviceName: "eth0", AttachToBridge: true},
+					"eth1": {
+						GuestDeviceName:       "eth1",
+						AllowMetadataRequests: true,
+						StaticIPv4Address:     &domain.StaticIPv4Address{Address: testAddress + "/16"},
+					},
+				},
+			},
+		},
+		Status: &domain.VMStatus{
+			MetadataURL: defaults.MetadataURL,
+			NetworkStatus: map[string]domain.NetworkStatus{
+				"eth0": {HostDeviveName: "mlt0", GuestMAC: "02:00:00:00:00:02"},
+				"eth1": {HostDeviveName: testTap, GuestMAC: testMAC},
+			},
+		},
+	}
+}
+
+// newTestServer saves the vm and its metadata to the state and creates the
+// server for it.
+func newTestServer(t *testing.T, vm *domain.VM, metadata map[string]string) (*Server, *fakes.NetworkService) {
+	t.Helper()
+
+	root := t.TempDir()
+	fs := afero.NewOsFs()
+	newStateService := func(vmName string) (ports.StateService, error) {
+		return filesystem.NewStateService(vmName, root, fs)
+	}
+
+	vmState, err := newStateService(vm.Name)
+	if err != nil {
+		t.Fatalf("creating state service: %s", err)
+	}
+	if err := vmState.SaveVM(vm); err != nil {
+		t.Fatalf("saving vm: %s", err)
+	}
+	if metadata != nil {
+		if err := vmState.SaveMetadata(metadata); err != nil {
+			t.Fatalf("saving metadata: %s", err)
+		}
+	}
+
+	network := fakes.NewNetworkService(fakes.NewRecorder())
+
+	return New(newStateService, network), network
+}
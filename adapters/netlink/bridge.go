@@ -51,3 +51,21 @@ func (s *networkService) AttachToBridge(interfaceName string, bridgeName string)
 
 	return nil
 }
+
+// BridgeAddress returns the first ipv4 address of the bridge.
+func (s *networkService) BridgeAddress(name string) (string, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return "", fmt.Errorf("getting bridge %s: %w", name, err)
+	}
+
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+	if err != nil {
+		return "", fmt.Errorf("getting addresses of bridge %s: %w", name, err)
+	}
+	if len(addrs) == 0 {
+		return "", fmt.Errorf("bridge %s doesn't have an ipv4 address", name)
+	}
+
+	return addrs[0].IP.String(), nil
+}
@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"strings"
 
 	"github.com/pterm/pterm"
 	"github.com/vishvananda/netlink"
+
+	"github.com/mikrolite/mikrolite/core/ports"
 )
 
 func (s *networkService) InterfaceCreate(name string, mac string) error {
@@ -55,6 +58,26 @@ func (s *networkService) InterfaceExists(name string) (bool, error) {
 	return linkExists(name, "interface")
 }
 
+func (s *networkService) ListInterfaces(prefix string) ([]string, error) {
+	slog.Debug("Listing network interfaces", "prefix", prefix)
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("listing network links: %w", err)
+	}
+
+	names := []string{}
+	for _, link := range links {
+		// Only taps are returned so that other links with the same prefix are never removed
+		if link.Type() != "tuntap" || !strings.HasPrefix(link.Attrs().Name, prefix) {
+			continue
+		}
+		names = append(names, link.Attrs().Name)
+	}
+
+	return names, nil
+}
+
 func (s *networkService) NewInterfaceName(prefix string) (string, error) {
 	slog.Debug("Generating new network interface name")
 
@@ -76,3 +99,88 @@ func (s *networkService) NewInterfaceName(prefix string) (string, error) {
 		}
 	}
 }
+
+func (s *networkService) InterfaceStats(name string) (*ports.InterfaceStats, error) {
+	slog.Debug("Getting network interface stats", "name", name)
+
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("getting interface %s: %w", name, err)
+	}
+
+	stats := link.Attrs().Statistics
+	if stats == nil {
+		return nil, fmt.Errorf("no statistics for interface %s", name)
+	}
+
+	return &ports.InterfaceStats{
+		RxBytes:   stats.RxBytes,
+		RxPackets: stats.RxPackets,
+		RxErrors:  stats.RxErrors,
+		RxDropped: stats.RxDropped,
+		TxBytes:   stats.TxBytes,
+		TxPackets: stats.TxPackets,
+		TxErrors:  stats.TxErrors,
+		TxDropped: stats.TxDropped,
+	}, nil
+}
+
+func (s *networkService) RouteToGuest(interfaceName string, hostAddress string, guestAddress string) error {
+	hostIP := net.ParseIP(hostAddress)
+	if hostIP == nil {
+		return fmt.Errorf("parsing host address %s", hostAddress)
+	}
+	guestIP := net.ParseIP(guestAddress)
+	if guestIP == nil {
+		return fmt.Errorf("parsing guest address %s", guestAddress)
+	}
+
+	link, err := netlink.LinkByName(interfaceName)
+	if err != nil {
+		return fmt.Errorf("getting interface %s: %w", interfaceName, err)
+	}
+
+	// The host address is on the interface of every vm that uses it, the route
+	// to the guest address makes sure the replies go back over this interface
+	addr := &netlink.Addr{
+		IPNet: &net.IPNet{IP: hostIP, Mask: net.CIDRMask(32, 32)},
+		Scope: int(netlink.SCOPE_LINK),
+	}
+	if err := netlink.AddrReplace(link, addr); err != nil {
+		return fmt.Errorf("adding address %s to interface %s: %w", hostAddress, interfaceName, err)
+	}
+
+	route := &netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Dst:       &net.IPNet{IP: guestIP, Mask: net.CIDRMask(32, 32)},
+		Src:       hostIP,
+		Scope:     netlink.SCOPE_LINK,
+	}
+	if err := netlink.RouteReplace(route); err != nil {
+		return fmt.Errorf("adding route to %s over interface %s: %w", guestAddress, interfaceName, err)
+	}
+
+	return nil
+}
+
+func (s *networkService) RouteInterface(address string) (string, error) {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return "", fmt.Errorf("parsing address %s", address)
+	}
+
+	routes, err := netlink.RouteGet(ip)
+	if err != nil {
+		return "", fmt.Errorf("getting route to %s: %w", address, err)
+	}
+	if len(routes) == 0 {
+		return "", fmt.Errorf("no route to %s", address)
+	}
+
+	link, err := netlink.LinkByIndex(routes[0].LinkIndex)
+	if err != nil {
+		return "", fmt.Errorf("getting interface of the route to %s: %w", address, err)
+	}
+
+	return link.Attrs().Name, nil
+}
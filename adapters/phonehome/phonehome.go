@@ -0,0 +1,135 @@
+// Package phonehome implements the readiness service with an http listener
+// that cloud-init in the vm posts to with its phone_home module.
+package phonehome
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+const (
+	// pathPrefix is the prefix of the path cloud-init posts to, followed by a
+	// token so that only the vm knows the url.
+	pathPrefix = "/phone-home/"
+	// instanceIDField is the form field cloud-init sends the instance id in.
+	instanceIDField = "instance_id"
+)
+
+// New creates a new readiness service.
+func New() ports.ReadinessService {
+	return &readinessService{now: time.Now}
+}
+
+type readinessService struct {
+	now func() time.Time
+}
+
+// Listen listens on a random port of the address. The address must be one the
+// vm can reach, e.g. the address of the bridge its attached to.
+func (s *readinessService) Listen(address string, vmName string) (ports.ReadyListener, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(address, "0"))
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", address, err)
+	}
+
+	l := &readyListener{
+		listener: listener,
+		vmName:   vmName,
+		path:     pathPrefix + token,
+		now:      s.now,
+		ready:    make(chan struct{}),
+	}
+	l.server = &http.Server{
+		Handler:           l,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go l.server.Serve(listener)
+
+	return l, nil
+}
+
+type readyListener struct {
+	listener net.Listener
+	server   *http.Server
+	vmName   string
+	path     string
+	now      func() time.Time
+
+	once    sync.Once
+	ready   chan struct{}
+	readyAt time.Time
+}
+
+func (l *readyListener) URL() string {
+	return fmt.Sprintf("http://%s%s", l.listener.Addr(), l.path)
+}
+
+func (l *readyListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != l.path {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// cloud-init posts the fields as a form, the instance id is the vm name
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if id := r.PostForm.Get(instanceIDField); id != "" && id != l.vmName {
+		slog.Warn("phone home from unexpected instance", "vm", l.vmName, "instance_id", id)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	l.once.Do(func() {
+		l.readyAt = l.now().UTC()
+		close(l.ready)
+	})
+	w.WriteHeader(http.StatusOK)
+}
+
+func (l *readyListener) Wait(ctx context.Context) (time.Time, error) {
+	select {
+	case <-l.ready:
+		return l.readyAt, nil
+	case <-ctx.Done():
+		return time.Time{}, ctx.Err()
+	}
+}
+
+func (l *readyListener) Close() error {
+	err := l.server.Close()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+func newToken() (string, error) {
+	data := make([]byte, 16)
+	if _, err := rand.Read(data); err != nil {
+		return "", fmt.Errorf("creating token: %w", err)
+	}
+
+	return hex.EncodeToString(data), nil
+}
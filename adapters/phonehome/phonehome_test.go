@@ -0,0 +1,64 @@
+package phonehome
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListen(t *testing.T) {
+	readyAt := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	svc := &readinessService{now: func() time.Time { return readyAt }}
+
+	listener, err := svc.Listen("127.0.0.1", "vm1")
+	if err != nil {
+		t.Fatalf("listening: %s", err)
+	}
+	defer listener.Close()
+
+	if !strings.HasPrefix(listener.URL(), "http://127.0.0.1:") || !strings.Contains(listener.URL(), pathPrefix) {
+		t.Fatalf("unexpected url %s", listener.URL())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := listener.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected wait to time out before the vm posts, got %v", err)
+	}
+
+	wrongPath := listener.URL() + "x"
+	post := func(u string, instanceID string) int {
+		resp, err := http.PostForm(u, url.Values{instanceIDField: {instanceID}, "hostname": {"vm1"}})
+		if err != nil {
+			t.Fatalf("posting: %s", err)
+		}
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+	if code := post(wrongPath, "vm1"); code != http.StatusNotFound {
+		t.Errorf("expected 404 for the wrong token, got %d", code)
+	}
+	if code := post(listener.URL(), "vm2"); code != http.StatusForbidden {
+		t.Errorf("expected 403 for another instance, got %d", code)
+	}
+	if code := post(listener.URL(), "vm1"); code != http.StatusOK {
+		t.Errorf("expected 200, got %d", code)
+	}
+	// cloud-init retries so posting again is fine
+	if code := post(listener.URL(), "vm1"); code != http.StatusOK {
+		t.Errorf("expected 200 when posting again, got %d", code)
+	}
+
+	got, err := listener.Wait(context.Background())
+	if err != nil {
+		t.Fatalf("waiting: %s", err)
+	}
+	if !got.Equal(readyAt) {
+		t.Errorf("expected ready at %s, got %s", readyAt, got)
+	}
+}
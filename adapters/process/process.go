@@ -0,0 +1,80 @@
+// Package process implements the process service using procfs.
+package process
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+const (
+	procPath = "/proc"
+
+	bootTimeField = "btime"
+)
+
+// New creates a new process service.
+func New() ports.ProcessService {
+	return &processService{}
+}
+
+type processService struct{}
+
+// Running returns true if the process exists and isn't a zombie. A zombie has
+// exited but hasn't been reaped by its parent yet.
+func (p *processService) Running(pid int) (bool, error) {
+	statFile := filepath.Join(procPath, strconv.Itoa(pid), "stat")
+
+	data, err := os.ReadFile(statFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("reading %s: %w", statFile, err)
+	}
+
+	// The state follows the command name, which is in brackets and can contain spaces
+	end := bytes.LastIndexByte(data, ')')
+	if end == -1 || end+2 >= len(data) {
+		return false, fmt.Errorf("unexpected format of %s", statFile)
+	}
+
+	switch data[end+2] {
+	case 'Z', 'X':
+		return false, nil
+	default:
+		return true, nil
+	}
+}
+
+// BootTime returns the boot time from the btime field of /proc/stat.
+func (p *processService) BootTime() (time.Time, error) {
+	statFile := filepath.Join(procPath, "stat")
+
+	data, err := os.ReadFile(statFile)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("reading %s: %w", statFile, err)
+	}
+
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		fields := bytes.Fields(line)
+		if len(fields) != 2 || string(fields[0]) != bootTimeField {
+			continue
+		}
+
+		seconds, err := strconv.ParseInt(string(fields[1]), 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("parsing %s in %s: %w", bootTimeField, statFile, err)
+		}
+
+		return time.Unix(seconds, 0), nil
+	}
+
+	return time.Time{}, fmt.Errorf("%s not found in %s", bootTimeField, statFile)
+}
@@ -0,0 +1,121 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+const defaultTerm = "xterm-256color"
+
+// Session is a command or shell run in the vm.
+type Session struct {
+	// Command is run by the shell of the user, a login shell is started if its
+	// empty.
+	Command string
+	Stdin   io.Reader
+	Stdout  io.Writer
+	Stderr  io.Writer
+	// TTY is the terminal to allocate a pty for, the pty is resized with the
+	// terminal. A pty isn't allocated if its nil.
+	TTY *os.File
+}
+
+// Run runs the session and waits for it to finish. An ExitError is returned if
+// the command exits with a non-zero status. The session is closed if the context
+// is cancelled.
+func Run(ctx context.Context, client *gossh.Client, s Session) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("creating session: %w", err)
+	}
+	defer session.Close()
+
+	session.Stdin = s.Stdin
+	session.Stdout = s.Stdout
+	session.Stderr = s.Stderr
+
+	if s.TTY != nil {
+		stop, err := requestPTY(session, s.TTY)
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+
+	if s.Command == "" {
+		err = session.Shell()
+	} else {
+		err = session.Start(s.Command)
+	}
+	if err != nil {
+		return fmt.Errorf("starting session: %w", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err = <-done:
+	}
+
+	exitErr := &gossh.ExitError{}
+	if errors.As(err, &exitErr) {
+		return &ExitError{Code: exitErr.ExitStatus()}
+	}
+	if err != nil {
+		return fmt.Errorf("running session: %w", err)
+	}
+
+	return nil
+}
+
+// requestPTY requests a pty the size of the terminal and resizes it when the
+// terminal is resized. The returned func stops watching for resizes.
+func requestPTY(session *gossh.Session, tty *os.File) (func(), error) {
+	fd := int(tty.Fd())
+	width, height, err := term.GetSize(fd)
+	if err != nil {
+		return nil, fmt.Errorf("getting terminal size: %w", err)
+	}
+
+	termName := os.Getenv("TERM")
+	if termName == "" {
+		termName = defaultTerm
+	}
+	if err := session.RequestPty(termName, height, width, gossh.TerminalModes{}); err != nil {
+		return nil, fmt.Errorf("requesting pty: %w", err)
+	}
+
+	resized := make(chan os.Signal, 1)
+	signal.Notify(resized, syscall.SIGWINCH)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-resized:
+				if width, height, err := term.GetSize(fd); err == nil {
+					_ = session.WindowChange(height, width)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(resized)
+		close(done)
+	}, nil
+}
@@ -0,0 +1,261 @@
+// Package ssh connects to the vms with ssh, using the key the vm was created
+// with.
+//
+// The host key of a vm is trusted the first time its seen and recorded in a
+// known hosts file in the state directory of the vm, so that later connections
+// are checked without the user's known_hosts being changed.
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	// DefaultUser is the user created in the vm by the bootstrap user data.
+	DefaultUser = "ml"
+	// DefaultPort is the port sshd listens on in the vm.
+	DefaultPort = 22
+
+	// KnownHostsFile is the name of the known hosts file in the state directory.
+	KnownHostsFile = "known_hosts"
+
+	knownHostsPerm = 0o600
+	dialTimeout    = 5 * time.Second
+	retryInterval  = time.Second
+)
+
+// ErrHostKeyChanged is returned when the host key of the vm isn't the one that
+// was seen when it was first connected to.
+var ErrHostKeyChanged = errors.New("host key has changed")
+
+// ExitError is returned when the command run in the vm exits with a non-zero
+// status.
+type ExitError struct {
+	// Code is the exit status of the command, 128 plus the signal number if it
+	// was killed by a signal.
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("command exited with status %d", e.Code)
+}
+
+// ExitCode returns the exit status of the command.
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
+
+// Options are the options for connecting to a vm.
+type Options struct {
+	// Address is the ip address of the vm.
+	Address string
+	// Port is the port sshd listens on, DefaultPort if it isn't set.
+	Port int
+	// User is the user to log in as, DefaultUser if it isn't set.
+	User string
+	// Signer is the private key to authenticate with.
+	Signer gossh.Signer
+	// KnownHostsPath is the path of the file the host key is recorded in.
+	KnownHostsPath string
+	// Wait is how long to keep trying to connect while sshd isn't reachable,
+	// e.g. because the vm is still booting.
+	Wait time.Duration
+}
+
+// Dial connects to the vm. Failed connections are retried until the wait has
+// passed, as sshd may not be running yet or cloud-init may not have added the
+// authorized key.
+func Dial(ctx context.Context, opts Options) (*gossh.Client, error) {
+	if opts.Port == 0 {
+		opts.Port = DefaultPort
+	}
+	if opts.User == "" {
+		opts.User = DefaultUser
+	}
+
+	checkHostKey, err := knownHostsCallback(opts.KnownHostsPath)
+	if err != nil {
+		return nil, err
+	}
+	// The handshake doesn't wrap the error from the callback, so its kept to
+	// stop retrying when the host key has changed
+	var hostKeyErr error
+
+	config := &gossh.ClientConfig{
+		User: opts.User,
+		Auth: []gossh.AuthMethod{gossh.PublicKeys(opts.Signer)},
+		HostKeyCallback: func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+			hostKeyErr = checkHostKey(hostname, remote, key)
+
+			return hostKeyErr
+		},
+		Timeout: dialTimeout,
+	}
+	address := net.JoinHostPort(opts.Address, fmt.Sprint(opts.Port))
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Wait)
+	defer cancel()
+
+	for {
+		client, err := dial(ctx, address, config)
+		if err == nil {
+			return client, nil
+		}
+		if errors.Is(hostKeyErr, ErrHostKeyChanged) {
+			return nil, hostKeyErr
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("connecting to %s: %w", address, err)
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+func dial(ctx context.Context, address string, config *gossh.ClientConfig) (*gossh.Client, error) {
+	dialer := net.Dialer{Timeout: config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	sshConn, chans, reqs, err := gossh.NewClientConn(conn, address, config)
+	if err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+
+	return gossh.NewClient(sshConn, chans, reqs), nil
+}
+
+// knownHostsCallback checks the host key against the known hosts file. A host
+// that isn't in the file is trusted and added to it. The file is read for each
+// check as the host key may have been added by an earlier attempt to connect.
+func knownHostsCallback(path string) (gossh.HostKeyCallback, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, knownHostsPerm)
+	if err != nil {
+		return nil, fmt.Errorf("creating known hosts %s: %w", path, err)
+	}
+	file.Close()
+
+	return func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+		check, err := knownhosts.New(path)
+		if err != nil {
+			return fmt.Errorf("reading known hosts %s: %w", path, err)
+		}
+
+		err = check(hostname, remote, key)
+		keyErr := &knownhosts.KeyError{}
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+		if len(keyErr.Want) > 0 {
+			return fmt.Errorf("%w for %s, remove it from %s if the vm was rebuilt: %s", ErrHostKeyChanged, hostname, path, err)
+		}
+
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, knownHostsPerm)
+		if err != nil {
+			return fmt.Errorf("opening known hosts %s: %w", path, err)
+		}
+		defer file.Close()
+
+		line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+		if _, err := fmt.Fprintln(file, line); err != nil {
+			return fmt.Errorf("adding host key to %s: %w", path, err)
+		}
+
+		return nil
+	}, nil
+}
+
+// LoadSigner returns the private key for the public key the vm was created
+// with. The private key is read from the public key path without the .pub
+// suffix, if that doesn't match or is protected by a passphrase then the keys
+// in the ssh agent are used.
+func LoadSigner(publicKeyPath string) (gossh.Signer, error) {
+	data, err := os.ReadFile(publicKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading public key %s: %w", publicKeyPath, err)
+	}
+	publicKey, _, _, _, err := gossh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key %s: %w", publicKeyPath, err)
+	}
+
+	privateKeyPath := strings.TrimSuffix(publicKeyPath, ".pub")
+	signer, fileErr := LoadPrivateKey(privateKeyPath)
+	if fileErr == nil && !keysEqual(signer.PublicKey(), publicKey) {
+		fileErr = fmt.Errorf("private key %s doesn't match public key %s", privateKeyPath, publicKeyPath)
+	}
+	if fileErr == nil {
+		return signer, nil
+	}
+
+	signer, err = agentSigner(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w, and from the ssh agent: %s", fileErr, err)
+	}
+
+	return signer, nil
+}
+
+// LoadPrivateKey reads an unencrypted private key.
+func LoadPrivateKey(path string) (gossh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading private key %s: %w", path, err)
+	}
+
+	signer, err := gossh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key %s: %w", path, err)
+	}
+
+	return signer, nil
+}
+
+// agentSigner returns the key from the ssh agent that matches the public key.
+func agentSigner(publicKey gossh.PublicKey) (gossh.Signer, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, errors.New("SSH_AUTH_SOCK isn't set")
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to ssh agent: %w", err)
+	}
+
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		conn.Close()
+
+		return nil, fmt.Errorf("listing ssh agent keys: %w", err)
+	}
+	for _, signer := range signers {
+		// The connection is used to sign with the key, so its left open for the
+		// life of the process
+		if keysEqual(signer.PublicKey(), publicKey) {
+			return signer, nil
+		}
+	}
+	conn.Close()
+
+	return nil, errors.New("the key isn't in the ssh agent")
+}
+
+func keysEqual(a gossh.PublicKey, b gossh.PublicKey) bool {
+	return string(a.Marshal()) == string(b.Marshal())
+}
@@ -0,0 +1,312 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// writeKey writes an ed25519 key pair to the directory and returns the path of
+// the public key.
+func writeKey(t *testing.T, dir string, name string, passphrase string) string {
+	t.Helper()
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %s", err)
+	}
+
+	var block *pem.Block
+	if passphrase == "" {
+		block, err = gossh.MarshalPrivateKey(privateKey, "")
+	} else {
+		block, err = gossh.MarshalPrivateKeyWithPassphrase(privateKey, "", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatalf("marshalling private key: %s", err)
+	}
+	signer, err := gossh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatalf("creating signer: %s", err)
+	}
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatalf("writing private key: %s", err)
+	}
+	if err := os.WriteFile(path+".pub", gossh.MarshalAuthorizedKey(signer.PublicKey()), 0o600); err != nil {
+		t.Fatalf("writing public key: %s", err)
+	}
+
+	return path + ".pub"
+}
+
+func TestLoadSigner(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	dir := t.TempDir()
+	publicKeyPath := writeKey(t, dir, "id_ed25519", "")
+	otherKeyPath := writeKey(t, dir, "other", "")
+	encryptedKeyPath := writeKey(t, dir, "encrypted", "secret")
+
+	signer, err := LoadSigner(publicKeyPath)
+	if err != nil {
+		t.Fatalf("expected the private key to be loaded, got %s", err)
+	}
+	data, _ := os.ReadFile(publicKeyPath)
+	if !bytes.Equal(gossh.MarshalAuthorizedKey(signer.PublicKey()), data) {
+		t.Errorf("expected the key matching %s, got %s", publicKeyPath, gossh.MarshalAuthorizedKey(signer.PublicKey()))
+	}
+
+	// The private key doesn't match the public key
+	if err := os.Rename(otherKeyPath, publicKeyPath); err != nil {
+		t.Fatalf("replacing public key: %s", err)
+	}
+	_, err = LoadSigner(publicKeyPath)
+	if err == nil || !strings.Contains(err.Error(), "doesn't match") {
+		t.Errorf("expected an error for the key that doesn't match, got %v", err)
+	}
+
+	_, err = LoadSigner(encryptedKeyPath)
+	if err == nil || !strings.Contains(err.Error(), "SSH_AUTH_SOCK") {
+		t.Errorf("expected the ssh agent to be needed for the encrypted key, got %v", err)
+	}
+}
+
+// testServer is an ssh server that runs the commands handled by handleSession.
+type testServer struct {
+	listener net.Listener
+	config   *gossh.ServerConfig
+}
+
+// newTestServer creates a server with a new host key that accepts the public
+// key, it isn't started until listen is called.
+func newTestServer(t *testing.T, publicKey gossh.PublicKey) *testServer {
+	t.Helper()
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating host key: %s", err)
+	}
+	hostSigner, err := gossh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatalf("creating host key signer: %s", err)
+	}
+
+	config := &gossh.ServerConfig{
+		PublicKeyCallback: func(conn gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+			if conn.User() != DefaultUser || !keysEqual(key, publicKey) {
+				return nil, errors.New("unauthorized")
+			}
+
+			return &gossh.Permissions{}, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	return &testServer{config: config}
+}
+
+// listen starts serving on the address.
+func (s *testServer) listen(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	go s.serve()
+
+	return nil
+}
+
+func (s *testServer) close() {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+}
+
+func (s *testServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *testServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			_, chans, reqs, err := gossh.NewServerConn(conn, s.config)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			go gossh.DiscardRequests(reqs)
+
+			for newChannel := range chans {
+				channel, requests, err := newChannel.Accept()
+				if err != nil {
+					continue
+				}
+				go handleSession(channel, requests)
+			}
+		}()
+	}
+}
+
+// handleSession runs the exec requests: echo writes its args to stdout, fail
+// writes to stderr and exits with the status in its args.
+func handleSession(channel gossh.Channel, requests <-chan *gossh.Request) {
+	defer channel.Close()
+
+	for req := range requests {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+
+		command := string(req.Payload[4:])
+		status := 0
+		name, args, _ := strings.Cut(command, " ")
+		switch name {
+		case "echo":
+			fmt.Fprintln(channel, args)
+		case "fail":
+			fmt.Fprintln(channel.Stderr(), "failed")
+			fmt.Sscan(args, &status)
+		}
+
+		payload := make([]byte, 4)
+		binary.BigEndian.PutUint32(payload, uint32(status))
+		channel.SendRequest("exit-status", false, payload)
+
+		return
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	signer, err := LoadPrivateKey(strings.TrimSuffix(writeKey(t, dir, "id_ed25519", ""), ".pub"))
+	if err != nil {
+		t.Fatalf("loading key: %s", err)
+	}
+	server := newTestServer(t, signer.PublicKey())
+	if err := server.listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("listening: %s", err)
+	}
+	defer server.close()
+
+	opts := Options{
+		Address:        "127.0.0.1",
+		Port:           server.port(),
+		Signer:         signer,
+		KnownHostsPath: filepath.Join(dir, KnownHostsFile),
+		Wait:           5 * time.Second,
+	}
+	client, err := Dial(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("dialing: %s", err)
+	}
+	defer client.Close()
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	err = Run(context.Background(), client, Session{Command: "echo hello world", Stdout: stdout, Stderr: stderr})
+	if err != nil {
+		t.Fatalf("expected the command to succeed, got %s", err)
+	}
+	if stdout.String() != "hello world\n" {
+		t.Errorf("expected the output of the command, got %q", stdout.String())
+	}
+
+	stdout.Reset()
+	err = Run(context.Background(), client, Session{Command: "fail 3", Stdout: stdout, Stderr: stderr})
+	exitErr := &ExitError{}
+	if !errors.As(err, &exitErr) || exitErr.Code != 3 {
+		t.Errorf("expected exit status 3, got %v", err)
+	}
+	if stderr.String() != "failed\n" {
+		t.Errorf("expected the stderr of the command, got %q", stderr.String())
+	}
+
+	knownHosts, err := os.ReadFile(opts.KnownHostsPath)
+	if err != nil {
+		t.Fatalf("reading known hosts: %s", err)
+	}
+	if !strings.HasPrefix(string(knownHosts), fmt.Sprintf("[127.0.0.1]:%d ssh-ed25519 ", server.port())) {
+		t.Errorf("expected the host key to be recorded, got %q", knownHosts)
+	}
+
+	// The same host and port with a different host key
+	client.Close()
+	server.close()
+	rebuilt := newTestServer(t, signer.PublicKey())
+	if err := rebuilt.listen(fmt.Sprintf("127.0.0.1:%d", opts.Port)); err != nil {
+		t.Fatalf("listening: %s", err)
+	}
+	defer rebuilt.close()
+	_, err = Dial(context.Background(), opts)
+	if !errors.Is(err, ErrHostKeyChanged) {
+		t.Errorf("expected the changed host key to be rejected, got %v", err)
+	}
+}
+
+func TestDialWaitsForServer(t *testing.T) {
+	dir := t.TempDir()
+	signer, err := LoadPrivateKey(strings.TrimSuffix(writeKey(t, dir, "id_ed25519", ""), ".pub"))
+	if err != nil {
+		t.Fatalf("loading key: %s", err)
+	}
+
+	// Find a free port for the server to be started on later
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %s", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	opts := Options{
+		Address:        "127.0.0.1",
+		Port:           port,
+		Signer:         signer,
+		KnownHostsPath: filepath.Join(dir, KnownHostsFile),
+		Wait:           time.Second,
+	}
+	if _, err := Dial(context.Background(), opts); err == nil {
+		t.Fatal("expected dialing to fail when the server isn't running")
+	}
+
+	server := newTestServer(t, signer.PublicKey())
+	listenErr := make(chan error, 1)
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		listenErr <- server.listen(fmt.Sprintf("127.0.0.1:%d", port))
+	}()
+	defer server.close()
+
+	opts.Wait = 10 * time.Second
+	client, err := Dial(context.Background(), opts)
+	if err := <-listenErr; err != nil {
+		t.Fatalf("listening: %s", err)
+	}
+	if err != nil {
+		t.Fatalf("expected dialing to wait for the server, got %s", err)
+	}
+	client.Close()
+}
@@ -0,0 +1,237 @@
+// Package systemd implements the autostart service by generating a systemd
+// service for each vm. The services are grouped by a target so that all the vms
+// can be started and stopped together.
+package systemd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/afero"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+const (
+	// TargetName is the name of the target that groups the vm services.
+	TargetName = "mikrolite-vms.target"
+
+	unitPrefix = "mikrolite-vm-"
+	unitPerm   = 0o644
+
+	// restartLimitInterval is the interval that the max retries of the restart
+	// policy applies to, it matches how long a vm has to run for the daemon to
+	// reset its restarts.
+	restartLimitInterval = "10min"
+	// stopTimeout is longer than mikrolite waits before killing the vm process,
+	// so that the vm is stopped by mikrolite rather than systemd.
+	stopTimeout = "45s"
+)
+
+// validUnitName matches the characters systemd allows in a unit name.
+var validUnitName = regexp.MustCompile(`^[a-zA-Z0-9:_.\\-]+$`)
+
+// Systemctl runs systemctl with the args.
+type Systemctl func(ctx context.Context, args ...string) error
+
+// ExecSystemctl runs the systemctl binary.
+func ExecSystemctl(ctx context.Context, args ...string) error {
+	out, err := exec.CommandContext(ctx, "systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("running systemctl %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// New creates the autostart service. The units are written to unitPath and each
+// vm is started by running command with the name of the vm appended.
+func New(unitPath string, command []string, fs afero.Fs, systemctl Systemctl) ports.AutostartService {
+	return &autostartService{
+		unitPath:  unitPath,
+		command:   command,
+		fs:        fs,
+		systemctl: systemctl,
+	}
+}
+
+type autostartService struct {
+	unitPath  string
+	command   []string
+	fs        afero.Fs
+	systemctl Systemctl
+}
+
+// Enable writes the units for the vm and enables them. The vm service is
+// started now as well, if the vm is already running the service takes it over.
+func (s *autostartService) Enable(ctx context.Context, vm *domain.VM) error {
+	unit, err := RenderVMUnit(vm, s.command)
+	if err != nil {
+		return err
+	}
+
+	if err := s.fs.MkdirAll(s.unitPath, 0o755); err != nil {
+		return fmt.Errorf("creating unit directory %s: %w", s.unitPath, err)
+	}
+	if err := s.writeUnit(TargetName, RenderTarget()); err != nil {
+		return err
+	}
+	if err := s.writeUnit(UnitName(vm.Name), unit); err != nil {
+		return err
+	}
+
+	if err := s.systemctl(ctx, "daemon-reload"); err != nil {
+		return err
+	}
+	if err := s.systemctl(ctx, "enable", TargetName); err != nil {
+		return err
+	}
+
+	return s.systemctl(ctx, "enable", "--now", UnitName(vm.Name))
+}
+
+// Disable stops and disables the vm service and removes its unit. The target is
+// left as other vms may use it.
+func (s *autostartService) Disable(ctx context.Context, name string) error {
+	unitFile := filepath.Join(s.unitPath, UnitName(name))
+
+	exists, err := afero.Exists(s.fs, unitFile)
+	if err != nil {
+		return fmt.Errorf("checking if unit %s exists: %w", unitFile, err)
+	}
+	if !exists {
+		return nil
+	}
+
+	if err := s.systemctl(ctx, "disable", "--now", UnitName(name)); err != nil {
+		return err
+	}
+	if err := s.fs.Remove(unitFile); err != nil {
+		return fmt.Errorf("removing unit %s: %w", unitFile, err)
+	}
+
+	return s.systemctl(ctx, "daemon-reload")
+}
+
+func (s *autostartService) writeUnit(name string, content string) error {
+	unitFile := filepath.Join(s.unitPath, name)
+
+	if err := afero.WriteFile(s.fs, unitFile, []byte(content), unitPerm); err != nil {
+		return fmt.Errorf("writing unit %s: %w", unitFile, err)
+	}
+
+	return nil
+}
+
+// UnitName returns the name of the service for the vm.
+func UnitName(name string) string {
+	return fmt.Sprintf("%s%s.service", unitPrefix, name)
+}
+
+var vmUnitTemplate = template.Must(template.New("vm").Parse(`# Generated by mikrolite, changes will be overwritten
+[Unit]
+Description=mikrolite vm {{ .Name }}
+After=containerd.service network-online.target
+Wants=containerd.service network-online.target
+PartOf={{ .Target }}
+StartLimitIntervalSec={{ .StartLimitInterval }}
+{{- if .StartLimitBurst }}
+StartLimitBurst={{ .StartLimitBurst }}
+{{- end }}
+
+[Service]
+Type=simple
+ExecStart={{ .ExecStart }}
+Restart={{ .Restart }}
+RestartSec={{ .RestartSec }}
+KillMode=mixed
+TimeoutStopSec={{ .StopTimeout }}
+
+[Install]
+WantedBy={{ .Target }}
+`))
+
+// RenderVMUnit renders the service for the vm. The restart policy of the vm is
+// mapped to the systemd restart settings.
+func RenderVMUnit(vm *domain.VM, command []string) (string, error) {
+	if !validUnitName.MatchString(vm.Name) {
+		return "", fmt.Errorf("vm name %q can't be used in a systemd unit name", vm.Name)
+	}
+
+	data := struct {
+		Name               string
+		Target             string
+		ExecStart          string
+		Restart            string
+		RestartSec         int
+		StartLimitInterval string
+		StartLimitBurst    int
+		StopTimeout        string
+	}{
+		Name:               vm.Name,
+		Target:             TargetName,
+		ExecStart:          execStart(append(append([]string{}, command...), vm.Name)),
+		Restart:            "no",
+		RestartSec:         1,
+		StartLimitInterval: "0",
+		StopTimeout:        stopTimeout,
+	}
+
+	if policy := vm.Spec.RestartPolicy; policy != nil {
+		switch policy.Policy {
+		case domain.RestartPolicyAlways:
+			data.Restart = "always"
+		case domain.RestartPolicyOnFailure:
+			data.Restart = "on-failure"
+			if policy.MaxRetries > 0 {
+				// The first start counts towards the limit
+				data.StartLimitInterval = restartLimitInterval
+				data.StartLimitBurst = policy.MaxRetries + 1
+			}
+		}
+		if policy.BackoffSeconds > 0 {
+			data.RestartSec = policy.BackoffSeconds
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := vmUnitTemplate.Execute(buf, data); err != nil {
+		return "", fmt.Errorf("rendering unit for vm %s: %w", vm.Name, err)
+	}
+
+	return buf.String(), nil
+}
+
+// RenderTarget renders the target that groups the vm services.
+func RenderTarget() string {
+	return `# Generated by mikrolite, changes will be overwritten
+[Unit]
+Description=mikrolite vms
+After=containerd.service network-online.target
+
+[Install]
+WantedBy=multi-user.target
+`
+}
+
+// execStart quotes the args so that systemd splits them as expected and
+// escapes the specifiers and variables that systemd would expand.
+func execStart(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		arg = strings.NewReplacer("%", "%%", "$", "$$").Replace(arg)
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\;") {
+			arg = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(arg) + `"`
+		}
+		quoted = append(quoted, arg)
+	}
+
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,142 @@
+package systemd
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+const testUnitPath = "/etc/systemd/system"
+
+var testCommand = []string{"/usr/local/bin/mikrolite", "vm", "start", "--foreground", "--direct", "--state-path", "/var/lib/mikrolite"}
+
+func TestRenderVMUnit(t *testing.T) {
+	testCases := []struct {
+		name     string
+		policy   *domain.RestartPolicy
+		expected []string
+		absent   []string
+	}{
+		{
+			name: "no restart policy",
+			expected: []string{
+				"ExecStart=/usr/local/bin/mikrolite vm start --foreground --direct --state-path /var/lib/mikrolite vm1\n",
+				"Restart=no\n",
+				"StartLimitIntervalSec=0\n",
+				"After=containerd.service network-online.target\n",
+				"PartOf=mikrolite-vms.target\n",
+				"WantedBy=mikrolite-vms.target\n",
+			},
+			absent: []string{"StartLimitBurst="},
+		},
+		{
+			name:     "always",
+			policy:   &domain.RestartPolicy{Policy: domain.RestartPolicyAlways, BackoffSeconds: 5},
+			expected: []string{"Restart=always\n", "RestartSec=5\n"},
+		},
+		{
+			name:     "on-failure with max retries",
+			policy:   &domain.RestartPolicy{Policy: domain.RestartPolicyOnFailure, MaxRetries: 3},
+			expected: []string{"Restart=on-failure\n", "RestartSec=1\n", "StartLimitIntervalSec=10min\n", "StartLimitBurst=4\n"},
+		},
+		{
+			name:     "on-failure without max retries",
+			policy:   &domain.RestartPolicy{Policy: domain.RestartPolicyOnFailure},
+			expected: []string{"Restart=on-failure\n", "StartLimitIntervalSec=0\n"},
+			absent:   []string{"StartLimitBurst="},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			vm := &domain.VM{Name: "vm1", Spec: domain.VMSpec{RestartPolicy: tc.policy}}
+
+			unit, err := RenderVMUnit(vm, testCommand)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			for _, expected := range tc.expected {
+				if !strings.Contains(unit, expected) {
+					t.Errorf("expected unit to contain %q, got:\n%s", expected, unit)
+				}
+			}
+			for _, absent := range tc.absent {
+				if strings.Contains(unit, absent) {
+					t.Errorf("expected unit not to contain %q, got:\n%s", absent, unit)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderVMUnitInvalidName(t *testing.T) {
+	vm := &domain.VM{Name: "my vm"}
+
+	if _, err := RenderVMUnit(vm, testCommand); err == nil {
+		t.Errorf("expected an error for a name with a space")
+	}
+}
+
+func TestExecStart(t *testing.T) {
+	actual := execStart([]string{"/bin/mikrolite", "--state-path", "/var/lib/my state", "100%", "$HOME", `a"b`, ""})
+	expected := `/bin/mikrolite --state-path "/var/lib/my state" 100%% $$HOME "a\"b" ""`
+
+	if actual != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+}
+
+func TestEnableDisable(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	calls := []string{}
+	systemctl := func(ctx context.Context, args ...string) error {
+		calls = append(calls, strings.Join(args, " "))
+		return nil
+	}
+	svc := New(testUnitPath, testCommand, fs, systemctl)
+
+	vm := &domain.VM{Name: "vm1"}
+	if err := svc.Enable(context.Background(), vm); err != nil {
+		t.Fatalf("enabling vm: %s", err)
+	}
+
+	for _, name := range []string{TargetName, "mikrolite-vm-vm1.service"} {
+		if exists, _ := afero.Exists(fs, filepath.Join(testUnitPath, name)); !exists {
+			t.Errorf("expected unit %s to be written", name)
+		}
+	}
+	assertCalls(t, []string{"daemon-reload", "enable mikrolite-vms.target", "enable --now mikrolite-vm-vm1.service"}, calls)
+
+	calls = []string{}
+	if err := svc.Disable(context.Background(), "vm1"); err != nil {
+		t.Fatalf("disabling vm: %s", err)
+	}
+
+	if exists, _ := afero.Exists(fs, filepath.Join(testUnitPath, "mikrolite-vm-vm1.service")); exists {
+		t.Errorf("expected vm unit to be removed")
+	}
+	if exists, _ := afero.Exists(fs, filepath.Join(testUnitPath, TargetName)); !exists {
+		t.Errorf("expected target to be kept")
+	}
+	assertCalls(t, []string{"disable --now mikrolite-vm-vm1.service", "daemon-reload"}, calls)
+
+	calls = []string{}
+	if err := svc.Disable(context.Background(), "vm1"); err != nil {
+		t.Fatalf("disabling vm again: %s", err)
+	}
+	assertCalls(t, []string{}, calls)
+}
+
+func assertCalls(t *testing.T, expected []string, actual []string) {
+	t.Helper()
+
+	if strings.Join(expected, ",") != strings.Join(actual, ",") {
+		t.Errorf("expected systemctl calls %v, got %v", expected, actual)
+	}
+}
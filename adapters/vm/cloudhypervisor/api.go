@@ -0,0 +1,90 @@
+package cloudhypervisor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+const (
+	apiBaseURL = "http://localhost/api/v1"
+)
+
+type diskConfig struct {
+	Path     string `json:"path"`
+	ID       string `json:"id,omitempty"`
+	Readonly bool   `json:"readonly,omitempty"`
+}
+
+type deviceConfig struct {
+	ID string `json:"id"`
+}
+
+// apiPut will call an endpoint of the cloud hypervisor api with the body encoded as json.
+func (f *provider) apiPut(ctx context.Context, endpoint string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("marshalling request body: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/%s", apiBaseURL, endpoint)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("creating request for %s: %w", endpoint, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return f.apiDo(req, endpoint, nil)
+}
+
+// apiGet will call an endpoint of the cloud hypervisor api and decode the json
+// response into out.
+func (f *provider) apiGet(ctx context.Context, endpoint string, out interface{}) error {
+	url := fmt.Sprintf("%s/%s", apiBaseURL, endpoint)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("creating request for %s: %w", endpoint, err)
+	}
+
+	return f.apiDo(req, endpoint, out)
+}
+
+func (f *provider) apiDo(req *http.Request, endpoint string, out interface{}) error {
+	resp, err := f.apiClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("calling %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(resp.Body)
+
+		return fmt.Errorf("calling %s: unexpected status %d: %s", endpoint, resp.StatusCode, string(respBody))
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("decoding response from %s: %w", endpoint, err)
+		}
+	}
+
+	return nil
+}
+
+func (f *provider) apiClient() *http.Client {
+	socketPath := f.socketPath()
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				dialer := net.Dialer{}
+
+				return dialer.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+}
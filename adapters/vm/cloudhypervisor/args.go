@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 
 	"github.com/mikrolite/mikrolite/adapters/vm/shared"
+	"github.com/mikrolite/mikrolite/cloudinit"
 	"github.com/mikrolite/mikrolite/core/domain"
 )
 
@@ -17,17 +18,27 @@ func (p *provider) buildArgs(vm *domain.VM, cloudInitFile string) ([]string, err
 		"--api-socket",
 		socketPath,
 		"--log-file",
-		p.ss.LogPath(),
+		shared.LogFIFOPath(p.ss),
 		"-v",
 	}
 
 	// Kernel and cmdline args
-	if len(vm.Spec.Kernel.CmdLine) == 0 {
-		vm.Spec.Kernel.CmdLine = defaultKernelCmdLine()
+	if len(vm.Spec.Kernel.CmdLine.Args) == 0 {
+		vm.Spec.Kernel.CmdLine.Args = defaultKernelArgs()
+	}
+	if vm.Status.MetadataURL != "" {
+		if err := shared.AddMetadataKernelArgs(vm, &vm.Spec.Kernel.CmdLine, vm.Status.MetadataURL); err != nil {
+			return nil, err
+		}
+	} else if err := shared.AddConfigDriveKernelArgs(vm, &vm.Spec.Kernel.CmdLine); err != nil {
+		return nil, err
 	}
 
-	args = append(args, "--cmdline", shared.FormatKernelCmdLine(vm.Spec.Kernel.CmdLine))
+	args = append(args, "--cmdline", vm.Spec.Kernel.CmdLine.String())
 	args = append(args, "--kernel", kernelPath)
+	if initrdPath := shared.InitrdPath(vm); initrdPath != "" {
+		args = append(args, "--initramfs", initrdPath)
+	}
 
 	// CPU and memory
 	args = append(args, "--cpus", fmt.Sprintf("boot=%d", vm.Spec.VCPU))
@@ -38,14 +49,16 @@ func (p *provider) buildArgs(vm *domain.VM, cloudInitFile string) ([]string, err
 	if !volumeStatusFound {
 		return nil, errors.New("root volume not found")
 	}
-	args = append(args, "--disk", fmt.Sprintf("path=%s", rootVolumeStatus.Location))
-	args = append(args, fmt.Sprintf("path=%s,readonly=on", cloudInitFile))
+	args = append(args, "--disk", fmt.Sprintf("path=%s,id=%s", rootVolumeStatus.Location, vm.Spec.RootVolume.Name))
+	if cloudInitFile != "" {
+		args = append(args, fmt.Sprintf("path=%s,readonly=on,id=%s", cloudInitFile, cloudinit.VolumeName))
+	}
 
 	for id, vol := range vm.Status.VolumeMounts {
-		if id == "root" {
+		if id == vm.Spec.RootVolume.Name {
 			continue
 		}
-		args = append(args, fmt.Sprintf("path=%s", vol.Location))
+		args = append(args, fmt.Sprintf("path=%s,id=%s", vol.Location, id))
 	}
 
 	// Network interfaces
@@ -60,6 +73,12 @@ func (p *provider) buildArgs(vm *domain.VM, cloudInitFile string) ([]string, err
 
 	}
 
+	vsock, err := shared.PrepareVSock(vm, p.ss)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, "--vsock", fmt.Sprintf("cid=%d,socket=%s", vsock.CID, vsock.Socket))
+
 	return args, nil
 
 }
@@ -68,13 +87,14 @@ func (f *provider) socketPath() string {
 	return filepath.Join(f.ss.Root(), "cloudhypervisor.sock")
 }
 
-func defaultKernelCmdLine() map[string]string {
-	return map[string]string{
-		"console": "hvc0",
-		"root":    "/dev/vda",
-		"rw":      "",
-		"reboot":  "k",
-		"panic":   "1",
-		"ds":      "nocloud",
+// defaultKernelArgs are the kernel args used if the spec doesn't have any.
+func defaultKernelArgs() []domain.KernelArg {
+	return []domain.KernelArg{
+		{Key: "console", Value: "hvc0"},
+		{Key: "root", Value: "/dev/vda"},
+		{Key: "rw"},
+		{Key: "reboot", Value: "k"},
+		{Key: "panic", Value: "1"},
+		{Key: "ds", Value: "nocloud"},
 	}
 }
@@ -1,7 +1,6 @@
 package cloudhypervisor
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"log/slog"
@@ -38,9 +37,14 @@ type provider struct {
 
 func (f *provider) Create(ctx context.Context, vm *domain.VM) (string, error) {
 
-	cloudInitFile, err := shared.CreateCloudInitImage(ctx, true, vm, f.ss, f.ds)
-	if err != nil {
-		return "", fmt.Errorf("creating cloud-init disk image: %w", err)
+	// The metadata is served by mikrolite on the host if the vm has a url
+	cloudInitFile := ""
+	if vm.Status.MetadataURL == "" {
+		var err error
+		cloudInitFile, err = shared.CreateBootstrapImage(ctx, true, vm, f.ss, f.ds)
+		if err != nil {
+			return "", fmt.Errorf("creating bootstrap disk image: %w", err)
+		}
 	}
 
 	args, err := f.buildArgs(vm, cloudInitFile)
@@ -50,10 +54,15 @@ func (f *provider) Create(ctx context.Context, vm *domain.VM) (string, error) {
 
 	cmd := exec.Command(f.binaryPath, args...)
 
-	stdOutFile, err := f.fs.OpenFile(f.ss.StdoutPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, defaults.DataFilePerm)
+	if err := shared.RotateLogs(f.ss); err != nil {
+		return "", err
+	}
+
+	tty, err := shared.StartConsole(vm, f.ss)
 	if err != nil {
-		return "", fmt.Errorf("opening stdout file %s: %w", f.ss.StdoutPath(), err)
+		return "", err
 	}
+	defer tty.Close()
 
 	stdErrFile, err := f.fs.OpenFile(f.ss.StderrPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, defaults.DataFilePerm)
 	if err != nil {
@@ -61,12 +70,18 @@ func (f *provider) Create(ctx context.Context, vm *domain.VM) (string, error) {
 	}
 
 	cmd.Stderr = stdErrFile
-	cmd.Stdout = stdOutFile
-	cmd.Stdin = &bytes.Buffer{}
+	cmd.Stdout = tty
+	cmd.Stdin = tty
+
+	// Remove the socket left behind if the vm is being restarted
+	if err := shared.RemoveStaleSocket(f.socketPath()); err != nil {
+		return "", err
+	}
 
 	if startErr := cmd.Start(); startErr != nil {
-		return "", fmt.Errorf("starting cloudhypervisor: %w", err)
+		return "", fmt.Errorf("starting cloudhypervisor: %w", startErr)
 	}
+	shared.RecordExit(f.ss, cmd.Wait)
 
 	// Save the pid
 	if err := f.ss.SavePID(cmd.Process.Pid); err != nil {
@@ -120,6 +135,59 @@ func (f *provider) Delete(ctx context.Context, name string) error {
 	return nil
 }
 
-func (f *provider) HasMetadataService() bool {
-	return false
+func (f *provider) AttachVolume(ctx context.Context, vm *domain.VM, volumeName string) error {
+	slog.Debug("attaching volume to cloud hypervisor vm", "name", vm.Name, "volume", volumeName)
+
+	mount, ok := vm.Status.VolumeMounts[volumeName]
+	if !ok {
+		return fmt.Errorf("no mount found for volume %s", volumeName)
+	}
+
+	disk := diskConfig{
+		Path: mount.Location,
+		ID:   volumeName,
+	}
+	if err := f.apiPut(ctx, "vm.add-disk", disk); err != nil {
+		return fmt.Errorf("adding disk %s: %w", volumeName, err)
+	}
+
+	if vm.Status.VolumeSlots == nil {
+		vm.Status.VolumeSlots = map[string]string{}
+	}
+	vm.Status.VolumeSlots[volumeName] = volumeName
+
+	return nil
+}
+
+func (f *provider) DetachVolume(ctx context.Context, vm *domain.VM, volumeName string) error {
+	slog.Debug("detaching volume from cloud hypervisor vm", "name", vm.Name, "volume", volumeName)
+
+	deviceID := volumeName
+	if slot, ok := vm.Status.VolumeSlots[volumeName]; ok {
+		deviceID = slot
+	}
+
+	if err := f.apiPut(ctx, "vm.remove-device", deviceConfig{ID: deviceID}); err != nil {
+		return fmt.Errorf("removing device %s: %w", deviceID, err)
+	}
+
+	delete(vm.Status.VolumeSlots, volumeName)
+
+	return nil
+}
+
+func (f *provider) Capabilities() ports.Capabilities {
+	return ports.Capabilities{
+		HotplugDisk: true,
+		Vsock:       true,
+		Metrics:     true,
+		DiskFeatures: []ports.DiskFeature{
+			ports.DiskFeatureRawFile,
+			ports.DiskFeatureBlockDevice,
+			ports.DiskFeatureReadOnly,
+		},
+		NetworkFeatures: []ports.NetworkFeature{
+			ports.NetworkFeatureTap,
+		},
+	}
 }
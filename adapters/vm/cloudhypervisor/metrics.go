@@ -0,0 +1,40 @@
+package cloudhypervisor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+// Metrics returns the counters of the vm devices, such as the bytes read by
+// each disk. The counters are keyed by the device id, e.g. _disk0.
+func (f *provider) Metrics(ctx context.Context, vm *domain.VM) ([]domain.Metric, error) {
+	counters := map[string]map[string]uint64{}
+	if err := f.apiGet(ctx, "vm.counters", &counters); err != nil {
+		return nil, fmt.Errorf("getting counters: %w", err)
+	}
+
+	metrics := []domain.Metric{}
+	for device, deviceCounters := range counters {
+		for name, value := range deviceCounters {
+			metrics = append(metrics, domain.Metric{
+				Name:   "cloud_hypervisor_" + name,
+				Type:   domain.MetricTypeCounter,
+				Labels: map[string]string{"device": device},
+				Value:  float64(value),
+			})
+		}
+	}
+
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].Name != metrics[j].Name {
+			return metrics[i].Name < metrics[j].Name
+		}
+
+		return metrics[i].Labels["device"] < metrics[j].Labels["device"]
+	})
+
+	return metrics, nil
+}
@@ -7,10 +7,10 @@ import (
 	"path/filepath"
 
 	"github.com/mikrolite/mikrolite/adapters/vm/shared"
-	"github.com/mikrolite/mikrolite/cloudinit"
 
 	sdk "github.com/firecracker-microvm/firecracker-go-sdk"
 	"github.com/firecracker-microvm/firecracker-go-sdk/client/models"
+	"github.com/mikrolite/mikrolite/cloudinit"
 	"github.com/mikrolite/mikrolite/core/domain"
 	"github.com/mikrolite/mikrolite/defaults"
 )
@@ -20,33 +20,46 @@ func (f *Provider) Create(ctx context.Context, vm *domain.VM) (string, error) {
 	socketPath := f.socketPath()
 	kernelPath := filepath.Join(vm.Status.KernelMount.Location, vm.Spec.Kernel.Source.Filename)
 	//networkCfgPath := fmt.Sprintf("%s/fcnet.conflist", f.ss.Root())
-	if len(vm.Spec.Kernel.CmdLine) == 0 {
-		vm.Spec.Kernel.CmdLine = defaultKernelCmdLine()
+	if len(vm.Spec.Kernel.CmdLine.Args) == 0 {
+		vm.Spec.Kernel.CmdLine.Args = defaultKernelArgs()
 	}
 
-	if err := f.ensureLogPath(); err != nil {
-		return "", fmt.Errorf("ensuring log file is created: %w", err)
+	if err := shared.RotateLogs(f.ss); err != nil {
+		return "", err
 	}
 
-	stdOutFile, err := f.fs.OpenFile(f.ss.StdoutPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, defaults.DataFilePerm)
+	metricsPipe := f.metricsPipe()
+	tty, err := shared.StartConsole(vm, f.ss, metricsPipe)
 	if err != nil {
-		return "", fmt.Errorf("opening stdout file %s: %w", f.ss.StdoutPath(), err)
+		return "", err
 	}
+	defer tty.Close()
 
 	stdErrFile, err := f.fs.OpenFile(f.ss.StderrPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, defaults.DataFilePerm)
 	if err != nil {
 		return "", fmt.Errorf("opening sterr file %s: %w", f.ss.StderrPath(), err)
 	}
 
-	metadataFile := ""
-	if len(vm.Status.Metadata) > 0 {
+	// The metadata is in the MMDS unless the vm reads it from a config drive
+	metadataFile, configDriveFile := "", ""
+	if len(vm.Status.Metadata) > 0 && vm.Status.MetadataURL != "" {
 		metadataFile, err = f.saveMetadata(vm)
 		if err != nil {
 			return "", fmt.Errorf("saving metadata to file: %w", err)
 		}
 
-		vm.Spec.Kernel.CmdLine["ds"] = "nocloud-net;s=http://169.254.169.254/latest/"
-		vm.Spec.Kernel.CmdLine[cloudinit.NetworkConfigDataKey] = vm.Status.Metadata[cloudinit.NetworkConfigDataKey]
+		if err := shared.AddMetadataKernelArgs(vm, &vm.Spec.Kernel.CmdLine, defaults.MetadataURL); err != nil {
+			return "", err
+		}
+	} else if len(vm.Status.Metadata) > 0 {
+		configDriveFile, err = shared.CreateBootstrapImage(ctx, true, vm, f.ss, f.ds)
+		if err != nil {
+			return "", fmt.Errorf("creating bootstrap disk image: %w", err)
+		}
+
+		if err := shared.AddConfigDriveKernelArgs(vm, &vm.Spec.Kernel.CmdLine); err != nil {
+			return "", err
+		}
 	}
 
 	//f.writeNetworkConfig(networkCfgPath, "fcnet")
@@ -56,32 +69,24 @@ func (f *Provider) Create(ctx context.Context, vm *domain.VM) (string, error) {
 		//NetNS:           vm.Status.NetworkNamespace,
 		SocketPath:      socketPath,
 		KernelImagePath: kernelPath,
-		KernelArgs:      shared.FormatKernelCmdLine(vm.Spec.Kernel.CmdLine),
+		KernelArgs:      vm.Spec.Kernel.CmdLine.String(),
+		InitrdPath:      shared.InitrdPath(vm),
 		MachineCfg: models.MachineConfiguration{
 			VcpuCount:  intTo64Ptr(vm.Spec.VCPU),
 			MemSizeMib: intTo64Ptr(vm.Spec.MemoryInMb),
 			Smt:        boolPtr(true),
 		},
-		Drives:   []models.Drive{},
-		LogPath:  f.ss.LogPath(), //TODO: should we change to use a fifo?
-		LogLevel: "Debug",
-	}
-
-	// if len(vm.Status.Metadata) > 0 {
-	// 	cloudInitFile, err := shared.CreateCloudInitImage(ctx, false, vm, f.ss, f.ds)
-	// 	if err != nil {
-	// 		return "", fmt.Errorf("creating cloud-init disk image: %w", err)
-	// 	}
-	// 	cfg.Drives = append(cfg.Drives, models.Drive{
-	// 		DriveID:      strPtr(cloudinit.VolumeName),
-	// 		IsReadOnly:   boolPtr(true),
-	// 		IsRootDevice: boolPtr(false),
-	// 		PathOnHost:   strPtr(cloudInitFile),
-	// 	})
-	// }
+		Drives:      []models.Drive{},
+		LogPath:     shared.LogFIFOPath(f.ss),
+		LogLevel:    "Debug",
+		MetricsPath: metricsPipe.FIFO,
+		// Don't pass signals to firecracker, the vm must keep running when the
+		// daemon is stopped.
+		ForwardSignals: []os.Signal{},
+	}
 
 	for id, mount := range vm.Status.VolumeMounts {
-		isRoot := id == "root"
+		isRoot := id == vm.Spec.RootVolume.Name
 		drive := models.Drive{
 			DriveID:      strPtr(id),
 			IsRootDevice: &isRoot,
@@ -91,6 +96,21 @@ func (f *Provider) Create(ctx context.Context, vm *domain.VM) (string, error) {
 		cfg.Drives = append(cfg.Drives, drive)
 	}
 
+	placeholders, err := f.placeholderDrives(vm)
+	if err != nil {
+		return "", fmt.Errorf("creating volume slots: %w", err)
+	}
+	cfg.Drives = append(cfg.Drives, placeholders...)
+
+	if configDriveFile != "" {
+		cfg.Drives = append(cfg.Drives, models.Drive{
+			DriveID:      strPtr(cloudinit.VolumeName),
+			IsReadOnly:   boolPtr(true),
+			IsRootDevice: boolPtr(false),
+			PathOnHost:   strPtr(configDriveFile),
+		})
+	}
+
 	// cfg.NetworkInterfaces = sdk.NetworkInterfaces{
 	// 	{
 	// 		CNIConfiguration: &sdk.CNIConfiguration{
@@ -121,18 +141,38 @@ func (f *Provider) Create(ctx context.Context, vm *domain.VM) (string, error) {
 		cfg.NetworkInterfaces = append(cfg.NetworkInterfaces, netInt)
 	}
 	cfg.MmdsVersion = sdk.MMDSv1
+	if vm.Spec.Datasource() == domain.DatasourceEC2 {
+		// V2 requires IMDSv2 session tokens, like EC2 instances that enforce them
+		cfg.MmdsVersion = sdk.MMDSv2
+	}
+
+	vsock, err := shared.PrepareVSock(vm, f.ss)
+	if err != nil {
+		return "", err
+	}
+	cfg.VsockDevices = []sdk.VsockDevice{{ID: "vsock0", Path: vsock.Socket, CID: vsock.CID}}
 
 	args := []string{}
 	if metadataFile != "" {
 		args = append(args, "--metadata", metadataFile)
 	}
 
+	// Remove the socket left behind if the vm is being restarted
+	if err := shared.RemoveStaleSocket(socketPath); err != nil {
+		return "", err
+	}
+
+	// The sdk kills firecracker when the context is cancelled, so the vm must not
+	// be tied to the context of the request that created it.
+	ctx = context.WithoutCancel(ctx)
+
 	//TODO: this needs to be an optional arg for the path
 	cmd := sdk.VMCommandBuilder{}.
 		WithSocketPath(socketPath).
 		WithBin(f.binaryPath).
 		WithStderr(stdErrFile).
-		WithStdout(stdOutFile).
+		WithStdout(tty).
+		WithStdin(tty).
 		WithArgs(args).
 		Build(ctx)
 
@@ -140,11 +180,17 @@ func (f *Provider) Create(ctx context.Context, vm *domain.VM) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to create new firecracker machine: %w", err)
 	}
+	// The sdk parses the kernel args into a map to add ip= for its static
+	// network config, which mikrolite doesn't use, and loses their order.
+	m.Handlers.FcInit = m.Handlers.FcInit.Remove(sdk.SetupKernelArgsHandlerName)
 
 	err = m.Start(ctx)
 	if err != nil {
 		return "", fmt.Errorf("failed to create new firecracker machine: %w", err)
 	}
+	shared.RecordExit(f.ss, func() error {
+		return m.Wait(ctx)
+	})
 
 	// Save the pid
 	if err := f.ss.SavePID(cmd.Process.Pid); err != nil {
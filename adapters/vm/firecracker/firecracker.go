@@ -3,16 +3,19 @@ package firecracker
 import (
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"syscall"
 
+	sdk "github.com/firecracker-microvm/firecracker-go-sdk"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/afero"
 
 	"github.com/mikrolite/mikrolite/adapters/vm/shared"
+	"github.com/mikrolite/mikrolite/core/domain"
 	"github.com/mikrolite/mikrolite/core/ports"
-	"github.com/mikrolite/mikrolite/defaults"
 )
 
 const (
@@ -78,18 +81,22 @@ func (f *Provider) Delete(ctx context.Context, name string) error {
 	return nil
 }
 
-func (f *Provider) HasMetadataService() bool {
-	return true
-}
-
-func (f *Provider) ensureLogPath() error {
-	logFile, err := f.fs.OpenFile(f.ss.LogPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, defaults.DataFilePerm)
-	if err != nil {
-		return fmt.Errorf("creating log file %s: %w", f.ss.LogPath(), err)
+func (f *Provider) Capabilities() ports.Capabilities {
+	return ports.Capabilities{
+		MetadataService: true,
+		Vsock:           true,
+		Metrics:         true,
+		DiskFeatures: []ports.DiskFeature{
+			ports.DiskFeatureRawFile,
+			ports.DiskFeatureBlockDevice,
+			ports.DiskFeatureReadOnly,
+			ports.DiskFeatureBackingSwap,
+		},
+		NetworkFeatures: []ports.NetworkFeature{
+			ports.NetworkFeatureTap,
+			ports.NetworkFeatureMetadataAccess,
+		},
 	}
-	logFile.Close()
-
-	return nil
 }
 
 func (f *Provider) writeNetworkConfig(path, networkName string) error {
@@ -111,19 +118,27 @@ func (f *Provider) writeNetworkConfig(path, networkName string) error {
 	  }`, networkName)), 0644)
 }
 
+func (f *Provider) apiClient() *sdk.Client {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	return sdk.NewClient(f.socketPath(), logrus.NewEntry(logger), false)
+}
+
 func (f *Provider) socketPath() string {
 	return filepath.Join(f.ss.Root(), "firecracker.sock")
 }
 
-func defaultKernelCmdLine() map[string]string {
-	return map[string]string{
-		"console":       "ttyS0",
-		"reboot":        "k",
-		"panic":         "1",
-		"pci":           "off",
-		"i8042.noaux":   "",
-		"i8042.nomux":   "",
-		"i8042.nopnp":   "",
-		"i8042.dumbkbd": "",
+// defaultKernelArgs are the kernel args used if the spec doesn't have any.
+func defaultKernelArgs() []domain.KernelArg {
+	return []domain.KernelArg{
+		{Key: "console", Value: "ttyS0"},
+		{Key: "reboot", Value: "k"},
+		{Key: "panic", Value: "1"},
+		{Key: "pci", Value: "off"},
+		{Key: "i8042.noaux"},
+		{Key: "i8042.nomux"},
+		{Key: "i8042.nopnp"},
+		{Key: "i8042.dumbkbd"},
 	}
 }
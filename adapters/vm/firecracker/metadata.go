@@ -10,15 +10,31 @@ import (
 	"github.com/mikrolite/mikrolite/cloudinit"
 	"github.com/mikrolite/mikrolite/core/domain"
 	"github.com/mikrolite/mikrolite/defaults"
+	"github.com/mikrolite/mikrolite/imds"
 )
 
 type metadata struct {
 	Latest map[string]string `json:"latest"`
 }
 
+// saveMetadata saves the content of the MMDS. With the ec2 datasource its the
+// EC2 instance metadata, otherwise its the nocloud-net seed.
 func (f *Provider) saveMetadata(vm *domain.VM) (string, error) {
 	metadataFile := filepath.Join(f.ss.Root(), "metadata.json")
 
+	if vm.Spec.Datasource() == domain.DatasourceEC2 {
+		tree, err := imds.New(vm)
+		if err != nil {
+			return "", fmt.Errorf("generating ec2 metadata: %w", err)
+		}
+
+		if err := f.writeMetadata(metadataFile, tree); err != nil {
+			return "", err
+		}
+
+		return metadataFile, nil
+	}
+
 	meta := &metadata{
 		Latest: map[string]string{},
 	}
@@ -36,20 +52,28 @@ func (f *Provider) saveMetadata(vm *domain.VM) (string, error) {
 		meta.Latest[key] = string(decodedValue)
 	}
 
+	if err := f.writeMetadata(metadataFile, meta); err != nil {
+		return "", err
+	}
+
+	return metadataFile, nil
+}
+
+func (f *Provider) writeMetadata(metadataFile string, meta interface{}) error {
 	data, err := json.MarshalIndent(meta, "", " ")
 	if err != nil {
-		return "", fmt.Errorf("marshalling metdata: %w", err)
+		return fmt.Errorf("marshalling metdata: %w", err)
 	}
 
 	file, err := f.fs.OpenFile(metadataFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, defaults.DataFilePerm)
 	if err != nil {
-		return "", fmt.Errorf("opening metadata file %s: %w", metadataFile, err)
+		return fmt.Errorf("opening metadata file %s: %w", metadataFile, err)
 	}
 	defer file.Close()
 
 	if _, err := file.Write(data); err != nil {
-		return "", fmt.Errorf("writing metdata to file: %w", err)
+		return fmt.Errorf("writing metdata to file: %w", err)
 	}
 
-	return metadataFile, nil
+	return nil
 }
@@ -0,0 +1,307 @@
+package firecracker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/firecracker-microvm/firecracker-go-sdk/client/models"
+	"golang.org/x/sys/unix"
+
+	"github.com/mikrolite/mikrolite/adapters/console"
+	"github.com/mikrolite/mikrolite/adapters/logs"
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/defaults"
+)
+
+const (
+	metricsPerm = 0o600
+	// flushWait is how long to wait for the flushed metrics to be copied from
+	// the fifo by the console shim.
+	flushWait         = time.Second
+	flushPollInterval = 10 * time.Millisecond
+)
+
+// deviceGroups are the metric groups that firecracker also writes for each
+// device, e.g. net_eth0. The device is used as a label.
+var deviceGroups = []string{"block", "net", "vhost_user_block"}
+
+// Metrics returns the firecracker metrics. Firecracker writes the counters as
+// the change since they were last written, so they are added up in a file in
+// the state directory.
+func (f *Provider) Metrics(ctx context.Context, vm *domain.VM) ([]domain.Metric, error) {
+	totals, err := f.collectMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return totals.metrics(), nil
+}
+
+// metricsPipe is the fifo firecracker writes its metrics to, its appended to
+// the metrics file by the console shim.
+func (f *Provider) metricsPipe() console.Pipe {
+	return console.Pipe{
+		FIFO: filepath.Join(f.ss.Root(), "vm.metrics.fifo"),
+		File: f.metricsPath(),
+	}
+}
+
+func (f *Provider) metricsPath() string {
+	return filepath.Join(f.ss.Root(), "vm.metrics")
+}
+
+func (f *Provider) metricsTotalsPath() string {
+	return filepath.Join(f.ss.Root(), "vm.metrics.totals")
+}
+
+// collectMetrics flushes the metrics and adds the ones written since they were
+// last collected to the totals. The totals are locked as the metrics can be
+// collected by mikrolited and the cli at the same time.
+func (f *Provider) collectMetrics(ctx context.Context) (*metricsTotals, error) {
+	totalsFile, err := os.OpenFile(f.metricsTotalsPath(), os.O_RDWR|os.O_CREATE, metricsPerm)
+	if err != nil {
+		return nil, fmt.Errorf("opening metrics totals: %w", err)
+	}
+	defer totalsFile.Close()
+
+	if err := unix.Flock(int(totalsFile.Fd()), unix.LOCK_EX); err != nil {
+		return nil, fmt.Errorf("locking metrics totals: %w", err)
+	}
+
+	totals, err := readMetricsTotals(totalsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.flushMetrics(ctx); err != nil {
+		return nil, err
+	}
+
+	// The console shim holds the lock on the metrics file while it appends to
+	// it and rotates it, so nothing is written between reading and removing
+	// the metrics
+	path := f.metricsPath()
+	metricsFile, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, metricsPerm)
+	if err != nil {
+		return nil, fmt.Errorf("opening metrics: %w", err)
+	}
+	defer metricsFile.Close()
+	if err := unix.Flock(int(metricsFile.Fd()), unix.LOCK_EX); err != nil {
+		return nil, fmt.Errorf("locking metrics: %w", err)
+	}
+
+	for _, file := range logs.WithRotated(path, defaults.LogMaxFiles) {
+		if file == path {
+			if err := collectMetricsFile(metricsFile, totals); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
+			return nil, fmt.Errorf("reading metrics %s: %w", file, err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			totals.add(line)
+		}
+
+		// The metrics are removed once they've been added so that they aren't
+		// added again
+		if err := os.Remove(file); err != nil {
+			return nil, fmt.Errorf("removing collected metrics %s: %w", file, err)
+		}
+	}
+
+	data, err := json.Marshal(totals)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling metrics totals: %w", err)
+	}
+	if err := totalsFile.Truncate(0); err != nil {
+		return nil, fmt.Errorf("truncating metrics totals: %w", err)
+	}
+	if _, err := totalsFile.WriteAt(data, 0); err != nil {
+		return nil, fmt.Errorf("writing metrics totals: %w", err)
+	}
+
+	return totals, nil
+}
+
+// collectMetricsFile adds the complete lines of the locked metrics file to the
+// totals and truncates it. A line that is still being copied by the shim is
+// kept.
+func collectMetricsFile(file *os.File, totals *metricsTotals) error {
+	data, err := io.ReadAll(io.NewSectionReader(file, 0, math.MaxInt64))
+	if err != nil {
+		return fmt.Errorf("reading metrics %s: %w", file.Name(), err)
+	}
+
+	complete := strings.LastIndexByte(string(data), '\n') + 1
+	for _, line := range strings.Split(string(data[:complete]), "\n") {
+		totals.add(line)
+	}
+
+	if err := file.Truncate(0); err != nil {
+		return fmt.Errorf("truncating metrics %s: %w", file.Name(), err)
+	}
+	if _, err := file.WriteAt(data[complete:], 0); err != nil {
+		return fmt.Errorf("keeping partial metrics %s: %w", file.Name(), err)
+	}
+
+	return nil
+}
+
+// flushMetrics makes firecracker write its metrics, which it otherwise only
+// does once a minute, and waits for them to be copied to the metrics file.
+func (f *Provider) flushMetrics(ctx context.Context) error {
+	before := fileSize(f.metricsPath())
+
+	action := &models.InstanceActionInfo{
+		ActionType: strPtr(models.InstanceActionInfoActionTypeFlushMetrics),
+	}
+	if _, err := f.apiClient().CreateSyncAction(ctx, action); err != nil {
+		return fmt.Errorf("flushing metrics: %w", err)
+	}
+
+	deadline := time.Now().Add(flushWait)
+	for fileSize(f.metricsPath()) == before && time.Now().Before(deadline) {
+		time.Sleep(flushPollInterval)
+	}
+
+	return nil
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+
+	return info.Size()
+}
+
+// metricsTotals are the firecracker metrics keyed by group and name, e.g.
+// net_eth0.rx_bytes_count.
+type metricsTotals struct {
+	Counters map[string]float64 `json:"counters"`
+	Gauges   map[string]float64 `json:"gauges"`
+}
+
+func readMetricsTotals(r io.Reader) (*metricsTotals, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading metrics totals: %w", err)
+	}
+
+	totals := &metricsTotals{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, totals); err != nil {
+			return nil, fmt.Errorf("unmarshalling metrics totals: %w", err)
+		}
+	}
+	if totals.Counters == nil {
+		totals.Counters = map[string]float64{}
+	}
+	if totals.Gauges == nil {
+		totals.Gauges = map[string]float64{}
+	}
+
+	return totals, nil
+}
+
+// add adds a line written by firecracker to the totals. The latencies are
+// gauges and the rest are counters, lines that aren't valid json are ignored.
+func (t *metricsTotals) add(line string) {
+	groups := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(line), &groups); err != nil {
+		return
+	}
+
+	for group, groupValue := range groups {
+		fields, ok := groupValue.(map[string]interface{})
+		if !ok {
+			// The timestamp of the line
+			continue
+		}
+
+		for name, value := range fields {
+			key := group + "." + name
+			switch v := value.(type) {
+			case float64:
+				if group == "latencies_us" || strings.HasSuffix(name, "_us") {
+					t.Gauges[key] = v
+				} else {
+					t.Counters[key] += v
+				}
+			case map[string]interface{}:
+				// Aggregated latencies such as {"min_us": 1, "max_us": 5, "sum_us": 9}
+				for stat, statValue := range v {
+					if n, ok := statValue.(float64); ok {
+						t.Gauges[key+"_"+stat] = n
+					}
+				}
+			}
+		}
+	}
+}
+
+func (t *metricsTotals) metrics() []domain.Metric {
+	metrics := []domain.Metric{}
+	for _, values := range []struct {
+		metricType domain.MetricType
+		values     map[string]float64
+	}{
+		{domain.MetricTypeCounter, t.Counters},
+		{domain.MetricTypeGauge, t.Gauges},
+	} {
+		for key, value := range values.values {
+			group, name, _ := strings.Cut(key, ".")
+			group, device := splitDeviceGroup(group)
+
+			metric := domain.Metric{
+				Name:  fmt.Sprintf("firecracker_%s_%s", group, name),
+				Type:  values.metricType,
+				Value: value,
+			}
+			if device != "" {
+				metric.Labels = map[string]string{"device": device}
+			}
+			metrics = append(metrics, metric)
+		}
+	}
+
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].Name != metrics[j].Name {
+			return metrics[i].Name < metrics[j].Name
+		}
+
+		return metrics[i].Labels["device"] < metrics[j].Labels["device"]
+	})
+
+	return metrics
+}
+
+// splitDeviceGroup splits a per device group such as net_eth0 into the group
+// and the device.
+func splitDeviceGroup(group string) (string, string) {
+	for _, deviceGroup := range deviceGroups {
+		if device, ok := strings.CutPrefix(group, deviceGroup+"_"); ok {
+			return deviceGroup, device
+		}
+	}
+
+	return group, ""
+}
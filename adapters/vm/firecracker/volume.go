@@ -0,0 +1,119 @@
+package firecracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/firecracker-microvm/firecracker-go-sdk/client/models"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/defaults"
+)
+
+const (
+	// placeholderSizeInBytes is the size of the backing file for an empty volume slot.
+	placeholderSizeInBytes = 1024 * 1024
+)
+
+var errNoFreeVolumeSlot = errors.New("no free volume slots, create the vm with more volume slots")
+
+// AttachVolume will attach a volume by swapping the backing file of a free placeholder drive.
+func (f *Provider) AttachVolume(ctx context.Context, vm *domain.VM, volumeName string) error {
+	slog.Debug("attaching volume to firecracker vm", "name", vm.Name, "volume", volumeName)
+
+	mount, ok := vm.Status.VolumeMounts[volumeName]
+	if !ok {
+		return fmt.Errorf("no mount found for volume %s", volumeName)
+	}
+
+	slot, err := freeVolumeSlot(vm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.apiClient().PatchGuestDriveByID(ctx, slot, mount.Location); err != nil {
+		return fmt.Errorf("updating drive %s: %w", slot, err)
+	}
+
+	if vm.Status.VolumeSlots == nil {
+		vm.Status.VolumeSlots = map[string]string{}
+	}
+	vm.Status.VolumeSlots[volumeName] = slot
+
+	return nil
+}
+
+// DetachVolume will detach a volume by swapping the backing file of its drive back to a placeholder.
+func (f *Provider) DetachVolume(ctx context.Context, vm *domain.VM, volumeName string) error {
+	slog.Debug("detaching volume from firecracker vm", "name", vm.Name, "volume", volumeName)
+
+	slot, ok := vm.Status.VolumeSlots[volumeName]
+	if !ok {
+		return fmt.Errorf("volume %s wasn't hot-attached and can't be detached", volumeName)
+	}
+
+	if _, err := f.apiClient().PatchGuestDriveByID(ctx, slot, f.placeholderPath(slot)); err != nil {
+		return fmt.Errorf("updating drive %s: %w", slot, err)
+	}
+
+	delete(vm.Status.VolumeSlots, volumeName)
+
+	return nil
+}
+
+// placeholderDrives will create the placeholder drives used to hot-attach volumes later.
+func (f *Provider) placeholderDrives(vm *domain.VM) ([]models.Drive, error) {
+	drives := []models.Drive{}
+
+	for i := 0; i < vm.Spec.VolumeSlots; i++ {
+		slot := volumeSlotName(i)
+		path := f.placeholderPath(slot)
+
+		file, err := f.fs.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, defaults.DataFilePerm)
+		if err != nil {
+			return nil, fmt.Errorf("creating placeholder file %s: %w", path, err)
+		}
+		if err := file.Truncate(placeholderSizeInBytes); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("sizing placeholder file %s: %w", path, err)
+		}
+		file.Close()
+
+		drives = append(drives, models.Drive{
+			DriveID:      strPtr(slot),
+			IsRootDevice: boolPtr(false),
+			IsReadOnly:   boolPtr(false),
+			PathOnHost:   strPtr(path),
+		})
+	}
+
+	return drives, nil
+}
+
+func (f *Provider) placeholderPath(slot string) string {
+	return filepath.Join(f.ss.Root(), fmt.Sprintf("%s.img", slot))
+}
+
+func freeVolumeSlot(vm *domain.VM) (string, error) {
+	used := map[string]bool{}
+	for _, slot := range vm.Status.VolumeSlots {
+		used[slot] = true
+	}
+
+	for i := 0; i < vm.Spec.VolumeSlots; i++ {
+		slot := volumeSlotName(i)
+		if !used[slot] {
+			return slot, nil
+		}
+	}
+
+	return "", errNoFreeVolumeSlot
+}
+
+func volumeSlotName(index int) string {
+	return fmt.Sprintf("slot%d", index)
+}
@@ -0,0 +1,74 @@
+package plugin
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	sdk "github.com/mikrolite/mikrolite/plugin"
+)
+
+// ErrNotFound is returned when there is no plugin for a provider.
+var ErrNotFound = errors.New("provider plugin not found")
+
+// Find will search the plugin paths and then PATH for the plugin executable of the named provider.
+func Find(name string, pluginPaths []string) (string, error) {
+	executable := sdk.ExecutablePrefix + name
+
+	for _, dir := range searchPaths(pluginPaths) {
+		path := filepath.Join(dir, executable)
+		if isExecutable(path) {
+			return path, nil
+		}
+	}
+
+	return "", ErrNotFound
+}
+
+// Discover will return the names of all the provider plugins found on the plugin paths and PATH.
+func Discover(pluginPaths []string) []string {
+	found := map[string]bool{}
+
+	for _, dir := range searchPaths(pluginPaths) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasPrefix(entry.Name(), sdk.ExecutablePrefix) {
+				continue
+			}
+			if !isExecutable(filepath.Join(dir, entry.Name())) {
+				continue
+			}
+
+			found[strings.TrimPrefix(entry.Name(), sdk.ExecutablePrefix)] = true
+		}
+	}
+
+	names := []string{}
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func searchPaths(pluginPaths []string) []string {
+	paths := append([]string{}, pluginPaths...)
+
+	return append(paths, filepath.SplitList(os.Getenv("PATH"))...)
+}
+
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	return !info.IsDir() && info.Mode().Perm()&0o111 != 0
+}
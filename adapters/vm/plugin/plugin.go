@@ -0,0 +1,177 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+	sdk "github.com/mikrolite/mikrolite/plugin"
+)
+
+// New creates a vm provider that forwards calls to a plugin executable.
+func New(name string, executablePath string, stateService ports.StateService) ports.VMProvider {
+	env := sdk.Environment{}
+	if stateService != nil {
+		env.StateDir = stateService.Root()
+	}
+
+	return &provider{
+		name:           name,
+		executablePath: executablePath,
+		env:            env,
+	}
+}
+
+type provider struct {
+	name           string
+	executablePath string
+	env            sdk.Environment
+	capabilities   *ports.Capabilities
+}
+
+func (p *provider) Create(ctx context.Context, vm *domain.VM) (string, error) {
+	resp := &sdk.CreateResponse{}
+	if err := p.call(ctx, sdk.MethodCreate, &sdk.CreateRequest{Env: p.env, VM: vm}, resp); err != nil {
+		return "", err
+	}
+
+	if resp.VM != nil {
+		*vm = *resp.VM
+	}
+
+	return resp.ID, nil
+}
+
+func (p *provider) Stop(ctx context.Context, name string) error {
+	return p.call(ctx, sdk.MethodStop, &sdk.NameRequest{Env: p.env, Name: name}, &sdk.Empty{})
+}
+
+func (p *provider) Delete(ctx context.Context, name string) error {
+	return p.call(ctx, sdk.MethodDelete, &sdk.NameRequest{Env: p.env, Name: name}, &sdk.Empty{})
+}
+
+func (p *provider) AttachVolume(ctx context.Context, vm *domain.VM, volumeName string) error {
+	return p.callVolume(ctx, sdk.MethodAttachVolume, vm, volumeName)
+}
+
+func (p *provider) DetachVolume(ctx context.Context, vm *domain.VM, volumeName string) error {
+	return p.callVolume(ctx, sdk.MethodDetachVolume, vm, volumeName)
+}
+
+func (p *provider) Metrics(ctx context.Context, vm *domain.VM) ([]domain.Metric, error) {
+	resp := &sdk.MetricsResponse{}
+	if err := p.call(ctx, sdk.MethodMetrics, &sdk.MetricsRequest{Env: p.env, VM: vm}, resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Metrics, nil
+}
+
+func (p *provider) Capabilities() ports.Capabilities {
+	if p.capabilities != nil {
+		return *p.capabilities
+	}
+
+	resp := &sdk.CapabilitiesResponse{}
+	if err := p.call(context.Background(), sdk.MethodCapabilities, &sdk.CapabilitiesRequest{Env: p.env}, resp); err != nil {
+		slog.Warn("failed to get capabilities from provider plugin", "name", p.name, "error", err)
+
+		return ports.Capabilities{}
+	}
+	p.capabilities = &resp.Capabilities
+
+	return resp.Capabilities
+}
+
+func (p *provider) callVolume(ctx context.Context, method string, vm *domain.VM, volumeName string) error {
+	req := &sdk.VolumeRequest{
+		Env:        p.env,
+		VM:         vm,
+		VolumeName: volumeName,
+	}
+	resp := &sdk.VolumeResponse{}
+	if err := p.call(ctx, method, req, resp); err != nil {
+		return err
+	}
+
+	if resp.VM != nil {
+		*vm = *resp.VM
+	}
+
+	return nil
+}
+
+// call will start the plugin, check the protocol version and then call the method.
+func (p *provider) call(ctx context.Context, method string, args interface{}, reply interface{}) error {
+	slog.Debug("calling provider plugin", "name", p.name, "method", method)
+
+	cmd := exec.CommandContext(ctx, p.executablePath)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("getting plugin stdin: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("getting plugin stdout: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting provider plugin %s: %w", p.executablePath, err)
+	}
+
+	client := jsonrpc.NewClient(&pipeConn{
+		ReadCloser:  stdout,
+		WriteCloser: stdin,
+	})
+
+	callErr := p.handshakeAndCall(client, method, args, reply)
+
+	client.Close()
+	if err := cmd.Wait(); err != nil {
+		slog.Debug("provider plugin exited with error", "name", p.name, "error", err)
+	}
+
+	return callErr
+}
+
+func (p *provider) handshakeAndCall(client *rpc.Client, method string, args interface{}, reply interface{}) error {
+	handshake := &sdk.HandshakeResponse{}
+	if err := client.Call(sdk.MethodHandshake, &sdk.HandshakeRequest{ProtocolVersion: sdk.ProtocolVersion}, handshake); err != nil {
+		return fmt.Errorf("handshake with provider plugin %s: %w", p.name, err)
+	}
+	if handshake.ProtocolVersion != sdk.ProtocolVersion {
+		return fmt.Errorf("provider plugin %s uses protocol version %d, expected %d", p.name, handshake.ProtocolVersion, sdk.ProtocolVersion)
+	}
+
+	if err := client.Call(method, args, reply); err != nil {
+		return fmt.Errorf("calling %s on provider plugin %s: %w", method, p.name, err)
+	}
+
+	return nil
+}
+
+// pipeConn joins the plugins stdout and stdin into a connection for the rpc codec.
+type pipeConn struct {
+	io.ReadCloser
+	io.WriteCloser
+}
+
+func (c *pipeConn) Close() error {
+	writeErr := c.WriteCloser.Close()
+	readErr := c.ReadCloser.Close()
+
+	if writeErr != nil {
+		return writeErr
+	}
+
+	return readErr
+}
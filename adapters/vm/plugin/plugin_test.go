@@ -0,0 +1,284 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+	sdk "github.com/mikrolite/mikrolite/plugin"
+	"github.com/mikrolite/mikrolite/testing/fakes"
+)
+
+const (
+	// pluginModeEnv makes the test binary run as a plugin, so the tests can
+	// start it as the plugin executable.
+	pluginModeEnv = "MIKROLITE_TEST_PLUGIN"
+
+	pluginModeServe       = "serve"
+	pluginModeOldProtocol = "old-protocol"
+)
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(pluginModeEnv) {
+	case pluginModeServe:
+		if err := sdk.Serve("fake", func(env sdk.Environment) (ports.VMProvider, error) {
+			return &fakeProvider{env: env}, nil
+		}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case pluginModeOldProtocol:
+		server := rpc.NewServer()
+		if err := server.RegisterName(sdk.ServiceName, &oldProtocolService{}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		server.ServeCodec(jsonrpc.NewServerCodec(&stdio{Reader: os.Stdin, Writer: os.Stdout}))
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+// fakeProvider is the provider served by the test plugin. A new plugin process
+// is started for every call, so it only changes the vm it's given.
+type fakeProvider struct {
+	env sdk.Environment
+}
+
+var errInjected = errors.New("injected failure")
+
+func (p *fakeProvider) Create(ctx context.Context, vm *domain.VM) (string, error) {
+	vm.Status.IP = "192.168.122.10"
+
+	return p.env.StateDir, nil
+}
+
+func (p *fakeProvider) Stop(ctx context.Context, name string) error {
+	if name == "fail" {
+		return errInjected
+	}
+
+	return nil
+}
+
+func (p *fakeProvider) Delete(ctx context.Context, name string) error {
+	if name == "fail" {
+		return errInjected
+	}
+
+	return nil
+}
+
+func (p *fakeProvider) AttachVolume(ctx context.Context, vm *domain.VM, volumeName string) error {
+	if vm.Status.VolumeSlots == nil {
+		vm.Status.VolumeSlots = map[string]string{}
+	}
+	vm.Status.VolumeSlots[volumeName] = "slot0"
+
+	return nil
+}
+
+func (p *fakeProvider) DetachVolume(ctx context.Context, vm *domain.VM, volumeName string) error {
+	if _, ok := vm.Status.VolumeSlots[volumeName]; !ok {
+		return errInjected
+	}
+	delete(vm.Status.VolumeSlots, volumeName)
+
+	return nil
+}
+
+func (p *fakeProvider) Metrics(ctx context.Context, vm *domain.VM) ([]domain.Metric, error) {
+	return []domain.Metric{
+		{Name: "tap_rx_bytes", Type: domain.MetricTypeCounter, Labels: map[string]string{"device": "eth0"}, Value: 42},
+	}, nil
+}
+
+func (p *fakeProvider) Capabilities() ports.Capabilities {
+	return ports.Capabilities{
+		HotplugDisk: true,
+		Metrics:     true,
+		DiskFeatures: []ports.DiskFeature{
+			ports.DiskFeatureRawFile,
+		},
+	}
+}
+
+// oldProtocolService is a plugin that only speaks a different protocol version.
+type oldProtocolService struct{}
+
+func (s *oldProtocolService) Handshake(req *sdk.HandshakeRequest, resp *sdk.HandshakeResponse) error {
+	resp.ProtocolVersion = sdk.ProtocolVersion + 1
+	resp.Name = "old"
+
+	return nil
+}
+
+func (s *oldProtocolService) Stop(req *sdk.NameRequest, resp *sdk.Empty) error {
+	return errors.New("expected the call to be refused after the handshake")
+}
+
+type stdio struct {
+	io.Reader
+	io.Writer
+}
+
+func (s *stdio) Close() error {
+	return nil
+}
+
+// newTestProvider returns a provider that runs the test binary as the plugin.
+func newTestProvider(t *testing.T, mode string) (ports.VMProvider, *fakes.StateService) {
+	t.Helper()
+
+	t.Setenv(pluginModeEnv, mode)
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatalf("getting test executable: %s", err)
+	}
+
+	state := fakes.NewStateService(fakes.NewRecorder(), t.TempDir(), "vm1")
+
+	return New("fake", executable, state), state
+}
+
+func TestProvider(t *testing.T) {
+	provider, state := newTestProvider(t, pluginModeServe)
+	ctx := context.Background()
+
+	vm := &domain.VM{Name: "vm1", Status: &domain.VMStatus{}}
+	id, err := provider.Create(ctx, vm)
+	if err != nil {
+		t.Fatalf("creating vm: %s", err)
+	}
+	if id != state.Root() {
+		t.Errorf("expected the state dir %s to be sent to the plugin, got %s", state.Root(), id)
+	}
+	if vm.Status.IP != "192.168.122.10" {
+		t.Errorf("expected the changes to the vm to be returned, got %+v", vm.Status)
+	}
+
+	if err := provider.AttachVolume(ctx, vm, "data"); err != nil {
+		t.Fatalf("attaching volume: %s", err)
+	}
+	if vm.Status.VolumeSlots["data"] != "slot0" {
+		t.Errorf("expected the volume slot to be returned, got %v", vm.Status.VolumeSlots)
+	}
+	if err := provider.DetachVolume(ctx, vm, "data"); err != nil {
+		t.Fatalf("detaching volume: %s", err)
+	}
+	if _, ok := vm.Status.VolumeSlots["data"]; ok {
+		t.Errorf("expected the volume slot to be removed, got %v", vm.Status.VolumeSlots)
+	}
+
+	metrics, err := provider.Metrics(ctx, vm)
+	if err != nil {
+		t.Fatalf("getting metrics: %s", err)
+	}
+	expectedMetrics := []domain.Metric{
+		{Name: "tap_rx_bytes", Type: domain.MetricTypeCounter, Labels: map[string]string{"device": "eth0"}, Value: 42},
+	}
+	if !reflect.DeepEqual(metrics, expectedMetrics) {
+		t.Errorf("expected metrics %v, got %v", expectedMetrics, metrics)
+	}
+
+	caps := provider.Capabilities()
+	if !caps.HotplugDisk || !caps.Metrics || !caps.HasDiskFeature(ports.DiskFeatureRawFile) {
+		t.Errorf("unexpected capabilities %+v", caps)
+	}
+
+	if err := provider.Stop(ctx, "vm1"); err != nil {
+		t.Errorf("stopping vm: %s", err)
+	}
+	if err := provider.Delete(ctx, "vm1"); err != nil {
+		t.Errorf("deleting vm: %s", err)
+	}
+}
+
+func TestProviderErrors(t *testing.T) {
+	provider, _ := newTestProvider(t, pluginModeServe)
+	ctx := context.Background()
+
+	if err := provider.Stop(ctx, "fail"); err == nil || !strings.Contains(err.Error(), errInjected.Error()) {
+		t.Errorf("expected the stop error from the plugin, got %v", err)
+	}
+	if err := provider.Delete(ctx, "fail"); err == nil || !strings.Contains(err.Error(), errInjected.Error()) {
+		t.Errorf("expected the delete error from the plugin, got %v", err)
+	}
+
+	vm := &domain.VM{Name: "vm1", Status: &domain.VMStatus{}}
+	if err := provider.DetachVolume(ctx, vm, "missing"); err == nil || !strings.Contains(err.Error(), errInjected.Error()) {
+		t.Errorf("expected the detach error from the plugin, got %v", err)
+	}
+}
+
+func TestProviderProtocolMismatch(t *testing.T) {
+	provider, _ := newTestProvider(t, pluginModeOldProtocol)
+
+	err := provider.Stop(context.Background(), "vm1")
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("uses protocol version %d, expected %d", sdk.ProtocolVersion+1, sdk.ProtocolVersion)) {
+		t.Errorf("expected the protocol version to be rejected, got %v", err)
+	}
+	if caps := provider.Capabilities(); !reflect.DeepEqual(caps, ports.Capabilities{}) {
+		t.Errorf("expected no capabilities, got %+v", caps)
+	}
+}
+
+func TestFind(t *testing.T) {
+	dir := t.TempDir()
+	writeExecutable(t, filepath.Join(dir, sdk.ExecutablePrefix+"fake"), 0o755)
+	writeExecutable(t, filepath.Join(dir, sdk.ExecutablePrefix+"notexec"), 0o644)
+	t.Setenv("PATH", "")
+
+	path, err := Find("fake", []string{t.TempDir(), dir})
+	if err != nil {
+		t.Fatalf("finding plugin: %s", err)
+	}
+	if path != filepath.Join(dir, sdk.ExecutablePrefix+"fake") {
+		t.Errorf("unexpected plugin path %s", path)
+	}
+
+	for _, name := range []string{"notexec", "missing"} {
+		if _, err := Find(name, []string{dir}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected %s not to be found, got %v", name, err)
+		}
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	first, second, pathDir := t.TempDir(), t.TempDir(), t.TempDir()
+	writeExecutable(t, filepath.Join(first, sdk.ExecutablePrefix+"b"), 0o755)
+	writeExecutable(t, filepath.Join(first, sdk.ExecutablePrefix+"ignored"), 0o644)
+	writeExecutable(t, filepath.Join(first, "other-binary"), 0o755)
+	writeExecutable(t, filepath.Join(second, sdk.ExecutablePrefix+"a"), 0o755)
+	writeExecutable(t, filepath.Join(second, sdk.ExecutablePrefix+"b"), 0o755)
+	writeExecutable(t, filepath.Join(pathDir, sdk.ExecutablePrefix+"c"), 0o755)
+	if err := os.Mkdir(filepath.Join(second, sdk.ExecutablePrefix+"dir"), 0o755); err != nil {
+		t.Fatalf("creating dir: %s", err)
+	}
+	t.Setenv("PATH", pathDir)
+
+	names := Discover([]string{first, second, filepath.Join(first, "missing")})
+	if expected := []string{"a", "b", "c"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected plugins %v, got %v", expected, names)
+	}
+}
+
+func writeExecutable(t *testing.T, path string, perm os.FileMode) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"), perm); err != nil {
+		t.Fatalf("writing %s: %s", path, err)
+	}
+}
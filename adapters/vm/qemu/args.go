@@ -0,0 +1,109 @@
+package qemu
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/mikrolite/mikrolite/adapters/vm/shared"
+	"github.com/mikrolite/mikrolite/cloudinit"
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+func (p *provider) buildArgs(vm *domain.VM, cloudInitFile string) ([]string, error) {
+	kernelPath := filepath.Join(vm.Status.KernelMount.Location, vm.Spec.Kernel.Source.Filename)
+
+	args := []string{
+		"-M", "microvm,x-option-roms=off,rtc=on",
+		"-enable-kvm",
+		"-cpu", "host",
+		"-nodefaults",
+		"-no-user-config",
+		"-nographic",
+		"-no-reboot",
+		"-serial", "stdio",
+		"-qmp", fmt.Sprintf("unix:%s,server=on,wait=off", p.qmpSocketPath()),
+		"-D", shared.LogFIFOPath(p.ss),
+	}
+
+	// Kernel and cmdline args
+	if len(vm.Spec.Kernel.CmdLine.Args) == 0 {
+		vm.Spec.Kernel.CmdLine.Args = defaultKernelArgs()
+	}
+	if vm.Status.MetadataURL != "" {
+		if err := shared.AddMetadataKernelArgs(vm, &vm.Spec.Kernel.CmdLine, vm.Status.MetadataURL); err != nil {
+			return nil, err
+		}
+	} else if err := shared.AddConfigDriveKernelArgs(vm, &vm.Spec.Kernel.CmdLine); err != nil {
+		return nil, err
+	}
+
+	args = append(args, "-kernel", kernelPath)
+	if initrdPath := shared.InitrdPath(vm); initrdPath != "" {
+		args = append(args, "-initrd", initrdPath)
+	}
+	args = append(args, "-append", vm.Spec.Kernel.CmdLine.String())
+
+	// CPU and memory
+	args = append(args, "-smp", fmt.Sprintf("%d", vm.Spec.VCPU))
+	args = append(args, "-m", fmt.Sprintf("%dM", vm.Spec.MemoryInMb))
+
+	// Volumes (root, additional, metadata)
+	rootVolumeStatus, volumeStatusFound := vm.Status.VolumeMounts[vm.Spec.RootVolume.Name]
+	if !volumeStatusFound {
+		return nil, errors.New("root volume not found")
+	}
+	args = append(args, diskArgs(vm.Spec.RootVolume.Name, rootVolumeStatus.Location, false)...)
+
+	for id, vol := range vm.Status.VolumeMounts {
+		if id == vm.Spec.RootVolume.Name {
+			continue
+		}
+		args = append(args, diskArgs(id, vol.Location, false)...)
+	}
+
+	if cloudInitFile != "" {
+		args = append(args, diskArgs(cloudinit.VolumeName, cloudInitFile, true)...)
+	}
+
+	// Network interfaces
+	for name := range vm.Spec.NetworkConfiguration.Interfaces {
+		status, ok := vm.Status.NetworkStatus[name]
+		if !ok {
+			return nil, fmt.Errorf("failed to get network status for %s", name)
+		}
+
+		args = append(args, "-netdev", fmt.Sprintf("tap,id=%s,ifname=%s,script=no,downscript=no", name, status.HostDeviveName))
+		args = append(args, "-device", fmt.Sprintf("virtio-net-device,netdev=%s,mac=%s", name, status.GuestMAC))
+	}
+
+	return args, nil
+}
+
+func diskArgs(id string, path string, readOnly bool) []string {
+	drive := fmt.Sprintf("id=%s,file=%s,format=raw,if=none", id, path)
+	if readOnly {
+		drive += ",readonly=on"
+	}
+
+	return []string{
+		"-drive", drive,
+		"-device", fmt.Sprintf("virtio-blk-device,drive=%s", id),
+	}
+}
+
+func (p *provider) qmpSocketPath() string {
+	return filepath.Join(p.ss.Root(), "qmp.sock")
+}
+
+// defaultKernelArgs are the kernel args used if the spec doesn't have any.
+func defaultKernelArgs() []domain.KernelArg {
+	return []domain.KernelArg{
+		{Key: "console", Value: "ttyS0"},
+		{Key: "root", Value: "/dev/vda"},
+		{Key: "rw"},
+		{Key: "reboot", Value: "t"},
+		{Key: "panic", Value: "1"},
+		{Key: "ds", Value: "nocloud"},
+	}
+}
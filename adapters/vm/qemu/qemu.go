@@ -0,0 +1,176 @@
+package qemu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/spf13/afero"
+
+	"github.com/mikrolite/mikrolite/adapters/vm/shared"
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+	"github.com/mikrolite/mikrolite/defaults"
+)
+
+const (
+	ProviderName = "qemu"
+)
+
+var (
+	errHotplugNotSupported = errors.New("hotplugging volumes isn't supported by the qemu microvm machine type")
+	errMetricsNotSupported = errors.New("metrics aren't supported by qemu")
+)
+
+func New(binaryPath string, stateService ports.StateService, ds ports.DiskService, fs afero.Fs) ports.VMProvider {
+	return &provider{
+		ss:         stateService,
+		fs:         fs,
+		ds:         ds,
+		binaryPath: binaryPath,
+	}
+}
+
+type provider struct {
+	ss         ports.StateService
+	ds         ports.DiskService
+	fs         afero.Fs
+	binaryPath string
+}
+
+func (p *provider) Create(ctx context.Context, vm *domain.VM) (string, error) {
+	// The metadata is served by mikrolite on the host if the vm has a url
+	cloudInitFile := ""
+	if vm.Status.MetadataURL == "" {
+		var err error
+		cloudInitFile, err = shared.CreateBootstrapImage(ctx, true, vm, p.ss, p.ds)
+		if err != nil {
+			return "", fmt.Errorf("creating bootstrap disk image: %w", err)
+		}
+	}
+
+	args, err := p.buildArgs(vm, cloudInitFile)
+	if err != nil {
+		return "", fmt.Errorf("building qemu args: %w", err)
+	}
+
+	cmd := exec.Command(p.binaryPath, args...)
+
+	if err := shared.RotateLogs(p.ss); err != nil {
+		return "", err
+	}
+
+	tty, err := shared.StartConsole(vm, p.ss)
+	if err != nil {
+		return "", err
+	}
+	defer tty.Close()
+
+	stdErrFile, err := p.fs.OpenFile(p.ss.StderrPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, defaults.DataFilePerm)
+	if err != nil {
+		return "", fmt.Errorf("opening sterr file %s: %w", p.ss.StderrPath(), err)
+	}
+
+	cmd.Stderr = stdErrFile
+	cmd.Stdout = tty
+	cmd.Stdin = tty
+
+	// Remove the socket left behind if the vm is being restarted
+	if err := shared.RemoveStaleSocket(p.qmpSocketPath()); err != nil {
+		return "", err
+	}
+
+	if startErr := cmd.Start(); startErr != nil {
+		return "", fmt.Errorf("starting qemu: %w", startErr)
+	}
+	shared.RecordExit(p.ss, cmd.Wait)
+
+	// Save the pid
+	if err := p.ss.SavePID(cmd.Process.Pid); err != nil {
+		return "", fmt.Errorf("saving pid %d to file: %w", cmd.Process.Pid, err)
+	}
+
+	// Save the config
+	if err := p.ss.SaveVM(vm); err != nil {
+		return "", fmt.Errorf("saving vm config to file: %w", err)
+	}
+
+	return "", nil
+}
+
+func (p *provider) Stop(ctx context.Context, name string) error {
+	pid, err := p.ss.GetPID()
+	if err != nil {
+		return fmt.Errorf("getting vm pid: %w", err)
+	}
+
+	if pid == 0 {
+		slog.Debug("pid not set for vm, skipping stop", "name", name)
+
+		return nil
+	}
+
+	if _, err := p.qmpExecute(ctx, "system_powerdown", nil); err != nil {
+		slog.Debug("qmp powerdown failed, signalling process instead", "name", name, "error", err)
+
+		proc, err := os.FindProcess(pid)
+		if err != nil {
+			return fmt.Errorf("finding qemu process %d: %w", pid, err)
+		}
+		if err := proc.Signal(syscall.SIGTERM); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			return fmt.Errorf("signalling qemu process %d: %w", pid, err)
+		}
+	}
+
+	return nil
+}
+
+func (p *provider) Delete(ctx context.Context, name string) error {
+	pid, err := p.ss.GetPID()
+	if err != nil {
+		return fmt.Errorf("getting vm pid: %w", err)
+	}
+
+	if pid == 0 {
+		slog.Debug("pid not set for vm, skipping stop", "name", name)
+
+		return nil
+	}
+
+	if err := shared.StopProcess(ctx, pid); err != nil {
+		return fmt.Errorf("stopping qemu process: %w", err)
+	}
+
+	return nil
+}
+
+func (p *provider) AttachVolume(ctx context.Context, vm *domain.VM, volumeName string) error {
+	return errHotplugNotSupported
+}
+
+func (p *provider) DetachVolume(ctx context.Context, vm *domain.VM, volumeName string) error {
+	return errHotplugNotSupported
+}
+
+func (p *provider) Metrics(ctx context.Context, vm *domain.VM) ([]domain.Metric, error) {
+	return nil, errMetricsNotSupported
+}
+
+func (p *provider) Capabilities() ports.Capabilities {
+	// Qemu has vhost-vsock but not the unix socket vsock that the guest agent
+	// is reached on
+	return ports.Capabilities{
+		DiskFeatures: []ports.DiskFeature{
+			ports.DiskFeatureRawFile,
+			ports.DiskFeatureBlockDevice,
+			ports.DiskFeatureReadOnly,
+		},
+		NetworkFeatures: []ports.NetworkFeature{
+			ports.NetworkFeatureTap,
+		},
+	}
+}
@@ -0,0 +1,95 @@
+package qemu
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	qmpTimeout = 10 * time.Second
+)
+
+type qmpCommand struct {
+	Execute   string      `json:"execute"`
+	Arguments interface{} `json:"arguments,omitempty"`
+}
+
+type qmpResponse struct {
+	Return json.RawMessage `json:"return,omitempty"`
+	Error  *qmpError       `json:"error,omitempty"`
+	Event  string          `json:"event,omitempty"`
+}
+
+type qmpError struct {
+	Class       string `json:"class"`
+	Description string `json:"desc"`
+}
+
+// qmpExecute will connect to the QMP socket, negotiate capabilities and run a single command.
+// Its only used to stop the vm as there's no use case to pause a vm or get its status.
+func (p *provider) qmpExecute(ctx context.Context, command string, args interface{}) (json.RawMessage, error) {
+	dialer := net.Dialer{Timeout: qmpTimeout}
+	conn, err := dialer.DialContext(ctx, "unix", p.qmpSocketPath())
+	if err != nil {
+		return nil, fmt.Errorf("connecting to qmp socket %s: %w", p.qmpSocketPath(), err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(qmpTimeout))
+	}
+
+	reader := bufio.NewReader(conn)
+
+	// Read the greeting
+	if _, err := reader.ReadBytes('\n'); err != nil {
+		return nil, fmt.Errorf("reading qmp greeting: %w", err)
+	}
+
+	if _, err := qmpSend(conn, reader, qmpCommand{Execute: "qmp_capabilities"}); err != nil {
+		return nil, fmt.Errorf("negotiating qmp capabilities: %w", err)
+	}
+
+	return qmpSend(conn, reader, qmpCommand{Execute: command, Arguments: args})
+}
+
+func qmpSend(conn net.Conn, reader *bufio.Reader, cmd qmpCommand) (json.RawMessage, error) {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling qmp command %s: %w", cmd.Execute, err)
+	}
+
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		return nil, fmt.Errorf("sending qmp command %s: %w", cmd.Execute, err)
+	}
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return nil, fmt.Errorf("reading qmp response for %s: %w", cmd.Execute, err)
+		}
+
+		resp := qmpResponse{}
+		if err := json.Unmarshal(line, &resp); err != nil {
+			return nil, fmt.Errorf("unmarshalling qmp response for %s: %w", cmd.Execute, err)
+		}
+
+		// Events can be received at any time, so skip them
+		if resp.Event != "" {
+			continue
+		}
+
+		if resp.Error != nil {
+			return nil, errors.New(resp.Error.Description)
+		}
+
+		return resp.Return, nil
+	}
+}
@@ -2,7 +2,10 @@ package shared
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"path"
 	"path/filepath"
 
 	"github.com/mikrolite/mikrolite/cloudinit"
@@ -10,7 +13,13 @@ import (
 	"github.com/mikrolite/mikrolite/core/ports"
 )
 
+// CreateCloudInitImage creates the drive with the cloud-init metadata, in the
+// config drive layout of the vm.
 func CreateCloudInitImage(ctx context.Context, includeNetworkConfig bool, vm *domain.VM, ss ports.StateService, ds ports.DiskService) (string, error) {
+	if vm.Spec.ConfigDrive() == domain.ConfigDriveOpenStack {
+		return createOpenStackImage(ctx, vm, ss, ds)
+	}
+
 	cloudInitFile := filepath.Join(ss.Root(), "cloud-init.img")
 
 	files := []ports.DiskFile{}
@@ -30,11 +39,16 @@ func CreateCloudInitImage(ctx context.Context, includeNetworkConfig bool, vm *do
 		})
 	}
 
+	diskType := ports.DiskTypeFat32
+	if vm.Spec.ConfigDrive() == domain.ConfigDriveNoCloudISO {
+		diskType = ports.DiskTypeISO9660
+	}
+
 	input := ports.DiskCreateInput{
 		Path:       cloudInitFile,
 		Size:       "8Mb",
 		VolumeName: cloudinit.VolumeName,
-		Type:       ports.DiskTypeFat32,
+		Type:       diskType,
 		Overwrite:  true,
 		Files:      files,
 	}
@@ -44,3 +58,66 @@ func CreateCloudInitImage(ctx context.Context, includeNetworkConfig bool, vm *do
 
 	return cloudInitFile, nil
 }
+
+// openStackMetadata is the meta_data.json of the OpenStack config drive.
+type openStackMetadata struct {
+	UUID     string `json:"uuid"`
+	Name     string `json:"name"`
+	Hostname string `json:"hostname"`
+}
+
+// createOpenStackImage creates an OpenStack config drive with the cloud-init
+// metadata. The network config isn't on the drive as OpenStack has its own
+// format for it, its passed on the kernel cmdline instead.
+func createOpenStackImage(ctx context.Context, vm *domain.VM, ss ports.StateService, ds ports.DiskService) (string, error) {
+	configDriveFile := filepath.Join(ss.Root(), "config-drive.img")
+
+	metadata, err := json.Marshal(&openStackMetadata{
+		UUID:     vm.Name,
+		Name:     vm.Name,
+		Hostname: vm.Name,
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshalling openstack metadata: %w", err)
+	}
+	files := []ports.DiskFile{{
+		Path:          path.Join(cloudinit.ConfigDriveDir, "meta_data.json"),
+		ContentBase64: base64.StdEncoding.EncodeToString(metadata),
+	}}
+
+	if userData, ok := vm.Status.Metadata[cloudinit.UserdataKey]; ok {
+		files = append(files, ports.DiskFile{
+			Path:          path.Join(cloudinit.ConfigDriveDir, "user_data"),
+			ContentBase64: userData,
+		})
+	}
+	// The vendor-data for cloud-init is under the cloud-init key
+	if vendorData, ok := vm.Status.Metadata[cloudinit.VendorDataKey]; ok {
+		decoded, err := base64.StdEncoding.DecodeString(vendorData)
+		if err != nil {
+			return "", fmt.Errorf("decoding vendor-data: %w", err)
+		}
+		data, err := json.Marshal(map[string]string{"cloud-init": string(decoded)})
+		if err != nil {
+			return "", fmt.Errorf("marshalling openstack vendor data: %w", err)
+		}
+		files = append(files, ports.DiskFile{
+			Path:          path.Join(cloudinit.ConfigDriveDir, "vendor_data.json"),
+			ContentBase64: base64.StdEncoding.EncodeToString(data),
+		})
+	}
+
+	input := ports.DiskCreateInput{
+		Path:       configDriveFile,
+		Size:       "8Mb",
+		VolumeName: cloudinit.ConfigDriveVolumeName,
+		Type:       ports.DiskTypeISO9660,
+		Overwrite:  true,
+		Files:      files,
+	}
+	if err := ds.Create(ctx, input); err != nil {
+		return "", fmt.Errorf("creating config drive %s: %w", configDriveFile, err)
+	}
+
+	return configDriveFile, nil
+}
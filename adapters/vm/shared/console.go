@@ -0,0 +1,37 @@
+package shared
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mikrolite/mikrolite/adapters/console"
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+// StartConsole starts serving the serial console of the vm and records the
+// socket in the vm status. The returned tty must be used as the stdin and stdout
+// of the vm process and closed once the process has started. The console
+// output is appended to the stdout file.
+//
+// The vm process must write its log to the fifo at LogFIFOPath, which is
+// appended to the log file so that it can be rotated. Any other pipes the vm
+// process writes to are copied in the same way.
+func StartConsole(vm *domain.VM, ss ports.StateService, pipes ...console.Pipe) (*os.File, error) {
+	socketPath := filepath.Join(ss.Root(), "console.sock")
+	logPipe := console.Pipe{FIFO: LogFIFOPath(ss), File: ss.LogPath()}
+
+	tty, err := console.Start(socketPath, ss.StdoutPath(), append([]console.Pipe{logPipe}, pipes...)...)
+	if err != nil {
+		return nil, fmt.Errorf("starting console: %w", err)
+	}
+	vm.Status.ConsoleSocket = socketPath
+
+	return tty, nil
+}
+
+// LogFIFOPath returns the path of the fifo the vm process writes its log to.
+func LogFIFOPath(ss ports.StateService) string {
+	return filepath.Join(ss.Root(), "vm.log.fifo")
+}
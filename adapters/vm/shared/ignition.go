@@ -0,0 +1,90 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+	"github.com/mikrolite/mikrolite/ignition"
+)
+
+// CreateBootstrapImage creates the disk image that the guest reads its
+// bootstrap config from, in the format that the guest uses.
+func CreateBootstrapImage(ctx context.Context, includeNetworkConfig bool, vm *domain.VM, ss ports.StateService, ds ports.DiskService) (string, error) {
+	if vm.Spec.BootstrapFormat() == domain.BootstrapFormatIgnition {
+		return CreateIgnitionImage(ctx, vm, ss, ds)
+	}
+
+	return CreateCloudInitImage(ctx, includeNetworkConfig, vm, ss, ds)
+}
+
+// CreateIgnitionImage creates a config drive with the Ignition config, which
+// Ignition reads on the openstack platform. Its an ISO9660 image if the vm uses
+// the openstack config drive.
+func CreateIgnitionImage(ctx context.Context, vm *domain.VM, ss ports.StateService, ds ports.DiskService) (string, error) {
+	configDriveFile := filepath.Join(ss.Root(), "config-drive.img")
+
+	files := []ports.DiskFile{}
+	if config, ok := vm.Status.Metadata[ignition.ConfigKey]; ok {
+		files = append(files, ports.DiskFile{
+			Path:          ignition.ConfigDrivePath,
+			ContentBase64: config,
+		})
+	}
+
+	diskType := ports.DiskTypeFat32
+	if vm.Spec.ConfigDrive() == domain.ConfigDriveOpenStack {
+		diskType = ports.DiskTypeISO9660
+	}
+
+	input := ports.DiskCreateInput{
+		Path:       configDriveFile,
+		Size:       "8Mb",
+		VolumeName: ignition.ConfigDriveLabel,
+		Type:       diskType,
+		Overwrite:  true,
+		Files:      files,
+	}
+	if err := ds.Create(ctx, input); err != nil {
+		return "", fmt.Errorf("creating config drive %s: %w", configDriveFile, err)
+	}
+
+	return configDriveFile, nil
+}
+
+// AddIgnitionKernelArgs adds the kernel args that make Ignition run when the vm
+// boots. Ignition reads the config from the config drive on the openstack
+// platform, or from the metadata service on the metal platform if the url is
+// supplied.
+func AddIgnitionKernelArgs(vm *domain.VM, cmdLine *domain.KernelCmdLine, configURL string) error {
+	// Ignition runs from the initramfs before the cloud-init datasource is used
+	cmdLine.Delete("ds")
+
+	cmdLine.Set("ignition.firstboot", "")
+	if configURL == "" {
+		cmdLine.Set("ignition.platform.id", "openstack")
+
+		return nil
+	}
+
+	cmdLine.Set("ignition.platform.id", "metal")
+	cmdLine.Set("ignition.config.url", configURL)
+
+	// The initramfs needs the interface that can reach the metadata service
+	for _, netInt := range vm.Spec.NetworkConfiguration.Interfaces {
+		if !netInt.AllowMetadataRequests || netInt.StaticIPv4Address == nil {
+			continue
+		}
+		ip, ipNet, err := net.ParseCIDR(netInt.StaticIPv4Address.Address)
+		if err != nil {
+			return fmt.Errorf("parsing metadata interface address %s: %w", netInt.StaticIPv4Address.Address, err)
+		}
+		cmdLine.Set("ip", fmt.Sprintf("%s:::%s::%s:off", ip, net.IP(ipNet.Mask), netInt.GuestDeviceName))
+		cmdLine.Set("rd.neednet", "1")
+	}
+
+	return nil
+}
@@ -1,20 +1,17 @@
 package shared
 
 import (
-	"fmt"
-	"strings"
-)
+	"path/filepath"
 
-func FormatKernelCmdLine(args map[string]string) string {
-	output := []string{}
+	"github.com/mikrolite/mikrolite/core/domain"
+)
 
-	for key, value := range args {
-		if value == "" {
-			output = append(output, key)
-		} else {
-			output = append(output, fmt.Sprintf("%s=%s", key, value))
-		}
+// InitrdPath returns the path of the initrd of the vm on the host, its empty if
+// the vm doesn't have one.
+func InitrdPath(vm *domain.VM) string {
+	if vm.Spec.Kernel.Initrd == nil || vm.Status.InitrdMount == nil {
+		return ""
 	}
 
-	return strings.Join(output, " ")
+	return filepath.Join(vm.Status.InitrdMount.Location, vm.Spec.Kernel.Initrd.Filename)
 }
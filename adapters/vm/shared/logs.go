@@ -0,0 +1,19 @@
+package shared
+
+import (
+	"fmt"
+
+	"github.com/mikrolite/mikrolite/adapters/logs"
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+// RotateLogs rotates the log files of the vm that have grown too big. Its
+// called before the vm process is started as the hypervisor logs are only
+// rotated by mikrolited while the vm is running.
+func RotateLogs(ss ports.StateService) error {
+	if err := logs.RotateVM(ss); err != nil {
+		return fmt.Errorf("rotating logs: %w", err)
+	}
+
+	return nil
+}
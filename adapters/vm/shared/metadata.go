@@ -0,0 +1,41 @@
+package shared
+
+import (
+	"github.com/mikrolite/mikrolite/cloudinit"
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/ignition"
+)
+
+// AddMetadataKernelArgs adds the kernel args that make the guest get its
+// bootstrap config from the metadata service at the url. The network config is
+// on the kernel cmdline as its needed to reach the metadata service. The Ec2
+// datasource always uses the EC2 metadata address.
+func AddMetadataKernelArgs(vm *domain.VM, cmdLine *domain.KernelCmdLine, metadataURL string) error {
+	if vm.Spec.BootstrapFormat() == domain.BootstrapFormatIgnition {
+		return AddIgnitionKernelArgs(vm, cmdLine, metadataURL+ignition.ConfigKey)
+	}
+
+	if vm.Spec.Datasource() == domain.DatasourceEC2 {
+		cmdLine.Set("ci.ds", "Ec2")
+	} else {
+		cmdLine.Set("ds", "nocloud-net;s="+metadataURL)
+	}
+	cmdLine.Set(cloudinit.NetworkConfigDataKey, vm.Status.Metadata[cloudinit.NetworkConfigDataKey])
+
+	return nil
+}
+
+// AddConfigDriveKernelArgs adds the kernel args that the guest needs to read its
+// bootstrap config from the drive created by CreateBootstrapImage.
+func AddConfigDriveKernelArgs(vm *domain.VM, cmdLine *domain.KernelCmdLine) error {
+	if vm.Spec.BootstrapFormat() == domain.BootstrapFormatIgnition {
+		return AddIgnitionKernelArgs(vm, cmdLine, "")
+	}
+
+	// The OpenStack layout doesn't have the network config
+	if vm.Spec.ConfigDrive() == domain.ConfigDriveOpenStack {
+		cmdLine.Set(cloudinit.NetworkConfigDataKey, vm.Status.Metadata[cloudinit.NetworkConfigDataKey])
+	}
+
+	return nil
+}
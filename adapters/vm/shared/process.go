@@ -1,10 +1,23 @@
 package shared
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+const (
+	maxReasonLength = 256
 )
 
 func StopProcess(ctx context.Context, pid int) error {
@@ -20,3 +33,89 @@ func StopProcess(ctx context.Context, pid int) error {
 
 	return nil
 }
+
+// RecordExit waits for the vm process to exit in the background and saves the
+// exit to the state. The exit is only recorded if the current process is still
+// running when the vm process exits (e.g. when running in the daemon).
+func RecordExit(ss ports.StateService, wait func() error) {
+	go func() {
+		exit := ExitFromError(wait())
+		if !exit.Succeeded() {
+			if line := lastLine(ss.StderrPath()); line != "" {
+				exit.Reason = fmt.Sprintf("%s: %s", exit.Reason, line)
+			}
+		}
+
+		if err := ss.SaveExit(exit); err != nil {
+			// The state is removed when the vm is removed
+			slog.Debug("failed to save vm process exit", "error", err)
+		}
+	}()
+}
+
+// ExitFromError converts the error returned from waiting for a process to the
+// process exit.
+func ExitFromError(err error) *domain.ProcessExit {
+	exit := &domain.ProcessExit{
+		Time: time.Now().UTC(),
+	}
+	if err == nil {
+		code := 0
+		exit.ExitCode = &code
+		exit.Reason = "exited with code 0"
+
+		return exit
+	}
+
+	exitErr := &exec.ExitError{}
+	if !errors.As(err, &exitErr) {
+		exit.Reason = err.Error()
+
+		return exit
+	}
+
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		exit.Signal = status.Signal().String()
+		exit.Reason = fmt.Sprintf("killed by signal: %s", exit.Signal)
+
+		return exit
+	}
+
+	code := exitErr.ExitCode()
+	exit.ExitCode = &code
+	exit.Reason = fmt.Sprintf("exited with code %d", code)
+
+	return exit
+}
+
+// RemoveStaleSocket removes an api socket left behind by a vm process that has exited.
+func RemoveStaleSocket(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing socket %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// lastLine returns the last non-empty line of the file.
+func lastLine(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	last := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			last = line
+		}
+	}
+
+	if len(last) > maxReasonLength {
+		last = last[:maxReasonLength]
+	}
+
+	return last
+}
@@ -0,0 +1,27 @@
+package shared
+
+import (
+	"path/filepath"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+// GuestCID is the context id of the vm. Each vm has its own hybrid vsock
+// socket, so they can all use the same one.
+const GuestCID = 3
+
+// PrepareVSock removes the vsock socket left behind by the last time the vm was
+// started and records the vsock device in the vm status.
+func PrepareVSock(vm *domain.VM, ss ports.StateService) (*domain.VSockStatus, error) {
+	vsock := &domain.VSockStatus{
+		CID:    GuestCID,
+		Socket: filepath.Join(ss.Root(), "vsock.sock"),
+	}
+	if err := RemoveStaleSocket(vsock.Socket); err != nil {
+		return nil, err
+	}
+	vm.Status.VSock = vsock
+
+	return vsock, nil
+}
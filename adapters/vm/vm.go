@@ -2,9 +2,13 @@ package vm
 
 import (
 	"errors"
+	"fmt"
+	"slices"
 
 	"github.com/mikrolite/mikrolite/adapters/vm/cloudhypervisor"
 	"github.com/mikrolite/mikrolite/adapters/vm/firecracker"
+	"github.com/mikrolite/mikrolite/adapters/vm/plugin"
+	"github.com/mikrolite/mikrolite/adapters/vm/qemu"
 	"github.com/mikrolite/mikrolite/core/ports"
 	"github.com/spf13/afero"
 )
@@ -15,6 +19,26 @@ type VMProviderProps struct {
 	Fs                 afero.Fs
 	FirecrackerBin     string
 	CloudHypervisorBin string
+	QemuBin            string
+	PluginPaths        []string
+}
+
+// ProviderNames returns the names of all the built-in vm providers and any
+// provider plugins found on the plugin paths.
+func ProviderNames(pluginPaths []string) []string {
+	names := []string{
+		firecracker.ProviderName,
+		cloudhypervisor.ProviderName,
+		qemu.ProviderName,
+	}
+
+	for _, name := range plugin.Discover(pluginPaths) {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	return names
 }
 
 func New(name string, props VMProviderProps) (ports.VMProvider, error) {
@@ -31,7 +55,22 @@ func New(name string, props VMProviderProps) (ports.VMProvider, error) {
 		}
 
 		return cloudhypervisor.New(props.CloudHypervisorBin, props.StateService, props.DiskSvc, props.Fs), nil
+	case qemu.ProviderName:
+		if props.QemuBin == "" {
+			return nil, errors.New("must supply a path to a qemu binary")
+		}
+
+		return qemu.New(props.QemuBin, props.StateService, props.DiskSvc, props.Fs), nil
 	default:
-		return nil, NewUnknownProvider(name)
+		pluginPath, err := plugin.Find(name, props.PluginPaths)
+		if err != nil {
+			if errors.Is(err, plugin.ErrNotFound) {
+				return nil, NewUnknownProvider(name)
+			}
+
+			return nil, fmt.Errorf("finding provider plugin %s: %w", name, err)
+		}
+
+		return plugin.New(name, pluginPath, props.StateService), nil
 	}
 }
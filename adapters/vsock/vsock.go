@@ -0,0 +1,165 @@
+// Package vsock connects to vms using the hybrid vsock of firecracker and
+// cloud-hypervisor, where the vsock device is a unix socket on the host.
+package vsock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mikrolite/mikrolite/agent"
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+const (
+	// handshakeTimeout is how long to wait for the reply to CONNECT.
+	handshakeTimeout = 10 * time.Second
+	// maxReplyLength is the longest reply to CONNECT that is accepted.
+	maxReplyLength = 64
+)
+
+// Dial connects to the port in the vm. The host connects to the unix socket and
+// sends "CONNECT <port>\n", the vmm replies with "OK <host port>\n" once the vm
+// has accepted the connection.
+func Dial(ctx context.Context, socket string, port uint32) (net.Conn, error) {
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", socket, err)
+	}
+
+	deadline := time.Now().Add(handshakeTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if _, err := fmt.Fprintf(conn, "CONNECT %d\n", port); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sending connect: %w", err)
+	}
+	reply, err := readLine(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("connecting to vsock port %d: %w", port, err)
+	}
+	if !strings.HasPrefix(reply, "OK ") {
+		conn.Close()
+		return nil, fmt.Errorf("connecting to vsock port %d: unexpected reply %q", port, reply)
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// readLine reads the reply a byte at a time so that nothing after it is read.
+func readLine(conn net.Conn) (string, error) {
+	reply := make([]byte, 0, maxReplyLength)
+	b := make([]byte, 1)
+	for len(reply) < maxReplyLength {
+		if _, err := conn.Read(b); err != nil {
+			// The vmm closes the connection if nothing is listening on the port
+			return "", fmt.Errorf("reading reply: %w", err)
+		}
+		if b[0] == '\n' {
+			return string(reply), nil
+		}
+		reply = append(reply, b[0])
+	}
+
+	return "", errors.New("reply is too long")
+}
+
+// NewClient creates a client for the guest agent in the vm.
+func NewClient(vsock *domain.VSockStatus) *agent.Client {
+	return agent.NewClient(func(ctx context.Context) (net.Conn, error) {
+		return Dial(ctx, vsock.Socket, agent.Port)
+	})
+}
+
+// ListenPath returns the unix socket that connections from the vm to the port
+// on the host are made to.
+func ListenPath(vsock *domain.VSockStatus, port uint32) string {
+	return fmt.Sprintf("%s_%d", vsock.Socket, port)
+}
+
+// NewGuestAgentService creates a new guest agent service.
+func NewGuestAgentService() ports.GuestAgentService {
+	return &guestAgentService{}
+}
+
+type guestAgentService struct{}
+
+// WaitReady listens for the agent to connect to agent.ReadyPort. The agent
+// retries until the host is listening, so it doesn't matter if the vm booted
+// before this is called.
+func (s *guestAgentService) WaitReady(ctx context.Context, vsock *domain.VSockStatus) (*domain.GuestInfo, error) {
+	path := ListenPath(vsock, agent.ReadyPort)
+
+	// The socket is left behind by the last time the vm was started
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("removing %s: %w", path, err)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", path, err)
+	}
+	defer func() {
+		listener.Close()
+		os.Remove(path)
+	}()
+
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			return nil, fmt.Errorf("accepting connection: %w", err)
+		}
+
+		ready, err := acceptReady(ctx, conn)
+		if errors.Is(err, agent.ErrUnsupportedVersion) {
+			return nil, fmt.Errorf("guest agent: %w", err)
+		}
+		if err != nil {
+			// Something other than the agent connected, or it was restarted
+			// part way through
+			slog.Debug("receiving ready from guest agent", "error", err)
+			continue
+		}
+
+		return &ready.Info, nil
+	}
+}
+
+func acceptReady(ctx context.Context, conn net.Conn) (*agent.Ready, error) {
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if err := conn.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return nil, err
+	}
+
+	return agent.AcceptReady(conn)
+}
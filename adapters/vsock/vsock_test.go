@@ -0,0 +1,118 @@
+package vsock
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mikrolite/mikrolite/agent"
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+// startHybridVSock emulates the hybrid vsock of a vmm. The agent is served on
+// agent.Port and connections to other ports are refused.
+func startHybridVSock(t *testing.T) *domain.VSockStatus {
+	t.Helper()
+
+	vsock := &domain.VSockStatus{CID: 3, Socket: filepath.Join(t.TempDir(), "vsock.sock")}
+	listener, err := net.Listen("unix", vsock.Socket)
+	if err != nil {
+		t.Fatalf("listening: %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &agent.Server{Info: func() (*domain.GuestInfo, error) {
+		return &domain.GuestInfo{Hostname: "vm1"}, nil
+	}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				// The agent doesn't send anything before the request, so the
+				// buffered reader can't read past the connect line
+				line, err := bufio.NewReader(conn).ReadString('\n')
+				if err != nil || line != fmt.Sprintf("CONNECT %d\n", agent.Port) {
+					conn.Close()
+					return
+				}
+				fmt.Fprintf(conn, "OK %d\n", 1073741824)
+				server.ServeConn(context.Background(), conn)
+			}()
+		}
+	}()
+
+	return vsock
+}
+
+func TestDial(t *testing.T) {
+	vsock := startHybridVSock(t)
+	ctx := context.Background()
+
+	client := NewClient(vsock)
+	info, err := client.Info(ctx)
+	if err != nil {
+		t.Fatalf("getting info: %s", err)
+	}
+	if info.Hostname != "vm1" {
+		t.Errorf("expected hostname vm1, got %s", info.Hostname)
+	}
+
+	stdout := &bytes.Buffer{}
+	if err := client.Exec(ctx, agent.ExecRequest{Args: []string{"echo", "hello"}}, nil, stdout, &bytes.Buffer{}); err != nil {
+		t.Fatalf("running command: %s", err)
+	}
+	if stdout.String() != "hello\n" {
+		t.Errorf("expected hello, got %q", stdout.String())
+	}
+
+	if _, err := Dial(ctx, vsock.Socket, 2000); err == nil {
+		t.Errorf("expected connection to a port nothing listens on to fail")
+	}
+	if _, err := Dial(ctx, filepath.Join(t.TempDir(), "missing.sock"), agent.Port); err == nil {
+		t.Errorf("expected connection to a missing socket to fail")
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	vsock := &domain.VSockStatus{CID: 3, Socket: filepath.Join(t.TempDir(), "vsock.sock")}
+	svc := NewGuestAgentService()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The agent retries until the host is listening
+	go func() {
+		dial := func(ctx context.Context) (net.Conn, error) {
+			dialer := &net.Dialer{}
+			return dialer.DialContext(ctx, "unix", ListenPath(vsock, agent.ReadyPort))
+		}
+		info := func() (*domain.GuestInfo, error) {
+			return &domain.GuestInfo{Hostname: "vm1"}, nil
+		}
+		_ = agent.SignalReady(ctx, dial, info)
+	}()
+
+	info, err := svc.WaitReady(ctx, vsock)
+	if err != nil {
+		t.Fatalf("waiting for ready: %s", err)
+	}
+	if info.Hostname != "vm1" {
+		t.Errorf("expected hostname vm1, got %s", info.Hostname)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := svc.WaitReady(ctx, vsock); err == nil || !strings.Contains(err.Error(), "deadline") {
+		t.Errorf("expected wait to time out, got %v", err)
+	}
+}
@@ -0,0 +1,315 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+// startServer serves the agent on a unix socket and returns a client for it.
+func startServer(t *testing.T, server *Server) *Client {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("listening: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = server.Serve(ctx, listener)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return NewClient(func(ctx context.Context) (net.Conn, error) {
+		dialer := &net.Dialer{}
+		return dialer.DialContext(ctx, "unix", socket)
+	})
+}
+
+func TestExec(t *testing.T) {
+	client := startServer(t, &Server{})
+	dir := t.TempDir()
+
+	testCases := []struct {
+		name  string
+		req   ExecRequest
+		stdin string
+
+		expectStdout string
+		expectStderr string
+		expectCode   int
+		expectAnyErr bool
+	}{
+		{
+			name:         "output is streamed",
+			req:          ExecRequest{Args: []string{"sh", "-c", "echo out; echo err >&2"}},
+			expectStdout: "out\n",
+			expectStderr: "err\n",
+		},
+		{
+			name:         "exit status is returned",
+			req:          ExecRequest{Args: []string{"sh", "-c", "echo failed >&2; exit 3"}},
+			expectStderr: "failed\n",
+			expectCode:   3,
+		},
+		{
+			name:         "stdin is sent",
+			req:          ExecRequest{Args: []string{"cat"}},
+			stdin:        strings.Repeat("a", chunkSize*2+10),
+			expectStdout: strings.Repeat("a", chunkSize*2+10),
+		},
+		{
+			name:         "stdin doesn't have to be read",
+			req:          ExecRequest{Args: []string{"true"}},
+			stdin:        "ignored",
+			expectStdout: "",
+		},
+		{
+			name:         "env and dir are used",
+			req:          ExecRequest{Args: []string{"sh", "-c", "echo $GREETING; pwd"}, Env: []string{"GREETING=hello"}, Dir: dir},
+			expectStdout: "hello\n" + dir + "\n",
+		},
+		{
+			name:         "signal is reported like a shell",
+			req:          ExecRequest{Args: []string{"sh", "-c", "kill -TERM $$"}},
+			expectCode:   128 + 15,
+			expectStdout: "",
+		},
+		{
+			name:         "missing command fails",
+			req:          ExecRequest{Args: []string{"does-not-exist"}},
+			expectAnyErr: true,
+		},
+		{
+			name:         "empty command fails",
+			req:          ExecRequest{},
+			expectAnyErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stdout := &bytes.Buffer{}
+			stderr := &bytes.Buffer{}
+
+			err := client.Exec(context.Background(), tc.req, strings.NewReader(tc.stdin), stdout, stderr)
+
+			exitErr := &ExitError{}
+			switch {
+			case tc.expectAnyErr:
+				if err == nil || errors.As(err, &exitErr) {
+					t.Fatalf("expected an error starting the command, got %v", err)
+				}
+				return
+			case tc.expectCode != 0:
+				if !errors.As(err, &exitErr) || exitErr.Code != tc.expectCode {
+					t.Fatalf("expected exit status %d, got %v", tc.expectCode, err)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("expected no error, got %s", err)
+				}
+			}
+
+			if stdout.String() != tc.expectStdout {
+				t.Errorf("expected stdout %q, got %q", tc.expectStdout, stdout.String())
+			}
+			if stderr.String() != tc.expectStderr {
+				t.Errorf("expected stderr %q, got %q", tc.expectStderr, stderr.String())
+			}
+		})
+	}
+}
+
+func TestExecCancelled(t *testing.T) {
+	client := startServer(t, &Server{})
+	marker := filepath.Join(t.TempDir(), "finished")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	// The command is killed when the connection is closed, so it never gets to
+	// create the file
+	req := ExecRequest{Args: []string{"sh", "-c", "sleep 1; touch " + marker}}
+	err := client.Exec(ctx, req, nil, &bytes.Buffer{}, &bytes.Buffer{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("expected the command to be killed")
+	}
+}
+
+func TestCopy(t *testing.T) {
+	client := startServer(t, &Server{})
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	content := strings.Repeat("mikrolite\n", chunkSize/5)
+
+	if err := client.Upload(ctx, path, 0o640, strings.NewReader(content)); err != nil {
+		t.Fatalf("uploading: %s", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("expected uploaded file: %s", err)
+	}
+	if info.Mode().Perm() != 0o640 {
+		t.Errorf("expected mode 0640, got %o", info.Mode().Perm())
+	}
+
+	// Uploading again replaces the file
+	if err := client.Upload(ctx, path, 0o600, strings.NewReader(content)); err != nil {
+		t.Fatalf("uploading again: %s", err)
+	}
+
+	downloaded := &bytes.Buffer{}
+	mode, err := client.Download(ctx, path, downloaded)
+	if err != nil {
+		t.Fatalf("downloading: %s", err)
+	}
+	if downloaded.String() != content {
+		t.Errorf("expected downloaded content to match, got %d bytes", downloaded.Len())
+	}
+	if mode != 0o600 {
+		t.Errorf("expected mode 0600, got %o", mode)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("reading dir: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected temporary files to be removed, got %d entries", len(entries))
+	}
+
+	if err := client.Upload(ctx, "relative.txt", 0o644, strings.NewReader("")); err == nil {
+		t.Errorf("expected relative upload path to fail")
+	}
+	if err := client.Upload(ctx, filepath.Join(dir, "missing", "file.txt"), 0o644, strings.NewReader(content)); err == nil {
+		t.Errorf("expected upload to missing directory to fail")
+	}
+	if _, err := client.Download(ctx, filepath.Join(dir, "missing.txt"), &bytes.Buffer{}); err == nil {
+		t.Errorf("expected download of missing file to fail")
+	}
+	if _, err := client.Download(ctx, dir, &bytes.Buffer{}); err == nil {
+		t.Errorf("expected download of directory to fail")
+	}
+}
+
+func TestInfo(t *testing.T) {
+	expected := &domain.GuestInfo{
+		Hostname:      "vm1",
+		Interfaces:    []domain.GuestInterface{{Name: "eth0", MAC: "aa:ff:00:00:00:01", Addresses: []string{"192.168.122.60/24"}}},
+		UptimeSeconds: 12.5,
+		LoadAverage:   []float64{0.1, 0.2, 0.3},
+	}
+	client := startServer(t, &Server{Info: func() (*domain.GuestInfo, error) { return expected, nil }})
+
+	info, err := client.Info(context.Background())
+	if err != nil {
+		t.Fatalf("getting info: %s", err)
+	}
+	if info.Hostname != "vm1" || info.UptimeSeconds != 12.5 || len(info.LoadAverage) != 3 {
+		t.Errorf("unexpected info %+v", info)
+	}
+	if ip := info.IPv4("AA:FF:00:00:00:01"); ip != "192.168.122.60" {
+		t.Errorf("expected ip 192.168.122.60, got %s", ip)
+	}
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	server, client := net.Pipe()
+	go (&Server{}).ServeConn(context.Background(), server)
+	defer client.Close()
+
+	c := newConn(client)
+	if err := c.send(&Request{Version: ProtocolVersion + 1, Method: MethodInfo}); err != nil {
+		t.Fatalf("sending request: %s", err)
+	}
+	msg := &Message{}
+	if err := c.receive(msg); err != nil {
+		t.Fatalf("receiving reply: %s", err)
+	}
+	if !strings.Contains(msg.Error, "unsupported protocol version") {
+		t.Errorf("expected unsupported version error, got %q", msg.Error)
+	}
+}
+
+func TestSignalReady(t *testing.T) {
+	host, guest := net.Pipe()
+	attempts := 0
+	dial := func(ctx context.Context) (net.Conn, error) {
+		attempts++
+		if attempts == 1 {
+			// The host isn't listening yet
+			return nil, errors.New("connection refused")
+		}
+
+		return guest, nil
+	}
+	info := func() (*domain.GuestInfo, error) {
+		return &domain.GuestInfo{Hostname: "vm1"}, nil
+	}
+
+	readyCh := make(chan *Ready, 1)
+	go func() {
+		ready, err := AcceptReady(host)
+		if err != nil {
+			t.Errorf("accepting ready: %s", err)
+		}
+		readyCh <- ready
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := SignalReady(ctx, dial, info); err != nil {
+		t.Fatalf("signalling ready: %s", err)
+	}
+
+	ready := <-readyCh
+	if ready == nil || ready.Info.Hostname != "vm1" || ready.Version != ProtocolVersion {
+		t.Errorf("unexpected ready %+v", ready)
+	}
+	if attempts != 2 {
+		t.Errorf("expected 2 attempts, got %d", attempts)
+	}
+}
+
+func TestParseProc(t *testing.T) {
+	uptime, err := parseUptime("350735.47 234388.90\n")
+	if err != nil || uptime != 350735.47 {
+		t.Errorf("expected uptime 350735.47, got %v %v", uptime, err)
+	}
+	if _, err := parseUptime(""); err == nil {
+		t.Errorf("expected empty uptime to fail")
+	}
+
+	load, err := parseLoadAverage("0.20 0.18 0.12 1/80 11206\n")
+	if err != nil || len(load) != 3 || load[0] != 0.20 || load[2] != 0.12 {
+		t.Errorf("expected load 0.20 0.18 0.12, got %v %v", load, err)
+	}
+	if _, err := parseLoadAverage("0.20 0.18"); err == nil {
+		t.Errorf("expected short load average to fail")
+	}
+	if _, err := parseLoadAverage("a b c"); err == nil {
+		t.Errorf("expected invalid load average to fail")
+	}
+}
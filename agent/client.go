@@ -0,0 +1,180 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+// Dialer opens a connection to the agent.
+type Dialer func(ctx context.Context) (net.Conn, error)
+
+// Client calls the agent in a vm.
+type Client struct {
+	dial Dialer
+}
+
+// NewClient creates a client that uses the dialer to connect to the agent.
+func NewClient(dial Dialer) *Client {
+	return &Client{dial: dial}
+}
+
+// call connects to the agent and sends the request. The connection is closed
+// if the context is cancelled, the returned func must be called to close it
+// otherwise.
+func (c *Client) call(ctx context.Context, req *Request) (*conn, func(), error) {
+	nc, err := c.dial(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connecting to the guest agent: %w", err)
+	}
+	stop := context.AfterFunc(ctx, func() { nc.Close() })
+	closeConn := func() {
+		stop()
+		nc.Close()
+	}
+
+	conn := newConn(nc)
+	req.Version = ProtocolVersion
+	if err := conn.send(req); err != nil {
+		closeConn()
+
+		return nil, nil, fmt.Errorf("sending %s request: %w", req.Method, err)
+	}
+
+	return conn, closeConn, nil
+}
+
+// Exec runs the command in the vm. The output of the command is written to
+// stdout and stderr as its received. An ExitError is returned if the command
+// exits with a non-zero status.
+func (c *Client) Exec(ctx context.Context, req ExecRequest, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	conn, closeConn, err := c.call(ctx, &Request{Method: MethodExec, Exec: &req})
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	if stdin == nil {
+		stdin = strings.NewReader("")
+	}
+	go func() {
+		_ = conn.sendStream(StreamStdin, stdin)
+	}()
+
+	for {
+		msg := &Message{}
+		if err := conn.receive(msg); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			return fmt.Errorf("receiving output: %w", err)
+		}
+
+		switch {
+		case msg.Error != "":
+			return remoteError(msg.Error)
+		case msg.Exit != nil:
+			if *msg.Exit != 0 {
+				return &ExitError{Code: *msg.Exit}
+			}
+
+			return nil
+		case msg.Stream == StreamStdout:
+			_, err = stdout.Write(msg.Data)
+		case msg.Stream == StreamStderr:
+			_, err = stderr.Write(msg.Data)
+		}
+		if err != nil {
+			return fmt.Errorf("writing output: %w", err)
+		}
+	}
+}
+
+// Upload writes the content of r to the file in the vm, replacing it if it
+// exists.
+func (c *Client) Upload(ctx context.Context, path string, mode os.FileMode, r io.Reader) error {
+	conn, closeConn, err := c.call(ctx, &Request{
+		Method: MethodUpload,
+		Upload: &UploadRequest{Path: path, Mode: uint32(mode.Perm())},
+	})
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	// If the agent fails it sends the error and closes the connection, so the
+	// reply is read even if sending the content failed
+	sendErr := conn.sendStream(StreamFile, r)
+
+	msg := &Message{}
+	if err := conn.receive(msg); err != nil {
+		if sendErr != nil {
+			return fmt.Errorf("sending %s: %w", path, sendErr)
+		}
+
+		return fmt.Errorf("receiving reply: %w", err)
+	}
+	if msg.Error != "" {
+		return remoteError(msg.Error)
+	}
+	if !msg.Done {
+		return errors.New("unexpected reply to upload")
+	}
+
+	return nil
+}
+
+// Download writes the content of the file in the vm to w and returns its mode.
+func (c *Client) Download(ctx context.Context, path string, w io.Writer) (os.FileMode, error) {
+	conn, closeConn, err := c.call(ctx, &Request{
+		Method:   MethodDownload,
+		Download: &DownloadRequest{Path: path},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer closeConn()
+
+	msg := &Message{}
+	if err := conn.receive(msg); err != nil {
+		return 0, fmt.Errorf("receiving reply: %w", err)
+	}
+	if msg.Error != "" {
+		return 0, remoteError(msg.Error)
+	}
+
+	if err := conn.receiveStream(StreamFile, w); err != nil {
+		return 0, fmt.Errorf("receiving %s: %w", path, err)
+	}
+
+	return os.FileMode(msg.Mode), nil
+}
+
+// Info returns the guest info.
+func (c *Client) Info(ctx context.Context) (*domain.GuestInfo, error) {
+	conn, closeConn, err := c.call(ctx, &Request{Method: MethodInfo})
+	if err != nil {
+		return nil, err
+	}
+	defer closeConn()
+
+	msg := &Message{}
+	if err := conn.receive(msg); err != nil {
+		return nil, fmt.Errorf("receiving reply: %w", err)
+	}
+	if msg.Error != "" {
+		return nil, remoteError(msg.Error)
+	}
+	if msg.Info == nil {
+		return nil, errors.New("unexpected reply to info")
+	}
+
+	return msg.Info, nil
+}
@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// conn sends and receives the messages on a connection. Messages can be sent
+// from several goroutines, e.g. for stdout and stderr.
+type conn struct {
+	net.Conn
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	dec *json.Decoder
+}
+
+func newConn(c net.Conn) *conn {
+	return &conn{
+		Conn: c,
+		enc:  json.NewEncoder(c),
+		dec:  json.NewDecoder(c),
+	}
+}
+
+func (c *conn) send(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.enc.Encode(v)
+}
+
+func (c *conn) receive(v interface{}) error {
+	return c.dec.Decode(v)
+}
+
+// streamWriter sends what is written to it as messages on the stream.
+type streamWriter struct {
+	c      *conn
+	stream Stream
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	for written := 0; written < len(p); {
+		end := min(written+chunkSize, len(p))
+		if err := w.c.send(&Message{Stream: w.stream, Data: p[written:end]}); err != nil {
+			return written, err
+		}
+		written = end
+	}
+
+	return len(p), nil
+}
+
+// sendStream sends r as messages on the stream, followed by EOF.
+func (c *conn) sendStream(stream Stream, r io.Reader) error {
+	if _, err := io.Copy(&streamWriter{c: c, stream: stream}, r); err != nil {
+		return err
+	}
+
+	return c.send(&Message{Stream: stream, EOF: true})
+}
+
+// receiveStream writes the data of the messages on the stream to w until EOF.
+func (c *conn) receiveStream(stream Stream, w io.Writer) error {
+	for {
+		msg := &Message{}
+		if err := c.receive(msg); err != nil {
+			return err
+		}
+		if msg.Error != "" {
+			return remoteError(msg.Error)
+		}
+		if msg.Stream != stream {
+			return fmt.Errorf("unexpected message for stream %q", msg.Stream)
+		}
+		if _, err := w.Write(msg.Data); err != nil {
+			return err
+		}
+		if msg.EOF {
+			return nil
+		}
+	}
+}
+
+// remoteError is an error reported by the other side of the connection.
+type remoteError string
+
+func (e remoteError) Error() string {
+	return string(e)
+}
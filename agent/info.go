@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+const (
+	uptimePath  = "/proc/uptime"
+	loadavgPath = "/proc/loadavg"
+)
+
+// CollectInfo returns the guest info of the system the agent is running on.
+func CollectInfo() (*domain.GuestInfo, error) {
+	info := &domain.GuestInfo{}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("getting hostname: %w", err)
+	}
+	info.Hostname = hostname
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("listing network interfaces: %w", err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		guestIface := domain.GuestInterface{
+			Name: iface.Name,
+			MAC:  iface.HardwareAddr.String(),
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("listing addresses of %s: %w", iface.Name, err)
+		}
+		for _, addr := range addrs {
+			guestIface.Addresses = append(guestIface.Addresses, addr.String())
+		}
+		info.Interfaces = append(info.Interfaces, guestIface)
+	}
+
+	data, err := os.ReadFile(uptimePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading uptime: %w", err)
+	}
+	if info.UptimeSeconds, err = parseUptime(string(data)); err != nil {
+		return nil, err
+	}
+
+	data, err = os.ReadFile(loadavgPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading load average: %w", err)
+	}
+	if info.LoadAverage, err = parseLoadAverage(string(data)); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// parseUptime parses /proc/uptime, e.g. "350735.47 234388.90".
+func parseUptime(data string) (float64, error) {
+	fields := strings.Fields(data)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("invalid uptime %q", data)
+	}
+
+	uptime, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing uptime %q: %w", data, err)
+	}
+
+	return uptime, nil
+}
+
+// parseLoadAverage parses /proc/loadavg, e.g. "0.20 0.18 0.12 1/80 11206".
+func parseLoadAverage(data string) ([]float64, error) {
+	fields := strings.Fields(data)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("invalid load average %q", data)
+	}
+
+	load := make([]float64, 3)
+	for i := range load {
+		value, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing load average %q: %w", data, err)
+		}
+		load[i] = value
+	}
+
+	return load, nil
+}
@@ -0,0 +1,139 @@
+// Package agent is the mikrolite guest agent and its client.
+//
+// The agent runs in the vm and is talked to over vsock, so that commands can be
+// run and files copied without the vm having working networking or a user that
+// can log in. It listens on Port and, once its started, signals that the vm has
+// booted by connecting to ReadyPort on the host.
+//
+// Each connection to the agent carries one request. The client sends a Request
+// and then both sides send Messages, encoded as JSON, until the request is done.
+package agent
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+const (
+	// ProtocolVersion is the version of the agent protocol. It will be increased
+	// if a breaking change is made to the protocol.
+	ProtocolVersion = 1
+
+	// Port is the vsock port the agent listens on in the vm.
+	Port = 1024
+	// ReadyPort is the vsock port on the host the agent connects to when its
+	// started.
+	ReadyPort = 1025
+	// HostCID is the context id of the host.
+	HostCID = 2
+
+	MethodExec     = "exec"
+	MethodUpload   = "upload"
+	MethodDownload = "download"
+	MethodInfo     = "info"
+
+	// chunkSize is the most data sent in a message.
+	chunkSize = 32 * 1024
+)
+
+// ErrUnsupportedVersion is returned when the other side uses a different
+// protocol version.
+var ErrUnsupportedVersion = errors.New("unsupported protocol version")
+
+// Stream identifies the data in a message.
+type Stream string
+
+const (
+	StreamStdin  Stream = "stdin"
+	StreamStdout Stream = "stdout"
+	StreamStderr Stream = "stderr"
+	// StreamFile is the content of a file being uploaded or downloaded.
+	StreamFile Stream = "file"
+)
+
+// Request is the first thing sent on a connection to the agent.
+type Request struct {
+	// Version is the protocol version used by the client.
+	Version int `json:"version"`
+	// Method is the method to call.
+	Method   string           `json:"method"`
+	Exec     *ExecRequest     `json:"exec,omitempty"`
+	Upload   *UploadRequest   `json:"upload,omitempty"`
+	Download *DownloadRequest `json:"download,omitempty"`
+}
+
+// ExecRequest runs a command in the vm. Stdin is sent to the agent and stdout
+// and stderr are sent back as the command writes them, followed by the exit
+// status.
+type ExecRequest struct {
+	// Args is the command and its arguments, its not run by a shell.
+	Args []string `json:"args"`
+	// Env are extra environment variables in the form key=value.
+	Env []string `json:"env,omitempty"`
+	// Dir is the working directory, the agent's if its empty.
+	Dir string `json:"dir,omitempty"`
+}
+
+// UploadRequest writes a file in the vm. The content is sent to the agent and
+// the file is replaced once all of it has been received.
+type UploadRequest struct {
+	// Path is the path of the file in the vm.
+	Path string `json:"path"`
+	// Mode is the permissions of the file.
+	Mode uint32 `json:"mode"`
+}
+
+// DownloadRequest reads a file in the vm. A message with the mode of the file is
+// sent back followed by its content.
+type DownloadRequest struct {
+	// Path is the path of the file in the vm.
+	Path string `json:"path"`
+}
+
+// Message is sent by both sides after the request.
+type Message struct {
+	// Stream identifies the data.
+	Stream Stream `json:"stream,omitempty"`
+	// Data is a chunk of the stream.
+	Data []byte `json:"data,omitempty"`
+	// EOF is set when there is no more data on the stream.
+	EOF bool `json:"eof,omitempty"`
+	// Exit is the exit status of the command, it ends an exec request.
+	Exit *int `json:"exit,omitempty"`
+	// Mode is the permissions of a downloaded file.
+	Mode uint32 `json:"mode,omitempty"`
+	// Info is the guest info, it ends an info request.
+	Info *domain.GuestInfo `json:"info,omitempty"`
+	// Done ends an upload request.
+	Done bool `json:"done,omitempty"`
+	// Error ends a request that failed.
+	Error string `json:"error,omitempty"`
+}
+
+// Ready is sent by the agent to ReadyPort on the host when its started. The host
+// replies with an empty message.
+type Ready struct {
+	// Version is the protocol version used by the agent.
+	Version int `json:"version"`
+	// Info is the guest info when the agent started.
+	Info domain.GuestInfo `json:"info"`
+}
+
+// ExitError is returned when a command run by the agent exits with a non-zero
+// status.
+type ExitError struct {
+	// Code is the exit status of the command, 128 plus the signal number if it
+	// was killed by a signal.
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("command exited with status %d", e.Code)
+}
+
+// ExitCode returns the exit status of the command.
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+// readyRetryInterval is how often the agent tries to signal that its ready.
+const readyRetryInterval = time.Second
+
+// SignalReady sends Ready to the host, retrying until the host replies or the
+// context is cancelled. The host may not be listening yet if the vm boots
+// quickly.
+func SignalReady(ctx context.Context, dial Dialer, info func() (*domain.GuestInfo, error)) error {
+	for {
+		err := signalReady(ctx, dial, info)
+		if err == nil {
+			return nil
+		}
+		slog.Debug("signalling ready", "error", err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("signalling ready: %w", err)
+		case <-time.After(readyRetryInterval):
+		}
+	}
+}
+
+func signalReady(ctx context.Context, dial Dialer, info func() (*domain.GuestInfo, error)) error {
+	guestInfo, err := info()
+	if err != nil {
+		return fmt.Errorf("collecting guest info: %w", err)
+	}
+
+	nc, err := dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	c := newConn(nc)
+	if err := c.send(&Ready{Version: ProtocolVersion, Info: *guestInfo}); err != nil {
+		return err
+	}
+
+	return c.receive(&Message{})
+}
+
+// AcceptReady receives Ready from the agent on the connection and replies to it.
+func AcceptReady(nc net.Conn) (*Ready, error) {
+	c := newConn(nc)
+
+	ready := &Ready{}
+	if err := c.receive(ready); err != nil {
+		return nil, fmt.Errorf("receiving ready: %w", err)
+	}
+	if ready.Version != ProtocolVersion {
+		return nil, fmt.Errorf("%w %d, expected %d", ErrUnsupportedVersion, ready.Version, ProtocolVersion)
+	}
+	if err := c.send(&Message{}); err != nil {
+		return nil, fmt.Errorf("replying to ready: %w", err)
+	}
+
+	return ready, nil
+}
@@ -0,0 +1,213 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+// Server serves the agent protocol.
+type Server struct {
+	// Info returns the guest info, CollectInfo is used if its nil.
+	Info func() (*domain.GuestInfo, error)
+}
+
+// Serve accepts connections on the listener and handles their requests until
+// the listener is closed or the context is cancelled.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		c, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return fmt.Errorf("accepting connection: %w", err)
+		}
+
+		go s.ServeConn(ctx, c)
+	}
+}
+
+// ServeConn handles the request on the connection and closes it.
+func (s *Server) ServeConn(ctx context.Context, nc net.Conn) {
+	defer nc.Close()
+	c := newConn(nc)
+
+	req := &Request{}
+	if err := c.receive(req); err != nil {
+		slog.Debug("reading request", "error", err)
+		return
+	}
+
+	var err error
+	switch {
+	case req.Version != ProtocolVersion:
+		err = fmt.Errorf("%w %d, the agent uses %d", ErrUnsupportedVersion, req.Version, ProtocolVersion)
+	case req.Method == MethodExec && req.Exec != nil:
+		err = s.exec(ctx, c, req.Exec)
+	case req.Method == MethodUpload && req.Upload != nil:
+		err = s.upload(c, req.Upload)
+	case req.Method == MethodDownload && req.Download != nil:
+		err = s.download(c, req.Download)
+	case req.Method == MethodInfo:
+		err = s.info(c)
+	default:
+		err = fmt.Errorf("unknown method %q", req.Method)
+	}
+	if err != nil {
+		slog.Debug("handling request", "method", req.Method, "error", err)
+		_ = c.send(&Message{Error: err.Error()})
+	}
+}
+
+func (s *Server) exec(ctx context.Context, c *conn, req *ExecRequest) error {
+	if len(req.Args) == 0 {
+		return errors.New("no command supplied")
+	}
+
+	cmd := exec.CommandContext(ctx, req.Args[0], req.Args[1:]...)
+	cmd.Env = append(os.Environ(), req.Env...)
+	cmd.Dir = req.Dir
+	cmd.Stdout = &streamWriter{c: c, stream: StreamStdout}
+	cmd.Stderr = &streamWriter{c: c, stream: StreamStderr}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting %s: %w", req.Args[0], err)
+	}
+
+	// Stdin is copied until the client sends EOF, after which the connection is
+	// still read so that the command is killed if its closed, e.g. because the
+	// client was interrupted.
+	go func() {
+		eof := false
+		for {
+			msg := &Message{}
+			if err := c.receive(msg); err != nil {
+				if !eof {
+					stdin.Close()
+				}
+				_ = cmd.Process.Kill()
+				return
+			}
+			if eof {
+				continue
+			}
+			// The command may not read all of stdin, so failed writes are ignored
+			_, _ = stdin.Write(msg.Data)
+			if msg.EOF {
+				eof = true
+				stdin.Close()
+			}
+		}
+	}()
+
+	code := 0
+	if err := cmd.Wait(); err != nil {
+		exitErr := &exec.ExitError{}
+		if !errors.As(err, &exitErr) {
+			return fmt.Errorf("running %s: %w", req.Args[0], err)
+		}
+		code = exitErr.ExitCode()
+		if code == -1 {
+			// Killed by a signal, reported like a shell does
+			code = 128 + int(exitSignal(exitErr))
+		}
+	}
+
+	return c.send(&Message{Exit: &code})
+}
+
+func (s *Server) upload(c *conn, req *UploadRequest) error {
+	if !filepath.IsAbs(req.Path) {
+		return fmt.Errorf("path %s isn't absolute", req.Path)
+	}
+
+	// The content is written to a temporary file so that the file isn't left
+	// half written if the upload fails
+	tmp, err := os.CreateTemp(filepath.Dir(req.Path), "."+filepath.Base(req.Path)+".*")
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", req.Path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	err = c.receiveStream(StreamFile, tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", req.Path, err)
+	}
+	if err := os.Chmod(tmp.Name(), os.FileMode(req.Mode).Perm()); err != nil {
+		return fmt.Errorf("setting mode of %s: %w", req.Path, err)
+	}
+	if err := os.Rename(tmp.Name(), req.Path); err != nil {
+		return fmt.Errorf("replacing %s: %w", req.Path, err)
+	}
+
+	return c.send(&Message{Done: true})
+}
+
+func (s *Server) download(c *conn, req *DownloadRequest) error {
+	file, err := os.Open(req.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s isn't a regular file", req.Path)
+	}
+
+	if err := c.send(&Message{Mode: uint32(info.Mode().Perm())}); err != nil {
+		return err
+	}
+	if err := c.sendStream(StreamFile, file); err != nil {
+		return fmt.Errorf("reading %s: %w", req.Path, err)
+	}
+
+	return nil
+}
+
+func (s *Server) info(c *conn) error {
+	collect := s.Info
+	if collect == nil {
+		collect = CollectInfo
+	}
+
+	info, err := collect()
+	if err != nil {
+		return fmt.Errorf("collecting guest info: %w", err)
+	}
+
+	return c.send(&Message{Info: info})
+}
+
+func exitSignal(exitErr *exec.ExitError) syscall.Signal {
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return status.Signal()
+	}
+
+	return 0
+}
@@ -0,0 +1,152 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Addr is a vsock address.
+type Addr struct {
+	CID  uint32
+	Port uint32
+}
+
+func (a *Addr) Network() string {
+	return "vsock"
+}
+
+func (a *Addr) String() string {
+	return fmt.Sprintf("%d:%d", a.CID, a.Port)
+}
+
+// Listen listens for vsock connections to the port. The net package doesn't
+// support vsock, so the socket is wrapped in an os.File to use the runtime's
+// poller.
+func Listen(port uint32) (net.Listener, error) {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("creating vsock socket: %w", err)
+	}
+
+	addr := &unix.SockaddrVM{CID: unix.VMADDR_CID_ANY, Port: port}
+	if err := unix.Bind(fd, addr); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("binding vsock port %d: %w", port, err)
+	}
+	if err := unix.Listen(fd, unix.SOMAXCONN); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("listening on vsock port %d: %w", port, err)
+	}
+
+	return &vsockListener{
+		file: os.NewFile(uintptr(fd), fmt.Sprintf("vsock:%d", port)),
+		addr: &Addr{CID: unix.VMADDR_CID_ANY, Port: port},
+	}, nil
+}
+
+// DialHost connects to the port on the host.
+func DialHost(ctx context.Context, port uint32) (net.Conn, error) {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("creating vsock socket: %w", err)
+	}
+
+	// The connect is blocking, vsock connections are accepted or refused by the
+	// hypervisor straight away
+	remote := &unix.SockaddrVM{CID: HostCID, Port: port}
+	if err := unix.Connect(fd, remote); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("connecting to vsock port %d on the host: %w", port, err)
+	}
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("setting vsock socket to non-blocking: %w", err)
+	}
+
+	return newVSockConn(fd, &Addr{CID: HostCID, Port: port})
+}
+
+type vsockListener struct {
+	file *os.File
+	addr *Addr
+}
+
+func (l *vsockListener) Accept() (net.Conn, error) {
+	raw, err := l.file.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		fd        int
+		sa        unix.Sockaddr
+		acceptErr error
+	)
+	err = raw.Read(func(lfd uintptr) bool {
+		fd, sa, acceptErr = unix.Accept4(int(lfd), unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC)
+		return !errors.Is(acceptErr, unix.EAGAIN)
+	})
+	if err != nil {
+		if errors.Is(err, os.ErrClosed) {
+			return nil, net.ErrClosed
+		}
+		return nil, err
+	}
+	if acceptErr != nil {
+		return nil, fmt.Errorf("accepting vsock connection: %w", acceptErr)
+	}
+
+	remote := &Addr{}
+	if vm, ok := sa.(*unix.SockaddrVM); ok {
+		remote = &Addr{CID: vm.CID, Port: vm.Port}
+	}
+
+	return newVSockConn(fd, remote)
+}
+
+func (l *vsockListener) Close() error {
+	return l.file.Close()
+}
+
+func (l *vsockListener) Addr() net.Addr {
+	return l.addr
+}
+
+// vsockConn is a vsock connection. The read, write, close and deadline methods
+// are those of the os.File.
+type vsockConn struct {
+	*os.File
+	local  *Addr
+	remote *Addr
+}
+
+func newVSockConn(fd int, remote *Addr) (net.Conn, error) {
+	local := &Addr{}
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("getting vsock address: %w", err)
+	}
+	if vm, ok := sa.(*unix.SockaddrVM); ok {
+		local = &Addr{CID: vm.CID, Port: vm.Port}
+	}
+
+	return &vsockConn{
+		File:   os.NewFile(uintptr(fd), "vsock:"+remote.String()),
+		local:  local,
+		remote: remote,
+	}, nil
+}
+
+func (c *vsockConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *vsockConn) RemoteAddr() net.Addr {
+	return c.remote
+}
@@ -0,0 +1,295 @@
+package v1alpha1
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+const (
+	// ServiceName is the full name of the grpc service.
+	ServiceName = "mikrolite.api.v1alpha1.VMService"
+)
+
+// VMServiceServer is the server side of the vm service.
+type VMServiceServer interface {
+	CreateVM(ctx context.Context, req *CreateVMRequest) (*VMResponse, error)
+	RemoveVM(ctx context.Context, req *RemoveVMRequest) (*Empty, error)
+	GetVM(ctx context.Context, req *GetVMRequest) (*VMResponse, error)
+	ListVMs(ctx context.Context, req *ListVMsRequest) (*ListVMsResponse, error)
+	AttachVolume(ctx context.Context, req *AttachVolumeRequest) (*VMResponse, error)
+	DetachVolume(ctx context.Context, req *DetachVolumeRequest) (*VMResponse, error)
+	StartVM(ctx context.Context, req *StartVMRequest) (*VMResponse, error)
+	EnableVM(ctx context.Context, req *EnableVMRequest) (*VMResponse, error)
+	DisableVM(ctx context.Context, req *DisableVMRequest) (*VMResponse, error)
+	StatsVM(ctx context.Context, req *StatsVMRequest) (*StatsVMResponse, error)
+	GC(ctx context.Context, req *GCRequest) (*GCResponse, error)
+	WatchEvents(req *WatchEventsRequest, stream VMService_WatchEventsServer) error
+}
+
+// VMService_WatchEventsServer is the server side stream of events.
+type VMService_WatchEventsServer interface {
+	Send(event *Event) error
+	grpc.ServerStream
+}
+
+// RegisterVMServiceServer registers the vm service with the grpc server.
+func RegisterVMServiceServer(s grpc.ServiceRegistrar, srv VMServiceServer) {
+	s.RegisterService(&vmServiceDesc, srv)
+}
+
+var vmServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*VMServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "CreateVM", Handler: unaryHandler(func(srv VMServiceServer, ctx context.Context, req *CreateVMRequest) (interface{}, error) {
+			return srv.CreateVM(ctx, req)
+		})},
+		{MethodName: "RemoveVM", Handler: unaryHandler(func(srv VMServiceServer, ctx context.Context, req *RemoveVMRequest) (interface{}, error) {
+			return srv.RemoveVM(ctx, req)
+		})},
+		{MethodName: "GetVM", Handler: unaryHandler(func(srv VMServiceServer, ctx context.Context, req *GetVMRequest) (interface{}, error) {
+			return srv.GetVM(ctx, req)
+		})},
+		{MethodName: "ListVMs", Handler: unaryHandler(func(srv VMServiceServer, ctx context.Context, req *ListVMsRequest) (interface{}, error) {
+			return srv.ListVMs(ctx, req)
+		})},
+		{MethodName: "AttachVolume", Handler: unaryHandler(func(srv VMServiceServer, ctx context.Context, req *AttachVolumeRequest) (interface{}, error) {
+			return srv.AttachVolume(ctx, req)
+		})},
+		{MethodName: "DetachVolume", Handler: unaryHandler(func(srv VMServiceServer, ctx context.Context, req *DetachVolumeRequest) (interface{}, error) {
+			return srv.DetachVolume(ctx, req)
+		})},
+		{MethodName: "StartVM", Handler: unaryHandler(func(srv VMServiceServer, ctx context.Context, req *StartVMRequest) (interface{}, error) {
+			return srv.StartVM(ctx, req)
+		})},
+		{MethodName: "EnableVM", Handler: unaryHandler(func(srv VMServiceServer, ctx context.Context, req *EnableVMRequest) (interface{}, error) {
+			return srv.EnableVM(ctx, req)
+		})},
+		{MethodName: "DisableVM", Handler: unaryHandler(func(srv VMServiceServer, ctx context.Context, req *DisableVMRequest) (interface{}, error) {
+			return srv.DisableVM(ctx, req)
+		})},
+		{MethodName: "StatsVM", Handler: unaryHandler(func(srv VMServiceServer, ctx context.Context, req *StatsVMRequest) (interface{}, error) {
+			return srv.StatsVM(ctx, req)
+		})},
+		{MethodName: "GC", Handler: unaryHandler(func(srv VMServiceServer, ctx context.Context, req *GCRequest) (interface{}, error) {
+			return srv.GC(ctx, req)
+		})},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchEvents",
+			Handler:       watchEventsHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "mikrolite/api/v1alpha1",
+}
+
+// unaryHandler creates the grpc handler for a unary method. The request type
+// is used to decode the incoming message.
+func unaryHandler[Req any](call func(srv VMServiceServer, ctx context.Context, req *Req) (interface{}, error)) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		req := new(Req)
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(VMServiceServer), ctx, req)
+		}
+
+		info := &grpc.UnaryServerInfo{
+			Server: srv,
+		}
+		if method, ok := grpc.Method(ctx); ok {
+			info.FullMethod = method
+		}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return call(srv.(VMServiceServer), ctx, req.(*Req))
+		}
+
+		return interceptor(ctx, req, info, handler)
+	}
+}
+
+func watchEventsHandler(srv interface{}, stream grpc.ServerStream) error {
+	req := &WatchEventsRequest{}
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+
+	return srv.(VMServiceServer).WatchEvents(req, &watchEventsServer{stream})
+}
+
+type watchEventsServer struct {
+	grpc.ServerStream
+}
+
+func (s *watchEventsServer) Send(event *Event) error {
+	return s.ServerStream.SendMsg(event)
+}
+
+// VMServiceClient is the client side of the vm service.
+type VMServiceClient interface {
+	CreateVM(ctx context.Context, req *CreateVMRequest, opts ...grpc.CallOption) (*VMResponse, error)
+	RemoveVM(ctx context.Context, req *RemoveVMRequest, opts ...grpc.CallOption) (*Empty, error)
+	GetVM(ctx context.Context, req *GetVMRequest, opts ...grpc.CallOption) (*VMResponse, error)
+	ListVMs(ctx context.Context, req *ListVMsRequest, opts ...grpc.CallOption) (*ListVMsResponse, error)
+	AttachVolume(ctx context.Context, req *AttachVolumeRequest, opts ...grpc.CallOption) (*VMResponse, error)
+	DetachVolume(ctx context.Context, req *DetachVolumeRequest, opts ...grpc.CallOption) (*VMResponse, error)
+	StartVM(ctx context.Context, req *StartVMRequest, opts ...grpc.CallOption) (*VMResponse, error)
+	EnableVM(ctx context.Context, req *EnableVMRequest, opts ...grpc.CallOption) (*VMResponse, error)
+	DisableVM(ctx context.Context, req *DisableVMRequest, opts ...grpc.CallOption) (*VMResponse, error)
+	StatsVM(ctx context.Context, req *StatsVMRequest, opts ...grpc.CallOption) (*StatsVMResponse, error)
+	GC(ctx context.Context, req *GCRequest, opts ...grpc.CallOption) (*GCResponse, error)
+	WatchEvents(ctx context.Context, req *WatchEventsRequest, opts ...grpc.CallOption) (VMService_WatchEventsClient, error)
+}
+
+// VMService_WatchEventsClient is the client side stream of events.
+type VMService_WatchEventsClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+// NewVMServiceClient creates a client for the vm service. The connection must use
+// the json codec.
+func NewVMServiceClient(cc grpc.ClientConnInterface) VMServiceClient {
+	return &vmServiceClient{cc: cc}
+}
+
+type vmServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func (c *vmServiceClient) CreateVM(ctx context.Context, req *CreateVMRequest, opts ...grpc.CallOption) (*VMResponse, error) {
+	out := &VMResponse{}
+	if err := c.cc.Invoke(ctx, methodName("CreateVM"), req, out, opts...); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *vmServiceClient) RemoveVM(ctx context.Context, req *RemoveVMRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := &Empty{}
+	if err := c.cc.Invoke(ctx, methodName("RemoveVM"), req, out, opts...); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *vmServiceClient) GetVM(ctx context.Context, req *GetVMRequest, opts ...grpc.CallOption) (*VMResponse, error) {
+	out := &VMResponse{}
+	if err := c.cc.Invoke(ctx, methodName("GetVM"), req, out, opts...); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *vmServiceClient) ListVMs(ctx context.Context, req *ListVMsRequest, opts ...grpc.CallOption) (*ListVMsResponse, error) {
+	out := &ListVMsResponse{}
+	if err := c.cc.Invoke(ctx, methodName("ListVMs"), req, out, opts...); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *vmServiceClient) AttachVolume(ctx context.Context, req *AttachVolumeRequest, opts ...grpc.CallOption) (*VMResponse, error) {
+	out := &VMResponse{}
+	if err := c.cc.Invoke(ctx, methodName("AttachVolume"), req, out, opts...); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *vmServiceClient) DetachVolume(ctx context.Context, req *DetachVolumeRequest, opts ...grpc.CallOption) (*VMResponse, error) {
+	out := &VMResponse{}
+	if err := c.cc.Invoke(ctx, methodName("DetachVolume"), req, out, opts...); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *vmServiceClient) StartVM(ctx context.Context, req *StartVMRequest, opts ...grpc.CallOption) (*VMResponse, error) {
+	out := &VMResponse{}
+	if err := c.cc.Invoke(ctx, methodName("StartVM"), req, out, opts...); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *vmServiceClient) EnableVM(ctx context.Context, req *EnableVMRequest, opts ...grpc.CallOption) (*VMResponse, error) {
+	out := &VMResponse{}
+	if err := c.cc.Invoke(ctx, methodName("EnableVM"), req, out, opts...); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *vmServiceClient) DisableVM(ctx context.Context, req *DisableVMRequest, opts ...grpc.CallOption) (*VMResponse, error) {
+	out := &VMResponse{}
+	if err := c.cc.Invoke(ctx, methodName("DisableVM"), req, out, opts...); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *vmServiceClient) StatsVM(ctx context.Context, req *StatsVMRequest, opts ...grpc.CallOption) (*StatsVMResponse, error) {
+	out := &StatsVMResponse{}
+	if err := c.cc.Invoke(ctx, methodName("StatsVM"), req, out, opts...); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *vmServiceClient) GC(ctx context.Context, req *GCRequest, opts ...grpc.CallOption) (*GCResponse, error) {
+	out := &GCResponse{}
+	if err := c.cc.Invoke(ctx, methodName("GC"), req, out, opts...); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *vmServiceClient) WatchEvents(ctx context.Context, req *WatchEventsRequest, opts ...grpc.CallOption) (VMService_WatchEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &vmServiceDesc.Streams[0], methodName("WatchEvents"), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &watchEventsClient{stream}
+	if err := client.SendMsg(req); err != nil {
+		return nil, err
+	}
+	if err := client.CloseSend(); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+type watchEventsClient struct {
+	grpc.ClientStream
+}
+
+func (c *watchEventsClient) Recv() (*Event, error) {
+	event := &Event{}
+	if err := c.ClientStream.RecvMsg(event); err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
+
+func methodName(method string) string {
+	return "/" + ServiceName + "/" + method
+}
@@ -0,0 +1,138 @@
+// Package v1alpha1 is version v1alpha1 of the mikrolited api.
+//
+// The api is a grpc service that uses json encoded messages, so that it can be
+// used without generated code. The same operations are exposed as a REST api.
+package v1alpha1
+
+import (
+	"time"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+const (
+	// Version is the version of the api.
+	Version = "v1alpha1"
+)
+
+type CreateVMRequest struct {
+	Name  string         `json:"name"`
+	Owner string         `json:"owner,omitempty"`
+	Spec  *domain.VMSpec `json:"spec"`
+	// WaitFor is what to wait for before the vm is returned, ip if its empty.
+	WaitFor domain.WaitFor `json:"waitFor,omitempty"`
+	// WaitTimeoutSeconds is how long to wait, the default for WaitFor is used
+	// if its 0.
+	WaitTimeoutSeconds int64 `json:"waitTimeoutSeconds,omitempty"`
+}
+
+type RemoveVMRequest struct {
+	Name  string `json:"name"`
+	Owner string `json:"owner,omitempty"`
+}
+
+type GetVMRequest struct {
+	Name string `json:"name"`
+}
+
+type ListVMsRequest struct{}
+
+type ListVMsResponse struct {
+	VMs []*domain.VM `json:"vms"`
+}
+
+type AttachVolumeRequest struct {
+	Name   string         `json:"name"`
+	Owner  string         `json:"owner,omitempty"`
+	Volume *domain.Volume `json:"volume"`
+}
+
+type DetachVolumeRequest struct {
+	Name       string `json:"name"`
+	Owner      string `json:"owner,omitempty"`
+	VolumeName string `json:"volume_name"`
+}
+
+type StartVMRequest struct {
+	Name  string `json:"name"`
+	Owner string `json:"owner,omitempty"`
+}
+
+type EnableVMRequest struct {
+	Name string `json:"name"`
+}
+
+type DisableVMRequest struct {
+	Name string `json:"name"`
+}
+
+type StatsVMRequest struct {
+	Name string `json:"name"`
+}
+
+type StatsVMResponse struct {
+	Stats *domain.VMStats `json:"stats"`
+}
+
+// GCRequest finds the resources that aren't used by any vm. They are only
+// removed if Force is set.
+type GCRequest struct {
+	Force          bool `json:"force,omitempty"`
+	IncludeStopped bool `json:"include_stopped,omitempty"`
+}
+
+type GCResponse struct {
+	Orphans []ports.Orphan `json:"orphans"`
+}
+
+// VMResponse is returned by the operations that change a vm.
+type VMResponse struct {
+	VM *domain.VM `json:"vm,omitempty"`
+}
+
+type Empty struct{}
+
+// WatchEventsRequest is used to subscribe to events. If Name is set then only
+// events for that vm are sent.
+type WatchEventsRequest struct {
+	Name string `json:"name,omitempty"`
+}
+
+// Operation is an operation performed against a vm.
+type Operation string
+
+const (
+	OperationCreate       Operation = "create"
+	OperationRemove       Operation = "remove"
+	OperationAttachVolume Operation = "attach-volume"
+	OperationDetachVolume Operation = "detach-volume"
+	OperationStart        Operation = "start"
+	OperationEnable       Operation = "enable"
+	OperationDisable      Operation = "disable"
+	// OperationGC is published when orphaned resources are removed, it isn't
+	// for a single vm.
+	OperationGC Operation = "gc"
+	// OperationExit is published when the vm process is found to have exited.
+	OperationExit Operation = "exit"
+	// OperationRestart is published when the vm process is restarted.
+	OperationRestart Operation = "restart"
+)
+
+// EventStatus is the status of an operation.
+type EventStatus string
+
+const (
+	EventStatusStarted   EventStatus = "started"
+	EventStatusSucceeded EventStatus = "succeeded"
+	EventStatusFailed    EventStatus = "failed"
+)
+
+// Event is sent when an operation is performed against a vm.
+type Event struct {
+	Time      time.Time   `json:"time"`
+	VM        string      `json:"vm"`
+	Operation Operation   `json:"operation"`
+	Status    EventStatus `json:"status"`
+	Message   string      `json:"message,omitempty"`
+}
@@ -9,8 +9,17 @@ const (
 	VendorDataKey = "vendor-data"
 	// NetworkConfigDataKey is the metadata key name for the network config.
 	NetworkConfigDataKey = "network-config"
+	// NetworkInterfacesKey is the meta-data key for the network config in the
+	// Debian /etc/network/interfaces format.
+	NetworkInterfacesKey = "network-interfaces"
 	// VolumeName is the name of a volume that contains cloud-init data.
 	VolumeName = "cidata"
+	// ConfigDriveVolumeName is the name of a volume that contains cloud-init data
+	// in the OpenStack config drive layout.
+	ConfigDriveVolumeName = "config-2"
+	// ConfigDriveDir is the directory of the latest metadata in the OpenStack
+	// config drive layout.
+	ConfigDriveDir = "/openstack/latest"
 )
 
 func IsCloudInitKey(keyName string) bool {
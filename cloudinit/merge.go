@@ -0,0 +1,374 @@
+package cloudinit
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultMergeHow is how cloud-init merges cloud-config parts that don't say
+// how they're merged. Top level keys in later parts replace earlier ones.
+const DefaultMergeHow = "dict(replace)+list()+str()"
+
+// mergeKeys are the keys that cloud-config parts use to say how they're merged.
+var mergeKeys = []string{"merge_how", "merge_type"}
+
+// Merger is a cloud-init merger for a type of value, e.g. dict(replace).
+type Merger struct {
+	Name    string
+	Options []string
+}
+
+func (m Merger) has(option string) bool {
+	return slices.Contains(m.Options, option)
+}
+
+// MergeHow is how a cloud-config part is merged into the earlier parts. The
+// first merger for the type of the earlier value is used, values without a
+// merger are kept.
+type MergeHow []Merger
+
+func (h MergeHow) merger(name string) (Merger, bool) {
+	for _, m := range h {
+		if m.Name == name {
+			return m, true
+		}
+	}
+
+	return Merger{}, false
+}
+
+// ParseMergeHow parses how to merge in the string form used by the Merge-Type
+// header and merge_how, e.g. list(append)+dict(no_replace,recurse_list).
+func ParseMergeHow(value string) (MergeHow, error) {
+	how := MergeHow{}
+	for _, spec := range strings.Split(value, "+") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		name, options, hasOptions := strings.Cut(spec, "(")
+		m := Merger{Name: strings.TrimSpace(name)}
+		if hasOptions {
+			options, ok := strings.CutSuffix(options, ")")
+			if !ok {
+				return nil, fmt.Errorf("merger %q is missing a closing bracket", spec)
+			}
+			for _, option := range strings.Split(options, ",") {
+				if option = strings.TrimSpace(option); option != "" {
+					m.Options = append(m.Options, option)
+				}
+			}
+		}
+		if err := m.validate(); err != nil {
+			return nil, err
+		}
+		how = append(how, m)
+	}
+
+	return how, nil
+}
+
+// mergeHowFromConfig reads merge_how from a cloud-config part, which can be a
+// string or a list of mergers with their settings.
+func mergeHowFromConfig(config map[interface{}]interface{}) (MergeHow, error) {
+	for _, key := range mergeKeys {
+		value, ok := config[key]
+		if !ok {
+			continue
+		}
+
+		switch value := value.(type) {
+		case string:
+			return ParseMergeHow(value)
+		case []interface{}:
+			how := MergeHow{}
+			for _, item := range value {
+				entry, ok := item.(map[interface{}]interface{})
+				if !ok {
+					return nil, fmt.Errorf("%s entries must have a name and settings", key)
+				}
+				m := Merger{Name: fmt.Sprint(entry["name"])}
+				settings, _ := entry["settings"].([]interface{})
+				for _, setting := range settings {
+					m.Options = append(m.Options, fmt.Sprint(setting))
+				}
+				if err := m.validate(); err != nil {
+					return nil, err
+				}
+				how = append(how, m)
+			}
+
+			return how, nil
+		default:
+			return nil, fmt.Errorf("%s must be a string or a list", key)
+		}
+	}
+
+	return nil, nil
+}
+
+var mergerOptions = map[string][]string{
+	"dict": {"allow_delete", "no_replace", "replace", "recurse_array", "recurse_dict", "recurse_list", "recurse_str"},
+	"list": {"append", "prepend", "no_replace", "replace", "recurse_array", "recurse_dict", "recurse_list", "recurse_str"},
+	"str":  {"append"},
+}
+
+func (m Merger) validate() error {
+	options, ok := mergerOptions[m.Name]
+	if !ok {
+		return fmt.Errorf("unknown merger %q", m.Name)
+	}
+	for _, option := range m.Options {
+		if !slices.Contains(options, option) {
+			return fmt.Errorf("unknown option %q for merger %s", option, m.Name)
+		}
+	}
+
+	return nil
+}
+
+// Merge merges the later value into the earlier one like cloud-init does, and
+// returns the result. The earlier value isn't changed.
+func (h MergeHow) Merge(earlier, later interface{}) interface{} {
+	switch earlier := earlier.(type) {
+	case map[interface{}]interface{}:
+		if m, ok := h.merger("dict"); ok {
+			return h.mergeDict(m, earlier, later)
+		}
+	case []interface{}:
+		if m, ok := h.merger("list"); ok {
+			return h.mergeList(m, earlier, later)
+		}
+	case string:
+		if m, ok := h.merger("str"); ok {
+			if laterStr, ok := later.(string); ok && m.has("append") {
+				return earlier + laterStr
+			}
+
+			return later
+		}
+	}
+
+	return earlier
+}
+
+func (h MergeHow) mergeDict(m Merger, earlier map[interface{}]interface{}, later interface{}) interface{} {
+	laterDict, ok := later.(map[interface{}]interface{})
+	if !ok {
+		return earlier
+	}
+
+	replace := m.has("replace")
+	recurseArray := m.has("recurse_array") || m.has("recurse_list")
+	recurseStr := m.has("recurse_str")
+
+	merged := make(map[interface{}]interface{}, len(earlier))
+	for key, value := range earlier {
+		merged[key] = value
+	}
+	for key, value := range laterDict {
+		old, exists := merged[key]
+		switch {
+		case !exists:
+			merged[key] = value
+		case value == nil && m.has("allow_delete"):
+			delete(merged, key)
+		case replace:
+			merged[key] = value
+		default:
+			switch value.(type) {
+			case []interface{}:
+				if recurseArray {
+					merged[key] = h.Merge(old, value)
+				}
+			case string:
+				if recurseStr {
+					merged[key] = h.Merge(old, value)
+				}
+			case map[interface{}]interface{}:
+				merged[key] = h.Merge(old, value)
+			}
+		}
+	}
+
+	return merged
+}
+
+func (h MergeHow) mergeList(m Merger, earlier []interface{}, later interface{}) interface{} {
+	laterList, ok := later.([]interface{})
+	switch {
+	case !ok && (m.has("append") || m.has("prepend") || m.has("no_replace")):
+		return earlier
+	case !ok:
+		return later
+	case m.has("prepend"):
+		return append(slices.Clone(laterList), earlier...)
+	case m.has("append"):
+		return append(slices.Clone(earlier), laterList...)
+	}
+
+	// Otherwise the items at the same index are replaced
+	merged := slices.Clone(earlier)
+	for i := 0; i < len(merged) && i < len(laterList); i++ {
+		if m.has("no_replace") {
+			continue
+		}
+
+		value := laterList[i]
+		switch {
+		case isList(value) && (m.has("recurse_array") || m.has("recurse_list")):
+			merged[i] = h.Merge(merged[i], value)
+		case isString(value) && m.has("recurse_str"):
+			merged[i] = h.Merge(merged[i], value)
+		case isDict(value) && m.has("recurse_dict"):
+			merged[i] = h.Merge(merged[i], value)
+		default:
+			merged[i] = value
+		}
+	}
+
+	return merged
+}
+
+func isList(value interface{}) bool {
+	_, ok := value.([]interface{})
+	return ok
+}
+
+func isString(value interface{}) bool {
+	_, ok := value.(string)
+	return ok
+}
+
+func isDict(value interface{}) bool {
+	_, ok := value.(map[interface{}]interface{})
+	return ok
+}
+
+// Compose merges the cloud-config parts of the supplied user-data into the
+// generated user-data in order, like cloud-init merges the parts of multipart
+// user-data, and then merges in the required config. The required config is
+// appended to lists and doesn't replace values set by the user. Parts that
+// aren't cloud-config are kept and the user-data is multipart MIME if there are
+// any.
+func Compose(generated *UserData, supplied []byte, required *UserData) ([]byte, error) {
+	merged, err := toConfig(generated)
+	if err != nil {
+		return nil, err
+	}
+
+	others := []Part{}
+	// The merged cloud-config is only a jinja template if the cloud-config the
+	// user supplied is, otherwise cloud-init would render their {{ }}
+	jinja := false
+	if len(supplied) > 0 {
+		parts, err := ParseUserData(supplied)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, part := range parts {
+			if part.ContentType != ContentTypeCloudConfig {
+				others = append(others, part)
+				continue
+			}
+
+			config := map[interface{}]interface{}{}
+			if err := yaml.Unmarshal(part.Content, &config); err != nil {
+				return nil, fmt.Errorf("parsing cloud-config part %s: %w", part.Filename, err)
+			}
+			how, err := partMergeHow(part, config)
+			if err != nil {
+				return nil, fmt.Errorf("cloud-config part %s: %w", part.Filename, err)
+			}
+			merged = how.Merge(merged, config).(map[interface{}]interface{})
+		}
+		jinja = allJinjaCloudConfig(parts)
+	}
+
+	if required != nil {
+		config, err := toConfig(required)
+		if err != nil {
+			return nil, err
+		}
+		how, _ := ParseMergeHow("dict(no_replace,recurse_list)+list(append)")
+		merged = how.Merge(merged, config).(map[interface{}]interface{})
+	}
+
+	for _, key := range mergeKeys {
+		delete(merged, key)
+	}
+	data, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling merged cloud-config: %w", err)
+	}
+	cloudConfig := Part{
+		ContentType: ContentTypeCloudConfig,
+		Filename:    "cloud-config.yaml",
+		Jinja:       jinja,
+		Content:     append([]byte(CloudConfigHeader+"\n\n"), data...),
+	}
+	if len(others) == 0 {
+		if jinja {
+			return append([]byte(JinjaHeader+"\n"), cloudConfig.Content...), nil
+		}
+
+		return cloudConfig.Content, nil
+	}
+
+	return WriteMultipart(append([]Part{cloudConfig}, others...))
+}
+
+// allJinjaCloudConfig returns true if there are cloud-config parts and they
+// are all jinja templates.
+func allJinjaCloudConfig(parts []Part) bool {
+	found := false
+	for _, part := range parts {
+		if part.ContentType != ContentTypeCloudConfig {
+			continue
+		}
+		if !part.Jinja {
+			return false
+		}
+		found = true
+	}
+
+	return found
+}
+
+func partMergeHow(part Part, config map[interface{}]interface{}) (MergeHow, error) {
+	how, err := mergeHowFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if part.MergeType != "" {
+		fromHeader, err := ParseMergeHow(part.MergeType)
+		if err != nil {
+			return nil, err
+		}
+		how = append(how, fromHeader...)
+	}
+	if len(how) == 0 {
+		return ParseMergeHow(DefaultMergeHow)
+	}
+
+	return how, nil
+}
+
+// toConfig converts the user-data to the untyped form used for merging.
+func toConfig(userdata *UserData) (map[interface{}]interface{}, error) {
+	data, err := yaml.Marshal(userdata)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling user-data: %w", err)
+	}
+	config := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("unmarshalling user-data: %w", err)
+	}
+
+	return config, nil
+}
@@ -0,0 +1,251 @@
+package cloudinit
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestMerge(t *testing.T) {
+	testCases := []struct {
+		name     string
+		how      string
+		earlier  string
+		later    string
+		expected string
+	}{
+		{
+			name:     "default replaces top level keys",
+			how:      DefaultMergeHow,
+			earlier:  "runcmd: [a, b]\nusers: [{name: ml}]\nhostname: vm1",
+			later:    "runcmd: [c]\npackages: [jq]",
+			expected: "runcmd: [c]\nusers: [{name: ml}]\nhostname: vm1\npackages: [jq]",
+		},
+		{
+			name:     "default replaces nested dicts",
+			how:      DefaultMergeHow,
+			earlier:  "apt: {preserve_sources_list: true, sources: {a: 1}}",
+			later:    "apt: {sources: {b: 2}}",
+			expected: "apt: {sources: {b: 2}}",
+		},
+		{
+			name:     "no replace keeps earlier values and recurses into dicts",
+			how:      "dict(no_replace)",
+			earlier:  "hostname: vm1\napt: {sources: {a: 1}}",
+			later:    "hostname: other\napt: {sources: {b: 2}}",
+			expected: "hostname: vm1\napt: {sources: {a: 1, b: 2}}",
+		},
+		{
+			name:     "lists are appended",
+			how:      "list(append)+dict(no_replace,recurse_list)+str()",
+			earlier:  "runcmd: [a, b]",
+			later:    "runcmd: [c]",
+			expected: "runcmd: [a, b, c]",
+		},
+		{
+			name:     "lists are prepended",
+			how:      "list(prepend)+dict(no_replace,recurse_array)+str()",
+			earlier:  "runcmd: [a, b]",
+			later:    "runcmd: [c]",
+			expected: "runcmd: [c, a, b]",
+		},
+		{
+			name:     "list replace is by index",
+			how:      "list()+dict(no_replace,recurse_list)",
+			earlier:  "runcmd: [a, b]",
+			later:    "runcmd: [c]",
+			expected: "runcmd: [c, b]",
+		},
+		{
+			name:     "strings are appended",
+			how:      "dict(no_replace,recurse_str)+str(append)",
+			earlier:  "final_message: booted",
+			later:    "final_message: ' again'",
+			expected: "final_message: booted again",
+		},
+		{
+			name:     "allow delete removes null keys",
+			how:      "dict(replace,allow_delete)",
+			earlier:  "hostname: vm1\nfqdn: vm1.local",
+			later:    "fqdn: null",
+			expected: "hostname: vm1",
+		},
+		{
+			name:     "values without a merger are kept",
+			how:      "dict(no_replace)",
+			earlier:  "runcmd: [a]",
+			later:    "runcmd: [b]",
+			expected: "runcmd: [a]",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			how, err := ParseMergeHow(tc.how)
+			if err != nil {
+				t.Fatalf("parsing merge how: %s", err)
+			}
+
+			earlier := parseConfig(t, tc.earlier)
+			before := parseConfig(t, tc.earlier)
+			merged := how.Merge(earlier, parseConfig(t, tc.later))
+
+			if !reflect.DeepEqual(merged, parseConfig(t, tc.expected)) {
+				t.Errorf("expected %v, got %v", parseConfig(t, tc.expected), merged)
+			}
+			if !reflect.DeepEqual(earlier, before) {
+				t.Errorf("expected the earlier value to be unchanged, got %v", earlier)
+			}
+		})
+	}
+}
+
+func TestParseMergeHow(t *testing.T) {
+	how, err := ParseMergeHow("list(append)+dict(no_replace, recurse_list)+str()")
+	if err != nil {
+		t.Fatalf("parsing: %s", err)
+	}
+	expected := MergeHow{
+		{Name: "list", Options: []string{"append"}},
+		{Name: "dict", Options: []string{"no_replace", "recurse_list"}},
+		{Name: "str"},
+	}
+	if !reflect.DeepEqual(how, expected) {
+		t.Errorf("expected %v, got %v", expected, how)
+	}
+
+	for _, invalid := range []string{"tuple()", "list(sideways)", "dict(replace"} {
+		if _, err := ParseMergeHow(invalid); err == nil {
+			t.Errorf("expected %q to fail", invalid)
+		}
+	}
+
+	config := parseConfig(t, "merge_how:\n- name: list\n  settings: [append]\n- name: dict\n  settings: [no_replace, recurse_list]")
+	how, err = mergeHowFromConfig(config)
+	if err != nil {
+		t.Fatalf("parsing merge_how list: %s", err)
+	}
+	if len(how) != 2 || how[0].Name != "list" || !how[1].has("recurse_list") {
+		t.Errorf("unexpected merge how %v", how)
+	}
+}
+
+func TestCompose(t *testing.T) {
+	generated := &UserData{
+		HostName:     "vm1",
+		BootCommands: []string{"echo boot"},
+		Users:        []User{{Name: "ml", Gecos: "Mikrolite user"}},
+	}
+	required := &UserData{
+		WriteFiles: []WriteFile{{Encoding: "b64", Content: "aGVsbG8=", Path: "/etc/hello", Permissions: "0644"}},
+		PhoneHome:  &PhoneHome{URL: "http://192.168.122.1:8080/phone-home/abc"},
+	}
+
+	t.Run("no supplied user-data", func(t *testing.T) {
+		data, err := Compose(generated, nil, nil)
+		if err != nil {
+			t.Fatalf("composing: %s", err)
+		}
+		if !strings.HasPrefix(string(data), CloudConfigHeader+"\n") {
+			t.Errorf("expected cloud-config, got %q", data)
+		}
+		userdata := &UserData{}
+		if err := yaml.Unmarshal(data, userdata); err != nil {
+			t.Fatalf("unmarshalling: %s", err)
+		}
+		if !reflect.DeepEqual(userdata, generated) {
+			t.Errorf("expected the generated user-data, got %+v", userdata)
+		}
+	})
+
+	t.Run("cloud-config is merged", func(t *testing.T) {
+		supplied := "#cloud-config\nmerge_how: list(append)+dict(no_replace,recurse_list)+str()\nruncmd: [echo run]\nbootcmd: [echo mine]\nwrite_files: [{path: /etc/mine, content: mine}]\n"
+		data, err := Compose(generated, []byte(supplied), required)
+		if err != nil {
+			t.Fatalf("composing: %s", err)
+		}
+		config := parseConfig(t, string(data))
+		expected := parseConfig(t, `
+hostname: vm1
+bootcmd: [echo boot, echo mine]
+runcmd: [echo run]
+users: [{name: ml, gecos: Mikrolite user}]
+write_files:
+- {path: /etc/mine, content: mine}
+- {encoding: b64, content: aGVsbG8=, path: /etc/hello, permissions: "0644"}
+phone_home: {url: "http://192.168.122.1:8080/phone-home/abc"}
+`)
+		if !reflect.DeepEqual(config, expected) {
+			t.Errorf("expected %v, got %v", expected, config)
+		}
+	})
+
+	t.Run("user-data that isn't cloud-config is kept", func(t *testing.T) {
+		supplied := "#!/bin/sh\necho hello\n"
+		data, err := Compose(generated, []byte(supplied), required)
+		if err != nil {
+			t.Fatalf("composing: %s", err)
+		}
+		parts, err := ParseUserData(data)
+		if err != nil {
+			t.Fatalf("parsing composed user-data: %s", err)
+		}
+		if len(parts) != 2 {
+			t.Fatalf("expected 2 parts, got %d", len(parts))
+		}
+		if parts[0].ContentType != ContentTypeCloudConfig || parts[0].Jinja {
+			t.Errorf("expected the merged cloud-config first, got %+v", parts[0])
+		}
+		if parts[1].ContentType != ContentTypeShellScript || string(parts[1].Content) != supplied {
+			t.Errorf("expected the script, got %+v", parts[1])
+		}
+	})
+
+	t.Run("braces in cloud-config aren't rendered as jinja", func(t *testing.T) {
+		supplied := "#cloud-config\nruncmd:\n- docker ps --format '{{.Names}}'\n"
+		data, err := Compose(generated, []byte(supplied), required)
+		if err != nil {
+			t.Fatalf("composing: %s", err)
+		}
+		if !strings.HasPrefix(string(data), CloudConfigHeader+"\n") {
+			t.Errorf("expected cloud-config without the jinja header, got %q", data)
+		}
+		config := parseConfig(t, string(data))
+		if runcmd := config["runcmd"].([]interface{}); len(runcmd) != 1 || runcmd[0] != "docker ps --format '{{.Names}}'" {
+			t.Errorf("expected the command to be kept, got %v", runcmd)
+		}
+	})
+
+	t.Run("jinja cloud-config stays jinja", func(t *testing.T) {
+		supplied := JinjaHeader + "\n#cloud-config\nruncmd:\n- echo {{ v1.instance_id }}\n"
+		data, err := Compose(generated, []byte(supplied), required)
+		if err != nil {
+			t.Fatalf("composing: %s", err)
+		}
+		if !strings.HasPrefix(string(data), JinjaHeader+"\n"+CloudConfigHeader+"\n") {
+			t.Errorf("expected jinja cloud-config, got %q", data)
+		}
+	})
+
+	t.Run("invalid cloud-config fails", func(t *testing.T) {
+		if _, err := Compose(generated, []byte("#cloud-config\nruncmd: [a\n"), nil); err == nil {
+			t.Errorf("expected invalid yaml to fail")
+		}
+		if _, err := Compose(generated, []byte("#cloud-config\nmerge_how: list(sideways)\n"), nil); err == nil {
+			t.Errorf("expected invalid merge_how to fail")
+		}
+	})
+}
+
+func parseConfig(t *testing.T, value string) map[interface{}]interface{} {
+	t.Helper()
+
+	config := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal([]byte(value), &config); err != nil {
+		t.Fatalf("unmarshalling %q: %s", value, err)
+	}
+
+	return config
+}
@@ -0,0 +1,248 @@
+package cloudinit
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	ContentTypeCloudConfig = "text/cloud-config"
+	ContentTypeShellScript = "text/x-shellscript"
+	ContentTypeBoothook    = "text/cloud-boothook"
+	ContentTypeIncludeURL  = "text/x-include-url"
+	ContentTypeMultipart   = "multipart/mixed"
+
+	// CloudConfigHeader is the first line of cloud-config user-data.
+	CloudConfigHeader = "#cloud-config"
+	// JinjaHeader is the first line of user-data that cloud-init renders as a
+	// jinja template before its used.
+	JinjaHeader = "## template: jinja"
+
+	mergeTypeHeader = "Merge-Type"
+)
+
+// ErrUnknownUserData is returned when the type of user-data can't be detected.
+var ErrUnknownUserData = errors.New("unknown user-data type")
+
+// startsWith maps the first line of user-data to its content type, like
+// cloud-init detects it.
+var startsWith = []struct {
+	prefix      string
+	contentType string
+}{
+	{CloudConfigHeader, ContentTypeCloudConfig},
+	{"#!", ContentTypeShellScript},
+	{"#cloud-boothook", ContentTypeBoothook},
+	{"#include", ContentTypeIncludeURL},
+	{"Content-Type: multipart", ContentTypeMultipart},
+	{"MIME-Version:", ContentTypeMultipart},
+}
+
+// Part is a part of the user-data.
+type Part struct {
+	// ContentType is the type of the part, e.g. text/cloud-config.
+	ContentType string
+	// Filename is the filename of the part in multipart user-data.
+	Filename string
+	// MergeType is how a cloud-config part is merged, from the Merge-Type
+	// header of the part.
+	MergeType string
+	// Jinja is true if the part is a jinja template. The template header isn't
+	// in the content.
+	Jinja bool
+	// Content is the content of the part.
+	Content []byte
+}
+
+// ParseUserData splits the user-data into its parts. Gzipped user-data is
+// decompressed and multipart MIME user-data is split into its parts, other
+// user-data is a single part whose type is detected from its first line.
+func ParseUserData(data []byte) ([]Part, error) {
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("decompressing user-data: %w", err)
+		}
+		data, err = io.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing user-data: %w", err)
+		}
+	}
+
+	part := Part{Content: data}
+	if firstLine(data) == JinjaHeader {
+		part.Jinja = true
+		part.Content = data[len(JinjaHeader):]
+		part.Content = bytes.TrimLeft(part.Content, "\r\n")
+	}
+
+	contentType, err := detectContentType(part.Content)
+	if err != nil {
+		return nil, err
+	}
+	if contentType == ContentTypeMultipart {
+		if part.Jinja {
+			return nil, errors.New("multipart user-data can't be a jinja template")
+		}
+
+		return parseMultipart(data)
+	}
+	part.ContentType = contentType
+
+	return []Part{part}, nil
+}
+
+func detectContentType(data []byte) (string, error) {
+	for _, sw := range startsWith {
+		if bytes.HasPrefix(data, []byte(sw.prefix)) {
+			return sw.contentType, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w, starting with %q", ErrUnknownUserData, firstLine(data))
+}
+
+func firstLine(data []byte) string {
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+
+	return strings.TrimSpace(string(line))
+}
+
+func parseMultipart(data []byte) ([]Part, error) {
+	msg, err := mail.ReadMessage(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, fmt.Errorf("reading multipart user-data: %w", err)
+	}
+
+	return readMultipart(textproto.MIMEHeader(msg.Header), msg.Body)
+}
+
+func readMultipart(header textproto.MIMEHeader, body io.Reader) ([]Part, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing content type of multipart user-data: %w", err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return nil, fmt.Errorf("user-data with content type %s isn't multipart", mediaType)
+	}
+
+	parts := []Part{}
+	reader := multipart.NewReader(body, params["boundary"])
+	for {
+		mimePart, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return parts, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading multipart user-data: %w", err)
+		}
+
+		contentType, _, _ := mime.ParseMediaType(mimePart.Header.Get("Content-Type"))
+		if strings.HasPrefix(contentType, "multipart/") {
+			nested, err := readMultipart(mimePart.Header, mimePart)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, nested...)
+			continue
+		}
+
+		var content io.Reader = mimePart
+		if strings.EqualFold(mimePart.Header.Get("Content-Transfer-Encoding"), "base64") {
+			content = base64.NewDecoder(base64.StdEncoding, mimePart)
+		}
+		data, err := io.ReadAll(content)
+		if err != nil {
+			return nil, fmt.Errorf("reading part %s of multipart user-data: %w", mimePart.FileName(), err)
+		}
+
+		part := Part{
+			ContentType: contentType,
+			Filename:    mimePart.FileName(),
+			MergeType:   mergeType(mimePart.Header),
+			Content:     data,
+		}
+		if firstLine(part.Content) == JinjaHeader || contentType == "text/jinja2" {
+			part.Jinja = true
+			part.Content = bytes.TrimLeft(bytes.TrimPrefix(part.Content, []byte(JinjaHeader)), "\r\n")
+		}
+		// Like cloud-init, parts without a useful content type are detected from
+		// their content
+		if contentType == "" || contentType == "text/plain" || contentType == "text/x-not-multipart" || contentType == "text/jinja2" {
+			part.ContentType, err = detectContentType(part.Content)
+			if err != nil {
+				return nil, fmt.Errorf("part %s of multipart user-data: %w", part.Filename, err)
+			}
+		}
+		parts = append(parts, part)
+	}
+}
+
+func mergeType(header textproto.MIMEHeader) string {
+	if value := header.Get(mergeTypeHeader); value != "" {
+		return value
+	}
+
+	return header.Get("X-" + mergeTypeHeader)
+}
+
+// WriteMultipart writes the parts as multipart MIME user-data.
+func WriteMultipart(parts []Part) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	writer := multipart.NewWriter(buf)
+
+	fmt.Fprintf(buf, "Content-Type: %s; boundary=\"%s\"\r\n", ContentTypeMultipart, writer.Boundary())
+	fmt.Fprint(buf, "MIME-Version: 1.0\r\n\r\n")
+
+	for i, part := range parts {
+		filename := part.Filename
+		if filename == "" {
+			filename = fmt.Sprintf("part-%03d", i+1)
+		}
+		content := part.Content
+		if part.Jinja {
+			content = append([]byte(JinjaHeader+"\n"), content...)
+		}
+
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", fmt.Sprintf("%s; charset=\"utf-8\"", part.ContentType))
+		header.Set("MIME-Version", "1.0")
+		header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+		if part.MergeType != "" {
+			header.Set(mergeTypeHeader, part.MergeType)
+		}
+		// Binary content is base64 encoded so that it survives the trip
+		encoded := !utf8.Valid(content) || bytes.IndexByte(content, 0) != -1
+		if encoded {
+			header.Set("Content-Transfer-Encoding", "base64")
+		}
+
+		w, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, fmt.Errorf("creating part %s: %w", filename, err)
+		}
+		if encoded {
+			content = []byte(base64.StdEncoding.EncodeToString(content))
+		}
+		if _, err := w.Write(content); err != nil {
+			return nil, fmt.Errorf("writing part %s: %w", filename, err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("closing multipart user-data: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
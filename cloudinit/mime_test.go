@@ -0,0 +1,95 @@
+package cloudinit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+)
+
+const testMultipart = `Content-Type: multipart/mixed; boundary="===============1=="
+MIME-Version: 1.0
+
+--===============1==
+Content-Type: text/cloud-config; charset="us-ascii"
+Content-Disposition: attachment; filename="config.yaml"
+Merge-Type: list(append)+dict(recurse_array)+str()
+
+#cloud-config
+runcmd: [echo one]
+
+--===============1==
+Content-Type: text/x-shellscript
+Content-Transfer-Encoding: base64
+Content-Disposition: attachment; filename="script.sh"
+
+IyEvYmluL3NoCmVjaG8gaGVsbG8K
+--===============1==
+Content-Type: text/plain
+
+## template: jinja
+#cloud-config
+hostname: {{ v1.instance_id }}
+--===============1==--
+`
+
+func TestParseUserData(t *testing.T) {
+	parts, err := ParseUserData([]byte(testMultipart))
+	if err != nil {
+		t.Fatalf("parsing: %s", err)
+	}
+	if len(parts) != 3 {
+		t.Fatalf("expected 3 parts, got %d", len(parts))
+	}
+	if parts[0].ContentType != ContentTypeCloudConfig || parts[0].Filename != "config.yaml" || parts[0].MergeType != "list(append)+dict(recurse_array)+str()" {
+		t.Errorf("unexpected cloud-config part %+v", parts[0])
+	}
+	if parts[1].ContentType != ContentTypeShellScript || string(parts[1].Content) != "#!/bin/sh\necho hello\n" {
+		t.Errorf("expected the base64 script to be decoded, got %+v", parts[1])
+	}
+	if parts[2].ContentType != ContentTypeCloudConfig || !parts[2].Jinja || !bytes.HasPrefix(parts[2].Content, []byte(CloudConfigHeader)) {
+		t.Errorf("expected the plain part to be detected as jinja cloud-config, got %+v", parts[2])
+	}
+
+	compressed := &bytes.Buffer{}
+	gz := gzip.NewWriter(compressed)
+	gz.Write([]byte("#cloud-config\nhostname: vm1\n"))
+	gz.Close()
+	parts, err = ParseUserData(compressed.Bytes())
+	if err != nil || len(parts) != 1 || parts[0].ContentType != ContentTypeCloudConfig {
+		t.Errorf("expected gzipped cloud-config, got %+v: %v", parts, err)
+	}
+
+	if _, err := ParseUserData([]byte("hostname: vm1\n")); !errors.Is(err, ErrUnknownUserData) {
+		t.Errorf("expected unknown user-data error, got %v", err)
+	}
+}
+
+func TestWriteMultipart(t *testing.T) {
+	parts := []Part{
+		{ContentType: ContentTypeCloudConfig, Jinja: true, MergeType: "dict(replace)", Content: []byte("#cloud-config\nhostname: vm1\n")},
+		{ContentType: ContentTypeShellScript, Filename: "script.sh", Content: []byte("#!/bin/sh\necho hello\n")},
+		{ContentType: ContentTypeBoothook, Filename: "binary", Content: []byte("#cloud-boothook\n\x00\xff")},
+	}
+
+	data, err := WriteMultipart(parts)
+	if err != nil {
+		t.Fatalf("writing: %s", err)
+	}
+	parsed, err := ParseUserData(data)
+	if err != nil {
+		t.Fatalf("parsing: %s", err)
+	}
+	if len(parsed) != len(parts) {
+		t.Fatalf("expected %d parts, got %d", len(parts), len(parsed))
+	}
+	for i, part := range parts {
+		if part.Filename == "" {
+			part.Filename = "part-001"
+		}
+		got := parsed[i]
+		if got.ContentType != part.ContentType || got.Filename != part.Filename || got.MergeType != part.MergeType || got.Jinja != part.Jinja || !bytes.Equal(got.Content, part.Content) {
+			t.Errorf("part %d: expected %+v, got %+v", i, part, got)
+		}
+	}
+}
@@ -10,13 +10,15 @@ type Network struct {
 }
 
 type Ethernet struct {
-	Match          Match       `yaml:"match"`
-	Addresses      []string    `yaml:"addresses,omitempty"`
-	GatewayIPv4    string      `yaml:"gateway4,omitempty"`
-	DHCP4          *bool       `yaml:"dhcp4,omitempty"`
-	DHCPIdentifier *string     `yaml:"dhcp-identifier,omitempty"`
-	Nameservers    Nameservers `yaml:"nameservers,omitempty"`
-	Routes         []Routes    `yaml:"routes,omitempty"`
+	Match          Match          `yaml:"match"`
+	Addresses      []string       `yaml:"addresses,omitempty"`
+	GatewayIPv4    string         `yaml:"gateway4,omitempty"`
+	DHCP4          *bool          `yaml:"dhcp4,omitempty"`
+	DHCP4Overrides *DHCPOverrides `yaml:"dhcp4-overrides,omitempty"`
+	DHCPIdentifier *string        `yaml:"dhcp-identifier,omitempty"`
+	MTU            int            `yaml:"mtu,omitempty"`
+	Nameservers    Nameservers    `yaml:"nameservers,omitempty"`
+	Routes         []Routes       `yaml:"routes,omitempty"`
 }
 
 type Match struct {
@@ -29,9 +31,57 @@ type Nameservers struct {
 	Addresses []string `yaml:"addresses,omitempty"`
 }
 
+type DHCPOverrides struct {
+	UseDNS      *bool `yaml:"use-dns,omitempty"`
+	UseDomains  *bool `yaml:"use-domains,omitempty"`
+	UseRoutes   *bool `yaml:"use-routes,omitempty"`
+	UseHostname *bool `yaml:"use-hostname,omitempty"`
+	RouteMetric *int  `yaml:"route-metric,omitempty"`
+}
+
 type Routes struct {
 	To     string `yaml:"to"`
 	Via    string `yaml:"via"`
 	Metric *int   `yaml:"metric,omitempty"`
 	OnLink *bool  `yaml:"on-link,omitempty"`
 }
+
+// NetworkV1 is the cloud-init network config v1, which is understood by older
+// versions of cloud-init than the netplan v2 config.
+type NetworkV1 struct {
+	Version int        `yaml:"version"`
+	Config  []ConfigV1 `yaml:"config"`
+}
+
+const (
+	// ConfigTypePhysical is a v1 config for a physical interface.
+	ConfigTypePhysical = "physical"
+	// ConfigTypeRoute is a v1 config for a route.
+	ConfigTypeRoute = "route"
+
+	// SubnetTypeDHCP4 is a v1 subnet that uses dhcp.
+	SubnetTypeDHCP4 = "dhcp4"
+	// SubnetTypeStatic is a v1 subnet with a static address.
+	SubnetTypeStatic = "static"
+)
+
+// ConfigV1 is an entry of the v1 network config, either a physical interface
+// or a route.
+type ConfigV1 struct {
+	Type        string     `yaml:"type"`
+	Name        string     `yaml:"name,omitempty"`
+	MACAddress  string     `yaml:"mac_address,omitempty"`
+	MTU         int        `yaml:"mtu,omitempty"`
+	Subnets     []SubnetV1 `yaml:"subnets,omitempty"`
+	Destination string     `yaml:"destination,omitempty"`
+	Gateway     string     `yaml:"gateway,omitempty"`
+	Metric      *int       `yaml:"metric,omitempty"`
+}
+
+type SubnetV1 struct {
+	Type           string   `yaml:"type"`
+	Address        string   `yaml:"address,omitempty"`
+	Gateway        string   `yaml:"gateway,omitempty"`
+	DNSNameservers []string `yaml:"dns_nameservers,omitempty"`
+	DNSSearch      []string `yaml:"dns_search,omitempty"`
+}
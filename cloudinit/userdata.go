@@ -11,6 +11,7 @@ type UserData struct {
 	WriteFiles      []WriteFile `yaml:"write_files,omitempty"`
 	RunCommands     []string    `yaml:"runcmd,omitempty"`
 	BootCommands    []string    `yaml:"bootcmd,omitempty"`
+	PhoneHome       *PhoneHome  `yaml:"phone_home,omitempty"`
 }
 
 type User struct {
@@ -30,3 +31,11 @@ type WriteFile struct {
 	Path        string `yaml:"path"`
 	Permissions string `yaml:"permissions"`
 }
+
+// PhoneHome configures the phone_home module, which posts to the url near the
+// end of the final stage of cloud-init.
+type PhoneHome struct {
+	URL   string   `yaml:"url"`
+	Post  []string `yaml:"post,omitempty"`
+	Tries int      `yaml:"tries,omitempty"`
+}
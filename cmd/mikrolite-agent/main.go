@@ -0,0 +1,38 @@
+// mikrolite-agent is the guest agent. Its run in the vm, usually by the init
+// system, and lets mikrolite run commands and copy files over vsock.
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"os/signal"
+	"syscall"
+
+	"github.com/mikrolite/mikrolite/agent"
+)
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	listener, err := agent.Listen(agent.Port)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// The agent is listening before the host is told the vm is ready
+	go func() {
+		dial := func(ctx context.Context) (net.Conn, error) {
+			return agent.DialHost(ctx, agent.ReadyPort)
+		}
+		if err := agent.SignalReady(ctx, dial, agent.CollectInfo); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	server := &agent.Server{}
+	if err := server.Serve(ctx, listener); err != nil {
+		log.Fatalln(err)
+	}
+}
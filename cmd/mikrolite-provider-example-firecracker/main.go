@@ -0,0 +1,52 @@
+// mikrolite-provider-example-firecracker is a reference provider plugin that
+// wraps the built-in firecracker provider.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/afero"
+
+	"github.com/mikrolite/mikrolite/adapters/console"
+	"github.com/mikrolite/mikrolite/adapters/filesystem"
+	"github.com/mikrolite/mikrolite/adapters/godisk"
+	"github.com/mikrolite/mikrolite/adapters/vm/firecracker"
+	"github.com/mikrolite/mikrolite/core/ports"
+	"github.com/mikrolite/mikrolite/plugin"
+)
+
+const (
+	providerName = "example-firecracker"
+	binEnvVar    = "MIKROLITE_FIRECRACKER_BIN"
+)
+
+func main() {
+	// The firecracker provider runs the console shim using this executable
+	console.Init()
+
+	// stdout is used for the plugin protocol
+	pterm.SetDefaultOutput(os.Stderr)
+	log.SetOutput(os.Stderr)
+
+	binaryPath := os.Getenv(binEnvVar)
+	if binaryPath == "" {
+		binaryPath = "firecracker"
+	}
+
+	err := plugin.Serve(providerName, func(env plugin.Environment) (ports.VMProvider, error) {
+		fs := afero.NewOsFs()
+
+		stateSvc, err := filesystem.NewStateService("", env.StateDir, fs)
+		if err != nil {
+			return nil, fmt.Errorf("creating state service: %w", err)
+		}
+
+		return firecracker.New(binaryPath, stateSvc, godisk.New(fs), fs), nil
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
@@ -0,0 +1,20 @@
+// mikrolited is the mikrolite daemon. Its the same as running mikrolite daemon.
+package main
+
+import (
+	"log"
+
+	"github.com/mikrolite/mikrolite/adapters/console"
+	"github.com/mikrolite/mikrolite/internal/commands/daemon"
+)
+
+func main() {
+	console.Init()
+
+	cmd := daemon.NewDaemonCommand()
+	cmd.Use = "mikrolited"
+
+	if err := cmd.Execute(); err != nil {
+		log.Fatalln(err)
+	}
+}
@@ -2,6 +2,7 @@ package app
 
 import (
 	"context"
+	"time"
 
 	"github.com/mikrolite/mikrolite/core/domain"
 	"github.com/mikrolite/mikrolite/core/ports"
@@ -11,24 +12,69 @@ import (
 // App represents the core application.
 type App interface {
 	ports.VMUseCases
+	ports.SupervisorUseCases
+	ports.GCUseCases
 }
 
-func New(imageService ports.ImageService, vmService ports.VMProvider, stateService ports.StateService, fs afero.Fs, networkService ports.NetworkService) App {
-	return &app{
-		imageService:   imageService,
-		fs:             fs,
-		vmService:      vmService,
-		stateService:   stateService,
-		networkService: networkService,
+// Option configures the app.
+type Option func(a *app)
+
+// WithHostMetadata makes the vms of providers without a metadata service get
+// their metadata from the metadata server that mikrolite runs on the host.
+func WithHostMetadata() Option {
+	return func(a *app) {
+		a.hostMetadata = true
+	}
+}
+
+func New(imageService ports.ImageService, vmService ports.VMProvider, stateService ports.StateService, fs afero.Fs, networkService ports.NetworkService, processService ports.ProcessService, autostartService ports.AutostartService, agentService ports.GuestAgentService, readinessService ports.ReadinessService, opts ...Option) App {
+	a := &app{
+		imageService:     imageService,
+		fs:               fs,
+		vmService:        vmService,
+		stateService:     stateService,
+		networkService:   networkService,
+		processService:   processService,
+		autostartService: autostartService,
+		agentService:     agentService,
+		readinessService: readinessService,
+		now:              time.Now,
+		exitWait:         defaultExitWait,
+		agentWait:        defaultAgentWait,
+	}
+	a.bootstrappers = a.defaultBootstrappers()
+	a.networkRenderers = defaultNetworkRenderers()
+	for _, opt := range opts {
+		opt(a)
 	}
+
+	return a
 }
 
 type app struct {
-	imageService   ports.ImageService
-	vmService      ports.VMProvider
-	fs             afero.Fs
-	stateService   ports.StateService
-	networkService ports.NetworkService
+	imageService     ports.ImageService
+	vmService        ports.VMProvider
+	fs               afero.Fs
+	stateService     ports.StateService
+	networkService   ports.NetworkService
+	processService   ports.ProcessService
+	autostartService ports.AutostartService
+	agentService     ports.GuestAgentService
+	readinessService ports.ReadinessService
+	now              func() time.Time
+	// exitWait is how long to wait for the exit of a process to be recorded
+	// by the provider after it stops running.
+	exitWait time.Duration
+	// agentWait is how long to wait for the guest agent to signal that the vm
+	// has booted.
+	agentWait time.Duration
+	// bootstrappers render the bootstrap config in the formats that guests use.
+	bootstrappers map[domain.BootstrapFormat]bootstrapper
+	// networkRenderers render the network config in the formats that guests use.
+	networkRenderers map[domain.NetworkConfigFormat]networkRenderer
+	// hostMetadata is true if mikrolite runs a metadata server on the host for
+	// providers without a metadata service.
+	hostMetadata bool
 }
 
 type handler func(ctx context.Context, owner string, vm *domain.VM) error
@@ -0,0 +1,161 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+	"github.com/mikrolite/mikrolite/testing/fakes"
+)
+
+const (
+	testVMName    = "vm1"
+	testOwner     = "vm-vm1"
+	testBridge    = "br0"
+	testIP        = "192.168.122.10"
+	testAgentIP   = "192.168.122.60"
+	testSSHKey    = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIEXAMPLE user@host"
+	testSSHKeyDir = "/home/user/.ssh/id_ed25519.pub"
+)
+
+// testEnv holds the fakes used by the app under test.
+type testEnv struct {
+	rec       *fakes.Recorder
+	image     *fakes.ImageService
+	vm        *fakes.VMProvider
+	state     *fakes.StateService
+	network   *fakes.NetworkService
+	process   *fakes.ProcessService
+	autostart *fakes.AutostartService
+	agent     *fakes.GuestAgentService
+	readiness *fakes.ReadinessService
+	fs        afero.Fs
+	app       App
+}
+
+func newTestEnv(t *testing.T, caps ports.Capabilities) *testEnv {
+	t.Helper()
+
+	rec := fakes.NewRecorder()
+	env := &testEnv{
+		rec:       rec,
+		image:     fakes.NewImageService(rec),
+		vm:        fakes.NewVMProvider(rec, caps),
+		state:     fakes.NewStateService(rec, "/state", testVMName),
+		network:   fakes.NewNetworkService(rec, testBridge),
+		process:   fakes.NewProcessService(rec),
+		autostart: fakes.NewAutostartService(rec),
+		agent:     fakes.NewGuestAgentService(rec),
+		readiness: fakes.NewReadinessService(rec),
+		fs:        afero.NewMemMapFs(),
+	}
+	env.network.DefaultIP = testIP
+
+	if err := afero.WriteFile(env.fs, testSSHKeyDir, []byte(testSSHKey), 0o644); err != nil {
+		t.Fatalf("writing ssh key: %s", err)
+	}
+
+	env.app = New(env.image, env.vm, env.state, env.fs, env.network, env.process, env.autostart, env.agent, env.readiness)
+	// The fakes record the exit straight away so there's no need to wait for it
+	env.app.(*app).exitWait = 0
+
+	return env
+}
+
+// basicCaps are the capabilities of a provider without a metadata service.
+func basicCaps() ports.Capabilities {
+	return ports.Capabilities{
+		HotplugDisk: true,
+		DiskFeatures: []ports.DiskFeature{
+			ports.DiskFeatureRawFile,
+			ports.DiskFeatureBlockDevice,
+		},
+		NetworkFeatures: []ports.NetworkFeature{
+			ports.NetworkFeatureTap,
+		},
+	}
+}
+
+// vsockCaps are the capabilities of a provider with a vsock device.
+func vsockCaps() ports.Capabilities {
+	caps := basicCaps()
+	caps.Vsock = true
+
+	return caps
+}
+
+// metadataCaps are the capabilities of a provider with a metadata service.
+func metadataCaps() ports.Capabilities {
+	caps := basicCaps()
+	caps.MetadataService = true
+	caps.NetworkFeatures = append(caps.NetworkFeatures, ports.NetworkFeatureMetadataAccess)
+
+	return caps
+}
+
+// qemuCaps are the capabilities of a provider like qemu, which can't hot-attach
+// volumes and has no vsock device.
+func qemuCaps() ports.Capabilities {
+	caps := basicCaps()
+	caps.HotplugDisk = false
+
+	return caps
+}
+
+// swapCaps are the capabilities of a provider like firecracker, which attaches
+// volumes by swapping the backing file of a spare drive.
+func swapCaps() ports.Capabilities {
+	caps := basicCaps()
+	caps.HotplugDisk = false
+	caps.DiskFeatures = append(caps.DiskFeatures, ports.DiskFeatureBackingSwap)
+
+	return caps
+}
+
+func testSpec() *domain.VMSpec {
+	return &domain.VMSpec{
+		VCPU:       2,
+		MemoryInMb: 2048,
+		Kernel: domain.Kernel{
+			Source: domain.KernelSource{
+				Filename: "boot/vmlinux",
+				Container: &domain.ContainerKernelSource{
+					Image: "ghcr.io/mikrolite/kernel:5.10",
+				},
+			},
+		},
+		RootVolume: domain.Volume{
+			Name: "root",
+			Source: domain.VolumeSource{
+				Container: &domain.ContainerVolumeSource{
+					Image: "ghcr.io/mikrolite/root:dev",
+				},
+			},
+		},
+		NetworkConfiguration: domain.NetworkConfiguration{
+			BridgeName: testBridge,
+			Interfaces: map[string]domain.NetwortInterface{
+				"eth0": {
+					GuestDeviceName: "eth0",
+					AttachToBridge:  true,
+				},
+			},
+		},
+	}
+}
+
+func assertMethods(t *testing.T, expected []string, actual []string) {
+	t.Helper()
+
+	if len(expected) != len(actual) {
+		t.Fatalf("expected calls %v, got %v", expected, actual)
+	}
+
+	for i := range expected {
+		if expected[i] != actual[i] {
+			t.Fatalf("expected call %d to be %s, got %s (all calls %v)", i, expected[i], actual[i], actual)
+		}
+	}
+}
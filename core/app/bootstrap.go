@@ -0,0 +1,252 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/afero"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/ignition"
+)
+
+const (
+	// systemdUnitDir is where systemd units are installed in the vm.
+	systemdUnitDir = "/etc/systemd/system"
+	// networkdDir is where systemd-networkd config is written in the vm.
+	networkdDir = "/etc/systemd/network"
+	// readyUnit is the unit that signals that a vm bootstrapped with Ignition is
+	// ready, as Ignition doesn't have an equivalent of phone_home.
+	readyUnit = "mikrolite-ready.service"
+)
+
+// bootstrapper renders the bootstrap config of a vm into the metadata given to
+// the vm, in a format that the guest understands. The metadata is keyed by the
+// metadata key name and the values are base64 encoded.
+type bootstrapper interface {
+	render(vm *domain.VM, phoneHomeURL string) (map[string]string, error)
+}
+
+// bootstrapperFunc is a bootstrapper that's a function.
+type bootstrapperFunc func(vm *domain.VM, phoneHomeURL string) (map[string]string, error)
+
+func (f bootstrapperFunc) render(vm *domain.VM, phoneHomeURL string) (map[string]string, error) {
+	return f(vm, phoneHomeURL)
+}
+
+func (a *app) defaultBootstrappers() map[domain.BootstrapFormat]bootstrapper {
+	return map[domain.BootstrapFormat]bootstrapper{
+		domain.BootstrapFormatCloudInit: bootstrapperFunc(a.renderCloudInit),
+		domain.BootstrapFormatIgnition:  bootstrapperFunc(a.renderIgnition),
+	}
+}
+
+// renderIgnition generates the Ignition config. The hostname, ml user, files
+// and units are the same as with cloud-init. Static addresses are configured
+// with systemd-networkd and the user-data is merged by Ignition.
+func (a *app) renderIgnition(vm *domain.VM, phoneHomeURL string) (map[string]string, error) {
+	config := &ignition.Config{
+		Ignition: ignition.Ignition{Version: ignition.Version},
+		Storage:  &ignition.Storage{},
+		Systemd:  &ignition.Systemd{},
+	}
+	addFile := func(path string, mode int, content []byte) {
+		config.Storage.Files = append(config.Storage.Files, ignition.File{
+			Path:      path,
+			Overwrite: boolPtr(true),
+			Mode:      &mode,
+			Contents:  ignition.Resource{Source: ignition.DataURL(content)},
+		})
+	}
+
+	addFile("/etc/hostname", 0o644, []byte(vm.Name+"\n"))
+
+	for name, netInt := range vm.Spec.NetworkConfiguration.Interfaces {
+		if netInt.StaticIPv4Address == nil && netInt.MTU == 0 && len(netInt.Routes) == 0 && netInt.DHCPOverrides == nil {
+			continue
+		}
+		status, ok := vm.Status.NetworkStatus[name]
+		if !ok {
+			return nil, fmt.Errorf("failed to get network status for %s", name)
+		}
+		network, err := networkdConfig(status.GuestMAC, netInt)
+		if err != nil {
+			return nil, fmt.Errorf("generating network config for %s: %w", name, err)
+		}
+		addFile(path.Join(networkdDir, fmt.Sprintf("10-mikrolite-%s.network", netInt.GuestDeviceName)), 0o644, []byte(network))
+	}
+
+	if bootstrap := vm.Spec.Bootstrap; bootstrap != nil {
+		if bootstrap.SSHKey != "" {
+			data, err := afero.ReadFile(a.fs, bootstrap.SSHKey)
+			if err != nil {
+				return nil, fmt.Errorf("reading ssh key %s: %w", bootstrap.SSHKey, err)
+			}
+			config.Passwd = &ignition.Passwd{Users: []ignition.User{{
+				Name:              "ml",
+				Gecos:             "Mikrolite user",
+				Shell:             "/bin/bash",
+				SSHAuthorizedKeys: []string{strings.TrimSpace(string(data))},
+			}}}
+			addFile("/etc/sudoers.d/ml", 0o440, []byte("ml ALL=(ALL) NOPASSWD:ALL\n"))
+		}
+
+		for _, file := range bootstrap.Files {
+			content, err := base64.StdEncoding.DecodeString(file.Content)
+			if err != nil {
+				return nil, fmt.Errorf("decoding content of file %s: %w", file.Path, err)
+			}
+			mode := 0o644
+			if file.Permissions != "" {
+				parsed, err := strconv.ParseUint(file.Permissions, 8, 32)
+				if err != nil {
+					return nil, fmt.Errorf("parsing permissions of file %s: %w", file.Path, err)
+				}
+				mode = int(parsed)
+			}
+			addFile(file.Path, mode, content)
+		}
+
+		for _, unit := range bootstrap.Units {
+			config.Systemd.Units = append(config.Systemd.Units, ignition.Unit{
+				Name:     unit.Name,
+				Enabled:  boolPtr(true),
+				Contents: unit.Contents,
+			})
+		}
+
+		if bootstrap.UserData != "" {
+			data, err := base64.StdEncoding.DecodeString(bootstrap.UserData)
+			if err != nil {
+				return nil, fmt.Errorf("decoding user-data: %w", err)
+			}
+			config.Ignition.Config = &ignition.IgnitionConfig{
+				Merge: []ignition.Resource{{Source: ignition.DataURL(data)}},
+			}
+		}
+	}
+
+	if phoneHomeURL != "" {
+		config.Systemd.Units = append(config.Systemd.Units, ignition.Unit{
+			Name:     readyUnit,
+			Enabled:  boolPtr(true),
+			Contents: readyUnitContents(vm.Name, phoneHomeURL),
+		})
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling ignition config: %w", err)
+	}
+
+	return map[string]string{
+		ignition.ConfigKey: base64.StdEncoding.EncodeToString(data),
+	}, nil
+}
+
+// networkdConfig configures the interface with the mac address with
+// systemd-networkd. Its the same config as the netplan network config that
+// cloud-init uses, the interface uses dhcp unless it has a static address.
+func networkdConfig(mac string, netInt domain.NetwortInterface) (string, error) {
+	lines := []string{
+		"[Match]",
+		"MACAddress=" + mac,
+	}
+	if netInt.MTU != 0 {
+		lines = append(lines, "", "[Link]", fmt.Sprintf("MTUBytes=%d", netInt.MTU))
+	}
+
+	lines = append(lines, "", "[Network]")
+	var routes [][]string
+	if address := netInt.StaticIPv4Address; address != nil {
+		_, subnet, err := net.ParseCIDR(address.Address)
+		if err != nil {
+			return "", fmt.Errorf("parsing address %s: %w", address.Address, err)
+		}
+		lines = append(lines, "Address="+address.Address)
+		if address.Gateway != nil && *address.Gateway != "" {
+			gateway, err := getIPFromCIDR(*address.Gateway)
+			if err != nil {
+				return "", fmt.Errorf("failed to get IP from cidr %s: %w", *address.Gateway, err)
+			}
+			if subnet.Contains(net.ParseIP(gateway)) {
+				lines = append(lines, "Gateway="+gateway)
+			} else {
+				routes = append(routes, []string{"Gateway=" + gateway, "GatewayOnLink=yes"})
+			}
+		}
+		for _, nameserver := range address.Nameservers {
+			lines = append(lines, "DNS="+nameserver)
+		}
+		if len(address.SearchDomains) > 0 {
+			lines = append(lines, "Domains="+strings.Join(address.SearchDomains, " "))
+		}
+	} else {
+		lines = append(lines, "DHCP=ipv4")
+	}
+
+	for _, route := range netInt.Routes {
+		section := []string{"Gateway=" + route.Via}
+		if route.To != domain.RouteDefault {
+			section = append(section, "Destination="+route.To)
+		}
+		if route.Metric != nil {
+			section = append(section, fmt.Sprintf("Metric=%d", *route.Metric))
+		}
+		if route.OnLink {
+			section = append(section, "GatewayOnLink=yes")
+		}
+		routes = append(routes, section)
+	}
+	for _, route := range routes {
+		lines = append(append(lines, "", "[Route]"), route...)
+	}
+
+	if overrides := netInt.DHCPOverrides; overrides != nil && netInt.StaticIPv4Address == nil {
+		lines = append(lines, "", "[DHCPv4]")
+		for _, setting := range []struct {
+			key   string
+			value *bool
+		}{
+			{"UseDNS", overrides.UseDNS},
+			{"UseDomains", overrides.UseDomains},
+			{"UseRoutes", overrides.UseRoutes},
+			{"UseHostname", overrides.UseHostname},
+		} {
+			if setting.value != nil {
+				lines = append(lines, fmt.Sprintf("%s=%t", setting.key, *setting.value))
+			}
+		}
+		if overrides.RouteMetric != nil {
+			lines = append(lines, fmt.Sprintf("RouteMetric=%d", *overrides.RouteMetric))
+		}
+	}
+
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// readyUnitContents is a unit that posts to the phone home url once the vm has
+// booted, like the cloud-init phone_home module.
+func readyUnitContents(vmName, phoneHomeURL string) string {
+	return fmt.Sprintf(`[Unit]
+Description=Signal mikrolite that the vm is ready
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=/usr/bin/curl -fsS --retry %d --retry-connrefused -d instance_id=%s -d hostname=%%H %s
+
+[Install]
+WantedBy=multi-user.target
+`, phoneHomeTries, vmName, phoneHomeURL)
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
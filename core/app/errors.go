@@ -6,6 +6,28 @@ var (
 	ErrNotImplemented  = errors.New("Not implemented")
 	ErrVmSpecRequired  = errors.New("VM spec is required")
 	ErrNameRequired    = errors.New("name is required")
+	ErrInvalidName     = errors.New("invalid name")
 	ErrNoKernelSource  = errors.New("no kernel source supplied")
 	ErrVMAlreadyExists = errors.New("VM already exists")
+	ErrVMNotFound      = errors.New("VM not found")
+	ErrVMRunning       = errors.New("VM is running")
+	ErrVMNotRunning    = errors.New("VM is not running")
+
+	ErrVolumeRequired      = errors.New("volume is required")
+	ErrVolumeAlreadyExists = errors.New("volume already exists")
+	ErrVolumeNotFound      = errors.New("volume not found")
+	ErrRootVolumeDetach    = errors.New("the root volume can't be detached")
+
+	ErrUnsupportedByProvider = errors.New("not supported by the vm provider")
+
+	ErrInvalidRestartPolicy = errors.New("invalid restart policy")
+
+	ErrInvalidWaitFor = errors.New("invalid wait for")
+	ErrWaitTimeout    = errors.New("timed out waiting for the vm")
+
+	ErrInvalidBootstrap = errors.New("invalid bootstrap config")
+
+	ErrInvalidNetwork = errors.New("invalid network config")
+
+	ErrInvalidKernel = errors.New("invalid kernel config")
 )
@@ -0,0 +1,347 @@
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/firecracker-microvm/firecracker-go-sdk"
+	"gopkg.in/yaml.v2"
+
+	"github.com/mikrolite/mikrolite/cloudinit"
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+// networkRenderer renders the network config of a vm in a format that the
+// guest understands.
+type networkRenderer interface {
+	render(vm *domain.VM) ([]byte, error)
+}
+
+// networkRendererFunc is a networkRenderer that's a function.
+type networkRendererFunc func(vm *domain.VM) ([]byte, error)
+
+func (f networkRendererFunc) render(vm *domain.VM) ([]byte, error) {
+	return f(vm)
+}
+
+func defaultNetworkRenderers() map[domain.NetworkConfigFormat]networkRenderer {
+	return map[domain.NetworkConfigFormat]networkRenderer{
+		domain.NetworkConfigFormatV2:  networkRendererFunc(generateNetworkConfig),
+		domain.NetworkConfigFormatV1:  networkRendererFunc(generateNetworkConfigV1),
+		domain.NetworkConfigFormatENI: networkRendererFunc(generateNetworkInterfaces),
+	}
+}
+
+// networkConfigFormat is the format of the network config of the vm, from the
+// spec or the label of the root image. ENI can only be in the meta-data of a
+// nocloud drive, so v1 is used instead if the image asks for ENI but the vm
+// gets the network config another way.
+func networkConfigFormat(vm *domain.VM) domain.NetworkConfigFormat {
+	if format := vm.Spec.NetworkConfiguration.ConfigFormat; format != "" {
+		return format
+	}
+
+	label := vm.Status.VolumeMounts[vm.Spec.RootVolume.Name].Labels[domain.NetworkConfigFormatLabel]
+	switch format := domain.NetworkConfigFormat(label); format {
+	case "":
+	case domain.NetworkConfigFormatV2, domain.NetworkConfigFormatV1:
+		return format
+	case domain.NetworkConfigFormatENI:
+		if usesNoCloudDrive(vm) {
+			return format
+		}
+		return domain.NetworkConfigFormatV1
+	default:
+		slog.Warn("unknown network config format in the root image label, using v2", "vm", vm.Name, "format", label)
+	}
+
+	return domain.NetworkConfigFormatV2
+}
+
+// usesNoCloudDrive is true if the vm reads its metadata from a nocloud drive,
+// rather than from a metadata service or an OpenStack config drive that have
+// the network config on the kernel cmdline.
+func usesNoCloudDrive(vm *domain.VM) bool {
+	switch vm.Spec.ConfigDrive() {
+	case domain.ConfigDriveNoCloud, domain.ConfigDriveNoCloudISO:
+		return true
+	case "":
+		return vm.Status.MetadataURL == ""
+	default:
+		return false
+	}
+}
+
+// sortedInterfaces returns the names of the interfaces of the vm in order, for
+// the formats that are lists of interfaces.
+func sortedInterfaces(vm *domain.VM) []string {
+	names := make([]string, 0, len(vm.Spec.NetworkConfiguration.Interfaces))
+	for name := range vm.Spec.NetworkConfiguration.Interfaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// defaultRouteCIDR is the destination of the default route, netplan also
+// accepts default but the cloud-init renderers don't.
+const defaultRouteCIDR = "0.0.0.0/0"
+
+// generateNetworkConfig generates the netplan v2 network config of the vm. The
+// interfaces are matched by their mac address.
+func generateNetworkConfig(vm *domain.VM) ([]byte, error) {
+	netConf := &cloudinit.Network{
+		Version:  2,
+		Ethernet: map[string]cloudinit.Ethernet{},
+	}
+
+	for name, netInt := range vm.Spec.NetworkConfiguration.Interfaces {
+		status, ok := vm.Status.NetworkStatus[name]
+		if !ok {
+			return nil, fmt.Errorf("failed to get network status for %s", name)
+		}
+
+		eth := &cloudinit.Ethernet{
+			Match: cloudinit.Match{
+				MACAddress: status.GuestMAC,
+			},
+			DHCP4:          firecracker.Bool(true),
+			DHCPIdentifier: firecracker.String(cloudinit.DhcpIdentifierMac),
+			MTU:            netInt.MTU,
+		}
+
+		if netInt.StaticIPv4Address != nil {
+			if err := addStaticIP(netInt.StaticIPv4Address, eth); err != nil {
+				return nil, fmt.Errorf("adding static ipv4 config: %w", err)
+			}
+		} else if overrides := netInt.DHCPOverrides; overrides != nil {
+			eth.DHCP4Overrides = &cloudinit.DHCPOverrides{
+				UseDNS:      overrides.UseDNS,
+				UseDomains:  overrides.UseDomains,
+				UseRoutes:   overrides.UseRoutes,
+				UseHostname: overrides.UseHostname,
+				RouteMetric: overrides.RouteMetric,
+			}
+		}
+
+		for _, route := range netInt.Routes {
+			eth.Routes = append(eth.Routes, toCloudInitRoute(route))
+		}
+
+		netConf.Ethernet[netInt.GuestDeviceName] = *eth
+	}
+
+	nd, err := yaml.Marshal(netConf)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling network data: %w", err)
+	}
+
+	return nd, nil
+}
+
+// addStaticIP configures the static address of the interface. The gateway is
+// the default route as gateway4 is deprecated, its on-link if its outside the
+// subnet of the address.
+func addStaticIP(ipConfig *domain.StaticIPv4Address, eth *cloudinit.Ethernet) error {
+	eth.DHCP4 = firecracker.Bool(false)
+	eth.DHCPIdentifier = nil
+	eth.Addresses = []string{ipConfig.Address}
+
+	if ipConfig.Gateway != nil && *ipConfig.Gateway != "" {
+		gwIp, err := getIPFromCIDR(*ipConfig.Gateway)
+		if err != nil {
+			return fmt.Errorf("failed to get IP from cidr %s: %w", *ipConfig.Gateway, err)
+		}
+		route := cloudinit.Routes{
+			To:  defaultRouteCIDR,
+			Via: gwIp,
+		}
+		if gatewayOutsideSubnet(ipConfig) {
+			route.OnLink = firecracker.Bool(true)
+		}
+		eth.Routes = append(eth.Routes, route)
+	}
+
+	eth.Nameservers = cloudinit.Nameservers{
+		Addresses: ipConfig.Nameservers,
+		Search:    ipConfig.SearchDomains,
+	}
+
+	return nil
+}
+
+// gatewayOutsideSubnet returns true if the gateway of the static address isn't
+// in the subnet of the address, so the route to it has to be on-link.
+func gatewayOutsideSubnet(address *domain.StaticIPv4Address) bool {
+	if address.Gateway == nil || *address.Gateway == "" {
+		return false
+	}
+	gateway, _, err := net.ParseCIDR(*address.Gateway)
+	if err != nil {
+		return false
+	}
+	_, subnet, err := net.ParseCIDR(address.Address)
+	if err != nil {
+		return false
+	}
+
+	return !subnet.Contains(gateway)
+}
+
+func toCloudInitRoute(route domain.Route) cloudinit.Routes {
+	to := route.To
+	if to == domain.RouteDefault {
+		to = defaultRouteCIDR
+	}
+
+	r := cloudinit.Routes{
+		To:     to,
+		Via:    route.Via,
+		Metric: route.Metric,
+	}
+	if route.OnLink {
+		r.OnLink = firecracker.Bool(true)
+	}
+
+	return r
+}
+
+// generateNetworkConfigV1 generates the cloud-init v1 network config of the
+// vm. v1 doesn't have dhcp overrides or on-link routes, so a gateway outside
+// the subnet of the address can't be used either.
+func generateNetworkConfigV1(vm *domain.VM) ([]byte, error) {
+	netConf := &cloudinit.NetworkV1{Version: 1}
+	var routes []cloudinit.ConfigV1
+
+	for _, name := range sortedInterfaces(vm) {
+		netInt := vm.Spec.NetworkConfiguration.Interfaces[name]
+		status, ok := vm.Status.NetworkStatus[name]
+		if !ok {
+			return nil, fmt.Errorf("failed to get network status for %s", name)
+		}
+		if netInt.DHCPOverrides != nil {
+			return nil, fmt.Errorf("interface %s has dhcp overrides, they need the v2 network config: %w", name, ErrInvalidNetwork)
+		}
+		if err := validateV1Routes(name, netInt); err != nil {
+			return nil, err
+		}
+
+		subnet := cloudinit.SubnetV1{Type: cloudinit.SubnetTypeDHCP4}
+		if address := netInt.StaticIPv4Address; address != nil {
+			subnet = cloudinit.SubnetV1{
+				Type:           cloudinit.SubnetTypeStatic,
+				Address:        address.Address,
+				DNSNameservers: address.Nameservers,
+				DNSSearch:      address.SearchDomains,
+			}
+			if address.Gateway != nil && *address.Gateway != "" {
+				gwIp, err := getIPFromCIDR(*address.Gateway)
+				if err != nil {
+					return nil, fmt.Errorf("failed to get IP from cidr %s: %w", *address.Gateway, err)
+				}
+				subnet.Gateway = gwIp
+			}
+		}
+
+		netConf.Config = append(netConf.Config, cloudinit.ConfigV1{
+			Type:       cloudinit.ConfigTypePhysical,
+			Name:       netInt.GuestDeviceName,
+			MACAddress: status.GuestMAC,
+			MTU:        netInt.MTU,
+			Subnets:    []cloudinit.SubnetV1{subnet},
+		})
+
+		for _, route := range netInt.Routes {
+			to := route.To
+			if to == domain.RouteDefault {
+				to = defaultRouteCIDR
+			}
+			routes = append(routes, cloudinit.ConfigV1{
+				Type:        cloudinit.ConfigTypeRoute,
+				Destination: to,
+				Gateway:     route.Via,
+				Metric:      route.Metric,
+			})
+		}
+	}
+	netConf.Config = append(netConf.Config, routes...)
+
+	nd, err := yaml.Marshal(netConf)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling network data: %w", err)
+	}
+
+	return nd, nil
+}
+
+// generateNetworkInterfaces generates the network config of the vm in the
+// Debian /etc/network/interfaces format. The interfaces are matched by name
+// and the routes are added with ip route when the interface is up.
+func generateNetworkInterfaces(vm *domain.VM) ([]byte, error) {
+	lines := []string{"auto lo", "iface lo inet loopback"}
+
+	for _, name := range sortedInterfaces(vm) {
+		netInt := vm.Spec.NetworkConfiguration.Interfaces[name]
+		if netInt.DHCPOverrides != nil {
+			return nil, fmt.Errorf("interface %s has dhcp overrides, they need the v2 network config: %w", name, ErrInvalidNetwork)
+		}
+		device := netInt.GuestDeviceName
+
+		lines = append(lines, "", "auto "+device)
+		var options, routes []string
+		if address := netInt.StaticIPv4Address; address != nil {
+			_, subnet, err := net.ParseCIDR(address.Address)
+			if err != nil {
+				return nil, fmt.Errorf("parsing address %s: %w", address.Address, err)
+			}
+			lines = append(lines, fmt.Sprintf("iface %s inet static", device))
+			options = append(options, "address "+address.Address)
+			if address.Gateway != nil && *address.Gateway != "" {
+				gwIp, err := getIPFromCIDR(*address.Gateway)
+				if err != nil {
+					return nil, fmt.Errorf("failed to get IP from cidr %s: %w", *address.Gateway, err)
+				}
+				if subnet.Contains(net.ParseIP(gwIp)) {
+					options = append(options, "gateway "+gwIp)
+				} else {
+					routes = append(routes, fmt.Sprintf("default via %s dev %s onlink", gwIp, device))
+				}
+			}
+			if len(address.Nameservers) > 0 {
+				options = append(options, "dns-nameservers "+strings.Join(address.Nameservers, " "))
+			}
+			if len(address.SearchDomains) > 0 {
+				options = append(options, "dns-search "+strings.Join(address.SearchDomains, " "))
+			}
+		} else {
+			lines = append(lines, fmt.Sprintf("iface %s inet dhcp", device))
+		}
+		if netInt.MTU != 0 {
+			options = append(options, fmt.Sprintf("mtu %d", netInt.MTU))
+		}
+
+		for _, route := range netInt.Routes {
+			r := fmt.Sprintf("%s via %s dev %s", route.To, route.Via, device)
+			if route.Metric != nil {
+				r += fmt.Sprintf(" metric %d", *route.Metric)
+			}
+			if route.OnLink {
+				r += " onlink"
+			}
+			routes = append(routes, r)
+		}
+		for _, route := range routes {
+			options = append(options, "post-up ip route add "+route)
+		}
+
+		for _, option := range options {
+			lines = append(lines, "    "+option)
+		}
+	}
+
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
@@ -0,0 +1,159 @@
+package app
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/firecracker-microvm/firecracker-go-sdk"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestNetworkRenderers(t *testing.T) {
+	gateway := "192.168.122.1/24"
+	metric := 100
+
+	testCases := []struct {
+		name       string
+		interfaces map[string]domain.NetwortInterface
+		// rejected are the formats that can't have the config.
+		rejected []domain.NetworkConfigFormat
+	}{
+		{
+			name:       "dhcp",
+			interfaces: map[string]domain.NetwortInterface{"eth0": {GuestDeviceName: "eth0"}},
+		},
+		{
+			name: "static",
+			interfaces: map[string]domain.NetwortInterface{"eth0": {
+				GuestDeviceName: "eth0",
+				StaticIPv4Address: &domain.StaticIPv4Address{
+					Address:       "192.168.122.20/24",
+					Gateway:       &gateway,
+					Nameservers:   []string{"1.1.1.1", "8.8.8.8"},
+					SearchDomains: []string{"example.com"},
+				},
+			}},
+		},
+		{
+			name:     "gateway outside the subnet",
+			rejected: []domain.NetworkConfigFormat{domain.NetworkConfigFormatV1},
+			interfaces: map[string]domain.NetwortInterface{"eth0": {
+				GuestDeviceName: "eth0",
+				StaticIPv4Address: &domain.StaticIPv4Address{
+					Address: "10.0.0.20/32",
+					Gateway: &gateway,
+				},
+			}},
+		},
+		{
+			name: "routes",
+			interfaces: map[string]domain.NetwortInterface{"eth0": {
+				GuestDeviceName: "eth0",
+				MTU:             9000,
+				Routes: []domain.Route{
+					{To: domain.RouteDefault, Via: "192.168.122.1", Metric: &metric},
+					{To: "10.10.0.0/16", Via: "10.0.0.1"},
+				},
+			}},
+		},
+		{
+			name:     "on-link route",
+			rejected: []domain.NetworkConfigFormat{domain.NetworkConfigFormatV1},
+			interfaces: map[string]domain.NetwortInterface{"eth0": {
+				GuestDeviceName: "eth0",
+				Routes:          []domain.Route{{To: "10.10.0.0/16", Via: "10.0.0.1", OnLink: true}},
+			}},
+		},
+		{
+			name:     "dhcp overrides",
+			rejected: []domain.NetworkConfigFormat{domain.NetworkConfigFormatV1, domain.NetworkConfigFormatENI},
+			interfaces: map[string]domain.NetwortInterface{
+				"eth0": {GuestDeviceName: "eth0"},
+				"eth1": {
+					GuestDeviceName: "eth1",
+					DHCPOverrides: &domain.DHCPOverrides{
+						UseDNS:      firecracker.Bool(false),
+						UseRoutes:   firecracker.Bool(false),
+						RouteMetric: &metric,
+					},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			vm := &domain.VM{
+				Spec: domain.VMSpec{
+					NetworkConfiguration: domain.NetworkConfiguration{Interfaces: tc.interfaces},
+				},
+				Status: &domain.VMStatus{NetworkStatus: map[string]domain.NetworkStatus{
+					"eth0": {GuestMAC: "02:00:00:00:00:10"},
+					"eth1": {GuestMAC: "02:00:00:00:00:11"},
+				}},
+			}
+
+			name := strings.ReplaceAll(tc.name, " ", "-")
+			for format, ext := range map[domain.NetworkConfigFormat]string{
+				domain.NetworkConfigFormatV2:  ".yaml",
+				domain.NetworkConfigFormatV1:  ".v1.yaml",
+				domain.NetworkConfigFormatENI: ".interfaces",
+			} {
+				config, err := defaultNetworkRenderers()[format].render(vm)
+				if slices.Contains(tc.rejected, format) {
+					if !errors.Is(err, ErrInvalidNetwork) {
+						t.Errorf("expected the %s network config to be rejected, got %v", format, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("generating %s network config: %s", format, err)
+				}
+				assertGolden(t, filepath.Join("testdata", "network", name+ext), string(config))
+			}
+
+			// Ignition vms get the same config for systemd-networkd
+			names := make([]string, 0, len(tc.interfaces))
+			for name := range tc.interfaces {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			networkd := ""
+			for _, name := range names {
+				network, err := networkdConfig(vm.Status.NetworkStatus[name].GuestMAC, tc.interfaces[name])
+				if err != nil {
+					t.Fatalf("generating networkd config: %s", err)
+				}
+				networkd += fmt.Sprintf("# %s\n%s", name, network)
+			}
+			assertGolden(t, filepath.Join("testdata", "network", name+".network"), networkd)
+		})
+	}
+}
+
+func assertGolden(t *testing.T, golden, actual string) {
+	t.Helper()
+
+	if *update {
+		if err := os.WriteFile(golden, []byte(actual), 0o644); err != nil {
+			t.Fatalf("updating %s: %s", golden, err)
+		}
+	}
+	expected, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("reading %s: %s", golden, err)
+	}
+	if actual != string(expected) {
+		t.Errorf("output doesn't match %s\nexpected:\n%s\ngot:\n%s", golden, expected, actual)
+	}
+}
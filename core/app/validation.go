@@ -0,0 +1,307 @@
+package app
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mikrolite/mikrolite/cloudinit"
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+	"github.com/mikrolite/mikrolite/ignition"
+)
+
+const (
+	// minMTU is the smallest mtu allowed for ipv4.
+	minMTU = 68
+	// maxMTU is the largest mtu of an interface.
+	maxMTU = 65535
+)
+
+// validName is what a vm name can be. The name is used as the name of the vm
+// state directory, so it mustn't be able to point outside of the state root.
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ValidateName checks that the vm name can be used. It's checked before the
+// services for the vm are created.
+func ValidateName(name string) error {
+	if name == "" {
+		return ErrNameRequired
+	}
+	if !validName.MatchString(name) {
+		return fmt.Errorf("%q must start with a letter or digit and only contain letters, digits, '_', '.' and '-': %w", name, ErrInvalidName)
+	}
+
+	return nil
+}
+
+// validateSpec checks that the spec only uses features that the vm provider supports.
+func validateSpec(spec *domain.VMSpec, caps ports.Capabilities) error {
+	if spec.VolumeSlots > 0 && !caps.HotplugDisk && !caps.HasDiskFeature(ports.DiskFeatureBackingSwap) {
+		return fmt.Errorf("volume slots: %w", ErrUnsupportedByProvider)
+	}
+
+	volumes := append([]domain.Volume{spec.RootVolume}, spec.AdditionalVolumes...)
+	for _, vol := range volumes {
+		if vol.Source.Raw != nil && !caps.HasDiskFeature(ports.DiskFeatureRawFile) {
+			return fmt.Errorf("raw volume %s: %w", vol.Name, ErrUnsupportedByProvider)
+		}
+		if vol.Source.Container != nil && !caps.HasDiskFeature(ports.DiskFeatureBlockDevice) {
+			return fmt.Errorf("container volume %s: %w", vol.Name, ErrUnsupportedByProvider)
+		}
+	}
+
+	if err := validateKernel(spec.Kernel); err != nil {
+		return err
+	}
+	if err := validateNetwork(spec.NetworkConfiguration); err != nil {
+		return err
+	}
+	if spec.NetworkConfiguration.ConfigFormat != "" && spec.BootstrapFormat() == domain.BootstrapFormatIgnition {
+		return fmt.Errorf("the network config format can't be set with ignition, it uses systemd-networkd: %w", ErrInvalidNetwork)
+	}
+	// The OpenStack layout has the network config on the kernel cmdline
+	if spec.NetworkConfiguration.ConfigFormat == domain.NetworkConfigFormatENI && spec.ConfigDrive() == domain.ConfigDriveOpenStack {
+		return fmt.Errorf("the eni network config can't be used with the openstack config drive: %w", ErrInvalidNetwork)
+	}
+	for name, netInt := range spec.NetworkConfiguration.Interfaces {
+		if !caps.HasNetworkFeature(ports.NetworkFeatureTap) {
+			return fmt.Errorf("network interface %s: %w", name, ErrUnsupportedByProvider)
+		}
+		if netInt.AllowMetadataRequests && !caps.HasNetworkFeature(ports.NetworkFeatureMetadataAccess) {
+			return fmt.Errorf("metadata requests on network interface %s: %w", name, ErrUnsupportedByProvider)
+		}
+	}
+
+	if spec.GuestAgent && !caps.Vsock {
+		return fmt.Errorf("guest agent: %w", ErrUnsupportedByProvider)
+	}
+
+	if err := validateRestartPolicy(spec.RestartPolicy); err != nil {
+		return err
+	}
+	if err := validateBootstrap(spec.Bootstrap); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateWaitFor checks what creating the vm waits for. Waiting for ready needs
+// the phone_home that mikrolite adds to the user-data, so the user-data can't be
+// replaced.
+func validateWaitFor(waitFor domain.WaitFor, timeout time.Duration, spec *domain.VMSpec) error {
+	switch waitFor {
+	case domain.WaitForReady:
+		key := cloudinit.UserdataKey
+		if spec.BootstrapFormat() == domain.BootstrapFormatIgnition {
+			key = ignition.ConfigKey
+		}
+		if _, ok := spec.Metadata[key]; ok {
+			return fmt.Errorf("waiting for ready with user-data in the metadata: %w", ErrInvalidWaitFor)
+		}
+	case domain.WaitForIP, domain.WaitForNone:
+	default:
+		return fmt.Errorf("%q, expected ready, ip or none: %w", waitFor, ErrInvalidWaitFor)
+	}
+	if timeout < 0 {
+		return fmt.Errorf("the timeout can't be negative: %w", ErrInvalidWaitFor)
+	}
+
+	return nil
+}
+
+// validateKernel checks that the initrd comes from either an image or the host,
+// like the kernel.
+func validateKernel(kernel domain.Kernel) error {
+	initrd := kernel.Initrd
+	if initrd == nil {
+		return nil
+	}
+	if (initrd.Container == nil) == (initrd.HostPath == nil) {
+		return fmt.Errorf("the initrd must be from an image or a host path: %w", ErrInvalidKernel)
+	}
+	if initrd.Filename == "" {
+		return fmt.Errorf("the initrd filename is required: %w", ErrInvalidKernel)
+	}
+
+	return nil
+}
+
+// validateNetwork checks that the network config of the interfaces can be
+// used in the guest.
+func validateNetwork(network domain.NetworkConfiguration) error {
+	switch network.ConfigFormat {
+	case "", domain.NetworkConfigFormatV2, domain.NetworkConfigFormatV1, domain.NetworkConfigFormatENI:
+	default:
+		return fmt.Errorf("network config format %q, expected v2, v1 or eni: %w", network.ConfigFormat, ErrInvalidNetwork)
+	}
+
+	for name, netInt := range network.Interfaces {
+		if netInt.DHCPOverrides != nil && (network.ConfigFormat == domain.NetworkConfigFormatV1 || network.ConfigFormat == domain.NetworkConfigFormatENI) {
+			return fmt.Errorf("interface %s dhcp overrides need the v2 network config: %w", name, ErrInvalidNetwork)
+		}
+		if network.ConfigFormat == domain.NetworkConfigFormatV1 {
+			if err := validateV1Routes(name, netInt); err != nil {
+				return err
+			}
+		}
+		if netInt.MTU != 0 && (netInt.MTU < minMTU || netInt.MTU > maxMTU) {
+			return fmt.Errorf("interface %s mtu %d must be between %d and %d: %w", name, netInt.MTU, minMTU, maxMTU, ErrInvalidNetwork)
+		}
+
+		if address := netInt.StaticIPv4Address; address != nil {
+			if netInt.DHCPOverrides != nil {
+				return fmt.Errorf("interface %s has dhcp overrides and a static address: %w", name, ErrInvalidNetwork)
+			}
+			if _, _, err := net.ParseCIDR(address.Address); err != nil {
+				return fmt.Errorf("interface %s address %q isn't a cidr: %w", name, address.Address, ErrInvalidNetwork)
+			}
+			if address.Gateway != nil && *address.Gateway != "" {
+				if _, _, err := net.ParseCIDR(*address.Gateway); err != nil {
+					return fmt.Errorf("interface %s gateway %q isn't a cidr: %w", name, *address.Gateway, ErrInvalidNetwork)
+				}
+			}
+			for _, nameserver := range address.Nameservers {
+				if net.ParseIP(nameserver) == nil {
+					return fmt.Errorf("interface %s nameserver %q isn't an ip address: %w", name, nameserver, ErrInvalidNetwork)
+				}
+			}
+		}
+
+		for _, route := range netInt.Routes {
+			if _, _, err := net.ParseCIDR(route.To); err != nil && route.To != domain.RouteDefault {
+				return fmt.Errorf("interface %s route to %q isn't a cidr or default: %w", name, route.To, ErrInvalidNetwork)
+			}
+			if net.ParseIP(route.Via) == nil {
+				return fmt.Errorf("interface %s route via %q isn't an ip address: %w", name, route.Via, ErrInvalidNetwork)
+			}
+			if route.Metric != nil && *route.Metric < 0 {
+				return fmt.Errorf("interface %s route metric can't be negative: %w", name, ErrInvalidNetwork)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateV1Routes checks that the interface doesn't need on-link routes,
+// which the v1 network config doesn't have.
+func validateV1Routes(name string, netInt domain.NetwortInterface) error {
+	for _, route := range netInt.Routes {
+		if route.OnLink {
+			return fmt.Errorf("interface %s on-link route to %s needs the v2 or eni network config: %w", name, route.To, ErrInvalidNetwork)
+		}
+	}
+	if netInt.StaticIPv4Address != nil && gatewayOutsideSubnet(netInt.StaticIPv4Address) {
+		return fmt.Errorf("interface %s gateway outside the subnet needs the v2 or eni network config: %w", name, ErrInvalidNetwork)
+	}
+
+	return nil
+}
+
+func validateRestartPolicy(policy *domain.RestartPolicy) error {
+	if policy == nil {
+		return nil
+	}
+
+	switch policy.Policy {
+	case domain.RestartPolicyNo, domain.RestartPolicyOnFailure, domain.RestartPolicyAlways:
+	default:
+		return fmt.Errorf("restart policy %q: %w", policy.Policy, ErrInvalidRestartPolicy)
+	}
+	if policy.MaxRetries < 0 || policy.BackoffSeconds < 0 {
+		return fmt.Errorf("restart policy max retries and backoff can't be negative: %w", ErrInvalidRestartPolicy)
+	}
+
+	return nil
+}
+
+// validateBootstrap checks that the user-data and vendor-data can be used by
+// the provisioning system of the guest and that the files and units can be
+// written.
+func validateBootstrap(bootstrap *domain.Bootstrap) error {
+	if bootstrap == nil {
+		return nil
+	}
+
+	switch bootstrap.Format {
+	case "", domain.BootstrapFormatCloudInit:
+		for key, value := range map[string]string{cloudinit.UserdataKey: bootstrap.UserData, cloudinit.VendorDataKey: bootstrap.VendorData} {
+			if value == "" {
+				continue
+			}
+			data, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return fmt.Errorf("decoding %s: %w", key, ErrInvalidBootstrap)
+			}
+			if _, err := cloudinit.ParseUserData(data); err != nil {
+				return fmt.Errorf("%s: %s: %w", key, err, ErrInvalidBootstrap)
+			}
+		}
+	case domain.BootstrapFormatIgnition:
+		if bootstrap.VendorData != "" {
+			return fmt.Errorf("vendor-data can't be used with ignition: %w", ErrInvalidBootstrap)
+		}
+		if bootstrap.UserData != "" {
+			data, err := base64.StdEncoding.DecodeString(bootstrap.UserData)
+			if err != nil {
+				return fmt.Errorf("decoding user-data: %w", ErrInvalidBootstrap)
+			}
+			if err := ignition.Validate(data); err != nil {
+				return fmt.Errorf("user-data: %s: %w", err, ErrInvalidBootstrap)
+			}
+		}
+	default:
+		return fmt.Errorf("format %q, expected cloud-init or ignition: %w", bootstrap.Format, ErrInvalidBootstrap)
+	}
+
+	switch bootstrap.Datasource {
+	case "", domain.DatasourceNoCloud:
+	case domain.DatasourceEC2:
+		if bootstrap.Format == domain.BootstrapFormatIgnition {
+			return fmt.Errorf("the ec2 datasource can't be used with ignition: %w", ErrInvalidBootstrap)
+		}
+	default:
+		return fmt.Errorf("datasource %q, expected nocloud or ec2: %w", bootstrap.Datasource, ErrInvalidBootstrap)
+	}
+
+	switch bootstrap.ConfigDrive {
+	case "":
+	case domain.ConfigDriveNoCloud, domain.ConfigDriveNoCloudISO, domain.ConfigDriveOpenStack:
+		if bootstrap.Datasource == domain.DatasourceEC2 {
+			return fmt.Errorf("the ec2 datasource can't be used with a config drive: %w", ErrInvalidBootstrap)
+		}
+		// Ignition only reads the OpenStack layout
+		if bootstrap.Format == domain.BootstrapFormatIgnition && bootstrap.ConfigDrive != domain.ConfigDriveOpenStack {
+			return fmt.Errorf("ignition can only use the openstack config drive: %w", ErrInvalidBootstrap)
+		}
+	default:
+		return fmt.Errorf("config drive %q, expected nocloud, nocloud-iso or openstack: %w", bootstrap.ConfigDrive, ErrInvalidBootstrap)
+	}
+
+	for _, file := range bootstrap.Files {
+		if !path.IsAbs(file.Path) {
+			return fmt.Errorf("file path %q isn't absolute: %w", file.Path, ErrInvalidBootstrap)
+		}
+		if _, err := base64.StdEncoding.DecodeString(file.Content); err != nil {
+			return fmt.Errorf("decoding content of file %s: %w", file.Path, ErrInvalidBootstrap)
+		}
+		if _, err := strconv.ParseUint(file.Permissions, 8, 32); file.Permissions != "" && err != nil {
+			return fmt.Errorf("file %s permissions %q aren't octal: %w", file.Path, file.Permissions, ErrInvalidBootstrap)
+		}
+	}
+
+	for _, unit := range bootstrap.Units {
+		if unit.Name == "" || strings.Contains(unit.Name, "/") || !strings.Contains(unit.Name, ".") {
+			return fmt.Errorf("unit name %q must be a unit file name, e.g. app.service: %w", unit.Name, ErrInvalidBootstrap)
+		}
+	}
+
+	return nil
+}
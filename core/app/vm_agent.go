@@ -0,0 +1,41 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+// defaultAgentWait is how long to wait for the guest agent to signal that the
+// vm has booted.
+const defaultAgentWait = 5 * time.Minute
+
+// waitForAgent waits for the guest agent to signal that the vm has booted and
+// uses the ip address it reports, instead of looking for the ip address of the
+// mac address.
+func (a *app) waitForAgent(ctx context.Context, vm *domain.VM) error {
+	if vm.Status.VSock == nil {
+		return fmt.Errorf("guest agent: no vsock device: %w", ErrUnsupportedByProvider)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, a.agentWait)
+	defer cancel()
+
+	info, err := a.agentService.WaitReady(ctx, vm.Status.VSock)
+	if err != nil {
+		return fmt.Errorf("waiting for the guest agent: %w", err)
+	}
+
+	mac := ""
+	name, _ := vm.Spec.NetworkConfiguration.PrimaryInterface()
+	if status, ok := vm.Status.NetworkStatus[name]; ok {
+		mac = status.GuestMAC
+	}
+	vm.Status.IP = info.IPv4(mac)
+	slog.Debug("guest agent is ready", "vm", vm.Name, "ip", vm.Status.IP)
+
+	return nil
+}
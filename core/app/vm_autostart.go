@@ -0,0 +1,54 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+func (a *app) EnableVM(ctx context.Context, name string) (*domain.VM, error) {
+	return a.setAutostart(ctx, name, true)
+}
+
+func (a *app) DisableVM(ctx context.Context, name string) (*domain.VM, error) {
+	return a.setAutostart(ctx, name, false)
+}
+
+// setAutostart enables or disables starting the vm when the host boots. Its
+// safe to call again, enabling an enabled vm will update the autostart config.
+func (a *app) setAutostart(ctx context.Context, name string, enabled bool) (*domain.VM, error) {
+	if name == "" {
+		return nil, ErrNameRequired
+	}
+
+	vm, err := a.getCreatedVM()
+	if err != nil {
+		return nil, err
+	}
+
+	if !enabled {
+		if err := a.autostartService.Disable(ctx, name); err != nil {
+			return nil, fmt.Errorf("disabling vm: %w", err)
+		}
+	}
+
+	// The vm is saved before its enabled as systemd may start it straight away
+	vm.Spec.Autostart = enabled
+	if err := a.stateService.SaveVM(vm); err != nil {
+		return nil, fmt.Errorf("saving vm state: %w", err)
+	}
+
+	if enabled {
+		if err := a.autostartService.Enable(ctx, vm); err != nil {
+			vm.Spec.Autostart = false
+			if saveErr := a.stateService.SaveVM(vm); saveErr != nil {
+				return nil, fmt.Errorf("saving vm state: %w", saveErr)
+			}
+
+			return nil, fmt.Errorf("enabling vm: %w", err)
+		}
+	}
+
+	return vm, nil
+}
@@ -0,0 +1,64 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/testing/fakes"
+)
+
+func TestEnableDisableVM(t *testing.T) {
+	env := newTestEnv(t, basicCaps())
+	env.state.VMs[testVMName] = &domain.VM{Name: testVMName, Spec: *testSpec(), Status: &domain.VMStatus{}}
+
+	vm, err := env.app.EnableVM(context.Background(), testVMName)
+	if err != nil {
+		t.Fatalf("enabling vm: %s", err)
+	}
+	if !vm.Spec.Autostart || !env.state.VMs[testVMName].Spec.Autostart {
+		t.Errorf("expected vm to start on boot")
+	}
+	if !env.autostart.Enabled[testVMName] {
+		t.Errorf("expected autostart to be enabled")
+	}
+	// The vm must be saved first as systemd may start it straight away
+	assertMethods(t, []string{fakes.StateServiceGetVM, fakes.StateServiceSaveVM, fakes.AutostartServiceEnable}, env.rec.Methods())
+
+	vm, err = env.app.DisableVM(context.Background(), testVMName)
+	if err != nil {
+		t.Fatalf("disabling vm: %s", err)
+	}
+	if vm.Spec.Autostart || env.state.VMs[testVMName].Spec.Autostart {
+		t.Errorf("expected vm not to start on boot")
+	}
+	if env.autostart.Enabled[testVMName] {
+		t.Errorf("expected autostart to be disabled")
+	}
+}
+
+func TestEnableVMError(t *testing.T) {
+	errInjected := errors.New("injected failure")
+	env := newTestEnv(t, basicCaps())
+	env.state.VMs[testVMName] = &domain.VM{Name: testVMName, Spec: *testSpec(), Status: &domain.VMStatus{}}
+	env.rec.FailOn(fakes.AutostartServiceEnable, errInjected)
+
+	if _, err := env.app.EnableVM(context.Background(), testVMName); !errors.Is(err, errInjected) {
+		t.Fatalf("expected error %v, got %v", errInjected, err)
+	}
+	if env.state.VMs[testVMName].Spec.Autostart {
+		t.Errorf("expected autostart to be reverted")
+	}
+}
+
+func TestEnableVMNotFound(t *testing.T) {
+	env := newTestEnv(t, basicCaps())
+
+	if _, err := env.app.EnableVM(context.Background(), testVMName); !errors.Is(err, ErrVMNotFound) {
+		t.Fatalf("expected error %v, got %v", ErrVMNotFound, err)
+	}
+	if len(env.rec.CallsTo(fakes.AutostartServiceEnable)) != 0 {
+		t.Errorf("expected autostart not to be enabled")
+	}
+}
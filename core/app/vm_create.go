@@ -1,20 +1,22 @@
 package app
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net"
+	"path"
 	"strings"
 	"time"
 
-	"github.com/firecracker-microvm/firecracker-go-sdk"
 	"github.com/mikrolite/mikrolite/cloudinit"
 	"github.com/mikrolite/mikrolite/core/domain"
 	"github.com/mikrolite/mikrolite/core/ports"
 	"github.com/mikrolite/mikrolite/defaults"
+	"github.com/mikrolite/mikrolite/imds"
 	"github.com/pterm/pterm"
 	"github.com/spf13/afero"
 	"github.com/yitsushi/macpot"
@@ -22,7 +24,7 @@ import (
 )
 
 func (a *app) CreateVM(ctx context.Context, input ports.CreateVMInput) (*domain.VM, error) {
-	slog.Debug("Creating vm")
+	slog.Debug("Creating vm", "vm", input.Name)
 
 	if input.Name == "" {
 		return nil, ErrNameRequired
@@ -32,7 +34,22 @@ func (a *app) CreateVM(ctx context.Context, input ports.CreateVMInput) (*domain.
 		return nil, ErrVmSpecRequired
 	}
 
-	//TODO: add validation
+	if err := validateSpec(input.Spec, a.vmService.Capabilities()); err != nil {
+		return nil, fmt.Errorf("validating vm spec: %w", err)
+	}
+	// The ec2 datasource can only be served by a metadata service
+	if input.Spec.Datasource() == domain.DatasourceEC2 && !a.vmService.Capabilities().MetadataService && !a.hostMetadata {
+		return nil, fmt.Errorf("validating vm spec: the ec2 datasource without the host metadata server: %w", ErrUnsupportedByProvider)
+	}
+	// ENI is in the meta-data, so it can't be used to reach the metadata service
+	if input.Spec.NetworkConfiguration.ConfigFormat == domain.NetworkConfigFormatENI && input.Spec.ConfigDrive() == "" && (a.vmService.Capabilities().MetadataService || a.hostMetadata) {
+		return nil, fmt.Errorf("validating vm spec: the eni network config with a metadata service, use a nocloud config drive: %w", ErrInvalidNetwork)
+	}
+	wait := newBootWait(input)
+	if err := validateWaitFor(wait.waitFor, wait.timeout, input.Spec); err != nil {
+		return nil, fmt.Errorf("validating wait for: %w", err)
+	}
+	defer wait.close()
 
 	vm, err := a.stateService.GetVM() //TODO: handle the state better
 	if err != nil {
@@ -46,8 +63,12 @@ func (a *app) CreateVM(ctx context.Context, input ports.CreateVMInput) (*domain.
 		Name: input.Name,
 	}
 	vm.Spec = *input.Spec
+	if vm.Spec.VolumeSlots == domain.DefaultVolumeSlots {
+		vm.Spec.VolumeSlots = defaultVolumeSlots(a.vmService.Capabilities())
+	}
 	vm.Status = &domain.VMStatus{
 		VolumeMounts: map[string]domain.Mount{},
+		Owner:        input.Owner,
 	}
 	vm.Status.NetworkNamespace = fmt.Sprintf("/var/run/netns/mikrolite-%s", input.Name)
 
@@ -56,9 +77,10 @@ func (a *app) CreateVM(ctx context.Context, input ports.CreateVMInput) (*domain.
 		a.handleKernel,
 		a.handleVolumes,
 		a.handleNetwork,
-		a.handleMetadata,
+		a.handleListenForReady(wait),
+		a.handleMetadata(wait),
 		a.handleVMCreateAndStart,
-		a.handleFindIP,
+		a.handleWait(wait),
 		a.handleSaveVM,
 	}
 
@@ -67,72 +89,130 @@ func (a *app) CreateVM(ctx context.Context, input ports.CreateVMInput) (*domain.
 			return nil, err
 		}
 	}
+	slog.Info("vm created", "vm", vm.Name, "ip", vm.Status.IP)
 
 	return vm, nil
 }
 
+// defaultVolumeSlots returns the number of volume slots to reserve if its not
+// given. Slots are only needed by providers that swap the backing file of a
+// spare drive instead of hotplugging it.
+func defaultVolumeSlots(caps ports.Capabilities) int {
+	if caps.HotplugDisk || !caps.HasDiskFeature(ports.DiskFeatureBackingSwap) {
+		return 0
+	}
+
+	return defaults.VolumeSlots
+}
+
 func (a *app) handleVMCreateAndStart(ctx context.Context, owner string, vm *domain.VM) error {
 	_, err := a.vmService.Create(ctx, vm)
 	if err != nil {
 		return fmt.Errorf("creating vm: %w", err)
 	}
 
+	procStatus := &domain.ProcessStatus{
+		State:     domain.ProcessStateRunning,
+		StartedAt: a.now().UTC(),
+	}
+	if vm.Status.Process != nil {
+		// Keep the last exit when the vm is started again
+		procStatus.LastExit = vm.Status.Process.LastExit
+	}
+	vm.Status.Process = procStatus
+	// Ready is kept when the vm is started again, cloud-init only signals it on
+	// the first boot
+	vm.Status.Boot = &domain.BootStatus{StartedAt: procStatus.StartedAt}
+
 	//TODO: add start if the provider supports start
 
 	return nil
 }
 
 func (a *app) handleFindIP(ctx context.Context, owner string, vm *domain.VM) error {
-	mac := vm.Status.NetworkStatus["eth0"].GuestMAC
+	return a.findIP(ctx, vm, defaultIPWait)
+}
 
-	sleep := 500 * time.Millisecond
-	ip, err := retry[string](40, sleep, func() (string, error) {
-		foundIp, foundErr := a.networkService.GetIPFromMac(mac)
-		if foundErr != nil {
-			return "", foundErr
+// findIP finds the ip address of the vm and records when it was found. If the
+// vm uses the guest agent the ip address it reports is used instead.
+func (a *app) findIP(ctx context.Context, vm *domain.VM, timeout time.Duration) error {
+	if vm.Spec.GuestAgent {
+		if err := a.waitForAgent(ctx, vm); err != nil {
+			return err
 		}
-		if foundIp == "" {
-			return "", errors.New("couldn't find ip address")
+	} else {
+		name, _ := vm.Spec.NetworkConfiguration.PrimaryInterface()
+		mac := vm.Status.NetworkStatus[name].GuestMAC
+
+		attempts := max(int(timeout/ipPollInterval), 1)
+		ip, err := retry[string](attempts, ipPollInterval, func() (string, error) {
+			foundIp, foundErr := a.networkService.GetIPFromMac(mac)
+			if foundErr != nil {
+				return "", foundErr
+			}
+			if foundIp == "" {
+				return "", errors.New("couldn't find ip address")
+			}
+
+			return foundIp, nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to find ip address for vm: %w", ErrWaitTimeout)
 		}
 
-		return foundIp, nil
-	})
-	if err != nil {
-		return errors.New("failed to find ip address for vm")
+		vm.Status.IP = ip
 	}
 
-	vm.Status.IP = ip
+	if vm.Status.Boot != nil {
+		ipAt := a.now().UTC()
+		vm.Status.Boot.IPAt = &ipAt
+	}
 
 	return nil
 }
 
+// initrdMountName makes the mount of the initrd image different to the mount of
+// the kernel image.
+const initrdMountName = "initrd"
+
 func (a *app) handleKernel(ctx context.Context, owner string, vm *domain.VM) error {
-	kernel := vm.Spec.Kernel
-	if kernel.Source.HostPath != nil {
-		vm.Status.KernelMount = &domain.Mount{
-			Type:     domain.MountTypeFilesystemPath,
-			Location: kernel.Source.HostPath.Path,
+	mount, err := a.mountKernelSource(ctx, owner, "", vm.Spec.Kernel.Source)
+	if err != nil {
+		return fmt.Errorf("getting kernel image: %w", err)
+	}
+	vm.Status.KernelMount = mount
+
+	if vm.Spec.Kernel.Initrd != nil {
+		mount, err := a.mountKernelSource(ctx, owner, initrdMountName, *vm.Spec.Kernel.Initrd)
+		if err != nil {
+			return fmt.Errorf("getting initrd image: %w", err)
 		}
+		vm.Status.InitrdMount = mount
+	}
 
-		return nil
+	return nil
+}
+
+// mountKernelSource mounts the image or host path that has the kernel, or the
+// initrd. The name makes the mount unique for the owner.
+func (a *app) mountKernelSource(ctx context.Context, owner, name string, source domain.KernelSource) (*domain.Mount, error) {
+	if source.HostPath != nil {
+		return &domain.Mount{
+			Type:     domain.MountTypeFilesystemPath,
+			Location: source.HostPath.Path,
+		}, nil
 	}
 
-	if kernel.Source.Container != nil {
-		mount, err := a.imageService.PullAndMount(ctx, ports.PullAndMountInput{
-			ImageName: kernel.Source.Container.Image,
+	if source.Container != nil {
+		return a.imageService.PullAndMount(ctx, ports.PullAndMountInput{
+			ImageName: source.Container.Image,
 			Owner:     owner,
 			UsedFor:   ports.ImageUsedForKernel,
+			Name:      name,
 		})
-		if err != nil {
-			return fmt.Errorf("getting kernel image: %w", err)
-		}
-
-		vm.Status.KernelMount = mount
-
-		return nil
 	}
 
-	return errors.New("unexpected")
+	return nil, errors.New("unexpected")
 }
 
 func (a *app) handleVolumes(ctx context.Context, owner string, vm *domain.VM) error {
@@ -170,6 +250,7 @@ func (a *app) handleVolume(ctx context.Context, owner string, volume *domain.Vol
 			ImageName: volume.Source.Container.Image,
 			Owner:     owner,
 			UsedFor:   ports.ImageUsedForVolume,
+			Name:      volume.Name,
 		})
 	}
 
@@ -177,27 +258,62 @@ func (a *app) handleVolume(ctx context.Context, owner string, volume *domain.Vol
 }
 
 func (a *app) handleMetadataService(ctx context.Context, owner string, vm *domain.VM) error {
-	if !a.vmService.HasMetadataService() {
-		slog.Debug("vm provider doesn't have metadata service")
+	if vm.Spec.ConfigDrive() != "" {
+		slog.Debug("vm reads its metadata from a config drive", "layout", vm.Spec.ConfigDrive())
 
 		return nil
 	}
 
+	guestAddress := "169.254.169.200/16"
+	if !a.vmService.Capabilities().MetadataService {
+		if !a.hostMetadata {
+			slog.Debug("vm provider doesn't have metadata service")
+
+			return nil
+		}
+
+		// The metadata server on the host tells the vms apart by their address
+		address, err := a.newMetadataAddress(vm.Name)
+		if err != nil {
+			return err
+		}
+		guestAddress = address + "/16"
+	}
+
+	name := metadataInterfaceName(vm.Spec.NetworkConfiguration.Interfaces)
 	metadataInt := &domain.NetwortInterface{
-		GuestDeviceName:       "eth1",
+		GuestDeviceName:       name,
 		AllowMetadataRequests: true,
 		AttachToBridge:        false,
 		StaticIPv4Address: &domain.StaticIPv4Address{
-			Address: "169.254.169.200/16",
+			Address: guestAddress,
 			//Gateway: firecracker.String("169.254.169.254/16"),
 		},
 	}
 
-	vm.Spec.NetworkConfiguration.Interfaces["eth1"] = *metadataInt
+	vm.Spec.NetworkConfiguration.Interfaces[name] = *metadataInt
+	vm.Status.MetadataURL = defaults.MetadataURL
 
 	return nil
 }
 
+// metadataInterfaceName returns the first ethN name, from eth1, that isn't used
+// by the interfaces of the spec. Flintlock specs name their own interfaces.
+func metadataInterfaceName(interfaces map[string]domain.NetwortInterface) string {
+	used := map[string]bool{}
+	for name, netInt := range interfaces {
+		used[name] = true
+		used[netInt.GuestDeviceName] = true
+	}
+
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("eth%d", i)
+		if !used[name] {
+			return name
+		}
+	}
+}
+
 func (a *app) handleNetwork(ctx context.Context, owner string, vm *domain.VM) error {
 	pterm.DefaultSpinner.Info("ℹ️  Setting up network")
 
@@ -243,53 +359,111 @@ func (a *app) handleNetwork(ctx context.Context, owner string, vm *domain.VM) er
 				return fmt.Errorf("attching vm interface to bridge: %w", attachErr)
 			}
 		}
+		if err := a.routeToHostMetadata(vm, ifaceName, intCfg); err != nil {
+			return err
+		}
+
+		guestMAC := mac.ToString()
+		if intCfg.GuestMAC != "" {
+			guestMAC = intCfg.GuestMAC
+		}
 
 		vm.Status.NetworkStatus[name] = domain.NetworkStatus{
 			HostDeviveName: ifaceName,
-			GuestMAC:       mac.ToString(),
+			GuestMAC:       guestMAC,
 		}
 	}
 
 	return nil
 }
 
-func (a *app) handleMetadata(ctx context.Context, owner string, vm *domain.VM) error {
-	networkConfig, err := generateNetworkConfig(vm)
+// handleMetadata renders the bootstrap config of the vm into its metadata, in
+// the format that the guest uses. The metadata in the spec replaces the
+// generated values.
+func (a *app) handleMetadata(wait *bootWait) handler {
+	return func(ctx context.Context, owner string, vm *domain.VM) error {
+		format := vm.Spec.BootstrapFormat()
+		b, ok := a.bootstrappers[format]
+		if !ok {
+			return fmt.Errorf("bootstrap format %q: %w", format, ErrInvalidBootstrap)
+		}
+
+		metadata, err := b.render(vm, wait.phoneHomeURL())
+		if err != nil {
+			return fmt.Errorf("generating %s config: %w", format, err)
+		}
+		vm.Status.Metadata = metadata
+
+		for key, value := range vm.Spec.Metadata {
+			vm.Status.Metadata[key] = value
+		}
+
+		return a.saveHostMetadata(vm)
+	}
+}
+
+// renderCloudInit generates the cloud-init metadata. The user-data is
+// generated if the vm is bootstrapped or it signals when its ready.
+func (a *app) renderCloudInit(vm *domain.VM, phoneHomeURL string) (map[string]string, error) {
+	format := networkConfigFormat(vm)
+	renderer, ok := a.networkRenderers[format]
+	if !ok {
+		return nil, fmt.Errorf("network config format %q: %w", format, ErrInvalidNetwork)
+	}
+	networkConfig, err := renderer.render(vm)
 	if err != nil {
-		return fmt.Errorf("generating network config")
+		return nil, fmt.Errorf("generating %s network config: %w", format, err)
 	}
-	vm.Status.Metadata = map[string]string{
-		cloudinit.NetworkConfigDataKey: networkConfig,
+	metadata := map[string]string{}
+
+	// ENI isn't a cloud-init network config, nocloud has it in the meta-data
+	networkInterfaces := ""
+	if format == domain.NetworkConfigFormatENI {
+		networkInterfaces = string(networkConfig)
+	} else {
+		metadata[cloudinit.NetworkConfigDataKey] = base64.StdEncoding.EncodeToString(networkConfig)
 	}
 
-	metadata, err := a.createMetadata(vm)
+	instanceData, err := a.createMetadata(vm, networkInterfaces)
 	if err != nil {
-		return fmt.Errorf("generating metada data: %w", err)
+		return nil, fmt.Errorf("generating metada data: %w", err)
 	}
-	vm.Status.Metadata[cloudinit.InstanceDataKey] = metadata
+	metadata[cloudinit.InstanceDataKey] = instanceData
 
-	if vm.Spec.Bootstrap != nil {
-
-		userdata, err := a.createUserData(vm)
+	if vm.Spec.Bootstrap != nil || phoneHomeURL != "" {
+		userdata, err := a.createUserData(vm, phoneHomeURL)
 		if err != nil {
-			return fmt.Errorf("generating user data: %w", err)
+			return nil, fmt.Errorf("generating user data: %w", err)
 		}
 
-		vm.Status.Metadata[cloudinit.UserdataKey] = userdata
+		metadata[cloudinit.UserdataKey] = userdata
+	}
+	if vm.Spec.Bootstrap != nil && vm.Spec.Bootstrap.VendorData != "" {
+		metadata[cloudinit.VendorDataKey] = vm.Spec.Bootstrap.VendorData
+	}
+	// The ec2 datasource serves the ssh key as a public key of the instance
+	if vm.Spec.Datasource() == domain.DatasourceEC2 && vm.Spec.Bootstrap.SSHKey != "" {
+		data, err := afero.ReadFile(a.fs, vm.Spec.Bootstrap.SSHKey)
+		if err != nil {
+			return nil, fmt.Errorf("reading ssh key %s: %w", vm.Spec.Bootstrap.SSHKey, err)
+		}
+		metadata[imds.PublicKeyKey] = base64.StdEncoding.EncodeToString(bytes.TrimSpace(data))
 	}
 
-	return nil
-
+	return metadata, nil
 }
 
 func (a *app) handleSaveVM(ctx context.Context, owner string, vm *domain.VM) error {
 	return a.stateService.SaveVM(vm)
 }
 
-func (a *app) createMetadata(vm *domain.VM) (string, error) {
+func (a *app) createMetadata(vm *domain.VM, networkInterfaces string) (string, error) {
 	metadata := map[string]string{}
 	metadata["instance_id"] = vm.Name
 	metadata["cloud_name"] = "mikrolite"
+	if networkInterfaces != "" {
+		metadata[cloudinit.NetworkInterfacesKey] = networkInterfaces
+	}
 
 	data, err := yaml.Marshal(&metadata)
 	if err != nil {
@@ -299,7 +473,10 @@ func (a *app) createMetadata(vm *domain.VM) (string, error) {
 	return base64.StdEncoding.EncodeToString(data), nil
 }
 
-func (a *app) createUserData(vm *domain.VM) (string, error) {
+// createUserData generates the user-data and merges the user-data from the
+// bootstrap config into it. The files and phone_home are needed by mikrolite so
+// they're added after the user-data has been merged.
+func (a *app) createUserData(vm *domain.VM, phoneHomeURL string) (string, error) {
 	userdata := &cloudinit.UserData{
 		FinalMessage: "mikrolite booted system",
 		BootCommands: []string{
@@ -307,101 +484,74 @@ func (a *app) createUserData(vm *domain.VM) (string, error) {
 		},
 		HostName: vm.Name,
 	}
+	required := &cloudinit.UserData{}
+	supplied := []byte{}
 
-	if vm.Spec.Bootstrap.SSHKey != "" {
-		data, err := afero.ReadFile(a.fs, vm.Spec.Bootstrap.SSHKey)
-		if err != nil {
-			return "", fmt.Errorf("reading ssh key %s: %w", vm.Spec.Bootstrap.SSHKey, err)
-		}
-		user := cloudinit.User{
-			Name:              "ml",
-			Gecos:             "Mikrolite user",
-			Shell:             "/bin/bash",
-			Groups:            "sudo",
-			Sudo:              "ALL=(ALL) NOPASSWD:ALL",
-			SSHAuthorizedKeys: []string{string(data)},
-		}
-
-		userdata.Users = []cloudinit.User{user}
-	}
-
-	data, err := yaml.Marshal(userdata)
-	if err != nil {
-		return "", fmt.Errorf("marshalling userdate: %w", err)
-	}
-	dataWithHeader := append([]byte("## template: jinja\n#cloud-config\n\n"), data...)
-
-	return base64.StdEncoding.EncodeToString(dataWithHeader), nil
-
-}
-
-func generateNetworkConfig(vm *domain.VM) (string, error) {
-	netConf := &cloudinit.Network{
-		Version:  2,
-		Ethernet: map[string]cloudinit.Ethernet{},
-	}
+	if bootstrap := vm.Spec.Bootstrap; bootstrap != nil {
+		if bootstrap.SSHKey != "" {
+			data, err := afero.ReadFile(a.fs, bootstrap.SSHKey)
+			if err != nil {
+				return "", fmt.Errorf("reading ssh key %s: %w", bootstrap.SSHKey, err)
+			}
+			user := cloudinit.User{
+				Name:              "ml",
+				Gecos:             "Mikrolite user",
+				Shell:             "/bin/bash",
+				Groups:            "sudo",
+				Sudo:              "ALL=(ALL) NOPASSWD:ALL",
+				SSHAuthorizedKeys: []string{string(data)},
+			}
 
-	for name, netInt := range vm.Spec.NetworkConfiguration.Interfaces {
-		status, ok := vm.Status.NetworkStatus[name]
-		if !ok {
-			return "", fmt.Errorf("failed to get network status for %s", name)
+			userdata.Users = []cloudinit.User{user}
 		}
 
-		eth := &cloudinit.Ethernet{
-			Match: cloudinit.Match{
-				MACAddress: status.GuestMAC,
-			},
-			DHCP4:          firecracker.Bool(true),
-			DHCPIdentifier: firecracker.String(cloudinit.DhcpIdentifierMac),
+		for _, file := range bootstrap.Files {
+			required.WriteFiles = append(required.WriteFiles, cloudinit.WriteFile{
+				Encoding:    "b64",
+				Content:     file.Content,
+				Path:        file.Path,
+				Permissions: file.Permissions,
+			})
 		}
 
-		if netInt.StaticIPv4Address != nil {
-			if err := addStaticIP(netInt.StaticIPv4Address, eth); err != nil {
-				return "", fmt.Errorf("adding static ipv4 config: %w", err)
+		for i, unit := range bootstrap.Units {
+			if i == 0 {
+				required.RunCommands = append(required.RunCommands, "systemctl daemon-reload")
 			}
+			required.WriteFiles = append(required.WriteFiles, cloudinit.WriteFile{
+				Encoding:    "b64",
+				Content:     base64.StdEncoding.EncodeToString([]byte(unit.Contents)),
+				Path:        path.Join(systemdUnitDir, unit.Name),
+				Permissions: "0644",
+			})
+			required.RunCommands = append(required.RunCommands, fmt.Sprintf("systemctl enable --now %s", unit.Name))
 		}
 
-		netConf.Ethernet[netInt.GuestDeviceName] = *eth
-	}
-
-	nd, err := yaml.Marshal(netConf)
-	if err != nil {
-		return "", fmt.Errorf("marshalling network data: %w", err)
-	}
-
-	return base64.StdEncoding.EncodeToString(nd), nil
-}
-
-func addStaticIP(ipConfig *domain.StaticIPv4Address, eth *cloudinit.Ethernet) error {
-	eth.DHCP4 = firecracker.Bool(false)
-	eth.Addresses = []string{ipConfig.Address}
-
-	if ipConfig.Gateway != nil && *ipConfig.Gateway != "" {
-		gwIp, err := getIPFromCIDR(*ipConfig.Gateway)
-		if err != nil {
-			return fmt.Errorf("failed to get IP from cidr %s: %w", *ipConfig.Gateway, err)
+		if bootstrap.UserData != "" {
+			var err error
+			supplied, err = base64.StdEncoding.DecodeString(bootstrap.UserData)
+			if err != nil {
+				return "", fmt.Errorf("decoding user-data: %w", err)
+			}
 		}
-
-		eth.GatewayIPv4 = gwIp
-		// eth.Routes = []cloudinit.Routes{
-		// 	{
-		// 		To:     "default",
-		// 		Via:    gwIp,
-		// 		OnLink: firecracker.Bool(true),
-		// 		Metric: firecracker.Int(100),
-		// 	},
-		// }
 	}
 
-	if len(ipConfig.Nameservers) == 0 {
-		return nil
+	if phoneHomeURL != "" {
+		// phone_home runs after everything else, so the vm is ready once its
+		// posted
+		required.PhoneHome = &cloudinit.PhoneHome{
+			URL:   phoneHomeURL,
+			Post:  []string{"instance_id", "hostname"},
+			Tries: phoneHomeTries,
+		}
 	}
 
-	eth.Nameservers = cloudinit.Nameservers{
-		Addresses: eth.Nameservers.Addresses,
+	data, err := cloudinit.Compose(userdata, supplied, required)
+	if err != nil {
+		return "", fmt.Errorf("merging user-data: %w", err)
 	}
 
-	return nil
+	return base64.StdEncoding.EncodeToString(data), nil
 }
 
 func getIPFromCIDR(cidr string) (string, error) {
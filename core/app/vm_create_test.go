@@ -0,0 +1,1174 @@
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/firecracker-microvm/firecracker-go-sdk"
+	"gopkg.in/yaml.v2"
+
+	"github.com/mikrolite/mikrolite/cloudinit"
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+	"github.com/mikrolite/mikrolite/defaults"
+	"github.com/mikrolite/mikrolite/ignition"
+	"github.com/mikrolite/mikrolite/imds"
+	"github.com/mikrolite/mikrolite/testing/fakes"
+)
+
+func TestCreateVM(t *testing.T) {
+	errInjected := errors.New("injected failure")
+
+	testCases := []struct {
+		name string
+		// caps are the provider capabilities, basicCaps is used if nil.
+		caps *ports.Capabilities
+		// spec changes the spec used to create the vm.
+		spec func(spec *domain.VMSpec)
+		// setup changes the fakes before creating the vm.
+		setup func(env *testEnv)
+		// input changes the input used to create the vm.
+		input func(input *ports.CreateVMInput)
+
+		expectErr     error
+		expectAnyErr  bool
+		expectMethods []string
+		check         func(t *testing.T, env *testEnv, vm *domain.VM)
+	}{
+		{
+			name: "calls ports in order",
+			expectMethods: []string{
+				fakes.StateServiceGetVM,
+				fakes.ImageServicePullAndMount,
+				fakes.ImageServicePullAndMount,
+				fakes.NetworkServiceBridgeExists,
+				fakes.NetworkServiceNewInterfaceName,
+				fakes.NetworkServiceInterfaceCreate,
+				fakes.NetworkServiceAttachToBridge,
+				fakes.VMProviderCreate,
+				fakes.NetworkServiceGetIPFromMac,
+				fakes.StateServiceSaveVM,
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if vm.Status.IP != testIP {
+					t.Errorf("expected ip %s, got %s", testIP, vm.Status.IP)
+				}
+				if _, ok := env.state.VMs[testVMName]; !ok {
+					t.Errorf("expected vm to be saved to state")
+				}
+				if vm.Status.KernelMount == nil || vm.Status.KernelMount.Type != domain.MountTypeFilesystemPath {
+					t.Errorf("expected kernel filesystem mount, got %v", vm.Status.KernelMount)
+				}
+				if mount := vm.Status.VolumeMounts["root"]; mount.Type != domain.MountTypeBlockDevice {
+					t.Errorf("expected root block device mount, got %v", mount)
+				}
+				status := vm.Status.NetworkStatus["eth0"]
+				if status.HostDeviveName != "mlt0" {
+					t.Errorf("expected host device mlt0, got %s", status.HostDeviveName)
+				}
+				if env.network.Attached["mlt0"] != testBridge {
+					t.Errorf("expected mlt0 to be attached to %s", testBridge)
+				}
+				if vm.Status.Process == nil || vm.Status.Process.State != domain.ProcessStateRunning {
+					t.Errorf("expected process to be running, got %v", vm.Status.Process)
+				}
+			},
+		},
+		{
+			name: "host path kernel and raw volumes don't use images",
+			spec: func(spec *domain.VMSpec) {
+				spec.Kernel.Source.Container = nil
+				spec.Kernel.Source.HostPath = &domain.HostPathKernelSource{Path: "/boot"}
+				spec.RootVolume.Source = domain.VolumeSource{
+					Raw: &domain.RawVolumeSource{Path: "/images/root.img"},
+				}
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if calls := env.rec.CallsTo(fakes.ImageServicePullAndMount); len(calls) != 0 {
+					t.Errorf("expected no image pulls, got %d", len(calls))
+				}
+				if vm.Status.KernelMount.Location != "/boot" {
+					t.Errorf("expected kernel location /boot, got %s", vm.Status.KernelMount.Location)
+				}
+				if mount := vm.Status.VolumeMounts["root"]; mount.Location != "/images/root.img" {
+					t.Errorf("expected root location /images/root.img, got %s", mount.Location)
+				}
+			},
+		},
+		{
+			name: "initrd image is mounted with the kernel",
+			spec: func(spec *domain.VMSpec) {
+				spec.Kernel.Initrd = &domain.KernelSource{
+					Filename:  "initrd.img",
+					Container: &domain.ContainerKernelSource{Image: "ghcr.io/mikrolite/initrd:dev"},
+				}
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				calls := env.rec.CallsTo(fakes.ImageServicePullAndMount)
+				if len(calls) != 3 {
+					t.Fatalf("expected 3 image pulls, got %d", len(calls))
+				}
+				input := calls[1].Args[0].(ports.PullAndMountInput)
+				if input.ImageName != "ghcr.io/mikrolite/initrd:dev" || input.Name != "initrd" || input.UsedFor != ports.ImageUsedForKernel {
+					t.Errorf("unexpected initrd pull input %+v", input)
+				}
+				if vm.Status.InitrdMount == nil || vm.Status.InitrdMount.Location == vm.Status.KernelMount.Location {
+					t.Errorf("expected a separate initrd mount, got %+v", vm.Status.InitrdMount)
+				}
+			},
+		},
+		{
+			name: "initrd without a source is rejected",
+			spec: func(spec *domain.VMSpec) {
+				spec.Kernel.Initrd = &domain.KernelSource{Filename: "initrd.img"}
+			},
+			expectErr:     ErrInvalidKernel,
+			expectMethods: []string{},
+		},
+		{
+			name: "additional volumes are mounted with their name",
+			spec: func(spec *domain.VMSpec) {
+				spec.AdditionalVolumes = []domain.Volume{
+					{
+						Name: "data",
+						Source: domain.VolumeSource{
+							Container: &domain.ContainerVolumeSource{Image: "ghcr.io/mikrolite/data:dev"},
+						},
+					},
+				}
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				calls := env.rec.CallsTo(fakes.ImageServicePullAndMount)
+				if len(calls) != 3 {
+					t.Fatalf("expected 3 image pulls, got %d", len(calls))
+				}
+				input := calls[2].Args[0].(ports.PullAndMountInput)
+				if input.Name != "data" || input.Owner != testOwner {
+					t.Errorf("unexpected pull input %+v", input)
+				}
+				if _, ok := vm.Status.VolumeMounts["data"]; !ok {
+					t.Errorf("expected data volume mount")
+				}
+			},
+		},
+		{
+			name: "metadata is generated",
+			spec: func(spec *domain.VMSpec) {
+				spec.Bootstrap = &domain.Bootstrap{SSHKey: testSSHKeyDir}
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				metadata := map[string]string{}
+				decodeYAML(t, vm.Status.Metadata[cloudinit.InstanceDataKey], &metadata)
+				if metadata["instance_id"] != testVMName {
+					t.Errorf("expected instance_id %s, got %s", testVMName, metadata["instance_id"])
+				}
+				if metadata["cloud_name"] != "mikrolite" {
+					t.Errorf("expected cloud_name mikrolite, got %s", metadata["cloud_name"])
+				}
+
+				userdataRaw := decodeBase64(t, vm.Status.Metadata[cloudinit.UserdataKey])
+				if !strings.Contains(userdataRaw, "#cloud-config") {
+					t.Errorf("expected user data to have cloud-config header")
+				}
+				userdata := cloudinit.UserData{}
+				decodeYAML(t, vm.Status.Metadata[cloudinit.UserdataKey], &userdata)
+				if userdata.HostName != testVMName {
+					t.Errorf("expected hostname %s, got %s", testVMName, userdata.HostName)
+				}
+				if len(userdata.Users) != 1 || len(userdata.Users[0].SSHAuthorizedKeys) != 1 || userdata.Users[0].SSHAuthorizedKeys[0] != testSSHKey {
+					t.Errorf("expected ssh key to be authorized, got %+v", userdata.Users)
+				}
+
+				network := cloudinit.Network{}
+				decodeYAML(t, vm.Status.Metadata[cloudinit.NetworkConfigDataKey], &network)
+				eth0, ok := network.Ethernet["eth0"]
+				if !ok {
+					t.Fatalf("expected eth0 in network config")
+				}
+				if eth0.Match.MACAddress != vm.Status.NetworkStatus["eth0"].GuestMAC {
+					t.Errorf("expected eth0 to match mac %s, got %s", vm.Status.NetworkStatus["eth0"].GuestMAC, eth0.Match.MACAddress)
+				}
+				if eth0.DHCP4 == nil || !*eth0.DHCP4 {
+					t.Errorf("expected eth0 to use dhcp")
+				}
+			},
+		},
+		{
+			name: "no user data without bootstrap",
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if _, ok := vm.Status.Metadata[cloudinit.UserdataKey]; ok {
+					t.Errorf("expected no user data")
+				}
+			},
+		},
+		{
+			name: "spec metadata replaces generated metadata",
+			spec: func(spec *domain.VMSpec) {
+				spec.Metadata = map[string]string{
+					cloudinit.UserdataKey: base64.StdEncoding.EncodeToString([]byte("#cloud-config\n")),
+				}
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if userdata := decodeBase64(t, vm.Status.Metadata[cloudinit.UserdataKey]); userdata != "#cloud-config\n" {
+					t.Errorf("expected user data from spec, got %s", userdata)
+				}
+				if _, ok := vm.Status.Metadata[cloudinit.InstanceDataKey]; !ok {
+					t.Errorf("expected generated meta data to be kept")
+				}
+			},
+		},
+		{
+			name: "guest mac from spec is used",
+			spec: func(spec *domain.VMSpec) {
+				eth0 := spec.NetworkConfiguration.Interfaces["eth0"]
+				eth0.GuestMAC = "02:00:00:00:00:aa"
+				spec.NetworkConfiguration.Interfaces["eth0"] = eth0
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if mac := vm.Status.NetworkStatus["eth0"].GuestMAC; mac != "02:00:00:00:00:aa" {
+					t.Errorf("expected guest mac 02:00:00:00:00:aa, got %s", mac)
+				}
+			},
+		},
+		{
+			name: "static ip is used in network config",
+			spec: func(spec *domain.VMSpec) {
+				gateway := "192.168.122.1/24"
+				eth0 := spec.NetworkConfiguration.Interfaces["eth0"]
+				eth0.StaticIPv4Address = &domain.StaticIPv4Address{
+					Address: "192.168.122.20/24",
+					Gateway: &gateway,
+				}
+				spec.NetworkConfiguration.Interfaces["eth0"] = eth0
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				network := cloudinit.Network{}
+				decodeYAML(t, vm.Status.Metadata[cloudinit.NetworkConfigDataKey], &network)
+				eth0 := network.Ethernet["eth0"]
+				if eth0.DHCP4 == nil || *eth0.DHCP4 {
+					t.Errorf("expected dhcp to be disabled")
+				}
+				if len(eth0.Addresses) != 1 || eth0.Addresses[0] != "192.168.122.20/24" {
+					t.Errorf("expected address 192.168.122.20/24, got %v", eth0.Addresses)
+				}
+				if len(eth0.Routes) != 1 || eth0.Routes[0].To != "0.0.0.0/0" || eth0.Routes[0].Via != "192.168.122.1" || eth0.Routes[0].OnLink != nil {
+					t.Errorf("expected the default route via 192.168.122.1, got %v", eth0.Routes)
+				}
+			},
+		},
+		{
+			name: "invalid static gateway fails",
+			spec: func(spec *domain.VMSpec) {
+				gateway := "not-a-cidr"
+				eth0 := spec.NetworkConfiguration.Interfaces["eth0"]
+				eth0.StaticIPv4Address = &domain.StaticIPv4Address{
+					Address: "192.168.122.20/24",
+					Gateway: &gateway,
+				}
+				spec.NetworkConfiguration.Interfaces["eth0"] = eth0
+			},
+			expectAnyErr: true,
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if calls := env.rec.CallsTo(fakes.VMProviderCreate); len(calls) != 0 {
+					t.Errorf("expected vm not to be created")
+				}
+			},
+		},
+		{
+			name: "route with an invalid gateway is rejected",
+			spec: func(spec *domain.VMSpec) {
+				eth0 := spec.NetworkConfiguration.Interfaces["eth0"]
+				eth0.Routes = []domain.Route{{To: "10.0.0.0/8", Via: "gateway"}}
+				spec.NetworkConfiguration.Interfaces["eth0"] = eth0
+			},
+			expectErr:     ErrInvalidNetwork,
+			expectMethods: []string{},
+		},
+		{
+			name: "mtu below the ipv4 minimum is rejected",
+			spec: func(spec *domain.VMSpec) {
+				eth0 := spec.NetworkConfiguration.Interfaces["eth0"]
+				eth0.MTU = 40
+				spec.NetworkConfiguration.Interfaces["eth0"] = eth0
+			},
+			expectErr:     ErrInvalidNetwork,
+			expectMethods: []string{},
+		},
+		{
+			name: "dhcp overrides with a static ip are rejected",
+			spec: func(spec *domain.VMSpec) {
+				eth0 := spec.NetworkConfiguration.Interfaces["eth0"]
+				eth0.StaticIPv4Address = &domain.StaticIPv4Address{Address: "192.168.122.20/24"}
+				eth0.DHCPOverrides = &domain.DHCPOverrides{UseDNS: firecracker.Bool(false)}
+				spec.NetworkConfiguration.Interfaces["eth0"] = eth0
+			},
+			expectErr:     ErrInvalidNetwork,
+			expectMethods: []string{},
+		},
+		{
+			name: "gateway outside the subnet is rejected with v1",
+			spec: func(spec *domain.VMSpec) {
+				gateway := "192.168.122.1/24"
+				eth0 := spec.NetworkConfiguration.Interfaces["eth0"]
+				eth0.StaticIPv4Address = &domain.StaticIPv4Address{Address: "10.0.0.20/32", Gateway: &gateway}
+				spec.NetworkConfiguration.Interfaces["eth0"] = eth0
+				spec.NetworkConfiguration.ConfigFormat = domain.NetworkConfigFormatV1
+			},
+			expectErr:     ErrInvalidNetwork,
+			expectMethods: []string{},
+		},
+		{
+			name: "on-link route is rejected with v1",
+			spec: func(spec *domain.VMSpec) {
+				eth0 := spec.NetworkConfiguration.Interfaces["eth0"]
+				eth0.Routes = []domain.Route{{To: "10.10.0.0/16", Via: "10.0.0.1", OnLink: true}}
+				spec.NetworkConfiguration.Interfaces["eth0"] = eth0
+				spec.NetworkConfiguration.ConfigFormat = domain.NetworkConfigFormatV1
+			},
+			expectErr:     ErrInvalidNetwork,
+			expectMethods: []string{},
+		},
+		{
+			name: "v1 network config format from the spec",
+			spec: func(spec *domain.VMSpec) {
+				spec.NetworkConfiguration.ConfigFormat = domain.NetworkConfigFormatV1
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				network := cloudinit.NetworkV1{}
+				decodeYAML(t, vm.Status.Metadata[cloudinit.NetworkConfigDataKey], &network)
+				if network.Version != 1 || len(network.Config) != 1 || network.Config[0].Name != "eth0" {
+					t.Errorf("expected the v1 network config of eth0, got %+v", network)
+				}
+			},
+		},
+		{
+			name: "eni network config is in the meta-data",
+			spec: func(spec *domain.VMSpec) {
+				spec.NetworkConfiguration.ConfigFormat = domain.NetworkConfigFormatENI
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if _, ok := vm.Status.Metadata[cloudinit.NetworkConfigDataKey]; ok {
+					t.Errorf("expected no network-config with eni")
+				}
+				metadata := map[string]string{}
+				decodeYAML(t, vm.Status.Metadata[cloudinit.InstanceDataKey], &metadata)
+				if !strings.Contains(metadata[cloudinit.NetworkInterfacesKey], "iface eth0 inet dhcp") {
+					t.Errorf("expected the eni network config in the meta-data, got %v", metadata)
+				}
+			},
+		},
+		{
+			name: "network config format detected from the root image label",
+			setup: func(env *testEnv) {
+				env.image.Labels = map[string]map[string]string{
+					"ghcr.io/mikrolite/root:dev": {domain.NetworkConfigFormatLabel: "v1"},
+				}
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				network := cloudinit.NetworkV1{}
+				decodeYAML(t, vm.Status.Metadata[cloudinit.NetworkConfigDataKey], &network)
+				if network.Version != 1 {
+					t.Errorf("expected the v1 network config, got version %d", network.Version)
+				}
+			},
+		},
+		{
+			name: "detected eni falls back to v1 with a metadata service",
+			caps: capsPtr(metadataCaps()),
+			setup: func(env *testEnv) {
+				env.image.Labels = map[string]map[string]string{
+					"ghcr.io/mikrolite/root:dev": {domain.NetworkConfigFormatLabel: "eni"},
+				}
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				network := cloudinit.NetworkV1{}
+				decodeYAML(t, vm.Status.Metadata[cloudinit.NetworkConfigDataKey], &network)
+				if network.Version != 1 {
+					t.Errorf("expected the v1 network config, got version %d", network.Version)
+				}
+			},
+		},
+		{
+			name: "eni with a metadata service is rejected",
+			caps: capsPtr(metadataCaps()),
+			spec: func(spec *domain.VMSpec) {
+				spec.NetworkConfiguration.ConfigFormat = domain.NetworkConfigFormatENI
+			},
+			expectErr:     ErrInvalidNetwork,
+			expectMethods: []string{},
+		},
+		{
+			name: "unknown network config format is rejected",
+			spec: func(spec *domain.VMSpec) {
+				spec.NetworkConfiguration.ConfigFormat = "v3"
+			},
+			expectErr:     ErrInvalidNetwork,
+			expectMethods: []string{},
+		},
+		{
+			name: "metadata interface added for providers with a metadata service",
+			caps: capsPtr(metadataCaps()),
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				eth1, ok := vm.Spec.NetworkConfiguration.Interfaces["eth1"]
+				if !ok {
+					t.Fatalf("expected metadata interface eth1")
+				}
+				if !eth1.AllowMetadataRequests || eth1.AttachToBridge {
+					t.Errorf("unexpected metadata interface config %+v", eth1)
+				}
+				status := vm.Status.NetworkStatus["eth1"]
+				if !strings.HasPrefix(status.HostDeviveName, "mltm") {
+					t.Errorf("expected metadata host device to have mltm prefix, got %s", status.HostDeviveName)
+				}
+				if _, attached := env.network.Attached[status.HostDeviveName]; attached {
+					t.Errorf("expected metadata interface not to be attached to the bridge")
+				}
+			},
+		},
+		{
+			name: "metadata interface doesn't replace an interface of the spec",
+			caps: capsPtr(metadataCaps()),
+			spec: func(spec *domain.VMSpec) {
+				spec.NetworkConfiguration.Interfaces["eth1"] = domain.NetwortInterface{GuestDeviceName: "eth1", AttachToBridge: true}
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if eth1 := vm.Spec.NetworkConfiguration.Interfaces["eth1"]; eth1.AllowMetadataRequests || !eth1.AttachToBridge {
+					t.Errorf("expected eth1 from the spec, got %+v", eth1)
+				}
+				eth2, ok := vm.Spec.NetworkConfiguration.Interfaces["eth2"]
+				if !ok || !eth2.AllowMetadataRequests || eth2.GuestDeviceName != "eth2" {
+					t.Errorf("expected metadata interface eth2, got %+v", eth2)
+				}
+			},
+		},
+		{
+			name: "ip is found on the primary interface",
+			caps: capsPtr(metadataCaps()),
+			spec: func(spec *domain.VMSpec) {
+				spec.NetworkConfiguration.Interfaces = map[string]domain.NetwortInterface{
+					"ens3": {GuestDeviceName: "ens3", AttachToBridge: true},
+				}
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				calls := env.rec.CallsTo(fakes.NetworkServiceGetIPFromMac)
+				if len(calls) == 0 || calls[0].Args[0] != vm.Status.NetworkStatus["ens3"].GuestMAC {
+					t.Errorf("expected the ip of ens3 to be looked up, got %v", calls)
+				}
+				if vm.Status.IP != testIP {
+					t.Errorf("expected ip %s, got %s", testIP, vm.Status.IP)
+				}
+			},
+		},
+		{
+			name:  "host metadata interface added for providers without a metadata service",
+			setup: func(env *testEnv) { env.app.(*app).hostMetadata = true },
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				eth1, ok := vm.Spec.NetworkConfiguration.Interfaces["eth1"]
+				if !ok || !eth1.AllowMetadataRequests || eth1.AttachToBridge {
+					t.Fatalf("expected metadata interface eth1, got %+v", eth1)
+				}
+				address := metadataAddress(vm)
+				if !strings.HasPrefix(address, "169.254.") || strings.HasPrefix(address, "169.254.169.") || !strings.HasSuffix(eth1.StaticIPv4Address.Address, "/16") {
+					t.Errorf("expected a link local metadata address, got %s", eth1.StaticIPv4Address.Address)
+				}
+				if vm.Status.MetadataURL != defaults.MetadataURL {
+					t.Errorf("expected metadata url %s, got %s", defaults.MetadataURL, vm.Status.MetadataURL)
+				}
+				calls := env.rec.CallsTo(fakes.NetworkServiceRouteToGuest)
+				if len(calls) != 1 || calls[0].Args[0] != vm.Status.NetworkStatus["eth1"].HostDeviveName || calls[0].Args[1] != defaults.MetadataAddress || calls[0].Args[2] != address {
+					t.Errorf("expected a route to the metadata address over the metadata interface, got %v", calls)
+				}
+				if _, ok := env.state.Metadata[cloudinit.InstanceDataKey]; !ok {
+					t.Errorf("expected the metadata to be saved for the metadata server, got %v", env.state.Metadata)
+				}
+				if len(env.rec.CallsTo(fakes.StateServiceSaveVM)) != 2 {
+					t.Errorf("expected the vm to be saved before its started")
+				}
+			},
+		},
+		{
+			name: "host metadata address isn't used by another vm",
+			setup: func(env *testEnv) {
+				env.app.(*app).hostMetadata = true
+				address, _ := env.app.(*app).newMetadataAddress(testVMName)
+				other := &domain.VM{Name: "vm2", Spec: *testSpec()}
+				other.Spec.NetworkConfiguration.Interfaces["eth1"] = domain.NetwortInterface{
+					AllowMetadataRequests: true,
+					StaticIPv4Address:     &domain.StaticIPv4Address{Address: address + "/16"},
+				}
+				env.state.VMs["vm2"] = other
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if address := metadataAddress(vm); address == metadataAddress(env.state.VMs["vm2"]) {
+					t.Errorf("expected a different address to vm2, got %s", address)
+				}
+			},
+		},
+		{
+			name: "built in metadata service doesn't use host metadata",
+			caps: capsPtr(metadataCaps()),
+			setup: func(env *testEnv) {
+				env.app.(*app).hostMetadata = true
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if address := metadataAddress(vm); address != "169.254.169.200" {
+					t.Errorf("expected the mmds address, got %s", address)
+				}
+				if calls := env.rec.CallsTo(fakes.NetworkServiceRouteToGuest); len(calls) != 0 {
+					t.Errorf("expected no routes to the host, got %v", calls)
+				}
+				if env.state.Metadata != nil {
+					t.Errorf("expected the metadata not to be saved by the app")
+				}
+			},
+		},
+		{
+			name: "no metadata interface for providers without a metadata service",
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if _, ok := vm.Spec.NetworkConfiguration.Interfaces["eth1"]; ok {
+					t.Errorf("expected no metadata interface")
+				}
+			},
+		},
+		{
+			name:      "name is required",
+			input:     func(input *ports.CreateVMInput) { input.Name = "" },
+			expectErr: ErrNameRequired,
+		},
+		{
+			name:      "spec is required",
+			input:     func(input *ports.CreateVMInput) { input.Spec = nil },
+			expectErr: ErrVmSpecRequired,
+		},
+		{
+			name: "existing vm",
+			setup: func(env *testEnv) {
+				env.state.VMs[testVMName] = &domain.VM{Name: testVMName}
+			},
+			expectErr:     ErrVMAlreadyExists,
+			expectMethods: []string{fakes.StateServiceGetVM},
+		},
+		{
+			name: "unsupported spec fields are rejected before any work",
+			spec: func(spec *domain.VMSpec) {
+				eth0 := spec.NetworkConfiguration.Interfaces["eth0"]
+				eth0.AllowMetadataRequests = true
+				spec.NetworkConfiguration.Interfaces["eth0"] = eth0
+			},
+			expectErr:     ErrUnsupportedByProvider,
+			expectMethods: []string{},
+		},
+		{
+			name: "qemu vm is created without volume slots",
+			caps: capsPtr(qemuCaps()),
+			spec: func(spec *domain.VMSpec) { spec.VolumeSlots = domain.DefaultVolumeSlots },
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if calls := env.rec.CallsTo(fakes.VMProviderCreate); len(calls) != 1 {
+					t.Errorf("expected the vm to be created, got %d calls", len(calls))
+				}
+				if vm.Spec.VolumeSlots != 0 {
+					t.Errorf("expected no volume slots, got %d", vm.Spec.VolumeSlots)
+				}
+			},
+		},
+		{
+			name: "default volume slots are reserved when the backing file is swapped",
+			caps: capsPtr(swapCaps()),
+			spec: func(spec *domain.VMSpec) { spec.VolumeSlots = domain.DefaultVolumeSlots },
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if vm.Spec.VolumeSlots != defaults.VolumeSlots {
+					t.Errorf("expected %d volume slots, got %d", defaults.VolumeSlots, vm.Spec.VolumeSlots)
+				}
+			},
+		},
+		{
+			name: "no volume slots can be asked for",
+			caps: capsPtr(swapCaps()),
+			spec: func(spec *domain.VMSpec) { spec.VolumeSlots = 0 },
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if vm.Spec.VolumeSlots != 0 {
+					t.Errorf("expected no volume slots, got %d", vm.Spec.VolumeSlots)
+				}
+			},
+		},
+		{
+			name:          "volume slots need hotplug or backing swap",
+			caps:          capsPtr(qemuCaps()),
+			spec:          func(spec *domain.VMSpec) { spec.VolumeSlots = 2 },
+			expectErr:     ErrUnsupportedByProvider,
+			expectMethods: []string{},
+		},
+		{
+			name: "invalid restart policy is rejected",
+			spec: func(spec *domain.VMSpec) {
+				spec.RestartPolicy = &domain.RestartPolicy{Policy: "sometimes"}
+			},
+			expectErr:     ErrInvalidRestartPolicy,
+			expectMethods: []string{},
+		},
+		{
+			name: "guest agent reports the ip address",
+			caps: capsPtr(vsockCaps()),
+			spec: func(spec *domain.VMSpec) { spec.GuestAgent = true },
+			setup: func(env *testEnv) {
+				env.vm.VSock = &domain.VSockStatus{CID: 3, Socket: "/state/vm1/vsock.sock"}
+				env.agent.Info = &domain.GuestInfo{
+					Interfaces: []domain.GuestInterface{
+						{Name: "lo", Addresses: []string{"127.0.0.1/8"}},
+						{Name: "eth0", Addresses: []string{"fe80::1/64", testAgentIP + "/24"}},
+					},
+				}
+			},
+			expectMethods: []string{
+				fakes.StateServiceGetVM,
+				fakes.ImageServicePullAndMount,
+				fakes.ImageServicePullAndMount,
+				fakes.NetworkServiceBridgeExists,
+				fakes.NetworkServiceNewInterfaceName,
+				fakes.NetworkServiceInterfaceCreate,
+				fakes.NetworkServiceAttachToBridge,
+				fakes.VMProviderCreate,
+				fakes.GuestAgentServiceWaitReady,
+				fakes.StateServiceSaveVM,
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if vm.Status.IP != testAgentIP {
+					t.Errorf("expected ip %s, got %s", testAgentIP, vm.Status.IP)
+				}
+				calls := env.rec.CallsTo(fakes.GuestAgentServiceWaitReady)
+				if len(calls) != 1 || calls[0].Args[0] != "/state/vm1/vsock.sock" {
+					t.Errorf("expected to wait for the agent on the vsock socket, got %v", calls)
+				}
+			},
+		},
+		{
+			name:          "guest agent needs vsock",
+			spec:          func(spec *domain.VMSpec) { spec.GuestAgent = true },
+			expectErr:     ErrUnsupportedByProvider,
+			expectMethods: []string{},
+		},
+		{
+			name:          "guest agent isn't supported by qemu",
+			caps:          capsPtr(qemuCaps()),
+			spec:          func(spec *domain.VMSpec) { spec.GuestAgent = true },
+			expectErr:     ErrUnsupportedByProvider,
+			expectMethods: []string{},
+		},
+		{
+			name:      "guest agent needs the provider to set the vsock device",
+			caps:      capsPtr(vsockCaps()),
+			spec:      func(spec *domain.VMSpec) { spec.GuestAgent = true },
+			expectErr: ErrUnsupportedByProvider,
+		},
+		{
+			name: "guest agent error is returned",
+			caps: capsPtr(vsockCaps()),
+			spec: func(spec *domain.VMSpec) { spec.GuestAgent = true },
+			setup: func(env *testEnv) {
+				env.vm.VSock = &domain.VSockStatus{CID: 3, Socket: "/state/vm1/vsock.sock"}
+				env.rec.FailOn(fakes.GuestAgentServiceWaitReady, errInjected)
+			},
+			expectErr: errInjected,
+		},
+		{
+			name:  "waiting for ready signals with phone home",
+			input: func(input *ports.CreateVMInput) { input.WaitFor = domain.WaitForReady },
+			expectMethods: []string{
+				fakes.StateServiceGetVM,
+				fakes.ImageServicePullAndMount,
+				fakes.ImageServicePullAndMount,
+				fakes.NetworkServiceBridgeExists,
+				fakes.NetworkServiceNewInterfaceName,
+				fakes.NetworkServiceInterfaceCreate,
+				fakes.NetworkServiceAttachToBridge,
+				fakes.NetworkServiceBridgeAddress,
+				fakes.ReadinessServiceListen,
+				fakes.VMProviderCreate,
+				fakes.NetworkServiceGetIPFromMac,
+				fakes.ReadyListenerWait,
+				fakes.StateServiceSaveVM,
+				fakes.ReadyListenerClose,
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if !vm.Status.Ready {
+					t.Errorf("expected vm to be ready")
+				}
+				boot := vm.Status.Boot
+				if boot == nil || boot.IPAt == nil || boot.ReadyAt == nil || !boot.ReadyAt.Equal(env.readiness.ReadyAt) {
+					t.Errorf("expected boot timings, got %+v", boot)
+				}
+				calls := env.rec.CallsTo(fakes.ReadinessServiceListen)
+				if len(calls) != 1 || calls[0].Args[0] != "127.0.0.1" || calls[0].Args[1] != testVMName {
+					t.Errorf("expected to listen on the bridge address, got %v", calls)
+				}
+
+				userdata := &cloudinit.UserData{}
+				decodeYAML(t, vm.Status.Metadata[cloudinit.UserdataKey], userdata)
+				if userdata.PhoneHome == nil || userdata.PhoneHome.URL != env.readiness.Listeners[0].URL() {
+					t.Errorf("expected phone home to the listener, got %+v", userdata.PhoneHome)
+				}
+				if len(userdata.Users) != 0 {
+					t.Errorf("expected no users without bootstrap, got %v", userdata.Users)
+				}
+			},
+		},
+		{
+			name: "waiting for ready times out",
+			input: func(input *ports.CreateVMInput) {
+				input.WaitFor = domain.WaitForReady
+				input.WaitTimeout = 50 * time.Millisecond
+			},
+			setup:     func(env *testEnv) { env.readiness.ReadyAt = time.Time{} },
+			expectErr: ErrWaitTimeout,
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if !env.readiness.Listeners[0].Closed {
+					t.Errorf("expected the listener to be closed")
+				}
+			},
+		},
+		{
+			name:  "not waiting doesn't look for the ip",
+			input: func(input *ports.CreateVMInput) { input.WaitFor = domain.WaitForNone },
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if calls := env.rec.CallsTo(fakes.NetworkServiceGetIPFromMac); len(calls) != 0 {
+					t.Errorf("expected no ip lookups, got %d", len(calls))
+				}
+				if vm.Status.IP != "" || vm.Status.Boot == nil || vm.Status.Boot.IPAt != nil {
+					t.Errorf("expected no ip, got %q and %+v", vm.Status.IP, vm.Status.Boot)
+				}
+			},
+		},
+		{
+			name:          "invalid wait for is rejected",
+			input:         func(input *ports.CreateVMInput) { input.WaitFor = "forever" },
+			expectErr:     ErrInvalidWaitFor,
+			expectMethods: []string{},
+		},
+		{
+			name: "waiting for ready with user-data in the spec is rejected",
+			spec: func(spec *domain.VMSpec) {
+				spec.Metadata = map[string]string{cloudinit.UserdataKey: "I2Nsb3VkLWNvbmZpZw=="}
+			},
+			input:         func(input *ports.CreateVMInput) { input.WaitFor = domain.WaitForReady },
+			expectErr:     ErrInvalidWaitFor,
+			expectMethods: []string{},
+		},
+		{
+			name: "bootstrap user-data and files are merged with the generated user-data",
+			spec: func(spec *domain.VMSpec) {
+				spec.Bootstrap = &domain.Bootstrap{
+					SSHKey:     testSSHKeyDir,
+					UserData:   base64.StdEncoding.EncodeToString([]byte("#cloud-config\nmerge_how: list(append)+dict(no_replace,recurse_list)\nruncmd: [echo hello]\nusers: [{name: extra}]\n")),
+					VendorData: base64.StdEncoding.EncodeToString([]byte("#cloud-config\npackages: [jq]\n")),
+					Files: []domain.BootstrapFile{
+						{Path: "/etc/app.conf", Content: base64.StdEncoding.EncodeToString([]byte("debug = true\n")), Permissions: "0600"},
+					},
+				}
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				userdata := &cloudinit.UserData{}
+				decodeYAML(t, vm.Status.Metadata[cloudinit.UserdataKey], userdata)
+				if len(userdata.Users) != 2 || userdata.Users[0].Name != "ml" || userdata.Users[1].Name != "extra" {
+					t.Errorf("expected the users to be appended, got %+v", userdata.Users)
+				}
+				if len(userdata.RunCommands) != 1 || userdata.HostName != testVMName {
+					t.Errorf("expected runcmd and the generated hostname, got %+v", userdata)
+				}
+				if len(userdata.WriteFiles) != 1 || userdata.WriteFiles[0].Path != "/etc/app.conf" || userdata.WriteFiles[0].Encoding != "b64" {
+					t.Errorf("expected the file to be written, got %+v", userdata.WriteFiles)
+				}
+				if vendorData := decodeBase64(t, vm.Status.Metadata[cloudinit.VendorDataKey]); vendorData != "#cloud-config\npackages: [jq]\n" {
+					t.Errorf("expected the vendor-data as is, got %q", vendorData)
+				}
+			},
+		},
+		{
+			name: "invalid bootstrap user-data is rejected",
+			spec: func(spec *domain.VMSpec) {
+				spec.Bootstrap = &domain.Bootstrap{UserData: base64.StdEncoding.EncodeToString([]byte("hostname: vm1\n"))}
+			},
+			expectErr:     ErrInvalidBootstrap,
+			expectMethods: []string{},
+		},
+		{
+			name: "bootstrap file with a relative path is rejected",
+			spec: func(spec *domain.VMSpec) {
+				spec.Bootstrap = &domain.Bootstrap{Files: []domain.BootstrapFile{{Path: "app.conf"}}}
+			},
+			expectErr:     ErrInvalidBootstrap,
+			expectMethods: []string{},
+		},
+		{
+			name: "bootstrap units are installed with cloud-init",
+			spec: func(spec *domain.VMSpec) {
+				spec.Bootstrap = &domain.Bootstrap{
+					Units: []domain.SystemdUnit{{Name: "app.service", Contents: "[Service]\nExecStart=/usr/bin/app\n"}},
+				}
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				userdata := &cloudinit.UserData{}
+				decodeYAML(t, vm.Status.Metadata[cloudinit.UserdataKey], userdata)
+				if len(userdata.WriteFiles) != 1 || userdata.WriteFiles[0].Path != "/etc/systemd/system/app.service" {
+					t.Errorf("expected the unit to be written, got %+v", userdata.WriteFiles)
+				}
+				if len(userdata.RunCommands) != 2 || userdata.RunCommands[1] != "systemctl enable --now app.service" {
+					t.Errorf("expected the unit to be enabled, got %v", userdata.RunCommands)
+				}
+			},
+		},
+		{
+			name: "ignition config is generated for the ignition format",
+			spec: func(spec *domain.VMSpec) {
+				gateway := "192.168.122.1/24"
+				eth0 := spec.NetworkConfiguration.Interfaces["eth0"]
+				eth0.StaticIPv4Address = &domain.StaticIPv4Address{
+					Address: "192.168.122.20/24",
+					Gateway: &gateway,
+				}
+				spec.NetworkConfiguration.Interfaces["eth0"] = eth0
+				spec.Bootstrap = &domain.Bootstrap{
+					Format:   domain.BootstrapFormatIgnition,
+					SSHKey:   testSSHKeyDir,
+					UserData: base64.StdEncoding.EncodeToString([]byte(`{"ignition": {"version": "3.3.0"}}`)),
+					Files: []domain.BootstrapFile{
+						{Path: "/etc/app.conf", Content: base64.StdEncoding.EncodeToString([]byte("debug = true\n")), Permissions: "0600"},
+					},
+					Units: []domain.SystemdUnit{{Name: "app.service", Contents: "[Service]\nExecStart=/usr/bin/app\n"}},
+				}
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if len(vm.Status.Metadata) != 1 {
+					t.Errorf("expected only the ignition config in the metadata, got %v", vm.Status.Metadata)
+				}
+				config := decodeIgnition(t, vm.Status.Metadata[ignition.ConfigKey])
+				if config.Ignition.Version != ignition.Version {
+					t.Errorf("expected version %s, got %s", ignition.Version, config.Ignition.Version)
+				}
+				if config.Passwd == nil || len(config.Passwd.Users) != 1 || config.Passwd.Users[0].Name != "ml" || len(config.Passwd.Users[0].SSHAuthorizedKeys) != 1 {
+					t.Errorf("expected the ml user with the ssh key, got %+v", config.Passwd)
+				}
+				files := map[string]ignition.File{}
+				for _, file := range config.Storage.Files {
+					files[file.Path] = file
+				}
+				if file, ok := files["/etc/app.conf"]; !ok || *file.Mode != 0o600 || file.Contents.Source != ignition.DataURL([]byte("debug = true\n")) {
+					t.Errorf("expected the file to be written, got %+v", file)
+				}
+				network, ok := files["/etc/systemd/network/10-mikrolite-eth0.network"]
+				if !ok || !strings.Contains(decodeBase64(t, strings.TrimPrefix(network.Contents.Source, "data:;base64,")), "Address=192.168.122.20/24\nGateway=192.168.122.1\n") {
+					t.Errorf("expected networkd config for the static address, got %+v", network)
+				}
+				for _, path := range []string{"/etc/hostname", "/etc/sudoers.d/ml"} {
+					if _, ok := files[path]; !ok {
+						t.Errorf("expected %s to be written", path)
+					}
+				}
+				if len(config.Systemd.Units) != 1 || config.Systemd.Units[0].Name != "app.service" || !*config.Systemd.Units[0].Enabled {
+					t.Errorf("expected the unit to be enabled, got %+v", config.Systemd.Units)
+				}
+				if config.Ignition.Config == nil || len(config.Ignition.Config.Merge) != 1 {
+					t.Errorf("expected the user-data to be merged, got %+v", config.Ignition.Config)
+				}
+			},
+		},
+		{
+			name: "waiting for ready with ignition adds the ready unit",
+			spec: func(spec *domain.VMSpec) {
+				spec.Bootstrap = &domain.Bootstrap{Format: domain.BootstrapFormatIgnition}
+			},
+			input: func(input *ports.CreateVMInput) { input.WaitFor = domain.WaitForReady },
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if !vm.Status.Ready {
+					t.Errorf("expected vm to be ready")
+				}
+				config := decodeIgnition(t, vm.Status.Metadata[ignition.ConfigKey])
+				if len(config.Systemd.Units) != 1 || config.Systemd.Units[0].Name != readyUnit || !strings.Contains(config.Systemd.Units[0].Contents, env.readiness.Listeners[0].URL()) {
+					t.Errorf("expected the ready unit to post to the listener, got %+v", config.Systemd.Units)
+				}
+			},
+		},
+		{
+			name: "ignition with vendor-data is rejected",
+			spec: func(spec *domain.VMSpec) {
+				spec.Bootstrap = &domain.Bootstrap{
+					Format:     domain.BootstrapFormatIgnition,
+					VendorData: base64.StdEncoding.EncodeToString([]byte(`{"ignition": {"version": "3.3.0"}}`)),
+				}
+			},
+			expectErr:     ErrInvalidBootstrap,
+			expectMethods: []string{},
+		},
+		{
+			name: "ignition with cloud-config user-data is rejected",
+			spec: func(spec *domain.VMSpec) {
+				spec.Bootstrap = &domain.Bootstrap{
+					Format:   domain.BootstrapFormatIgnition,
+					UserData: base64.StdEncoding.EncodeToString([]byte("#cloud-config\nhostname: vm1\n")),
+				}
+			},
+			expectErr:     ErrInvalidBootstrap,
+			expectMethods: []string{},
+		},
+		{
+			name: "unknown bootstrap format is rejected",
+			spec: func(spec *domain.VMSpec) {
+				spec.Bootstrap = &domain.Bootstrap{Format: "butane"}
+			},
+			expectErr:     ErrInvalidBootstrap,
+			expectMethods: []string{},
+		},
+		{
+			name: "ec2 datasource serves the ssh key as a public key",
+			caps: capsPtr(metadataCaps()),
+			spec: func(spec *domain.VMSpec) {
+				spec.Bootstrap = &domain.Bootstrap{Datasource: domain.DatasourceEC2, SSHKey: testSSHKeyDir}
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if key := decodeBase64(t, vm.Status.Metadata[imds.PublicKeyKey]); key != testSSHKey {
+					t.Errorf("expected the public key %q, got %q", testSSHKey, key)
+				}
+				if _, ok := vm.Status.Metadata[cloudinit.UserdataKey]; !ok {
+					t.Errorf("expected the user-data to be generated")
+				}
+			},
+		},
+		{
+			name: "ec2 datasource without a metadata service is rejected",
+			spec: func(spec *domain.VMSpec) {
+				spec.Bootstrap = &domain.Bootstrap{Datasource: domain.DatasourceEC2}
+			},
+			expectErr:     ErrUnsupportedByProvider,
+			expectMethods: []string{},
+		},
+		{
+			name: "ec2 datasource with ignition is rejected",
+			caps: capsPtr(metadataCaps()),
+			spec: func(spec *domain.VMSpec) {
+				spec.Bootstrap = &domain.Bootstrap{Format: domain.BootstrapFormatIgnition, Datasource: domain.DatasourceEC2}
+			},
+			expectErr:     ErrInvalidBootstrap,
+			expectMethods: []string{},
+		},
+		{
+			name: "unknown datasource is rejected",
+			spec: func(spec *domain.VMSpec) {
+				spec.Bootstrap = &domain.Bootstrap{Datasource: "azure"}
+			},
+			expectErr:     ErrInvalidBootstrap,
+			expectMethods: []string{},
+		},
+		{
+			name: "config drive is used instead of the metadata service",
+			caps: capsPtr(metadataCaps()),
+			spec: func(spec *domain.VMSpec) {
+				spec.Bootstrap = &domain.Bootstrap{ConfigDrive: domain.ConfigDriveOpenStack}
+			},
+			setup: func(env *testEnv) { env.app.(*app).hostMetadata = true },
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if _, ok := vm.Spec.NetworkConfiguration.Interfaces["eth1"]; ok {
+					t.Errorf("expected no metadata interface")
+				}
+				if vm.Status.MetadataURL != "" {
+					t.Errorf("expected no metadata url, got %s", vm.Status.MetadataURL)
+				}
+				if _, ok := vm.Status.Metadata[cloudinit.InstanceDataKey]; !ok {
+					t.Errorf("expected the metadata to be generated for the config drive")
+				}
+			},
+		},
+		{
+			name: "unknown config drive is rejected",
+			spec: func(spec *domain.VMSpec) {
+				spec.Bootstrap = &domain.Bootstrap{ConfigDrive: "vfat"}
+			},
+			expectErr:     ErrInvalidBootstrap,
+			expectMethods: []string{},
+		},
+		{
+			name: "ignition with a nocloud config drive is rejected",
+			spec: func(spec *domain.VMSpec) {
+				spec.Bootstrap = &domain.Bootstrap{Format: domain.BootstrapFormatIgnition, ConfigDrive: domain.ConfigDriveNoCloudISO}
+			},
+			expectErr:     ErrInvalidBootstrap,
+			expectMethods: []string{},
+		},
+		{
+			name: "ec2 datasource with a config drive is rejected",
+			caps: capsPtr(metadataCaps()),
+			spec: func(spec *domain.VMSpec) {
+				spec.Bootstrap = &domain.Bootstrap{Datasource: domain.DatasourceEC2, ConfigDrive: domain.ConfigDriveOpenStack}
+			},
+			expectErr:     ErrInvalidBootstrap,
+			expectMethods: []string{},
+		},
+		{
+			name:          "state error is returned",
+			setup:         func(env *testEnv) { env.rec.FailOn(fakes.StateServiceGetVM, errInjected) },
+			expectErr:     errInjected,
+			expectMethods: []string{fakes.StateServiceGetVM},
+		},
+		{
+			name:      "image error is returned",
+			setup:     func(env *testEnv) { env.rec.FailOn(fakes.ImageServicePullAndMount, errInjected) },
+			expectErr: errInjected,
+			expectMethods: []string{
+				fakes.StateServiceGetVM,
+				fakes.ImageServicePullAndMount,
+			},
+		},
+		{
+			name:         "missing bridge fails",
+			setup:        func(env *testEnv) { delete(env.network.Bridges, testBridge) },
+			expectAnyErr: true,
+			expectMethods: []string{
+				fakes.StateServiceGetVM,
+				fakes.ImageServicePullAndMount,
+				fakes.ImageServicePullAndMount,
+				fakes.NetworkServiceBridgeExists,
+			},
+		},
+		{
+			name:      "interface error is returned",
+			setup:     func(env *testEnv) { env.rec.FailOn(fakes.NetworkServiceInterfaceCreate, errInjected) },
+			expectErr: errInjected,
+			expectMethods: []string{
+				fakes.StateServiceGetVM,
+				fakes.ImageServicePullAndMount,
+				fakes.ImageServicePullAndMount,
+				fakes.NetworkServiceBridgeExists,
+				fakes.NetworkServiceNewInterfaceName,
+				fakes.NetworkServiceInterfaceCreate,
+			},
+		},
+		{
+			name:      "provider error is returned",
+			setup:     func(env *testEnv) { env.rec.FailOn(fakes.VMProviderCreate, errInjected) },
+			expectErr: errInjected,
+			expectMethods: []string{
+				fakes.StateServiceGetVM,
+				fakes.ImageServicePullAndMount,
+				fakes.ImageServicePullAndMount,
+				fakes.NetworkServiceBridgeExists,
+				fakes.NetworkServiceNewInterfaceName,
+				fakes.NetworkServiceInterfaceCreate,
+				fakes.NetworkServiceAttachToBridge,
+				fakes.VMProviderCreate,
+			},
+		},
+		{
+			name:      "save error is returned",
+			setup:     func(env *testEnv) { env.rec.FailOn(fakes.StateServiceSaveVM, errInjected) },
+			expectErr: errInjected,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			caps := basicCaps()
+			if tc.caps != nil {
+				caps = *tc.caps
+			}
+			env := newTestEnv(t, caps)
+			if tc.setup != nil {
+				tc.setup(env)
+			}
+
+			spec := testSpec()
+			if tc.spec != nil {
+				tc.spec(spec)
+			}
+			input := ports.CreateVMInput{
+				Name:  testVMName,
+				Owner: testOwner,
+				Spec:  spec,
+			}
+			if tc.input != nil {
+				tc.input(&input)
+			}
+
+			vm, err := env.app.CreateVM(context.Background(), input)
+
+			switch {
+			case tc.expectErr != nil:
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+				}
+			case tc.expectAnyErr:
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+			default:
+				if err != nil {
+					t.Fatalf("expected no error, got %s", err)
+				}
+			}
+
+			if tc.expectMethods != nil {
+				assertMethods(t, tc.expectMethods, env.rec.Methods())
+			}
+
+			if tc.check != nil {
+				tc.check(t, env, vm)
+			}
+		})
+	}
+}
+
+func capsPtr(caps ports.Capabilities) *ports.Capabilities {
+	return &caps
+}
+
+func TestValidateName(t *testing.T) {
+	for _, name := range []string{"vm1", "ns1-vm1", "vm_1.test", "1vm"} {
+		if err := ValidateName(name); err != nil {
+			t.Errorf("expected %q to be valid, got %s", name, err)
+		}
+	}
+
+	if err := ValidateName(""); !errors.Is(err, ErrNameRequired) {
+		t.Errorf("expected %v for an empty name, got %v", ErrNameRequired, err)
+	}
+	for _, name := range []string{"..", ".", "../vm1", "vm1/..", "a/b", "-vm1", ".vm1", "vm 1"} {
+		if err := ValidateName(name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("expected %q to fail with %v, got %v", name, ErrInvalidName, err)
+		}
+	}
+}
+
+func decodeBase64(t *testing.T, value string) string {
+	t.Helper()
+
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		t.Fatalf("decoding base64: %s", err)
+	}
+
+	return string(data)
+}
+
+func decodeIgnition(t *testing.T, value string) *ignition.Config {
+	t.Helper()
+
+	config := &ignition.Config{}
+	if err := json.Unmarshal([]byte(decodeBase64(t, value)), config); err != nil {
+		t.Fatalf("unmarshalling ignition config: %s", err)
+	}
+	if config.Storage == nil || config.Systemd == nil {
+		t.Fatalf("expected storage and systemd in the ignition config")
+	}
+
+	return config
+}
+
+func decodeYAML(t *testing.T, value string, out interface{}) {
+	t.Helper()
+
+	if err := yaml.Unmarshal([]byte(decodeBase64(t, value)), out); err != nil {
+		t.Fatalf("unmarshalling yaml: %s", err)
+	}
+}
@@ -0,0 +1,201 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+	"github.com/mikrolite/mikrolite/defaults"
+)
+
+// gcGracePeriod is how long a state directory without a vm is left alone, as
+// the vm is only saved once its been created.
+const gcGracePeriod = 10 * time.Minute
+
+// GC finds the resources that aren't used by a vm by cross-referencing the vm
+// state with the images, the network interfaces and the vm processes. The app
+// must be created without a vm name.
+func (a *app) GC(ctx context.Context, input ports.GCInput) (*ports.GCOutput, error) {
+	slog.Debug("Finding orphaned resources")
+
+	dirs, err := a.stateService.ListDirs()
+	if err != nil {
+		return nil, fmt.Errorf("listing vm state directories: %w", err)
+	}
+
+	vms, err := a.stateService.ListVMs()
+	if err != nil {
+		return nil, fmt.Errorf("listing vms: %w", err)
+	}
+	vmsByName := map[string]*domain.VM{}
+	for _, vm := range vms {
+		vmsByName[vm.Name] = vm
+	}
+
+	now := a.now().UTC()
+	usedOwners := map[string]bool{}
+	usedInterfaces := map[string]bool{}
+	// Interfaces are only saved with the vm so they can't be checked whilst a vm is being created
+	creating := false
+	orphans := []ports.Orphan{}
+
+	for _, dir := range dirs {
+		vm, ok := vmsByName[dir.Name]
+		if !ok {
+			if now.Sub(dir.ModTime) < gcGracePeriod {
+				usedOwners[defaultOwner(dir.Name)] = true
+				creating = true
+
+				continue
+			}
+
+			orphans = append(orphans, ports.Orphan{
+				Type:   ports.OrphanTypeState,
+				Name:   dir.Name,
+				Reason: "the state directory has no vm",
+			})
+
+			continue
+		}
+
+		// The images and interfaces of a vm orphan are removed with the vm
+		usedOwners[vmOwner(vm)] = true
+		if vm.Status != nil {
+			for _, netStatus := range vm.Status.NetworkStatus {
+				usedInterfaces[netStatus.HostDeviveName] = true
+			}
+		}
+
+		if !input.IncludeStopped || vm.Spec.Autostart {
+			continue
+		}
+
+		running, known, err := a.pidRunning(vm, dir.PID)
+		if err != nil {
+			return nil, err
+		}
+		if known && !running {
+			orphans = append(orphans, ports.Orphan{
+				Type:   ports.OrphanTypeVM,
+				Name:   vm.Name,
+				Reason: "the vm process isn't running",
+			})
+		}
+	}
+
+	owners, err := a.imageService.ListOwners(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing image owners: %w", err)
+	}
+	for _, owner := range owners {
+		if usedOwners[owner] {
+			continue
+		}
+
+		orphans = append(orphans, ports.Orphan{
+			Type:   ports.OrphanTypeImages,
+			Name:   owner,
+			Reason: "the images aren't used by a vm",
+		})
+	}
+
+	if creating {
+		slog.Debug("A vm is being created, skipping network interfaces")
+	} else {
+		// The metadata interfaces have the same prefix
+		interfaces, err := a.networkService.ListInterfaces(defaults.InterfacePrefix)
+		if err != nil {
+			return nil, fmt.Errorf("listing network interfaces: %w", err)
+		}
+		for _, name := range interfaces {
+			if usedInterfaces[name] {
+				continue
+			}
+
+			orphans = append(orphans, ports.Orphan{
+				Type:   ports.OrphanTypeInterface,
+				Name:   name,
+				Reason: "the interface isn't used by a vm",
+			})
+		}
+	}
+
+	if input.Force {
+		for i := range orphans {
+			if err := a.removeOrphan(ctx, &orphans[i], vmsByName); err != nil {
+				slog.Warn("failed to remove orphan", "type", orphans[i].Type, "name", orphans[i].Name, "error", err)
+				orphans[i].Error = err.Error()
+
+				continue
+			}
+			orphans[i].Removed = true
+		}
+	}
+
+	return &ports.GCOutput{Orphans: orphans}, nil
+}
+
+func (a *app) removeOrphan(ctx context.Context, orphan *ports.Orphan, vms map[string]*domain.VM) error {
+	slog.Info("removing orphan", "type", orphan.Type, "name", orphan.Name)
+
+	switch orphan.Type {
+	case ports.OrphanTypeVM:
+		vm := vms[orphan.Name]
+		if err := a.imageService.Cleanup(ctx, vmOwner(vm)); err != nil {
+			return fmt.Errorf("cleaning up vm images: %w", err)
+		}
+		if vm.Status != nil {
+			for _, netStatus := range vm.Status.NetworkStatus {
+				if err := a.networkService.InterfaceDelete(netStatus.HostDeviveName); err != nil {
+					return fmt.Errorf("deleting vm network interface: %w", err)
+				}
+			}
+		}
+
+		return a.removeStateDir(orphan.Name)
+	case ports.OrphanTypeState:
+		return a.removeStateDir(orphan.Name)
+	case ports.OrphanTypeImages:
+		if err := a.imageService.Cleanup(ctx, orphan.Name); err != nil {
+			return fmt.Errorf("cleaning up images: %w", err)
+		}
+
+		return nil
+	case ports.OrphanTypeInterface:
+		if err := a.networkService.InterfaceDelete(orphan.Name); err != nil {
+			return fmt.Errorf("deleting network interface: %w", err)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown orphan type %s", orphan.Type)
+	}
+}
+
+func (a *app) removeStateDir(name string) error {
+	dir := filepath.Join(a.stateService.Root(), name)
+	if err := a.fs.RemoveAll(dir); err != nil {
+		return fmt.Errorf("removing vm state directory %s: %w", dir, err)
+	}
+
+	return nil
+}
+
+// vmOwner returns the owner of the vm images. Vms created before the owner was
+// saved use the default owner.
+func vmOwner(vm *domain.VM) string {
+	if vm.Status != nil && vm.Status.Owner != "" {
+		return vm.Status.Owner
+	}
+
+	return defaultOwner(vm.Name)
+}
+
+// defaultOwner returns the owner used for a vm when one isn't supplied.
+func defaultOwner(name string) string {
+	return fmt.Sprintf("vm-%s", name)
+}
@@ -0,0 +1,215 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+	"github.com/mikrolite/mikrolite/testing/fakes"
+)
+
+// newGCEnv creates a test env with an app that isn't for a vm. It has a
+// running vm with custom owner, a stale state directory, images that aren't
+// used and an interface that isn't used.
+func newGCEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	env := newTestEnv(t, basicCaps())
+	env.state = fakes.NewStateService(env.rec, "/state", "")
+	env.app = New(env.image, env.vm, env.state, env.fs, env.network, env.process, env.autostart, env.agent, env.readiness)
+	env.app.(*app).now = func() time.Time { return testNow }
+
+	env.state.VMs[testVMName] = &domain.VM{
+		Name: testVMName,
+		Spec: *testSpec(),
+		Status: &domain.VMStatus{
+			Owner: "team-vm1",
+			NetworkStatus: map[string]domain.NetworkStatus{
+				"eth0": {HostDeviveName: "mlt0"},
+			},
+			Process: &domain.ProcessStatus{State: domain.ProcessStateRunning, StartedAt: testNow.Add(-time.Hour)},
+		},
+	}
+	env.state.Dirs[testVMName] = ports.StateDir{Name: testVMName, ModTime: testNow.Add(-time.Hour), PID: testPID}
+	env.process.RunningPIDs[testPID] = true
+	env.image.Owners["team-vm1"] = true
+	env.network.Interfaces["mlt0"] = "02:00:00:00:00:01"
+
+	env.state.Dirs["old"] = ports.StateDir{Name: "old", ModTime: testNow.Add(-time.Hour)}
+	env.image.Owners["vm-old"] = true
+	env.network.Interfaces["mltm1"] = "02:00:00:00:00:02"
+
+	for _, dir := range []string{"/state/vm1", "/state/old"} {
+		if err := env.fs.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("creating state dir: %s", err)
+		}
+	}
+
+	return env
+}
+
+func TestGC(t *testing.T) {
+	errInjected := errors.New("injected failure")
+
+	testCases := []struct {
+		name          string
+		input         ports.GCInput
+		setup         func(env *testEnv)
+		expectErr     error
+		expectOrphans []ports.Orphan
+		check         func(t *testing.T, env *testEnv)
+	}{
+		{
+			name: "orphans are only reported without force",
+			expectOrphans: []ports.Orphan{
+				{Type: ports.OrphanTypeState, Name: "old"},
+				{Type: ports.OrphanTypeImages, Name: "vm-old"},
+				{Type: ports.OrphanTypeInterface, Name: "mltm1"},
+			},
+			check: func(t *testing.T, env *testEnv) {
+				if len(env.rec.CallsTo(fakes.ImageServiceCleanup)) != 0 || len(env.rec.CallsTo(fakes.NetworkServiceInterfaceDelete)) != 0 {
+					t.Errorf("expected nothing to be removed, got %v", env.rec.Methods())
+				}
+				if exists, _ := afero.DirExists(env.fs, "/state/old"); !exists {
+					t.Errorf("expected state directory not to be removed")
+				}
+			},
+		},
+		{
+			name:  "orphans are removed with force",
+			input: ports.GCInput{Force: true},
+			expectOrphans: []ports.Orphan{
+				{Type: ports.OrphanTypeState, Name: "old", Removed: true},
+				{Type: ports.OrphanTypeImages, Name: "vm-old", Removed: true},
+				{Type: ports.OrphanTypeInterface, Name: "mltm1", Removed: true},
+			},
+			check: func(t *testing.T, env *testEnv) {
+				if exists, _ := afero.DirExists(env.fs, "/state/old"); exists {
+					t.Errorf("expected state directory to be removed")
+				}
+				if exists, _ := afero.DirExists(env.fs, "/state/vm1"); !exists {
+					t.Errorf("expected vm state directory not to be removed")
+				}
+				if env.image.Owners["vm-old"] || !env.image.Owners["team-vm1"] {
+					t.Errorf("expected only the orphaned images to be removed, got %v", env.image.Owners)
+				}
+				if _, ok := env.network.Interfaces["mltm1"]; ok {
+					t.Errorf("expected interface to be removed")
+				}
+			},
+		},
+		{
+			name:  "vm being created is left alone",
+			input: ports.GCInput{Force: true},
+			setup: func(env *testEnv) {
+				env.state.Dirs["old"] = ports.StateDir{Name: "old", ModTime: testNow.Add(-time.Minute)}
+			},
+			expectOrphans: []ports.Orphan{},
+			check: func(t *testing.T, env *testEnv) {
+				if len(env.rec.CallsTo(fakes.NetworkServiceListInterfaces)) != 0 {
+					t.Errorf("expected interfaces not to be checked")
+				}
+			},
+		},
+		{
+			name:  "stopped vm is included",
+			input: ports.GCInput{Force: true, IncludeStopped: true},
+			setup: func(env *testEnv) {
+				env.process.RunningPIDs[testPID] = false
+				delete(env.state.Dirs, "old")
+				delete(env.image.Owners, "vm-old")
+				delete(env.network.Interfaces, "mltm1")
+			},
+			expectOrphans: []ports.Orphan{
+				{Type: ports.OrphanTypeVM, Name: testVMName, Removed: true},
+			},
+			check: func(t *testing.T, env *testEnv) {
+				if exists, _ := afero.DirExists(env.fs, "/state/vm1"); exists {
+					t.Errorf("expected vm state directory to be removed")
+				}
+				if len(env.image.Owners) != 0 {
+					t.Errorf("expected vm images to be removed, got %v", env.image.Owners)
+				}
+				if _, ok := env.network.Interfaces["mlt0"]; ok {
+					t.Errorf("expected vm interface to be removed")
+				}
+			},
+		},
+		{
+			name:  "stopped vm that starts on boot is excluded",
+			input: ports.GCInput{IncludeStopped: true},
+			setup: func(env *testEnv) {
+				env.process.RunningPIDs[testPID] = false
+				env.state.VMs[testVMName].Spec.Autostart = true
+				delete(env.state.Dirs, "old")
+				delete(env.image.Owners, "vm-old")
+				delete(env.network.Interfaces, "mltm1")
+			},
+			expectOrphans: []ports.Orphan{},
+		},
+		{
+			name:  "running vm isn't included",
+			input: ports.GCInput{IncludeStopped: true},
+			setup: func(env *testEnv) {
+				delete(env.state.Dirs, "old")
+				delete(env.image.Owners, "vm-old")
+				delete(env.network.Interfaces, "mltm1")
+			},
+			expectOrphans: []ports.Orphan{},
+		},
+		{
+			name:  "removal error is recorded",
+			input: ports.GCInput{Force: true},
+			setup: func(env *testEnv) { env.rec.FailOn(fakes.ImageServiceCleanup, errInjected) },
+			expectOrphans: []ports.Orphan{
+				{Type: ports.OrphanTypeState, Name: "old", Removed: true},
+				{Type: ports.OrphanTypeImages, Name: "vm-old", Error: "cleaning up images: injected failure"},
+				{Type: ports.OrphanTypeInterface, Name: "mltm1", Removed: true},
+			},
+		},
+		{
+			name:      "list error is returned",
+			setup:     func(env *testEnv) { env.rec.FailOn(fakes.ImageServiceListOwners, errInjected) },
+			expectErr: errInjected,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newGCEnv(t)
+			if tc.setup != nil {
+				tc.setup(env)
+			}
+
+			output, err := env.app.GC(context.Background(), tc.input)
+
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+
+			if len(output.Orphans) != len(tc.expectOrphans) {
+				t.Fatalf("expected orphans %+v, got %+v", tc.expectOrphans, output.Orphans)
+			}
+			for i, expected := range tc.expectOrphans {
+				actual := output.Orphans[i]
+				if actual.Type != expected.Type || actual.Name != expected.Name || actual.Removed != expected.Removed || actual.Error != expected.Error {
+					t.Errorf("expected orphan %d to be %+v, got %+v", i, expected, actual)
+				}
+			}
+			if tc.check != nil {
+				tc.check(t, env)
+			}
+		})
+	}
+}
@@ -2,10 +2,26 @@ package app
 
 import (
 	"context"
+	"fmt"
+	"log/slog"
 
 	"github.com/mikrolite/mikrolite/core/domain"
 )
 
 func (a *app) GetVM(ctx context.Context, name string) (*domain.VM, error) {
-	return nil, ErrNotImplemented
+	slog.Debug("Getting vm", "vm", name)
+
+	if name == "" {
+		return nil, ErrNameRequired
+	}
+
+	vm, err := a.stateService.GetVM()
+	if err != nil {
+		return nil, fmt.Errorf("getting vm state: %w", err)
+	}
+	if vm == nil {
+		return nil, ErrVMNotFound
+	}
+
+	return vm, nil
 }
@@ -0,0 +1,57 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+func TestGetVM(t *testing.T) {
+	testCases := []struct {
+		name      string
+		vmName    string
+		exists    bool
+		expectErr error
+	}{
+		{
+			name:   "returns the vm",
+			vmName: testVMName,
+			exists: true,
+		},
+		{
+			name:      "missing vm",
+			vmName:    testVMName,
+			expectErr: ErrVMNotFound,
+		},
+		{
+			name:      "name required",
+			expectErr: ErrNameRequired,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTestEnv(t, basicCaps())
+			if tc.exists {
+				env.state.VMs[testVMName] = &domain.VM{Name: testVMName, Spec: *testSpec()}
+			}
+
+			vm, err := env.app.GetVM(context.Background(), tc.vmName)
+
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			if vm.Name != testVMName {
+				t.Errorf("expected vm %s, got %s", testVMName, vm.Name)
+			}
+		})
+	}
+}
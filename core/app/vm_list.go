@@ -2,10 +2,16 @@ package app
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/mikrolite/mikrolite/core/domain"
 )
 
 func (a *app) ListVMs(ctx context.Context) ([]*domain.VM, error) {
-	return nil, ErrNotImplemented
+	vms, err := a.stateService.ListVMs()
+	if err != nil {
+		return nil, fmt.Errorf("listing vms: %w", err)
+	}
+
+	return vms, nil
 }
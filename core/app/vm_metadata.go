@@ -0,0 +1,104 @@
+package app
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/defaults"
+)
+
+// metadataAddresses is the number of addresses in 169.254.0.0/16 that can be
+// given to the metadata interface of vms.
+const metadataAddresses = 1 << 16
+
+// usesHostMetadata returns true if the vm gets its metadata from the metadata
+// server on the host rather than one built in to the provider.
+func (a *app) usesHostMetadata(vm *domain.VM) bool {
+	return vm.Status.MetadataURL != "" && !a.vmService.Capabilities().MetadataService
+}
+
+// newMetadataAddress picks the address of the metadata interface of the vm. Its
+// unique on the host so that the metadata server can tell which vm a request
+// is from. The address is based on the name of the vm so that it's usually the
+// same if the vm is created again.
+func (a *app) newMetadataAddress(vmName string) (string, error) {
+	vms, err := a.stateService.ListVMs()
+	if err != nil {
+		return "", fmt.Errorf("listing vms: %w", err)
+	}
+	used := map[string]bool{}
+	for _, vm := range vms {
+		if address := metadataAddress(vm); address != "" {
+			used[address] = true
+		}
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(vmName))
+	start := hash.Sum32() % metadataAddresses
+	for i := uint32(0); i < metadataAddresses; i++ {
+		n := (start + i) % metadataAddresses
+		address := net.IPv4(169, 254, byte(n>>8), byte(n)).String()
+		// Skip the network and broadcast addresses and those near the metadata
+		// server
+		if byte(n) == 0 || byte(n) == 255 || byte(n>>8) == 169 || used[address] {
+			continue
+		}
+
+		return address, nil
+	}
+
+	return "", fmt.Errorf("no free metadata addresses")
+}
+
+// metadataAddress returns the address of the metadata interface of the vm, its
+// empty if it doesn't have one.
+func metadataAddress(vm *domain.VM) string {
+	for _, netInt := range vm.Spec.NetworkConfiguration.Interfaces {
+		if !netInt.AllowMetadataRequests || netInt.StaticIPv4Address == nil {
+			continue
+		}
+		ip, _, err := net.ParseCIDR(netInt.StaticIPv4Address.Address)
+		if err != nil {
+			continue
+		}
+
+		return ip.String()
+	}
+
+	return ""
+}
+
+// routeToHostMetadata makes the metadata server on the host reachable over the
+// metadata interface of the vm.
+func (a *app) routeToHostMetadata(vm *domain.VM, ifaceName string, intCfg domain.NetwortInterface) error {
+	if !intCfg.AllowMetadataRequests || !a.usesHostMetadata(vm) {
+		return nil
+	}
+
+	address := metadataAddress(vm)
+	if err := a.networkService.RouteToGuest(ifaceName, defaults.MetadataAddress, address); err != nil {
+		return fmt.Errorf("routing metadata requests from vm interface %s: %w", ifaceName, err)
+	}
+
+	return nil
+}
+
+// saveHostMetadata saves the metadata for the metadata server on the host. The
+// vm is saved as well so that the server can find it before it has started.
+func (a *app) saveHostMetadata(vm *domain.VM) error {
+	if !a.usesHostMetadata(vm) {
+		return nil
+	}
+
+	if err := a.stateService.SaveMetadata(vm.Status.Metadata); err != nil {
+		return fmt.Errorf("saving metadata: %w", err)
+	}
+	if err := a.stateService.SaveVM(vm); err != nil {
+		return fmt.Errorf("saving vm state: %w", err)
+	}
+
+	return nil
+}
@@ -10,6 +10,21 @@ import (
 func (a *app) RemoveVM(ctx context.Context, name string, owner string) error {
 	pterm.DefaultSpinner.Info(fmt.Sprintf("ℹ️  Removing VM: %s\n", name))
 
+	vm, err := a.stateService.GetVM()
+	if err != nil {
+		return fmt.Errorf("getting vm config: %w", err)
+	}
+	if vm == nil {
+		return ErrVMNotFound
+	}
+
+	// Disable first so that systemd doesn't start the vm again whilst its removed
+	if vm.Spec.Autostart {
+		if err := a.autostartService.Disable(ctx, name); err != nil {
+			return fmt.Errorf("disabling vm: %w", err)
+		}
+	}
+
 	if err := a.vmService.Stop(ctx, name); err != nil {
 		return fmt.Errorf("stopping vm: %w", err)
 	}
@@ -22,10 +37,13 @@ func (a *app) RemoveVM(ctx context.Context, name string, owner string) error {
 		return fmt.Errorf("cleaning up vm images: %w", err)
 	}
 
-	vm, err := a.stateService.GetVM()
+	vm, err = a.stateService.GetVM()
 	if err != nil {
 		return fmt.Errorf("getting vm config: %w", err)
 	}
+	if vm == nil {
+		return ErrVMNotFound
+	}
 
 	for _, netStatus := range vm.Status.NetworkStatus {
 		if err := a.networkService.InterfaceDelete(netStatus.HostDeviveName); err != nil {
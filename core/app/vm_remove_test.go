@@ -0,0 +1,159 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/testing/fakes"
+)
+
+func TestRemoveVM(t *testing.T) {
+	errInjected := errors.New("injected failure")
+
+	testCases := []struct {
+		name          string
+		setup         func(env *testEnv)
+		expectErr     error
+		expectMethods []string
+		check         func(t *testing.T, env *testEnv)
+	}{
+		{
+			name: "calls ports in order",
+			expectMethods: []string{
+				fakes.StateServiceGetVM,
+				fakes.VMProviderStop,
+				fakes.VMProviderDelete,
+				fakes.ImageServiceCleanup,
+				fakes.StateServiceGetVM,
+				fakes.NetworkServiceInterfaceDelete,
+			},
+			check: func(t *testing.T, env *testEnv) {
+				if _, exists := env.network.Interfaces["mlt0"]; exists {
+					t.Errorf("expected interface mlt0 to be deleted")
+				}
+				cleanup := env.rec.CallsTo(fakes.ImageServiceCleanup)
+				if cleanup[0].Args[0] != testOwner {
+					t.Errorf("expected cleanup for owner %s, got %v", testOwner, cleanup[0].Args[0])
+				}
+				exists, _ := afero.DirExists(env.fs, env.state.Root())
+				if exists {
+					t.Errorf("expected state directory to be removed")
+				}
+			},
+		},
+		{
+			name:          "stop error is returned",
+			setup:         func(env *testEnv) { env.rec.FailOn(fakes.VMProviderStop, errInjected) },
+			expectErr:     errInjected,
+			expectMethods: []string{fakes.StateServiceGetVM, fakes.VMProviderStop},
+		},
+		{
+			name:      "delete error is returned",
+			setup:     func(env *testEnv) { env.rec.FailOn(fakes.VMProviderDelete, errInjected) },
+			expectErr: errInjected,
+			expectMethods: []string{
+				fakes.StateServiceGetVM,
+				fakes.VMProviderStop,
+				fakes.VMProviderDelete,
+			},
+		},
+		{
+			name:      "cleanup error is returned",
+			setup:     func(env *testEnv) { env.rec.FailOn(fakes.ImageServiceCleanup, errInjected) },
+			expectErr: errInjected,
+			expectMethods: []string{
+				fakes.StateServiceGetVM,
+				fakes.VMProviderStop,
+				fakes.VMProviderDelete,
+				fakes.ImageServiceCleanup,
+			},
+		},
+		{
+			name:      "interface error is returned",
+			setup:     func(env *testEnv) { env.rec.FailOn(fakes.NetworkServiceInterfaceDelete, errInjected) },
+			expectErr: errInjected,
+			check: func(t *testing.T, env *testEnv) {
+				exists, _ := afero.DirExists(env.fs, env.state.Root())
+				if !exists {
+					t.Errorf("expected state directory not to be removed")
+				}
+			},
+		},
+		{
+			name: "vm that starts on boot is disabled first",
+			setup: func(env *testEnv) {
+				env.state.VMs[testVMName].Spec.Autostart = true
+				env.autostart.Enabled[testVMName] = true
+			},
+			check: func(t *testing.T, env *testEnv) {
+				methods := env.rec.Methods()
+				if len(methods) < 2 || methods[1] != fakes.AutostartServiceDisable {
+					t.Errorf("expected vm to be disabled before its stopped, got %v", methods)
+				}
+				if env.autostart.Enabled[testVMName] {
+					t.Errorf("expected vm to be disabled")
+				}
+			},
+		},
+		{
+			name: "disable error is returned",
+			setup: func(env *testEnv) {
+				env.state.VMs[testVMName].Spec.Autostart = true
+				env.rec.FailOn(fakes.AutostartServiceDisable, errInjected)
+			},
+			expectErr:     errInjected,
+			expectMethods: []string{fakes.StateServiceGetVM, fakes.AutostartServiceDisable},
+		},
+		{
+			name:          "unknown vm isn't found",
+			setup:         func(env *testEnv) { delete(env.state.VMs, testVMName) },
+			expectErr:     ErrVMNotFound,
+			expectMethods: []string{fakes.StateServiceGetVM},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTestEnv(t, basicCaps())
+			env.state.VMs[testVMName] = &domain.VM{
+				Name: testVMName,
+				Spec: *testSpec(),
+				Status: &domain.VMStatus{
+					NetworkStatus: map[string]domain.NetworkStatus{
+						"eth0": {HostDeviveName: "mlt0", GuestMAC: "02:00:00:00:00:01"},
+					},
+				},
+			}
+			env.network.Interfaces["mlt0"] = "02:00:00:00:00:01"
+			if err := env.fs.MkdirAll(env.state.Root(), 0o755); err != nil {
+				t.Fatalf("creating state dir: %s", err)
+			}
+
+			if tc.setup != nil {
+				tc.setup(env)
+			}
+
+			err := env.app.RemoveVM(context.Background(), testVMName, testOwner)
+
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+
+			if tc.expectMethods != nil {
+				assertMethods(t, tc.expectMethods, env.rec.Methods())
+			}
+
+			if tc.check != nil {
+				tc.check(t, env)
+			}
+		})
+	}
+}
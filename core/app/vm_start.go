@@ -0,0 +1,114 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/pterm/pterm"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+func (a *app) StartVM(ctx context.Context, name string, owner string) (*domain.VM, error) {
+	slog.Debug("Starting vm", "vm", name)
+
+	if name == "" {
+		return nil, ErrNameRequired
+	}
+
+	vm, err := a.getCreatedVM()
+	if err != nil {
+		return nil, err
+	}
+
+	running, known, err := a.processRunning(vm)
+	if err != nil {
+		return nil, err
+	}
+	if running {
+		return nil, ErrVMRunning
+	}
+	if known && vm.Status.Process != nil && vm.Status.Process.State == domain.ProcessStateRunning {
+		// The exit hasn't been recorded yet, e.g. the host was rebooted
+		if _, err := a.recordExit(vm, a.now().UTC()); err != nil {
+			return nil, err
+		}
+	}
+	if err := a.stateService.DeleteExit(); err != nil {
+		return nil, fmt.Errorf("deleting vm process exit: %w", err)
+	}
+
+	// The mounts and taps don't survive a reboot so they are set up again
+	vm.Status.VolumeMounts = map[string]domain.Mount{}
+	vm.Status.Owner = owner
+	// Hot-attached volumes are in the spec, so they're added as drives like the
+	// other volumes and their slots are free again
+	vm.Status.VolumeSlots = nil
+
+	handlers := []handler{
+		a.handleKernel,
+		a.handleVolumes,
+		a.handleNetworkRestore,
+		a.handleVMCreateAndStart,
+		a.handleFindIP,
+		a.handleSaveVM,
+	}
+
+	for _, h := range handlers {
+		if err := h(ctx, owner, vm); err != nil {
+			return nil, err
+		}
+	}
+	slog.Info("vm started", "vm", vm.Name, "ip", vm.Status.IP)
+
+	return vm, nil
+}
+
+// handleNetworkRestore creates the network interfaces recorded in the vm status
+// that no longer exist. The same names and mac addresses are used so that the
+// vm gets the same ip address.
+func (a *app) handleNetworkRestore(ctx context.Context, owner string, vm *domain.VM) error {
+	pterm.DefaultSpinner.Info("ℹ️  Restoring network")
+
+	bridgeExists, err := a.networkService.BridgeExists(vm.Spec.NetworkConfiguration.BridgeName)
+	if err != nil {
+		return fmt.Errorf("checking if bridge exists")
+	}
+	if !bridgeExists {
+		return errors.New("currently the network bridge must exist already. Create it using virt-manager/virsh")
+	}
+
+	for name, intCfg := range vm.Spec.NetworkConfiguration.Interfaces {
+		netStatus, ok := vm.Status.NetworkStatus[name]
+		if !ok {
+			return fmt.Errorf("failed to get network status for %s", name)
+		}
+
+		exists, err := a.networkService.InterfaceExists(netStatus.HostDeviveName)
+		if err != nil {
+			return fmt.Errorf("checking if vm network interface %s exists: %w", netStatus.HostDeviveName, err)
+		}
+		if exists {
+			continue
+		}
+
+		slog.Debug("restoring network interface", "name", name)
+
+		if createErr := a.networkService.InterfaceCreate(netStatus.HostDeviveName, netStatus.GuestMAC); createErr != nil {
+			return fmt.Errorf("creating vm network interface %s: %w", netStatus.HostDeviveName, createErr)
+		}
+
+		if intCfg.AttachToBridge {
+			if attachErr := a.networkService.AttachToBridge(netStatus.HostDeviveName, vm.Spec.NetworkConfiguration.BridgeName); attachErr != nil {
+				return fmt.Errorf("attching vm interface to bridge: %w", attachErr)
+			}
+		}
+		if err := a.routeToHostMetadata(vm, netStatus.HostDeviveName, intCfg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
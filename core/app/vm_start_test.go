@@ -0,0 +1,205 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/defaults"
+	"github.com/mikrolite/mikrolite/testing/fakes"
+)
+
+func TestStartVM(t *testing.T) {
+	errInjected := errors.New("injected failure")
+
+	testCases := []struct {
+		name      string
+		vmName    string
+		exists    bool
+		process   *domain.ProcessStatus
+		running   bool
+		setup     func(env *testEnv)
+		expectErr error
+		check     func(t *testing.T, env *testEnv, vm *domain.VM)
+	}{
+		{
+			name:    "starts a stopped vm",
+			vmName:  testVMName,
+			exists:  true,
+			process: &domain.ProcessStatus{State: domain.ProcessStateStopped, StartedAt: testNow.Add(-time.Hour), LastExit: &domain.ProcessExit{Time: testNow, ExitCode: exitCode(0)}},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if len(env.rec.CallsTo(fakes.VMProviderCreate)) != 1 {
+					t.Errorf("expected vm to be created, got %v", env.rec.Methods())
+				}
+				if len(env.rec.CallsTo(fakes.NetworkServiceInterfaceCreate)) != 0 {
+					t.Errorf("expected existing interface not to be created")
+				}
+				procStatus := vm.Status.Process
+				if procStatus.State != domain.ProcessStateRunning || !procStatus.StartedAt.Equal(testNow) {
+					t.Errorf("expected process to be running, got %+v", procStatus)
+				}
+				if procStatus.LastExit == nil {
+					t.Errorf("expected last exit to be kept")
+				}
+				if _, ok := vm.Status.VolumeMounts["root"]; !ok {
+					t.Errorf("expected root volume to be mounted, got %v", vm.Status.VolumeMounts)
+				}
+				if vm.Status.IP != testIP {
+					t.Errorf("expected ip %s, got %s", testIP, vm.Status.IP)
+				}
+			},
+		},
+		{
+			name:    "ready is kept from the first boot",
+			vmName:  testVMName,
+			exists:  true,
+			process: &domain.ProcessStatus{State: domain.ProcessStateStopped, StartedAt: testNow.Add(-time.Hour)},
+			setup: func(env *testEnv) {
+				readyAt := testNow.Add(-time.Hour + time.Minute)
+				env.state.VMs[testVMName].Status.Ready = true
+				env.state.VMs[testVMName].Status.Boot = &domain.BootStatus{StartedAt: testNow.Add(-time.Hour), ReadyAt: &readyAt}
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if !vm.Status.Ready {
+					t.Errorf("expected ready to be kept")
+				}
+				if boot := vm.Status.Boot; !boot.StartedAt.Equal(testNow) || boot.ReadyAt != nil {
+					t.Errorf("expected the boot timing to be reset, got %+v", boot)
+				}
+			},
+		},
+		{
+			name:    "restores missing interfaces after a reboot",
+			vmName:  testVMName,
+			exists:  true,
+			process: &domain.ProcessStatus{State: domain.ProcessStateRunning, StartedAt: testNow.Add(-time.Hour)},
+			running: true,
+			setup: func(env *testEnv) {
+				// The pid has been reused since the host booted
+				env.process.Booted = testNow.Add(-time.Minute)
+				delete(env.network.Interfaces, "mlt0")
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if env.network.Interfaces["mlt0"] != "02:00:00:00:00:01" {
+					t.Errorf("expected interface mlt0 to be created with the guest mac, got %v", env.network.Interfaces)
+				}
+				if env.network.Attached["mlt0"] != testBridge {
+					t.Errorf("expected interface mlt0 to be attached to %s", testBridge)
+				}
+				if vm.Status.Process.LastExit == nil || vm.Status.Process.LastExit.Reason != unknownExitReason {
+					t.Errorf("expected the exit to be recorded, got %+v", vm.Status.Process.LastExit)
+				}
+			},
+		},
+		{
+			name:    "restores the route to the host metadata server",
+			vmName:  testVMName,
+			exists:  true,
+			process: &domain.ProcessStatus{State: domain.ProcessStateStopped},
+			setup: func(env *testEnv) {
+				vm := env.state.VMs[testVMName]
+				vm.Spec.NetworkConfiguration.Interfaces["eth1"] = domain.NetwortInterface{
+					GuestDeviceName:       "eth1",
+					AllowMetadataRequests: true,
+					StaticIPv4Address:     &domain.StaticIPv4Address{Address: "169.254.10.20/16"},
+				}
+				vm.Status.NetworkStatus["eth1"] = domain.NetworkStatus{HostDeviveName: "mltm0", GuestMAC: "02:00:00:00:00:02"}
+				vm.Status.MetadataURL = defaults.MetadataURL
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if env.network.Routes["169.254.10.20"] != "mltm0" {
+					t.Errorf("expected the route to be restored over mltm0, got %v", env.network.Routes)
+				}
+			},
+		},
+		{
+			name:    "hot-attached volumes are added as drives",
+			vmName:  testVMName,
+			exists:  true,
+			process: &domain.ProcessStatus{State: domain.ProcessStateStopped},
+			setup: func(env *testEnv) {
+				vm := env.state.VMs[testVMName]
+				vm.Spec.AdditionalVolumes = []domain.Volume{
+					{Name: "data", Source: domain.VolumeSource{Container: &domain.ContainerVolumeSource{Image: "ghcr.io/mikrolite/data:dev"}}},
+				}
+				vm.Status.VolumeSlots = map[string]string{"data": "slot0"}
+			},
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if _, ok := vm.Status.VolumeMounts["data"]; !ok {
+					t.Errorf("expected the data volume to be mounted, got %v", vm.Status.VolumeMounts)
+				}
+				if len(vm.Status.VolumeSlots) != 0 {
+					t.Errorf("expected the volume slots to be free, got %v", vm.Status.VolumeSlots)
+				}
+			},
+		},
+		{
+			name:      "running vm",
+			vmName:    testVMName,
+			exists:    true,
+			process:   &domain.ProcessStatus{State: domain.ProcessStateRunning, StartedAt: testNow.Add(-time.Hour)},
+			running:   true,
+			expectErr: ErrVMRunning,
+		},
+		{
+			name:      "create error is returned",
+			vmName:    testVMName,
+			exists:    true,
+			process:   &domain.ProcessStatus{State: domain.ProcessStateCrashed},
+			setup:     func(env *testEnv) { env.rec.FailOn(fakes.VMProviderCreate, errInjected) },
+			expectErr: errInjected,
+		},
+		{
+			name:      "missing vm",
+			vmName:    testVMName,
+			expectErr: ErrVMNotFound,
+		},
+		{
+			name:      "name required",
+			expectErr: ErrNameRequired,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTestEnv(t, basicCaps())
+			env.app.(*app).now = func() time.Time { return testNow }
+			if tc.exists {
+				env.state.VMs[testVMName] = &domain.VM{
+					Name: testVMName,
+					Spec: *testSpec(),
+					Status: &domain.VMStatus{
+						VolumeMounts: map[string]domain.Mount{},
+						NetworkStatus: map[string]domain.NetworkStatus{
+							"eth0": {HostDeviveName: "mlt0", GuestMAC: "02:00:00:00:00:01"},
+						},
+						Process: tc.process,
+					},
+				}
+			}
+			env.network.Interfaces["mlt0"] = "02:00:00:00:00:01"
+			env.state.PID = testPID
+			env.process.RunningPIDs[testPID] = tc.running
+			if tc.setup != nil {
+				tc.setup(env)
+			}
+
+			vm, err := env.app.StartVM(context.Background(), tc.vmName, testOwner)
+
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			if tc.check != nil {
+				tc.check(t, env, vm)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+func (a *app) StatsVM(ctx context.Context, name string) (*domain.VMStats, error) {
+	slog.Debug("Getting vm stats", "vm", name)
+
+	if name == "" {
+		return nil, ErrNameRequired
+	}
+
+	vm, err := a.getCreatedVM()
+	if err != nil {
+		return nil, err
+	}
+
+	// Providers that don't record the pid are asked for the metrics anyway
+	running, known, err := a.processRunning(vm)
+	if err != nil {
+		return nil, err
+	}
+	if known && !running {
+		return nil, ErrVMNotRunning
+	}
+
+	stats := &domain.VMStats{
+		Name:    vm.Name,
+		Time:    a.now().UTC(),
+		Metrics: []domain.Metric{},
+	}
+
+	if a.vmService.Capabilities().Metrics {
+		metrics, err := a.vmService.Metrics(ctx, vm)
+		if err != nil {
+			return nil, fmt.Errorf("getting vm metrics: %w", err)
+		}
+		stats.Metrics = append(stats.Metrics, metrics...)
+	}
+
+	tapMetrics, err := a.tapMetrics(vm)
+	if err != nil {
+		return nil, err
+	}
+	stats.Metrics = append(stats.Metrics, tapMetrics...)
+
+	return stats, nil
+}
+
+// tapMetrics returns the counters of the host side of the vm network interfaces.
+// Received on the host is sent by the vm and the other way around.
+func (a *app) tapMetrics(vm *domain.VM) ([]domain.Metric, error) {
+	names := make([]string, 0, len(vm.Status.NetworkStatus))
+	for name := range vm.Status.NetworkStatus {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	metrics := []domain.Metric{}
+	for _, name := range names {
+		device := vm.Status.NetworkStatus[name].HostDeviveName
+
+		stats, err := a.networkService.InterfaceStats(device)
+		if err != nil {
+			return nil, fmt.Errorf("getting stats for interface %s: %w", device, err)
+		}
+
+		labels := map[string]string{"interface": name, "device": device}
+		counters := []struct {
+			name  string
+			value uint64
+		}{
+			{"tap_rx_bytes", stats.RxBytes},
+			{"tap_rx_packets", stats.RxPackets},
+			{"tap_rx_errors", stats.RxErrors},
+			{"tap_rx_dropped", stats.RxDropped},
+			{"tap_tx_bytes", stats.TxBytes},
+			{"tap_tx_packets", stats.TxPackets},
+			{"tap_tx_errors", stats.TxErrors},
+			{"tap_tx_dropped", stats.TxDropped},
+		}
+		for _, counter := range counters {
+			metrics = append(metrics, domain.Metric{
+				Name:   counter.name,
+				Type:   domain.MetricTypeCounter,
+				Labels: labels,
+				Value:  float64(counter.value),
+			})
+		}
+	}
+
+	return metrics, nil
+}
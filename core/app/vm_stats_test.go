@@ -0,0 +1,150 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+	"github.com/mikrolite/mikrolite/testing/fakes"
+)
+
+func TestStatsVM(t *testing.T) {
+	errInjected := errors.New("injected failure")
+	providerMetric := domain.Metric{Name: "firecracker_vcpu_exit_io_in", Type: domain.MetricTypeCounter, Value: 3}
+
+	testCases := []struct {
+		name      string
+		vmName    string
+		exists    bool
+		metrics   bool
+		running   bool
+		pid       int
+		setup     func(env *testEnv)
+		expectErr error
+		check     func(t *testing.T, env *testEnv, stats *domain.VMStats)
+	}{
+		{
+			name:    "provider and tap metrics",
+			vmName:  testVMName,
+			exists:  true,
+			metrics: true,
+			running: true,
+			pid:     testPID,
+			check: func(t *testing.T, env *testEnv, stats *domain.VMStats) {
+				if stats.Name != testVMName || !stats.Time.Equal(testNow) {
+					t.Errorf("expected stats for %s at %s, got %s at %s", testVMName, testNow, stats.Name, stats.Time)
+				}
+				if len(stats.Metrics) != 9 || stats.Metrics[0].Name != providerMetric.Name {
+					t.Fatalf("expected the provider metric and 8 tap metrics, got %+v", stats.Metrics)
+				}
+				rx := stats.Metrics[1]
+				if rx.Name != "tap_rx_bytes" || rx.Value != 100 || rx.Type != domain.MetricTypeCounter {
+					t.Errorf("expected tap_rx_bytes counter of 100, got %+v", rx)
+				}
+				if rx.Labels["interface"] != "eth0" || rx.Labels["device"] != "mlt0" {
+					t.Errorf("expected interface and device labels, got %v", rx.Labels)
+				}
+			},
+		},
+		{
+			name:    "provider without metrics",
+			vmName:  testVMName,
+			exists:  true,
+			running: true,
+			pid:     testPID,
+			check: func(t *testing.T, env *testEnv, stats *domain.VMStats) {
+				if len(env.rec.CallsTo(fakes.VMProviderMetrics)) != 0 {
+					t.Errorf("expected the provider not to be asked for metrics")
+				}
+				if len(stats.Metrics) != 8 {
+					t.Errorf("expected only the tap metrics, got %+v", stats.Metrics)
+				}
+			},
+		},
+		{
+			name:    "pid not recorded by the provider",
+			vmName:  testVMName,
+			exists:  true,
+			metrics: true,
+			check: func(t *testing.T, env *testEnv, stats *domain.VMStats) {
+				if len(env.rec.CallsTo(fakes.VMProviderMetrics)) != 1 {
+					t.Errorf("expected the provider to be asked for metrics")
+				}
+			},
+		},
+		{
+			name:      "stopped vm",
+			vmName:    testVMName,
+			exists:    true,
+			metrics:   true,
+			pid:       testPID,
+			expectErr: ErrVMNotRunning,
+		},
+		{
+			name:      "provider error is returned",
+			vmName:    testVMName,
+			exists:    true,
+			metrics:   true,
+			running:   true,
+			pid:       testPID,
+			setup:     func(env *testEnv) { env.rec.FailOn(fakes.VMProviderMetrics, errInjected) },
+			expectErr: errInjected,
+		},
+		{
+			name:      "missing vm",
+			vmName:    testVMName,
+			expectErr: ErrVMNotFound,
+		},
+		{
+			name:      "name required",
+			expectErr: ErrNameRequired,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			caps := basicCaps()
+			caps.Metrics = tc.metrics
+			env := newTestEnv(t, caps)
+			env.app.(*app).now = func() time.Time { return testNow }
+			env.vm.VMMetrics = []domain.Metric{providerMetric}
+			if tc.exists {
+				env.state.VMs[testVMName] = &domain.VM{
+					Name: testVMName,
+					Spec: *testSpec(),
+					Status: &domain.VMStatus{
+						NetworkStatus: map[string]domain.NetworkStatus{
+							"eth0": {HostDeviveName: "mlt0", GuestMAC: "02:00:00:00:00:01"},
+						},
+						Process: &domain.ProcessStatus{State: domain.ProcessStateRunning},
+					},
+				}
+			}
+			env.network.Interfaces["mlt0"] = "02:00:00:00:00:01"
+			env.network.Stats["mlt0"] = &ports.InterfaceStats{RxBytes: 100, TxBytes: 200}
+			env.state.PID = tc.pid
+			env.process.RunningPIDs[testPID] = tc.running
+			if tc.setup != nil {
+				tc.setup(env)
+			}
+
+			stats, err := env.app.StatsVM(context.Background(), tc.vmName)
+
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			if tc.check != nil {
+				tc.check(t, env, stats)
+			}
+		})
+	}
+}
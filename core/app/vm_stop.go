@@ -0,0 +1,151 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+const (
+	// stopTimeout is how long the vm process is given to stop before its killed.
+	stopTimeout = 30 * time.Second
+)
+
+func (a *app) WaitVM(ctx context.Context, name string) (*domain.ProcessExit, error) {
+	if name == "" {
+		return nil, ErrNameRequired
+	}
+
+	vm, err := a.getCreatedVM()
+	if err != nil {
+		return nil, err
+	}
+
+	known, err := a.waitForExit(ctx, vm)
+	if err != nil {
+		return nil, err
+	}
+	if !known {
+		return nil, fmt.Errorf("waiting for vm: %w", ErrUnsupportedByProvider)
+	}
+
+	return a.exited()
+}
+
+func (a *app) StopVM(ctx context.Context, name string) error {
+	if name == "" {
+		return ErrNameRequired
+	}
+
+	vm, err := a.getCreatedVM()
+	if err != nil {
+		return err
+	}
+
+	running, known, err := a.processRunning(vm)
+	if err != nil {
+		return err
+	}
+	if running || !known {
+		if err := a.stopProcess(ctx, vm); err != nil {
+			return err
+		}
+	}
+
+	if _, err := a.exited(); err != nil {
+		return err
+	}
+
+	vm, err = a.getCreatedVM()
+	if err != nil {
+		return err
+	}
+	vm.Status.Process.State = domain.ProcessStateStopped
+	if err := a.stateService.SaveVM(vm); err != nil {
+		return fmt.Errorf("saving vm state: %w", err)
+	}
+
+	return nil
+}
+
+// stopProcess stops the vm process, its killed if it doesn't stop in time.
+func (a *app) stopProcess(ctx context.Context, vm *domain.VM) error {
+	slog.Info("stopping vm", "vm", vm.Name)
+
+	if err := a.vmService.Stop(ctx, vm.Name); err != nil {
+		return fmt.Errorf("stopping vm: %w", err)
+	}
+
+	stopCtx, cancel := context.WithTimeout(ctx, stopTimeout)
+	defer cancel()
+
+	known, err := a.waitForExit(stopCtx, vm)
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if err == nil && known {
+		return nil
+	}
+
+	slog.Info("killing vm", "vm", vm.Name)
+
+	if err := a.vmService.Delete(ctx, vm.Name); err != nil {
+		return fmt.Errorf("killing vm: %w", err)
+	}
+	_, err = a.waitForExit(ctx, vm)
+
+	return err
+}
+
+// getCreatedVM returns the vm from the state. Its an error if the vm doesn't
+// exist or hasn't been created yet.
+func (a *app) getCreatedVM() (*domain.VM, error) {
+	vm, err := a.stateService.GetVM()
+	if err != nil {
+		return nil, fmt.Errorf("getting vm state: %w", err)
+	}
+	if vm == nil || vm.Status == nil {
+		return nil, ErrVMNotFound
+	}
+
+	return vm, nil
+}
+
+// waitForExit waits until the vm process isn't running. If the provider doesn't
+// record the pid then known is false and it returns straight away.
+func (a *app) waitForExit(ctx context.Context, vm *domain.VM) (known bool, err error) {
+	for {
+		running, known, err := a.processRunning(vm)
+		if err != nil || !known || !running {
+			return known, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return true, ctx.Err()
+		case <-time.After(processPollInterval):
+		}
+	}
+}
+
+// exited records the exit of the vm process once its stopped running. The vm
+// is read again as the exit may have been recorded by the daemon whilst waiting.
+func (a *app) exited() (*domain.ProcessExit, error) {
+	vm, err := a.getCreatedVM()
+	if err != nil {
+		return nil, err
+	}
+
+	if vm.Status.Process == nil {
+		vm.Status.Process = &domain.ProcessStatus{State: domain.ProcessStateRunning}
+	}
+	if vm.Status.Process.State != domain.ProcessStateRunning {
+		return vm.Status.Process.LastExit, nil
+	}
+
+	return a.recordExit(vm, a.now().UTC())
+}
@@ -0,0 +1,135 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/testing/fakes"
+)
+
+// newRunningVMEnv creates a test env with a running vm.
+func newRunningVMEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	env := newTestEnv(t, basicCaps())
+	env.app.(*app).now = func() time.Time { return testNow }
+	env.state.VMs[testVMName] = &domain.VM{
+		Name: testVMName,
+		Spec: *testSpec(),
+		Status: &domain.VMStatus{
+			Process: &domain.ProcessStatus{State: domain.ProcessStateRunning, StartedAt: testNow.Add(-time.Hour)},
+		},
+	}
+	env.state.PID = testPID
+	env.process.RunningPIDs[testPID] = true
+
+	return env
+}
+
+func TestStopVM(t *testing.T) {
+	env := newRunningVMEnv(t)
+	env.vm.OnStop = func(id string) {
+		env.process.RunningPIDs[testPID] = false
+		env.state.Exit = &domain.ProcessExit{Time: testNow, Signal: "hangup"}
+	}
+
+	if err := env.app.StopVM(context.Background(), testVMName); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(env.rec.CallsTo(fakes.VMProviderDelete)) != 0 {
+		t.Errorf("expected vm not to be killed")
+	}
+	procStatus := env.state.VMs[testVMName].Status.Process
+	if procStatus.State != domain.ProcessStateStopped {
+		t.Errorf("expected vm to be stopped, got %s", procStatus.State)
+	}
+	if procStatus.LastExit == nil || procStatus.LastExit.Signal != "hangup" {
+		t.Errorf("expected the exit to be recorded, got %+v", procStatus.LastExit)
+	}
+}
+
+func TestStopVMNotRunning(t *testing.T) {
+	env := newRunningVMEnv(t)
+	env.process.RunningPIDs[testPID] = false
+
+	if err := env.app.StopVM(context.Background(), testVMName); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(env.rec.CallsTo(fakes.VMProviderStop)) != 0 {
+		t.Errorf("expected an exited process not to be stopped")
+	}
+	if state := env.state.VMs[testVMName].Status.Process.State; state != domain.ProcessStateStopped {
+		t.Errorf("expected vm to be stopped, got %s", state)
+	}
+}
+
+func TestStopVMError(t *testing.T) {
+	errInjected := errors.New("injected failure")
+	env := newRunningVMEnv(t)
+	env.rec.FailOn(fakes.VMProviderStop, errInjected)
+
+	if err := env.app.StopVM(context.Background(), testVMName); !errors.Is(err, errInjected) {
+		t.Fatalf("expected error %v, got %v", errInjected, err)
+	}
+}
+
+func TestWaitVM(t *testing.T) {
+	env := newRunningVMEnv(t)
+	env.process.RunningPIDs[testPID] = false
+	env.state.Exit = &domain.ProcessExit{Time: testNow, ExitCode: exitCode(3)}
+
+	exit, err := env.app.WaitVM(context.Background(), testVMName)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if exit == nil || exit.ExitCode == nil || *exit.ExitCode != 3 {
+		t.Errorf("expected exit code 3, got %+v", exit)
+	}
+	if state := env.state.VMs[testVMName].Status.Process.State; state != domain.ProcessStateCrashed {
+		t.Errorf("expected vm to have crashed, got %s", state)
+	}
+}
+
+func TestWaitVMExitAlreadyRecorded(t *testing.T) {
+	env := newRunningVMEnv(t)
+	env.process.RunningPIDs[testPID] = false
+	procStatus := env.state.VMs[testVMName].Status.Process
+	procStatus.State = domain.ProcessStateExited
+	procStatus.LastExit = &domain.ProcessExit{Time: testNow, ExitCode: exitCode(0)}
+
+	exit, err := env.app.WaitVM(context.Background(), testVMName)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if exit == nil || !exit.Succeeded() {
+		t.Errorf("expected the recorded exit, got %+v", exit)
+	}
+	if len(env.rec.CallsTo(fakes.StateServiceSaveVM)) != 0 {
+		t.Errorf("expected vm not to be saved")
+	}
+}
+
+func TestWaitVMCancelled(t *testing.T) {
+	env := newRunningVMEnv(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*processPollInterval)
+	defer cancel()
+
+	if _, err := env.app.WaitVM(ctx, testVMName); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestWaitVMWithoutPID(t *testing.T) {
+	env := newRunningVMEnv(t)
+	env.state.PID = 0
+
+	if _, err := env.app.WaitVM(context.Background(), testVMName); !errors.Is(err, ErrUnsupportedByProvider) {
+		t.Fatalf("expected error %v, got %v", ErrUnsupportedByProvider, err)
+	}
+}
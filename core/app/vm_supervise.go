@@ -0,0 +1,234 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+const (
+	defaultRestartBackoff = time.Second
+	maxRestartBackoff     = 5 * time.Minute
+	// restartResetAfter is how long a restarted vm has to run for before the
+	// restarts count (and so the backoff) is reset.
+	restartResetAfter = 10 * time.Minute
+
+	unknownExitReason = "process exited, the exit code isn't known"
+
+	// processPollInterval is how often a process is checked when waiting for it.
+	processPollInterval = 100 * time.Millisecond
+	// defaultExitWait is how long to wait for the provider to record the exit.
+	defaultExitWait = time.Second
+)
+
+func (a *app) SuperviseVM(ctx context.Context, name string) (*ports.SuperviseVMOutput, error) {
+	if name == "" {
+		return nil, ErrNameRequired
+	}
+
+	vm, err := a.stateService.GetVM()
+	if err != nil {
+		return nil, fmt.Errorf("getting vm state: %w", err)
+	}
+	if vm == nil {
+		return nil, ErrVMNotFound
+	}
+
+	output := &ports.SuperviseVMOutput{VM: vm}
+	if vm.Status == nil {
+		// The vm hasn't been created yet
+		return output, nil
+	}
+
+	// Vms created before the process status was recorded are assumed to be running
+	if vm.Status.Process == nil {
+		vm.Status.Process = &domain.ProcessStatus{State: domain.ProcessStateRunning}
+	}
+	procStatus := vm.Status.Process
+	now := a.now().UTC()
+
+	running, known, err := a.processRunning(vm)
+	if err != nil {
+		return nil, err
+	}
+	if !known {
+		// The provider doesn't record the pid (e.g. plugins) so the process can't be supervised
+		return output, nil
+	}
+
+	if running {
+		if procStatus.Restarts > 0 && now.Sub(procStatus.StartedAt) > restartResetAfter {
+			procStatus.Restarts = 0
+			if err := a.stateService.SaveVM(vm); err != nil {
+				return nil, fmt.Errorf("saving vm state: %w", err)
+			}
+		}
+
+		return output, nil
+	}
+
+	if procStatus.State == domain.ProcessStateRunning {
+		exit, err := a.recordExit(vm, now)
+		if err != nil {
+			return nil, err
+		}
+		output.Exited = exit
+	}
+
+	if vm.Spec.Autostart {
+		// Vms that start on boot are run by systemd, which restarts them
+		return output, nil
+	}
+	if !shouldRestart(vm.Spec.RestartPolicy, procStatus) {
+		return output, nil
+	}
+	if now.Before(procStatus.LastExit.Time.Add(restartBackoff(vm.Spec.RestartPolicy, procStatus.Restarts))) {
+		return output, nil
+	}
+
+	slog.Info("restarting vm", "vm", name, "restarts", procStatus.Restarts)
+
+	procStatus.Restarts++
+	// Hot-attached volumes are in the spec, so they're added as drives like the
+	// other volumes and their slots are free again
+	vm.Status.VolumeSlots = nil
+	if _, err := a.vmService.Create(ctx, vm); err != nil {
+		procStatus.LastExit = &domain.ProcessExit{
+			Time:   now,
+			Reason: fmt.Sprintf("restarting vm: %s", err),
+		}
+		if saveErr := a.stateService.SaveVM(vm); saveErr != nil {
+			return nil, fmt.Errorf("saving vm state: %w", saveErr)
+		}
+
+		return nil, fmt.Errorf("restarting vm: %w", err)
+	}
+
+	procStatus.State = domain.ProcessStateRunning
+	procStatus.StartedAt = now
+	if err := a.stateService.SaveVM(vm); err != nil {
+		return nil, fmt.Errorf("saving vm state: %w", err)
+	}
+	output.Restarted = true
+
+	return output, nil
+}
+
+// processRunning returns true if the vm process is running. If the provider
+// doesn't record the pid then known is false.
+func (a *app) processRunning(vm *domain.VM) (running bool, known bool, err error) {
+	pid, err := a.stateService.GetPID()
+	if err != nil {
+		return false, false, fmt.Errorf("getting vm pid: %w", err)
+	}
+
+	return a.pidRunning(vm, pid)
+}
+
+// pidRunning returns true if the process with the pid saved for the vm is
+// running. If the pid isn't known then known is false.
+func (a *app) pidRunning(vm *domain.VM, pid int) (running bool, known bool, err error) {
+	if pid <= 0 {
+		return false, false, nil
+	}
+
+	if vm.Status != nil && vm.Status.Process != nil && !vm.Status.Process.StartedAt.IsZero() {
+		booted, err := a.processService.BootTime()
+		if err != nil {
+			return false, false, fmt.Errorf("getting host boot time: %w", err)
+		}
+		if vm.Status.Process.StartedAt.Before(booted) {
+			// The process was started before the host rebooted, the pid may have been reused
+			return false, true, nil
+		}
+	}
+
+	running, err = a.processService.Running(pid)
+	if err != nil {
+		return false, false, fmt.Errorf("checking if process %d is running: %w", pid, err)
+	}
+
+	return running, true, nil
+}
+
+// recordExit records the exit of the vm process in the vm status. The exit is
+// only known if it was saved by the provider that started the process, which
+// happens just after the process stops running.
+func (a *app) recordExit(vm *domain.VM, now time.Time) (*domain.ProcessExit, error) {
+	exit, err := a.stateService.GetExit()
+	if err != nil {
+		return nil, fmt.Errorf("getting vm process exit: %w", err)
+	}
+	for deadline := time.Now().Add(a.exitWait); exit == nil && time.Now().Before(deadline); {
+		time.Sleep(processPollInterval)
+
+		exit, err = a.stateService.GetExit()
+		if err != nil {
+			return nil, fmt.Errorf("getting vm process exit: %w", err)
+		}
+	}
+	if exit == nil {
+		exit = &domain.ProcessExit{
+			Time:   now,
+			Reason: unknownExitReason,
+		}
+	}
+	if err := a.stateService.DeleteExit(); err != nil {
+		return nil, fmt.Errorf("deleting vm process exit: %w", err)
+	}
+
+	vm.Status.Process.LastExit = exit
+	vm.Status.Process.State = domain.ProcessStateCrashed
+	if exit.Succeeded() {
+		vm.Status.Process.State = domain.ProcessStateExited
+	}
+
+	if err := a.stateService.SaveVM(vm); err != nil {
+		return nil, fmt.Errorf("saving vm state: %w", err)
+	}
+
+	return exit, nil
+}
+
+// shouldRestart returns true if the restart policy requires the exited process
+// to be restarted.
+func shouldRestart(policy *domain.RestartPolicy, procStatus *domain.ProcessStatus) bool {
+	if policy == nil || procStatus.LastExit == nil || procStatus.State == domain.ProcessStateStopped {
+		return false
+	}
+
+	switch policy.Policy {
+	case domain.RestartPolicyAlways:
+		return true
+	case domain.RestartPolicyOnFailure:
+		if procStatus.LastExit.Succeeded() {
+			return false
+		}
+
+		return policy.MaxRetries == 0 || procStatus.Restarts < policy.MaxRetries
+	default:
+		return false
+	}
+}
+
+// restartBackoff returns how long to wait after the process exits before it's
+// restarted. The delay doubles with each restart.
+func restartBackoff(policy *domain.RestartPolicy, restarts int) time.Duration {
+	backoff := defaultRestartBackoff
+	if policy.BackoffSeconds > 0 {
+		backoff = time.Duration(policy.BackoffSeconds) * time.Second
+	}
+
+	for i := 0; i < restarts; i++ {
+		backoff *= 2
+		if backoff >= maxRestartBackoff {
+			return maxRestartBackoff
+		}
+	}
+
+	return backoff
+}
@@ -0,0 +1,272 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/testing/fakes"
+)
+
+const testPID = 1234
+
+var testNow = time.Date(2023, 11, 1, 12, 0, 0, 0, time.UTC)
+
+func exitCode(code int) *int {
+	return &code
+}
+
+func TestSuperviseVM(t *testing.T) {
+	errInjected := errors.New("injected failure")
+
+	testCases := []struct {
+		name          string
+		policy        *domain.RestartPolicy
+		process       *domain.ProcessStatus
+		running       bool
+		exit          *domain.ProcessExit
+		setup         func(env *testEnv)
+		expectErr     error
+		expectExited  bool
+		expectRestart bool
+		expectState   domain.ProcessState
+		check         func(t *testing.T, env *testEnv, vm *domain.VM)
+	}{
+		{
+			name:        "running process isn't changed",
+			policy:      &domain.RestartPolicy{Policy: domain.RestartPolicyAlways},
+			process:     &domain.ProcessStatus{State: domain.ProcessStateRunning, StartedAt: testNow.Add(-time.Minute)},
+			running:     true,
+			expectState: domain.ProcessStateRunning,
+		},
+		{
+			name:        "restarts are reset once the process has been running for a while",
+			process:     &domain.ProcessStatus{State: domain.ProcessStateRunning, StartedAt: testNow.Add(-time.Hour), Restarts: 3},
+			running:     true,
+			expectState: domain.ProcessStateRunning,
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if vm.Status.Process.Restarts != 0 {
+					t.Errorf("expected restarts to be reset, got %d", vm.Status.Process.Restarts)
+				}
+			},
+		},
+		{
+			name:         "recorded exit is saved in the status",
+			process:      &domain.ProcessStatus{State: domain.ProcessStateRunning},
+			exit:         &domain.ProcessExit{Time: testNow, ExitCode: exitCode(3), Reason: "exited with code 3"},
+			expectExited: true,
+			expectState:  domain.ProcessStateCrashed,
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				lastExit := vm.Status.Process.LastExit
+				if lastExit == nil || lastExit.ExitCode == nil || *lastExit.ExitCode != 3 {
+					t.Errorf("expected exit code 3 to be recorded, got %+v", lastExit)
+				}
+				if env.state.Exit != nil {
+					t.Errorf("expected recorded exit to be deleted")
+				}
+			},
+		},
+		{
+			name:         "unknown exit is a crash",
+			expectExited: true,
+			expectState:  domain.ProcessStateCrashed,
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if vm.Status.Process.LastExit.Reason != unknownExitReason {
+					t.Errorf("expected unknown exit reason, got %s", vm.Status.Process.LastExit.Reason)
+				}
+			},
+		},
+		{
+			name:         "successful exit",
+			process:      &domain.ProcessStatus{State: domain.ProcessStateRunning},
+			exit:         &domain.ProcessExit{Time: testNow, ExitCode: exitCode(0)},
+			expectExited: true,
+			expectState:  domain.ProcessStateExited,
+		},
+		{
+			name:          "on-failure restarts a crashed process",
+			policy:        &domain.RestartPolicy{Policy: domain.RestartPolicyOnFailure},
+			process:       &domain.ProcessStatus{State: domain.ProcessStateRunning},
+			exit:          &domain.ProcessExit{Time: testNow.Add(-time.Minute), Signal: "killed"},
+			expectExited:  true,
+			expectRestart: true,
+			expectState:   domain.ProcessStateRunning,
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if vm.Status.Process.Restarts != 1 {
+					t.Errorf("expected 1 restart, got %d", vm.Status.Process.Restarts)
+				}
+				if !vm.Status.Process.StartedAt.Equal(testNow) {
+					t.Errorf("expected started at to be updated, got %s", vm.Status.Process.StartedAt)
+				}
+			},
+		},
+		{
+			name:         "on-failure doesn't restart a successful exit",
+			policy:       &domain.RestartPolicy{Policy: domain.RestartPolicyOnFailure},
+			process:      &domain.ProcessStatus{State: domain.ProcessStateRunning},
+			exit:         &domain.ProcessExit{Time: testNow.Add(-time.Minute), ExitCode: exitCode(0)},
+			expectExited: true,
+			expectState:  domain.ProcessStateExited,
+		},
+		{
+			name:        "on-failure gives up after the max retries",
+			policy:      &domain.RestartPolicy{Policy: domain.RestartPolicyOnFailure, MaxRetries: 2},
+			process:     &domain.ProcessStatus{State: domain.ProcessStateCrashed, Restarts: 2, LastExit: &domain.ProcessExit{Time: testNow.Add(-time.Hour), ExitCode: exitCode(1)}},
+			expectState: domain.ProcessStateCrashed,
+		},
+		{
+			name:          "always restarts a successful exit",
+			policy:        &domain.RestartPolicy{Policy: domain.RestartPolicyAlways},
+			process:       &domain.ProcessStatus{State: domain.ProcessStateRunning},
+			exit:          &domain.ProcessExit{Time: testNow.Add(-time.Minute), ExitCode: exitCode(0)},
+			expectExited:  true,
+			expectRestart: true,
+			expectState:   domain.ProcessStateRunning,
+		},
+		{
+			name:        "no policy doesn't restart",
+			policy:      &domain.RestartPolicy{Policy: domain.RestartPolicyNo},
+			process:     &domain.ProcessStatus{State: domain.ProcessStateCrashed, LastExit: &domain.ProcessExit{Time: testNow.Add(-time.Hour)}},
+			expectState: domain.ProcessStateCrashed,
+		},
+		{
+			name:         "vm that starts on boot is left to systemd",
+			policy:       &domain.RestartPolicy{Policy: domain.RestartPolicyAlways},
+			process:      &domain.ProcessStatus{State: domain.ProcessStateRunning},
+			exit:         &domain.ProcessExit{Time: testNow.Add(-time.Minute), Signal: "killed"},
+			setup:        func(env *testEnv) { env.state.VMs[testVMName].Spec.Autostart = true },
+			expectExited: true,
+			expectState:  domain.ProcessStateCrashed,
+		},
+		{
+			name:        "stopped vm isn't restarted",
+			policy:      &domain.RestartPolicy{Policy: domain.RestartPolicyAlways},
+			process:     &domain.ProcessStatus{State: domain.ProcessStateStopped, LastExit: &domain.ProcessExit{Time: testNow.Add(-time.Hour)}},
+			expectState: domain.ProcessStateStopped,
+		},
+		{
+			name:         "process started before the host booted has exited",
+			process:      &domain.ProcessStatus{State: domain.ProcessStateRunning, StartedAt: testNow.Add(-time.Hour)},
+			running:      true,
+			setup:        func(env *testEnv) { env.process.Booted = testNow.Add(-time.Minute) },
+			expectExited: true,
+			expectState:  domain.ProcessStateCrashed,
+		},
+		{
+			name:        "restart waits for the backoff",
+			policy:      &domain.RestartPolicy{Policy: domain.RestartPolicyAlways, BackoffSeconds: 10},
+			process:     &domain.ProcessStatus{State: domain.ProcessStateCrashed, Restarts: 2, LastExit: &domain.ProcessExit{Time: testNow.Add(-30 * time.Second)}},
+			expectState: domain.ProcessStateCrashed,
+		},
+		{
+			name:          "restart after the backoff",
+			policy:        &domain.RestartPolicy{Policy: domain.RestartPolicyAlways, BackoffSeconds: 10},
+			process:       &domain.ProcessStatus{State: domain.ProcessStateCrashed, Restarts: 2, LastExit: &domain.ProcessExit{Time: testNow.Add(-41 * time.Second)}},
+			expectRestart: true,
+			expectState:   domain.ProcessStateRunning,
+		},
+		{
+			name:        "restart error is recorded",
+			policy:      &domain.RestartPolicy{Policy: domain.RestartPolicyAlways},
+			process:     &domain.ProcessStatus{State: domain.ProcessStateCrashed, LastExit: &domain.ProcessExit{Time: testNow.Add(-time.Hour)}},
+			setup:       func(env *testEnv) { env.rec.FailOn(fakes.VMProviderCreate, errInjected) },
+			expectErr:   errInjected,
+			expectState: domain.ProcessStateCrashed,
+			check: func(t *testing.T, env *testEnv, vm *domain.VM) {
+				if vm.Status.Process.Restarts != 1 {
+					t.Errorf("expected failed restart to be counted, got %d", vm.Status.Process.Restarts)
+				}
+				if !vm.Status.Process.LastExit.Time.Equal(testNow) {
+					t.Errorf("expected last exit time to be updated, got %s", vm.Status.Process.LastExit.Time)
+				}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTestEnv(t, basicCaps())
+			env.app.(*app).now = func() time.Time { return testNow }
+
+			spec := testSpec()
+			spec.RestartPolicy = tc.policy
+			env.state.VMs[testVMName] = &domain.VM{
+				Name:   testVMName,
+				Spec:   *spec,
+				Status: &domain.VMStatus{Process: tc.process},
+			}
+			env.state.PID = testPID
+			env.state.Exit = tc.exit
+			env.process.RunningPIDs[testPID] = tc.running
+			if tc.setup != nil {
+				tc.setup(env)
+			}
+
+			output, err := env.app.SuperviseVM(context.Background(), testVMName)
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if (output.Exited != nil) != tc.expectExited {
+					t.Errorf("expected exited %t, got %+v", tc.expectExited, output.Exited)
+				}
+				if output.Restarted != tc.expectRestart {
+					t.Errorf("expected restarted %t, got %t", tc.expectRestart, output.Restarted)
+				}
+			}
+
+			created := len(env.rec.CallsTo(fakes.VMProviderCreate)) > 0
+			if created != (tc.expectRestart || tc.expectErr != nil) {
+				t.Errorf("unexpected vm provider create calls %v", env.rec.Methods())
+			}
+
+			saved := env.state.VMs[testVMName]
+			if saved.Status.Process == nil || saved.Status.Process.State != tc.expectState {
+				t.Errorf("expected state %s, got %+v", tc.expectState, saved.Status.Process)
+			}
+			if tc.check != nil {
+				tc.check(t, env, saved)
+			}
+		})
+	}
+}
+
+func TestSuperviseVMWithoutPID(t *testing.T) {
+	env := newTestEnv(t, basicCaps())
+	env.state.VMs[testVMName] = &domain.VM{
+		Name:   testVMName,
+		Spec:   *testSpec(),
+		Status: &domain.VMStatus{},
+	}
+
+	output, err := env.app.SuperviseVM(context.Background(), testVMName)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if output.Exited != nil || output.Restarted {
+		t.Errorf("expected vm without a pid not to be supervised, got %+v", output)
+	}
+	if len(env.rec.CallsTo(fakes.ProcessServiceRunning)) != 0 {
+		t.Errorf("expected process not to be checked")
+	}
+}
+
+func TestRestartBackoff(t *testing.T) {
+	policy := &domain.RestartPolicy{Policy: domain.RestartPolicyAlways, BackoffSeconds: 2}
+
+	expected := []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second}
+	for restarts, backoff := range expected {
+		if actual := restartBackoff(policy, restarts); actual != backoff {
+			t.Errorf("expected backoff %s after %d restarts, got %s", backoff, restarts, actual)
+		}
+	}
+	if actual := restartBackoff(policy, 100); actual != maxRestartBackoff {
+		t.Errorf("expected backoff to be capped at %s, got %s", maxRestartBackoff, actual)
+	}
+}
@@ -0,0 +1,152 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/pterm/pterm"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+func (a *app) AttachVolume(ctx context.Context, input ports.AttachVolumeInput) (*domain.VM, error) {
+	slog.Debug("Attaching volume to vm", "vm", input.Name)
+
+	if input.Name == "" {
+		return nil, ErrNameRequired
+	}
+	if input.Volume == nil || input.Volume.Name == "" {
+		return nil, ErrVolumeRequired
+	}
+
+	vm, err := a.stateService.GetVM()
+	if err != nil {
+		return nil, fmt.Errorf("getting vm state: %w", err)
+	}
+	if vm == nil {
+		return nil, ErrVMNotFound
+	}
+
+	caps := a.vmService.Capabilities()
+	if !caps.HotplugDisk && !caps.HasDiskFeature(ports.DiskFeatureBackingSwap) {
+		return nil, fmt.Errorf("hot-attaching volumes: %w", ErrUnsupportedByProvider)
+	}
+
+	if hasVolume(vm, input.Volume.Name) {
+		return nil, ErrVolumeAlreadyExists
+	}
+
+	pterm.DefaultSpinner.Info(fmt.Sprintf("ℹ️  Attaching volume %s to VM: %s\n", input.Volume.Name, input.Name))
+
+	mount, err := a.handleVolume(ctx, input.Owner, input.Volume)
+	if err != nil {
+		return nil, fmt.Errorf("handling volume %s: %w", input.Volume.Name, err)
+	}
+	if vm.Status.VolumeMounts == nil {
+		vm.Status.VolumeMounts = map[string]domain.Mount{}
+	}
+	vm.Status.VolumeMounts[input.Volume.Name] = *mount
+
+	if err := a.vmService.AttachVolume(ctx, vm, input.Volume.Name); err != nil {
+		delete(vm.Status.VolumeMounts, input.Volume.Name)
+		if releaseErr := a.releaseVolume(ctx, input.Owner, input.Volume); releaseErr != nil {
+			slog.Warn("failed to release volume after attach failure", "volume", input.Volume.Name, "error", releaseErr)
+		}
+
+		return nil, fmt.Errorf("attaching volume %s: %w", input.Volume.Name, err)
+	}
+
+	vm.Spec.AdditionalVolumes = append(vm.Spec.AdditionalVolumes, *input.Volume)
+
+	if err := a.stateService.SaveVM(vm); err != nil {
+		return nil, fmt.Errorf("saving vm state: %w", err)
+	}
+	slog.Info("volume attached", "vm", vm.Name, "volume", input.Volume.Name)
+
+	return vm, nil
+}
+
+func (a *app) DetachVolume(ctx context.Context, name string, volumeName string, owner string) (*domain.VM, error) {
+	slog.Debug("Detaching volume from vm", "vm", name, "volume", volumeName)
+
+	if name == "" {
+		return nil, ErrNameRequired
+	}
+	if volumeName == "" {
+		return nil, ErrVolumeRequired
+	}
+
+	vm, err := a.stateService.GetVM()
+	if err != nil {
+		return nil, fmt.Errorf("getting vm state: %w", err)
+	}
+	if vm == nil {
+		return nil, ErrVMNotFound
+	}
+
+	if vm.Spec.RootVolume.Name == volumeName {
+		return nil, ErrRootVolumeDetach
+	}
+
+	index := -1
+	for i, vol := range vm.Spec.AdditionalVolumes {
+		if vol.Name == volumeName {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return nil, ErrVolumeNotFound
+	}
+	volume := vm.Spec.AdditionalVolumes[index]
+
+	pterm.DefaultSpinner.Info(fmt.Sprintf("ℹ️  Detaching volume %s from VM: %s\n", volumeName, name))
+
+	if err := a.vmService.DetachVolume(ctx, vm, volumeName); err != nil {
+		return nil, fmt.Errorf("detaching volume %s: %w", volumeName, err)
+	}
+
+	// The volume is saved as detached before its released, as it has already
+	// been removed from the vm
+	vm.Spec.AdditionalVolumes = append(vm.Spec.AdditionalVolumes[:index], vm.Spec.AdditionalVolumes[index+1:]...)
+	delete(vm.Status.VolumeMounts, volumeName)
+
+	if err := a.stateService.SaveVM(vm); err != nil {
+		return nil, fmt.Errorf("saving vm state: %w", err)
+	}
+
+	if err := a.releaseVolume(ctx, owner, &volume); err != nil {
+		return nil, fmt.Errorf("releasing volume %s: %w", volumeName, err)
+	}
+	slog.Info("volume detached", "vm", vm.Name, "volume", volumeName)
+
+	return vm, nil
+}
+
+func (a *app) releaseVolume(ctx context.Context, owner string, volume *domain.Volume) error {
+	if volume.Source.Container == nil {
+		return nil
+	}
+
+	return a.imageService.Release(ctx, ports.ReleaseInput{
+		Owner:   owner,
+		UsedFor: ports.ImageUsedForVolume,
+		Name:    volume.Name,
+	})
+}
+
+func hasVolume(vm *domain.VM, volumeName string) bool {
+	if vm.Spec.RootVolume.Name == volumeName {
+		return true
+	}
+
+	for _, vol := range vm.Spec.AdditionalVolumes {
+		if vol.Name == volumeName {
+			return true
+		}
+	}
+
+	return false
+}
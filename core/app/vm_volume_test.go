@@ -0,0 +1,253 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+	"github.com/mikrolite/mikrolite/testing/fakes"
+)
+
+func TestAttachVolume(t *testing.T) {
+	errInjected := errors.New("injected failure")
+
+	testCases := []struct {
+		name          string
+		caps          *ports.Capabilities
+		volumeName    string
+		setup         func(env *testEnv)
+		expectErr     error
+		expectMethods []string
+	}{
+		{
+			name:       "attaches and saves",
+			volumeName: "data",
+			expectMethods: []string{
+				fakes.StateServiceGetVM,
+				fakes.ImageServicePullAndMount,
+				fakes.VMProviderAttachVolume,
+				fakes.StateServiceSaveVM,
+			},
+		},
+		{
+			name:          "existing volume",
+			volumeName:    "root",
+			expectErr:     ErrVolumeAlreadyExists,
+			expectMethods: []string{fakes.StateServiceGetVM},
+		},
+		{
+			name:          "provider without hotplug",
+			caps:          &ports.Capabilities{},
+			volumeName:    "data",
+			expectErr:     ErrUnsupportedByProvider,
+			expectMethods: []string{fakes.StateServiceGetVM},
+		},
+		{
+			name:       "attach failure releases the image",
+			volumeName: "data",
+			setup:      func(env *testEnv) { env.rec.FailOn(fakes.VMProviderAttachVolume, errInjected) },
+			expectErr:  errInjected,
+			expectMethods: []string{
+				fakes.StateServiceGetVM,
+				fakes.ImageServicePullAndMount,
+				fakes.VMProviderAttachVolume,
+				fakes.ImageServiceRelease,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			caps := basicCaps()
+			if tc.caps != nil {
+				caps = *tc.caps
+			}
+			env := newTestEnv(t, caps)
+			env.state.VMs[testVMName] = &domain.VM{
+				Name:   testVMName,
+				Spec:   *testSpec(),
+				Status: &domain.VMStatus{},
+			}
+			if tc.setup != nil {
+				tc.setup(env)
+			}
+
+			vm, err := env.app.AttachVolume(context.Background(), ports.AttachVolumeInput{
+				Name:  testVMName,
+				Owner: testOwner,
+				Volume: &domain.Volume{
+					Name: tc.volumeName,
+					Source: domain.VolumeSource{
+						Container: &domain.ContainerVolumeSource{Image: "ghcr.io/mikrolite/data:dev"},
+					},
+				},
+			})
+
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("expected no error, got %s", err)
+				}
+				saved := env.state.VMs[testVMName]
+				if _, ok := saved.Status.VolumeMounts[tc.volumeName]; !ok {
+					t.Errorf("expected volume mount to be saved")
+				}
+				if len(vm.Spec.AdditionalVolumes) != 1 {
+					t.Errorf("expected volume to be added to the spec")
+				}
+			}
+
+			assertMethods(t, tc.expectMethods, env.rec.Methods())
+		})
+	}
+}
+
+func TestDetachVolume(t *testing.T) {
+	errInjected := errors.New("injected failure")
+
+	testCases := []struct {
+		name          string
+		volumeName    string
+		setup         func(env *testEnv)
+		expectErr     error
+		expectSaved   bool
+		expectMethods []string
+	}{
+		{
+			name:        "detaches and releases",
+			volumeName:  "data",
+			expectSaved: true,
+			expectMethods: []string{
+				fakes.StateServiceGetVM,
+				fakes.VMProviderDetachVolume,
+				fakes.StateServiceSaveVM,
+				fakes.ImageServiceRelease,
+			},
+		},
+		{
+			name:        "release failure is returned after the detach is saved",
+			volumeName:  "data",
+			setup:       func(env *testEnv) { env.rec.FailOn(fakes.ImageServiceRelease, errInjected) },
+			expectErr:   errInjected,
+			expectSaved: true,
+			expectMethods: []string{
+				fakes.StateServiceGetVM,
+				fakes.VMProviderDetachVolume,
+				fakes.StateServiceSaveVM,
+				fakes.ImageServiceRelease,
+			},
+		},
+		{
+			name:          "root volume",
+			volumeName:    "root",
+			expectErr:     ErrRootVolumeDetach,
+			expectMethods: []string{fakes.StateServiceGetVM},
+		},
+		{
+			name:          "unknown volume",
+			volumeName:    "other",
+			expectErr:     ErrVolumeNotFound,
+			expectMethods: []string{fakes.StateServiceGetVM},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTestEnv(t, basicCaps())
+			spec := testSpec()
+			spec.AdditionalVolumes = []domain.Volume{
+				{
+					Name: "data",
+					Source: domain.VolumeSource{
+						Container: &domain.ContainerVolumeSource{Image: "ghcr.io/mikrolite/data:dev"},
+					},
+				},
+			}
+			env.state.VMs[testVMName] = &domain.VM{
+				Name: testVMName,
+				Spec: *spec,
+				Status: &domain.VMStatus{
+					VolumeMounts: map[string]domain.Mount{
+						"data": {Type: domain.MountTypeBlockDevice, Location: "/dev/mapper/data"},
+					},
+				},
+			}
+			if tc.setup != nil {
+				tc.setup(env)
+			}
+
+			_, err := env.app.DetachVolume(context.Background(), testVMName, tc.volumeName, testOwner)
+
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			if tc.expectSaved {
+				saved := env.state.VMs[testVMName]
+				if len(saved.Spec.AdditionalVolumes) != 0 {
+					t.Errorf("expected volume to be removed from the spec")
+				}
+				if _, ok := saved.Status.VolumeMounts[tc.volumeName]; ok {
+					t.Errorf("expected volume mount to be removed")
+				}
+			}
+
+			assertMethods(t, tc.expectMethods, env.rec.Methods())
+		})
+	}
+}
+
+func TestDetachVolumeAfterRestart(t *testing.T) {
+	env := newTestEnv(t, basicCaps())
+	env.app.(*app).now = func() time.Time { return testNow }
+	spec := testSpec()
+	spec.RestartPolicy = &domain.RestartPolicy{Policy: domain.RestartPolicyAlways}
+	env.state.VMs[testVMName] = &domain.VM{
+		Name:   testVMName,
+		Spec:   *spec,
+		Status: &domain.VMStatus{Process: &domain.ProcessStatus{State: domain.ProcessStateRunning}},
+	}
+	env.state.PID = testPID
+	env.process.RunningPIDs[testPID] = true
+
+	ctx := context.Background()
+	_, err := env.app.AttachVolume(ctx, ports.AttachVolumeInput{
+		Name:  testVMName,
+		Owner: testOwner,
+		Volume: &domain.Volume{
+			Name: "data",
+			Source: domain.VolumeSource{
+				Container: &domain.ContainerVolumeSource{Image: "ghcr.io/mikrolite/data:dev"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("attaching volume: %s", err)
+	}
+
+	env.process.RunningPIDs[testPID] = false
+	env.state.Exit = &domain.ProcessExit{Time: testNow.Add(-time.Minute), Signal: "killed"}
+	output, err := env.app.SuperviseVM(ctx, testVMName)
+	if err != nil || !output.Restarted {
+		t.Fatalf("expected the vm to be restarted, got %+v, %v", output, err)
+	}
+	if slot, ok := env.state.VMs[testVMName].Status.VolumeSlots["data"]; ok {
+		t.Fatalf("expected the restarted vm not to have a slot for the volume, got %s", slot)
+	}
+
+	if _, err := env.app.DetachVolume(ctx, testVMName, "data", testOwner); err != nil {
+		t.Fatalf("detaching volume: %s", err)
+	}
+	if saved := env.state.VMs[testVMName]; len(saved.Spec.AdditionalVolumes) != 0 || len(saved.Status.VolumeSlots) != 0 {
+		t.Errorf("expected the volume to be detached, got %+v and slots %v", saved.Spec.AdditionalVolumes, saved.Status.VolumeSlots)
+	}
+}
@@ -0,0 +1,129 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/pterm/pterm"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+const (
+	// defaultIPWait is how long to wait for the vm to get an ip address.
+	defaultIPWait = 20 * time.Second
+	// defaultReadyWait is how long to wait for cloud-init in the vm to finish.
+	defaultReadyWait = 10 * time.Minute
+	// ipPollInterval is how often to look for the ip address of the vm.
+	ipPollInterval = 500 * time.Millisecond
+	// phoneHomeTries is how many times cloud-init tries to signal that the vm is
+	// ready.
+	phoneHomeTries = 10
+)
+
+// bootWait is what creating a vm waits for before it returns.
+type bootWait struct {
+	waitFor  domain.WaitFor
+	timeout  time.Duration
+	listener ports.ReadyListener
+}
+
+func newBootWait(input ports.CreateVMInput) *bootWait {
+	w := &bootWait{
+		waitFor: input.WaitFor,
+		timeout: input.WaitTimeout,
+	}
+	if w.waitFor == "" {
+		w.waitFor = domain.WaitForIP
+	}
+	if w.timeout == 0 {
+		w.timeout = defaultIPWait
+		if w.waitFor == domain.WaitForReady {
+			w.timeout = defaultReadyWait
+		}
+	}
+
+	return w
+}
+
+// phoneHomeURL returns the url the vm signals that its ready on, its empty if
+// creating the vm doesn't wait for ready.
+func (w *bootWait) phoneHomeURL() string {
+	if w.listener == nil {
+		return ""
+	}
+
+	return w.listener.URL()
+}
+
+func (w *bootWait) close() {
+	if w.listener == nil {
+		return
+	}
+	if err := w.listener.Close(); err != nil {
+		slog.Warn("closing ready listener", "error", err)
+	}
+}
+
+// handleListenForReady starts listening for the vm to signal that its ready. It
+// must be called before the user-data is generated, as the user-data has the url
+// to signal on. The vm reaches the host on the address of the bridge.
+func (a *app) handleListenForReady(w *bootWait) handler {
+	return func(ctx context.Context, owner string, vm *domain.VM) error {
+		if w.waitFor != domain.WaitForReady {
+			return nil
+		}
+
+		address, err := a.networkService.BridgeAddress(vm.Spec.NetworkConfiguration.BridgeName)
+		if err != nil {
+			return fmt.Errorf("getting the address to listen for the vm on: %w", err)
+		}
+		w.listener, err = a.readinessService.Listen(address, vm.Name)
+		if err != nil {
+			return fmt.Errorf("listening for the vm to be ready: %w", err)
+		}
+
+		return nil
+	}
+}
+
+// handleWait waits for the vm to get an ip address and, if asked for, to be
+// ready. The timeout is for the whole wait.
+func (a *app) handleWait(w *bootWait) handler {
+	return func(ctx context.Context, owner string, vm *domain.VM) error {
+		if w.waitFor == domain.WaitForNone {
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, w.timeout)
+		defer cancel()
+
+		if err := a.findIP(ctx, vm, w.timeout); err != nil {
+			return err
+		}
+		if w.waitFor != domain.WaitForReady {
+			return nil
+		}
+
+		pterm.DefaultSpinner.Info("ℹ️  Waiting for cloud-init to finish")
+		readyAt, err := w.listener.Wait(ctx)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return fmt.Errorf("waiting %s for cloud-init to signal that the vm is ready: %w", w.timeout, ErrWaitTimeout)
+			}
+
+			return fmt.Errorf("waiting for the vm to be ready: %w", err)
+		}
+		vm.Status.Ready = true
+		if vm.Status.Boot != nil {
+			vm.Status.Boot.ReadyAt = &readyAt
+		}
+		slog.Debug("vm is ready", "vm", vm.Name, "boot", vm.Status.Boot)
+
+		return nil
+	}
+}
@@ -0,0 +1,71 @@
+package domain
+
+import (
+	"net"
+	"strings"
+)
+
+// VSockStatus holds the details of the vsock device of a vm.
+type VSockStatus struct {
+	// CID is the context id of the vm.
+	CID uint32 `json:"cid"`
+	// Socket is the unix socket on the host that connections to the vm are made
+	// with. Connections from the vm to a port are made to Socket_<port>.
+	Socket string `json:"socket"`
+}
+
+// GuestInfo is the information about a running vm reported by the guest agent.
+type GuestInfo struct {
+	// Hostname is the hostname of the vm.
+	Hostname string `json:"hostname"`
+	// Interfaces are the network interfaces in the vm, except loopback.
+	Interfaces []GuestInterface `json:"interfaces,omitempty"`
+	// UptimeSeconds is how long the vm has been running.
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	// LoadAverage is the 1, 5 and 15 minute load average.
+	LoadAverage []float64 `json:"load_average,omitempty"`
+}
+
+// GuestInterface is a network interface in the vm.
+type GuestInterface struct {
+	// Name is the name of the interface, e.g. eth0.
+	Name string `json:"name"`
+	// MAC is the mac address of the interface.
+	MAC string `json:"mac,omitempty"`
+	// Addresses are the ip addresses of the interface in CIDR notation.
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+// IPv4 returns the first ipv4 address of the interface with the mac address. If
+// there isn't an interface with the mac address the first ipv4 address of any
+// interface is returned.
+func (g *GuestInfo) IPv4(mac string) string {
+	for _, iface := range g.Interfaces {
+		if mac != "" && strings.EqualFold(iface.MAC, mac) {
+			if ip := firstIPv4(iface.Addresses); ip != "" {
+				return ip
+			}
+		}
+	}
+	for _, iface := range g.Interfaces {
+		if ip := firstIPv4(iface.Addresses); ip != "" {
+			return ip
+		}
+	}
+
+	return ""
+}
+
+func firstIPv4(addresses []string) string {
+	for _, address := range addresses {
+		ip, _, err := net.ParseCIDR(address)
+		if err != nil {
+			ip = net.ParseIP(address)
+		}
+		if ip != nil && ip.To4() != nil && !ip.IsLoopback() {
+			return ip.String()
+		}
+	}
+
+	return ""
+}
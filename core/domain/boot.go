@@ -0,0 +1,44 @@
+package domain
+
+import "time"
+
+// WaitFor is what creating a vm waits for before it returns.
+type WaitFor string
+
+const (
+	// WaitForReady waits for cloud-init in the vm to signal that it has finished.
+	WaitForReady WaitFor = "ready"
+	// WaitForIP waits for the vm to get an ip address.
+	WaitForIP WaitFor = "ip"
+	// WaitForNone returns once the vm process has started.
+	WaitForNone WaitFor = "none"
+)
+
+// BootStatus holds the boot timing of a vm.
+type BootStatus struct {
+	// StartedAt is when the vm process was started.
+	StartedAt time.Time `json:"started_at"`
+	// IPAt is when the ip address of the vm was found.
+	IPAt *time.Time `json:"ip_at,omitempty"`
+	// ReadyAt is when cloud-init in the vm signalled that it had finished.
+	ReadyAt *time.Time `json:"ready_at,omitempty"`
+}
+
+// TimeToIP returns how long the vm took to get an ip address, 0 if it doesn't
+// have one.
+func (b *BootStatus) TimeToIP() time.Duration {
+	if b.IPAt == nil {
+		return 0
+	}
+
+	return b.IPAt.Sub(b.StartedAt)
+}
+
+// TimeToReady returns how long the vm took to be ready, 0 if it isn't ready.
+func (b *BootStatus) TimeToReady() time.Duration {
+	if b.ReadyAt == nil {
+		return 0
+	}
+
+	return b.ReadyAt.Sub(b.StartedAt)
+}
@@ -1,5 +1,109 @@
 package domain
 
+// BootstrapFormat is the provisioning system the guest uses to bootstrap itself.
+type BootstrapFormat string
+
+const (
+	// BootstrapFormatCloudInit bootstraps the vm with cloud-init, its the default.
+	BootstrapFormatCloudInit BootstrapFormat = "cloud-init"
+	// BootstrapFormatIgnition bootstraps the vm with Ignition, as used by Flatcar
+	// and Fedora CoreOS.
+	BootstrapFormatIgnition BootstrapFormat = "ignition"
+)
+
+// Datasource is the cloud-init datasource the guest gets its metadata from.
+type Datasource string
+
+const (
+	// DatasourceNoCloud serves the metadata in the nocloud layout, its the default.
+	DatasourceNoCloud Datasource = "nocloud"
+	// DatasourceEC2 serves the metadata like the EC2 instance metadata service,
+	// for images and tools that expect to run on EC2.
+	DatasourceEC2 Datasource = "ec2"
+)
+
+// ConfigDrive is the layout of the drive that the guest reads its metadata from
+// instead of a metadata service.
+type ConfigDrive string
+
+const (
+	// ConfigDriveNoCloud is a FAT32 cidata drive in the nocloud layout.
+	ConfigDriveNoCloud ConfigDrive = "nocloud"
+	// ConfigDriveNoCloudISO is an ISO9660 cidata drive in the nocloud layout.
+	ConfigDriveNoCloudISO ConfigDrive = "nocloud-iso"
+	// ConfigDriveOpenStack is an ISO9660 config-2 drive in the OpenStack
+	// config drive layout.
+	ConfigDriveOpenStack ConfigDrive = "openstack"
+)
+
+// Bootstrap is how the vm is configured when it first boots.
 type Bootstrap struct {
-	SSHKey string `json="ssh_key,omitempty"`
+	// Format is the provisioning system the guest uses, cloud-init if its empty.
+	Format BootstrapFormat `json:"format,omitempty"`
+	// Datasource is the cloud-init datasource the guest uses, nocloud if its
+	// empty. The ec2 datasource needs a metadata service.
+	Datasource Datasource `json:"datasource,omitempty"`
+	// ConfigDrive puts the metadata on a drive with the layout even if the
+	// provider has a metadata service. If its empty the metadata service is
+	// used, or a nocloud drive without one.
+	ConfigDrive ConfigDrive `json:"config_drive,omitempty"`
+	// SSHKey is the path to a public key that's authorized for the ml user.
+	SSHKey string `json:"ssh_key,omitempty"`
+	// UserData is base64 encoded user-data that's merged with the generated
+	// config. For cloud-init it can be cloud-config, a script or multipart MIME,
+	// for Ignition its an Ignition config.
+	UserData string `json:"user_data,omitempty"`
+	// VendorData is base64 encoded cloud-init vendor-data, its used as is.
+	VendorData string `json:"vendor_data,omitempty"`
+	// Files are written to the vm when it first boots.
+	Files []BootstrapFile `json:"files,omitempty"`
+	// Units are systemd units that are installed and enabled when the vm first
+	// boots.
+	Units []SystemdUnit `json:"units,omitempty"`
+}
+
+// BootstrapFile is a file written to the vm when it first boots.
+type BootstrapFile struct {
+	// Path is the absolute path of the file in the vm.
+	Path string `json:"path"`
+	// Content is the base64 encoded content of the file.
+	Content string `json:"content"`
+	// Permissions are the octal permissions of the file, e.g. 0644.
+	Permissions string `json:"permissions,omitempty"`
+}
+
+// SystemdUnit is a systemd unit installed in the vm when it first boots.
+type SystemdUnit struct {
+	// Name is the name of the unit, e.g. app.service.
+	Name string `json:"name"`
+	// Contents is the unit file.
+	Contents string `json:"contents"`
+}
+
+// BootstrapFormat returns the provisioning system the guest uses.
+func (s *VMSpec) BootstrapFormat() BootstrapFormat {
+	if s.Bootstrap == nil || s.Bootstrap.Format == "" {
+		return BootstrapFormatCloudInit
+	}
+
+	return s.Bootstrap.Format
+}
+
+// Datasource returns the cloud-init datasource the guest uses.
+func (s *VMSpec) Datasource() Datasource {
+	if s.Bootstrap == nil || s.Bootstrap.Datasource == "" {
+		return DatasourceNoCloud
+	}
+
+	return s.Bootstrap.Datasource
+}
+
+// ConfigDrive returns the layout of the drive that the guest reads its metadata
+// from, its empty if the guest uses a metadata service when there is one.
+func (s *VMSpec) ConfigDrive() ConfigDrive {
+	if s.Bootstrap == nil {
+		return ""
+	}
+
+	return s.Bootstrap.ConfigDrive
 }
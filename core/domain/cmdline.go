@@ -0,0 +1,217 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// initArgsSeparator separates the kernel args from the args passed to init.
+const initArgsSeparator = "--"
+
+// KernelCmdLine is the kernel command line. The args are kept in order, as the
+// kernel and init use the order for args like init= and repeated keys like
+// console. Its stored as the formatted command line.
+type KernelCmdLine struct {
+	// Args are the kernel args in order, a key can be repeated.
+	Args []KernelArg
+	// InitArgs are the args after -- that the kernel passes to init.
+	InitArgs []string
+}
+
+// KernelArg is an arg on the kernel command line. Its a flag, like rw, if the
+// value is empty.
+type KernelArg struct {
+	Key   string
+	Value string
+}
+
+// ParseKernelCmdLine parses a kernel command line. Values can be quoted with
+// double quotes if they contain spaces.
+func ParseKernelCmdLine(cmdLine string) (KernelCmdLine, error) {
+	words, err := splitCmdLine(cmdLine)
+	if err != nil {
+		return KernelCmdLine{}, err
+	}
+
+	parsed := KernelCmdLine{}
+	for i, word := range words {
+		if word == initArgsSeparator {
+			parsed.InitArgs = append(parsed.InitArgs, words[i+1:]...)
+			break
+		}
+		key, value, _ := strings.Cut(word, "=")
+		parsed.Args = append(parsed.Args, KernelArg{Key: key, Value: value})
+	}
+
+	return parsed, nil
+}
+
+// KernelCmdLineFromMap creates a kernel command line from args without an
+// order, the args are sorted by key so that the command line is always the
+// same.
+func KernelCmdLineFromMap(args map[string]string) KernelCmdLine {
+	keys := make([]string, 0, len(args))
+	for key := range args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	cmdLine := KernelCmdLine{}
+	for _, key := range keys {
+		cmdLine.Add(key, args[key])
+	}
+
+	return cmdLine
+}
+
+// IsEmpty returns true if there aren't any kernel or init args.
+func (c KernelCmdLine) IsEmpty() bool {
+	return len(c.Args) == 0 && len(c.InitArgs) == 0
+}
+
+// Get returns the value of the key. If the key is repeated its the last value,
+// which is the one the kernel uses for most args.
+func (c KernelCmdLine) Get(key string) (string, bool) {
+	for i := len(c.Args) - 1; i >= 0; i-- {
+		if c.Args[i].Key == key {
+			return c.Args[i].Value, true
+		}
+	}
+
+	return "", false
+}
+
+// Add appends an arg, even if the key is already on the command line.
+func (c *KernelCmdLine) Add(key, value string) {
+	c.Args = append(c.Args, KernelArg{Key: key, Value: value})
+}
+
+// Set sets the value of the key. The first arg with the key is replaced and
+// any others are removed, the arg is appended if the key isn't there.
+func (c *KernelCmdLine) Set(key, value string) {
+	args := make([]KernelArg, 0, len(c.Args)+1)
+	found := false
+	for _, arg := range c.Args {
+		if arg.Key != key {
+			args = append(args, arg)
+		} else if !found {
+			args = append(args, KernelArg{Key: key, Value: value})
+			found = true
+		}
+	}
+	if !found {
+		args = append(args, KernelArg{Key: key, Value: value})
+	}
+	c.Args = args
+}
+
+// Delete removes all the args with the key.
+func (c *KernelCmdLine) Delete(key string) {
+	args := make([]KernelArg, 0, len(c.Args))
+	for _, arg := range c.Args {
+		if arg.Key != key {
+			args = append(args, arg)
+		}
+	}
+	c.Args = args
+}
+
+// Map returns the args without their order, the last value is used for
+// repeated keys and the init args are dropped.
+func (c KernelCmdLine) Map() map[string]string {
+	args := map[string]string{}
+	for _, arg := range c.Args {
+		args[arg.Key] = arg.Value
+	}
+
+	return args
+}
+
+// String formats the command line for the kernel, with the init args after --.
+func (c KernelCmdLine) String() string {
+	words := make([]string, 0, len(c.Args)+len(c.InitArgs)+1)
+	for _, arg := range c.Args {
+		if arg.Value == "" {
+			words = append(words, arg.Key)
+		} else {
+			words = append(words, arg.Key+"="+quoteCmdLineValue(arg.Value))
+		}
+	}
+	if len(c.InitArgs) > 0 {
+		words = append(words, initArgsSeparator)
+		for _, arg := range c.InitArgs {
+			words = append(words, quoteCmdLineValue(arg))
+		}
+	}
+
+	return strings.Join(words, " ")
+}
+
+func (c KernelCmdLine) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+// UnmarshalJSON reads the formatted command line. Vms created before the order
+// was kept have the args as an object, they're sorted by key.
+func (c *KernelCmdLine) UnmarshalJSON(data []byte) error {
+	var cmdLine string
+	if err := json.Unmarshal(data, &cmdLine); err == nil {
+		parsed, err := ParseKernelCmdLine(cmdLine)
+		if err != nil {
+			return err
+		}
+		*c = parsed
+
+		return nil
+	}
+
+	args := map[string]string{}
+	if err := json.Unmarshal(data, &args); err != nil {
+		return fmt.Errorf("kernel cmdline must be a string: %w", err)
+	}
+	*c = KernelCmdLineFromMap(args)
+
+	return nil
+}
+
+// splitCmdLine splits the command line into words on spaces, except for spaces
+// in double quotes. The quotes are removed.
+func splitCmdLine(cmdLine string) ([]string, error) {
+	words := []string{}
+	var word strings.Builder
+	inWord, quoted := false, false
+	for _, r := range cmdLine {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inWord = true
+		case (r == ' ' || r == '\t' || r == '\n') && !quoted:
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("kernel cmdline %q has an unterminated quote", cmdLine)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
+
+func quoteCmdLineValue(value string) string {
+	if strings.ContainsAny(value, " \t\n") {
+		return `"` + value + `"`
+	}
+
+	return value
+}
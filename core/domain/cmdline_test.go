@@ -0,0 +1,82 @@
+package domain
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseKernelCmdLine(t *testing.T) {
+	testCases := []struct {
+		name      string
+		cmdLine   string
+		expected  string
+		expectErr bool
+	}{
+		{name: "order is kept", cmdLine: "init=/sbin/init console=ttyS0 root=/dev/vda rw", expected: "init=/sbin/init console=ttyS0 root=/dev/vda rw"},
+		{name: "repeated keys", cmdLine: "console=ttyS0 console=hvc0", expected: "console=ttyS0 console=hvc0"},
+		{name: "init args", cmdLine: "console=ttyS0 -- single --debug", expected: "console=ttyS0 -- single --debug"},
+		{name: "quoted value", cmdLine: `dyndbg="file app.c +p"  rw`, expected: `dyndbg="file app.c +p" rw`},
+		{name: "value with equals", cmdLine: "ds=nocloud-net;s=http://169.254.169.254/", expected: "ds=nocloud-net;s=http://169.254.169.254/"},
+		{name: "unterminated quote", cmdLine: `dyndbg="file`, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cmdLine, err := ParseKernelCmdLine(tc.cmdLine)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsing cmdline: %s", err)
+			}
+			if actual := cmdLine.String(); actual != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestKernelCmdLineSet(t *testing.T) {
+	cmdLine, err := ParseKernelCmdLine("console=ttyS0 ds=nocloud rw console=hvc0 -- single")
+	if err != nil {
+		t.Fatalf("parsing cmdline: %s", err)
+	}
+
+	cmdLine.Set("ds", "nocloud-net")
+	cmdLine.Set("console", "ttyS1")
+	cmdLine.Set("panic", "1")
+	cmdLine.Delete("rw")
+
+	if expected := "console=ttyS1 ds=nocloud-net panic=1 -- single"; cmdLine.String() != expected {
+		t.Errorf("expected %q, got %q", expected, cmdLine.String())
+	}
+	if value, ok := cmdLine.Get("console"); !ok || value != "ttyS1" {
+		t.Errorf("expected console ttyS1, got %q", value)
+	}
+}
+
+func TestKernelCmdLineJSON(t *testing.T) {
+	kernel := Kernel{}
+	if err := json.Unmarshal([]byte(`{"cmd_line": "console=ttyS0 rw -- single"}`), &kernel); err != nil {
+		t.Fatalf("unmarshalling kernel: %s", err)
+	}
+	data, err := json.Marshal(kernel)
+	if err != nil {
+		t.Fatalf("marshalling kernel: %s", err)
+	}
+	if expected := `{"source":{"filename":""},"cmd_line":"console=ttyS0 rw -- single"}`; string(data) != expected {
+		t.Errorf("expected %s, got %s", expected, data)
+	}
+
+	// Vms created before the order was kept have the args as an object
+	legacy := Kernel{}
+	if err := json.Unmarshal([]byte(`{"cmd_line": {"rw": "", "console": "ttyS0"}}`), &legacy); err != nil {
+		t.Fatalf("unmarshalling legacy kernel: %s", err)
+	}
+	if expected := "console=ttyS0 rw"; legacy.CmdLine.String() != expected {
+		t.Errorf("expected %q, got %q", expected, legacy.CmdLine.String())
+	}
+}
@@ -0,0 +1,37 @@
+package domain
+
+import "time"
+
+// MetricType is the type of a metric.
+type MetricType string
+
+const (
+	// MetricTypeCounter is a value that only increases, such as the bytes received.
+	MetricTypeCounter MetricType = "counter"
+	// MetricTypeGauge is a value that can go up and down, such as a latency.
+	MetricTypeGauge MetricType = "gauge"
+)
+
+// Metric is a value measured for a vm.
+type Metric struct {
+	// Name is the name of the metric, e.g. tap_rx_bytes.
+	Name string `json:"name"`
+	// Type is the type of the metric.
+	Type MetricType `json:"type"`
+	// Labels identify the device the metric is for, if any.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Value is the value of the metric.
+	Value float64 `json:"value"`
+}
+
+// VMStats holds the metrics of a running vm.
+type VMStats struct {
+	// Name is the name of the vm.
+	Name string `json:"name"`
+	// Provider is the name of the vm provider, it isn't known by the core app.
+	Provider string `json:"provider,omitempty"`
+	// Time is when the metrics were collected.
+	Time time.Time `json:"time"`
+	// Metrics are the metrics from the vm provider and the host network interfaces.
+	Metrics []Metric `json:"metrics"`
+}
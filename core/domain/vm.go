@@ -1,5 +1,10 @@
 package domain
 
+import (
+	"sort"
+	"time"
+)
+
 // VM represents the spec and status of a VM.
 type VM struct {
 	// Name is the name of the vm. Used as an identified only and not the hostname.
@@ -26,6 +31,29 @@ type VMSpec struct {
 	MemoryInMb int `json:"memory_in_mb"`
 	// NetworkConfiguration holds the configuration for the the vm networking
 	NetworkConfiguration NetworkConfiguration `json:"network_configuration"`
+	// VolumeSlots is the number of spare volume slots to reserve for hot-attaching
+	// volumes. Its only used by providers that can't hotplug new devices. If its
+	// DefaultVolumeSlots then the number for the provider is used when the vm is
+	// created.
+	VolumeSlots int `json:"volume_slots,omitempty"`
+
+	// Labels are arbitrary key/values used to identify the vm.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Metadata is additional cloud-init metadata keyed by the cloud-init key name
+	// (e.g. user-data) with base64 encoded values. It replaces the generated values.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Autostart is true if the vm is started when the host boots.
+	Autostart bool `json:"autostart,omitempty"`
+
+	// RestartPolicy defines what happens when the vm process exits. The vm isn't
+	// restarted if it isn't set.
+	RestartPolicy *RestartPolicy `json:"restart_policy,omitempty"`
+
+	// GuestAgent is true if the vm runs the mikrolite guest agent. The vm has
+	// booted when the agent signals that its ready, rather than when its ip
+	// address is found.
+	GuestAgent bool `json:"guest_agent,omitempty"`
 
 	//TODO: should this be separate completely???
 	Bootstrap *Bootstrap `json:"bootstrap"`
@@ -36,8 +64,13 @@ type VMStatus struct {
 	// VolumeMounts holds details of where the volumes are mounted.
 	VolumeMounts map[string]Mount `json:"volume_mounts"`
 
+	// VolumeSlots holds the provider device slot that each hot-attached volume is using.
+	VolumeSlots map[string]string `json:"volume_slots,omitempty"`
+
 	// KernelMount holds the mount details for the kernel.
 	KernelMount *Mount `json:"kernel_mount,omitempty"`
+	// InitrdMount holds the mount details for the initrd, if the vm has one.
+	InitrdMount *Mount `json:"initrd_mount,omitempty"`
 
 	// NetworkNamespace is the netns for this vm
 	NetworkNamespace string
@@ -48,16 +81,116 @@ type VMStatus struct {
 	// Metadata holds any generated metadata.
 	Metadata map[string]string `json:"metadata,omitempty"`
 
+	// MetadataURL is the url the vm gets its metadata from, its empty if the
+	// metadata is on a disk attached to the vm.
+	MetadataURL string `json:"metadata_url,omitempty"`
+
+	// Process holds the status of the vm process.
+	Process *ProcessStatus `json:"process,omitempty"`
+
+	// ConsoleSocket is the unix socket the serial console is served on, if the
+	// provider supports attaching to the console.
+	ConsoleSocket string `json:"console_socket,omitempty"`
+
+	// VSock holds the details of the vsock device used to talk to the guest
+	// agent, if the provider configured one.
+	VSock *VSockStatus `json:"vsock,omitempty"`
+
+	// Owner is the owner of the images used by the vm.
+	Owner string `json:"owner,omitempty"`
+
 	// TODO: refactor this
 	IP string `json:"ip,omitempty"`
+
+	// Ready is true once cloud-init in the vm has signalled that it finished. It
+	// covers the first boot, the vm isn't waited for when its started again.
+	Ready bool `json:"ready,omitempty"`
+
+	// Boot holds the boot timing of the last time the vm was started.
+	Boot *BootStatus `json:"boot,omitempty"`
+}
+
+// DefaultVolumeSlots is the number of volume slots that means the default for
+// the provider is used.
+const DefaultVolumeSlots = -1
+
+// RestartPolicyType is the type of restart policy.
+type RestartPolicyType string
+
+const (
+	// RestartPolicyNo means the vm is never restarted.
+	RestartPolicyNo RestartPolicyType = "no"
+	// RestartPolicyOnFailure means the vm is restarted if the process fails.
+	RestartPolicyOnFailure RestartPolicyType = "on-failure"
+	// RestartPolicyAlways means the vm is restarted whenever the process exits.
+	RestartPolicyAlways RestartPolicyType = "always"
+)
+
+// RestartPolicy defines if and when the vm process is restarted.
+type RestartPolicy struct {
+	// Policy is the type of restart policy.
+	Policy RestartPolicyType `json:"policy"`
+	// MaxRetries is the number of times a failed vm is restarted before giving up.
+	// Only used with on-failure, 0 means there is no limit.
+	MaxRetries int `json:"max_retries,omitempty"`
+	// BackoffSeconds is the delay before the first restart, the delay is doubled
+	// for each restart after that.
+	BackoffSeconds int `json:"backoff_seconds,omitempty"`
+}
+
+// ProcessState is the state of the vm process.
+type ProcessState string
+
+const (
+	// ProcessStateRunning means the vm process is running.
+	ProcessStateRunning ProcessState = "running"
+	// ProcessStateExited means the vm process exited successfully.
+	ProcessStateExited ProcessState = "exited"
+	// ProcessStateCrashed means the vm process failed or was killed.
+	ProcessStateCrashed ProcessState = "crashed"
+	// ProcessStateStopped means the vm process was stopped on request.
+	ProcessStateStopped ProcessState = "stopped"
+)
+
+// ProcessStatus holds the status of the vm process.
+type ProcessStatus struct {
+	// State is the state of the process.
+	State ProcessState `json:"state"`
+	// StartedAt is when the process was last started.
+	StartedAt time.Time `json:"started_at"`
+	// Restarts is the number of times the process has been restarted in a row.
+	Restarts int `json:"restarts,omitempty"`
+	// LastExit holds the details of the last time the process exited.
+	LastExit *ProcessExit `json:"last_exit,omitempty"`
+}
+
+// ProcessExit holds the details of a process exit.
+type ProcessExit struct {
+	// Time is when the process exited.
+	Time time.Time `json:"time"`
+	// ExitCode is the exit code of the process, it isn't set if the process was
+	// killed by a signal or if the exit code isn't known.
+	ExitCode *int `json:"exit_code,omitempty"`
+	// Signal is the signal that killed the process.
+	Signal string `json:"signal,omitempty"`
+	// Reason is a description of why the process exited.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Succeeded returns true if the process exited with a zero exit code.
+func (e *ProcessExit) Succeeded() bool {
+	return e.ExitCode != nil && *e.ExitCode == 0
 }
 
 // Kernel defines the kernel to use.
 type Kernel struct {
 	// Source defines where to get the kernel from.
 	Source KernelSource `json:"source"`
-	// CmdLine is the cmd line args for the kernel.
-	CmdLine map[string]string `json:"cmd_line"`
+	// CmdLine is the cmd line args for the kernel, the vm provider uses its
+	// defaults if there aren't any args.
+	CmdLine KernelCmdLine `json:"cmd_line"`
+	// Initrd is an optional initial ramdisk, from the same sources as the kernel.
+	Initrd *KernelSource `json:"initrd,omitempty"`
 }
 
 // Volume represents a volume for a VM.
@@ -113,20 +246,101 @@ type HostPathKernelSource struct {
 type NetworkConfiguration struct {
 	BridgeName string                      `json:"bridge_name"`
 	Interfaces map[string]NetwortInterface `json:"interfaces,omitempty"`
+	// ConfigFormat is the format of the network config given to the guest. Its
+	// detected from the root image if its empty.
+	ConfigFormat NetworkConfigFormat `json:"config_format,omitempty"`
+}
+
+// PrimaryInterface returns the name of the interface the vm is reached on, its
+// the first interface attached to the bridge that isn't used for metadata
+// requests.
+func (n NetworkConfiguration) PrimaryInterface() (string, bool) {
+	names := make([]string, 0, len(n.Interfaces))
+	for name, netInt := range n.Interfaces {
+		if netInt.AttachToBridge && !netInt.AllowMetadataRequests {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", false
+	}
+	sort.Strings(names)
+
+	return names[0], true
 }
 
+// NetworkConfigFormat is the format of the network config given to the guest.
+type NetworkConfigFormat string
+
+const (
+	// NetworkConfigFormatV2 is the cloud-init network config v2, which is netplan.
+	NetworkConfigFormatV2 NetworkConfigFormat = "v2"
+	// NetworkConfigFormatV1 is the cloud-init network config v1, for older
+	// versions of cloud-init.
+	NetworkConfigFormatV1 NetworkConfigFormat = "v1"
+	// NetworkConfigFormatENI is the Debian /etc/network/interfaces format.
+	NetworkConfigFormatENI NetworkConfigFormat = "eni"
+
+	// NetworkConfigFormatLabel is the label of the root image that sets the
+	// network config format if its not in the spec.
+	NetworkConfigFormatLabel = "io.mikrolite.network-config-format"
+)
+
 // NetwortkInterface is network interface attached to the vm.
 type NetwortInterface struct {
 	GuestDeviceName       string             `json:"guest_device_name"`
 	AllowMetadataRequests bool               `json:"allow_metadata_requests"`
 	AttachToBridge        bool               `json:"attach_to_bridge"`
 	StaticIPv4Address     *StaticIPv4Address `json:"static_ipv4_address"`
+	// GuestMAC is the mac address to use in the guest, one is generated if empty.
+	GuestMAC string `json:"guest_mac,omitempty"`
+	// MTU is the mtu of the interface in the guest, the default is used if its 0.
+	MTU int `json:"mtu,omitempty"`
+	// Routes are static routes over the interface.
+	Routes []Route `json:"routes,omitempty"`
+	// DHCPOverrides change which of the settings from dhcp are used, if the
+	// interface doesn't have a static address.
+	DHCPOverrides *DHCPOverrides `json:"dhcp_overrides,omitempty"`
 }
 
 type StaticIPv4Address struct {
 	Address     string   `json:"address"`
 	Gateway     *string  `json:"gateway,omitempty"`
 	Nameservers []string `json:"nameservers"`
+	// SearchDomains are the dns search domains.
+	SearchDomains []string `json:"search_domains,omitempty"`
+}
+
+// RouteDefault is the destination of the default route.
+const RouteDefault = "default"
+
+// Route is a static route in the guest.
+type Route struct {
+	// To is the destination as a cidr, or default for the default route.
+	To string `json:"to"`
+	// Via is the address of the gateway.
+	Via string `json:"via"`
+	// Metric is the metric of the route, the default is used if its nil.
+	Metric *int `json:"metric,omitempty"`
+	// OnLink is true if the gateway is directly reachable even though its not in
+	// the subnet of the interface.
+	OnLink bool `json:"on_link,omitempty"`
+}
+
+// DHCPOverrides change which of the settings from dhcp are used. The settings
+// are used if they're nil.
+type DHCPOverrides struct {
+	// UseDNS is false to ignore the nameservers from dhcp.
+	UseDNS *bool `json:"use_dns,omitempty"`
+	// UseDomains is false to ignore the search domains from dhcp.
+	UseDomains *bool `json:"use_domains,omitempty"`
+	// UseRoutes is false to ignore the routes, including the default route,
+	// from dhcp.
+	UseRoutes *bool `json:"use_routes,omitempty"`
+	// UseHostname is false to ignore the hostname from dhcp.
+	UseHostname *bool `json:"use_hostname,omitempty"`
+	// RouteMetric is the metric of the routes from dhcp.
+	RouteMetric *int `json:"route_metric,omitempty"`
 }
 
 // NetworkStatus holds information about the status of the network
@@ -143,6 +357,8 @@ type Mount struct {
 	Type MountType `json:"type"`
 	// Location is the location of the mount.
 	Location string `json:"location"`
+	// Labels are the labels of the image, if the mount is of an image.
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 // MountType is the type of volume mount.
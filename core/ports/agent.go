@@ -0,0 +1,14 @@
+package ports
+
+import (
+	"context"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+// GuestAgentService is used to talk to the mikrolite guest agent in a vm.
+type GuestAgentService interface {
+	// WaitReady waits for the agent to signal that the vm has booted and returns
+	// the guest info it sent.
+	WaitReady(ctx context.Context, vsock *domain.VSockStatus) (*domain.GuestInfo, error)
+}
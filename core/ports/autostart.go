@@ -0,0 +1,15 @@
+package ports
+
+import (
+	"context"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+// AutostartService is used to start vms when the host boots.
+type AutostartService interface {
+	// Enable will make the vm start when the host boots.
+	Enable(ctx context.Context, vm *domain.VM) error
+	// Disable will stop the named vm from starting when the host boots.
+	Disable(ctx context.Context, name string) error
+}
@@ -10,6 +10,15 @@ type PullAndMountInput struct {
 	ImageName string
 	Owner     string
 	UsedFor   ImageUserFor
+	// Name is an optional name used to make the mount unique for the owner,
+	// such as the name of the volume.
+	Name string
+}
+
+type ReleaseInput struct {
+	Owner   string
+	UsedFor ImageUserFor
+	Name    string
 }
 
 type ImageUserFor string
@@ -21,9 +30,17 @@ const (
 
 // Image service is the definition of a driven port for interacting with container images.
 type ImageService interface {
-	// PullAndMount will pull an image and mount it.
+	// PullAndMount will pull an image and mount it. The mount has the labels of
+	// the image.
 	PullAndMount(ctx context.Context, input PullAndMountInput) (*domain.Mount, error)
 
+	// Release will remove a single mount previously created with PullAndMount.
+	Release(ctx context.Context, input ReleaseInput) error
+
 	// Cleanup any images used by a VM.
 	Cleanup(ctx context.Context, owner string) error
+
+	// ListOwners returns the owners that have images, used to find images that
+	// are no longer used by a vm.
+	ListOwners(ctx context.Context) ([]string, error)
 }
@@ -8,10 +8,37 @@ type NetworkService interface {
 	InterfaceCreate(name string, mac string) error
 	InterfaceDelete(name string) error
 	InterfaceExists(name string) (bool, error)
+	// ListInterfaces returns the names of the interfaces that start with the prefix.
+	ListInterfaces(prefix string) ([]string, error)
 
 	AttachToBridge(interfaceName string, bridgeName string) error
+	// BridgeAddress returns the ipv4 address of the bridge, which the vms
+	// attached to it can reach the host on.
+	BridgeAddress(name string) (string, error)
 
 	NewInterfaceName(prefix string) (string, error)
+	// RouteToGuest adds the host address to the interface and a route to the
+	// guest address over it, so that the vm can reach the host on the address.
+	RouteToGuest(interfaceName string, hostAddress string, guestAddress string) error
+
+	// RouteInterface returns the name of the interface that the host routes the
+	// address over.
+	RouteInterface(address string) (string, error)
 
 	GetIPFromMac(macAddress string) (string, error)
+
+	// InterfaceStats returns the counters of the interface.
+	InterfaceStats(name string) (*InterfaceStats, error)
+}
+
+// InterfaceStats are the counters of a network interface on the host.
+type InterfaceStats struct {
+	RxBytes   uint64
+	RxPackets uint64
+	RxErrors  uint64
+	RxDropped uint64
+	TxBytes   uint64
+	TxPackets uint64
+	TxErrors  uint64
+	TxDropped uint64
 }
@@ -0,0 +1,12 @@
+package ports
+
+import "time"
+
+// ProcessService is used to query host processes.
+type ProcessService interface {
+	// Running returns true if the process with the pid is running.
+	Running(pid int) (bool, error)
+	// BootTime returns the time the host booted. Pids recorded before then
+	// belong to processes that no longer exist.
+	BootTime() (time.Time, error)
+}
@@ -0,0 +1,24 @@
+package ports
+
+import (
+	"context"
+	"time"
+)
+
+// ReadinessService receives the signal from a vm that cloud-init has finished.
+type ReadinessService interface {
+	// Listen starts listening on the address for the vm to signal that its
+	// ready.
+	Listen(address string, vmName string) (ReadyListener, error)
+}
+
+// ReadyListener is listening for a vm to signal that its ready.
+type ReadyListener interface {
+	// URL is the url the vm must post to, its used as the cloud-init phone_home
+	// url.
+	URL() string
+	// Wait waits for the vm to signal that its ready and returns when it did.
+	Wait(ctx context.Context) (time.Time, error)
+	// Close stops listening.
+	Close() error
+}
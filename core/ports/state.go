@@ -1,6 +1,20 @@
 package ports
 
-import "github.com/mikrolite/mikrolite/core/domain"
+import (
+	"time"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+// StateDir is a vm state directory.
+type StateDir struct {
+	// Name is the name of the vm.
+	Name string
+	// ModTime is when the directory was last modified.
+	ModTime time.Time
+	// PID is the saved pid of the vm process, 0 if there isn't one.
+	PID int
+}
 
 type StateService interface {
 	Root() string
@@ -8,14 +22,25 @@ type StateService interface {
 	GetVM() (*domain.VM, error)
 	SaveVM(vm *domain.VM) error
 	ListVMs() ([]*domain.VM, error)
+	// ListDirs returns the vm state directories, including those without a saved vm.
+	ListDirs() ([]StateDir, error)
 
 	LogPath() string
 	StdoutPath() string
 	StderrPath() string
+	// MikroliteLogPath is the path of the log of what mikrolite has done to the vm.
+	MikroliteLogPath() string
 
 	GetMetadata() (map[string]string, error)
 	SaveMetadata(metadata map[string]string) error
 
 	GetPID() (int, error)
 	SavePID(pid int) error
+
+	// GetExit returns the last recorded exit of the vm process, or nil if there isn't one.
+	GetExit() (*domain.ProcessExit, error)
+	// SaveExit records the exit of the vm process.
+	SaveExit(exit *domain.ProcessExit) error
+	// DeleteExit removes the recorded exit of the vm process.
+	DeleteExit() error
 }
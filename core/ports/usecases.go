@@ -2,6 +2,7 @@ package ports
 
 import (
 	"context"
+	"time"
 
 	"github.com/mikrolite/mikrolite/core/domain"
 )
@@ -10,6 +11,18 @@ type CreateVMInput struct {
 	Name  string
 	Owner string
 	Spec  *domain.VMSpec
+	// WaitFor is what to wait for before returning, domain.WaitForIP if it
+	// isn't set.
+	WaitFor domain.WaitFor
+	// WaitTimeout is how long to wait, a default for WaitFor is used if it
+	// isn't set.
+	WaitTimeout time.Duration
+}
+
+type AttachVolumeInput struct {
+	Name   string
+	Owner  string
+	Volume *domain.Volume
 }
 
 // VMUseCases defines the uses cases related to interacting with vms
@@ -22,4 +35,82 @@ type VMUseCases interface {
 	GetVM(ctx context.Context, name string) (*domain.VM, error)
 	// ListVMs is the use case for listing all the vms.
 	ListVMs(ctx context.Context) ([]*domain.VM, error)
+	// AttachVolume is the use case for attaching a volume to a running VM.
+	AttachVolume(ctx context.Context, input AttachVolumeInput) (*domain.VM, error)
+	// DetachVolume is the use case for detaching a volume from a running VM.
+	DetachVolume(ctx context.Context, name string, volumeName string, owner string) (*domain.VM, error)
+	// StartVM is the use case for starting an existing VM that isn't running.
+	StartVM(ctx context.Context, name string, owner string) (*domain.VM, error)
+	// EnableVM is the use case for making a VM start when the host boots.
+	EnableVM(ctx context.Context, name string) (*domain.VM, error)
+	// DisableVM is the use case for stopping a VM from starting when the host boots.
+	DisableVM(ctx context.Context, name string) (*domain.VM, error)
+	// StatsVM is the use case for getting the metrics of a running VM.
+	StatsVM(ctx context.Context, name string) (*domain.VMStats, error)
+}
+
+// SuperviseVMOutput is the result of supervising a vm.
+type SuperviseVMOutput struct {
+	// VM is the supervised vm.
+	VM *domain.VM
+	// Exited is set if the vm process was found to have exited.
+	Exited *domain.ProcessExit
+	// Restarted is true if the vm process was restarted.
+	Restarted bool
+}
+
+// SupervisorUseCases defines the use cases for supervising the vm processes.
+type SupervisorUseCases interface {
+	// SuperviseVM checks if the vm process has exited, records the exit and
+	// restarts the process if the restart policy requires it.
+	SuperviseVM(ctx context.Context, name string) (*SuperviseVMOutput, error)
+	// WaitVM waits for the vm process to exit and records the exit.
+	WaitVM(ctx context.Context, name string) (*domain.ProcessExit, error)
+	// StopVM stops the vm process, it's killed if it doesn't stop in time.
+	StopVM(ctx context.Context, name string) error
+}
+
+// OrphanType is the type of a resource found by garbage collection.
+type OrphanType string
+
+const (
+	// OrphanTypeVM is a vm whose process isn't running.
+	OrphanTypeVM OrphanType = "vm"
+	// OrphanTypeState is a state directory without a created vm.
+	OrphanTypeState OrphanType = "state"
+	// OrphanTypeImages are the containerd lease and snapshots of an owner.
+	OrphanTypeImages OrphanType = "images"
+	// OrphanTypeInterface is a tap device.
+	OrphanTypeInterface OrphanType = "interface"
+)
+
+// Orphan is a resource that isn't used by any vm.
+type Orphan struct {
+	Type OrphanType `json:"type"`
+	// Name is the name of the vm, the owner of the images or the name of the interface.
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+	// Removed is true if the resource was removed.
+	Removed bool `json:"removed,omitempty"`
+	// Error is set if removing the resource failed.
+	Error string `json:"error,omitempty"`
+}
+
+type GCInput struct {
+	// Force removes the orphans, otherwise they are only reported.
+	Force bool
+	// IncludeStopped includes the vms whose process isn't running. Vms that start
+	// on boot are never included.
+	IncludeStopped bool
+}
+
+type GCOutput struct {
+	Orphans []Orphan
+}
+
+// GCUseCases are the use cases for cleaning up resources left behind, for
+// example by a failed create or a host crash.
+type GCUseCases interface {
+	// GC finds the orphaned resources and removes them if forced.
+	GC(ctx context.Context, input GCInput) (*GCOutput, error)
 }
@@ -15,7 +15,97 @@ type VMProvider interface {
 	// Delete will delete a running vm.
 	Delete(ctx context.Context, id string) error
 
-	// HasMetadataService returns true if the provider has a metadata service
-	// NOTE: we could expose features like this using "capabilities"
-	HasMetadataService() bool
+	// AttachVolume will attach the mount for the named volume to a running vm.
+	AttachVolume(ctx context.Context, vm *domain.VM, volumeName string) error
+	// DetachVolume will detach the named volume from a running vm.
+	DetachVolume(ctx context.Context, vm *domain.VM, volumeName string) error
+
+	// Metrics returns the metrics the hypervisor collects for a running vm.
+	Metrics(ctx context.Context, vm *domain.VM) ([]domain.Metric, error)
+
+	// Capabilities returns the features supported by the provider.
+	Capabilities() Capabilities
+}
+
+// Capabilities describes the features that a vm provider supports.
+type Capabilities struct {
+	// MetadataService is true if the provider has a built-in metadata service.
+	MetadataService bool `json:"metadata_service,omitempty"`
+	// Snapshot is true if the provider can snapshot and restore a vm.
+	Snapshot bool `json:"snapshot,omitempty"`
+	// Pause is true if the provider can pause and resume a vm.
+	Pause bool `json:"pause,omitempty"`
+	// HotplugDisk is true if disks can be added to and removed from a running vm.
+	HotplugDisk bool `json:"hotplug_disk,omitempty"`
+	// HotplugNetwork is true if network interfaces can be added to and removed from a running vm.
+	HotplugNetwork bool `json:"hotplug_network,omitempty"`
+	// HotplugCPU is true if vcpus can be added to a running vm.
+	HotplugCPU bool `json:"hotplug_cpu,omitempty"`
+	// HotplugMemory is true if memory can be added to a running vm.
+	HotplugMemory bool `json:"hotplug_memory,omitempty"`
+	// Vsock is true if the provider supports virtio-vsock devices.
+	Vsock bool `json:"vsock,omitempty"`
+	// VirtioFS is true if the provider supports sharing host directories using virtio-fs.
+	VirtioFS bool `json:"virtio_fs,omitempty"`
+	// Balloon is true if the provider supports a memory balloon device.
+	Balloon bool `json:"balloon,omitempty"`
+	// Metrics is true if the provider can return the metrics of a running vm.
+	Metrics bool `json:"metrics,omitempty"`
+	// DiskFeatures are the disk features supported by the provider.
+	DiskFeatures []DiskFeature `json:"disk_features,omitempty"`
+	// NetworkFeatures are the network features supported by the provider.
+	NetworkFeatures []NetworkFeature `json:"network_features,omitempty"`
+}
+
+// HasDiskFeature returns true if the disk feature is supported.
+func (c Capabilities) HasDiskFeature(feature DiskFeature) bool {
+	for _, f := range c.DiskFeatures {
+		if f == feature {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasNetworkFeature returns true if the network feature is supported.
+func (c Capabilities) HasNetworkFeature(feature NetworkFeature) bool {
+	for _, f := range c.NetworkFeatures {
+		if f == feature {
+			return true
+		}
+	}
+
+	return false
 }
+
+// DiskFeature is a feature of the disks supported by a provider.
+type DiskFeature string
+
+const (
+	// DiskFeatureRawFile means disks can be backed by a raw file.
+	DiskFeatureRawFile DiskFeature = "raw-file"
+	// DiskFeatureBlockDevice means disks can be backed by a block device.
+	DiskFeatureBlockDevice DiskFeature = "block-device"
+	// DiskFeatureReadOnly means disks can be attached read-only.
+	DiskFeatureReadOnly DiskFeature = "read-only"
+	// DiskFeatureRateLimit means disk io can be rate limited.
+	DiskFeatureRateLimit DiskFeature = "rate-limit"
+	// DiskFeatureBackingSwap means the backing file of an attached disk can be swapped
+	// on a running vm. This is used to hot-attach volumes using pre-provisioned slots.
+	DiskFeatureBackingSwap DiskFeature = "backing-swap"
+)
+
+// NetworkFeature is a feature of the networking supported by a provider.
+type NetworkFeature string
+
+const (
+	// NetworkFeatureTap means network interfaces can be backed by a tap device.
+	NetworkFeatureTap NetworkFeature = "tap"
+	// NetworkFeatureMetadataAccess means an interface can be allowed to access the metadata service.
+	NetworkFeatureMetadataAccess NetworkFeature = "metadata-access"
+	// NetworkFeatureRateLimit means network io can be rate limited.
+	NetworkFeatureRateLimit NetworkFeature = "rate-limit"
+	// NetworkFeatureMultiQueue means network interfaces can have multiple queues.
+	NetworkFeatureMultiQueue NetworkFeature = "multi-queue"
+)
@@ -1,5 +1,7 @@
 package defaults
 
+import "time"
+
 const (
 	// DataFilePerm is the permissions to use for data files.
 	DataFilePerm = 0o644
@@ -12,4 +14,42 @@ const (
 
 	// MetadataInterfacePrefix is a prefix to use for network interface names for a metadata connection
 	MetadataInterfacePrefix = "mltm"
+
+	// MetadataAddress is the address that vms get their metadata from.
+	MetadataAddress = "169.254.169.254"
+
+	// MetadataURL is the nocloud-net seed url that vms get their metadata from.
+	MetadataURL = "http://" + MetadataAddress + "/latest/"
+
+	// MetadataListenAddress is the address that the metadata server run on the
+	// host listens on, for providers without a metadata service.
+	MetadataListenAddress = MetadataAddress + ":80"
+
+	// VolumeSlots is the number of spare volume slots to reserve for hot-attaching
+	// volumes, for providers that use slots, if the number isn't given.
+	VolumeSlots = 2
+
+	// StatePath is the default root directory to hold the vm state in.
+	StatePath = "/usr/local/share/mikrolite"
+
+	// DaemonSocketPath is the default path of the mikrolited api socket.
+	DaemonSocketPath = "/run/mikrolite/mikrolited.sock"
+
+	// SuperviseInterval is how often the daemon checks the vm processes.
+	SuperviseInterval = 5 * time.Second
+
+	// GCInterval is how often the daemon removes orphaned resources.
+	GCInterval = 10 * time.Minute
+
+	// LogMaxSize is the size in bytes a vm log file can grow to before its rotated.
+	LogMaxSize = 10 * 1024 * 1024
+
+	// LogMaxFiles is the number of rotated files kept for each vm log file.
+	LogMaxFiles = 3
+
+	// PluginPath is the default directory to search for provider plugins.
+	PluginPath = "/usr/local/lib/mikrolite/plugins"
+
+	// SystemdUnitPath is the default directory to write the systemd units to.
+	SystemdUnitPath = "/etc/systemd/system"
 )
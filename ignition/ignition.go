@@ -0,0 +1,105 @@
+// Package ignition has the types of the Ignition v3 config used by Flatcar and
+// Fedora CoreOS, and how its delivered to the vm.
+package ignition
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// Version is the Ignition config spec version that's generated.
+	Version = "3.3.0"
+	// ConfigKey is the metadata key name to use for the Ignition config.
+	ConfigKey = "ignition"
+	// ConfigDriveLabel is the label of the config drive that Ignition reads the
+	// config from on the openstack platform.
+	ConfigDriveLabel = "config-2"
+	// ConfigDrivePath is the path of the config in the config drive.
+	ConfigDrivePath = "/openstack/latest/user_data"
+)
+
+// Config is an Ignition v3 config.
+type Config struct {
+	Ignition Ignition `json:"ignition"`
+	Passwd   *Passwd  `json:"passwd,omitempty"`
+	Storage  *Storage `json:"storage,omitempty"`
+	Systemd  *Systemd `json:"systemd,omitempty"`
+}
+
+type Ignition struct {
+	Version string          `json:"version"`
+	Config  *IgnitionConfig `json:"config,omitempty"`
+}
+
+// IgnitionConfig is other configs that Ignition merges into this one.
+type IgnitionConfig struct {
+	Merge []Resource `json:"merge,omitempty"`
+}
+
+type Resource struct {
+	Source string `json:"source"`
+}
+
+type Passwd struct {
+	Users []User `json:"users,omitempty"`
+}
+
+type User struct {
+	Name              string   `json:"name"`
+	Gecos             string   `json:"gecos,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+	Shell             string   `json:"shell,omitempty"`
+	SSHAuthorizedKeys []string `json:"sshAuthorizedKeys,omitempty"`
+}
+
+type Storage struct {
+	Files []File `json:"files,omitempty"`
+}
+
+type File struct {
+	Path      string   `json:"path"`
+	Overwrite *bool    `json:"overwrite,omitempty"`
+	Mode      *int     `json:"mode,omitempty"`
+	Contents  Resource `json:"contents"`
+}
+
+type Systemd struct {
+	Units []Unit `json:"units,omitempty"`
+}
+
+type Unit struct {
+	Name     string `json:"name"`
+	Enabled  *bool  `json:"enabled,omitempty"`
+	Contents string `json:"contents,omitempty"`
+}
+
+// DataURL returns a data url with the content, for use as the source of a
+// file or a config to merge.
+func DataURL(content []byte) string {
+	return "data:;base64," + base64.StdEncoding.EncodeToString(content)
+}
+
+// Validate checks that the data is an Ignition v3 config, it doesn't check the
+// rest of the config against the spec.
+func Validate(data []byte) error {
+	config := struct {
+		Ignition struct {
+			Version string `json:"version"`
+		} `json:"ignition"`
+	}{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("parsing ignition config: %w", err)
+	}
+	if config.Ignition.Version == "" {
+		return errors.New("ignition config doesn't have a version")
+	}
+	if !strings.HasPrefix(config.Ignition.Version, "3.") {
+		return fmt.Errorf("ignition config version %s isn't supported, only version 3 is", config.Ignition.Version)
+	}
+
+	return nil
+}
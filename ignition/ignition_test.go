@@ -0,0 +1,44 @@
+package ignition
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		name      string
+		data      string
+		expectErr string
+	}{
+		{name: "v3 config", data: `{"ignition": {"version": "3.4.0"}, "systemd": {"units": []}}`},
+		{name: "not json", data: "#cloud-config\n", expectErr: "parsing ignition config"},
+		{name: "no version", data: `{"passwd": {}}`, expectErr: "doesn't have a version"},
+		{name: "v2 config", data: `{"ignition": {"version": "2.3.0"}}`, expectErr: "isn't supported"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Validate([]byte(tc.data))
+			switch {
+			case tc.expectErr == "" && err != nil:
+				t.Errorf("unexpected error: %s", err)
+			case tc.expectErr != "" && (err == nil || !strings.Contains(err.Error(), tc.expectErr)):
+				t.Errorf("expected error containing %q, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestDataURL(t *testing.T) {
+	url := DataURL([]byte("hello\n"))
+	encoded, ok := strings.CutPrefix(url, "data:;base64,")
+	if !ok {
+		t.Fatalf("expected a base64 data url, got %s", url)
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || string(data) != "hello\n" {
+		t.Errorf("expected the content, got %q: %v", data, err)
+	}
+}
@@ -0,0 +1,121 @@
+// Package imds builds the metadata of a vm in the layout of the EC2 instance
+// metadata service, for the cloud-init Ec2 datasource and tools like the AWS
+// SDKs.
+package imds
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/mikrolite/mikrolite/cloudinit"
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+const (
+	// PublicKeyKey is the metadata key name to use for the ssh public key.
+	PublicKeyKey = "public-key"
+	// TokenPath is the path that IMDSv2 session tokens are requested from.
+	TokenPath = "/latest/api/token"
+	// TokenHeader is the header that has the session token.
+	TokenHeader = "X-aws-ec2-metadata-token"
+	// TokenTTLHeader is the header that has the lifetime of the session token
+	// in seconds.
+	TokenTTLHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+	// MaxTokenTTL is the longest lifetime of a session token in seconds.
+	MaxTokenTTL = 21600
+)
+
+// Versions are the metadata versions that are served. The cloud-init Ec2
+// datasource needs 2009-04-04, everything else uses latest.
+var Versions = []string{"latest", "2009-04-04"}
+
+// Tree is the metadata of a vm keyed by the path segments. The values are
+// either strings or trees.
+type Tree map[string]interface{}
+
+// New builds the metadata tree of the vm for each version. The local address
+// is only included once it's known.
+func New(vm *domain.VM) (Tree, error) {
+	metaData := Tree{
+		"instance-id":    vm.Name,
+		"hostname":       vm.Name,
+		"local-hostname": vm.Name,
+	}
+
+	if name, ok := vm.Spec.NetworkConfiguration.PrimaryInterface(); ok {
+		netInt := vm.Spec.NetworkConfiguration.Interfaces[name]
+		if status, ok := vm.Status.NetworkStatus[name]; ok {
+			metaData["mac"] = status.GuestMAC
+		}
+		address := vm.Status.IP
+		if netInt.StaticIPv4Address != nil {
+			ip, _, err := net.ParseCIDR(netInt.StaticIPv4Address.Address)
+			if err != nil {
+				return nil, fmt.Errorf("parsing address %s: %w", netInt.StaticIPv4Address.Address, err)
+			}
+			address = ip.String()
+		}
+		if address != "" {
+			metaData["local-ipv4"] = address
+		}
+	}
+
+	version := Tree{"meta-data": metaData}
+	if key, ok := vm.Status.Metadata[PublicKeyKey]; ok {
+		data, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("decoding public key: %w", err)
+		}
+		metaData["public-keys"] = Tree{"0": Tree{"openssh-key": string(data)}}
+	}
+	if userData, ok := vm.Status.Metadata[cloudinit.UserdataKey]; ok {
+		data, err := base64.StdEncoding.DecodeString(userData)
+		if err != nil {
+			return nil, fmt.Errorf("decoding user-data: %w", err)
+		}
+		version["user-data"] = string(data)
+	}
+
+	tree := Tree{}
+	for _, name := range Versions {
+		tree[name] = version
+	}
+
+	return tree, nil
+}
+
+// Get returns the value at the path. A tree is listed like EC2 does, one key
+// per line with a trailing / for trees.
+func (t Tree) Get(path string) (string, bool) {
+	var value interface{} = t
+	for _, segment := range strings.Split(strings.Trim(path, "/"), "/") {
+		if segment == "" {
+			continue
+		}
+		tree, ok := value.(Tree)
+		if !ok {
+			return "", false
+		}
+		if value, ok = tree[segment]; !ok {
+			return "", false
+		}
+	}
+
+	tree, ok := value.(Tree)
+	if !ok {
+		return value.(string), true
+	}
+	keys := make([]string, 0, len(tree))
+	for key, value := range tree {
+		if _, ok := value.(Tree); ok {
+			key += "/"
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return strings.Join(keys, "\n"), true
+}
@@ -0,0 +1,98 @@
+package imds
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/mikrolite/mikrolite/cloudinit"
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+func testVM() *domain.VM {
+	return &domain.VM{
+		Name: "vm1",
+		Spec: domain.VMSpec{
+			NetworkConfiguration: domain.NetworkConfiguration{
+				Interfaces: map[string]domain.NetwortInterface{
+					"eth0": {GuestDeviceName: "eth0", AttachToBridge: true},
+					"eth1": {
+						GuestDeviceName:       "eth1",
+						AllowMetadataRequests: true,
+						StaticIPv4Address:     &domain.StaticIPv4Address{Address: "169.254.169.200/16"},
+					},
+				},
+			},
+		},
+		Status: &domain.VMStatus{
+			IP: "192.168.122.10",
+			NetworkStatus: map[string]domain.NetworkStatus{
+				"eth0": {GuestMAC: "02:00:00:00:00:01"},
+				"eth1": {GuestMAC: "02:00:00:00:00:02"},
+			},
+			Metadata: map[string]string{
+				cloudinit.UserdataKey: base64.StdEncoding.EncodeToString([]byte("#cloud-config\n")),
+				PublicKeyKey:          base64.StdEncoding.EncodeToString([]byte("ssh-ed25519 AAAA user@host")),
+			},
+		},
+	}
+}
+
+func TestNew(t *testing.T) {
+	tree, err := New(testVM())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	testCases := []struct {
+		path   string
+		expect string
+	}{
+		{path: "/", expect: "2009-04-04/\nlatest/"},
+		{path: "/latest/", expect: "meta-data/\nuser-data"},
+		{path: "/latest/meta-data/", expect: "hostname\ninstance-id\nlocal-hostname\nlocal-ipv4\nmac\npublic-keys/"},
+		{path: "/latest/meta-data/instance-id", expect: "vm1"},
+		{path: "/latest/meta-data/local-ipv4", expect: "192.168.122.10"},
+		{path: "/latest/meta-data/mac", expect: "02:00:00:00:00:01"},
+		{path: "/latest/meta-data/public-keys/0/openssh-key", expect: "ssh-ed25519 AAAA user@host"},
+		{path: "/2009-04-04/meta-data/instance-id", expect: "vm1"},
+		{path: "/latest/user-data", expect: "#cloud-config\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			value, ok := tree.Get(tc.path)
+			if !ok {
+				t.Fatalf("expected %s to exist", tc.path)
+			}
+			if value != tc.expect {
+				t.Errorf("expected %q, got %q", tc.expect, value)
+			}
+		})
+	}
+
+	for _, path := range []string{"/latest/meta-data/ami-id", "/latest/user-data/extra", "/2021-03-23/meta-data/"} {
+		if value, ok := tree.Get(path); ok {
+			t.Errorf("expected %s not to exist, got %q", path, value)
+		}
+	}
+}
+
+func TestNewStaticAddress(t *testing.T) {
+	vm := testVM()
+	vm.Status.IP = ""
+	vm.Status.Metadata = nil
+	eth0 := vm.Spec.NetworkConfiguration.Interfaces["eth0"]
+	eth0.StaticIPv4Address = &domain.StaticIPv4Address{Address: "10.0.0.5/24"}
+	vm.Spec.NetworkConfiguration.Interfaces["eth0"] = eth0
+
+	tree, err := New(vm)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if value, _ := tree.Get("/latest/meta-data/local-ipv4"); value != "10.0.0.5" {
+		t.Errorf("expected the static address, got %q", value)
+	}
+	if value, _ := tree.Get("/latest/"); value != "meta-data/" {
+		t.Errorf("expected no user-data, got %q", value)
+	}
+}
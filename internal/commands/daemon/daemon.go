@@ -0,0 +1,66 @@
+package daemon
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+
+	"github.com/mikrolite/mikrolite/adapters/logs"
+	"github.com/mikrolite/mikrolite/defaults"
+	"github.com/mikrolite/mikrolite/internal/daemon"
+	"github.com/mikrolite/mikrolite/internal/factory"
+)
+
+func NewDaemonCommand() *cobra.Command {
+	return NewDaemonCommandWithDependencies(factory.DefaultDependencies())
+}
+
+// NewDaemonCommandWithDependencies creates the daemon command using the supplied dependencies.
+func NewDaemonCommandWithDependencies(deps factory.Dependencies) *cobra.Command {
+	cfg := daemon.Config{}
+	debug := false
+
+	cmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Run mikrolited, which serves the mikrolite api on a unix socket",
+		PreRun: func(cmd *cobra.Command, args []string) {
+			loggerOpts := &slog.HandlerOptions{
+				Level: slog.LevelInfo,
+			}
+			if debug {
+				loggerOpts.Level = slog.LevelDebug
+			}
+			// The vm logs are also written to the state directory of the vm
+			logger := slog.New(logs.NewHandler(slog.NewTextHandler(os.Stdout, loggerOpts), cfg.StateRootPath))
+			slog.SetDefault(logger)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
+
+			server, err := daemon.New(cfg, deps)
+			if err != nil {
+				return fmt.Errorf("creating daemon: %w", err)
+			}
+
+			pterm.DefaultSpinner.Info(fmt.Sprintf("ℹ️  Starting mikrolited on %s\n", cfg.ListenPath))
+
+			return server.Serve(ctx)
+		},
+	}
+
+	cfg.BindFlags(cmd.Flags())
+	cmd.Flags().StringVar(&cfg.ListenPath, "listen", defaults.DaemonSocketPath, "the path of the unix socket to serve the api on")
+	cmd.Flags().DurationVar(&cfg.SuperviseInterval, "supervise-interval", defaults.SuperviseInterval, "how often to check the vm processes and apply the restart policies, 0 disables supervision")
+	cmd.Flags().DurationVar(&cfg.GCInterval, "gc-interval", defaults.GCInterval, "how often to remove orphaned resources, stopped vms are kept. 0 disables automatic removal")
+	cmd.Flags().StringVar(&cfg.MetadataAddress, "metadata-address", defaults.MetadataListenAddress, "the address to serve the metadata of the vms on with --host-metadata")
+	cmd.Flags().StringVar(&cfg.FlintlockAddress, "flintlock-address", "", "the tcp address to serve the insecure flintlock compatible api on, e.g. :9090. Disabled if empty")
+	cmd.Flags().BoolVar(&debug, "debug", false, "enable debug features")
+
+	return cmd
+}
@@ -0,0 +1,142 @@
+package gc
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+
+	"github.com/mikrolite/mikrolite/core/ports"
+	"github.com/mikrolite/mikrolite/defaults"
+	"github.com/mikrolite/mikrolite/internal/daemon"
+	"github.com/mikrolite/mikrolite/internal/factory"
+)
+
+func NewGCCommand() *cobra.Command {
+	return NewGCCommandWithDependencies(factory.DefaultDependencies())
+}
+
+// NewGCCommandWithDependencies creates the gc command using the supplied dependencies.
+func NewGCCommandWithDependencies(deps factory.Dependencies) *cobra.Command {
+	cfg := &config{
+		deps: deps,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Find and remove resources that aren't used by any vm",
+		Long: `Find and remove resources that aren't used by any vm.
+
+A failed create or a host crash can leave behind containerd leases and
+snapshots, tap devices and state directories. These are found by checking them
+against the vm state and the vm processes, and listed. They are only removed
+with --force.
+
+Vms whose process isn't running are only included with --include-stopped, vms
+that start on boot are never included.`,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			loggerOpts := &slog.HandlerOptions{
+				Level: slog.LevelInfo,
+			}
+			if cfg.Debug {
+				loggerOpts.Level = slog.LevelDebug
+			}
+			logger := slog.New(slog.NewTextHandler(os.Stdout, loggerOpts))
+			slog.SetDefault(logger)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			a, err := newApp(cfg)
+			if err != nil {
+				pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error %s\n", err))
+				return
+			}
+
+			output, err := a.GC(cmd.Context(), cfg.input)
+			if err != nil {
+				pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error finding orphaned resources: %s\n", err))
+				return
+			}
+
+			if len(output.Orphans) == 0 {
+				pterm.DefaultSpinner.Success("✅ No orphaned resources found\n")
+				return
+			}
+
+			orphanPrintData := [][]string{
+				{"Type", "Name", "Reason", "Action"},
+			}
+			for _, orphan := range output.Orphans {
+				orphanPrintData = append(orphanPrintData, []string{string(orphan.Type), orphan.Name, orphan.Reason, action(orphan)})
+			}
+
+			table := pterm.DefaultTable
+			table.HasHeader = true
+
+			table.WithData(orphanPrintData).Render()
+
+			if !cfg.input.Force {
+				pterm.DefaultSpinner.Info("ℹ️  Run with --force to remove the orphaned resources\n")
+			}
+		},
+	}
+
+	cfg.BindFlags(cmd.Flags())
+	cmd.Flags().BoolVar(&cfg.input.Force, "force", false, "remove the orphaned resources, otherwise they are only listed")
+	cmd.Flags().BoolVar(&cfg.input.IncludeStopped, "include-stopped", false, "include the vms whose process isn't running")
+	cmd.Flags().BoolVar(&cfg.Debug, "debug", false, "enable debug features")
+	cmd.Flags().StringVar(&cfg.DaemonSocket, "daemon-socket", defaults.DaemonSocketPath, "the path to the mikrolited socket, used if a daemon is running")
+	cmd.Flags().BoolVar(&cfg.Direct, "direct", false, "don't use mikrolited even if its running")
+
+	return cmd
+}
+
+type config struct {
+	factory.Config
+
+	Debug        bool
+	DaemonSocket string
+	Direct       bool
+
+	input ports.GCInput
+	deps  factory.Dependencies
+}
+
+// action describes what was done with the orphan.
+func action(orphan ports.Orphan) string {
+	switch {
+	case orphan.Removed:
+		return "removed"
+	case orphan.Error != "":
+		return fmt.Sprintf("failed: %s", orphan.Error)
+	default:
+		return "none (dry run)"
+	}
+}
+
+// newApp returns the gc use cases. If mikrolited is running then its used, so
+// that gc doesn't race with the operations in the daemon.
+func newApp(cfg *config) (ports.GCUseCases, error) {
+	if !cfg.Direct && daemon.Available(cfg.DaemonSocket) {
+		client, err := daemon.Dial(cfg.DaemonSocket)
+		if err == nil {
+			slog.Debug("using mikrolited", "socket", cfg.DaemonSocket)
+
+			return client, nil
+		}
+		slog.Debug(fmt.Sprintf("falling back to direct mode: %s", err))
+	}
+
+	netSvc := cfg.deps.NewNetworkService()
+	imageSvc, err := cfg.deps.NewImageService(cfg.SocketPath)
+	if err != nil {
+		return nil, err
+	}
+	autostartSvc, err := cfg.deps.NewAutostartService(cfg.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	return factory.NewApp(cfg.Config, imageSvc, netSvc, autostartSvc, "")
+}
@@ -0,0 +1,66 @@
+package metadata
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+
+	"github.com/mikrolite/mikrolite/adapters/metadata"
+	"github.com/mikrolite/mikrolite/defaults"
+	"github.com/mikrolite/mikrolite/internal/factory"
+)
+
+func NewMetadataServerCommand() *cobra.Command {
+	return NewMetadataServerCommandWithDependencies(factory.DefaultDependencies())
+}
+
+// NewMetadataServerCommandWithDependencies creates the metadata-server command
+// using the supplied dependencies.
+func NewMetadataServerCommandWithDependencies(deps factory.Dependencies) *cobra.Command {
+	cfg := factory.Config{}
+	address := ""
+	debug := false
+
+	cmd := &cobra.Command{
+		Use:   "metadata-server",
+		Short: "Serve the metadata of the vms from the host for providers without a metadata service",
+		Long: `Serve the metadata of the vms from the host for providers without a metadata service.
+
+Vms created with --host-metadata get their metadata from this server, in the
+same way that firecracker vms get it from the MMDS. mikrolited serves it when
+it's run with --host-metadata, this is for running vms in direct mode.`,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			loggerOpts := &slog.HandlerOptions{
+				Level: slog.LevelInfo,
+			}
+			if debug {
+				loggerOpts.Level = slog.LevelDebug
+			}
+			slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, loggerOpts)))
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
+
+			listener, err := metadata.Listen(ctx, address)
+			if err != nil {
+				return err
+			}
+
+			pterm.DefaultSpinner.Info(fmt.Sprintf("ℹ️  Serving metadata on %s\n", address))
+
+			return factory.NewMetadataServer(cfg, deps.NewNetworkService()).Serve(ctx, listener)
+		},
+	}
+
+	cfg.BindFlags(cmd.Flags())
+	cmd.Flags().StringVar(&address, "listen", defaults.MetadataListenAddress, "the address to serve the metadata on")
+	cmd.Flags().BoolVar(&debug, "debug", false, "enable debug features")
+
+	return cmd
+}
@@ -0,0 +1,90 @@
+package provider
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/mikrolite/mikrolite/adapters/vm"
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+func newInfoCommand(cfg *commonConfig) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "info [name]",
+		Short: "Show the capabilities of the vm providers",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			names := vm.ProviderNames(cfg.PluginPaths)
+			if len(args) == 1 {
+				names = args
+			}
+
+			header := []string{"Capability"}
+			capabilities := []ports.Capabilities{}
+			for _, name := range names {
+				provider, err := vm.New(name, vm.VMProviderProps{
+					Fs:                 afero.NewOsFs(),
+					FirecrackerBin:     cfg.FirecrackerBin,
+					CloudHypervisorBin: cfg.CloudHypervisorBin,
+					QemuBin:            cfg.QemuBin,
+					PluginPaths:        cfg.PluginPaths,
+				})
+				if err != nil {
+					pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error creating vm provider %s: %s\n", name, err))
+					return
+				}
+
+				header = append(header, name)
+				capabilities = append(capabilities, provider.Capabilities())
+			}
+
+			rows := []struct {
+				name  string
+				value func(c ports.Capabilities) string
+			}{
+				{"Metadata service", func(c ports.Capabilities) string { return strconv.FormatBool(c.MetadataService) }},
+				{"Snapshot", func(c ports.Capabilities) string { return strconv.FormatBool(c.Snapshot) }},
+				{"Pause", func(c ports.Capabilities) string { return strconv.FormatBool(c.Pause) }},
+				{"Hotplug disk", func(c ports.Capabilities) string { return strconv.FormatBool(c.HotplugDisk) }},
+				{"Hotplug network", func(c ports.Capabilities) string { return strconv.FormatBool(c.HotplugNetwork) }},
+				{"Hotplug cpu", func(c ports.Capabilities) string { return strconv.FormatBool(c.HotplugCPU) }},
+				{"Hotplug memory", func(c ports.Capabilities) string { return strconv.FormatBool(c.HotplugMemory) }},
+				{"Vsock", func(c ports.Capabilities) string { return strconv.FormatBool(c.Vsock) }},
+				{"Virtio-fs", func(c ports.Capabilities) string { return strconv.FormatBool(c.VirtioFS) }},
+				{"Balloon", func(c ports.Capabilities) string { return strconv.FormatBool(c.Balloon) }},
+				{"Disk features", func(c ports.Capabilities) string { return joinFeatures(c.DiskFeatures) }},
+				{"Network features", func(c ports.Capabilities) string { return joinFeatures(c.NetworkFeatures) }},
+			}
+
+			printData := [][]string{header}
+			for _, row := range rows {
+				line := []string{row.name}
+				for _, c := range capabilities {
+					line = append(line, row.value(c))
+				}
+				printData = append(printData, line)
+			}
+
+			table := pterm.DefaultTable
+			table.HasHeader = true
+
+			table.WithData(printData).Render()
+		},
+	}
+
+	return cmd
+}
+
+func joinFeatures[T ~string](features []T) string {
+	values := make([]string, 0, len(features))
+	for _, f := range features {
+		values = append(values, string(f))
+	}
+
+	return strings.Join(values, ", ")
+}
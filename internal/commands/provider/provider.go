@@ -0,0 +1,35 @@
+package provider
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/mikrolite/mikrolite/defaults"
+)
+
+func NewProviderCommand() *cobra.Command {
+	cfg := &commonConfig{}
+
+	cmd := &cobra.Command{
+		Use:   "provider",
+		Short: "Get information about the vm providers",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
+		},
+	}
+
+	cmd.PersistentFlags().StringVar(&cfg.FirecrackerBin, "firecracker-bin", "firecracker", "the path to the firecracker binary to use")
+	cmd.PersistentFlags().StringVar(&cfg.CloudHypervisorBin, "cloudhypervisor-bin", "cloud-hypervisor-static", "the path to the cloud-hypervisor binary to use")
+	cmd.PersistentFlags().StringVar(&cfg.QemuBin, "qemu-bin", "qemu-system-x86_64", "the path to the qemu binary to use")
+	cmd.PersistentFlags().StringSliceVar(&cfg.PluginPaths, "plugin-path", []string{defaults.PluginPath}, "the directories to search for provider plugins")
+
+	cmd.AddCommand(newInfoCommand(cfg))
+
+	return cmd
+}
+
+type commonConfig struct {
+	FirecrackerBin     string
+	CloudHypervisorBin string
+	QemuBin            string
+	PluginPaths        []string
+}
@@ -3,6 +3,10 @@ package commands
 import (
 	"fmt"
 
+	"github.com/mikrolite/mikrolite/internal/commands/daemon"
+	"github.com/mikrolite/mikrolite/internal/commands/gc"
+	"github.com/mikrolite/mikrolite/internal/commands/metadata"
+	"github.com/mikrolite/mikrolite/internal/commands/provider"
 	"github.com/mikrolite/mikrolite/internal/commands/vm"
 	"github.com/pterm/pterm"
 	"github.com/pterm/pterm/putils"
@@ -25,6 +29,10 @@ func NewRoot() *cobra.Command {
 	}
 
 	cmd.AddCommand(vm.NewVMCommand())
+	cmd.AddCommand(provider.NewProviderCommand())
+	cmd.AddCommand(daemon.NewDaemonCommand())
+	cmd.AddCommand(gc.NewGCCommand())
+	cmd.AddCommand(metadata.NewMetadataServerCommand())
 
 	return cmd
 }
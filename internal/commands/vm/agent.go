@@ -0,0 +1,29 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mikrolite/mikrolite/adapters/vsock"
+	"github.com/mikrolite/mikrolite/agent"
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+// newAgentClient creates a client for the guest agent in the running vm.
+func newAgentClient(cmd *cobra.Command, cfg *commonConfig, vmName string) (*agent.Client, error) {
+	vm, err := getRunningVM(cmd, cfg, vmName)
+	if err != nil {
+		return nil, err
+	}
+	if !usesAgent(vm) {
+		return nil, fmt.Errorf("vm %s wasn't created with --guest-agent", vmName)
+	}
+
+	return vsock.NewClient(vm.Status.VSock), nil
+}
+
+// usesAgent returns true if the guest agent can be used to talk to the vm.
+func usesAgent(vm *domain.VM) bool {
+	return vm.Spec.GuestAgent && vm.Status != nil && vm.Status.VSock != nil
+}
@@ -0,0 +1,62 @@
+package vm
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/mikrolite/mikrolite/core/app"
+	"github.com/mikrolite/mikrolite/core/ports"
+	"github.com/mikrolite/mikrolite/internal/daemon"
+	"github.com/mikrolite/mikrolite/internal/factory"
+)
+
+// newApp returns the use cases for the named vm. If mikrolited is running then
+// the use cases are called via the daemon, otherwise the services are wired up
+// and the app is run directly.
+func newApp(cfg *commonConfig, vmName string) (ports.VMUseCases, error) {
+	if err := app.ValidateName(vmName); err != nil {
+		return nil, err
+	}
+	if client, ok := daemonClient(cfg); ok {
+		return client, nil
+	}
+
+	return newDirectApp(cfg, vmName)
+}
+
+// newDirectApp wires up the services for the named vm and returns the app,
+// mikrolited isn't used even if its running.
+func newDirectApp(cfg *commonConfig, vmName string) (app.App, error) {
+	if err := app.ValidateName(vmName); err != nil {
+		return nil, err
+	}
+
+	netSvc := cfg.deps.NewNetworkService()
+	imageSvc, err := cfg.deps.NewImageService(cfg.SocketPath)
+	if err != nil {
+		return nil, err
+	}
+	autostartSvc, err := cfg.deps.NewAutostartService(cfg.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	return factory.NewApp(cfg.Config, imageSvc, netSvc, autostartSvc, vmName)
+}
+
+// daemonClient returns a client for mikrolited if its running and direct mode
+// hasn't been requested.
+func daemonClient(cfg *commonConfig) (*daemon.Client, bool) {
+	if cfg.Direct || !daemon.Available(cfg.DaemonSocket) {
+		return nil, false
+	}
+
+	client, err := daemon.Dial(cfg.DaemonSocket)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("falling back to direct mode: %s", err))
+		return nil, false
+	}
+	slog.Debug("using mikrolited", "socket", cfg.DaemonSocket)
+
+	return client, true
+}
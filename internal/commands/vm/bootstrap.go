@@ -0,0 +1,121 @@
+package vm
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+// bootstrapFlags are the create flags that configure how the vm is
+// bootstrapped.
+type bootstrapFlags struct {
+	Format         string
+	Datasource     string
+	ConfigDrive    string
+	SSHKeyFile     string
+	UserDataFile   string
+	VendorDataFile string
+	Files          []string
+	Units          []string
+}
+
+// newBootstrap creates the bootstrap config from the create flags, reading
+// the files so that the config can be sent to mikrolited. Its nil if none of
+// the flags are set.
+func newBootstrap(flags bootstrapFlags) (*domain.Bootstrap, error) {
+	if flags.Format == string(domain.BootstrapFormatCloudInit) {
+		flags.Format = ""
+	}
+	if flags.Datasource == string(domain.DatasourceNoCloud) {
+		flags.Datasource = ""
+	}
+	if flags.Format == "" && flags.Datasource == "" && flags.ConfigDrive == "" && flags.SSHKeyFile == "" && flags.UserDataFile == "" && flags.VendorDataFile == "" && len(flags.Files) == 0 && len(flags.Units) == 0 {
+		return nil, nil
+	}
+
+	bootstrap := &domain.Bootstrap{
+		Format:      domain.BootstrapFormat(flags.Format),
+		Datasource:  domain.Datasource(flags.Datasource),
+		ConfigDrive: domain.ConfigDrive(flags.ConfigDrive),
+		SSHKey:      flags.SSHKeyFile,
+	}
+
+	var err error
+	if flags.UserDataFile != "" {
+		if bootstrap.UserData, err = readBase64(flags.UserDataFile); err != nil {
+			return nil, fmt.Errorf("reading user-data: %w", err)
+		}
+	}
+	if flags.VendorDataFile != "" {
+		if bootstrap.VendorData, err = readBase64(flags.VendorDataFile); err != nil {
+			return nil, fmt.Errorf("reading vendor-data: %w", err)
+		}
+	}
+
+	for _, value := range flags.Files {
+		file, err := parseFileFlag(value)
+		if err != nil {
+			return nil, err
+		}
+		bootstrap.Files = append(bootstrap.Files, *file)
+	}
+
+	for _, path := range flags.Units {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading unit: %w", err)
+		}
+		bootstrap.Units = append(bootstrap.Units, domain.SystemdUnit{
+			Name:     filepath.Base(path),
+			Contents: string(contents),
+		})
+	}
+
+	return bootstrap, nil
+}
+
+// parseFileFlag parses a file to write to the vm, as src:dest[:mode], and reads
+// the source file.
+func parseFileFlag(value string) (*domain.BootstrapFile, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("file %q must be src:dest[:mode]", value)
+	}
+
+	info, err := os.Stat(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("reading file %s: %w", parts[0], err)
+	}
+	mode := fmt.Sprintf("%04o", info.Mode().Perm())
+	if len(parts) == 3 {
+		if _, err := strconv.ParseUint(parts[2], 8, 32); err != nil {
+			return nil, fmt.Errorf("file %q mode %s isn't octal", value, parts[2])
+		}
+		mode = parts[2]
+	}
+
+	content, err := readBase64(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("reading file %s: %w", parts[0], err)
+	}
+
+	return &domain.BootstrapFile{
+		Path:        parts[1],
+		Content:     content,
+		Permissions: mode,
+	}, nil
+}
+
+func readBase64(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(data), nil
+}
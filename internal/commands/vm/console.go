@@ -0,0 +1,76 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/mikrolite/mikrolite/adapters/console"
+)
+
+func newConsoleVMCommand(cfg *commonConfig) *cobra.Command {
+	detachKeys := console.DefaultDetachKeys
+
+	cmd := &cobra.Command{
+		Use:   "console [name]",
+		Short: "Attach to the serial console of a vm",
+		Long: `Attach to the serial console of a vm, e.g. to log in to a vm whose network
+isn't working.
+
+Press ctrl-] to detach from the console, the vm keeps running. The console
+output is also appended to vm.stdout in the state directory of the vm.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			vmName := args[0]
+			cmd.SilenceUsage = true
+
+			keys, err := console.ParseDetachKeys(detachKeys)
+			if err != nil {
+				return err
+			}
+
+			a, err := newApp(cfg, vmName)
+			if err != nil {
+				return err
+			}
+
+			vm, err := a.GetVM(cmd.Context(), vmName)
+			if err != nil {
+				return fmt.Errorf("getting vm %s: %w", vmName, err)
+			}
+			if vm.Status == nil || vm.Status.ConsoleSocket == "" {
+				return fmt.Errorf("vm %s doesn't have a console, its provider doesn't support it or it hasn't been started since consoles were added", vmName)
+			}
+
+			in := cmd.InOrStdin()
+			if file, ok := in.(*os.File); ok && term.IsTerminal(int(file.Fd())) {
+				state, err := term.MakeRaw(int(file.Fd()))
+				if err != nil {
+					return fmt.Errorf("setting terminal to raw mode: %w", err)
+				}
+				defer term.Restore(int(file.Fd()), state)
+			}
+
+			fmt.Fprintf(cmd.ErrOrStderr(), "Connected to the console of %s, press %s to detach\r\n", vmName, detachKeys)
+
+			if err := console.Attach(cmd.Context(), vm.Status.ConsoleSocket, in, cmd.OutOrStdout(), keys); err != nil {
+				if errors.Is(err, console.ErrNotAvailable) {
+					return fmt.Errorf("attaching to vm %s, is it running? %w", vmName, err)
+				}
+
+				return fmt.Errorf("attaching to vm %s: %w", vmName, err)
+			}
+
+			fmt.Fprintf(cmd.ErrOrStderr(), "\r\nDetached from the console of %s\r\n", vmName)
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&detachKeys, "detach-keys", console.DefaultDetachKeys, "the key sequence to detach from the console, e.g. ctrl-p,ctrl-q")
+
+	return cmd
+}
@@ -0,0 +1,122 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newCopyVMCommand(cfg *commonConfig) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cp [src] [dst]",
+		Short: "Copy a file to or from a vm",
+		Long: `Copy a file to or from a vm using the guest agent, the vm must have been created
+with --guest-agent. The path in the vm is given as name:/path, e.g.
+
+  mikrolite vm cp ./app.conf vm1:/etc/app.conf
+  mikrolite vm cp vm1:/var/log/app.log .
+
+The mode of the file is kept. If the destination is a directory the file is
+copied into it.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			srcVM, srcPath := parseCopyPath(args[0])
+			dstVM, dstPath := parseCopyPath(args[1])
+
+			switch {
+			case srcVM != "" && dstVM != "":
+				return errors.New("copying between vms isn't supported")
+			case srcVM != "":
+				return download(cmd, cfg, srcVM, srcPath, dstPath)
+			case dstVM != "":
+				return upload(cmd, cfg, srcPath, dstVM, dstPath)
+			default:
+				return errors.New("either the source or the destination must be in a vm, e.g. vm1:/path")
+			}
+		},
+	}
+
+	return cmd
+}
+
+// parseCopyPath splits name:/path into the vm name and the path. The vm name is
+// empty for a local path, a local path containing a colon can be given as ./path.
+func parseCopyPath(arg string) (string, string) {
+	name, path, found := strings.Cut(arg, ":")
+	if !found || name == "" || strings.Contains(name, "/") {
+		return "", arg
+	}
+
+	return name, path
+}
+
+func upload(cmd *cobra.Command, cfg *commonConfig, src string, vmName string, dst string) error {
+	client, err := newAgentClient(cmd, cfg, vmName)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s isn't a regular file", src)
+	}
+
+	// A destination ending in / is a directory in the vm
+	if strings.HasSuffix(dst, "/") {
+		dst += filepath.Base(src)
+	}
+
+	if err := client.Upload(cmd.Context(), dst, info.Mode(), file); err != nil {
+		return fmt.Errorf("copying %s to %s:%s: %w", src, vmName, dst, err)
+	}
+
+	return nil
+}
+
+func download(cmd *cobra.Command, cfg *commonConfig, vmName string, src string, dst string) error {
+	client, err := newAgentClient(cmd, cfg, vmName)
+	if err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(dst); err == nil && info.IsDir() {
+		dst = filepath.Join(dst, filepath.Base(src))
+	}
+
+	// The file is written to a temporary file so that the destination isn't
+	// left half written if the copy fails
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	mode, err := client.Download(cmd.Context(), src, tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("copying %s:%s to %s: %w", vmName, src, dst, err)
+	}
+
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dst)
+}
@@ -3,17 +3,11 @@ package vm
 import (
 	"errors"
 	"fmt"
+	"time"
 
-	ctr "github.com/containerd/containerd"
 	"github.com/pterm/pterm"
-	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 
-	"github.com/mikrolite/mikrolite/adapters/containerd"
-	"github.com/mikrolite/mikrolite/adapters/filesystem"
-	"github.com/mikrolite/mikrolite/adapters/godisk"
-	"github.com/mikrolite/mikrolite/adapters/netlink"
-	"github.com/mikrolite/mikrolite/adapters/vm"
 	"github.com/mikrolite/mikrolite/core/app"
 	"github.com/mikrolite/mikrolite/core/domain"
 	"github.com/mikrolite/mikrolite/core/ports"
@@ -29,10 +23,20 @@ func newCreateCommandVM(cfg *commonConfig) *cobra.Command {
 		KernelVolumeImage string
 		KernelFilename    string
 		KernelHostPath    string
+		KernelCmdLine     string
+		InitrdImage       string
+		InitrdHostPath    string
+		InitrdFilename    string
 		BridgeName        string
-		StaticIP          string
-		StaticGatewayIP   string
-		SSHKeyFile        string
+		Network           networkFlags
+		VolumeSlots       int
+		RestartPolicy     string
+		RestartMaxRetries int
+		RestartBackoff    int
+		GuestAgent        bool
+		WaitFor           string
+		WaitTimeout       time.Duration
+		Bootstrap         bootstrapFlags
 	}{}
 
 	cmd := &cobra.Command{
@@ -44,8 +48,9 @@ func newCreateCommandVM(cfg *commonConfig) *cobra.Command {
 			pterm.DefaultSpinner.Info(fmt.Sprintf("🚀 Creating VM: %s\n", input.Name))
 
 			spec := &domain.VMSpec{
-				VCPU:       input.VCPU,
-				MemoryInMb: input.MemoryInMb,
+				VCPU:        input.VCPU,
+				MemoryInMb:  input.MemoryInMb,
+				VolumeSlots: input.VolumeSlots,
 				Kernel: domain.Kernel{
 					Source: domain.KernelSource{
 						Filename: input.KernelFilename,
@@ -60,8 +65,9 @@ func newCreateCommandVM(cfg *commonConfig) *cobra.Command {
 					},
 				},
 				NetworkConfiguration: domain.NetworkConfiguration{
-					BridgeName: input.BridgeName,
-					Interfaces: map[string]domain.NetwortInterface{},
+					BridgeName:   input.BridgeName,
+					Interfaces:   map[string]domain.NetwortInterface{},
+					ConfigFormat: domain.NetworkConfigFormat(input.Network.ConfigFormat),
 				},
 			}
 			if input.KernelVolumeImage != "" {
@@ -74,62 +80,59 @@ func newCreateCommandVM(cfg *commonConfig) *cobra.Command {
 					Path: input.KernelHostPath,
 				}
 			}
-			netInt := domain.NetwortInterface{
-				GuestDeviceName:       "eth0",
-				AllowMetadataRequests: false,
-				AttachToBridge:        true,
-			}
-
-			if input.StaticIP != "" {
-				netInt.StaticIPv4Address = &domain.StaticIPv4Address{
-					Address: input.StaticIP,
-				}
-
-				if input.StaticGatewayIP != "" {
-					netInt.StaticIPv4Address.Gateway = &input.StaticGatewayIP
+			if input.KernelCmdLine != "" {
+				cmdLine, err := domain.ParseKernelCmdLine(input.KernelCmdLine)
+				if err != nil {
+					pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error %s\n", err))
+					return
 				}
+				spec.Kernel.CmdLine = cmdLine
 			}
-			spec.NetworkConfiguration.Interfaces["eth0"] = netInt
-
-			if input.SSHKeyFile != "" {
-				spec.Bootstrap = &domain.Bootstrap{
-					SSHKey: input.SSHKeyFile,
+			if input.InitrdImage != "" || input.InitrdHostPath != "" {
+				spec.Kernel.Initrd = &domain.KernelSource{Filename: input.InitrdFilename}
+				if input.InitrdImage != "" {
+					spec.Kernel.Initrd.Container = &domain.ContainerKernelSource{Image: input.InitrdImage}
+				} else {
+					spec.Kernel.Initrd.HostPath = &domain.HostPathKernelSource{Path: input.InitrdHostPath}
 				}
 			}
-
-			//TODO: move this to dependency injection
-			fsSvc := afero.NewOsFs()
-			stateSvc, err := filesystem.NewStateService(input.Name, cfg.StateRootPath, fsSvc)
+			netInt, err := newNetworkInterface(input.Network)
 			if err != nil {
-				pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error creating state service: %s\n", err))
+				pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error %s\n", err))
 				return
 			}
-			diskSvc := godisk.New(fsSvc)
-			netSvc := netlink.New()
-			client, err := ctr.New(cfg.SocketPath)
+			spec.NetworkConfiguration.Interfaces["eth0"] = netInt
+
+			bootstrap, err := newBootstrap(input.Bootstrap)
 			if err != nil {
-				pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error creating containerd client: %s\n", err))
+				pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error %s\n", err))
 				return
 			}
-			imageSvc := containerd.NewImageService(client)
-			vmSvc, err := vm.New(cfg.VMProvider, vm.VMProviderProps{
-				StateService:       stateSvc,
-				DiskSvc:            diskSvc,
-				Fs:                 fsSvc,
-				FirecrackerBin:     cfg.FirecrackerBin,
-				CloudHypervisorBin: cfg.CloudHypervisorBin,
-			})
+			spec.Bootstrap = bootstrap
+
+			if input.RestartPolicy != string(domain.RestartPolicyNo) {
+				spec.RestartPolicy = &domain.RestartPolicy{
+					Policy:         domain.RestartPolicyType(input.RestartPolicy),
+					MaxRetries:     input.RestartMaxRetries,
+					BackoffSeconds: input.RestartBackoff,
+				}
+			}
+
+			spec.GuestAgent = input.GuestAgent
+
+			a, err := newApp(cfg, input.Name)
 			if err != nil {
-				pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error creating vm provider %s: %s\n", cfg.VMProvider, err))
+				pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error %s\n", err))
 				return
 			}
 
 			owner := fmt.Sprintf("vm-%s", input.Name)
-			a := app.New(imageSvc, vmSvc, stateSvc, fsSvc, netSvc)
 			vm, err := a.CreateVM(cmd.Context(), ports.CreateVMInput{
-				Name:  input.Name,
-				Owner: owner,
-				Spec:  spec,
+				Name:        input.Name,
+				Owner:       owner,
+				Spec:        spec,
+				WaitFor:     domain.WaitFor(input.WaitFor),
+				WaitTimeout: input.WaitTimeout,
 			})
 			if err != nil {
 				switch {
@@ -142,7 +145,11 @@ func newCreateCommandVM(cfg *commonConfig) *cobra.Command {
 				}
 			}
 
-			pterm.DefaultSpinner.Success(fmt.Sprintf("✅ Succesfully created VM: %s (%s)\n", input.Name, vm.Status.IP))
+			if vm.Status.Ready {
+				pterm.DefaultSpinner.Success(fmt.Sprintf("✅ Succesfully created VM: %s (%s), ready in %s\n", input.Name, vm.Status.IP, vm.Status.Boot.TimeToReady().Round(100*time.Millisecond)))
+			} else {
+				pterm.DefaultSpinner.Success(fmt.Sprintf("✅ Succesfully created VM: %s (%s)\n", input.Name, vm.Status.IP))
+			}
 			pterm.DefaultSpinner.Stop()
 		},
 	}
@@ -154,14 +161,40 @@ func newCreateCommandVM(cfg *commonConfig) *cobra.Command {
 	cmd.Flags().StringVar(&input.KernelVolumeImage, "kernel-image", "", "The container to use for the kernel")
 	cmd.Flags().StringVar(&input.KernelHostPath, "kernel-path", "", "The path to a kernel file on the host")
 	cmd.Flags().StringVar(&input.KernelFilename, "kernel-filename", "vmlinux", "The name of the kernel file in the image or in the hostpath")
+	cmd.Flags().StringVar(&input.KernelCmdLine, "kernel-cmdline", "", "The kernel command line, the args after -- are passed to init. The defaults of the provider are used if ommitted")
+	cmd.Flags().StringVar(&input.InitrdImage, "initrd-image", "", "The container to use for the initrd")
+	cmd.Flags().StringVar(&input.InitrdHostPath, "initrd-path", "", "The path to an initrd file on the host")
+	cmd.Flags().StringVar(&input.InitrdFilename, "initrd-filename", "initrd.img", "The name of the initrd file in the image or in the hostpath")
 	cmd.Flags().StringVar(&input.BridgeName, "network-bridge", defaults.SharedBridgeName, "The name of the bridge to attach the vm to")
-	cmd.Flags().StringVar(&input.StaticIP, "static-ip", "", "A static IPV4 address (as a CIDR) to assign to the VM. If ommitted DHCP will be used")
-	cmd.Flags().StringVar(&input.StaticGatewayIP, "static-gateway-ip", "", "A gateway (as a CIDR) to use with the static IP")
-	cmd.Flags().StringVar(&input.SSHKeyFile, "ssh-key", "", "A SSH public key to use as an authorized key")
+	cmd.Flags().StringVar(&input.Network.StaticIP, "static-ip", "", "A static IPV4 address (as a CIDR) to assign to the VM. If ommitted DHCP will be used")
+	cmd.Flags().StringVar(&input.Network.StaticGatewayIP, "static-gateway-ip", "", "A gateway (as a CIDR) to use with the static IP")
+	cmd.Flags().StringArrayVar(&input.Network.Nameservers, "nameserver", nil, "A nameserver to use with the static IP. Can be repeated")
+	cmd.Flags().StringArrayVar(&input.Network.SearchDomains, "search-domain", nil, "A DNS search domain to use with the static IP. Can be repeated")
+	cmd.Flags().StringArrayVar(&input.Network.Routes, "route", nil, "A static route in the vm as to=cidr,via=ip[,metric=n][,on-link=true], to can also be default. Can be repeated")
+	cmd.Flags().IntVar(&input.Network.MTU, "mtu", 0, "The MTU of the vm interface, the default of the guest is used if ommitted")
+	cmd.Flags().StringVar(&input.Network.ConfigFormat, "network-config-format", "", "The format of the network config given to the vm: v2 (netplan), v1 or eni (/etc/network/interfaces, needs a nocloud drive). If ommitted the io.mikrolite.network-config-format label of the root image is used, or v2")
+	cmd.Flags().StringArrayVar(&input.Network.DHCPOverrides, "dhcp-override", nil, "Override what the vm uses from DHCP as key=value, the keys are use-dns, use-domains, use-routes, use-hostname and route-metric. Can be repeated")
+	cmd.Flags().StringVar(&input.Bootstrap.SSHKeyFile, "ssh-key", "", "A SSH public key to use as an authorized key")
+	cmd.Flags().IntVar(&input.VolumeSlots, "volume-slots", domain.DefaultVolumeSlots, fmt.Sprintf("The number of spare slots to reserve for hot-attaching volumes (firecracker only), %d uses the provider default of %d for firecracker and 0 for the others", domain.DefaultVolumeSlots, defaults.VolumeSlots))
+	cmd.Flags().StringVar(&input.RestartPolicy, "restart", string(domain.RestartPolicyNo), "The restart policy to apply when the vm process exits: no, on-failure or always. Requires mikrolited")
+	cmd.Flags().IntVar(&input.RestartMaxRetries, "restart-max-retries", 0, "The number of times to restart a failed vm before giving up (on-failure only), 0 means no limit")
+	cmd.Flags().IntVar(&input.RestartBackoff, "restart-backoff", 1, "The delay in seconds before the first restart, doubled for each restart after that")
+	cmd.Flags().BoolVar(&input.GuestAgent, "guest-agent", false, "Wait for the mikrolite guest agent in the vm to signal that it has booted and use the ip address it reports. The agent must be installed in the root image")
+
+	cmd.Flags().StringVar(&input.Bootstrap.Format, "bootstrap-format", string(domain.BootstrapFormatCloudInit), "The provisioning system the vm uses: cloud-init or ignition (Flatcar and Fedora CoreOS)")
+	cmd.Flags().StringVar(&input.Bootstrap.Datasource, "datasource", string(domain.DatasourceNoCloud), "The cloud-init datasource the vm uses: nocloud, or ec2 to serve the metadata like the EC2 instance metadata service. ec2 needs a provider with a metadata service or --host-metadata")
+	cmd.Flags().StringVar(&input.Bootstrap.ConfigDrive, "config-drive", "", "Put the metadata on a drive instead of using a metadata service: nocloud (FAT32), nocloud-iso or openstack (an ISO9660 config-2 drive). By default the metadata service is used, or a nocloud drive without one")
+	cmd.Flags().StringVar(&input.Bootstrap.UserDataFile, "user-data", "", "A file with user-data to merge with the generated config. For cloud-init it can be cloud-config, a script or multipart MIME, for ignition its an Ignition config")
+	cmd.Flags().StringVar(&input.Bootstrap.VendorDataFile, "vendor-data", "", "A file with cloud-init vendor-data")
+	cmd.Flags().StringArrayVar(&input.Bootstrap.Files, "file", nil, "A file to write to the vm when it first boots as src:dest[:mode], the mode of the source file is used if its not supplied. Can be repeated")
+	cmd.Flags().StringArrayVar(&input.Bootstrap.Units, "unit", nil, "A systemd unit file to install and enable in the vm when it first boots. Can be repeated")
+	cmd.Flags().StringVar(&input.WaitFor, "wait-for", string(domain.WaitForIP), "What to wait for before returning: ready waits for cloud-init to finish, ip waits for the vm to get an ip address and none returns once the vm has started")
+	cmd.Flags().DurationVar(&input.WaitTimeout, "wait-timeout", 0, "How long to wait, defaults to 20s for ip and 10m for ready")
 
 	cmd.MarkFlagRequired("name")
 	cmd.MarkFlagRequired("root-image")
 	cmd.MarkFlagsMutuallyExclusive("kernel-image", "kernel-path")
+	cmd.MarkFlagsMutuallyExclusive("initrd-image", "initrd-path")
 
 	return cmd
 }
@@ -0,0 +1,75 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+func newEnableVMCommand(cfg *commonConfig) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "enable [name]",
+		Short: "Start a vm when the host boots",
+		Long: `Start a vm when the host boots.
+
+A systemd service is generated for the vm and enabled, the service is part of
+mikrolite-vms.target. If the vm is running then the service takes it over.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			vmName := args[0]
+
+			pterm.DefaultSpinner.Start()
+			pterm.DefaultSpinner.Info(fmt.Sprintf("ℹ️  Enabling VM: %s\n", vmName))
+
+			a, err := newApp(cfg, vmName)
+			if err != nil {
+				pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error %s\n", err))
+				return
+			}
+
+			if _, err := a.EnableVM(cmd.Context(), vmName); err != nil {
+				pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error enabling vm %s: %s\n", vmName, err))
+				return
+			}
+
+			pterm.DefaultSpinner.Success(fmt.Sprintf("✅ Succesfully enabled VM: %s\n", vmName))
+			pterm.DefaultSpinner.Stop()
+		},
+	}
+
+	return cmd
+}
+
+func newDisableVMCommand(cfg *commonConfig) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "disable [name]",
+		Short: "Stop a vm from starting when the host boots",
+		Long: `Stop a vm from starting when the host boots.
+
+The systemd service for the vm is stopped, which stops the vm, and removed.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			vmName := args[0]
+
+			pterm.DefaultSpinner.Start()
+			pterm.DefaultSpinner.Info(fmt.Sprintf("ℹ️  Disabling VM: %s\n", vmName))
+
+			a, err := newApp(cfg, vmName)
+			if err != nil {
+				pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error %s\n", err))
+				return
+			}
+
+			if _, err := a.DisableVM(cmd.Context(), vmName); err != nil {
+				pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error disabling vm %s: %s\n", vmName, err))
+				return
+			}
+
+			pterm.DefaultSpinner.Success(fmt.Sprintf("✅ Succesfully disabled VM: %s\n", vmName))
+			pterm.DefaultSpinner.Stop()
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,45 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+
+	"github.com/mikrolite/mikrolite/api/v1alpha1"
+)
+
+func newEventsCommand(cfg *commonConfig) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "events [name]",
+		Short: "Watch the events for vms, requires mikrolited",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			name := ""
+			if len(args) == 1 {
+				name = args[0]
+			}
+
+			client, ok := daemonClient(cfg)
+			if !ok {
+				pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error mikrolited isn't running on %s\n", cfg.DaemonSocket))
+				return
+			}
+			defer client.Close()
+
+			err := client.WatchEvents(cmd.Context(), name, func(event *v1alpha1.Event) {
+				line := fmt.Sprintf("%s %s %s %s", event.Time.Format("2006-01-02T15:04:05Z"), event.VM, event.Operation, event.Status)
+				if event.Message != "" {
+					line = fmt.Sprintf("%s: %s", line, event.Message)
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), line)
+			})
+			if err != nil {
+				pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error watching events: %s\n", err))
+				return
+			}
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,88 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mikrolite/mikrolite/adapters/ssh"
+	"github.com/mikrolite/mikrolite/agent"
+)
+
+const (
+	viaAuto  = "auto"
+	viaAgent = "agent"
+	viaSSH   = "ssh"
+)
+
+func newExecVMCommand(cfg *commonConfig) *cobra.Command {
+	sshCfg := &sshConfig{}
+	via := viaAuto
+
+	cmd := &cobra.Command{
+		Use:   "exec [name] -- [command]",
+		Short: "Run a command in a vm",
+		Long: `Run a command in a vm. The command is run by the guest agent if the vm was
+created with --guest-agent, otherwise with ssh, waiting for sshd in the vm to be
+reachable. Use --via to choose.
+
+The output of the command is streamed to stdout and stderr, and mikrolite exits
+with the exit status of the command. A pty isn't allocated, use vm ssh for
+interactive commands.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			vmName := args[0]
+			command := strings.Join(args[1:], " ")
+			cmd.SilenceUsage = true
+
+			switch via {
+			case viaAuto:
+				vm, err := getRunningVM(cmd, cfg, vmName)
+				if err != nil {
+					return err
+				}
+				if usesAgent(vm) {
+					return execWithAgent(cmd, cfg, vmName, command)
+				}
+			case viaAgent:
+				return execWithAgent(cmd, cfg, vmName, command)
+			case viaSSH:
+			default:
+				return fmt.Errorf("unknown --via %q, expected %s, %s or %s", via, viaAuto, viaAgent, viaSSH)
+			}
+
+			client, err := dialVM(cmd, cfg, sshCfg, vmName)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			return runSession(cmd, client, ssh.Session{
+				Command: command,
+				Stdin:   cmd.InOrStdin(),
+				Stdout:  cmd.OutOrStdout(),
+				Stderr:  cmd.ErrOrStderr(),
+			})
+		},
+	}
+
+	sshCfg.BindFlags(cmd.Flags())
+	cmd.Flags().StringVar(&via, "via", viaAuto, "how to run the command: auto, agent or ssh")
+
+	return cmd
+}
+
+// execWithAgent runs the command with a shell, like sshd does, so that the
+// command is the same whichever way its run.
+func execWithAgent(cmd *cobra.Command, cfg *commonConfig, vmName string, command string) error {
+	client, err := newAgentClient(cmd, cfg, vmName)
+	if err != nil {
+		return err
+	}
+
+	err = client.Exec(cmd.Context(), agent.ExecRequest{Args: []string{"/bin/sh", "-c", command}},
+		cmd.InOrStdin(), cmd.OutOrStdout(), cmd.ErrOrStderr())
+
+	return silenceExitError(cmd, err)
+}
@@ -0,0 +1,73 @@
+package vm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+func newInfoVMCommand(cfg *commonConfig) *cobra.Command {
+	asJSON := false
+
+	cmd := &cobra.Command{
+		Use:   "info [name]",
+		Short: "Show the guest info of a running vm",
+		Long: `Show the hostname, network interfaces, uptime and load average reported by the
+guest agent in the vm. The vm must have been created with --guest-agent.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			vmName := args[0]
+			cmd.SilenceUsage = true
+
+			client, err := newAgentClient(cmd, cfg, vmName)
+			if err != nil {
+				return err
+			}
+
+			info, err := client.Info(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("getting guest info for vm %s: %w", vmName, err)
+			}
+
+			if asJSON {
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+
+				return encoder.Encode(info)
+			}
+
+			load := make([]string, 0, len(info.LoadAverage))
+			for _, value := range info.LoadAverage {
+				load = append(load, strconv.FormatFloat(value, 'f', 2, 64))
+			}
+			uptime := time.Duration(info.UptimeSeconds * float64(time.Second)).Round(time.Second)
+
+			data := [][]string{
+				{"Interface", "MAC", "Addresses"},
+			}
+			for _, iface := range info.Interfaces {
+				data = append(data, []string{iface.Name, iface.MAC, strings.Join(iface.Addresses, ",")})
+			}
+
+			table := pterm.DefaultTable
+			table.HasHeader = true
+
+			rendered, err := table.WithData(data).Srender()
+			if err != nil {
+				return fmt.Errorf("rendering interfaces: %w", err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Hostname: %s\nUptime: %s\nLoad average: %s\n%s\n", info.Hostname, uptime, strings.Join(load, " "), rendered)
+
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the guest info as json")
+
+	return cmd
+}
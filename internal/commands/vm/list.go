@@ -1,10 +1,13 @@
 package vm
 
 import (
+	"context"
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/mikrolite/mikrolite/adapters/filesystem"
+	"github.com/mikrolite/mikrolite/core/domain"
 	"github.com/pterm/pterm"
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
@@ -15,33 +18,80 @@ func newListCommandVM(cfg *commonConfig) *cobra.Command {
 		Use:   "list",
 		Short: "List virtual machines",
 		Run: func(cmd *cobra.Command, args []string) {
-			fsSvc := afero.NewOsFs()
-			stateSvc, err := filesystem.NewStateService("", cfg.StateRootPath, fsSvc)
-			if err != nil {
-				pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error creating state service: %s\n", err))
-				return
-			}
-
-			vms, err := stateSvc.ListVMs()
+			vms, err := listVMs(cmd.Context(), cfg)
 			if err != nil {
 				pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error listing VMs: %s\n", err))
 				return
 			}
 
 			vmPrintData := [][]string{
-				{"Name", "VCPU", "Memory In MB", "IP Address"},
+				{"Name", "VCPU", "Memory In MB", "IP Address", "State", "Ready", "Enabled"},
 			}
 			for _, vm := range vms {
 				ip := vm.Status.IP
-				vmPrintData = append(vmPrintData, []string{vm.Name, strconv.Itoa(vm.Spec.VCPU), strconv.Itoa(vm.Spec.MemoryInMb), ip})
+				vmPrintData = append(vmPrintData, []string{vm.Name, strconv.Itoa(vm.Spec.VCPU), strconv.Itoa(vm.Spec.MemoryInMb), ip, processState(vm), readyState(vm), strconv.FormatBool(vm.Spec.Autostart)})
 			}
 
 			table := pterm.DefaultTable
 			table.HasHeader = true
 
-			table.WithData(vmPrintData).Render()
+			out, err := table.WithData(vmPrintData).Srender()
+			if err != nil {
+				pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error rendering VMs: %s\n", err))
+				return
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), out)
 		},
 	}
 
 	return cmd
 }
+
+// processState describes the state of the vm process, including the reason
+// that it last exited.
+func processState(vm *domain.VM) string {
+	if vm.Status == nil || vm.Status.Process == nil {
+		return "unknown"
+	}
+
+	procStatus := vm.Status.Process
+	state := string(procStatus.State)
+	if procStatus.State != domain.ProcessStateRunning && procStatus.LastExit != nil {
+		state = fmt.Sprintf("%s (%s)", state, procStatus.LastExit.Reason)
+	}
+	if procStatus.Restarts > 0 {
+		state = fmt.Sprintf("%s, %d restarts", state, procStatus.Restarts)
+	}
+
+	return state
+}
+
+// readyState describes whether cloud-init has signalled that the vm is ready and
+// how long it took to boot. The time is only known for the first boot.
+func readyState(vm *domain.VM) string {
+	if vm.Status == nil || !vm.Status.Ready {
+		return "no"
+	}
+	if vm.Status.Boot == nil || vm.Status.Boot.ReadyAt == nil {
+		return "yes"
+	}
+
+	return fmt.Sprintf("yes (%s)", vm.Status.Boot.TimeToReady().Round(100*time.Millisecond))
+}
+
+// listVMs lists the vms using mikrolited if its running. Otherwise the state is
+// read directly, which doesn't need containerd.
+func listVMs(ctx context.Context, cfg *commonConfig) ([]*domain.VM, error) {
+	if client, ok := daemonClient(cfg); ok {
+		defer client.Close()
+
+		return client.ListVMs(ctx)
+	}
+
+	stateSvc, err := filesystem.NewStateService("", cfg.StateRootPath, afero.NewOsFs())
+	if err != nil {
+		return nil, fmt.Errorf("creating state service: %w", err)
+	}
+
+	return stateSvc.ListVMs()
+}
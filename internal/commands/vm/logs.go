@@ -0,0 +1,97 @@
+package vm
+
+import (
+	"fmt"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/mikrolite/mikrolite/adapters/filesystem"
+	"github.com/mikrolite/mikrolite/adapters/logs"
+)
+
+func newLogsVMCommand(cfg *commonConfig) *cobra.Command {
+	follow := false
+	since := ""
+	sources := []string{}
+
+	cmd := &cobra.Command{
+		Use:   "logs [name]",
+		Short: "Show the logs of a vm",
+		Long: `Show the logs of a vm in time order. Each line is tagged with its source:
+
+  console     the output of the serial console of the vm
+  hypervisor  the log and stderr of the firecracker, cloud-hypervisor or qemu process
+  mikrolite   what mikrolite has done to the vm
+
+The log files in the state directory of the vm are rotated once they reach 10MB
+and the last 3 rotated files are kept.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			vmName := args[0]
+			cmd.SilenceUsage = true
+
+			opts := logs.Options{Follow: follow}
+			for _, name := range sources {
+				source, err := logs.ParseSource(name)
+				if err != nil {
+					return err
+				}
+				opts.Sources = append(opts.Sources, source)
+			}
+			if since != "" {
+				sinceTime, err := parseSince(since, time.Now())
+				if err != nil {
+					return err
+				}
+				opts.Since = sinceTime
+			}
+
+			a, err := newApp(cfg, vmName)
+			if err != nil {
+				return err
+			}
+			if _, err := a.GetVM(cmd.Context(), vmName); err != nil {
+				return fmt.Errorf("getting vm %s: %w", vmName, err)
+			}
+
+			ss, err := filesystem.NewStateService(vmName, cfg.StateRootPath, afero.NewOsFs())
+			if err != nil {
+				return fmt.Errorf("creating state service: %w", err)
+			}
+
+			ctx, cancel := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
+
+			out := cmd.OutOrStdout()
+
+			return logs.Read(ctx, ss, opts, func(entry logs.Entry) error {
+				_, err := fmt.Fprintln(out, entry.Format())
+
+				return err
+			})
+		},
+	}
+
+	cmd.Flags().BoolVarP(&follow, "follow", "f", false, "wait for new log lines until interrupted")
+	cmd.Flags().StringVar(&since, "since", "", "only show the lines since a time, either a duration such as 10m or a time such as 2024-01-02T15:04:05Z")
+	cmd.Flags().StringSliceVar(&sources, "source", []string{}, "the sources to show, one or more of console, hypervisor and mikrolite (default all)")
+
+	return cmd
+}
+
+// parseSince parses the --since flag, which is either a duration before now or
+// an RFC3339 time.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(-duration), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid --since %q, expected a duration such as 10m or a time such as 2024-01-02T15:04:05Z", value)
+}
@@ -0,0 +1,141 @@
+package vm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+// networkFlags are the create flags that configure the network of eth0 in
+// the guest.
+type networkFlags struct {
+	StaticIP        string
+	StaticGatewayIP string
+	Nameservers     []string
+	SearchDomains   []string
+	Routes          []string
+	MTU             int
+	DHCPOverrides   []string
+	ConfigFormat    string
+}
+
+// newNetworkInterface creates eth0 from the create flags, its attached to the
+// bridge and uses dhcp unless a static ip is set.
+func newNetworkInterface(flags networkFlags) (domain.NetwortInterface, error) {
+	netInt := domain.NetwortInterface{
+		GuestDeviceName:       "eth0",
+		AllowMetadataRequests: false,
+		AttachToBridge:        true,
+		MTU:                   flags.MTU,
+	}
+
+	if flags.StaticIP != "" {
+		netInt.StaticIPv4Address = &domain.StaticIPv4Address{
+			Address:       flags.StaticIP,
+			Nameservers:   flags.Nameservers,
+			SearchDomains: flags.SearchDomains,
+		}
+
+		if flags.StaticGatewayIP != "" {
+			netInt.StaticIPv4Address.Gateway = &flags.StaticGatewayIP
+		}
+	} else if len(flags.Nameservers) > 0 || len(flags.SearchDomains) > 0 {
+		return netInt, fmt.Errorf("nameservers and search domains can only be set with a static ip, use --dhcp-override use-dns=false with dhcp")
+	}
+
+	for _, value := range flags.Routes {
+		route, err := parseRouteFlag(value)
+		if err != nil {
+			return netInt, err
+		}
+		netInt.Routes = append(netInt.Routes, *route)
+	}
+
+	if len(flags.DHCPOverrides) > 0 {
+		overrides, err := parseDHCPOverrideFlags(flags.DHCPOverrides)
+		if err != nil {
+			return netInt, err
+		}
+		netInt.DHCPOverrides = overrides
+	}
+
+	return netInt, nil
+}
+
+// parseRouteFlag parses a static route, as to=cidr,via=ip[,metric=n][,on-link=true].
+func parseRouteFlag(value string) (*domain.Route, error) {
+	route := &domain.Route{}
+	for _, part := range strings.Split(value, ",") {
+		key, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("route %q must be to=cidr,via=ip[,metric=n][,on-link=true]", value)
+		}
+
+		switch key {
+		case "to":
+			route.To = val
+		case "via":
+			route.Via = val
+		case "metric":
+			metric, err := strconv.Atoi(val)
+			if err != nil {
+				return nil, fmt.Errorf("route %q metric %s isn't a number", value, val)
+			}
+			route.Metric = &metric
+		case "on-link":
+			onLink, err := strconv.ParseBool(val)
+			if err != nil {
+				return nil, fmt.Errorf("route %q on-link %s isn't true or false", value, val)
+			}
+			route.OnLink = onLink
+		default:
+			return nil, fmt.Errorf("route %q has unknown key %s, expected to, via, metric or on-link", value, key)
+		}
+	}
+	if route.To == "" || route.Via == "" {
+		return nil, fmt.Errorf("route %q must be to=cidr,via=ip[,metric=n][,on-link=true]", value)
+	}
+
+	return route, nil
+}
+
+// parseDHCPOverrideFlags parses the dhcp overrides, as key=value.
+func parseDHCPOverrideFlags(values []string) (*domain.DHCPOverrides, error) {
+	overrides := &domain.DHCPOverrides{}
+	for _, value := range values {
+		key, val, ok := strings.Cut(value, "=")
+		if !ok {
+			return nil, fmt.Errorf("dhcp override %q must be key=value", value)
+		}
+
+		if key == "route-metric" {
+			metric, err := strconv.Atoi(val)
+			if err != nil {
+				return nil, fmt.Errorf("dhcp override %q isn't a number", value)
+			}
+			overrides.RouteMetric = &metric
+			continue
+		}
+
+		enabled, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("dhcp override %q isn't true or false", value)
+		}
+		switch key {
+		case "use-dns":
+			overrides.UseDNS = &enabled
+		case "use-domains":
+			overrides.UseDomains = &enabled
+		case "use-routes":
+			overrides.UseRoutes = &enabled
+		case "use-hostname":
+			overrides.UseHostname = &enabled
+		default:
+			return nil, fmt.Errorf("dhcp override %q has unknown key %s, expected use-dns, use-domains, use-routes, use-hostname or route-metric", value, key)
+		}
+	}
+
+	return overrides, nil
+}
@@ -0,0 +1,166 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+
+	"github.com/mikrolite/mikrolite/adapters/filesystem"
+	"github.com/mikrolite/mikrolite/adapters/ssh"
+	"github.com/mikrolite/mikrolite/agent"
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+const defaultSSHWait = 2 * time.Minute
+
+type sshConfig struct {
+	User     string
+	Port     int
+	Identity string
+	Wait     time.Duration
+}
+
+func (c *sshConfig) BindFlags(fs *pflag.FlagSet) {
+	fs.StringVarP(&c.User, "user", "u", ssh.DefaultUser, "the user to log in to the vm as")
+	fs.IntVar(&c.Port, "port", ssh.DefaultPort, "the port sshd listens on in the vm")
+	fs.StringVarP(&c.Identity, "identity", "i", "", "the private key to use, defaults to the one matching the --ssh-key the vm was created with")
+	fs.DurationVar(&c.Wait, "wait", defaultSSHWait, "how long to wait for sshd in the vm to be reachable")
+}
+
+func newSSHVMCommand(cfg *commonConfig) *cobra.Command {
+	sshCfg := &sshConfig{}
+
+	cmd := &cobra.Command{
+		Use:   "ssh [name] [-- command]",
+		Short: "Connect to a vm with ssh",
+		Long: `Connect to a vm with ssh, or run a command in it. The ip address of the vm is
+used and the private key is the one matching the --ssh-key the vm was created
+with, either the file without the .pub suffix or a key in the ssh agent.
+
+A pty is allocated if stdin is a terminal. The host key of the vm is trusted the
+first time it's connected to and is kept in the state directory of the vm.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			vmName := args[0]
+			cmd.SilenceUsage = true
+
+			client, err := dialVM(cmd, cfg, sshCfg, vmName)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			session := ssh.Session{
+				Command: strings.Join(args[1:], " "),
+				Stdin:   cmd.InOrStdin(),
+				Stdout:  cmd.OutOrStdout(),
+				Stderr:  cmd.ErrOrStderr(),
+			}
+			if file, ok := session.Stdin.(*os.File); ok && term.IsTerminal(int(file.Fd())) {
+				state, err := term.MakeRaw(int(file.Fd()))
+				if err != nil {
+					return fmt.Errorf("setting terminal to raw mode: %w", err)
+				}
+				defer term.Restore(int(file.Fd()), state)
+				session.TTY = file
+			}
+
+			return runSession(cmd, client, session)
+		},
+	}
+
+	sshCfg.BindFlags(cmd.Flags())
+
+	return cmd
+}
+
+// dialVM connects to the running vm with ssh.
+func dialVM(cmd *cobra.Command, cfg *commonConfig, sshCfg *sshConfig, vmName string) (*gossh.Client, error) {
+	vm, err := getRunningVM(cmd, cfg, vmName)
+	if err != nil {
+		return nil, err
+	}
+	if vm.Status.IP == "" {
+		return nil, fmt.Errorf("vm %s doesn't have an ip address", vmName)
+	}
+
+	var signer gossh.Signer
+	switch {
+	case sshCfg.Identity != "":
+		signer, err = ssh.LoadPrivateKey(sshCfg.Identity)
+	case vm.Spec.Bootstrap != nil && vm.Spec.Bootstrap.SSHKey != "":
+		signer, err = ssh.LoadSigner(vm.Spec.Bootstrap.SSHKey)
+	default:
+		err = errors.New("it wasn't created with --ssh-key, use --identity to supply the private key")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting ssh key for vm %s: %w", vmName, err)
+	}
+
+	ss, err := filesystem.NewStateService(vmName, cfg.StateRootPath, afero.NewOsFs())
+	if err != nil {
+		return nil, fmt.Errorf("creating state service: %w", err)
+	}
+
+	client, err := ssh.Dial(cmd.Context(), ssh.Options{
+		Address:        vm.Status.IP,
+		Port:           sshCfg.Port,
+		User:           sshCfg.User,
+		Signer:         signer,
+		KnownHostsPath: filepath.Join(ss.Root(), ssh.KnownHostsFile),
+		Wait:           sshCfg.Wait,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("connecting to vm %s: %w", vmName, err)
+	}
+
+	return client, nil
+}
+
+// getRunningVM gets the vm and checks that its process is running.
+func getRunningVM(cmd *cobra.Command, cfg *commonConfig, vmName string) (*domain.VM, error) {
+	a, err := newApp(cfg, vmName)
+	if err != nil {
+		return nil, err
+	}
+
+	vm, err := a.GetVM(cmd.Context(), vmName)
+	if err != nil {
+		return nil, fmt.Errorf("getting vm %s: %w", vmName, err)
+	}
+	if vm.Status == nil {
+		return nil, fmt.Errorf("vm %s doesn't have a status", vmName)
+	}
+	if vm.Status.Process != nil && vm.Status.Process.State != domain.ProcessStateRunning {
+		return nil, fmt.Errorf("vm %s isn't running, it's %s", vmName, vm.Status.Process.State)
+	}
+
+	return vm, nil
+}
+
+// runSession runs the session.
+func runSession(cmd *cobra.Command, client *gossh.Client, session ssh.Session) error {
+	return silenceExitError(cmd, ssh.Run(cmd.Context(), client, session))
+}
+
+// silenceExitError stops the error being printed if the command run in the vm
+// failed, so that the exit status of the command is used as the exit status of
+// mikrolite.
+func silenceExitError(cmd *cobra.Command, err error) error {
+	sshErr := &ssh.ExitError{}
+	agentErr := &agent.ExitError{}
+	if errors.As(err, &sshErr) || errors.As(err, &agentErr) {
+		cmd.SilenceErrors = true
+	}
+
+	return err
+}
@@ -0,0 +1,110 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+
+	"github.com/mikrolite/mikrolite/core/app"
+)
+
+func newStartVMCommand(cfg *commonConfig) *cobra.Command {
+	foreground := false
+
+	cmd := &cobra.Command{
+		Use:   "start [name]",
+		Short: "Start a vm that isn't running",
+		Long: `Start a vm that isn't running, for example after the host has rebooted.
+
+With --foreground the command doesn't exit until the vm process exits and the vm
+is stopped if the command is interrupted or terminated. This is used to run the
+vm from a systemd unit (see vm enable).`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			vmName := args[0]
+
+			if foreground {
+				cmd.SilenceUsage = true
+
+				return runForeground(cmd.Context(), cfg, vmName)
+			}
+
+			pterm.DefaultSpinner.Start()
+			pterm.DefaultSpinner.Info(fmt.Sprintf("🚀 Starting VM: %s\n", vmName))
+
+			a, err := newApp(cfg, vmName)
+			if err != nil {
+				pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error %s\n", err))
+				return nil
+			}
+
+			owner := fmt.Sprintf("vm-%s", vmName)
+			vm, err := a.StartVM(cmd.Context(), vmName, owner)
+			if err != nil {
+				switch {
+				case errors.Is(err, app.ErrVMRunning):
+					pterm.DefaultSpinner.Warning(fmt.Sprintf("VM %s is already running\n", vmName))
+					return nil
+				default:
+					pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error starting vm %s: %s\n", vmName, err))
+					return nil
+				}
+			}
+
+			pterm.DefaultSpinner.Success(fmt.Sprintf("✅ Succesfully started VM: %s (%s)\n", vmName, vm.Status.IP))
+			pterm.DefaultSpinner.Stop()
+
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&foreground, "foreground", false, "wait for the vm process to exit and stop the vm when terminated, mikrolited isn't used")
+
+	return cmd
+}
+
+// runForeground starts the vm and waits for its process to exit. If the vm is
+// already running then its adopted and waited for. An error is returned if the
+// vm process fails, so that the exit status can be used by systemd.
+func runForeground(ctx context.Context, cfg *commonConfig, vmName string) error {
+	a, err := newDirectApp(cfg, vmName)
+	if err != nil {
+		return err
+	}
+
+	owner := fmt.Sprintf("vm-%s", vmName)
+	if _, err := a.StartVM(ctx, vmName, owner); err != nil {
+		if !errors.Is(err, app.ErrVMRunning) {
+			return fmt.Errorf("starting vm %s: %w", vmName, err)
+		}
+		slog.Info("vm is already running, waiting for it to exit", "name", vmName)
+	}
+
+	waitCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	exit, err := a.WaitVM(waitCtx, vmName)
+	switch {
+	case waitCtx.Err() != nil:
+		return a.StopVM(context.WithoutCancel(ctx), vmName)
+	case errors.Is(err, app.ErrVMNotFound):
+		slog.Info("vm was removed", "name", vmName)
+
+		return nil
+	case err != nil:
+		return fmt.Errorf("waiting for vm %s: %w", vmName, err)
+	case !exit.Succeeded():
+		return fmt.Errorf("vm %s failed: %s", vmName, exit.Reason)
+	}
+
+	slog.Info("vm exited", "name", vmName)
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package vm
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+func newStatsVMCommand(cfg *commonConfig) *cobra.Command {
+	asJSON := false
+
+	cmd := &cobra.Command{
+		Use:   "stats [name]",
+		Short: "Show the metrics of a running vm",
+		Long: `Show the metrics of a running vm. The metrics come from the hypervisor, the
+firecracker metrics or the cloud-hypervisor counters, and from the tap
+interfaces on the host. When mikrolited is running the same metrics are served
+on /metrics in the prometheus format.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			vmName := args[0]
+			cmd.SilenceUsage = true
+
+			a, err := newApp(cfg, vmName)
+			if err != nil {
+				return err
+			}
+
+			stats, err := a.StatsVM(cmd.Context(), vmName)
+			if err != nil {
+				return fmt.Errorf("getting stats for vm %s: %w", vmName, err)
+			}
+			if stats.Provider == "" {
+				stats.Provider = cfg.VMProvider
+			}
+
+			if asJSON {
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+
+				return encoder.Encode(stats)
+			}
+
+			data := [][]string{
+				{"Metric", "Type", "Labels", "Value"},
+			}
+			for _, metric := range stats.Metrics {
+				data = append(data, []string{metric.Name, string(metric.Type), formatMetricLabels(metric.Labels), strconv.FormatFloat(metric.Value, 'f', -1, 64)})
+			}
+
+			table := pterm.DefaultTable
+			table.HasHeader = true
+
+			rendered, err := table.WithData(data).Srender()
+			if err != nil {
+				return fmt.Errorf("rendering stats: %w", err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s (%s) at %s\n%s\n", stats.Name, stats.Provider, stats.Time.Format("2006-01-02T15:04:05Z"), rendered)
+
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the metrics as json")
+
+	return cmd
+}
+
+func formatMetricLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
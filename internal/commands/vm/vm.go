@@ -4,13 +4,22 @@ import (
 	"log/slog"
 	"os"
 
-	"github.com/mikrolite/mikrolite/adapters/vm/firecracker"
+	"github.com/mikrolite/mikrolite/adapters/logs"
+	"github.com/mikrolite/mikrolite/defaults"
+	"github.com/mikrolite/mikrolite/internal/factory"
 
 	"github.com/spf13/cobra"
 )
 
 func NewVMCommand() *cobra.Command {
-	cfg := &commonConfig{}
+	return NewVMCommandWithDependencies(factory.DefaultDependencies())
+}
+
+// NewVMCommandWithDependencies creates the vm command using the supplied dependencies.
+func NewVMCommandWithDependencies(deps factory.Dependencies) *cobra.Command {
+	cfg := &commonConfig{
+		deps: deps,
+	}
 
 	cmd := &cobra.Command{
 		Use:   "vm",
@@ -22,7 +31,8 @@ func NewVMCommand() *cobra.Command {
 			if cfg.Debug {
 				loggerOpts.Level = slog.LevelDebug
 			}
-			logger := slog.New(slog.NewTextHandler(os.Stdout, loggerOpts))
+			// The vm logs are also written to the state directory of the vm
+			logger := slog.New(logs.NewHandler(slog.NewTextHandler(os.Stdout, loggerOpts), cfg.StateRootPath))
 			slog.SetDefault(logger)
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -30,25 +40,36 @@ func NewVMCommand() *cobra.Command {
 		},
 	}
 
-	cmd.PersistentFlags().StringVar(&cfg.SocketPath, "socket-path", "/run/containerd/containerd.sock", "the path to the containerd socket")
-	cmd.PersistentFlags().StringVar(&cfg.StateRootPath, "state-path", "/usr/local/share/mikrolite", "the path to the root directory to hold state in")
+	cfg.BindFlags(cmd.PersistentFlags())
 	cmd.PersistentFlags().BoolVar(&cfg.Debug, "debug", false, "enable debug features")
-	cmd.PersistentFlags().StringVarP(&cfg.VMProvider, "provider", "p", firecracker.ProviderName, "the vm provider to use")
-	cmd.PersistentFlags().StringVar(&cfg.FirecrackerBin, "firecracker-bin", "firecracker", "the path to the firecracker binary to use")
-	cmd.PersistentFlags().StringVar(&cfg.CloudHypervisorBin, "cloudhypervisor-bin", "cloud-hypervisor-static", "the path to the cloud-hypervisor binary to use")
+	cmd.PersistentFlags().StringVar(&cfg.DaemonSocket, "daemon-socket", defaults.DaemonSocketPath, "the path to the mikrolited socket, used if a daemon is running")
+	cmd.PersistentFlags().BoolVar(&cfg.Direct, "direct", false, "don't use mikrolited even if its running")
 
 	cmd.AddCommand(newCreateCommandVM(cfg))
 	cmd.AddCommand(newRemoveVMCommand(cfg))
+	cmd.AddCommand(newStartVMCommand(cfg))
+	cmd.AddCommand(newEnableVMCommand(cfg))
+	cmd.AddCommand(newDisableVMCommand(cfg))
 	cmd.AddCommand(newListCommandVM(cfg))
+	cmd.AddCommand(newVolumeCommand(cfg))
+	cmd.AddCommand(newEventsCommand(cfg))
+	cmd.AddCommand(newConsoleVMCommand(cfg))
+	cmd.AddCommand(newLogsVMCommand(cfg))
+	cmd.AddCommand(newStatsVMCommand(cfg))
+	cmd.AddCommand(newSSHVMCommand(cfg))
+	cmd.AddCommand(newExecVMCommand(cfg))
+	cmd.AddCommand(newCopyVMCommand(cfg))
+	cmd.AddCommand(newInfoVMCommand(cfg))
 
 	return cmd
 }
 
 type commonConfig struct {
-	SocketPath         string
-	StateRootPath      string
-	Debug              bool
-	VMProvider         string
-	FirecrackerBin     string
-	CloudHypervisorBin string
+	factory.Config
+
+	Debug        bool
+	DaemonSocket string
+	Direct       bool
+
+	deps factory.Dependencies
 }
@@ -0,0 +1,127 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+
+	"github.com/mikrolite/mikrolite/core/app"
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+func newVolumeCommand(cfg *commonConfig) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "volume",
+		Short: "Manage the volumes of a running vm",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
+		},
+	}
+
+	cmd.AddCommand(newVolumeAttachCommand(cfg))
+	cmd.AddCommand(newVolumeDetachCommand(cfg))
+
+	return cmd
+}
+
+func newVolumeAttachCommand(cfg *commonConfig) *cobra.Command {
+	input := struct {
+		VolumeName string
+		Image      string
+		RawPath    string
+	}{}
+
+	cmd := &cobra.Command{
+		Use:   "attach [name]",
+		Short: "Attach a volume to a running vm",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			vmName := args[0]
+
+			pterm.DefaultSpinner.Start()
+
+			volume := &domain.Volume{
+				Name: input.VolumeName,
+			}
+			if input.Image != "" {
+				volume.Source.Container = &domain.ContainerVolumeSource{
+					Image: input.Image,
+				}
+			}
+			if input.RawPath != "" {
+				volume.Source.Raw = &domain.RawVolumeSource{
+					Path: input.RawPath,
+				}
+			}
+
+			a, err := newApp(cfg, vmName)
+			if err != nil {
+				pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error %s\n", err))
+				return
+			}
+
+			_, err = a.AttachVolume(cmd.Context(), ports.AttachVolumeInput{
+				Name:   vmName,
+				Owner:  fmt.Sprintf("vm-%s", vmName),
+				Volume: volume,
+			})
+			if err != nil {
+				switch {
+				case errors.Is(err, app.ErrVolumeAlreadyExists):
+					pterm.DefaultSpinner.Warning(fmt.Sprintf("Volume %s already exists on VM %s\n", input.VolumeName, vmName))
+					return
+				default:
+					pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error attaching volume %s to vm %s: %s\n", input.VolumeName, vmName, err))
+					return
+				}
+			}
+
+			pterm.DefaultSpinner.Success(fmt.Sprintf("✅ Succesfully attached volume %s to VM: %s\n", input.VolumeName, vmName))
+			pterm.DefaultSpinner.Stop()
+		},
+	}
+
+	cmd.Flags().StringVar(&input.VolumeName, "volume-name", "", "The name of the volume")
+	cmd.Flags().StringVar(&input.Image, "image", "", "The container to use for the volume")
+	cmd.Flags().StringVar(&input.RawPath, "raw", "", "The path to a raw filesystem file to use for the volume")
+
+	cmd.MarkFlagRequired("volume-name")
+	cmd.MarkFlagsMutuallyExclusive("image", "raw")
+	cmd.MarkFlagsOneRequired("image", "raw")
+
+	return cmd
+}
+
+func newVolumeDetachCommand(cfg *commonConfig) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "detach [name] [volume-name]",
+		Short: "Detach a volume from a running vm",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			vmName := args[0]
+			volumeName := args[1]
+
+			pterm.DefaultSpinner.Start()
+
+			a, err := newApp(cfg, vmName)
+			if err != nil {
+				pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error %s\n", err))
+				return
+			}
+
+			owner := fmt.Sprintf("vm-%s", vmName)
+			if _, err := a.DetachVolume(cmd.Context(), vmName, volumeName, owner); err != nil {
+				pterm.DefaultSpinner.Fail(fmt.Sprintf("❌ Error detaching volume %s from vm %s: %s\n", volumeName, vmName, err))
+				return
+			}
+
+			pterm.DefaultSpinner.Success(fmt.Sprintf("✅ Succesfully detached volume %s from VM: %s\n", volumeName, vmName))
+			pterm.DefaultSpinner.Stop()
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,205 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/mikrolite/mikrolite/api/v1alpha1"
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+const (
+	probeTimeout = 500 * time.Millisecond
+)
+
+// Available returns true if a daemon is accepting connections on the socket.
+func Available(socketPath string) bool {
+	conn, err := net.DialTimeout("unix", socketPath, probeTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+
+	return true
+}
+
+// Client implements the vm use cases by calling the daemon.
+type Client struct {
+	conn *grpc.ClientConn
+	api  v1alpha1.VMServiceClient
+}
+
+var _ ports.VMUseCases = &Client{}
+
+// Dial creates a client for the daemon listening on the socket.
+func Dial(socketPath string) (*Client, error) {
+	conn, err := grpc.Dial(fmt.Sprintf("unix://%s", socketPath),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(v1alpha1.CodecName)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to mikrolited on %s: %w", socketPath, err)
+	}
+
+	return &Client{
+		conn: conn,
+		api:  v1alpha1.NewVMServiceClient(conn),
+	}, nil
+}
+
+// Close closes the connection to the daemon.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) CreateVM(ctx context.Context, input ports.CreateVMInput) (*domain.VM, error) {
+	resp, err := c.api.CreateVM(ctx, &v1alpha1.CreateVMRequest{
+		Name:               input.Name,
+		Owner:              input.Owner,
+		Spec:               input.Spec,
+		WaitFor:            input.WaitFor,
+		WaitTimeoutSeconds: int64(input.WaitTimeout / time.Second),
+	})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+
+	return resp.VM, nil
+}
+
+func (c *Client) RemoveVM(ctx context.Context, name string, owner string) error {
+	_, err := c.api.RemoveVM(ctx, &v1alpha1.RemoveVMRequest{
+		Name:  name,
+		Owner: owner,
+	})
+
+	return fromStatus(err)
+}
+
+func (c *Client) GetVM(ctx context.Context, name string) (*domain.VM, error) {
+	resp, err := c.api.GetVM(ctx, &v1alpha1.GetVMRequest{Name: name})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+
+	return resp.VM, nil
+}
+
+func (c *Client) ListVMs(ctx context.Context) ([]*domain.VM, error) {
+	resp, err := c.api.ListVMs(ctx, &v1alpha1.ListVMsRequest{})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+
+	return resp.VMs, nil
+}
+
+func (c *Client) AttachVolume(ctx context.Context, input ports.AttachVolumeInput) (*domain.VM, error) {
+	resp, err := c.api.AttachVolume(ctx, &v1alpha1.AttachVolumeRequest{
+		Name:   input.Name,
+		Owner:  input.Owner,
+		Volume: input.Volume,
+	})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+
+	return resp.VM, nil
+}
+
+func (c *Client) DetachVolume(ctx context.Context, name string, volumeName string, owner string) (*domain.VM, error) {
+	resp, err := c.api.DetachVolume(ctx, &v1alpha1.DetachVolumeRequest{
+		Name:       name,
+		Owner:      owner,
+		VolumeName: volumeName,
+	})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+
+	return resp.VM, nil
+}
+
+func (c *Client) StartVM(ctx context.Context, name string, owner string) (*domain.VM, error) {
+	resp, err := c.api.StartVM(ctx, &v1alpha1.StartVMRequest{
+		Name:  name,
+		Owner: owner,
+	})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+
+	return resp.VM, nil
+}
+
+func (c *Client) EnableVM(ctx context.Context, name string) (*domain.VM, error) {
+	resp, err := c.api.EnableVM(ctx, &v1alpha1.EnableVMRequest{Name: name})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+
+	return resp.VM, nil
+}
+
+func (c *Client) DisableVM(ctx context.Context, name string) (*domain.VM, error) {
+	resp, err := c.api.DisableVM(ctx, &v1alpha1.DisableVMRequest{Name: name})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+
+	return resp.VM, nil
+}
+
+func (c *Client) StatsVM(ctx context.Context, name string) (*domain.VMStats, error) {
+	resp, err := c.api.StatsVM(ctx, &v1alpha1.StatsVMRequest{Name: name})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+
+	return resp.Stats, nil
+}
+
+func (c *Client) GC(ctx context.Context, input ports.GCInput) (*ports.GCOutput, error) {
+	resp, err := c.api.GC(ctx, &v1alpha1.GCRequest{
+		Force:          input.Force,
+		IncludeStopped: input.IncludeStopped,
+	})
+	if err != nil {
+		return nil, fromStatus(err)
+	}
+
+	return &ports.GCOutput{Orphans: resp.Orphans}, nil
+}
+
+// WatchEvents calls fn for each event until the context is cancelled or the
+// daemon stops. If name is set then only events for that vm are watched.
+func (c *Client) WatchEvents(ctx context.Context, name string, fn func(event *v1alpha1.Event)) error {
+	stream, err := c.api.WatchEvents(ctx, &v1alpha1.WatchEventsRequest{Name: name})
+	if err != nil {
+		return fromStatus(err)
+	}
+
+	for {
+		event, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fromStatus(err)
+		}
+
+		fn(event)
+	}
+}
@@ -0,0 +1,270 @@
+// Package daemon implements mikrolited, a long running process that hosts the vm
+// use cases behind the grpc and REST api on a unix socket.
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+
+	"github.com/mikrolite/mikrolite/adapters/metadata"
+	"github.com/mikrolite/mikrolite/api/v1alpha1"
+	"github.com/mikrolite/mikrolite/core/app"
+	"github.com/mikrolite/mikrolite/core/ports"
+	"github.com/mikrolite/mikrolite/internal/factory"
+	"github.com/mikrolite/mikrolite/internal/flintlock"
+)
+
+const (
+	socketPerm      = 0o660
+	shutdownTimeout = 10 * time.Second
+)
+
+// Config is the configuration for the daemon.
+type Config struct {
+	factory.Config
+
+	// ListenPath is the path of the unix socket to serve the api on.
+	ListenPath string
+	// SuperviseInterval is how often the vm processes are checked. The vms
+	// aren't supervised if it's 0.
+	SuperviseInterval time.Duration
+	// GCInterval is how often the orphaned resources are removed. They aren't
+	// removed automatically if it's 0.
+	GCInterval time.Duration
+	// FlintlockAddress is the tcp address to serve the flintlock compatible api
+	// on. The api isn't served if it's empty.
+	FlintlockAddress string
+	// MetadataAddress is the address to serve the metadata of the vms on, when
+	// the metadata is served from the host.
+	MetadataAddress string
+}
+
+// Server serves the api. All the operations against a single vm are serialized.
+type Server struct {
+	cfg      Config
+	imageSvc ports.ImageService
+	netSvc   ports.NetworkService
+	autoSvc  ports.AutostartService
+	locks    *vmLocks
+	events   *broker
+}
+
+// New creates the server. The image, network and autostart services are created
+// once and shared by all the operations.
+func New(cfg Config, deps factory.Dependencies) (*Server, error) {
+	imageSvc, err := deps.NewImageService(cfg.SocketPath)
+	if err != nil {
+		return nil, err
+	}
+	autoSvc, err := deps.NewAutostartService(cfg.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		cfg:      cfg,
+		imageSvc: imageSvc,
+		netSvc:   deps.NewNetworkService(),
+		autoSvc:  autoSvc,
+		locks:    newVMLocks(),
+		events:   newBroker(),
+	}, nil
+}
+
+// Serve serves the api until the context is cancelled. Grpc and REST requests are
+// served on the same socket.
+func (s *Server) Serve(ctx context.Context) error {
+	listener, err := s.listen()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(s.cfg.ListenPath)
+
+	grpcServer := grpc.NewServer(recoveryOptions()...)
+	v1alpha1.RegisterVMServiceServer(grpcServer, s)
+
+	if s.cfg.FlintlockAddress != "" {
+		if err := s.serveFlintlock(ctx); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+
+	if s.cfg.HostMetadata {
+		if err := s.serveMetadata(ctx); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+
+	if s.cfg.SuperviseInterval > 0 {
+		go s.supervise(ctx, s.cfg.SuperviseInterval)
+	}
+	if s.cfg.GCInterval > 0 {
+		go s.collectGarbage(ctx, s.cfg.GCInterval)
+	}
+
+	gateway := s.gateway()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			grpcServer.ServeHTTP(w, r)
+			return
+		}
+		gateway.ServeHTTP(w, r)
+	})
+
+	httpServer := &http.Server{
+		Handler:           h2c.NewHandler(handler, &http2.Server{}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		slog.Info("shutting down mikrolited")
+
+		s.events.close()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("failed to shutdown gracefully", "error", err)
+			httpServer.Close()
+		}
+	}()
+
+	slog.Info("mikrolited listening", "socket", s.cfg.ListenPath, "api", v1alpha1.Version)
+
+	if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serving api: %w", err)
+	}
+
+	return nil
+}
+
+// serveFlintlock serves the flintlock compatible api on tcp until the context
+// is cancelled. The api is insecure and is meant for development use only.
+func (s *Server) serveFlintlock(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.cfg.FlintlockAddress)
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", s.cfg.FlintlockAddress, err)
+	}
+
+	grpcServer := grpc.NewServer(recoveryOptions()...)
+	flintlock.RegisterMicroVMServer(grpcServer, s)
+
+	go func() {
+		<-ctx.Done()
+		grpcServer.Stop()
+	}()
+
+	go func() {
+		if err := grpcServer.Serve(listener); err != nil {
+			slog.Error("serving flintlock api", "error", err)
+		}
+	}()
+
+	slog.Warn("serving the insecure flintlock api", "address", listener.Addr().String())
+
+	return nil
+}
+
+// serveMetadata serves the metadata of the vms for providers without a metadata
+// service until the context is cancelled.
+func (s *Server) serveMetadata(ctx context.Context) error {
+	listener, err := metadata.Listen(ctx, s.cfg.MetadataAddress)
+	if err != nil {
+		return err
+	}
+
+	server := factory.NewMetadataServer(s.cfg.Config, s.netSvc)
+	go func() {
+		if err := server.Serve(ctx, listener); err != nil {
+			slog.Error("serving metadata", "error", err)
+		}
+	}()
+
+	return nil
+}
+
+func (s *Server) listen() (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(s.cfg.ListenPath), 0o755); err != nil {
+		return nil, fmt.Errorf("creating socket directory: %w", err)
+	}
+
+	// Remove the socket left behind by a previous daemon
+	if err := os.Remove(s.cfg.ListenPath); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("removing existing socket %s: %w", s.cfg.ListenPath, err)
+	}
+
+	listener, err := net.Listen("unix", s.cfg.ListenPath)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", s.cfg.ListenPath, err)
+	}
+
+	if err := os.Chmod(s.cfg.ListenPath, socketPerm); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("setting permissions on %s: %w", s.cfg.ListenPath, err)
+	}
+
+	return listener, nil
+}
+
+// run runs the operation against the named vm whilst holding the lock for the
+// vm and publishes the events for the operation.
+func (s *Server) run(name string, op v1alpha1.Operation, fn func(a app.App) error) error {
+	unlock := s.locks.lock(name)
+	defer unlock()
+
+	s.publish(name, op, v1alpha1.EventStatusStarted, "")
+
+	err := s.withApp(name, fn)
+	if err != nil {
+		s.publish(name, op, v1alpha1.EventStatusFailed, err.Error())
+
+		return err
+	}
+
+	s.publish(name, op, v1alpha1.EventStatusSucceeded, "")
+
+	return nil
+}
+
+// withApp runs fn with the app for the named vm, or for all the vms if the name
+// is empty. The name is checked before the app is created as its used to find
+// the state directory of the vm.
+func (s *Server) withApp(name string, fn func(a app.App) error) error {
+	if name != "" {
+		if err := app.ValidateName(name); err != nil {
+			return err
+		}
+	}
+
+	a, err := factory.NewApp(s.cfg.Config, s.imageSvc, s.netSvc, s.autoSvc, name)
+	if err != nil {
+		return err
+	}
+
+	return fn(a)
+}
+
+func (s *Server) publish(name string, op v1alpha1.Operation, status v1alpha1.EventStatus, message string) {
+	s.events.publish(&v1alpha1.Event{
+		Time:      time.Now().UTC(),
+		VM:        name,
+		Operation: op,
+		Status:    status,
+		Message:   message,
+	})
+}
@@ -0,0 +1,108 @@
+package daemon
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/mikrolite/mikrolite/core/app"
+)
+
+// errorCodes maps the errors from the app to grpc codes. Its used in both
+// directions so that the errors returned by the client can be checked with
+// errors.Is just like in direct mode.
+var errorCodes = []struct {
+	err  error
+	code codes.Code
+}{
+	{app.ErrVMNotFound, codes.NotFound},
+	{app.ErrVolumeNotFound, codes.NotFound},
+	{app.ErrVMAlreadyExists, codes.AlreadyExists},
+	{app.ErrVolumeAlreadyExists, codes.AlreadyExists},
+	{app.ErrNameRequired, codes.InvalidArgument},
+	{app.ErrInvalidName, codes.InvalidArgument},
+	{app.ErrVmSpecRequired, codes.InvalidArgument},
+	{app.ErrNoKernelSource, codes.InvalidArgument},
+	{app.ErrVolumeRequired, codes.InvalidArgument},
+	{app.ErrInvalidRestartPolicy, codes.InvalidArgument},
+	{app.ErrInvalidWaitFor, codes.InvalidArgument},
+	{app.ErrInvalidBootstrap, codes.InvalidArgument},
+	{app.ErrInvalidNetwork, codes.InvalidArgument},
+	{app.ErrInvalidKernel, codes.InvalidArgument},
+	{app.ErrRootVolumeDetach, codes.FailedPrecondition},
+	{app.ErrVMRunning, codes.FailedPrecondition},
+	{app.ErrVMNotRunning, codes.FailedPrecondition},
+	{app.ErrUnsupportedByProvider, codes.Unimplemented},
+	{app.ErrNotImplemented, codes.Unimplemented},
+	{app.ErrWaitTimeout, codes.DeadlineExceeded},
+}
+
+// toStatus converts an error from the app into a grpc status error.
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	for _, ec := range errorCodes {
+		if errors.Is(err, ec.err) {
+			return status.Error(ec.code, err.Error())
+		}
+	}
+
+	return status.Error(codes.Internal, err.Error())
+}
+
+// fromStatus converts a grpc status error back into an error that wraps the
+// matching app error.
+func fromStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	st := status.Convert(err)
+	for _, ec := range errorCodes {
+		if st.Code() == ec.code && strings.HasSuffix(st.Message(), ec.err.Error()) {
+			return &apiError{message: st.Message(), cause: ec.err}
+		}
+	}
+
+	return errors.New(st.Message())
+}
+
+type apiError struct {
+	message string
+	cause   error
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func (e *apiError) Unwrap() error {
+	return e.cause
+}
+
+// httpStatus returns the http status code to use for a grpc status error.
+func httpStatus(err error) int {
+	switch status.Code(err) {
+	case codes.OK:
+		return http.StatusOK
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists:
+		return http.StatusConflict
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.FailedPrecondition:
+		return http.StatusPreconditionFailed
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
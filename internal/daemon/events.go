@@ -0,0 +1,92 @@
+package daemon
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/mikrolite/mikrolite/api/v1alpha1"
+)
+
+const (
+	// subscriberBufferSize is the number of events buffered for each subscriber.
+	subscriberBufferSize = 64
+)
+
+// broker fans out events to subscribers. Slow subscribers will miss events rather
+// than block the operations.
+type broker struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+	closed      bool
+}
+
+type subscriber struct {
+	name   string
+	events chan *v1alpha1.Event
+}
+
+func newBroker() *broker {
+	return &broker{
+		subscribers: map[*subscriber]struct{}{},
+	}
+}
+
+// subscribe returns a channel of events for the named vm, or all vms if name is
+// empty. The channel is closed when the subscription is cancelled or the broker
+// is closed.
+func (b *broker) subscribe(name string) (<-chan *v1alpha1.Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &subscriber{
+		name:   name,
+		events: make(chan *v1alpha1.Event, subscriberBufferSize),
+	}
+	if b.closed {
+		close(sub.events)
+
+		return sub.events, func() {}
+	}
+	b.subscribers[sub] = struct{}{}
+
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subscribers[sub]; ok {
+			delete(b.subscribers, sub)
+			close(sub.events)
+		}
+	}
+
+	return sub.events, cancel
+}
+
+func (b *broker) publish(event *v1alpha1.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers {
+		if sub.name != "" && sub.name != event.VM {
+			continue
+		}
+
+		select {
+		case sub.events <- event:
+		default:
+			slog.Warn("dropping event for slow subscriber", "vm", event.VM, "operation", event.Operation)
+		}
+	}
+}
+
+// close closes all the subscriptions.
+func (b *broker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers {
+		close(sub.events)
+	}
+	b.subscribers = map[*subscriber]struct{}{}
+	b.closed = true
+}
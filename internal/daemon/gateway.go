@@ -0,0 +1,199 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/mikrolite/mikrolite/api/v1alpha1"
+)
+
+// gateway returns the handler for the REST api. It exposes the same operations
+// as the grpc service:
+//
+//	GET    /v1alpha1/vms
+//	POST   /v1alpha1/vms
+//	GET    /v1alpha1/vms/{name}
+//	DELETE /v1alpha1/vms/{name}
+//	POST   /v1alpha1/vms/{name}/volumes
+//	DELETE /v1alpha1/vms/{name}/volumes/{volume}
+//	POST   /v1alpha1/vms/{name}/start
+//	POST   /v1alpha1/vms/{name}/enable
+//	POST   /v1alpha1/vms/{name}/disable
+//	GET    /v1alpha1/vms/{name}/stats
+//	POST   /v1alpha1/gc?force=true&include_stopped=true
+//	GET    /v1alpha1/events?name={name}
+//
+// The metrics of the running vms are served on /metrics in the prometheus text
+// format.
+func (s *Server) gateway() http.Handler {
+	prefix := "/" + v1alpha1.Version
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(prefix+"/vms", s.handleVMs)
+	mux.HandleFunc(prefix+"/vms/", s.handleVM)
+	mux.HandleFunc(prefix+"/gc", s.handleGC)
+	mux.HandleFunc(prefix+"/events", s.handleEvents)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+
+	return mux
+}
+
+func (s *Server) handleVMs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		resp, err := s.ListVMs(r.Context(), &v1alpha1.ListVMsRequest{})
+		writeResponse(w, resp, err)
+	case http.MethodPost:
+		req := &v1alpha1.CreateVMRequest{}
+		if !decodeRequest(w, r, req) {
+			return
+		}
+		resp, err := s.CreateVM(r.Context(), req)
+		writeResponse(w, resp, err)
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+func (s *Server) handleVM(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"+v1alpha1.Version+"/vms/"), "/")
+	name := parts[0]
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		resp, err := s.GetVM(r.Context(), &v1alpha1.GetVMRequest{Name: name})
+		writeResponse(w, resp, err)
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		resp, err := s.RemoveVM(r.Context(), &v1alpha1.RemoveVMRequest{Name: name, Owner: r.URL.Query().Get("owner")})
+		writeResponse(w, resp, err)
+	case len(parts) == 2 && parts[1] == "volumes" && r.Method == http.MethodPost:
+		req := &v1alpha1.AttachVolumeRequest{}
+		if !decodeRequest(w, r, req) {
+			return
+		}
+		req.Name = name
+		resp, err := s.AttachVolume(r.Context(), req)
+		writeResponse(w, resp, err)
+	case len(parts) == 3 && parts[1] == "volumes" && r.Method == http.MethodDelete:
+		resp, err := s.DetachVolume(r.Context(), &v1alpha1.DetachVolumeRequest{
+			Name:       name,
+			VolumeName: parts[2],
+			Owner:      r.URL.Query().Get("owner"),
+		})
+		writeResponse(w, resp, err)
+	case len(parts) == 2 && parts[1] == "start" && r.Method == http.MethodPost:
+		resp, err := s.StartVM(r.Context(), &v1alpha1.StartVMRequest{Name: name, Owner: r.URL.Query().Get("owner")})
+		writeResponse(w, resp, err)
+	case len(parts) == 2 && parts[1] == "enable" && r.Method == http.MethodPost:
+		resp, err := s.EnableVM(r.Context(), &v1alpha1.EnableVMRequest{Name: name})
+		writeResponse(w, resp, err)
+	case len(parts) == 2 && parts[1] == "disable" && r.Method == http.MethodPost:
+		resp, err := s.DisableVM(r.Context(), &v1alpha1.DisableVMRequest{Name: name})
+		writeResponse(w, resp, err)
+	case len(parts) == 2 && parts[1] == "stats" && r.Method == http.MethodGet:
+		resp, err := s.StatsVM(r.Context(), &v1alpha1.StatsVMRequest{Name: name})
+		writeResponse(w, resp, err)
+	case len(parts) <= 3:
+		writeMethodNotAllowed(w)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) handleGC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	resp, err := s.GC(r.Context(), &v1alpha1.GCRequest{
+		Force:          r.URL.Query().Get("force") == "true",
+		IncludeStopped: r.URL.Query().Get("include_stopped") == "true",
+	})
+	writeResponse(w, resp, err)
+}
+
+// handleEvents streams the events as newline delimited json.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, status.Error(codes.Internal, "streaming not supported"))
+		return
+	}
+
+	events, cancel := s.events.subscribe(r.URL.Query().Get("name"))
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := encoder.Encode(event); err != nil {
+				slog.Debug("writing event", "error", err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func decodeRequest(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, status.Error(codes.InvalidArgument, fmt.Sprintf("decoding request: %s", err)))
+		return false
+	}
+
+	return true
+}
+
+func writeResponse(w http.ResponseWriter, resp interface{}, err error) {
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	writeJSON(w, httpStatus(err), map[string]string{
+		"code":    status.Code(err).String(),
+		"message": status.Convert(err).Message(),
+	})
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter) {
+	writeJSON(w, http.StatusMethodNotAllowed, map[string]string{
+		"code":    codes.Unimplemented.String(),
+		"message": "method not allowed",
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Debug("writing response", "error", err)
+	}
+}
@@ -0,0 +1,73 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/mikrolite/mikrolite/api/v1alpha1"
+	"github.com/mikrolite/mikrolite/core/app"
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+// collectGarbage removes the orphaned resources every interval until the
+// context is cancelled. The vms whose process isn't running are left alone as
+// they may be started again.
+func (s *Server) collectGarbage(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.gc(ctx, ports.GCInput{Force: true}); err != nil {
+				slog.Warn("failed to remove orphaned resources", "error", err)
+			}
+		}
+	}
+}
+
+// gc finds the orphaned resources whilst holding the lock for all the vms, so
+// that the resources of a vm that is being created or removed aren't seen as
+// orphans. An event is published if any are removed.
+func (s *Server) gc(ctx context.Context, input ports.GCInput) (*ports.GCOutput, error) {
+	unlock := s.locks.lockAll()
+	defer unlock()
+
+	var output *ports.GCOutput
+	err := s.withApp("", func(a app.App) error {
+		var err error
+		output, err = a.GC(ctx, input)
+
+		return err
+	})
+	if err != nil {
+		s.publish("", v1alpha1.OperationGC, v1alpha1.EventStatusFailed, err.Error())
+
+		return nil, err
+	}
+
+	removed, failed := 0, 0
+	for _, orphan := range output.Orphans {
+		switch {
+		case orphan.Removed:
+			removed++
+		case orphan.Error != "":
+			failed++
+		}
+	}
+	if removed == 0 && failed == 0 {
+		return output, nil
+	}
+
+	status := v1alpha1.EventStatusSucceeded
+	if failed > 0 {
+		status = v1alpha1.EventStatusFailed
+	}
+	s.publish("", v1alpha1.OperationGC, status, fmt.Sprintf("removed %d orphans, %d failed", removed, failed))
+
+	return output, nil
+}
@@ -0,0 +1,62 @@
+package daemon
+
+import "sync"
+
+// vmLocks serializes the operations on each vm. Operations on different vms
+// can run at the same time.
+type vmLocks struct {
+	// all is held for reading by the operations on a vm and for writing by the
+	// operations on all the vms.
+	all   sync.RWMutex
+	mu    sync.Mutex
+	locks map[string]*vmLock
+}
+
+type vmLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func newVMLocks() *vmLocks {
+	return &vmLocks{
+		locks: map[string]*vmLock{},
+	}
+}
+
+// lock will block until no other operation holds the lock for the named vm. The
+// returned func releases the lock.
+func (l *vmLocks) lock(name string) func() {
+	l.all.RLock()
+
+	l.mu.Lock()
+	lock, ok := l.locks[name]
+	if !ok {
+		lock = &vmLock{}
+		l.locks[name] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.mu.Lock()
+
+	return func() {
+		lock.mu.Unlock()
+
+		l.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, name)
+		}
+		l.mu.Unlock()
+
+		l.all.RUnlock()
+	}
+}
+
+// lockAll will block until no other operation holds a lock for any vm. The
+// returned func releases the lock.
+func (l *vmLocks) lockAll() func() {
+	l.all.Lock()
+
+	return l.all.Unlock
+}
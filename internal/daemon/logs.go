@@ -0,0 +1,25 @@
+package daemon
+
+import (
+	"log/slog"
+
+	"github.com/spf13/afero"
+
+	"github.com/mikrolite/mikrolite/adapters/filesystem"
+	"github.com/mikrolite/mikrolite/adapters/logs"
+)
+
+// rotateLogs rotates the log files of the vm that have grown too big. The vm
+// process keeps writing to its logs while its running, so they're checked each
+// time the vm is supervised.
+func (s *Server) rotateLogs(name string) {
+	ss, err := filesystem.NewStateService(name, s.cfg.StateRootPath, afero.NewOsFs())
+	if err != nil {
+		slog.Warn("failed to rotate vm logs", "vm", name, "error", err)
+		return
+	}
+
+	if err := logs.RotateVM(ss); err != nil {
+		slog.Warn("failed to rotate vm logs", "vm", name, "error", err)
+	}
+}
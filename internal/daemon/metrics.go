@@ -0,0 +1,161 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mikrolite/mikrolite/core/app"
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+const (
+	metricsPrefix      = "mikrolite_"
+	metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+var invalidMetricChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// statsVM gets the metrics of the vm, labelled with the provider.
+func (s *Server) statsVM(ctx context.Context, name string) (*domain.VMStats, error) {
+	var stats *domain.VMStats
+	err := s.withApp(name, func(a app.App) error {
+		var err error
+		stats, err = a.StatsVM(ctx, name)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	stats.Provider = s.cfg.VMProvider
+
+	return stats, nil
+}
+
+// handleMetrics serves the metrics of all the running vms in the prometheus text
+// format. Each metric has the name and provider of the vm as labels.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	var vms []*domain.VM
+	err := s.withApp("", func(a app.App) error {
+		var err error
+		vms, err = a.ListVMs(r.Context())
+
+		return err
+	})
+	if err != nil {
+		writeError(w, toStatus(err))
+		return
+	}
+
+	allStats := []*domain.VMStats{}
+	for _, vm := range vms {
+		if vm.Status == nil || vm.Status.Process == nil || vm.Status.Process.State != domain.ProcessStateRunning {
+			continue
+		}
+
+		stats, err := s.statsVM(r.Context(), vm.Name)
+		if err != nil {
+			// The vm may have stopped since it was listed
+			if !errors.Is(err, app.ErrVMNotRunning) {
+				slog.Warn("failed to get vm metrics", "vm", vm.Name, "error", err)
+			}
+			continue
+		}
+		allStats = append(allStats, stats)
+	}
+
+	w.Header().Set("Content-Type", metricsContentType)
+	w.WriteHeader(http.StatusOK)
+	if err := writePrometheus(w, allStats); err != nil {
+		slog.Debug("writing metrics", "error", err)
+	}
+}
+
+// sample is a single value of a metric family.
+type sample struct {
+	labels map[string]string
+	value  float64
+}
+
+// family is all the samples of a metric.
+type family struct {
+	name       string
+	metricType domain.MetricType
+	samples    []sample
+}
+
+// writePrometheus writes the metrics in the prometheus text format. The names
+// are prefixed with mikrolite_ and counters get the _total suffix.
+func writePrometheus(w io.Writer, allStats []*domain.VMStats) error {
+	families := map[string]*family{}
+	for _, stats := range allStats {
+		for _, metric := range stats.Metrics {
+			name := metricsPrefix + invalidMetricChars.ReplaceAllString(metric.Name, "_")
+			if metric.Type == domain.MetricTypeCounter && !strings.HasSuffix(name, "_total") {
+				name += "_total"
+			}
+
+			f, ok := families[name]
+			if !ok {
+				f = &family{name: name, metricType: metric.Type}
+				families[name] = f
+			}
+
+			labels := map[string]string{"name": stats.Name, "provider": stats.Provider}
+			for key, value := range metric.Labels {
+				labels[invalidMetricChars.ReplaceAllString(key, "_")] = value
+			}
+			f.samples = append(f.samples, sample{labels: labels, value: metric.Value})
+		}
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := families[name]
+		if _, err := fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.metricType); err != nil {
+			return err
+		}
+		for _, s := range f.samples {
+			if _, err := fmt.Fprintf(w, "%s{%s} %s\n", f.name, formatLabels(s.labels), strconv.FormatFloat(s.value, 'g', -1, 64)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// formatLabels formats the labels sorted by name with the values escaped.
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, key, replacer.Replace(labels[key])))
+	}
+
+	return strings.Join(pairs, ",")
+}
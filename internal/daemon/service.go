@@ -0,0 +1,239 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mikrolite/mikrolite/api/v1alpha1"
+	"github.com/mikrolite/mikrolite/core/app"
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+func (s *Server) CreateVM(ctx context.Context, req *v1alpha1.CreateVMRequest) (*v1alpha1.VMResponse, error) {
+	if req.Name == "" {
+		return nil, toStatus(app.ErrNameRequired)
+	}
+
+	var vm *domain.VM
+	err := s.run(req.Name, v1alpha1.OperationCreate, func(a app.App) error {
+		var err error
+		vm, err = a.CreateVM(ctx, ports.CreateVMInput{
+			Name:        req.Name,
+			Owner:       ownerFor(req.Name, req.Owner),
+			Spec:        req.Spec,
+			WaitFor:     req.WaitFor,
+			WaitTimeout: time.Duration(req.WaitTimeoutSeconds) * time.Second,
+		})
+
+		return err
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &v1alpha1.VMResponse{VM: vm}, nil
+}
+
+func (s *Server) RemoveVM(ctx context.Context, req *v1alpha1.RemoveVMRequest) (*v1alpha1.Empty, error) {
+	if req.Name == "" {
+		return nil, toStatus(app.ErrNameRequired)
+	}
+
+	err := s.run(req.Name, v1alpha1.OperationRemove, func(a app.App) error {
+		return a.RemoveVM(ctx, req.Name, ownerFor(req.Name, req.Owner))
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &v1alpha1.Empty{}, nil
+}
+
+func (s *Server) GetVM(ctx context.Context, req *v1alpha1.GetVMRequest) (*v1alpha1.VMResponse, error) {
+	if req.Name == "" {
+		return nil, toStatus(app.ErrNameRequired)
+	}
+
+	var vm *domain.VM
+	err := s.withApp(req.Name, func(a app.App) error {
+		var err error
+		vm, err = a.GetVM(ctx, req.Name)
+
+		return err
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &v1alpha1.VMResponse{VM: vm}, nil
+}
+
+func (s *Server) ListVMs(ctx context.Context, req *v1alpha1.ListVMsRequest) (*v1alpha1.ListVMsResponse, error) {
+	var vms []*domain.VM
+	err := s.withApp("", func(a app.App) error {
+		var err error
+		vms, err = a.ListVMs(ctx)
+
+		return err
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &v1alpha1.ListVMsResponse{VMs: vms}, nil
+}
+
+func (s *Server) AttachVolume(ctx context.Context, req *v1alpha1.AttachVolumeRequest) (*v1alpha1.VMResponse, error) {
+	if req.Name == "" {
+		return nil, toStatus(app.ErrNameRequired)
+	}
+
+	var vm *domain.VM
+	err := s.run(req.Name, v1alpha1.OperationAttachVolume, func(a app.App) error {
+		var err error
+		vm, err = a.AttachVolume(ctx, ports.AttachVolumeInput{
+			Name:   req.Name,
+			Owner:  ownerFor(req.Name, req.Owner),
+			Volume: req.Volume,
+		})
+
+		return err
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &v1alpha1.VMResponse{VM: vm}, nil
+}
+
+func (s *Server) DetachVolume(ctx context.Context, req *v1alpha1.DetachVolumeRequest) (*v1alpha1.VMResponse, error) {
+	if req.Name == "" {
+		return nil, toStatus(app.ErrNameRequired)
+	}
+
+	var vm *domain.VM
+	err := s.run(req.Name, v1alpha1.OperationDetachVolume, func(a app.App) error {
+		var err error
+		vm, err = a.DetachVolume(ctx, req.Name, req.VolumeName, ownerFor(req.Name, req.Owner))
+
+		return err
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &v1alpha1.VMResponse{VM: vm}, nil
+}
+
+func (s *Server) StartVM(ctx context.Context, req *v1alpha1.StartVMRequest) (*v1alpha1.VMResponse, error) {
+	if req.Name == "" {
+		return nil, toStatus(app.ErrNameRequired)
+	}
+
+	var vm *domain.VM
+	err := s.run(req.Name, v1alpha1.OperationStart, func(a app.App) error {
+		var err error
+		vm, err = a.StartVM(ctx, req.Name, ownerFor(req.Name, req.Owner))
+
+		return err
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &v1alpha1.VMResponse{VM: vm}, nil
+}
+
+func (s *Server) EnableVM(ctx context.Context, req *v1alpha1.EnableVMRequest) (*v1alpha1.VMResponse, error) {
+	if req.Name == "" {
+		return nil, toStatus(app.ErrNameRequired)
+	}
+
+	var vm *domain.VM
+	err := s.run(req.Name, v1alpha1.OperationEnable, func(a app.App) error {
+		var err error
+		vm, err = a.EnableVM(ctx, req.Name)
+
+		return err
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &v1alpha1.VMResponse{VM: vm}, nil
+}
+
+func (s *Server) DisableVM(ctx context.Context, req *v1alpha1.DisableVMRequest) (*v1alpha1.VMResponse, error) {
+	if req.Name == "" {
+		return nil, toStatus(app.ErrNameRequired)
+	}
+
+	var vm *domain.VM
+	err := s.run(req.Name, v1alpha1.OperationDisable, func(a app.App) error {
+		var err error
+		vm, err = a.DisableVM(ctx, req.Name)
+
+		return err
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &v1alpha1.VMResponse{VM: vm}, nil
+}
+
+func (s *Server) StatsVM(ctx context.Context, req *v1alpha1.StatsVMRequest) (*v1alpha1.StatsVMResponse, error) {
+	if req.Name == "" {
+		return nil, toStatus(app.ErrNameRequired)
+	}
+
+	stats, err := s.statsVM(ctx, req.Name)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &v1alpha1.StatsVMResponse{Stats: stats}, nil
+}
+
+func (s *Server) GC(ctx context.Context, req *v1alpha1.GCRequest) (*v1alpha1.GCResponse, error) {
+	output, err := s.gc(ctx, ports.GCInput{
+		Force:          req.Force,
+		IncludeStopped: req.IncludeStopped,
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &v1alpha1.GCResponse{Orphans: output.Orphans}, nil
+}
+
+func (s *Server) WatchEvents(req *v1alpha1.WatchEventsRequest, stream v1alpha1.VMService_WatchEventsServer) error {
+	events, cancel := s.events.subscribe(req.Name)
+	defer cancel()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ownerFor returns the owner to use for the vm. The cli uses vm-<name> and so
+// thats used if no owner is supplied.
+func ownerFor(name string, owner string) string {
+	if owner != "" {
+		return owner
+	}
+
+	return fmt.Sprintf("vm-%s", name)
+}
@@ -0,0 +1,89 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/mikrolite/mikrolite/api/v1alpha1"
+	"github.com/mikrolite/mikrolite/core/app"
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+// supervise checks the vm processes every interval until the context is
+// cancelled. Exited processes are recorded and restarted based on the restart
+// policy of the vm.
+func (s *Server) supervise(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.superviseAll(ctx)
+		}
+	}
+}
+
+func (s *Server) superviseAll(ctx context.Context) {
+	var vms []*domain.VM
+	err := s.withApp("", func(a app.App) error {
+		var err error
+		vms, err = a.ListVMs(ctx)
+
+		return err
+	})
+	if err != nil {
+		slog.Warn("failed to list vms to supervise", "error", err)
+		return
+	}
+
+	for _, vm := range vms {
+		if ctx.Err() != nil {
+			return
+		}
+
+		s.superviseVM(ctx, vm.Name)
+		s.rotateLogs(vm.Name)
+	}
+}
+
+func (s *Server) superviseVM(ctx context.Context, name string) {
+	unlock := s.locks.lock(name)
+	defer unlock()
+
+	var output *ports.SuperviseVMOutput
+	err := s.withApp(name, func(a app.App) error {
+		var err error
+		output, err = a.SuperviseVM(ctx, name)
+
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, app.ErrVMNotFound) {
+			// The vm was removed after it was listed
+			return
+		}
+
+		slog.Warn("failed to supervise vm", "vm", name, "error", err)
+		s.publish(name, v1alpha1.OperationRestart, v1alpha1.EventStatusFailed, err.Error())
+
+		return
+	}
+
+	if output.Exited != nil {
+		status := v1alpha1.EventStatusFailed
+		if output.Exited.Succeeded() {
+			status = v1alpha1.EventStatusSucceeded
+		}
+		slog.Info("vm process exited", "vm", name, "reason", output.Exited.Reason)
+		s.publish(name, v1alpha1.OperationExit, status, output.Exited.Reason)
+	}
+	if output.Restarted {
+		s.publish(name, v1alpha1.OperationRestart, v1alpha1.EventStatusSucceeded, "")
+	}
+}
@@ -0,0 +1,150 @@
+// Package factory wires up the adapters and the core app. Its shared by the cli
+// when running in direct mode and by the daemon.
+package factory
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	ctr "github.com/containerd/containerd"
+	"github.com/spf13/afero"
+	"github.com/spf13/pflag"
+
+	"github.com/mikrolite/mikrolite/adapters/containerd"
+	"github.com/mikrolite/mikrolite/adapters/filesystem"
+	"github.com/mikrolite/mikrolite/adapters/godisk"
+	"github.com/mikrolite/mikrolite/adapters/metadata"
+	"github.com/mikrolite/mikrolite/adapters/netlink"
+	"github.com/mikrolite/mikrolite/adapters/phonehome"
+	"github.com/mikrolite/mikrolite/adapters/process"
+	"github.com/mikrolite/mikrolite/adapters/systemd"
+	"github.com/mikrolite/mikrolite/adapters/vm"
+	"github.com/mikrolite/mikrolite/adapters/vm/firecracker"
+	"github.com/mikrolite/mikrolite/adapters/vsock"
+	"github.com/mikrolite/mikrolite/core/app"
+	"github.com/mikrolite/mikrolite/core/ports"
+	"github.com/mikrolite/mikrolite/defaults"
+)
+
+// Config is the configuration needed to create the app.
+type Config struct {
+	SocketPath         string
+	StateRootPath      string
+	VMProvider         string
+	FirecrackerBin     string
+	CloudHypervisorBin string
+	QemuBin            string
+	PluginPaths        []string
+	SystemdUnitPath    string
+	HostMetadata       bool
+}
+
+// BindFlags adds the flags for the config to the flag set.
+func (c *Config) BindFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&c.SocketPath, "socket-path", "/run/containerd/containerd.sock", "the path to the containerd socket")
+	flags.StringVar(&c.StateRootPath, "state-path", defaults.StatePath, "the path to the root directory to hold state in")
+	flags.StringVarP(&c.VMProvider, "provider", "p", firecracker.ProviderName, "the vm provider to use")
+	flags.StringVar(&c.FirecrackerBin, "firecracker-bin", "firecracker", "the path to the firecracker binary to use")
+	flags.StringVar(&c.CloudHypervisorBin, "cloudhypervisor-bin", "cloud-hypervisor-static", "the path to the cloud-hypervisor binary to use")
+	flags.StringVar(&c.QemuBin, "qemu-bin", "qemu-system-x86_64", "the path to the qemu binary to use")
+	flags.StringSliceVar(&c.PluginPaths, "plugin-path", []string{defaults.PluginPath}, "the directories to search for provider plugins")
+	flags.StringVar(&c.SystemdUnitPath, "systemd-unit-path", defaults.SystemdUnitPath, "the directory to write the systemd units for vms that start on boot to")
+	flags.BoolVar(&c.HostMetadata, "host-metadata", false, "serve the metadata from the host for providers without a metadata service, mikrolited or mikrolite metadata-server must be running with it")
+}
+
+// Args returns the flags that recreate the config, for example when running
+// mikrolite from a systemd unit.
+func (c *Config) Args() []string {
+	return []string{
+		"--socket-path", c.SocketPath,
+		"--state-path", c.StateRootPath,
+		"--provider", c.VMProvider,
+		"--firecracker-bin", c.FirecrackerBin,
+		"--cloudhypervisor-bin", c.CloudHypervisorBin,
+		"--qemu-bin", c.QemuBin,
+		"--plugin-path", strings.Join(c.PluginPaths, ","),
+		"--systemd-unit-path", c.SystemdUnitPath,
+		fmt.Sprintf("--host-metadata=%t", c.HostMetadata),
+	}
+}
+
+// Dependencies are used to create the driven adapters that talk to the host. They
+// can be replaced so that mikrolite can be run without containerd or netlink.
+type Dependencies struct {
+	// NewImageService creates the image service using the containerd socket path.
+	NewImageService func(socketPath string) (ports.ImageService, error)
+	// NewNetworkService creates the network service.
+	NewNetworkService func() ports.NetworkService
+	// NewAutostartService creates the service used to start vms on boot.
+	NewAutostartService func(cfg Config) (ports.AutostartService, error)
+}
+
+// DefaultDependencies returns the dependencies that use containerd and netlink.
+func DefaultDependencies() Dependencies {
+	return Dependencies{
+		NewImageService: func(socketPath string) (ports.ImageService, error) {
+			client, err := ctr.New(socketPath)
+			if err != nil {
+				return nil, fmt.Errorf("creating containerd client: %w", err)
+			}
+
+			return containerd.NewImageService(client), nil
+		},
+		NewNetworkService:   netlink.New,
+		NewAutostartService: NewSystemdAutostartService,
+	}
+}
+
+// NewSystemdAutostartService creates the autostart service that uses systemd. The
+// units run the current executable to start the vm in the foreground.
+func NewSystemdAutostartService(cfg Config) (ports.AutostartService, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("getting the path to mikrolite: %w", err)
+	}
+
+	command := append([]string{executable, "vm", "start", "--foreground", "--direct"}, cfg.Args()...)
+
+	return systemd.New(cfg.SystemdUnitPath, command, afero.NewOsFs(), systemd.ExecSystemctl), nil
+}
+
+// NewApp wires up the services for the named vm and creates the app. The image,
+// network and autostart services aren't specific to a vm and so are passed in.
+func NewApp(cfg Config, imageSvc ports.ImageService, netSvc ports.NetworkService, autostartSvc ports.AutostartService, vmName string) (app.App, error) {
+	fsSvc := afero.NewOsFs()
+	stateSvc, err := filesystem.NewStateService(vmName, cfg.StateRootPath, fsSvc)
+	if err != nil {
+		return nil, fmt.Errorf("creating state service: %w", err)
+	}
+	diskSvc := godisk.New(fsSvc)
+	vmSvc, err := vm.New(cfg.VMProvider, vm.VMProviderProps{
+		StateService:       stateSvc,
+		DiskSvc:            diskSvc,
+		Fs:                 fsSvc,
+		FirecrackerBin:     cfg.FirecrackerBin,
+		CloudHypervisorBin: cfg.CloudHypervisorBin,
+		QemuBin:            cfg.QemuBin,
+		PluginPaths:        cfg.PluginPaths,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating vm provider %s: %w", cfg.VMProvider, err)
+	}
+
+	opts := []app.Option{}
+	if cfg.HostMetadata {
+		opts = append(opts, app.WithHostMetadata())
+	}
+
+	return app.New(imageSvc, vmSvc, stateSvc, fsSvc, netSvc, process.New(), autostartSvc, vsock.NewGuestAgentService(), phonehome.New(), opts...), nil
+}
+
+// NewMetadataServer creates the metadata server for the vms in the state root,
+// for providers without a metadata service.
+func NewMetadataServer(cfg Config, netSvc ports.NetworkService) *metadata.Server {
+	fsSvc := afero.NewOsFs()
+
+	return metadata.New(func(vmName string) (ports.StateService, error) {
+		return filesystem.NewStateService(vmName, cfg.StateRootPath, fsSvc)
+	}, netSvc)
+}
@@ -0,0 +1,320 @@
+package flintlock
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/defaults"
+)
+
+const (
+	// LabelNamespace is the label used to store the flintlock namespace of a vm.
+	LabelNamespace = "flintlock/namespace"
+	// LabelID is the label used to store the flintlock id of a vm.
+	LabelID = "flintlock/id"
+
+	// defaultKernelFilename is the kernel file flintlock uses if none is given.
+	defaultKernelFilename = "boot/vmlinux"
+	// defaultInitrdFilename is the initrd file used if none is given, like the
+	// cli's default.
+	defaultInitrdFilename = "initrd.img"
+	// microVMVersion is the version of the microvm spec that is returned.
+	microVMVersion = 1
+)
+
+var validNamePart = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// vmName returns the mikrolite vm name for the flintlock namespace and id. The
+// vm name is used as the flintlock uid.
+func vmName(namespace string, id string) (string, error) {
+	if !validNamePart.MatchString(namespace) {
+		return "", status.Errorf(codes.InvalidArgument, "invalid namespace %q", namespace)
+	}
+	if !validNamePart.MatchString(id) {
+		return "", status.Errorf(codes.InvalidArgument, "invalid id %q", id)
+	}
+
+	return fmt.Sprintf("%s-%s", namespace, id), nil
+}
+
+// vmNameFromUID returns the mikrolite vm name for a flintlock uid. The uid is
+// checked like the namespace and id it was made from.
+func vmNameFromUID(uid string) (string, error) {
+	if uid == "" {
+		return "", status.Error(codes.InvalidArgument, "uid is required")
+	}
+
+	namespace, id, ok := strings.Cut(uid, "-")
+	if !ok {
+		return "", status.Errorf(codes.InvalidArgument, "invalid uid %q", uid)
+	}
+
+	return vmName(namespace, id)
+}
+
+// toVMSpec converts a flintlock microvm spec to a vm spec.
+func toVMSpec(spec *MicroVMSpec) (*domain.VMSpec, error) {
+	if spec.Kernel == nil || spec.Kernel.Image == "" {
+		return nil, status.Error(codes.InvalidArgument, "kernel image is required")
+	}
+	if spec.Initrd != nil && spec.Initrd.Image == "" {
+		return nil, status.Error(codes.InvalidArgument, "initrd image is required")
+	}
+	if spec.RootVolume == nil {
+		return nil, status.Error(codes.InvalidArgument, "root volume is required")
+	}
+
+	vmSpec := &domain.VMSpec{
+		VCPU:       int(spec.VCPU),
+		MemoryInMb: int(spec.MemoryInMb),
+		Kernel: domain.Kernel{
+			Source: domain.KernelSource{
+				Filename: spec.Kernel.Filename,
+				Container: &domain.ContainerKernelSource{
+					Image: spec.Kernel.Image,
+				},
+			},
+			CmdLine: domain.KernelCmdLineFromMap(spec.Kernel.Cmdline),
+		},
+		NetworkConfiguration: domain.NetworkConfiguration{
+			BridgeName: defaults.SharedBridgeName,
+			Interfaces: map[string]domain.NetwortInterface{},
+		},
+		Labels: map[string]string{
+			LabelNamespace: spec.Namespace,
+			LabelID:        spec.ID,
+		},
+		Metadata: spec.Metadata,
+	}
+	if vmSpec.Kernel.Source.Filename == "" {
+		vmSpec.Kernel.Source.Filename = defaultKernelFilename
+	}
+	if spec.Initrd != nil {
+		vmSpec.Kernel.Initrd = &domain.KernelSource{
+			Filename: spec.Initrd.Filename,
+			Container: &domain.ContainerKernelSource{
+				Image: spec.Initrd.Image,
+			},
+		}
+		if vmSpec.Kernel.Initrd.Filename == "" {
+			vmSpec.Kernel.Initrd.Filename = defaultInitrdFilename
+		}
+	}
+	for k, v := range spec.Labels {
+		if k == LabelNamespace || k == LabelID {
+			continue
+		}
+		vmSpec.Labels[k] = v
+	}
+
+	rootVolume, err := toVolume(spec.RootVolume)
+	if err != nil {
+		return nil, err
+	}
+	vmSpec.RootVolume = *rootVolume
+
+	for _, vol := range spec.AdditionalVolumes {
+		volume, err := toVolume(vol)
+		if err != nil {
+			return nil, err
+		}
+		vmSpec.AdditionalVolumes = append(vmSpec.AdditionalVolumes, *volume)
+	}
+
+	bridgeName := ""
+	for _, iface := range spec.Interfaces {
+		if iface.DeviceID == "" {
+			return nil, status.Error(codes.InvalidArgument, "network interface device id is required")
+		}
+
+		// Mikrolite only creates tap devices attached to the bridge, so macvtap
+		// interfaces are created as taps as well.
+		netInt := domain.NetwortInterface{
+			GuestDeviceName: iface.DeviceID,
+			AttachToBridge:  true,
+			GuestMAC:        iface.GuestMAC,
+		}
+		if iface.Address != nil && iface.Address.Address != "" {
+			netInt.StaticIPv4Address = &domain.StaticIPv4Address{
+				Address:     iface.Address.Address,
+				Nameservers: iface.Address.Nameservers,
+			}
+			if iface.Address.Gateway != "" {
+				gateway := iface.Address.Gateway
+				netInt.StaticIPv4Address.Gateway = &gateway
+			}
+		}
+		if iface.Overrides != nil && iface.Overrides.BridgeName != "" {
+			if bridgeName != "" && bridgeName != iface.Overrides.BridgeName {
+				return nil, status.Error(codes.Unimplemented, "interfaces on different bridges aren't supported")
+			}
+			bridgeName = iface.Overrides.BridgeName
+		}
+
+		vmSpec.NetworkConfiguration.Interfaces[iface.DeviceID] = netInt
+	}
+	if bridgeName != "" {
+		vmSpec.NetworkConfiguration.BridgeName = bridgeName
+	}
+
+	return vmSpec, nil
+}
+
+func toVolume(vol *Volume) (*domain.Volume, error) {
+	if vol.ID == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is required")
+	}
+	if vol.Source == nil || vol.Source.ContainerSource == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %s: only container sources are supported", vol.ID)
+	}
+	if vol.IsReadOnly {
+		return nil, status.Errorf(codes.Unimplemented, "volume %s: read only volumes aren't supported", vol.ID)
+	}
+	if vol.MountPoint != "" || vol.SizeInMb != 0 {
+		slog.Debug("ignoring volume mount point and size", "volume", vol.ID)
+	}
+
+	return &domain.Volume{
+		Name: vol.ID,
+		Source: domain.VolumeSource{
+			Container: &domain.ContainerVolumeSource{
+				Image: vol.Source.ContainerSource,
+			},
+		},
+	}, nil
+}
+
+// fromVM converts a vm to a flintlock microvm.
+func fromVM(vm *domain.VM) *MicroVM {
+	spec := &MicroVMSpec{
+		ID:         vm.Name,
+		VCPU:       int32(vm.Spec.VCPU),
+		MemoryInMb: int32(vm.Spec.MemoryInMb),
+		Kernel: &Kernel{
+			Cmdline:  vm.Spec.Kernel.CmdLine.Map(),
+			Filename: vm.Spec.Kernel.Source.Filename,
+		},
+		RootVolume: fromVolume(vm.Spec.RootVolume),
+		Metadata:   vm.Spec.Metadata,
+		UID:        vm.Name,
+	}
+	if vm.Spec.Kernel.Source.Container != nil {
+		spec.Kernel.Image = vm.Spec.Kernel.Source.Container.Image
+	}
+	if initrd := vm.Spec.Kernel.Initrd; initrd != nil && initrd.Container != nil {
+		spec.Initrd = &Initrd{
+			Image:    initrd.Container.Image,
+			Filename: initrd.Filename,
+		}
+	}
+	for k, v := range vm.Spec.Labels {
+		switch k {
+		case LabelNamespace:
+			spec.Namespace = v
+		case LabelID:
+			spec.ID = v
+		default:
+			if spec.Labels == nil {
+				spec.Labels = map[string]string{}
+			}
+			spec.Labels[k] = v
+		}
+	}
+	for _, vol := range vm.Spec.AdditionalVolumes {
+		spec.AdditionalVolumes = append(spec.AdditionalVolumes, fromVolume(vol))
+	}
+
+	names := []string{}
+	for name := range vm.Spec.NetworkConfiguration.Interfaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		netInt := vm.Spec.NetworkConfiguration.Interfaces[name]
+		iface := &NetworkInterface{
+			DeviceID: netInt.GuestDeviceName,
+			Type:     IfaceTypeTap,
+			GuestMAC: netInt.GuestMAC,
+			Overrides: &NetworkOverrides{
+				BridgeName: vm.Spec.NetworkConfiguration.BridgeName,
+			},
+		}
+		if netInt.StaticIPv4Address != nil {
+			iface.Address = &StaticAddress{
+				Address:     netInt.StaticIPv4Address.Address,
+				Nameservers: netInt.StaticIPv4Address.Nameservers,
+			}
+			if netInt.StaticIPv4Address.Gateway != nil {
+				iface.Address.Gateway = *netInt.StaticIPv4Address.Gateway
+			}
+		}
+		spec.Interfaces = append(spec.Interfaces, iface)
+	}
+
+	return &MicroVM{
+		Version: microVMVersion,
+		Spec:    spec,
+		Status:  fromVMStatus(vm.Status),
+	}
+}
+
+func fromVolume(vol domain.Volume) *Volume {
+	volume := &Volume{
+		ID: vol.Name,
+	}
+	if vol.Source.Container != nil {
+		volume.Source = &VolumeSource{
+			ContainerSource: vol.Source.Container.Image,
+		}
+	}
+
+	return volume
+}
+
+func fromVMStatus(vmStatus *domain.VMStatus) *MicroVMStatus {
+	if vmStatus == nil {
+		return &MicroVMStatus{State: StatePending}
+	}
+
+	st := &MicroVMStatus{
+		State:             StateCreated,
+		Volumes:           map[string]*VolumeStatus{},
+		NetworkInterfaces: map[string]*NetworkInterfaceStatus{},
+	}
+	for name, mount := range vmStatus.VolumeMounts {
+		st.Volumes[name] = &VolumeStatus{Mount: fromMount(mount)}
+	}
+	if vmStatus.KernelMount != nil {
+		st.KernelMount = fromMount(*vmStatus.KernelMount)
+	}
+	if vmStatus.InitrdMount != nil {
+		st.InitrdMount = fromMount(*vmStatus.InitrdMount)
+	}
+	for name, netStatus := range vmStatus.NetworkStatus {
+		st.NetworkInterfaces[name] = &NetworkInterfaceStatus{
+			HostDeviceName: netStatus.HostDeviveName,
+			MACAddress:     netStatus.GuestMAC,
+		}
+	}
+
+	return st
+}
+
+func fromMount(mount domain.Mount) *Mount {
+	mountType := MountTypeHostPath
+	if mount.Type == domain.MountTypeBlockDevice {
+		mountType = MountTypeDev
+	}
+
+	return &Mount{
+		Type:   mountType,
+		Source: mount.Location,
+	}
+}
@@ -0,0 +1,293 @@
+package flintlock
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// The flintlock protos are built at runtime as there's no protoc in the build.
+// The messages, field names and numbers match the flintlock v1alpha1 api so
+// that the wire format is the same. Fields that mikrolite doesn't use (e.g. the
+// timestamps) are left out and are ignored if sent by a client.
+
+const (
+	typesPackage    = "flintlock.types"
+	servicesPackage = "microvm.services.api.v1alpha1"
+)
+
+var (
+	typeString = descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+	typeInt32  = descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum()
+	typeBool   = descriptorpb.FieldDescriptorProto_TYPE_BOOL.Enum()
+	typeEnum   = descriptorpb.FieldDescriptorProto_TYPE_ENUM.Enum()
+	typeMsg    = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+
+	labelOptional = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	labelRepeated = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+)
+
+// files holds the flintlock file descriptors.
+var files = mustBuildFiles()
+
+func mustBuildFiles() *protoregistry.Files {
+	registry := &protoregistry.Files{}
+
+	for _, fdp := range []*descriptorpb.FileDescriptorProto{typesFile(), servicesFile()} {
+		fd, err := protodesc.NewFile(fdp, registry)
+		if err != nil {
+			panic(fmt.Sprintf("building flintlock descriptor %s: %s", fdp.GetName(), err))
+		}
+		if err := registry.RegisterFile(fd); err != nil {
+			panic(fmt.Sprintf("registering flintlock descriptor %s: %s", fdp.GetName(), err))
+		}
+	}
+
+	return registry
+}
+
+// messageDescriptor returns the descriptor for the fully qualified message name.
+func messageDescriptor(name string) protoreflect.MessageDescriptor {
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		panic(fmt.Sprintf("finding flintlock message %s: %s", name, err))
+	}
+
+	return desc.(protoreflect.MessageDescriptor)
+}
+
+func typesFile() *descriptorpb.FileDescriptorProto {
+	return &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("types/microvm.proto"),
+		Package: proto.String(typesPackage),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			message("MicroVM",
+				scalar("version", 1, typeInt32),
+				msgField("spec", 2, ".flintlock.types.MicroVMSpec"),
+				msgField("status", 3, ".flintlock.types.MicroVMStatus"),
+			),
+			withNested(message("MicroVMSpec",
+				scalar("id", 1, typeString),
+				scalar("namespace", 2, typeString),
+				mapField("labels", 3, ".flintlock.types.MicroVMSpec.LabelsEntry"),
+				scalar("vcpu", 4, typeInt32),
+				scalar("memory_in_mb", 5, typeInt32),
+				msgField("kernel", 6, ".flintlock.types.Kernel"),
+				msgField("initrd", 7, ".flintlock.types.Initrd"),
+				msgField("root_volume", 8, ".flintlock.types.Volume"),
+				repeatedMsgField("additional_volumes", 9, ".flintlock.types.Volume"),
+				repeatedMsgField("interfaces", 10, ".flintlock.types.NetworkInterface"),
+				mapField("metadata", 11, ".flintlock.types.MicroVMSpec.MetadataEntry"),
+				scalar("uid", 15, typeString),
+				scalar("provider", 16, typeString),
+			), stringMapEntry("LabelsEntry"), stringMapEntry("MetadataEntry")),
+			withNested(message("Kernel",
+				scalar("image", 1, typeString),
+				mapField("cmdline", 2, ".flintlock.types.Kernel.CmdlineEntry"),
+				scalar("filename", 3, typeString),
+				scalar("add_network_config", 4, typeBool),
+			), stringMapEntry("CmdlineEntry")),
+			message("Initrd",
+				scalar("image", 1, typeString),
+				scalar("filename", 2, typeString),
+			),
+			withEnums(message("NetworkInterface",
+				scalar("device_id", 1, typeString),
+				enumField("type", 2, ".flintlock.types.NetworkInterface.IfaceType"),
+				scalar("guest_mac", 3, typeString),
+				msgField("address", 4, ".flintlock.types.StaticAddress"),
+				msgField("overrides", 5, ".flintlock.types.NetworkOverrides"),
+			), enum("IfaceType", "MACVTAP", "TAP", "UNSUPPORTED")),
+			message("StaticAddress",
+				scalar("address", 1, typeString),
+				scalar("gateway", 2, typeString),
+				repeatedScalar("nameservers", 3, typeString),
+			),
+			message("NetworkOverrides",
+				scalar("bridge_name", 1, typeString),
+			),
+			message("Volume",
+				scalar("id", 1, typeString),
+				scalar("is_read_only", 2, typeBool),
+				scalar("mount_point", 3, typeString),
+				msgField("source", 4, ".flintlock.types.VolumeSource"),
+				scalar("size_in_mb", 5, typeInt32),
+			),
+			message("VolumeSource",
+				scalar("container_source", 1, typeString),
+			),
+			withEnums(withNested(message("MicroVMStatus",
+				enumField("state", 1, ".flintlock.types.MicroVMStatus.MicroVMState"),
+				mapField("volumes", 2, ".flintlock.types.MicroVMStatus.VolumesEntry"),
+				msgField("kernel_mount", 3, ".flintlock.types.Mount"),
+				msgField("initrd_mount", 4, ".flintlock.types.Mount"),
+				mapField("network_interfaces", 5, ".flintlock.types.MicroVMStatus.NetworkInterfacesEntry"),
+				scalar("retry", 6, typeInt32),
+			),
+				mapEntry("VolumesEntry", msgField("value", 2, ".flintlock.types.VolumeStatus")),
+				mapEntry("NetworkInterfacesEntry", msgField("value", 2, ".flintlock.types.NetworkInterfaceStatus")),
+			), enum("MicroVMState", "PENDING", "CREATED", "FAILED", "DELETING")),
+			message("VolumeStatus",
+				msgField("mount", 1, ".flintlock.types.Mount"),
+			),
+			withEnums(message("Mount",
+				enumField("type", 1, ".flintlock.types.Mount.MountType"),
+				scalar("source", 2, typeString),
+			), enum("MountType", "HOSTPATH", "DEV")),
+			message("NetworkInterfaceStatus",
+				scalar("host_device_name", 1, typeString),
+				scalar("index", 2, typeInt32),
+				scalar("mac_address", 3, typeString),
+			),
+		},
+	}
+}
+
+func servicesFile() *descriptorpb.FileDescriptorProto {
+	return &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("services/microvm/v1alpha1/microvms.proto"),
+		Package:    proto.String(servicesPackage),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"types/microvm.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{
+			message("CreateMicroVMRequest",
+				msgField("microvm", 1, ".flintlock.types.MicroVMSpec"),
+			),
+			message("CreateMicroVMResponse",
+				msgField("microvm", 1, ".flintlock.types.MicroVM"),
+			),
+			message("DeleteMicroVMRequest",
+				scalar("uid", 1, typeString),
+			),
+			message("GetMicroVMRequest",
+				scalar("uid", 1, typeString),
+			),
+			message("GetMicroVMResponse",
+				msgField("microvm", 1, ".flintlock.types.MicroVM"),
+			),
+			message("ListMicroVMsRequest",
+				scalar("namespace", 1, typeString),
+				scalar("name", 2, typeString),
+			),
+			message("ListMicroVMsResponse",
+				repeatedMsgField("microvm", 1, ".flintlock.types.MicroVM"),
+			),
+			message("ListMessage",
+				msgField("microvm", 1, ".flintlock.types.MicroVM"),
+			),
+		},
+	}
+}
+
+func message(name string, fields ...*descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+	return &descriptorpb.DescriptorProto{
+		Name:  proto.String(name),
+		Field: fields,
+	}
+}
+
+func withNested(msg *descriptorpb.DescriptorProto, nested ...*descriptorpb.DescriptorProto) *descriptorpb.DescriptorProto {
+	msg.NestedType = append(msg.NestedType, nested...)
+
+	return msg
+}
+
+func withEnums(msg *descriptorpb.DescriptorProto, enums ...*descriptorpb.EnumDescriptorProto) *descriptorpb.DescriptorProto {
+	msg.EnumType = append(msg.EnumType, enums...)
+
+	return msg
+}
+
+func enum(name string, values ...string) *descriptorpb.EnumDescriptorProto {
+	e := &descriptorpb.EnumDescriptorProto{
+		Name: proto.String(name),
+	}
+	for i, value := range values {
+		e.Value = append(e.Value, &descriptorpb.EnumValueDescriptorProto{
+			Name:   proto.String(value),
+			Number: proto.Int32(int32(i)),
+		})
+	}
+
+	return e
+}
+
+func scalar(name string, number int32, typ *descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+	return &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		Number:   proto.Int32(number),
+		Label:    labelOptional,
+		Type:     typ,
+		JsonName: proto.String(jsonName(name)),
+	}
+}
+
+func repeatedScalar(name string, number int32, typ *descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+	field := scalar(name, number, typ)
+	field.Label = labelRepeated
+
+	return field
+}
+
+func enumField(name string, number int32, typeName string) *descriptorpb.FieldDescriptorProto {
+	field := scalar(name, number, typeEnum)
+	field.TypeName = proto.String(typeName)
+
+	return field
+}
+
+func msgField(name string, number int32, typeName string) *descriptorpb.FieldDescriptorProto {
+	field := scalar(name, number, typeMsg)
+	field.TypeName = proto.String(typeName)
+
+	return field
+}
+
+func repeatedMsgField(name string, number int32, typeName string) *descriptorpb.FieldDescriptorProto {
+	field := msgField(name, number, typeName)
+	field.Label = labelRepeated
+
+	return field
+}
+
+// mapField is a map field, the entry type must be nested in the parent message.
+func mapField(name string, number int32, entryTypeName string) *descriptorpb.FieldDescriptorProto {
+	return repeatedMsgField(name, number, entryTypeName)
+}
+
+func stringMapEntry(name string) *descriptorpb.DescriptorProto {
+	return mapEntry(name, scalar("value", 2, typeString))
+}
+
+func mapEntry(name string, value *descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+	return &descriptorpb.DescriptorProto{
+		Name:    proto.String(name),
+		Field:   []*descriptorpb.FieldDescriptorProto{scalar("key", 1, typeString), value},
+		Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+	}
+}
+
+// jsonName converts a field name to lower camel case, which is what protoc does.
+func jsonName(name string) string {
+	out := []byte{}
+	upper := false
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c == '_' {
+			upper = true
+			continue
+		}
+		if upper && c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		upper = false
+		out = append(out, c)
+	}
+
+	return string(out)
+}
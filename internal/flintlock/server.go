@@ -0,0 +1,209 @@
+// Package flintlock serves the liquidmetal flintlock MicroVM grpc api, so that
+// tooling written for flintlock (e.g. the cluster api provider) can be used
+// with mikrolite. The operations are passed to the mikrolited api.
+package flintlock
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/mikrolite/mikrolite/api/v1alpha1"
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+const (
+	// ServiceName is the full name of the flintlock microvm service.
+	ServiceName = servicesPackage + ".MicroVM"
+)
+
+// RegisterMicroVMServer registers the flintlock microvm service with the grpc
+// server. The operations are run using the mikrolited api.
+func RegisterMicroVMServer(s grpc.ServiceRegistrar, api v1alpha1.VMServiceServer) {
+	s.RegisterService(&microVMServiceDesc, &server{api: api})
+}
+
+type server struct {
+	api v1alpha1.VMServiceServer
+}
+
+var microVMServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "CreateMicroVM", Handler: unaryHandler(msgCreateMicroVMRequest, (*server).createMicroVM)},
+		{MethodName: "DeleteMicroVM", Handler: unaryHandler(msgDeleteMicroVMRequest, (*server).deleteMicroVM)},
+		{MethodName: "GetMicroVM", Handler: unaryHandler(msgGetMicroVMRequest, (*server).getMicroVM)},
+		{MethodName: "ListMicroVMs", Handler: unaryHandler(msgListMicroVMsRequest, (*server).listMicroVMs)},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListMicroVMsStream",
+			Handler:       listMicroVMsStreamHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "services/microvm/v1alpha1/microvms.proto",
+}
+
+// unaryHandler creates the grpc handler for a unary method. The request is
+// decoded as a dynamic message of the named type.
+func unaryHandler(requestType string, call func(s *server, ctx context.Context, req *dynamicpb.Message) (interface{}, error)) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		req := newMessage(requestType)
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(*server), ctx, req)
+		}
+
+		info := &grpc.UnaryServerInfo{
+			Server: srv,
+		}
+		if method, ok := grpc.Method(ctx); ok {
+			info.FullMethod = method
+		}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return call(srv.(*server), ctx, req.(*dynamicpb.Message))
+		}
+
+		return interceptor(ctx, req, info, handler)
+	}
+}
+
+func (s *server) createMicroVM(ctx context.Context, msg *dynamicpb.Message) (interface{}, error) {
+	req := &CreateMicroVMRequest{}
+	if err := fromMessage(msg, req); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if req.MicroVM == nil {
+		return nil, status.Error(codes.InvalidArgument, "microvm spec is required")
+	}
+
+	name, err := vmName(req.MicroVM.Namespace, req.MicroVM.ID)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := toVMSpec(req.MicroVM)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.api.CreateVM(ctx, &v1alpha1.CreateVMRequest{
+		Name: name,
+		Spec: spec,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return toMessage(msgCreateMicroVMResponse, &CreateMicroVMResponse{MicroVM: fromVM(resp.VM)})
+}
+
+func (s *server) deleteMicroVM(ctx context.Context, msg *dynamicpb.Message) (interface{}, error) {
+	req := &DeleteMicroVMRequest{}
+	if err := fromMessage(msg, req); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	name, err := vmNameFromUID(req.UID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.api.RemoveVM(ctx, &v1alpha1.RemoveVMRequest{Name: name}); err != nil {
+		return nil, err
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *server) getMicroVM(ctx context.Context, msg *dynamicpb.Message) (interface{}, error) {
+	req := &GetMicroVMRequest{}
+	if err := fromMessage(msg, req); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	name, err := vmNameFromUID(req.UID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.api.GetVM(ctx, &v1alpha1.GetVMRequest{Name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	return toMessage(msgGetMicroVMResponse, &GetMicroVMResponse{MicroVM: fromVM(resp.VM)})
+}
+
+func (s *server) listMicroVMs(ctx context.Context, msg *dynamicpb.Message) (interface{}, error) {
+	vms, err := s.list(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &ListMicroVMsResponse{}
+	for _, vm := range vms {
+		resp.MicroVM = append(resp.MicroVM, fromVM(vm))
+	}
+
+	return toMessage(msgListMicroVMsResponse, resp)
+}
+
+func listMicroVMsStreamHandler(srv interface{}, stream grpc.ServerStream) error {
+	msg := newMessage(msgListMicroVMsRequest)
+	if err := stream.RecvMsg(msg); err != nil {
+		return err
+	}
+
+	vms, err := srv.(*server).list(stream.Context(), msg)
+	if err != nil {
+		return err
+	}
+
+	for _, vm := range vms {
+		resp, err := toMessage(msgListMessage, &ListMessage{MicroVM: fromVM(vm)})
+		if err != nil {
+			return err
+		}
+		if err := stream.SendMsg(resp); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// list returns the vms created using the flintlock api that match the request.
+func (s *server) list(ctx context.Context, msg *dynamicpb.Message) ([]*domain.VM, error) {
+	req := &ListMicroVMsRequest{}
+	if err := fromMessage(msg, req); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	resp, err := s.api.ListVMs(ctx, &v1alpha1.ListVMsRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	vms := []*domain.VM{}
+	for _, vm := range resp.VMs {
+		namespace, ok := vm.Spec.Labels[LabelNamespace]
+		if !ok {
+			continue
+		}
+		if req.Namespace != "" && req.Namespace != namespace {
+			continue
+		}
+		if req.Name != "" && req.Name != vm.Spec.Labels[LabelID] {
+			continue
+		}
+		vms = append(vms, vm)
+	}
+
+	return vms, nil
+}
@@ -0,0 +1,411 @@
+package flintlock
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/mikrolite/mikrolite/api/v1alpha1"
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+// fakeAPI is an in-memory mikrolited api.
+type fakeAPI struct {
+	v1alpha1.VMServiceServer
+
+	vms map[string]*domain.VM
+}
+
+func (f *fakeAPI) CreateVM(ctx context.Context, req *v1alpha1.CreateVMRequest) (*v1alpha1.VMResponse, error) {
+	vm := &domain.VM{
+		Name: req.Name,
+		Spec: *req.Spec,
+		Status: &domain.VMStatus{
+			NetworkStatus: map[string]domain.NetworkStatus{},
+		},
+	}
+	f.vms[req.Name] = vm
+
+	return &v1alpha1.VMResponse{VM: vm}, nil
+}
+
+func (f *fakeAPI) RemoveVM(ctx context.Context, req *v1alpha1.RemoveVMRequest) (*v1alpha1.Empty, error) {
+	if _, ok := f.vms[req.Name]; !ok {
+		return nil, status.Error(codes.NotFound, "vm not found")
+	}
+	delete(f.vms, req.Name)
+
+	return &v1alpha1.Empty{}, nil
+}
+
+func (f *fakeAPI) GetVM(ctx context.Context, req *v1alpha1.GetVMRequest) (*v1alpha1.VMResponse, error) {
+	vm, ok := f.vms[req.Name]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "vm not found")
+	}
+
+	return &v1alpha1.VMResponse{VM: vm}, nil
+}
+
+func (f *fakeAPI) ListVMs(ctx context.Context, req *v1alpha1.ListVMsRequest) (*v1alpha1.ListVMsResponse, error) {
+	resp := &v1alpha1.ListVMsResponse{}
+	for _, vm := range f.vms {
+		resp.VMs = append(resp.VMs, vm)
+	}
+	sort.Slice(resp.VMs, func(i, j int) bool { return resp.VMs[i].Name < resp.VMs[j].Name })
+
+	return resp, nil
+}
+
+func newTestClient(t *testing.T) (*grpc.ClientConn, *fakeAPI) {
+	t.Helper()
+
+	api := &fakeAPI{vms: map[string]*domain.VM{}}
+
+	socket := filepath.Join(t.TempDir(), "flintlock.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("listening: %s", err)
+	}
+
+	s := grpc.NewServer()
+	RegisterMicroVMServer(s, api)
+	go s.Serve(listener) //nolint:errcheck
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dialing: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn, api
+}
+
+func invoke(t *testing.T, conn *grpc.ClientConn, method string, reqType string, req interface{}, respType string, resp interface{}) error {
+	t.Helper()
+
+	in, err := toMessage(reqType, req)
+	if err != nil {
+		t.Fatalf("converting request: %s", err)
+	}
+	out := newMessage(respType)
+	if err := conn.Invoke(context.Background(), "/"+ServiceName+"/"+method, in, out); err != nil {
+		return err
+	}
+	if err := fromMessage(out, resp); err != nil {
+		t.Fatalf("converting response: %s", err)
+	}
+
+	return nil
+}
+
+func testMicroVMSpec(namespace, id string) *MicroVMSpec {
+	return &MicroVMSpec{
+		ID:         id,
+		Namespace:  namespace,
+		Labels:     map[string]string{"env": "test"},
+		VCPU:       2,
+		MemoryInMb: 2048,
+		Kernel: &Kernel{
+			Image:   "ghcr.io/test/kernel:latest",
+			Cmdline: map[string]string{"console": "ttyS0"},
+		},
+		RootVolume: &Volume{
+			ID:     "root",
+			Source: &VolumeSource{ContainerSource: "ghcr.io/test/root:latest"},
+		},
+		Interfaces: []*NetworkInterface{
+			{
+				DeviceID: "eth0",
+				Type:     IfaceTypeTap,
+				GuestMAC: "AA:FF:00:00:00:01",
+				Address: &StaticAddress{
+					Address: "10.0.0.2/24",
+					Gateway: "10.0.0.1",
+				},
+			},
+		},
+		Metadata: map[string]string{"meta-data": "aW5zdGFuY2VfaWQ6IHRlc3Q="},
+	}
+}
+
+func TestCreateGetDeleteMicroVM(t *testing.T) {
+	conn, api := newTestClient(t)
+
+	spec := testMicroVMSpec("ns1", "vm1")
+	spec.Initrd = &Initrd{Image: "ghcr.io/test/initrd:latest"}
+
+	createResp := &CreateMicroVMResponse{}
+	err := invoke(t, conn, "CreateMicroVM",
+		msgCreateMicroVMRequest, &CreateMicroVMRequest{MicroVM: spec},
+		msgCreateMicroVMResponse, createResp)
+	if err != nil {
+		t.Fatalf("creating microvm: %s", err)
+	}
+
+	vm, ok := api.vms["ns1-vm1"]
+	if !ok {
+		t.Fatalf("expected vm ns1-vm1 to be created")
+	}
+	if vm.Spec.Kernel.Source.Filename != defaultKernelFilename {
+		t.Errorf("expected kernel filename %s, got %s", defaultKernelFilename, vm.Spec.Kernel.Source.Filename)
+	}
+	initrd := vm.Spec.Kernel.Initrd
+	if initrd == nil || initrd.Container == nil || initrd.Container.Image != "ghcr.io/test/initrd:latest" || initrd.Filename != defaultInitrdFilename {
+		t.Errorf("expected the initrd to be passed to the vm spec, got %+v", initrd)
+	}
+	if vm.Spec.NetworkConfiguration.Interfaces["eth0"].GuestMAC != "AA:FF:00:00:00:01" {
+		t.Errorf("expected guest mac to be passed to the vm spec")
+	}
+	if vm.Spec.Labels[LabelNamespace] != "ns1" || vm.Spec.Labels[LabelID] != "vm1" || vm.Spec.Labels["env"] != "test" {
+		t.Errorf("unexpected labels %v", vm.Spec.Labels)
+	}
+	if vm.Spec.Metadata["meta-data"] == "" {
+		t.Errorf("expected metadata to be passed to the vm spec")
+	}
+
+	created := createResp.MicroVM
+	if created == nil || created.Spec == nil || created.Status == nil {
+		t.Fatalf("expected microvm in the response, got %+v", createResp)
+	}
+	if created.Spec.UID != "ns1-vm1" || created.Spec.ID != "vm1" || created.Spec.Namespace != "ns1" {
+		t.Errorf("unexpected microvm identity %+v", created.Spec)
+	}
+	if created.Status.State != StateCreated {
+		t.Errorf("expected state %s, got %s", StateCreated, created.Status.State)
+	}
+
+	getResp := &GetMicroVMResponse{}
+	err = invoke(t, conn, "GetMicroVM",
+		msgGetMicroVMRequest, &GetMicroVMRequest{UID: "ns1-vm1"},
+		msgGetMicroVMResponse, getResp)
+	if err != nil {
+		t.Fatalf("getting microvm: %s", err)
+	}
+	if getResp.MicroVM == nil || getResp.MicroVM.Spec.Kernel.Image != "ghcr.io/test/kernel:latest" {
+		t.Errorf("unexpected microvm %+v", getResp.MicroVM)
+	}
+	if initrd := getResp.MicroVM.Spec.Initrd; initrd == nil || initrd.Image != "ghcr.io/test/initrd:latest" || initrd.Filename != defaultInitrdFilename {
+		t.Errorf("expected the initrd in the microvm, got %+v", initrd)
+	}
+
+	in, _ := toMessage(msgDeleteMicroVMRequest, &DeleteMicroVMRequest{UID: "ns1-vm1"})
+	if err := conn.Invoke(context.Background(), "/"+ServiceName+"/DeleteMicroVM", in, &emptypb.Empty{}); err != nil {
+		t.Fatalf("deleting microvm: %s", err)
+	}
+	if _, ok := api.vms["ns1-vm1"]; ok {
+		t.Errorf("expected vm to be removed")
+	}
+
+	err = invoke(t, conn, "GetMicroVM",
+		msgGetMicroVMRequest, &GetMicroVMRequest{UID: "ns1-vm1"},
+		msgGetMicroVMResponse, &GetMicroVMResponse{})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
+
+func TestDeleteMicroVMInvalid(t *testing.T) {
+	conn, api := newTestClient(t)
+	api.vms["ns1-vm1"] = &domain.VM{Name: "ns1-vm1"}
+
+	testCases := []struct {
+		name       string
+		uid        string
+		expectCode codes.Code
+	}{
+		{name: "unknown uid", uid: "ns1-vm2", expectCode: codes.NotFound},
+		{name: "uid required", uid: "", expectCode: codes.InvalidArgument},
+		{name: "uid without namespace", uid: "vm1", expectCode: codes.InvalidArgument},
+		{name: "uid outside of the state root", uid: "../x", expectCode: codes.InvalidArgument},
+		{name: "uid with a path", uid: "ns1-vm1/../../x", expectCode: codes.InvalidArgument},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			in, _ := toMessage(msgDeleteMicroVMRequest, &DeleteMicroVMRequest{UID: tc.uid})
+			err := conn.Invoke(context.Background(), "/"+ServiceName+"/DeleteMicroVM", in, &emptypb.Empty{})
+			if status.Code(err) != tc.expectCode {
+				t.Errorf("expected code %s, got %v", tc.expectCode, err)
+			}
+			if _, ok := api.vms["ns1-vm1"]; !ok {
+				t.Errorf("expected vm ns1-vm1 not to be removed")
+			}
+		})
+	}
+}
+
+func TestCreateMicroVMInvalid(t *testing.T) {
+	testCases := []struct {
+		name       string
+		spec       func() *MicroVMSpec
+		expectCode codes.Code
+	}{
+		{
+			name: "invalid namespace",
+			spec: func() *MicroVMSpec {
+				return testMicroVMSpec("", "vm1")
+			},
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			name: "kernel image required",
+			spec: func() *MicroVMSpec {
+				spec := testMicroVMSpec("ns1", "vm1")
+				spec.Kernel.Image = ""
+				return spec
+			},
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			name: "initrd image required",
+			spec: func() *MicroVMSpec {
+				spec := testMicroVMSpec("ns1", "vm1")
+				spec.Initrd = &Initrd{Filename: "boot/initrd"}
+				return spec
+			},
+			expectCode: codes.InvalidArgument,
+		},
+		{
+			name: "read only volume unsupported",
+			spec: func() *MicroVMSpec {
+				spec := testMicroVMSpec("ns1", "vm1")
+				spec.AdditionalVolumes = []*Volume{{
+					ID:         "data",
+					IsReadOnly: true,
+					Source:     &VolumeSource{ContainerSource: "ghcr.io/test/data:latest"},
+				}}
+				return spec
+			},
+			expectCode: codes.Unimplemented,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, api := newTestClient(t)
+
+			err := invoke(t, conn, "CreateMicroVM",
+				msgCreateMicroVMRequest, &CreateMicroVMRequest{MicroVM: tc.spec()},
+				msgCreateMicroVMResponse, &CreateMicroVMResponse{})
+			if status.Code(err) != tc.expectCode {
+				t.Fatalf("expected code %s, got %v", tc.expectCode, err)
+			}
+			if len(api.vms) != 0 {
+				t.Errorf("expected no vms to be created")
+			}
+		})
+	}
+}
+
+func TestListMicroVMs(t *testing.T) {
+	conn, api := newTestClient(t)
+
+	for _, spec := range []*MicroVMSpec{testMicroVMSpec("ns1", "vm1"), testMicroVMSpec("ns1", "vm2"), testMicroVMSpec("ns2", "vm1")} {
+		err := invoke(t, conn, "CreateMicroVM",
+			msgCreateMicroVMRequest, &CreateMicroVMRequest{MicroVM: spec},
+			msgCreateMicroVMResponse, &CreateMicroVMResponse{})
+		if err != nil {
+			t.Fatalf("creating microvm: %s", err)
+		}
+	}
+	// Vms not created using the flintlock api aren't listed
+	api.vms["other"] = &domain.VM{Name: "other"}
+
+	testCases := []struct {
+		name   string
+		req    *ListMicroVMsRequest
+		expect []string
+	}{
+		{
+			name:   "all",
+			req:    &ListMicroVMsRequest{},
+			expect: []string{"ns1-vm1", "ns1-vm2", "ns2-vm1"},
+		},
+		{
+			name:   "namespace",
+			req:    &ListMicroVMsRequest{Namespace: "ns1"},
+			expect: []string{"ns1-vm1", "ns1-vm2"},
+		},
+		{
+			name:   "namespace and name",
+			req:    &ListMicroVMsRequest{Namespace: "ns2", Name: "vm1"},
+			expect: []string{"ns2-vm1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &ListMicroVMsResponse{}
+			if err := invoke(t, conn, "ListMicroVMs", msgListMicroVMsRequest, tc.req, msgListMicroVMsResponse, resp); err != nil {
+				t.Fatalf("listing microvms: %s", err)
+			}
+			uids := []string{}
+			for _, vm := range resp.MicroVM {
+				uids = append(uids, vm.Spec.UID)
+			}
+			assertUIDs(t, tc.expect, uids)
+
+			uids = listStream(t, conn, tc.req)
+			assertUIDs(t, tc.expect, uids)
+		})
+	}
+}
+
+func listStream(t *testing.T, conn *grpc.ClientConn, req *ListMicroVMsRequest) []string {
+	t.Helper()
+
+	desc := &grpc.StreamDesc{StreamName: "ListMicroVMsStream", ServerStreams: true}
+	stream, err := conn.NewStream(context.Background(), desc, "/"+ServiceName+"/ListMicroVMsStream")
+	if err != nil {
+		t.Fatalf("creating stream: %s", err)
+	}
+	in, _ := toMessage(msgListMicroVMsRequest, req)
+	if err := stream.SendMsg(in); err != nil {
+		t.Fatalf("sending request: %s", err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("closing send: %s", err)
+	}
+
+	uids := []string{}
+	for {
+		out := newMessage(msgListMessage)
+		err := stream.RecvMsg(out)
+		if errors.Is(err, io.EOF) {
+			return uids
+		}
+		if err != nil {
+			t.Fatalf("receiving: %s", err)
+		}
+		msg := &ListMessage{}
+		if err := fromMessage(out, msg); err != nil {
+			t.Fatalf("converting message: %s", err)
+		}
+		uids = append(uids, msg.MicroVM.Spec.UID)
+	}
+}
+
+func assertUIDs(t *testing.T, expect []string, actual []string) {
+	t.Helper()
+
+	if len(expect) != len(actual) {
+		t.Fatalf("expected %v, got %v", expect, actual)
+	}
+	for i := range expect {
+		if expect[i] != actual[i] {
+			t.Fatalf("expected %v, got %v", expect, actual)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package flintlock
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// The flintlock messages as Go types. The json names are the proto field names
+// so that the messages can be converted to and from the dynamic proto messages
+// using protojson.
+
+type MicroVM struct {
+	Version int32          `json:"version,omitempty"`
+	Spec    *MicroVMSpec   `json:"spec,omitempty"`
+	Status  *MicroVMStatus `json:"status,omitempty"`
+}
+
+type MicroVMSpec struct {
+	ID                string              `json:"id,omitempty"`
+	Namespace         string              `json:"namespace,omitempty"`
+	Labels            map[string]string   `json:"labels,omitempty"`
+	VCPU              int32               `json:"vcpu,omitempty"`
+	MemoryInMb        int32               `json:"memory_in_mb,omitempty"`
+	Kernel            *Kernel             `json:"kernel,omitempty"`
+	Initrd            *Initrd             `json:"initrd,omitempty"`
+	RootVolume        *Volume             `json:"root_volume,omitempty"`
+	AdditionalVolumes []*Volume           `json:"additional_volumes,omitempty"`
+	Interfaces        []*NetworkInterface `json:"interfaces,omitempty"`
+	Metadata          map[string]string   `json:"metadata,omitempty"`
+	UID               string              `json:"uid,omitempty"`
+	Provider          string              `json:"provider,omitempty"`
+}
+
+type Kernel struct {
+	Image            string            `json:"image,omitempty"`
+	Cmdline          map[string]string `json:"cmdline,omitempty"`
+	Filename         string            `json:"filename,omitempty"`
+	AddNetworkConfig bool              `json:"add_network_config,omitempty"`
+}
+
+type Initrd struct {
+	Image    string `json:"image,omitempty"`
+	Filename string `json:"filename,omitempty"`
+}
+
+const (
+	IfaceTypeMacvtap = "MACVTAP"
+	IfaceTypeTap     = "TAP"
+)
+
+type NetworkInterface struct {
+	DeviceID  string            `json:"device_id,omitempty"`
+	Type      string            `json:"type,omitempty"`
+	GuestMAC  string            `json:"guest_mac,omitempty"`
+	Address   *StaticAddress    `json:"address,omitempty"`
+	Overrides *NetworkOverrides `json:"overrides,omitempty"`
+}
+
+type StaticAddress struct {
+	Address     string   `json:"address,omitempty"`
+	Gateway     string   `json:"gateway,omitempty"`
+	Nameservers []string `json:"nameservers,omitempty"`
+}
+
+type NetworkOverrides struct {
+	BridgeName string `json:"bridge_name,omitempty"`
+}
+
+type Volume struct {
+	ID         string        `json:"id,omitempty"`
+	IsReadOnly bool          `json:"is_read_only,omitempty"`
+	MountPoint string        `json:"mount_point,omitempty"`
+	Source     *VolumeSource `json:"source,omitempty"`
+	SizeInMb   int32         `json:"size_in_mb,omitempty"`
+}
+
+type VolumeSource struct {
+	ContainerSource string `json:"container_source,omitempty"`
+}
+
+const (
+	StatePending  = "PENDING"
+	StateCreated  = "CREATED"
+	StateFailed   = "FAILED"
+	StateDeleting = "DELETING"
+)
+
+type MicroVMStatus struct {
+	State             string                             `json:"state,omitempty"`
+	Volumes           map[string]*VolumeStatus           `json:"volumes,omitempty"`
+	KernelMount       *Mount                             `json:"kernel_mount,omitempty"`
+	InitrdMount       *Mount                             `json:"initrd_mount,omitempty"`
+	NetworkInterfaces map[string]*NetworkInterfaceStatus `json:"network_interfaces,omitempty"`
+	Retry             int32                              `json:"retry,omitempty"`
+}
+
+type VolumeStatus struct {
+	Mount *Mount `json:"mount,omitempty"`
+}
+
+const (
+	MountTypeHostPath = "HOSTPATH"
+	MountTypeDev      = "DEV"
+)
+
+type Mount struct {
+	Type   string `json:"type,omitempty"`
+	Source string `json:"source,omitempty"`
+}
+
+type NetworkInterfaceStatus struct {
+	HostDeviceName string `json:"host_device_name,omitempty"`
+	Index          int32  `json:"index,omitempty"`
+	MACAddress     string `json:"mac_address,omitempty"`
+}
+
+type CreateMicroVMRequest struct {
+	MicroVM *MicroVMSpec `json:"microvm,omitempty"`
+}
+
+type CreateMicroVMResponse struct {
+	MicroVM *MicroVM `json:"microvm,omitempty"`
+}
+
+type DeleteMicroVMRequest struct {
+	UID string `json:"uid,omitempty"`
+}
+
+type GetMicroVMRequest struct {
+	UID string `json:"uid,omitempty"`
+}
+
+type GetMicroVMResponse struct {
+	MicroVM *MicroVM `json:"microvm,omitempty"`
+}
+
+type ListMicroVMsRequest struct {
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+type ListMicroVMsResponse struct {
+	MicroVM []*MicroVM `json:"microvm,omitempty"`
+}
+
+type ListMessage struct {
+	MicroVM *MicroVM `json:"microvm,omitempty"`
+}
+
+const (
+	msgCreateMicroVMRequest  = servicesPackage + ".CreateMicroVMRequest"
+	msgCreateMicroVMResponse = servicesPackage + ".CreateMicroVMResponse"
+	msgDeleteMicroVMRequest  = servicesPackage + ".DeleteMicroVMRequest"
+	msgGetMicroVMRequest     = servicesPackage + ".GetMicroVMRequest"
+	msgGetMicroVMResponse    = servicesPackage + ".GetMicroVMResponse"
+	msgListMicroVMsRequest   = servicesPackage + ".ListMicroVMsRequest"
+	msgListMicroVMsResponse  = servicesPackage + ".ListMicroVMsResponse"
+	msgListMessage           = servicesPackage + ".ListMessage"
+)
+
+// newMessage creates an empty dynamic message of the named type.
+func newMessage(name string) *dynamicpb.Message {
+	return dynamicpb.NewMessage(messageDescriptor(name))
+}
+
+// fromMessage converts the dynamic proto message to the Go type.
+func fromMessage(msg *dynamicpb.Message, out interface{}) error {
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshalling %s: %w", msg.Descriptor().FullName(), err)
+	}
+
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("unmarshalling %s: %w", msg.Descriptor().FullName(), err)
+	}
+
+	return nil
+}
+
+// toMessage converts the Go type to a dynamic proto message of the named type.
+func toMessage(name string, in interface{}) (*dynamicpb.Message, error) {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling %s: %w", name, err)
+	}
+
+	msg := newMessage(name)
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(data, msg); err != nil {
+		return nil, fmt.Errorf("unmarshalling %s: %w", name, err)
+	}
+
+	return msg, nil
+}
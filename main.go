@@ -1,19 +1,36 @@
 package main
 
 import (
+	"errors"
 	"log"
+	"os"
 	"runtime"
 
+	"github.com/mikrolite/mikrolite/adapters/console"
+	"github.com/mikrolite/mikrolite/adapters/ssh"
+	"github.com/mikrolite/mikrolite/agent"
 	"github.com/mikrolite/mikrolite/internal/commands"
 )
 
 func main() {
+	console.Init()
+
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
 	rootCmd := commands.NewRoot()
 
 	if err := rootCmd.Execute(); err != nil {
+		// Commands run in a vm exit with the status of the command
+		sshErr := &ssh.ExitError{}
+		if errors.As(err, &sshErr) {
+			os.Exit(sshErr.Code)
+		}
+		agentErr := &agent.ExitError{}
+		if errors.As(err, &agentErr) {
+			os.Exit(agentErr.Code)
+		}
+
 		log.Fatalln(err)
 	}
 }
@@ -0,0 +1,112 @@
+// Package plugin is the SDK for building out-of-process vm providers for mikrolite.
+//
+// A plugin is an executable named mikrolite-provider-<name> that is placed on the
+// plugin path. Mikrolite starts the plugin for each operation and talks to it using
+// JSON-RPC over the plugins stdin and stdout. As stdout is used for the protocol,
+// plugins must write any output to stderr.
+package plugin
+
+import (
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+const (
+	// ProtocolVersion is the version of the plugin protocol. It will be
+	// increased if a breaking change is made to the protocol.
+	ProtocolVersion = 1
+
+	// ExecutablePrefix is the prefix of the plugin executable names.
+	ExecutablePrefix = "mikrolite-provider-"
+
+	// ServiceName is the name of the rpc service exposed by a plugin.
+	ServiceName = "Provider"
+
+	MethodHandshake    = ServiceName + ".Handshake"
+	MethodCapabilities = ServiceName + ".Capabilities"
+	MethodCreate       = ServiceName + ".Create"
+	MethodStop         = ServiceName + ".Stop"
+	MethodDelete       = ServiceName + ".Delete"
+	MethodAttachVolume = ServiceName + ".AttachVolume"
+	MethodDetachVolume = ServiceName + ".DetachVolume"
+	MethodMetrics      = ServiceName + ".Metrics"
+)
+
+// Environment is sent with every request and contains the details a plugin needs
+// to create its provider.
+type Environment struct {
+	// StateDir is the directory that holds the state for the vm.
+	StateDir string `json:"state_dir"`
+}
+
+// HandshakeRequest is the request for the Handshake method.
+type HandshakeRequest struct {
+	// ProtocolVersion is the protocol version used by mikrolite.
+	ProtocolVersion int `json:"protocol_version"`
+}
+
+// HandshakeResponse is the response for the Handshake method.
+type HandshakeResponse struct {
+	// ProtocolVersion is the protocol version used by the plugin.
+	ProtocolVersion int `json:"protocol_version"`
+	// Name is the name of the provider.
+	Name string `json:"name"`
+}
+
+// CapabilitiesRequest is the request for the Capabilities method.
+type CapabilitiesRequest struct {
+	Env Environment `json:"env"`
+}
+
+// CapabilitiesResponse is the response for the Capabilities method.
+type CapabilitiesResponse struct {
+	Capabilities ports.Capabilities `json:"capabilities"`
+}
+
+// CreateRequest is the request for the Create method.
+type CreateRequest struct {
+	Env Environment `json:"env"`
+	VM  *domain.VM  `json:"vm"`
+}
+
+// CreateResponse is the response for the Create method.
+type CreateResponse struct {
+	// ID is the identifier returned by the provider.
+	ID string `json:"id"`
+	// VM is the vm including any changes made by the provider.
+	VM *domain.VM `json:"vm"`
+}
+
+// NameRequest is the request for the methods that only need the vm name.
+type NameRequest struct {
+	Env  Environment `json:"env"`
+	Name string      `json:"name"`
+}
+
+// VolumeRequest is the request for the volume methods.
+type VolumeRequest struct {
+	Env        Environment `json:"env"`
+	VM         *domain.VM  `json:"vm"`
+	VolumeName string      `json:"volume_name"`
+}
+
+// VolumeResponse is the response for the volume methods.
+type VolumeResponse struct {
+	// VM is the vm including any changes made by the provider.
+	VM *domain.VM `json:"vm"`
+}
+
+// MetricsRequest is the request for the Metrics method. Its only called if
+// the provider has the metrics capability.
+type MetricsRequest struct {
+	Env Environment `json:"env"`
+	VM  *domain.VM  `json:"vm"`
+}
+
+// MetricsResponse is the response for the Metrics method.
+type MetricsResponse struct {
+	Metrics []domain.Metric `json:"metrics"`
+}
+
+// Empty is used for methods that don't return anything.
+type Empty struct{}
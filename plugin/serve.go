@@ -0,0 +1,160 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+// ProviderFactory creates the vm provider to use for a request.
+type ProviderFactory func(env Environment) (ports.VMProvider, error)
+
+// Serve will serve the provider over stdin and stdout. It blocks until
+// mikrolite closes the connection.
+func Serve(name string, factory ProviderFactory) error {
+	return serveConn(name, factory, &stdioConn{
+		Reader: os.Stdin,
+		Writer: os.Stdout,
+	})
+}
+
+// serveConn will serve the provider over the connection until its closed.
+func serveConn(name string, factory ProviderFactory, conn io.ReadWriteCloser) error {
+	server := rpc.NewServer()
+
+	svc := &service{
+		name:    name,
+		factory: factory,
+	}
+	if err := server.RegisterName(ServiceName, svc); err != nil {
+		return fmt.Errorf("registering provider service: %w", err)
+	}
+
+	server.ServeCodec(jsonrpc.NewServerCodec(conn))
+
+	return nil
+}
+
+// service adapts a ports.VMProvider to the rpc methods of the protocol.
+type service struct {
+	name    string
+	factory ProviderFactory
+}
+
+func (s *service) Handshake(req *HandshakeRequest, resp *HandshakeResponse) error {
+	if req.ProtocolVersion != ProtocolVersion {
+		return fmt.Errorf("unsupported protocol version %d, plugin supports %d", req.ProtocolVersion, ProtocolVersion)
+	}
+
+	resp.ProtocolVersion = ProtocolVersion
+	resp.Name = s.name
+
+	return nil
+}
+
+func (s *service) Capabilities(req *CapabilitiesRequest, resp *CapabilitiesResponse) error {
+	provider, err := s.factory(req.Env)
+	if err != nil {
+		return fmt.Errorf("creating provider: %w", err)
+	}
+
+	resp.Capabilities = provider.Capabilities()
+
+	return nil
+}
+
+func (s *service) Create(req *CreateRequest, resp *CreateResponse) error {
+	provider, err := s.factory(req.Env)
+	if err != nil {
+		return fmt.Errorf("creating provider: %w", err)
+	}
+
+	id, err := provider.Create(context.Background(), req.VM)
+	if err != nil {
+		return err
+	}
+
+	resp.ID = id
+	resp.VM = req.VM
+
+	return nil
+}
+
+func (s *service) Stop(req *NameRequest, resp *Empty) error {
+	provider, err := s.factory(req.Env)
+	if err != nil {
+		return fmt.Errorf("creating provider: %w", err)
+	}
+
+	return provider.Stop(context.Background(), req.Name)
+}
+
+func (s *service) Delete(req *NameRequest, resp *Empty) error {
+	provider, err := s.factory(req.Env)
+	if err != nil {
+		return fmt.Errorf("creating provider: %w", err)
+	}
+
+	return provider.Delete(context.Background(), req.Name)
+}
+
+func (s *service) AttachVolume(req *VolumeRequest, resp *VolumeResponse) error {
+	provider, err := s.factory(req.Env)
+	if err != nil {
+		return fmt.Errorf("creating provider: %w", err)
+	}
+
+	if err := provider.AttachVolume(context.Background(), req.VM, req.VolumeName); err != nil {
+		return err
+	}
+
+	resp.VM = req.VM
+
+	return nil
+}
+
+func (s *service) DetachVolume(req *VolumeRequest, resp *VolumeResponse) error {
+	provider, err := s.factory(req.Env)
+	if err != nil {
+		return fmt.Errorf("creating provider: %w", err)
+	}
+
+	if err := provider.DetachVolume(context.Background(), req.VM, req.VolumeName); err != nil {
+		return err
+	}
+
+	resp.VM = req.VM
+
+	return nil
+}
+
+func (s *service) Metrics(req *MetricsRequest, resp *MetricsResponse) error {
+	provider, err := s.factory(req.Env)
+	if err != nil {
+		return fmt.Errorf("creating provider: %w", err)
+	}
+
+	metrics, err := provider.Metrics(context.Background(), req.VM)
+	if err != nil {
+		return err
+	}
+
+	resp.Metrics = metrics
+
+	return nil
+}
+
+// stdioConn joins a reader and writer into a connection for the rpc codec.
+type stdioConn struct {
+	io.Reader
+	io.Writer
+}
+
+func (c *stdioConn) Close() error {
+	return nil
+}
@@ -0,0 +1,60 @@
+package plugin
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"strings"
+	"testing"
+
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+func newTestClient(t *testing.T, factory ProviderFactory) *rpc.Client {
+	t.Helper()
+
+	serverConn, clientConn := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- serveConn("test", factory, serverConn) }()
+
+	client := jsonrpc.NewClient(clientConn)
+	t.Cleanup(func() {
+		client.Close()
+		if err := <-done; err != nil {
+			t.Errorf("serving plugin: %s", err)
+		}
+	})
+
+	return client
+}
+
+func TestHandshake(t *testing.T) {
+	client := newTestClient(t, func(env Environment) (ports.VMProvider, error) {
+		return nil, errors.New("not used")
+	})
+
+	resp := &HandshakeResponse{}
+	if err := client.Call(MethodHandshake, &HandshakeRequest{ProtocolVersion: ProtocolVersion}, resp); err != nil {
+		t.Fatalf("handshake: %s", err)
+	}
+	if resp.ProtocolVersion != ProtocolVersion || resp.Name != "test" {
+		t.Errorf("unexpected handshake response %+v", resp)
+	}
+
+	err := client.Call(MethodHandshake, &HandshakeRequest{ProtocolVersion: ProtocolVersion + 1}, &HandshakeResponse{})
+	if err == nil || !strings.Contains(err.Error(), "unsupported protocol version") {
+		t.Errorf("expected the protocol version to be rejected, got %v", err)
+	}
+}
+
+func TestFactoryError(t *testing.T) {
+	client := newTestClient(t, func(env Environment) (ports.VMProvider, error) {
+		return nil, errors.New("no state dir")
+	})
+
+	err := client.Call(MethodStop, &NameRequest{Name: "vm1"}, &Empty{})
+	if err == nil || !strings.Contains(err.Error(), "creating provider: no state dir") {
+		t.Errorf("expected the factory error, got %v", err)
+	}
+}
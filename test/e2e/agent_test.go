@@ -0,0 +1,115 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mikrolite/mikrolite/agent"
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/internal/commands/vm"
+)
+
+// output runs the vm command with the args and returns the stdout.
+func (h *harness) output(args ...string) (string, error) {
+	h.t.Helper()
+
+	stdout := &syncBuffer{}
+	cmd := vm.NewVMCommandWithDependencies(h.deps())
+	cmd.SetIn(strings.NewReader(""))
+	cmd.SetOut(stdout)
+	cmd.SetErr(os.Stderr)
+
+	err := h.executeCommand(context.Background(), cmd, args...)
+
+	return stdout.String(), err
+}
+
+func TestGuestAgent(t *testing.T) {
+	for _, provider := range []string{"firecracker", "cloudhypervisor"} {
+		t.Run(provider, func(t *testing.T) {
+			h := newHarness(t, provider)
+
+			// The fake vmm serves the agent and reports a different ip than the
+			// network service would find
+			h.create("agent1", "--guest-agent")
+			created := h.vm("agent1")
+			if created == nil || created.Status.IP != "192.168.122.60" {
+				t.Fatalf("expected the ip reported by the agent, got %+v", created)
+			}
+			if created.Status.VSock == nil || created.Status.VSock.Socket != filepath.Join(h.stateDir, "agent1", "vsock.sock") {
+				t.Errorf("expected the vsock device in the status, got %+v", created.Status.VSock)
+			}
+
+			stdout, stderr, err := h.exec("agent1", "--", "echo", "hello;", "echo", "oops", ">&2")
+			if err != nil {
+				t.Fatalf("expected the command to succeed, got %s", err)
+			}
+			if stdout != "hello\n" || stderr != "oops\n" {
+				t.Errorf("expected the stdout and stderr of the command, got %q and %q", stdout, stderr)
+			}
+
+			_, _, err = h.exec("agent1", "--", "exit", "3")
+			exitErr := &agent.ExitError{}
+			if !errors.As(err, &exitErr) || exitErr.Code != 3 {
+				t.Errorf("expected exit status 3, got %v", err)
+			}
+
+			// The fake vmm runs on the host so the paths in the vm are host paths
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src.txt")
+			if err := os.WriteFile(src, []byte("copied\n"), 0o640); err != nil {
+				t.Fatalf("writing file: %s", err)
+			}
+			inVM := filepath.Join(dir, "vm") + "/"
+			if err := os.Mkdir(inVM, 0o755); err != nil {
+				t.Fatalf("creating dir: %s", err)
+			}
+			if _, err := h.output("cp", src, "agent1:"+inVM); err != nil {
+				t.Fatalf("copying to the vm: %s", err)
+			}
+			if _, err := h.output("cp", "agent1:"+inVM+"src.txt", filepath.Join(dir, "dst.txt")); err != nil {
+				t.Fatalf("copying from the vm: %s", err)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, "dst.txt"))
+			if err != nil || string(data) != "copied\n" {
+				t.Errorf("expected the copied file, got %q: %v", data, err)
+			}
+			if info, err := os.Stat(filepath.Join(dir, "dst.txt")); err != nil || info.Mode().Perm() != 0o640 {
+				t.Errorf("expected the mode to be kept, got %v: %v", info, err)
+			}
+
+			out, err := h.output("info", "agent1", "--json")
+			if err != nil {
+				t.Fatalf("getting info: %s", err)
+			}
+			info := &domain.GuestInfo{}
+			if err := json.Unmarshal([]byte(out), info); err != nil {
+				t.Fatalf("unmarshalling info %q: %s", out, err)
+			}
+			if info.Hostname != "agent1" || info.IPv4("") != "192.168.122.60" {
+				t.Errorf("unexpected guest info %+v", info)
+			}
+
+			// A vm created without the agent uses the ip from the network service
+			// and can't be used with the agent
+			h.create("noagent")
+			if vm := h.vm("noagent"); vm == nil || vm.Status.IP != testIP {
+				t.Errorf("expected ip %s, got %+v", testIP, vm)
+			}
+			_, _, err = h.exec("noagent", "--via", "agent", "--", "true")
+			if err == nil || !strings.Contains(err.Error(), "--guest-agent") {
+				t.Errorf("expected an error about --guest-agent, got %v", err)
+			}
+
+			h.run("remove", "agent1")
+			h.run("remove", "noagent")
+		})
+	}
+}
@@ -0,0 +1,165 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+func TestStartForeground(t *testing.T) {
+	h := newHarness(t, "cloudhypervisor")
+
+	h.create("s1")
+	first := h.waitForRecord("s1", func(r *record) bool { return r.PID != 0 })
+
+	// Starting a running vm only warns
+	h.run("start", "s1")
+
+	// Kill the vm like a host reboot would, the state still says its running
+	if err := syscall.Kill(first.PID, syscall.SIGKILL); err != nil {
+		t.Fatalf("killing vm process: %s", err)
+	}
+	waitForProcessExit(t, first.PID)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- h.execute(ctx, "start", "--foreground", "s1") }()
+
+	second := h.waitForRecord("s1", func(r *record) bool { return r.PID != 0 && r.PID != first.PID })
+	vm := h.waitForVM("s1", func(vm *domain.VM) bool {
+		return vm.Status.Process != nil && vm.Status.Process.State == domain.ProcessStateRunning && vm.Status.Process.LastExit != nil
+	})
+	if vm.Status.IP != testIP {
+		t.Errorf("expected ip %s, got %s", testIP, vm.Status.IP)
+	}
+
+	// Cancelling the command is the same as systemd stopping the service
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected foreground command to succeed, got %s", err)
+		}
+	case <-time.After(waitTimeout):
+		t.Fatalf("timed out waiting for the foreground command to exit")
+	}
+	waitForProcessExit(t, second.PID)
+
+	procStatus := h.vm("s1").Status.Process
+	if procStatus.State != domain.ProcessStateStopped {
+		t.Errorf("expected vm to be stopped, got %+v", procStatus)
+	}
+
+	h.run("remove", "s1")
+}
+
+func TestStartForegroundFailure(t *testing.T) {
+	h := newHarness(t, "cloudhypervisor")
+
+	h.create("s2")
+	first := h.waitForRecord("s2", func(r *record) bool { return r.PID != 0 })
+	if err := syscall.Kill(first.PID, syscall.SIGKILL); err != nil {
+		t.Fatalf("killing vm process: %s", err)
+	}
+	waitForProcessExit(t, first.PID)
+
+	t.Setenv("FAKEVMM_CRASH_AFTER", "300ms")
+	t.Setenv("FAKEVMM_EXIT_CODE", "3")
+
+	err := h.execute(context.Background(), "start", "--foreground", "s2")
+	if err == nil || !strings.Contains(err.Error(), "exit code 3") {
+		t.Errorf("expected the foreground command to fail with the exit code, got %v", err)
+	}
+
+	procStatus := h.vm("s2").Status.Process
+	if procStatus.State != domain.ProcessStateCrashed {
+		t.Errorf("expected vm to have crashed, got %+v", procStatus)
+	}
+
+	h.run("remove", "s2")
+}
+
+func TestEnableDisable(t *testing.T) {
+	h := newHarness(t, "cloudhypervisor")
+
+	h.create("a1", "--restart", "on-failure", "--restart-max-retries", "2")
+	h.waitForRecord("a1", func(r *record) bool { return r.PID != 0 })
+
+	h.run("enable", "a1")
+
+	if !h.vm("a1").Spec.Autostart {
+		t.Errorf("expected vm to be enabled")
+	}
+	unit, err := os.ReadFile(filepath.Join(h.unitDir, "mikrolite-vm-a1.service"))
+	if err != nil {
+		t.Fatalf("reading vm unit: %s", err)
+	}
+	for _, expected := range []string{"vm start --foreground --direct", "--state-path " + h.stateDir, " a1\n", "Restart=on-failure", "StartLimitBurst=3"} {
+		if !strings.Contains(string(unit), expected) {
+			t.Errorf("expected unit to contain %q, got:\n%s", expected, unit)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(h.unitDir, "mikrolite-vms.target")); err != nil {
+		t.Errorf("expected target to be written: %s", err)
+	}
+
+	h.run("disable", "a1")
+
+	if h.vm("a1").Spec.Autostart {
+		t.Errorf("expected vm to be disabled")
+	}
+	if _, err := os.Stat(filepath.Join(h.unitDir, "mikrolite-vm-a1.service")); !os.IsNotExist(err) {
+		t.Errorf("expected vm unit to be removed")
+	}
+
+	// Removing an enabled vm disables it
+	h.run("enable", "a1")
+	h.run("remove", "a1")
+
+	if _, err := os.Stat(filepath.Join(h.unitDir, "mikrolite-vm-a1.service")); !os.IsNotExist(err) {
+		t.Errorf("expected vm unit to be removed")
+	}
+
+	expected := []string{
+		"daemon-reload",
+		"enable mikrolite-vms.target",
+		"enable --now mikrolite-vm-a1.service",
+		"disable --now mikrolite-vm-a1.service",
+		"daemon-reload",
+		"daemon-reload",
+		"enable mikrolite-vms.target",
+		"enable --now mikrolite-vm-a1.service",
+		"disable --now mikrolite-vm-a1.service",
+		"daemon-reload",
+	}
+	if strings.Join(h.systemctl, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected systemctl calls %v, got %v", expected, h.systemctl)
+	}
+}
+
+// waitForVM waits until the saved vm matches the condition.
+func (h *harness) waitForVM(name string, condition func(vm *domain.VM) bool) *domain.VM {
+	h.t.Helper()
+
+	deadline := time.Now().Add(waitTimeout)
+	var last *domain.VM
+	for time.Now().Before(deadline) {
+		last = h.vm(name)
+		if last != nil && condition(last) {
+			return last
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	h.t.Fatalf("timed out waiting for vm %s, last state: %+v", name, last)
+
+	return nil
+}
@@ -0,0 +1,110 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/filesystem"
+
+	"github.com/mikrolite/mikrolite/cloudinit"
+)
+
+func TestConfigDrive(t *testing.T) {
+	t.Run("cloud-hypervisor openstack config drive", func(t *testing.T) {
+		h := newHarness(t, "cloudhypervisor")
+
+		h.create("cd1", "--config-drive", "openstack", "--host-metadata")
+		r := h.waitForRecord("cd1", func(r *record) bool { return r.PID != 0 })
+		if hasArg(r, "ds=nocloud-net") {
+			t.Errorf("expected the config drive rather than the metadata server, got %v", r.Args)
+		}
+		if !hasArg(r, cloudinit.NetworkConfigDataKey+"=") {
+			t.Errorf("expected the network config on the cmdline, got %v", r.Args)
+		}
+
+		fs := readImage(t, filepath.Join(h.stateDir, "cd1", "config-drive.img"), cloudinit.ConfigDriveVolumeName)
+		metadata := map[string]string{}
+		if err := json.Unmarshal([]byte(readImageFile(t, fs, "/openstack/latest/meta_data.json")), &metadata); err != nil {
+			t.Fatalf("unmarshalling meta_data.json: %s", err)
+		}
+		if metadata["uuid"] != "cd1" || metadata["hostname"] != "cd1" {
+			t.Errorf("expected the metadata of the vm, got %v", metadata)
+		}
+
+		h.run("remove", "cd1")
+		waitForProcessExit(t, r.PID)
+	})
+
+	t.Run("firecracker nocloud iso instead of mmds", func(t *testing.T) {
+		h := newHarness(t, "firecracker")
+
+		sshKey := filepath.Join(t.TempDir(), "id_ed25519.pub")
+		if err := os.WriteFile(sshKey, []byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIE2E user@host\n"), 0o644); err != nil {
+			t.Fatalf("writing ssh key: %s", err)
+		}
+		h.create("cd2", "--config-drive", "nocloud-iso", "--ssh-key", sshKey)
+		r := h.waitForRecord("cd2", func(r *record) bool { return hasRequest(r, "PUT", "/actions") })
+		if !hasRequest(r, "PUT", "/drives/"+cloudinit.VolumeName) {
+			t.Errorf("expected the cidata drive to be attached, got %v", r.Requests)
+		}
+		if hasArg(r, "--metadata") {
+			t.Errorf("expected no mmds content, got %v", r.Args)
+		}
+
+		fs := readImage(t, filepath.Join(h.stateDir, "cd2", "cloud-init.img"), cloudinit.VolumeName)
+		if metadata := readImageFile(t, fs, "/"+cloudinit.InstanceDataKey); !strings.Contains(metadata, "instance_id: cd2") {
+			t.Errorf("expected the meta-data of the vm, got %q", metadata)
+		}
+		if userData := readImageFile(t, fs, "/"+cloudinit.UserdataKey); !strings.Contains(userData, "ssh-ed25519 ") {
+			t.Errorf("expected the ssh key in the user-data, got %q", userData)
+		}
+
+		h.run("remove", "cd2")
+		waitForProcessExit(t, r.PID)
+	})
+}
+
+// readImage opens the ISO9660 image and checks its label.
+func readImage(t *testing.T, path, label string) filesystem.FileSystem {
+	t.Helper()
+
+	image, err := diskfs.Open(path, diskfs.WithOpenMode(diskfs.ReadOnly))
+	if err != nil {
+		t.Fatalf("opening image %s: %s", path, err)
+	}
+	t.Cleanup(func() { image.File.Close() })
+	fs, err := image.GetFilesystem(0)
+	if err != nil {
+		t.Fatalf("reading image filesystem: %s", err)
+	}
+	if fs.Type() != filesystem.TypeISO9660 {
+		t.Errorf("expected an iso9660 image, got %d", fs.Type())
+	}
+	if actual := strings.Trim(fs.Label(), " \x00"); actual != label {
+		t.Errorf("expected label %s, got %s", label, actual)
+	}
+
+	return fs
+}
+
+func readImageFile(t *testing.T, fs filesystem.FileSystem, path string) string {
+	t.Helper()
+
+	file, err := fs.OpenFile(path, os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("opening %s: %s", path, err)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatalf("reading %s: %s", path, err)
+	}
+
+	return string(data)
+}
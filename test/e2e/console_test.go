@@ -0,0 +1,117 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mikrolite/mikrolite/internal/commands/vm"
+)
+
+// syncBuffer is a bytes.Buffer that can be written by the command and read by
+// the test at the same time.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+// waitForOutput waits until the output contains the text.
+func waitForOutput(t *testing.T, output func() string, text string) {
+	t.Helper()
+
+	deadline := time.Now().Add(waitTimeout)
+	for !strings.Contains(output(), text) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %q, got %q", text, output())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestConsole(t *testing.T) {
+	h := newHarness(t, "cloudhypervisor")
+
+	h.create("con1")
+	r := h.waitForRecord("con1", func(r *record) bool { return r.PID != 0 })
+
+	socket := h.vm("con1").Status.ConsoleSocket
+	if socket != filepath.Join(h.stateDir, "con1", "console.sock") {
+		t.Errorf("expected the console socket to be recorded, got %q", socket)
+	}
+
+	// The console output is logged even when nothing is attached
+	stdoutPath := filepath.Join(h.stateDir, "con1", "vm.stdout")
+	readLog := func() string {
+		data, _ := os.ReadFile(stdoutPath)
+
+		return string(data)
+	}
+	waitForOutput(t, readLog, "fakevmm login: ")
+
+	inReader, inWriter := io.Pipe()
+	out := &syncBuffer{}
+	cmd := vm.NewVMCommandWithDependencies(h.deps())
+	cmd.SetIn(inReader)
+	cmd.SetOut(out)
+	cmd.SetErr(io.Discard)
+
+	done := make(chan error, 1)
+	go func() { done <- h.executeCommand(context.Background(), cmd, "console", "con1") }()
+
+	// The pipe blocks until the command has connected and reads the input
+	if _, err := inWriter.Write([]byte("hello\n")); err != nil {
+		t.Fatalf("writing to console: %s", err)
+	}
+	waitForOutput(t, out.String, "echo: hello")
+	waitForOutput(t, readLog, "echo: hello")
+
+	// ctrl-] detaches
+	if _, err := inWriter.Write([]byte{0x1d}); err != nil {
+		t.Fatalf("writing detach keys: %s", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("running console: %s", err)
+		}
+	case <-time.After(waitTimeout):
+		t.Fatalf("timed out waiting for console to detach")
+	}
+
+	h.run("remove", "con1")
+	waitForProcessExit(t, r.PID)
+
+	// The shim exits and removes the socket once the vm process has exited
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		if _, err := os.Stat(socket); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the console socket to be removed")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,253 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mikrolite/mikrolite/api/v1alpha1"
+	"github.com/mikrolite/mikrolite/core/app"
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/internal/daemon"
+	"github.com/mikrolite/mikrolite/internal/factory"
+)
+
+const (
+	superviseInterval = 100 * time.Millisecond
+)
+
+// startDaemon runs mikrolited in the test and makes the commands use it.
+func (h *harness) startDaemon() {
+	h.t.Helper()
+
+	h.daemonSocket = filepath.Join(h.t.TempDir(), "mikrolited.sock")
+	server, err := daemon.New(daemon.Config{
+		Config: factory.Config{
+			StateRootPath:      h.stateDir,
+			VMProvider:         h.provider,
+			FirecrackerBin:     firecrackerBin,
+			CloudHypervisorBin: cloudHypervisorBin,
+		},
+		ListenPath:        h.daemonSocket,
+		SuperviseInterval: superviseInterval,
+	}, h.deps())
+	if err != nil {
+		h.t.Fatalf("creating daemon: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(ctx) }()
+	h.t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			h.t.Errorf("serving daemon: %s", err)
+		}
+	})
+
+	deadline := time.Now().Add(waitTimeout)
+	for !daemon.Available(h.daemonSocket) {
+		if time.Now().After(deadline) {
+			h.t.Fatalf("timed out waiting for daemon on %s", h.daemonSocket)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// restClient returns a http client that uses the daemon socket.
+func (h *harness) restClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				dialer := net.Dialer{}
+
+				return dialer.DialContext(ctx, "unix", h.daemonSocket)
+			},
+		},
+	}
+}
+
+func TestDaemon(t *testing.T) {
+	h := newHarness(t, "cloudhypervisor")
+	h.startDaemon()
+
+	client, err := daemon.Dial(h.daemonSocket)
+	if err != nil {
+		t.Fatalf("connecting to daemon: %s", err)
+	}
+	defer client.Close()
+
+	mu := sync.Mutex{}
+	events := []*v1alpha1.Event{}
+	watchCtx, cancelWatch := context.WithCancel(context.Background())
+	watchDone := make(chan struct{})
+	go func() {
+		defer close(watchDone)
+		client.WatchEvents(watchCtx, "", func(event *v1alpha1.Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, event)
+		})
+	}()
+	// Give the stream time to subscribe
+	time.Sleep(100 * time.Millisecond)
+
+	h.create("d1")
+	r := h.waitForRecord("d1", func(r *record) bool { return r.PID != 0 })
+
+	vm, err := client.GetVM(context.Background(), "d1")
+	if err != nil {
+		t.Fatalf("getting vm: %s", err)
+	}
+	if vm.Status.IP != testIP {
+		t.Errorf("expected ip %s, got %s", testIP, vm.Status.IP)
+	}
+
+	resp, err := h.restClient().Get("http://mikrolited/v1alpha1/vms")
+	if err != nil {
+		t.Fatalf("listing vms with rest api: %s", err)
+	}
+	list := &v1alpha1.ListVMsResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(list); err != nil {
+		t.Fatalf("decoding list response: %s", err)
+	}
+	resp.Body.Close()
+	if len(list.VMs) != 1 || list.VMs[0].Name != "d1" {
+		t.Errorf("expected vm d1 to be listed, got %v", list.VMs)
+	}
+
+	resp, err = h.restClient().Get("http://mikrolited/v1alpha1/vms/missing")
+	if err != nil {
+		t.Fatalf("getting vm with rest api: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status %d for a missing vm, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	h.run("remove", "d1")
+	waitForProcessExit(t, r.PID)
+	if h.vm("d1") != nil {
+		t.Errorf("expected vm state to be removed")
+	}
+
+	// Wait for the remove events to be streamed before cancelling the watch
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		mu.Lock()
+		received := len(events)
+		mu.Unlock()
+		if received >= 4 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	cancelWatch()
+	<-watchDone
+
+	if err := client.RemoveVM(context.Background(), "missing", ""); !errors.Is(err, app.ErrVMNotFound) {
+		t.Errorf("expected removing a missing vm to fail with %v, got %v", app.ErrVMNotFound, err)
+	}
+	if _, err := client.ListVMs(context.Background()); err != nil {
+		t.Errorf("expected the daemon to keep serving after removing a missing vm: %s", err)
+	}
+	for _, name := range []string{"../escaped", "..", "a/b"} {
+		if err := client.RemoveVM(context.Background(), name, ""); !errors.Is(err, app.ErrInvalidName) {
+			t.Errorf("expected removing %q to fail with %v, got %v", name, app.ErrInvalidName, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(h.stateDir), "escaped")); !os.IsNotExist(err) {
+		t.Errorf("expected no directory to be created outside of the state root, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	got := []string{}
+	for _, event := range events {
+		got = append(got, fmt.Sprintf("%s %s %s", event.VM, event.Operation, event.Status))
+	}
+	expected := []string{
+		"d1 create started",
+		"d1 create succeeded",
+		"d1 remove started",
+		"d1 remove succeeded",
+	}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected events %v, got %v", expected, got)
+	}
+}
+
+func TestDaemonRestartPolicy(t *testing.T) {
+	h := newHarness(t, "cloudhypervisor")
+	t.Setenv("FAKEVMM_CRASH_AFTER", "300ms")
+	t.Setenv("FAKEVMM_EXIT_CODE", "3")
+	h.startDaemon()
+
+	h.create("r1", "--restart", "on-failure", "--restart-max-retries", "1", "--restart-backoff", "1")
+
+	// The vm crashes, is restarted once and then crashes again
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		vm := h.vm("r1")
+		if vm != nil && vm.Status.Process != nil && vm.Status.Process.Restarts == 1 && vm.Status.Process.State == domain.ProcessStateCrashed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for vm to be restarted and crash, last state: %+v", vm)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// Give the supervisor time to check the vm again, it mustn't be restarted
+	time.Sleep(1500 * time.Millisecond)
+
+	procStatus := h.vm("r1").Status.Process
+	if procStatus.State != domain.ProcessStateCrashed || procStatus.Restarts != 1 {
+		t.Errorf("expected vm not to be restarted again, got %+v", procStatus)
+	}
+	lastExit := procStatus.LastExit
+	if lastExit == nil || lastExit.ExitCode == nil || *lastExit.ExitCode != 3 {
+		t.Errorf("expected exit code 3 to be recorded, got %+v", lastExit)
+	}
+
+	h.run("remove", "r1")
+	if h.vm("r1") != nil {
+		t.Errorf("expected vm state to be removed")
+	}
+}
+
+func TestDaemonGC(t *testing.T) {
+	h := newHarness(t, "cloudhypervisor")
+	h.startDaemon()
+
+	h.image.Owners["vm-lost"] = true
+
+	resp, err := h.restClient().Post("http://mikrolited/v1alpha1/gc?force=true", "application/json", nil)
+	if err != nil {
+		t.Fatalf("running gc with rest api: %s", err)
+	}
+	gcResp := &v1alpha1.GCResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(gcResp); err != nil {
+		t.Fatalf("decoding gc response: %s", err)
+	}
+	resp.Body.Close()
+
+	if len(gcResp.Orphans) != 1 || gcResp.Orphans[0].Name != "vm-lost" || !gcResp.Orphans[0].Removed {
+		t.Errorf("expected images vm-lost to be removed, got %+v", gcResp.Orphans)
+	}
+	if h.image.Owners["vm-lost"] {
+		t.Errorf("expected images to be removed")
+	}
+}
@@ -0,0 +1,440 @@
+//go:build e2e
+
+// Package e2e runs the mikrolite commands against fake hypervisor binaries.
+// It doesn't need kvm, containerd or root and is run with:
+//
+//	go test -tags e2e ./test/e2e/...
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/mikrolite/mikrolite/adapters/console"
+	"github.com/mikrolite/mikrolite/adapters/systemd"
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+	"github.com/mikrolite/mikrolite/defaults"
+	"github.com/mikrolite/mikrolite/internal/commands/vm"
+	"github.com/mikrolite/mikrolite/internal/factory"
+	"github.com/mikrolite/mikrolite/testing/fakes"
+)
+
+const (
+	testIP      = "192.168.122.50"
+	waitTimeout = 10 * time.Second
+)
+
+var (
+	firecrackerBin     string
+	cloudHypervisorBin string
+)
+
+// record matches the record written by fakevmm.
+type record struct {
+	Mode     string   `json:"mode"`
+	PID      int      `json:"pid"`
+	Args     []string `json:"args"`
+	Requests []struct {
+		Method string          `json:"method"`
+		Path   string          `json:"path"`
+		Body   json.RawMessage `json:"body"`
+	} `json:"requests"`
+	Exited     bool   `json:"exited"`
+	ExitReason string `json:"exit_reason"`
+}
+
+func TestMain(m *testing.M) {
+	// The providers run the console shim using the test binary
+	console.Init()
+
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	binDir, err := os.MkdirTemp("", "mikrolite-e2e-bin")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "creating bin dir: %s\n", err)
+		return 1
+	}
+	defer os.RemoveAll(binDir)
+
+	firecrackerBin = filepath.Join(binDir, "firecracker")
+	cloudHypervisorBin = filepath.Join(binDir, "cloud-hypervisor")
+
+	build := exec.Command("go", "build", "-o", firecrackerBin, "./fakevmm")
+	build.Stdout = os.Stdout
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "building fakevmm: %s\n", err)
+		return 1
+	}
+	if err := os.Link(firecrackerBin, cloudHypervisorBin); err != nil {
+		fmt.Fprintf(os.Stderr, "linking fakevmm: %s\n", err)
+		return 1
+	}
+
+	pterm.DisableOutput()
+
+	return m.Run()
+}
+
+// harness holds everything needed to run the commands for a test.
+type harness struct {
+	t         *testing.T
+	stateDir  string
+	recordDir string
+	kernelDir string
+	provider  string
+	rec       *fakes.Recorder
+	network   *fakes.NetworkService
+	image     *fakes.ImageService
+	unitDir   string
+	// systemctl holds the recorded systemctl calls, systemctl isn't run.
+	systemctl   []string
+	systemctlMu sync.Mutex
+	// daemonSocket is the socket of mikrolited, if empty the commands are run in
+	// direct mode.
+	daemonSocket string
+}
+
+func newHarness(t *testing.T, provider string) *harness {
+	t.Helper()
+
+	h := &harness{
+		t:         t,
+		stateDir:  t.TempDir(),
+		recordDir: t.TempDir(),
+		kernelDir: t.TempDir(),
+		unitDir:   t.TempDir(),
+		provider:  provider,
+		rec:       fakes.NewRecorder(),
+	}
+	h.network = fakes.NewNetworkService(h.rec, defaults.SharedBridgeName)
+	h.network.DefaultIP = testIP
+	h.image = fakes.NewImageService(h.rec)
+	h.image.MountDir = t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(h.kernelDir, "vmlinux"), []byte("kernel"), 0o644); err != nil {
+		t.Fatalf("creating kernel: %s", err)
+	}
+
+	t.Setenv("FAKEVMM_RECORD_DIR", h.recordDir)
+
+	return h
+}
+
+// run will run the vm command with the args.
+func (h *harness) run(args ...string) {
+	h.t.Helper()
+
+	if err := h.execute(context.Background(), args...); err != nil {
+		h.t.Fatalf("running %v: %s", args, err)
+	}
+}
+
+// execute runs the vm command with the args until it exits or the context is
+// cancelled.
+func (h *harness) execute(ctx context.Context, args ...string) error {
+	cmd := vm.NewVMCommandWithDependencies(h.deps())
+	cmd.SetOut(os.Stderr)
+
+	return h.executeCommand(ctx, cmd, args...)
+}
+
+// executeCommand runs the command with the args and the flags for the harness.
+// The flags are added before --, if the args have one.
+func (h *harness) executeCommand(ctx context.Context, cmd *cobra.Command, args ...string) error {
+	flags := []string{
+		"--state-path", h.stateDir,
+		"--provider", h.provider,
+		"--firecracker-bin", firecrackerBin,
+		"--cloudhypervisor-bin", cloudHypervisorBin,
+	}
+	if h.daemonSocket != "" {
+		flags = append(flags, "--daemon-socket", h.daemonSocket)
+	} else {
+		flags = append(flags, "--direct")
+	}
+
+	dash := slices.Index(args, "--")
+	if dash == -1 {
+		dash = len(args)
+	}
+	cmd.SetArgs(append(append(slices.Clone(args[:dash]), flags...), args[dash:]...))
+
+	return cmd.ExecuteContext(ctx)
+}
+
+// deps returns the dependencies that use the fakes.
+func (h *harness) deps() factory.Dependencies {
+	return factory.Dependencies{
+		NewImageService: func(socketPath string) (ports.ImageService, error) {
+			return h.image, nil
+		},
+		NewNetworkService: func() ports.NetworkService {
+			return h.network
+		},
+		NewAutostartService: func(cfg factory.Config) (ports.AutostartService, error) {
+			command := append([]string{"mikrolite", "vm", "start", "--foreground", "--direct"}, cfg.Args()...)
+
+			return systemd.New(h.unitDir, command, afero.NewOsFs(), h.recordSystemctl), nil
+		},
+	}
+}
+
+func (h *harness) recordSystemctl(ctx context.Context, args ...string) error {
+	h.systemctlMu.Lock()
+	defer h.systemctlMu.Unlock()
+
+	h.systemctl = append(h.systemctl, strings.Join(args, " "))
+
+	return nil
+}
+
+func (h *harness) create(name string, extraArgs ...string) {
+	h.t.Helper()
+
+	args := []string{
+		"create",
+		"--name", name,
+		"--root-image", "ghcr.io/mikrolite/root:e2e",
+		"--kernel-path", h.kernelDir,
+	}
+	h.run(append(args, extraArgs...)...)
+}
+
+// vm reads the saved vm from the state directory, it returns nil if it doesn't exist.
+func (h *harness) vm(name string) *domain.VM {
+	h.t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(h.stateDir, name, "vm.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		h.t.Fatalf("reading vm state: %s", err)
+	}
+
+	vm := &domain.VM{}
+	if err := json.Unmarshal(data, vm); err != nil {
+		h.t.Fatalf("unmarshalling vm state: %s", err)
+	}
+
+	return vm
+}
+
+// waitForRecord waits until the record for the vm matches the condition.
+func (h *harness) waitForRecord(name string, condition func(r *record) bool) *record {
+	h.t.Helper()
+
+	deadline := time.Now().Add(waitTimeout)
+	var last *record
+	for time.Now().Before(deadline) {
+		data, err := os.ReadFile(filepath.Join(h.recordDir, fmt.Sprintf("%s.json", name)))
+		if err == nil {
+			r := &record{}
+			if json.Unmarshal(data, r) == nil {
+				last = r
+				if condition(r) {
+					return r
+				}
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	h.t.Fatalf("timed out waiting for fakevmm record for %s, last record: %+v", name, last)
+
+	return nil
+}
+
+func hasRequest(r *record, method string, path string) bool {
+	for _, req := range r.Requests {
+		if req.Method == method && req.Path == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasArg(r *record, value string) bool {
+	for _, arg := range r.Args {
+		if strings.Contains(arg, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestFirecrackerLifecycle(t *testing.T) {
+	h := newHarness(t, "firecracker")
+
+	h.create("fc1")
+
+	r := h.waitForRecord("fc1", func(r *record) bool {
+		return hasRequest(r, "PUT", "/actions")
+	})
+	for _, path := range []string{"/machine-config", "/boot-source", "/drives/root", "/drives/slot0", "/mmds/config"} {
+		if !hasRequest(r, "PUT", path) {
+			t.Errorf("expected PUT %s request", path)
+		}
+	}
+	if !hasArg(r, "--metadata") {
+		t.Errorf("expected --metadata arg, got %v", r.Args)
+	}
+
+	vm := h.vm("fc1")
+	if vm == nil {
+		t.Fatalf("expected vm state to be saved")
+	}
+	if vm.Status.IP != testIP {
+		t.Errorf("expected ip %s, got %s", testIP, vm.Status.IP)
+	}
+
+	h.run("remove", "fc1")
+
+	waitForProcessExit(t, r.PID)
+	if _, err := os.Stat(filepath.Join(h.stateDir, "fc1")); !os.IsNotExist(err) {
+		t.Errorf("expected state directory to be removed")
+	}
+	if len(h.network.Interfaces) != 0 {
+		t.Errorf("expected network interfaces to be removed, got %v", h.network.Interfaces)
+	}
+}
+
+func TestFirecrackerVolumeAttach(t *testing.T) {
+	h := newHarness(t, "firecracker")
+
+	h.create("fc2", "--volume-slots", "1")
+	r := h.waitForRecord("fc2", func(r *record) bool { return hasRequest(r, "PUT", "/actions") })
+
+	rawFile := filepath.Join(t.TempDir(), "data.img")
+	if err := os.WriteFile(rawFile, nil, 0o644); err != nil {
+		t.Fatalf("creating raw volume: %s", err)
+	}
+
+	h.run("volume", "attach", "fc2", "--volume-name", "data", "--raw", rawFile)
+	h.waitForRecord("fc2", func(r *record) bool { return hasRequest(r, "PATCH", "/drives/slot0") })
+
+	vm := h.vm("fc2")
+	if vm.Status.VolumeSlots["data"] != "slot0" {
+		t.Errorf("expected data volume in slot0, got %v", vm.Status.VolumeSlots)
+	}
+
+	h.run("volume", "detach", "fc2", "data")
+	vm = h.vm("fc2")
+	if _, ok := vm.Status.VolumeMounts["data"]; ok {
+		t.Errorf("expected data volume to be detached")
+	}
+
+	h.run("remove", "fc2")
+	waitForProcessExit(t, r.PID)
+}
+
+func TestCloudHypervisorLifecycle(t *testing.T) {
+	h := newHarness(t, "cloudhypervisor")
+
+	h.create("ch1")
+
+	r := h.waitForRecord("ch1", func(r *record) bool { return r.PID != 0 })
+	for _, arg := range []string{"--kernel", "--cmdline", "id=root", "id=cidata", "--net"} {
+		if !hasArg(r, arg) {
+			t.Errorf("expected arg %s, got %v", arg, r.Args)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(h.stateDir, "ch1", "cloud-init.img")); err != nil {
+		t.Errorf("expected cloud-init image to be created: %s", err)
+	}
+
+	rawFile := filepath.Join(t.TempDir(), "data.img")
+	if err := os.WriteFile(rawFile, nil, 0o644); err != nil {
+		t.Fatalf("creating raw volume: %s", err)
+	}
+	// Wait for the api socket before hot-attaching
+	waitForFile(t, filepath.Join(h.stateDir, "ch1", "cloudhypervisor.sock"))
+
+	h.run("volume", "attach", "ch1", "--volume-name", "data", "--raw", rawFile)
+	h.waitForRecord("ch1", func(r *record) bool { return hasRequest(r, "PUT", "/api/v1/vm.add-disk") })
+
+	h.run("volume", "detach", "ch1", "data")
+	h.waitForRecord("ch1", func(r *record) bool { return hasRequest(r, "PUT", "/api/v1/vm.remove-device") })
+
+	h.run("remove", "ch1")
+
+	waitForProcessExit(t, r.PID)
+	if h.vm("ch1") != nil {
+		t.Errorf("expected vm state to be removed")
+	}
+}
+
+func TestCrashingVM(t *testing.T) {
+	h := newHarness(t, "cloudhypervisor")
+	t.Setenv("FAKEVMM_CRASH_AFTER", "200ms")
+	t.Setenv("FAKEVMM_EXIT_CODE", "3")
+
+	h.create("crash1")
+
+	r := h.waitForRecord("crash1", func(r *record) bool { return r.Exited })
+	if r.ExitReason != "crash" {
+		t.Errorf("expected vm to crash, got %s", r.ExitReason)
+	}
+
+	h.run("remove", "crash1")
+	if h.vm("crash1") != nil {
+		t.Errorf("expected vm state to be removed")
+	}
+}
+
+// waitForProcessExit waits until the process has exited. Remove kills the vm
+// process straight after asking it to stop, so the fake may not get to record
+// its exit. The vm processes are never reaped by the cli so a zombie counts as
+// exited.
+func waitForProcessExit(t *testing.T, pid int) {
+	t.Helper()
+
+	deadline := time.Now().Add(waitTimeout)
+	for time.Now().Before(deadline) {
+		data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil {
+			return
+		}
+		// The state follows the command name, which is in brackets
+		fields := strings.Fields(string(data[strings.LastIndex(string(data), ")")+1:]))
+		if len(fields) > 0 && (fields[0] == "Z" || fields[0] == "X") {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	t.Fatalf("timed out waiting for process %d to exit", pid)
+}
+
+func waitForFile(t *testing.T, path string) {
+	t.Helper()
+
+	deadline := time.Now().Add(waitTimeout)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	t.Fatalf("timed out waiting for %s", path)
+}
@@ -0,0 +1,88 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEC2Datasource(t *testing.T) {
+	t.Run("firecracker serves the ec2 metadata from mmds", func(t *testing.T) {
+		h := newHarness(t, "firecracker")
+
+		sshKey := filepath.Join(t.TempDir(), "id_ed25519.pub")
+		if err := os.WriteFile(sshKey, []byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIE2E user@host\n"), 0o644); err != nil {
+			t.Fatalf("writing ssh key: %s", err)
+		}
+
+		h.create("ec21", "--datasource", "ec2", "--ssh-key", sshKey)
+		r := h.waitForRecord("ec21", func(r *record) bool { return hasRequest(r, "PUT", "/actions") })
+
+		bootArgs, mmdsConfig := "", ""
+		for _, req := range r.Requests {
+			switch req.Path {
+			case "/boot-source":
+				bootArgs = string(req.Body)
+			case "/mmds/config":
+				mmdsConfig = string(req.Body)
+			}
+		}
+		if !strings.Contains(bootArgs, "ci.ds=Ec2") || strings.Contains(bootArgs, "ds=nocloud") {
+			t.Errorf("expected cloud-init to use the Ec2 datasource, got %s", bootArgs)
+		}
+		if !strings.Contains(mmdsConfig, `"V2"`) {
+			t.Errorf("expected mmds to require session tokens, got %s", mmdsConfig)
+		}
+
+		data, err := os.ReadFile(filepath.Join(h.stateDir, "ec21", "metadata.json"))
+		if err != nil {
+			t.Fatalf("reading mmds content: %s", err)
+		}
+		content := map[string]struct {
+			MetaData struct {
+				InstanceID string `json:"instance-id"`
+				PublicKeys map[string]struct {
+					OpenSSHKey string `json:"openssh-key"`
+				} `json:"public-keys"`
+			} `json:"meta-data"`
+			UserData string `json:"user-data"`
+		}{}
+		if err := json.Unmarshal(data, &content); err != nil {
+			t.Fatalf("unmarshalling mmds content: %s", err)
+		}
+		for _, version := range []string{"latest", "2009-04-04"} {
+			metaData := content[version].MetaData
+			if metaData.InstanceID != "ec21" || !strings.HasPrefix(metaData.PublicKeys["0"].OpenSSHKey, "ssh-ed25519 ") {
+				t.Errorf("expected the %s meta-data of the vm, got %+v", version, metaData)
+			}
+			if !strings.Contains(content[version].UserData, "#cloud-config") {
+				t.Errorf("expected the %s user-data, got %q", version, content[version].UserData)
+			}
+		}
+
+		h.run("remove", "ec21")
+		waitForProcessExit(t, r.PID)
+	})
+
+	t.Run("cloud-hypervisor needs the host metadata server", func(t *testing.T) {
+		h := newHarness(t, "cloudhypervisor")
+
+		h.create("ec22", "--datasource", "ec2")
+		if h.vm("ec22") != nil {
+			t.Errorf("expected the vm to not be created without a metadata service")
+		}
+
+		h.create("ec23", "--datasource", "ec2", "--host-metadata")
+		r := h.waitForRecord("ec23", func(r *record) bool { return r.PID != 0 })
+		if !hasArg(r, "ci.ds=Ec2") {
+			t.Errorf("expected cloud-init to use the Ec2 datasource, got %v", r.Args)
+		}
+
+		h.run("remove", "ec23")
+		waitForProcessExit(t, r.PID)
+	})
+}
@@ -0,0 +1,523 @@
+// fakevmm is a fake firecracker and cloud-hypervisor binary used by the e2e tests.
+//
+// It behaves as firecracker if its called firecracker and as cloud-hypervisor
+// otherwise. It serves the api socket, records the args and api requests it
+// receives and then stays running until its told to shutdown or is signalled,
+// just like a real vm process. The serial console echoes its input back and a
+// line is written to the log once its configured. In firecracker mode the
+// metrics are written when they're flushed. If a vsock device is configured the
+// guest agent is served on it, running commands on the host, and ready is
+// signalled with guestIP as the ip address. If the user-data asks cloud-init to
+// phone home once its finished, the fake posts to the url like cloud-init does.
+//
+// It can be controlled with these environment variables:
+//
+//	FAKEVMM_RECORD_DIR   the directory to write the record to, as <vm name>.json.
+//	FAKEVMM_CRASH_AFTER  a duration after which the process will crash.
+//	FAKEVMM_EXIT_CODE    the exit code to use when crashing, defaults to 1.
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/mikrolite/mikrolite/agent"
+	"github.com/mikrolite/mikrolite/cloudinit"
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/ignition"
+)
+
+const (
+	modeFirecracker     = "firecracker"
+	modeCloudHypervisor = "cloud-hypervisor"
+
+	envRecordDir  = "FAKEVMM_RECORD_DIR"
+	envCrashAfter = "FAKEVMM_CRASH_AFTER"
+	envExitCode   = "FAKEVMM_EXIT_CODE"
+
+	// guestIP is the ip address the guest agent reports.
+	guestIP = "192.168.122.60"
+)
+
+// Record is what the fake writes to the record file.
+type Record struct {
+	Mode       string    `json:"mode"`
+	PID        int       `json:"pid"`
+	Args       []string  `json:"args"`
+	Requests   []Request `json:"requests"`
+	Exited     bool      `json:"exited"`
+	ExitReason string    `json:"exit_reason,omitempty"`
+}
+
+// Request is an api request received by the fake.
+type Request struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+type fakeVMM struct {
+	mu          sync.Mutex
+	record      Record
+	recordFile  string
+	metricsPath string
+	vsockPath   string
+	exitCh      chan exitRequest
+}
+
+type exitRequest struct {
+	reason string
+	code   int
+}
+
+func main() {
+	mode := modeCloudHypervisor
+	if strings.HasPrefix(filepath.Base(os.Args[0]), modeFirecracker) {
+		mode = modeFirecracker
+	}
+
+	socketPath := socketFromArgs(mode, os.Args[1:])
+	if socketPath == "" {
+		fmt.Fprintln(os.Stderr, "no api socket supplied")
+		os.Exit(2)
+	}
+
+	f := &fakeVMM{
+		record: Record{
+			Mode:     mode,
+			PID:      os.Getpid(),
+			Args:     os.Args[1:],
+			Requests: []Request{},
+		},
+		exitCh: make(chan exitRequest, 1),
+	}
+	if dir := os.Getenv(envRecordDir); dir != "" {
+		vmName := filepath.Base(filepath.Dir(socketPath))
+		f.recordFile = filepath.Join(dir, fmt.Sprintf("%s.json", vmName))
+	}
+	f.save()
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "listening on %s: %s\n", socketPath, err)
+		os.Exit(2)
+	}
+	defer os.Remove(socketPath)
+
+	server := &http.Server{Handler: f.handler(mode)}
+	go server.Serve(listener)
+	go serialConsole()
+	go phoneHome(filepath.Dir(socketPath))
+	if logPath := flagValue(os.Args[1:], "--log-file"); mode == modeCloudHypervisor && logPath != "" {
+		writeLog(logPath, "cloud-hypervisor: 0.1ms: <vmm> INFO:vmm/src/lib.rs:1 fakevmm started")
+	}
+	if vsock := flagValue(os.Args[1:], "--vsock"); mode == modeCloudHypervisor && vsock != "" {
+		for _, option := range strings.Split(vsock, ",") {
+			if path, found := strings.CutPrefix(option, "socket="); found {
+				f.startVSock(path)
+			}
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		f.exit(fmt.Sprintf("signal %s", sig), 0)
+	}()
+
+	if crashAfter := os.Getenv(envCrashAfter); crashAfter != "" {
+		duration, err := time.ParseDuration(crashAfter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "parsing %s: %s\n", envCrashAfter, err)
+			os.Exit(2)
+		}
+		code := 1
+		if value := os.Getenv(envExitCode); value != "" {
+			code, _ = strconv.Atoi(value)
+		}
+		time.AfterFunc(duration, func() { f.exit("crash", code) })
+	}
+
+	req := <-f.exitCh
+
+	f.mu.Lock()
+	f.record.Exited = true
+	f.record.ExitReason = req.reason
+	f.mu.Unlock()
+	f.save()
+
+	if req.code != 0 {
+		fmt.Fprintf(os.Stderr, "fakevmm crashed with exit code %d\n", req.code)
+	}
+	server.Close()
+	os.Remove(socketPath)
+	f.mu.Lock()
+	if f.vsockPath != "" {
+		os.Remove(f.vsockPath)
+	}
+	f.mu.Unlock()
+	os.Exit(req.code)
+}
+
+// serialConsole emulates the serial console of the vm, which is stdin and
+// stdout. A login prompt is written and then the input is echoed back.
+func serialConsole() {
+	fmt.Print("fakevmm login: ")
+
+	buf := make([]byte, 1024)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if n > 0 {
+			fmt.Printf("echo: %s", buf[:n])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeVMM) handler(mode string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		req := Request{
+			Method: r.Method,
+			Path:   r.URL.Path,
+		}
+		if json.Valid(body) {
+			req.Body = body
+		}
+
+		f.mu.Lock()
+		f.record.Requests = append(f.record.Requests, req)
+		f.mu.Unlock()
+		f.save()
+
+		if mode == modeFirecracker {
+			f.handleFirecracker(w, r, body)
+		} else {
+			f.handleCloudHypervisor(w, r)
+		}
+	})
+}
+
+func (f *fakeVMM) handleFirecracker(w http.ResponseWriter, r *http.Request, body []byte) {
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/machine-config":
+		writeJSON(w, map[string]interface{}{"vcpu_count": 1, "mem_size_mib": 128, "smt": false})
+	case r.Method == http.MethodPut && r.URL.Path == "/logger":
+		logger := struct {
+			LogPath string `json:"log_path"`
+		}{}
+		json.Unmarshal(body, &logger)
+		w.WriteHeader(http.StatusNoContent)
+		writeLog(logger.LogPath, time.Now().Format("2006-01-02T15:04:05.000000000")+" [fakevmm:main] fakevmm started")
+	case r.Method == http.MethodGet && r.URL.Path == "/":
+		writeJSON(w, map[string]interface{}{"id": "fakevmm", "state": "Running", "vmm_version": "1.5.0", "app_name": "Firecracker"})
+	case r.Method == http.MethodPut && r.URL.Path == "/actions":
+		action := struct {
+			ActionType string `json:"action_type"`
+		}{}
+		json.Unmarshal(body, &action)
+		w.WriteHeader(http.StatusNoContent)
+		switch action.ActionType {
+		case "SendCtrlAltDel":
+			f.exit("shutdown", 0)
+		case "FlushMetrics":
+			f.flushMetrics()
+		}
+	case r.Method == http.MethodPut && r.URL.Path == "/metrics":
+		metrics := struct {
+			MetricsPath string `json:"metrics_path"`
+		}{}
+		json.Unmarshal(body, &metrics)
+		f.mu.Lock()
+		f.metricsPath = metrics.MetricsPath
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && r.URL.Path == "/vsock":
+		vsock := struct {
+			UDSPath string `json:"uds_path"`
+		}{}
+		json.Unmarshal(body, &vsock)
+		f.startVSock(vsock.UDSPath)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet:
+		writeJSON(w, map[string]interface{}{})
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeVMM) handleCloudHypervisor(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/api/v1/vmm.ping":
+		writeJSON(w, map[string]interface{}{"version": "36.0"})
+	case "/api/v1/vm.info":
+		writeJSON(w, map[string]interface{}{"state": "Running"})
+	case "/api/v1/vm.counters":
+		writeJSON(w, map[string]interface{}{
+			"_disk0": map[string]interface{}{"read_bytes": 4096, "read_ops": 1},
+			"_net1":  map[string]interface{}{"rx_bytes": 100, "tx_bytes": 200},
+		})
+	case "/api/v1/vm.add-disk":
+		writeJSON(w, map[string]interface{}{"id": "disk", "bdf": "0000:00:06.0"})
+	case "/api/v1/vm.shutdown", "/api/v1/vmm.shutdown":
+		w.WriteHeader(http.StatusNoContent)
+		f.exit("shutdown", 0)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// startVSock serves the hybrid vsock socket. Connections to agent.Port are
+// served by the guest agent, which signals ready by connecting to the host.
+func (f *fakeVMM) startVSock(path string) {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "listening on %s: %s\n", path, err)
+		return
+	}
+	f.mu.Lock()
+	f.vsockPath = path
+	f.mu.Unlock()
+
+	hostname := filepath.Base(filepath.Dir(path))
+	server := &agent.Server{Info: func() (*domain.GuestInfo, error) {
+		return &domain.GuestInfo{
+			Hostname: hostname,
+			Interfaces: []domain.GuestInterface{
+				{Name: "eth0", Addresses: []string{guestIP + "/24"}},
+			},
+			UptimeSeconds: 1,
+			LoadAverage:   []float64{0, 0, 0},
+		}, nil
+	}}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveVSockConn(server, conn)
+		}
+	}()
+
+	go func() {
+		dial := func(ctx context.Context) (net.Conn, error) {
+			dialer := &net.Dialer{}
+			return dialer.DialContext(ctx, "unix", fmt.Sprintf("%s_%d", path, agent.ReadyPort))
+		}
+		agent.SignalReady(context.Background(), dial, server.Info)
+	}()
+}
+
+// phoneHome waits for the provider to save the vm state, which has the
+// user-data, and posts to the phone_home url in it like cloud-init does once
+// the vm has booted.
+func phoneHome(stateDir string) {
+	for i := 0; i < 100; i++ {
+		time.Sleep(100 * time.Millisecond)
+
+		data, err := os.ReadFile(filepath.Join(stateDir, "vm.json"))
+		if err != nil {
+			continue
+		}
+		vm := &domain.VM{}
+		if err := json.Unmarshal(data, vm); err != nil || vm.Status == nil {
+			continue
+		}
+		phoneHomeURL := ""
+		if encoded, ok := vm.Status.Metadata[ignition.ConfigKey]; ok {
+			phoneHomeURL = ignitionPhoneHomeURL(encoded)
+		} else if encoded, ok := vm.Status.Metadata[cloudinit.UserdataKey]; ok {
+			phoneHomeURL = cloudInitPhoneHomeURL(encoded)
+		}
+		if phoneHomeURL == "" {
+			return
+		}
+
+		form := url.Values{}
+		form.Set("instance_id", vm.Name)
+		form.Set("hostname", vm.Name)
+		resp, err := http.PostForm(phoneHomeURL, form)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "phoning home: %s\n", err)
+			return
+		}
+		resp.Body.Close()
+
+		return
+	}
+}
+
+// cloudInitPhoneHomeURL returns the phone_home url in the user-data.
+func cloudInitPhoneHomeURL(encoded string) string {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return ""
+	}
+	// The merged cloud-config is the first part of multipart user-data
+	parts, err := cloudinit.ParseUserData(raw)
+	if err != nil || parts[0].ContentType != cloudinit.ContentTypeCloudConfig {
+		return ""
+	}
+	userdata := &cloudinit.UserData{}
+	if err := yaml.Unmarshal(parts[0].Content, userdata); err != nil || userdata.PhoneHome == nil {
+		return ""
+	}
+
+	return userdata.PhoneHome.URL
+}
+
+// ignitionPhoneHomeURL returns the url that the unit in the Ignition config
+// posts to once the vm has booted, its the last arg of the curl command.
+func ignitionPhoneHomeURL(encoded string) string {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return ""
+	}
+	config := &ignition.Config{}
+	if err := json.Unmarshal(raw, config); err != nil || config.Systemd == nil {
+		return ""
+	}
+	for _, unit := range config.Systemd.Units {
+		for _, line := range strings.Split(unit.Contents, "\n") {
+			if command, ok := strings.CutPrefix(line, "ExecStart=/usr/bin/curl "); ok {
+				fields := strings.Fields(command)
+				return fields[len(fields)-1]
+			}
+		}
+	}
+
+	return ""
+}
+
+// serveVSockConn handles the CONNECT handshake and serves the agent.
+func serveVSockConn(server *agent.Server, conn net.Conn) {
+	line := []byte{}
+	b := make([]byte, 1)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			conn.Close()
+			return
+		}
+		if b[0] == '\n' {
+			break
+		}
+		line = append(line, b[0])
+	}
+
+	if string(line) != fmt.Sprintf("CONNECT %d", agent.Port) {
+		conn.Close()
+		return
+	}
+	fmt.Fprintf(conn, "OK %d\n", 1073741824)
+	server.ServeConn(context.Background(), conn)
+}
+
+// flushMetrics writes a line of metrics. Like firecracker the counters are the
+// change since the last line, which is the same each time.
+func (f *fakeVMM) flushMetrics() {
+	f.mu.Lock()
+	path := f.metricsPath
+	f.mu.Unlock()
+	if path == "" {
+		return
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{
+		"utc_timestamp_ms": time.Now().UnixMilli(),
+		"api_server":       map[string]interface{}{"process_startup_time_us": 42},
+		"net":              map[string]interface{}{"rx_bytes_count": 100},
+		"net_eth0":         map[string]interface{}{"rx_bytes_count": 100},
+		"vcpu":             map[string]interface{}{"exit_io_in_agg": map[string]interface{}{"min_us": 1, "max_us": 5}},
+	})
+	writeLog(path, string(data))
+}
+
+func (f *fakeVMM) exit(reason string, code int) {
+	select {
+	case f.exitCh <- exitRequest{reason: reason, code: code}:
+	default:
+	}
+}
+
+func (f *fakeVMM) save() {
+	if f.recordFile == "" {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := json.MarshalIndent(f.record, "", " ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshalling record: %s\n", err)
+		return
+	}
+
+	tmpFile := f.recordFile + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "writing record: %s\n", err)
+		return
+	}
+	os.Rename(tmpFile, f.recordFile)
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeLog appends a line to a log or metrics file, which is a fifo when started by
+// mikrolite. Like the real vm processes the log isn't opened with O_APPEND.
+func writeLog(path string, line string) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "opening log %s: %s\n", path, err)
+		return
+	}
+	defer file.Close()
+
+	fmt.Fprintln(file, line)
+}
+
+func socketFromArgs(mode string, args []string) string {
+	if mode == modeFirecracker {
+		return flagValue(args, "--api-sock")
+	}
+
+	return flagValue(args, "--api-socket")
+}
+
+func flagValue(args []string, flag string) string {
+	for i, arg := range args {
+		if arg == flag && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(arg, flag+"=") {
+			return strings.TrimPrefix(arg, flag+"=")
+		}
+	}
+
+	return ""
+}
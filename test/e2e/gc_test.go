@@ -0,0 +1,94 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mikrolite/mikrolite/internal/commands/gc"
+)
+
+func TestGC(t *testing.T) {
+	h := newHarness(t, "cloudhypervisor")
+
+	h.create("g1")
+	h.create("g2")
+	h.waitForRecord("g1", func(r *record) bool { return r.PID != 0 })
+	stopped := h.waitForRecord("g2", func(r *record) bool { return r.PID != 0 })
+	if err := syscall.Kill(stopped.PID, syscall.SIGKILL); err != nil {
+		t.Fatalf("killing vm process: %s", err)
+	}
+	waitForProcessExit(t, stopped.PID)
+	stoppedTap := h.vm("g2").Status.NetworkStatus["eth0"].HostDeviveName
+
+	// Leave behind the resources of a create that failed a while ago
+	staleDir := filepath.Join(h.stateDir, "stale")
+	if err := os.Mkdir(staleDir, 0o755); err != nil {
+		t.Fatalf("creating state dir: %s", err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(staleDir, old, old); err != nil {
+		t.Fatalf("changing state dir times: %s", err)
+	}
+	h.image.Owners["vm-stale"] = true
+	h.network.Interfaces["mlt99"] = "02:00:00:00:00:99"
+
+	// Orphans are only listed without force
+	h.gc()
+
+	if _, err := os.Stat(staleDir); err != nil {
+		t.Errorf("expected state dir not to be removed: %s", err)
+	}
+	if !h.image.Owners["vm-stale"] {
+		t.Errorf("expected images not to be removed")
+	}
+
+	h.gc("--force")
+
+	if _, err := os.Stat(staleDir); !os.IsNotExist(err) {
+		t.Errorf("expected state dir to be removed")
+	}
+	if h.image.Owners["vm-stale"] {
+		t.Errorf("expected images to be removed")
+	}
+	if _, ok := h.network.Interfaces["mlt99"]; ok {
+		t.Errorf("expected interface to be removed")
+	}
+	if h.vm("g2") == nil {
+		t.Errorf("expected stopped vm not to be removed")
+	}
+
+	h.gc("--force", "--include-stopped")
+
+	if h.vm("g2") != nil {
+		t.Errorf("expected stopped vm to be removed")
+	}
+	if h.image.Owners["vm-g2"] {
+		t.Errorf("expected stopped vm images to be removed")
+	}
+	if _, ok := h.network.Interfaces[stoppedTap]; ok {
+		t.Errorf("expected stopped vm interface to be removed")
+	}
+	if h.vm("g1") == nil || !h.image.Owners["vm-g1"] {
+		t.Errorf("expected running vm not to be removed")
+	}
+
+	h.run("remove", "g1")
+}
+
+// gc runs the gc command with the args.
+func (h *harness) gc(args ...string) {
+	h.t.Helper()
+
+	cmd := gc.NewGCCommandWithDependencies(h.deps())
+	cmd.SetOut(os.Stderr)
+
+	if err := h.executeCommand(context.Background(), cmd, args...); err != nil {
+		h.t.Fatalf("running gc %v: %s", args, err)
+	}
+}
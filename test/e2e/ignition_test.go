@@ -0,0 +1,110 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs"
+
+	"github.com/mikrolite/mikrolite/ignition"
+)
+
+func TestIgnition(t *testing.T) {
+	dir := t.TempDir()
+	unit := filepath.Join(dir, "app.service")
+	if err := os.WriteFile(unit, []byte("[Service]\nExecStart=/usr/bin/app\n"), 0o644); err != nil {
+		t.Fatalf("writing unit: %s", err)
+	}
+
+	t.Run("firecracker reads the config from mmds", func(t *testing.T) {
+		h := newHarness(t, "firecracker")
+
+		// The ready unit phones home like cloud-init does
+		h.create("ign1", "--bootstrap-format", "ignition", "--unit", unit, "--wait-for", "ready")
+		vm := h.vm("ign1")
+		if vm == nil || !vm.Status.Ready {
+			t.Fatalf("expected the vm to be ready, got %+v", vm)
+		}
+
+		raw, err := base64.StdEncoding.DecodeString(vm.Status.Metadata[ignition.ConfigKey])
+		if err != nil {
+			t.Fatalf("decoding ignition config: %s", err)
+		}
+		config := &ignition.Config{}
+		if err := json.Unmarshal(raw, config); err != nil {
+			t.Fatalf("unmarshalling ignition config: %s", err)
+		}
+		if config.Systemd == nil || len(config.Systemd.Units) != 2 || config.Systemd.Units[0].Name != "app.service" {
+			t.Errorf("expected the unit and the ready unit, got %+v", config.Systemd)
+		}
+
+		r := h.waitForRecord("ign1", func(r *record) bool { return hasRequest(r, "PUT", "/actions") })
+		bootArgs := ""
+		for _, req := range r.Requests {
+			if req.Path == "/boot-source" {
+				bootArgs = string(req.Body)
+			}
+		}
+		if !strings.Contains(bootArgs, "ignition.config.url=http://169.254.169.254/latest/ignition") || strings.Contains(bootArgs, "ds=nocloud") {
+			t.Errorf("expected ignition to read the config from mmds, got %s", bootArgs)
+		}
+
+		h.run("remove", "ign1")
+		waitForProcessExit(t, r.PID)
+	})
+
+	t.Run("cloud-hypervisor reads the config from a config drive", func(t *testing.T) {
+		h := newHarness(t, "cloudhypervisor")
+
+		h.create("ign2", "--bootstrap-format", "ignition")
+		r := h.waitForRecord("ign2", func(r *record) bool { return r.PID != 0 })
+		if !hasArg(r, "ignition.platform.id=openstack") {
+			t.Errorf("expected the openstack platform, got %v", r.Args)
+		}
+		configDrive, err := diskfs.Open(filepath.Join(h.stateDir, "ign2", "config-drive.img"), diskfs.WithOpenMode(diskfs.ReadOnly))
+		if err != nil {
+			t.Fatalf("opening config drive: %s", err)
+		}
+		fs, err := configDrive.GetFilesystem(0)
+		if err != nil {
+			t.Fatalf("reading config drive filesystem: %s", err)
+		}
+		if label := strings.TrimSpace(fs.Label()); label != ignition.ConfigDriveLabel {
+			t.Errorf("expected label %s, got %s", ignition.ConfigDriveLabel, label)
+		}
+		file, err := fs.OpenFile(ignition.ConfigDrivePath, os.O_RDONLY)
+		if err != nil {
+			t.Fatalf("opening ignition config on the config drive: %s", err)
+		}
+		data, err := io.ReadAll(file)
+		if err != nil {
+			t.Fatalf("reading ignition config: %s", err)
+		}
+		if err := ignition.Validate(data); err != nil {
+			t.Errorf("expected an ignition config on the config drive: %s", err)
+		}
+
+		h.run("remove", "ign2")
+		waitForProcessExit(t, r.PID)
+	})
+
+	t.Run("cloud-config user-data is rejected", func(t *testing.T) {
+		h := newHarness(t, "firecracker")
+
+		userData := filepath.Join(dir, "user-data")
+		if err := os.WriteFile(userData, []byte("#cloud-config\nhostname: vm1\n"), 0o644); err != nil {
+			t.Fatalf("writing user-data: %s", err)
+		}
+		h.create("ign3", "--bootstrap-format", "ignition", "--user-data", userData)
+		if h.vm("ign3") != nil {
+			t.Errorf("expected the vm with cloud-config user-data to not be created")
+		}
+	})
+}
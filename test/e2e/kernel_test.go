@@ -0,0 +1,79 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKernelCmdLine(t *testing.T) {
+	t.Run("cloud-hypervisor keeps the order and boots the initrd", func(t *testing.T) {
+		h := newHarness(t, "cloudhypervisor")
+		if err := os.WriteFile(filepath.Join(h.kernelDir, "initrd.img"), []byte("initrd"), 0o644); err != nil {
+			t.Fatalf("creating initrd: %s", err)
+		}
+
+		h.create("k1", "--kernel-cmdline", "console=ttyS0 console=hvc0 root=/dev/vda rw init=/sbin/init -- single", "--initrd-path", h.kernelDir)
+		r := h.waitForRecord("k1", func(r *record) bool { return r.PID != 0 })
+
+		cmdLine, initrd := "", ""
+		for i, arg := range r.Args {
+			switch {
+			case arg == "--cmdline" && i+1 < len(r.Args):
+				cmdLine = r.Args[i+1]
+			case arg == "--initramfs" && i+1 < len(r.Args):
+				initrd = r.Args[i+1]
+			}
+		}
+		if !strings.HasPrefix(cmdLine, "console=ttyS0 console=hvc0 root=/dev/vda rw init=/sbin/init ") || !strings.HasSuffix(cmdLine, " -- single") {
+			t.Errorf("expected the cmdline in order with the init args last, got %q", cmdLine)
+		}
+		if initrd != filepath.Join(h.kernelDir, "initrd.img") {
+			t.Errorf("expected the initrd, got %q", initrd)
+		}
+
+		// The cmdline is the same when the vm is started again
+		if saved := h.vm("k1").Spec.Kernel.CmdLine.String(); saved != cmdLine {
+			t.Errorf("expected the saved cmdline %q, got %q", cmdLine, saved)
+		}
+
+		h.run("remove", "k1")
+		waitForProcessExit(t, r.PID)
+	})
+
+	t.Run("firecracker boots the initrd", func(t *testing.T) {
+		h := newHarness(t, "firecracker")
+		if err := os.WriteFile(filepath.Join(h.kernelDir, "initrd.img"), []byte("initrd"), 0o644); err != nil {
+			t.Fatalf("creating initrd: %s", err)
+		}
+
+		h.create("k2", "--initrd-path", h.kernelDir)
+		r := h.waitForRecord("k2", func(r *record) bool { return hasRequest(r, "PUT", "/actions") })
+
+		for _, req := range r.Requests {
+			if req.Path != "/boot-source" {
+				continue
+			}
+			bootSource := struct {
+				BootArgs   string `json:"boot_args"`
+				InitrdPath string `json:"initrd_path"`
+			}{}
+			if err := json.Unmarshal(req.Body, &bootSource); err != nil {
+				t.Fatalf("unmarshalling boot source: %s", err)
+			}
+			if bootSource.InitrdPath != filepath.Join(h.kernelDir, "initrd.img") {
+				t.Errorf("expected the initrd in the boot source, got %s", req.Body)
+			}
+			if !strings.HasPrefix(bootSource.BootArgs, "console=ttyS0 reboot=k panic=1 pci=off i8042.noaux i8042.nomux i8042.nopnp i8042.dumbkbd ") {
+				t.Errorf("expected the default args in order, got %q", bootSource.BootArgs)
+			}
+		}
+
+		h.run("remove", "k2")
+		waitForProcessExit(t, r.PID)
+	})
+}
@@ -0,0 +1,58 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/mikrolite/mikrolite/internal/commands/vm"
+)
+
+// logs runs vm logs with the args and returns the output.
+func (h *harness) logs(args ...string) string {
+	h.t.Helper()
+
+	out := &syncBuffer{}
+	cmd := vm.NewVMCommandWithDependencies(h.deps())
+	cmd.SetOut(out)
+	cmd.SetErr(io.Discard)
+
+	if err := h.executeCommand(context.Background(), cmd, append([]string{"logs"}, args...)...); err != nil {
+		h.t.Fatalf("running logs %v: %s", args, err)
+	}
+
+	return out.String()
+}
+
+func TestLogs(t *testing.T) {
+	h := newHarness(t, "cloudhypervisor")
+
+	h.create("log1")
+	r := h.waitForRecord("log1", func(r *record) bool { return r.PID != 0 })
+	waitForOutput(t, func() string { return h.logs("log1", "--source", "console") }, "[console] fakevmm login: ")
+
+	all := h.logs("log1")
+	if !strings.Contains(all, "[mikrolite] INFO vm created") {
+		t.Errorf("expected the mikrolite log to be shown, got:\n%s", all)
+	}
+	if strings.Index(all, "[mikrolite] INFO vm created") > strings.Index(all, "[console] fakevmm login: ") {
+		t.Errorf("expected the lines to be in time order, got:\n%s", all)
+	}
+
+	if since := h.logs("log1", "--since", "2030-01-01T00:00:00Z"); since != "" {
+		t.Errorf("expected no lines since a time in the future, got:\n%s", since)
+	}
+	hypervisor := h.logs("log1", "--source", "hypervisor")
+	if strings.Contains(hypervisor, "[console]") || strings.Contains(hypervisor, "[mikrolite]") {
+		t.Errorf("expected only the hypervisor lines, got:\n%s", hypervisor)
+	}
+	if !strings.Contains(hypervisor, "[hypervisor] cloud-hypervisor: 0.1ms: <vmm> INFO:vmm/src/lib.rs:1 fakevmm started") {
+		t.Errorf("expected the hypervisor log written to the fifo to be shown, got:\n%s", hypervisor)
+	}
+
+	h.run("remove", "log1")
+	waitForProcessExit(t, r.PID)
+}
@@ -0,0 +1,70 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mikrolite/mikrolite/cloudinit"
+	"github.com/mikrolite/mikrolite/defaults"
+	"github.com/mikrolite/mikrolite/internal/factory"
+)
+
+func TestHostMetadata(t *testing.T) {
+	h := newHarness(t, "cloudhypervisor")
+
+	h.create("md1", "--host-metadata")
+
+	r := h.waitForRecord("md1", func(r *record) bool { return r.PID != 0 })
+	if !hasArg(r, "ds=nocloud-net;s="+defaults.MetadataURL) {
+		t.Errorf("expected the vm to get its metadata from the host, got %v", r.Args)
+	}
+	if hasArg(r, "id=cidata") {
+		t.Errorf("expected no cloud-init disk, got %v", r.Args)
+	}
+	if _, err := os.Stat(filepath.Join(h.stateDir, "md1", "cloud-init.img")); !os.IsNotExist(err) {
+		t.Errorf("expected the cloud-init image not to be created")
+	}
+
+	vm := h.vm("md1")
+	if vm == nil || vm.Status.MetadataURL != defaults.MetadataURL {
+		t.Fatalf("expected the vm to use the host metadata server, got %+v", vm)
+	}
+	eth1 := vm.Spec.NetworkConfiguration.Interfaces["eth1"]
+	address := strings.TrimSuffix(eth1.StaticIPv4Address.Address, "/16")
+	status := vm.Status.NetworkStatus["eth1"]
+	if h.network.Routes[address] != status.HostDeviveName {
+		t.Errorf("expected a route to %s over %s, got %v", address, status.HostDeviveName, h.network.Routes)
+	}
+
+	// The metadata server finds the vm by the address of its metadata interface
+	server := factory.NewMetadataServer(factory.Config{StateRootPath: h.stateDir}, h.network)
+	get := func(path string, routedOver string) (int, string) {
+		h.network.Routes[address] = routedOver
+		defer func() { h.network.Routes[address] = status.HostDeviveName }()
+
+		req := httptest.NewRequest(http.MethodGet, defaults.MetadataURL+path, nil)
+		req.RemoteAddr = address + ":40000"
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		body, _ := io.ReadAll(rec.Body)
+
+		return rec.Code, string(body)
+	}
+	code, body := get(cloudinit.InstanceDataKey, status.HostDeviveName)
+	if code != http.StatusOK || !strings.Contains(body, "md1") {
+		t.Errorf("expected the meta-data of the vm, got %d %q", code, body)
+	}
+	if code, _ := get(cloudinit.InstanceDataKey, "mlt99"); code != http.StatusForbidden {
+		t.Errorf("expected an address routed over another interface to be rejected, got %d", code)
+	}
+
+	h.run("remove", "md1")
+	waitForProcessExit(t, r.PID)
+}
@@ -0,0 +1,150 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/mikrolite/mikrolite/cloudinit"
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+func TestNetworkConfig(t *testing.T) {
+	t.Run("static ip with nameservers, routes and mtu", func(t *testing.T) {
+		h := newHarness(t, "firecracker")
+
+		h.create("net1",
+			"--static-ip", "192.168.122.20/24",
+			"--static-gateway-ip", "192.168.122.1/24",
+			"--nameserver", "1.1.1.1",
+			"--search-domain", "example.com",
+			"--route", "to=10.10.0.0/16,via=10.0.0.1,metric=200,on-link=true",
+			"--mtu", "1400",
+		)
+		r := h.waitForRecord("net1", func(r *record) bool { return hasRequest(r, "PUT", "/actions") })
+
+		eth0 := networkConfig(t, h, "net1").Ethernet["eth0"]
+		if eth0.MTU != 1400 {
+			t.Errorf("expected mtu 1400, got %d", eth0.MTU)
+		}
+		if len(eth0.Nameservers.Addresses) != 1 || eth0.Nameservers.Addresses[0] != "1.1.1.1" || len(eth0.Nameservers.Search) != 1 || eth0.Nameservers.Search[0] != "example.com" {
+			t.Errorf("expected the nameservers and search domains, got %+v", eth0.Nameservers)
+		}
+		if len(eth0.Routes) != 2 {
+			t.Fatalf("expected the default and static routes, got %+v", eth0.Routes)
+		}
+		if route := eth0.Routes[0]; route.To != "0.0.0.0/0" || route.Via != "192.168.122.1" {
+			t.Errorf("expected the default route via the gateway, got %+v", route)
+		}
+		if route := eth0.Routes[1]; route.To != "10.10.0.0/16" || route.Metric == nil || *route.Metric != 200 || route.OnLink == nil || !*route.OnLink {
+			t.Errorf("expected the static route, got %+v", route)
+		}
+
+		h.run("remove", "net1")
+		waitForProcessExit(t, r.PID)
+	})
+
+	t.Run("dhcp overrides", func(t *testing.T) {
+		h := newHarness(t, "firecracker")
+
+		h.create("net2", "--dhcp-override", "use-dns=false", "--dhcp-override", "route-metric=50")
+		r := h.waitForRecord("net2", func(r *record) bool { return hasRequest(r, "PUT", "/actions") })
+
+		overrides := networkConfig(t, h, "net2").Ethernet["eth0"].DHCP4Overrides
+		if overrides == nil || overrides.UseDNS == nil || *overrides.UseDNS || overrides.RouteMetric == nil || *overrides.RouteMetric != 50 {
+			t.Errorf("expected the dhcp overrides, got %+v", overrides)
+		}
+
+		h.run("remove", "net2")
+		waitForProcessExit(t, r.PID)
+	})
+
+	t.Run("eni in the meta-data of the cidata disk", func(t *testing.T) {
+		h := newHarness(t, "cloudhypervisor")
+
+		h.create("net4", "--network-config-format", "eni", "--mtu", "1400")
+		r := h.waitForRecord("net4", func(r *record) bool { return r.PID != 0 })
+		if hasArg(r, cloudinit.NetworkConfigDataKey+"=") {
+			t.Errorf("expected no network config on the cmdline, got %v", r.Args)
+		}
+
+		vm := h.vm("net4")
+		if _, ok := vm.Status.Metadata[cloudinit.NetworkConfigDataKey]; ok {
+			t.Errorf("expected no network-config with eni")
+		}
+		data, err := base64.StdEncoding.DecodeString(vm.Status.Metadata[cloudinit.InstanceDataKey])
+		if err != nil {
+			t.Fatalf("decoding meta-data: %s", err)
+		}
+		metadata := map[string]string{}
+		if err := yaml.Unmarshal(data, &metadata); err != nil {
+			t.Fatalf("unmarshalling meta-data: %s", err)
+		}
+		if eni := metadata[cloudinit.NetworkInterfacesKey]; !strings.Contains(eni, "iface eth0 inet dhcp\n    mtu 1400\n") {
+			t.Errorf("expected the eni network config in the meta-data, got %q", eni)
+		}
+
+		h.run("remove", "net4")
+		waitForProcessExit(t, r.PID)
+	})
+
+	t.Run("format from the root image label", func(t *testing.T) {
+		h := newHarness(t, "firecracker")
+		h.image.Labels = map[string]map[string]string{
+			"ghcr.io/mikrolite/root:e2e": {domain.NetworkConfigFormatLabel: "eni"},
+		}
+
+		h.create("net5")
+		r := h.waitForRecord("net5", func(r *record) bool { return hasRequest(r, "PUT", "/actions") })
+
+		// Firecracker uses the MMDS, so eni can't be used and v1 is used instead
+		config := h.vm("net5").Status.Metadata[cloudinit.NetworkConfigDataKey]
+		for _, req := range r.Requests {
+			if req.Path == "/boot-source" && !strings.Contains(string(req.Body), cloudinit.NetworkConfigDataKey+"="+config) {
+				t.Errorf("expected the network config on the cmdline, got %s", req.Body)
+			}
+		}
+		data, err := base64.StdEncoding.DecodeString(config)
+		if err != nil {
+			t.Fatalf("decoding network config: %s", err)
+		}
+		if !strings.HasPrefix(string(data), "version: 1\n") {
+			t.Errorf("expected the v1 network config, got %s", data)
+		}
+
+		h.run("remove", "net5")
+		waitForProcessExit(t, r.PID)
+	})
+
+	t.Run("invalid route is rejected", func(t *testing.T) {
+		h := newHarness(t, "firecracker")
+
+		h.create("net3", "--route", "to=10.10.0.0/16,via=gateway")
+		if h.vm("net3") != nil {
+			t.Errorf("expected the vm to not be created with an invalid route")
+		}
+	})
+}
+
+func networkConfig(t *testing.T, h *harness, name string) *cloudinit.Network {
+	t.Helper()
+
+	vm := h.vm(name)
+	if vm == nil {
+		t.Fatalf("expected vm %s to be created", name)
+	}
+	data, err := base64.StdEncoding.DecodeString(vm.Status.Metadata[cloudinit.NetworkConfigDataKey])
+	if err != nil {
+		t.Fatalf("decoding network config: %s", err)
+	}
+	network := &cloudinit.Network{}
+	if err := yaml.Unmarshal(data, network); err != nil {
+		t.Fatalf("unmarshalling network config: %s", err)
+	}
+
+	return network
+}
@@ -0,0 +1,208 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/mikrolite/mikrolite/adapters/ssh"
+	"github.com/mikrolite/mikrolite/internal/commands/vm"
+)
+
+// startSSHServer starts an ssh server on localhost that accepts the public key
+// and runs the commands with sh, standing in for sshd in the vm. It returns the
+// port.
+func startSSHServer(t *testing.T, publicKey gossh.PublicKey) int {
+	t.Helper()
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating host key: %s", err)
+	}
+	hostSigner, err := gossh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatalf("creating host key signer: %s", err)
+	}
+
+	config := &gossh.ServerConfig{
+		PublicKeyCallback: func(conn gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+			if conn.User() != ssh.DefaultUser || string(key.Marshal()) != string(publicKey.Marshal()) {
+				return nil, errors.New("unauthorized")
+			}
+
+			return &gossh.Permissions{}, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, config)
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func serveSSH(conn net.Conn, config *gossh.ServerConfig) {
+	_, chans, reqs, err := gossh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go gossh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		go func() {
+			defer channel.Close()
+
+			for req := range requests {
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+				req.Reply(true, nil)
+
+				cmd := exec.Command("sh", "-c", string(req.Payload[4:]))
+				cmd.Stdin = channel
+				cmd.Stdout = channel
+				cmd.Stderr = channel.Stderr()
+				status := 0
+				if err := cmd.Run(); err != nil {
+					status = 255
+					exitErr := &exec.ExitError{}
+					if errors.As(err, &exitErr) {
+						status = exitErr.ExitCode()
+					}
+				}
+
+				payload := make([]byte, 4)
+				binary.BigEndian.PutUint32(payload, uint32(status))
+				channel.SendRequest("exit-status", false, payload)
+
+				return
+			}
+		}()
+	}
+}
+
+// writeSSHKey writes a key pair to the directory and returns the path of the
+// public key and the key.
+func writeSSHKey(t *testing.T, dir string) (string, gossh.PublicKey) {
+	t.Helper()
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %s", err)
+	}
+	block, err := gossh.MarshalPrivateKey(privateKey, "")
+	if err != nil {
+		t.Fatalf("marshalling private key: %s", err)
+	}
+	signer, err := gossh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatalf("creating signer: %s", err)
+	}
+
+	path := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatalf("writing private key: %s", err)
+	}
+	if err := os.WriteFile(path+".pub", gossh.MarshalAuthorizedKey(signer.PublicKey()), 0o600); err != nil {
+		t.Fatalf("writing public key: %s", err)
+	}
+
+	return path + ".pub", signer.PublicKey()
+}
+
+// exec runs vm exec with the args and returns the stdout, stderr and error.
+func (h *harness) exec(args ...string) (string, string, error) {
+	h.t.Helper()
+
+	stdout := &syncBuffer{}
+	stderr := &syncBuffer{}
+	cmd := vm.NewVMCommandWithDependencies(h.deps())
+	cmd.SetIn(strings.NewReader(""))
+	cmd.SetOut(stdout)
+	cmd.SetErr(stderr)
+
+	err := h.executeCommand(context.Background(), cmd, append([]string{"exec"}, args...)...)
+
+	return stdout.String(), stderr.String(), err
+}
+
+func TestExec(t *testing.T) {
+	h := newHarness(t, "cloudhypervisor")
+	h.network.DefaultIP = "127.0.0.1"
+
+	publicKeyPath, publicKey := writeSSHKey(t, t.TempDir())
+	port := fmt.Sprint(startSSHServer(t, publicKey))
+
+	h.create("ssh1", "--ssh-key", publicKeyPath)
+	r := h.waitForRecord("ssh1", func(r *record) bool { return r.PID != 0 })
+
+	stdout, stderr, err := h.exec("ssh1", "--port", port, "--", "echo", "hello;", "echo", "oops", ">&2")
+	if err != nil {
+		t.Fatalf("expected the command to succeed, got %s", err)
+	}
+	if stdout != "hello\n" || stderr != "oops\n" {
+		t.Errorf("expected the stdout and stderr of the command, got %q and %q", stdout, stderr)
+	}
+
+	_, _, err = h.exec("ssh1", "--port", port, "--", "exit", "3")
+	exitErr := &ssh.ExitError{}
+	if !errors.As(err, &exitErr) || exitErr.Code != 3 {
+		t.Errorf("expected exit status 3, got %v", err)
+	}
+
+	knownHosts, err := os.ReadFile(filepath.Join(h.stateDir, "ssh1", ssh.KnownHostsFile))
+	if err != nil || !strings.Contains(string(knownHosts), "ssh-ed25519") {
+		t.Errorf("expected the host key to be recorded in the state directory, got %q: %v", knownHosts, err)
+	}
+
+	// A vm created without an ssh key needs the private key to be supplied
+	h.create("ssh2")
+	r2 := h.waitForRecord("ssh2", func(r *record) bool { return r.PID != 0 })
+	_, _, err = h.exec("ssh2", "--port", port, "--", "true")
+	if err == nil || !strings.Contains(err.Error(), "--identity") {
+		t.Errorf("expected an error about --identity, got %v", err)
+	}
+	_, _, err = h.exec("ssh2", "--port", port, "--identity", strings.TrimSuffix(publicKeyPath, ".pub"), "--", "true")
+	if err != nil {
+		t.Errorf("expected the identity to be used, got %s", err)
+	}
+
+	h.run("remove", "ssh1")
+	h.run("remove", "ssh2")
+	waitForProcessExit(t, r.PID)
+	waitForProcessExit(t, r2.PID)
+}
@@ -0,0 +1,150 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mikrolite/mikrolite/api/v1alpha1"
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/internal/commands/vm"
+)
+
+// stats runs vm stats with the args and returns the output.
+func (h *harness) stats(args ...string) string {
+	h.t.Helper()
+
+	out := &syncBuffer{}
+	cmd := vm.NewVMCommandWithDependencies(h.deps())
+	cmd.SetOut(out)
+	cmd.SetErr(io.Discard)
+
+	if err := h.executeCommand(context.Background(), cmd, append([]string{"stats"}, args...)...); err != nil {
+		h.t.Fatalf("running stats %v: %s", args, err)
+	}
+
+	return out.String()
+}
+
+// statsJSON runs vm stats for the vm and decodes the json output.
+func (h *harness) statsJSON(name string) *domain.VMStats {
+	h.t.Helper()
+
+	stats := &domain.VMStats{}
+	if err := json.Unmarshal([]byte(h.stats(name, "--json")), stats); err != nil {
+		h.t.Fatalf("decoding stats: %s", err)
+	}
+
+	return stats
+}
+
+// findMetric returns the value of the metric with the name and device label.
+func findMetric(t *testing.T, stats *domain.VMStats, name string, device string) float64 {
+	t.Helper()
+
+	for _, metric := range stats.Metrics {
+		if metric.Name == name && metric.Labels["device"] == device {
+			return metric.Value
+		}
+	}
+	t.Fatalf("expected metric %s for device %q, got %+v", name, device, stats.Metrics)
+
+	return 0
+}
+
+func TestFirecrackerStats(t *testing.T) {
+	h := newHarness(t, "firecracker")
+
+	h.create("st1")
+	r := h.waitForRecord("st1", func(r *record) bool { return hasRequest(r, "PUT", "/metrics") && r.PID != 0 })
+
+	stats := h.statsJSON("st1")
+	if stats.Name != "st1" || stats.Provider != "firecracker" {
+		t.Errorf("expected stats for st1 from firecracker, got %s from %s", stats.Name, stats.Provider)
+	}
+	if value := findMetric(t, stats, "firecracker_net_rx_bytes_count", "eth0"); value != 100 {
+		t.Errorf("expected 100 bytes received, got %v", value)
+	}
+	if value := findMetric(t, stats, "firecracker_api_server_process_startup_time_us", ""); value != 42 {
+		t.Errorf("expected startup time of 42us, got %v", value)
+	}
+	findMetric(t, stats, "firecracker_vcpu_exit_io_in_agg_max_us", "")
+	tapDevice := h.vm("st1").Status.NetworkStatus["eth0"].HostDeviveName
+	findMetric(t, stats, "tap_rx_bytes", tapDevice)
+
+	// Firecracker writes the change in the counters each time they're flushed
+	stats = h.statsJSON("st1")
+	if value := findMetric(t, stats, "firecracker_net_rx_bytes_count", "eth0"); value != 200 {
+		t.Errorf("expected the counter to be added up to 200, got %v", value)
+	}
+	if value := findMetric(t, stats, "firecracker_api_server_process_startup_time_us", ""); value != 42 {
+		t.Errorf("expected the gauge to stay at 42, got %v", value)
+	}
+
+	table := h.stats("st1")
+	if !strings.Contains(table, "st1 (firecracker)") || !strings.Contains(table, "firecracker_net_rx_bytes_count") {
+		t.Errorf("expected the metrics to be shown in a table, got:\n%s", table)
+	}
+
+	h.run("remove", "st1")
+	waitForProcessExit(t, r.PID)
+}
+
+func TestDaemonMetrics(t *testing.T) {
+	h := newHarness(t, "cloudhypervisor")
+	h.startDaemon()
+
+	h.create("m1")
+	r := h.waitForRecord("m1", func(r *record) bool { return r.PID != 0 })
+
+	stats := h.statsJSON("m1")
+	if stats.Provider != "cloudhypervisor" {
+		t.Errorf("expected the provider from the daemon, got %q", stats.Provider)
+	}
+	if value := findMetric(t, stats, "cloud_hypervisor_read_bytes", "_disk0"); value != 4096 {
+		t.Errorf("expected 4096 bytes read, got %v", value)
+	}
+
+	resp, err := h.restClient().Get("http://mikrolited/v1alpha1/vms/m1/stats")
+	if err != nil {
+		t.Fatalf("getting stats with rest api: %s", err)
+	}
+	statsResp := &v1alpha1.StatsVMResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(statsResp); err != nil {
+		t.Fatalf("decoding stats response: %s", err)
+	}
+	resp.Body.Close()
+	if statsResp.Stats == nil || len(statsResp.Stats.Metrics) == 0 {
+		t.Errorf("expected metrics from the rest api, got %+v", statsResp.Stats)
+	}
+
+	resp, err = h.restClient().Get("http://mikrolited/metrics")
+	if err != nil {
+		t.Fatalf("getting prometheus metrics: %s", err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("reading prometheus metrics: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("expected prometheus text, got status %d and %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	for _, expected := range []string{
+		"# TYPE mikrolite_cloud_hypervisor_read_bytes_total counter\n",
+		`mikrolite_cloud_hypervisor_read_bytes_total{device="_disk0",name="m1",provider="cloudhypervisor"} 4096` + "\n",
+		"# TYPE mikrolite_tap_rx_bytes_total counter\n",
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", expected, data)
+		}
+	}
+
+	h.run("remove", "m1")
+	waitForProcessExit(t, r.PID)
+}
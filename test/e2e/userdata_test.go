@@ -0,0 +1,82 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/mikrolite/mikrolite/cloudinit"
+)
+
+func TestUserData(t *testing.T) {
+	h := newHarness(t, "firecracker")
+
+	dir := t.TempDir()
+	userData := filepath.Join(dir, "user-data")
+	script := "Content-Type: multipart/mixed; boundary=\"b\"\nMIME-Version: 1.0\n\n" +
+		"--b\nContent-Type: text/cloud-config\n\n#cloud-config\nruncmd: [echo hello]\n" +
+		"--b\nContent-Type: text/x-shellscript\n\n#!/bin/sh\necho script\n" +
+		"--b--\n"
+	vendorData := filepath.Join(dir, "vendor-data")
+	appConf := filepath.Join(dir, "app.conf")
+	for path, content := range map[string]string{userData: script, vendorData: "#cloud-config\npackages: [jq]\n", appConf: "debug = true\n"} {
+		if err := os.WriteFile(path, []byte(content), 0o640); err != nil {
+			t.Fatalf("writing %s: %s", path, err)
+		}
+	}
+
+	// The supplied parts are merged with the generated user-data and the ready
+	// phone_home is still added
+	h.create("userdata1",
+		"--user-data", userData,
+		"--vendor-data", vendorData,
+		"--file", appConf+":/etc/app.conf",
+		"--wait-for", "ready",
+	)
+	vm := h.vm("userdata1")
+	if vm == nil || !vm.Status.Ready {
+		t.Fatalf("expected the vm to be ready, got %+v", vm)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(vm.Status.Metadata[cloudinit.UserdataKey])
+	if err != nil {
+		t.Fatalf("decoding user-data: %s", err)
+	}
+	parts, err := cloudinit.ParseUserData(raw)
+	if err != nil {
+		t.Fatalf("parsing user-data: %s", err)
+	}
+	if len(parts) != 2 || parts[1].ContentType != cloudinit.ContentTypeShellScript {
+		t.Fatalf("expected the merged cloud-config and the script, got %+v", parts)
+	}
+	merged := &cloudinit.UserData{}
+	if err := yaml.Unmarshal(parts[0].Content, merged); err != nil {
+		t.Fatalf("unmarshalling merged cloud-config: %s", err)
+	}
+	if merged.HostName != "userdata1" || len(merged.RunCommands) != 1 || merged.PhoneHome == nil {
+		t.Errorf("expected the generated and supplied config, got %+v", merged)
+	}
+	if len(merged.WriteFiles) != 1 || merged.WriteFiles[0].Path != "/etc/app.conf" || merged.WriteFiles[0].Permissions != "0640" {
+		t.Errorf("expected the file with the mode of the source, got %+v", merged.WriteFiles)
+	}
+	if _, ok := vm.Status.Metadata[cloudinit.VendorDataKey]; !ok {
+		t.Errorf("expected the vendor-data in the metadata")
+	}
+
+	// Invalid user-data fails before anything is created
+	invalid := filepath.Join(dir, "invalid")
+	if err := os.WriteFile(invalid, []byte("hostname: vm1\n"), 0o644); err != nil {
+		t.Fatalf("writing invalid user-data: %s", err)
+	}
+	h.create("invalid1", "--user-data", invalid)
+	if h.vm("invalid1") != nil {
+		t.Errorf("expected the vm with invalid user-data to not be created")
+	}
+
+	h.run("remove", "userdata1")
+}
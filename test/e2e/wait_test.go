@@ -0,0 +1,44 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWaitForReady(t *testing.T) {
+	for _, provider := range []string{"firecracker", "cloudhypervisor"} {
+		t.Run(provider, func(t *testing.T) {
+			h := newHarness(t, provider)
+
+			// The fake vmm phones home like cloud-init once the vm is started
+			h.create("ready1", "--wait-for", "ready", "--wait-timeout", "30s")
+			created := h.vm("ready1")
+			if created == nil || !created.Status.Ready {
+				t.Fatalf("expected the vm to be ready, got %+v", created)
+			}
+			boot := created.Status.Boot
+			if boot == nil || boot.IPAt == nil || boot.ReadyAt == nil || boot.TimeToReady() < 0 {
+				t.Errorf("expected the boot timings, got %+v", boot)
+			}
+
+			out, err := h.output("list")
+			if err != nil {
+				t.Fatalf("listing vms: %s", err)
+			}
+			if !strings.Contains(out, "yes (") {
+				t.Errorf("expected the list to show the vm is ready, got %q", out)
+			}
+
+			// Without waiting the vm is saved before it has an ip or is ready
+			h.create("nowait", "--wait-for", "none")
+			if vm := h.vm("nowait"); vm == nil || vm.Status.Ready || vm.Status.IP != "" {
+				t.Errorf("expected the vm to not have an ip or be ready, got %+v", vm)
+			}
+
+			h.run("remove", "ready1")
+			h.run("remove", "nowait")
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package fakes
+
+import (
+	"context"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+const (
+	GuestAgentServiceWaitReady = "GuestAgentService.WaitReady"
+)
+
+// NewGuestAgentService creates a fake guest agent service that is ready
+// straight away.
+func NewGuestAgentService(rec *Recorder) *GuestAgentService {
+	return &GuestAgentService{
+		rec:  rec,
+		Info: &domain.GuestInfo{},
+	}
+}
+
+// GuestAgentService is a fake ports.GuestAgentService.
+type GuestAgentService struct {
+	rec *Recorder
+
+	// Info is the guest info returned when the agent is ready.
+	Info *domain.GuestInfo
+}
+
+func (s *GuestAgentService) WaitReady(ctx context.Context, vsock *domain.VSockStatus) (*domain.GuestInfo, error) {
+	if err := s.rec.record(GuestAgentServiceWaitReady, vsock.Socket); err != nil {
+		return nil, err
+	}
+
+	return s.Info, nil
+}
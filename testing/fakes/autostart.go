@@ -0,0 +1,48 @@
+package fakes
+
+import (
+	"context"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+)
+
+const (
+	AutostartServiceEnable  = "AutostartService.Enable"
+	AutostartServiceDisable = "AutostartService.Disable"
+)
+
+// NewAutostartService creates a fake autostart service with no enabled vms.
+func NewAutostartService(rec *Recorder) *AutostartService {
+	return &AutostartService{
+		rec:     rec,
+		Enabled: map[string]bool{},
+	}
+}
+
+// AutostartService is a fake ports.AutostartService.
+type AutostartService struct {
+	rec *Recorder
+
+	// Enabled holds the names of the vms that start when the host boots.
+	Enabled map[string]bool
+}
+
+func (s *AutostartService) Enable(ctx context.Context, vm *domain.VM) error {
+	if err := s.rec.record(AutostartServiceEnable, vm.Name); err != nil {
+		return err
+	}
+
+	s.Enabled[vm.Name] = true
+
+	return nil
+}
+
+func (s *AutostartService) Disable(ctx context.Context, name string) error {
+	if err := s.rec.record(AutostartServiceDisable, name); err != nil {
+		return err
+	}
+
+	delete(s.Enabled, name)
+
+	return nil
+}
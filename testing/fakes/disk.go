@@ -0,0 +1,37 @@
+package fakes
+
+import (
+	"context"
+
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+const (
+	DiskServiceCreate = "DiskService.Create"
+)
+
+// NewDiskService creates a fake disk service.
+func NewDiskService(rec *Recorder) *DiskService {
+	return &DiskService{
+		rec:   rec,
+		Disks: map[string]ports.DiskCreateInput{},
+	}
+}
+
+// DiskService is a fake ports.DiskService.
+type DiskService struct {
+	rec *Recorder
+
+	// Disks holds the input used to create each disk keyed by path.
+	Disks map[string]ports.DiskCreateInput
+}
+
+func (s *DiskService) Create(ctx context.Context, input ports.DiskCreateInput) error {
+	if err := s.rec.record(DiskServiceCreate, input); err != nil {
+		return err
+	}
+
+	s.Disks[input.Path] = input
+
+	return nil
+}
@@ -0,0 +1,15 @@
+package fakes
+
+import (
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+var (
+	_ ports.ImageService     = &ImageService{}
+	_ ports.VMProvider       = &VMProvider{}
+	_ ports.StateService     = &StateService{}
+	_ ports.NetworkService   = &NetworkService{}
+	_ ports.DiskService      = &DiskService{}
+	_ ports.ProcessService   = &ProcessService{}
+	_ ports.AutostartService = &AutostartService{}
+)
@@ -0,0 +1,126 @@
+package fakes
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+const (
+	ImageServicePullAndMount = "ImageService.PullAndMount"
+	ImageServiceRelease      = "ImageService.Release"
+	ImageServiceCleanup      = "ImageService.Cleanup"
+	ImageServiceListOwners   = "ImageService.ListOwners"
+)
+
+// NewImageService creates a fake image service.
+func NewImageService(rec *Recorder) *ImageService {
+	return &ImageService{
+		rec:    rec,
+		Owners: map[string]bool{},
+	}
+}
+
+// ImageService is a fake ports.ImageService. Kernels are mounted as filesystem
+// paths and volumes as block devices.
+type ImageService struct {
+	rec *Recorder
+
+	// MountDir is an optional directory to create the mounts in. If its set then
+	// a directory is created for kernels and an empty file for volumes, so that
+	// the mounts can be used by a real vm provider.
+	MountDir string
+	// Owners holds the owners that have images, they are added when an image is
+	// mounted and removed by cleanup.
+	Owners map[string]bool
+	// Labels are the labels of the images, keyed by image name.
+	Labels map[string]map[string]string
+}
+
+func (s *ImageService) PullAndMount(ctx context.Context, input ports.PullAndMountInput) (*domain.Mount, error) {
+	if err := s.rec.record(ImageServicePullAndMount, input); err != nil {
+		return nil, err
+	}
+
+	s.Owners[input.Owner] = true
+
+	mount := &domain.Mount{
+		Type:     domain.MountTypeBlockDevice,
+		Location: fmt.Sprintf("/dev/mapper/%s-%s-%s", input.Owner, input.UsedFor, input.Name),
+	}
+	if s.MountDir != "" {
+		var err error
+		if mount, err = s.createMount(input); err != nil {
+			return nil, err
+		}
+	} else if input.UsedFor == ports.ImageUsedForKernel {
+		mount = &domain.Mount{
+			Type:     domain.MountTypeFilesystemPath,
+			Location: path.Join("/snapshots", input.Owner, string(input.UsedFor), input.Name),
+		}
+	}
+	mount.Labels = s.Labels[input.ImageName]
+
+	return mount, nil
+}
+
+func (s *ImageService) Release(ctx context.Context, input ports.ReleaseInput) error {
+	return s.rec.record(ImageServiceRelease, input)
+}
+
+func (s *ImageService) Cleanup(ctx context.Context, owner string) error {
+	if err := s.rec.record(ImageServiceCleanup, owner); err != nil {
+		return err
+	}
+
+	delete(s.Owners, owner)
+
+	return nil
+}
+
+func (s *ImageService) ListOwners(ctx context.Context) ([]string, error) {
+	if err := s.rec.record(ImageServiceListOwners); err != nil {
+		return nil, err
+	}
+
+	owners := []string{}
+	for owner := range s.Owners {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+
+	return owners, nil
+}
+
+func (s *ImageService) createMount(input ports.PullAndMountInput) (*domain.Mount, error) {
+	location := filepath.Join(s.MountDir, input.Owner, string(input.UsedFor), input.Name)
+
+	if input.UsedFor == ports.ImageUsedForKernel {
+		if err := os.MkdirAll(location, 0o755); err != nil {
+			return nil, fmt.Errorf("creating kernel mount %s: %w", location, err)
+		}
+
+		return &domain.Mount{
+			Type:     domain.MountTypeFilesystemPath,
+			Location: location,
+		}, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(location), 0o755); err != nil {
+		return nil, fmt.Errorf("creating volume mount directory: %w", err)
+	}
+	if err := os.WriteFile(location, nil, 0o644); err != nil {
+		return nil, fmt.Errorf("creating volume mount %s: %w", location, err)
+	}
+
+	return &domain.Mount{
+		Type:     domain.MountTypeBlockDevice,
+		Location: location,
+	}, nil
+}
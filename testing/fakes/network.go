@@ -0,0 +1,237 @@
+package fakes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+const (
+	NetworkServiceBridgeCreate     = "NetworkService.BridgeCreate"
+	NetworkServiceBridgeDelete     = "NetworkService.BridgeDelete"
+	NetworkServiceBridgeExists     = "NetworkService.BridgeExists"
+	NetworkServiceInterfaceCreate  = "NetworkService.InterfaceCreate"
+	NetworkServiceInterfaceDelete  = "NetworkService.InterfaceDelete"
+	NetworkServiceInterfaceExists  = "NetworkService.InterfaceExists"
+	NetworkServiceListInterfaces   = "NetworkService.ListInterfaces"
+	NetworkServiceAttachToBridge   = "NetworkService.AttachToBridge"
+	NetworkServiceBridgeAddress    = "NetworkService.BridgeAddress"
+	NetworkServiceNewInterfaceName = "NetworkService.NewInterfaceName"
+	NetworkServiceRouteToGuest     = "NetworkService.RouteToGuest"
+	NetworkServiceGetIPFromMac     = "NetworkService.GetIPFromMac"
+	NetworkServiceRouteInterface   = "NetworkService.RouteInterface"
+	NetworkServiceInterfaceStats   = "NetworkService.InterfaceStats"
+)
+
+// NewNetworkService creates a fake network service with the supplied bridges.
+func NewNetworkService(rec *Recorder, bridges ...string) *NetworkService {
+	svc := &NetworkService{
+		rec:        rec,
+		Bridges:    map[string]bool{},
+		Interfaces: map[string]string{},
+		Attached:   map[string]string{},
+		IPs:        map[string]string{},
+		Routes:     map[string]string{},
+		Stats:      map[string]*ports.InterfaceStats{},
+		BridgeIP:   "127.0.0.1",
+	}
+	for _, bridge := range bridges {
+		svc.Bridges[bridge] = true
+	}
+
+	return svc
+}
+
+// NetworkService is a fake ports.NetworkService.
+type NetworkService struct {
+	rec *Recorder
+
+	// Bridges holds the names of the bridges that exist.
+	Bridges map[string]bool
+	// Interfaces holds the interfaces that exist and their mac address.
+	Interfaces map[string]string
+	// Attached holds the bridge an interface is attached to.
+	Attached map[string]string
+	// IPs holds the ip address to return for a mac address.
+	IPs map[string]string
+	// Routes holds the interface that each guest address is routed over.
+	Routes map[string]string
+	// DefaultIP is returned for any mac address that isn't in IPs.
+	DefaultIP string
+	// BridgeIP is the address returned for any bridge that exists.
+	BridgeIP string
+	// Stats holds the counters to return for an interface, interfaces that exist
+	// without stats have zero counters.
+	Stats map[string]*ports.InterfaceStats
+}
+
+func (s *NetworkService) BridgeCreate(name string) error {
+	if err := s.rec.record(NetworkServiceBridgeCreate, name); err != nil {
+		return err
+	}
+
+	s.Bridges[name] = true
+
+	return nil
+}
+
+func (s *NetworkService) BridgeDelete(name string) error {
+	if err := s.rec.record(NetworkServiceBridgeDelete, name); err != nil {
+		return err
+	}
+
+	delete(s.Bridges, name)
+
+	return nil
+}
+
+func (s *NetworkService) BridgeExists(name string) (bool, error) {
+	if err := s.rec.record(NetworkServiceBridgeExists, name); err != nil {
+		return false, err
+	}
+
+	return s.Bridges[name], nil
+}
+
+func (s *NetworkService) InterfaceCreate(name string, mac string) error {
+	if err := s.rec.record(NetworkServiceInterfaceCreate, name, mac); err != nil {
+		return err
+	}
+
+	if _, exists := s.Interfaces[name]; exists {
+		return fmt.Errorf("interface %s already exists", name)
+	}
+	s.Interfaces[name] = mac
+
+	return nil
+}
+
+func (s *NetworkService) InterfaceDelete(name string) error {
+	if err := s.rec.record(NetworkServiceInterfaceDelete, name); err != nil {
+		return err
+	}
+
+	delete(s.Interfaces, name)
+	delete(s.Attached, name)
+
+	return nil
+}
+
+func (s *NetworkService) InterfaceExists(name string) (bool, error) {
+	if err := s.rec.record(NetworkServiceInterfaceExists, name); err != nil {
+		return false, err
+	}
+
+	_, exists := s.Interfaces[name]
+
+	return exists, nil
+}
+
+func (s *NetworkService) ListInterfaces(prefix string) ([]string, error) {
+	if err := s.rec.record(NetworkServiceListInterfaces, prefix); err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for name := range s.Interfaces {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (s *NetworkService) AttachToBridge(interfaceName string, bridgeName string) error {
+	if err := s.rec.record(NetworkServiceAttachToBridge, interfaceName, bridgeName); err != nil {
+		return err
+	}
+
+	if !s.Bridges[bridgeName] {
+		return fmt.Errorf("bridge %s doesn't exist", bridgeName)
+	}
+	if _, exists := s.Interfaces[interfaceName]; !exists {
+		return fmt.Errorf("interface %s doesn't exist", interfaceName)
+	}
+	s.Attached[interfaceName] = bridgeName
+
+	return nil
+}
+
+func (s *NetworkService) BridgeAddress(name string) (string, error) {
+	if err := s.rec.record(NetworkServiceBridgeAddress, name); err != nil {
+		return "", err
+	}
+
+	if !s.Bridges[name] {
+		return "", fmt.Errorf("bridge %s doesn't exist", name)
+	}
+
+	return s.BridgeIP, nil
+}
+
+func (s *NetworkService) NewInterfaceName(prefix string) (string, error) {
+	if err := s.rec.record(NetworkServiceNewInterfaceName, prefix); err != nil {
+		return "", err
+	}
+
+	for index := 0; ; index++ {
+		name := fmt.Sprintf("%s%d", prefix, index)
+		if _, exists := s.Interfaces[name]; !exists {
+			return name, nil
+		}
+	}
+}
+
+func (s *NetworkService) RouteToGuest(interfaceName string, hostAddress string, guestAddress string) error {
+	if err := s.rec.record(NetworkServiceRouteToGuest, interfaceName, hostAddress, guestAddress); err != nil {
+		return err
+	}
+
+	if _, exists := s.Interfaces[interfaceName]; !exists {
+		return fmt.Errorf("interface %s doesn't exist", interfaceName)
+	}
+	s.Routes[guestAddress] = interfaceName
+
+	return nil
+}
+
+// RouteInterface returns the interface in Routes for the address, its empty if
+// there isn't a route.
+func (s *NetworkService) RouteInterface(address string) (string, error) {
+	if err := s.rec.record(NetworkServiceRouteInterface, address); err != nil {
+		return "", err
+	}
+
+	return s.Routes[address], nil
+}
+
+func (s *NetworkService) GetIPFromMac(macAddress string) (string, error) {
+	if err := s.rec.record(NetworkServiceGetIPFromMac, macAddress); err != nil {
+		return "", err
+	}
+
+	if ip, ok := s.IPs[macAddress]; ok {
+		return ip, nil
+	}
+
+	return s.DefaultIP, nil
+}
+
+func (s *NetworkService) InterfaceStats(name string) (*ports.InterfaceStats, error) {
+	if err := s.rec.record(NetworkServiceInterfaceStats, name); err != nil {
+		return nil, err
+	}
+
+	if _, exists := s.Interfaces[name]; !exists {
+		return nil, fmt.Errorf("interface %s doesn't exist", name)
+	}
+	if stats, ok := s.Stats[name]; ok {
+		return stats, nil
+	}
+
+	return &ports.InterfaceStats{}, nil
+}
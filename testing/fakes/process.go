@@ -0,0 +1,42 @@
+package fakes
+
+import "time"
+
+const (
+	ProcessServiceRunning  = "ProcessService.Running"
+	ProcessServiceBootTime = "ProcessService.BootTime"
+)
+
+// NewProcessService creates a fake process service with no running processes.
+func NewProcessService(rec *Recorder) *ProcessService {
+	return &ProcessService{
+		rec:         rec,
+		RunningPIDs: map[int]bool{},
+	}
+}
+
+// ProcessService is a fake ports.ProcessService.
+type ProcessService struct {
+	rec *Recorder
+
+	// RunningPIDs holds the pids of the running processes.
+	RunningPIDs map[int]bool
+	// Booted is the time the host booted, the zero time by default.
+	Booted time.Time
+}
+
+func (s *ProcessService) Running(pid int) (bool, error) {
+	if err := s.rec.record(ProcessServiceRunning, pid); err != nil {
+		return false, err
+	}
+
+	return s.RunningPIDs[pid], nil
+}
+
+func (s *ProcessService) BootTime() (time.Time, error) {
+	if err := s.rec.record(ProcessServiceBootTime); err != nil {
+		return time.Time{}, err
+	}
+
+	return s.Booted, nil
+}
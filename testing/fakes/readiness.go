@@ -0,0 +1,80 @@
+package fakes
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+const (
+	ReadinessServiceListen = "ReadinessService.Listen"
+	ReadyListenerWait      = "ReadyListener.Wait"
+	ReadyListenerClose     = "ReadyListener.Close"
+)
+
+// NewReadinessService creates a fake readiness service whose vms are ready
+// straight away.
+func NewReadinessService(rec *Recorder) *ReadinessService {
+	return &ReadinessService{rec: rec, ReadyAt: time.Date(2024, 1, 1, 0, 0, 30, 0, time.UTC)}
+}
+
+// ReadinessService is a fake ports.ReadinessService.
+type ReadinessService struct {
+	rec *Recorder
+
+	// ReadyAt is the time the vms signal that they're ready, the listener
+	// waits until the context is done if its zero.
+	ReadyAt time.Time
+	// Listeners holds the listeners that have been created.
+	Listeners []*ReadyListener
+}
+
+func (s *ReadinessService) Listen(address string, vmName string) (ports.ReadyListener, error) {
+	if err := s.rec.record(ReadinessServiceListen, address, vmName); err != nil {
+		return nil, err
+	}
+
+	listener := &ReadyListener{
+		rec:     s.rec,
+		url:     fmt.Sprintf("http://%s:8080/phone-home/%s", address, vmName),
+		readyAt: s.ReadyAt,
+	}
+	s.Listeners = append(s.Listeners, listener)
+
+	return listener, nil
+}
+
+// ReadyListener is a fake ports.ReadyListener.
+type ReadyListener struct {
+	rec     *Recorder
+	url     string
+	readyAt time.Time
+
+	// Closed is true once the listener has been closed.
+	Closed bool
+}
+
+func (l *ReadyListener) URL() string {
+	return l.url
+}
+
+func (l *ReadyListener) Wait(ctx context.Context) (time.Time, error) {
+	if err := l.rec.record(ReadyListenerWait); err != nil {
+		return time.Time{}, err
+	}
+
+	if l.readyAt.IsZero() {
+		<-ctx.Done()
+		return time.Time{}, ctx.Err()
+	}
+
+	return l.readyAt, nil
+}
+
+func (l *ReadyListener) Close() error {
+	l.Closed = true
+
+	return l.rec.record(ReadyListenerClose)
+}
@@ -0,0 +1,97 @@
+// Package fakes contains in-memory implementations of the ports that record
+// their calls and can be told to fail. They are used to test the core app
+// without containerd, netlink or a hypervisor.
+package fakes
+
+import (
+	"sync"
+)
+
+// Call is a recorded call to a fake.
+type Call struct {
+	// Method is the name of the method called, prefixed with the name of the port.
+	// For example ImageService.PullAndMount.
+	Method string
+	// Args are the arguments passed to the method, excluding any context.
+	Args []interface{}
+}
+
+// NewRecorder creates a new recorder. A recorder can be shared between fakes
+// so that the order of calls across ports can be checked.
+func NewRecorder() *Recorder {
+	return &Recorder{
+		failures: map[string]error{},
+	}
+}
+
+// Recorder records the calls made to the fakes and holds the failures to inject.
+type Recorder struct {
+	mu       sync.Mutex
+	calls    []Call
+	failures map[string]error
+}
+
+// FailOn will make every call to the method return the error.
+func (r *Recorder) FailOn(method string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.failures[method] = err
+}
+
+// Calls returns all the recorded calls in the order they were made.
+func (r *Recorder) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Call{}, r.calls...)
+}
+
+// Methods returns the names of the methods called in the order they were made.
+func (r *Recorder) Methods() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	methods := make([]string, 0, len(r.calls))
+	for _, call := range r.calls {
+		methods = append(methods, call.Method)
+	}
+
+	return methods
+}
+
+// CallsTo returns the recorded calls to the method.
+func (r *Recorder) CallsTo(method string) []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	calls := []Call{}
+	for _, call := range r.calls {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+
+	return calls
+}
+
+// Reset will remove all the recorded calls and failures.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = nil
+	r.failures = map[string]error{}
+}
+
+func (r *Recorder) record(method string, args ...interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = append(r.calls, Call{
+		Method: method,
+		Args:   args,
+	})
+
+	return r.failures[method]
+}
@@ -0,0 +1,219 @@
+package fakes
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+const (
+	StateServiceGetVM        = "StateService.GetVM"
+	StateServiceSaveVM       = "StateService.SaveVM"
+	StateServiceListVMs      = "StateService.ListVMs"
+	StateServiceListDirs     = "StateService.ListDirs"
+	StateServiceGetMetadata  = "StateService.GetMetadata"
+	StateServiceSaveMetadata = "StateService.SaveMetadata"
+	StateServiceGetPID       = "StateService.GetPID"
+	StateServiceSavePID      = "StateService.SavePID"
+	StateServiceGetExit      = "StateService.GetExit"
+	StateServiceSaveExit     = "StateService.SaveExit"
+	StateServiceDeleteExit   = "StateService.DeleteExit"
+)
+
+// NewStateService creates a fake state service for the vm with the given
+// name. The vm will be stored in memory.
+func NewStateService(rec *Recorder, root string, name string) *StateService {
+	return &StateService{
+		rec:  rec,
+		root: filepath.Join(root, name),
+		name: name,
+		VMs:  map[string]*domain.VM{},
+		Dirs: map[string]ports.StateDir{},
+	}
+}
+
+// StateService is a fake ports.StateService. Calls to the path methods
+// aren't recorded.
+type StateService struct {
+	rec  *Recorder
+	root string
+	name string
+
+	// VMs holds the saved vms keyed by name.
+	VMs map[string]*domain.VM
+	// Metadata holds the saved metadata.
+	Metadata map[string]string
+	// PID is the saved pid.
+	PID int
+	// Exit is the saved process exit.
+	Exit *domain.ProcessExit
+	// Dirs holds the state directories keyed by name. A directory without a pid
+	// is returned by ListDirs for any vm that isn't in Dirs.
+	Dirs map[string]ports.StateDir
+}
+
+func (s *StateService) Root() string {
+	return s.root
+}
+
+func (s *StateService) GetVM() (*domain.VM, error) {
+	if err := s.rec.record(StateServiceGetVM); err != nil {
+		return nil, err
+	}
+
+	vm, ok := s.VMs[s.name]
+	if !ok {
+		return nil, nil
+	}
+
+	return copyVM(vm)
+}
+
+func (s *StateService) SaveVM(vm *domain.VM) error {
+	if err := s.rec.record(StateServiceSaveVM, vm); err != nil {
+		return err
+	}
+
+	saved, err := copyVM(vm)
+	if err != nil {
+		return err
+	}
+	s.VMs[vm.Name] = saved
+
+	return nil
+}
+
+func (s *StateService) ListVMs() ([]*domain.VM, error) {
+	if err := s.rec.record(StateServiceListVMs); err != nil {
+		return nil, err
+	}
+
+	vms := []*domain.VM{}
+	for _, vm := range s.VMs {
+		copied, err := copyVM(vm)
+		if err != nil {
+			return nil, err
+		}
+		vms = append(vms, copied)
+	}
+
+	return vms, nil
+}
+
+func (s *StateService) ListDirs() ([]ports.StateDir, error) {
+	if err := s.rec.record(StateServiceListDirs); err != nil {
+		return nil, err
+	}
+
+	dirs := []ports.StateDir{}
+	for _, dir := range s.Dirs {
+		dirs = append(dirs, dir)
+	}
+	for name := range s.VMs {
+		if _, ok := s.Dirs[name]; !ok {
+			dirs = append(dirs, ports.StateDir{Name: name})
+		}
+	}
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].Name < dirs[j].Name })
+
+	return dirs, nil
+}
+
+func (s *StateService) LogPath() string {
+	return filepath.Join(s.root, "vm.log")
+}
+
+func (s *StateService) StdoutPath() string {
+	return filepath.Join(s.root, "vm.stdout")
+}
+
+func (s *StateService) StderrPath() string {
+	return filepath.Join(s.root, "vm.stderr")
+}
+
+func (s *StateService) MikroliteLogPath() string {
+	return filepath.Join(s.root, "mikrolite.log")
+}
+
+func (s *StateService) GetMetadata() (map[string]string, error) {
+	if err := s.rec.record(StateServiceGetMetadata); err != nil {
+		return nil, err
+	}
+
+	return s.Metadata, nil
+}
+
+func (s *StateService) SaveMetadata(metadata map[string]string) error {
+	if err := s.rec.record(StateServiceSaveMetadata, metadata); err != nil {
+		return err
+	}
+
+	s.Metadata = metadata
+
+	return nil
+}
+
+func (s *StateService) GetPID() (int, error) {
+	if err := s.rec.record(StateServiceGetPID); err != nil {
+		return -1, err
+	}
+
+	return s.PID, nil
+}
+
+func (s *StateService) SavePID(pid int) error {
+	if err := s.rec.record(StateServiceSavePID, pid); err != nil {
+		return err
+	}
+
+	s.PID = pid
+
+	return nil
+}
+
+func (s *StateService) GetExit() (*domain.ProcessExit, error) {
+	if err := s.rec.record(StateServiceGetExit); err != nil {
+		return nil, err
+	}
+
+	return s.Exit, nil
+}
+
+func (s *StateService) SaveExit(exit *domain.ProcessExit) error {
+	if err := s.rec.record(StateServiceSaveExit, exit); err != nil {
+		return err
+	}
+
+	s.Exit = exit
+
+	return nil
+}
+
+func (s *StateService) DeleteExit() error {
+	if err := s.rec.record(StateServiceDeleteExit); err != nil {
+		return err
+	}
+
+	s.Exit = nil
+
+	return nil
+}
+
+// copyVM copies a vm using json, the same way it would be if it was saved to disk.
+func copyVM(vm *domain.VM) (*domain.VM, error) {
+	data, err := json.Marshal(vm)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling vm: %w", err)
+	}
+
+	copied := &domain.VM{}
+	if err := json.Unmarshal(data, copied); err != nil {
+		return nil, fmt.Errorf("unmarshalling vm: %w", err)
+	}
+
+	return copied, nil
+}
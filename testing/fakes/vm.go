@@ -0,0 +1,114 @@
+package fakes
+
+import (
+	"context"
+
+	"github.com/mikrolite/mikrolite/core/domain"
+	"github.com/mikrolite/mikrolite/core/ports"
+)
+
+const (
+	VMProviderCreate       = "VMProvider.Create"
+	VMProviderStop         = "VMProvider.Stop"
+	VMProviderDelete       = "VMProvider.Delete"
+	VMProviderAttachVolume = "VMProvider.AttachVolume"
+	VMProviderDetachVolume = "VMProvider.DetachVolume"
+	VMProviderMetrics      = "VMProvider.Metrics"
+)
+
+// NewVMProvider creates a fake vm provider with the supplied capabilities.
+func NewVMProvider(rec *Recorder, caps ports.Capabilities) *VMProvider {
+	return &VMProvider{
+		rec:  rec,
+		Caps: caps,
+	}
+}
+
+// VMProvider is a fake ports.VMProvider.
+type VMProvider struct {
+	rec *Recorder
+
+	// Caps are the capabilities returned by the provider.
+	Caps ports.Capabilities
+	// Created holds the vms that have been created, keyed by name.
+	Created map[string]*domain.VM
+	// OnStop is called when a vm is stopped, for example to stop its process.
+	OnStop func(id string)
+	// VMMetrics are the metrics returned for any vm.
+	VMMetrics []domain.Metric
+	// VSock is set as the vsock status of the created vms, if it isn't nil.
+	VSock *domain.VSockStatus
+}
+
+func (p *VMProvider) Create(ctx context.Context, vm *domain.VM) (string, error) {
+	if err := p.rec.record(VMProviderCreate, vm); err != nil {
+		return "", err
+	}
+
+	if p.Created == nil {
+		p.Created = map[string]*domain.VM{}
+	}
+	p.Created[vm.Name] = vm
+	if p.VSock != nil {
+		vm.Status.VSock = p.VSock
+	}
+
+	return vm.Name, nil
+}
+
+func (p *VMProvider) Stop(ctx context.Context, id string) error {
+	if err := p.rec.record(VMProviderStop, id); err != nil {
+		return err
+	}
+
+	if p.OnStop != nil {
+		p.OnStop(id)
+	}
+
+	return nil
+}
+
+func (p *VMProvider) Delete(ctx context.Context, id string) error {
+	if err := p.rec.record(VMProviderDelete, id); err != nil {
+		return err
+	}
+
+	delete(p.Created, id)
+
+	return nil
+}
+
+func (p *VMProvider) AttachVolume(ctx context.Context, vm *domain.VM, volumeName string) error {
+	if err := p.rec.record(VMProviderAttachVolume, vm, volumeName); err != nil {
+		return err
+	}
+
+	if vm.Status.VolumeSlots == nil {
+		vm.Status.VolumeSlots = map[string]string{}
+	}
+	vm.Status.VolumeSlots[volumeName] = volumeName
+
+	return nil
+}
+
+func (p *VMProvider) DetachVolume(ctx context.Context, vm *domain.VM, volumeName string) error {
+	if err := p.rec.record(VMProviderDetachVolume, vm, volumeName); err != nil {
+		return err
+	}
+
+	delete(vm.Status.VolumeSlots, volumeName)
+
+	return nil
+}
+
+func (p *VMProvider) Metrics(ctx context.Context, vm *domain.VM) ([]domain.Metric, error) {
+	if err := p.rec.record(VMProviderMetrics, vm); err != nil {
+		return nil, err
+	}
+
+	return p.VMMetrics, nil
+}
+
+func (p *VMProvider) Capabilities() ports.Capabilities {
+	return p.Caps
+}